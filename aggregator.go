@@ -0,0 +1,301 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AggregatorOpts contains options for NewAggregator.
+type AggregatorOpts struct {
+	// Timeout limits the duration of fetching metrics from every target.
+	//
+	// By default 10 seconds timeout is used.
+	Timeout time.Duration
+
+	// TargetLabel is an optional label name, which is added to every sample fetched from a target.
+	// The label value is the index of the target in the targets passed to NewAggregator. For example, `worker="3"`.
+	//
+	// Samples, which already have the label, keep their own label value.
+	TargetLabel string
+
+	// SumDuplicates enables summing counters and histograms with identical series names across targets.
+	//
+	// By default only the first sample is kept for identical series names, while the rest of samples are counted
+	// by metrics_duplicate_series_total{source="aggregator"} counter. Only the first sample is kept for other metric types
+	// with identical series names if SumDuplicates is set.
+	// Metric types are detected by `# TYPE` lines. Samples without `# TYPE` lines are treated as counters
+	// if their names have `_total` suffix.
+	SumDuplicates bool
+
+	// Client is an optional client for fetching metrics from targets.
+	//
+	// By default a client with keep-alive connections is used, so connections are reused across scrapes.
+	Client *http.Client
+}
+
+// NewAggregator returns http handler, which merges metrics fetched from the given targets.
+//
+// Every target must be http or https url. `/metrics` path is used for targets without path.
+// Metrics are fetched from all the targets concurrently on every request to the handler.
+// They are parsed with ReadPrometheus and are written in Prometheus text exposition format in the order of targets.
+// Comments including `# HELP` and `# TYPE` lines aren't written.
+//
+// `up{target="..."}` gauge is written for every target after the merged metrics. Its value is 1 if metrics
+// have been fetched from the target and 0 otherwise. Samples from failed targets are skipped.
+//
+// See AggregatorOpts for the available options.
+func NewAggregator(targets []string, opts AggregatorOpts) http.Handler {
+	urls := make([]string, len(targets))
+	for i, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			panic(fmt.Errorf("BUG: cannot parse target %q: %w", target, err))
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			panic(fmt.Errorf("BUG: unsupported scheme in target %q: %q. It must be http or https", target, u.Scheme))
+		}
+		if u.Path == "" {
+			u.Path = "/metrics"
+		}
+		urls[i] = u.String()
+	}
+	if opts.TargetLabel != "" {
+		if err := validateIdent(opts.TargetLabel); err != nil {
+			panic(fmt.Errorf("BUG: invalid TargetLabel %q: %w", opts.TargetLabel, err))
+		}
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: 2,
+				IdleConnTimeout:     5 * time.Minute,
+			},
+		}
+	}
+	a := &aggregator{
+		targets:       targets,
+		urls:          urls,
+		timeout:       timeout,
+		targetLabel:   opts.TargetLabel,
+		sumDuplicates: opts.SumDuplicates,
+		client:        client,
+	}
+	return http.HandlerFunc(a.serveHTTP)
+}
+
+type aggregator struct {
+	targets       []string
+	urls          []string
+	timeout       time.Duration
+	targetLabel   string
+	sumDuplicates bool
+	client        *http.Client
+}
+
+// aggregatorTargetResult contains the result of fetching metrics from a single target.
+type aggregatorTargetResult struct {
+	samples []MetricSample
+	types   map[string]string
+	err     error
+}
+
+func (a *aggregator) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.timeout)
+	defer cancel()
+
+	results := make([]aggregatorTargetResult, len(a.urls))
+	var wg sync.WaitGroup
+	for i := range a.urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = a.fetchTarget(ctx, a.urls[i])
+		}(i)
+	}
+	wg.Wait()
+
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	bb.B = a.appendMergedSamples(bb.B, results)
+	for i, target := range a.targets {
+		bb.B = append(bb.B, `up{target="`...)
+		bb.B = appendEscapedLabelValue(bb.B, target)
+		bb.B = append(bb.B, `"} `...)
+		if err := results[i].err; err != nil {
+			log.Printf("ERROR: metrics.aggregator: %s", err)
+			bb.B = append(bb.B, "0\n"...)
+		} else {
+			bb.B = append(bb.B, "1\n"...)
+		}
+	}
+	w.Header().Set("Content-Type", prometheusContentType)
+	_, _ = w.Write(bb.B)
+}
+
+// aggregatedSample is a sample in the merged output.
+type aggregatedSample struct {
+	seriesName string
+	value      float64
+	timestamp  int64
+}
+
+// appendMergedSamples appends samples from successful results to dst in Prometheus text exposition format.
+func (a *aggregator) appendMergedSamples(dst []byte, results []aggregatorTargetResult) []byte {
+	types := make(map[string]string)
+	for _, result := range results {
+		for name, metricType := range result.types {
+			if _, ok := types[name]; !ok {
+				types[name] = metricType
+			}
+		}
+	}
+
+	var samples []*aggregatedSample
+	seen := make(map[string]*aggregatedSample)
+	for i, result := range results {
+		if result.err != nil {
+			continue
+		}
+		targetIdx := strconv.Itoa(i)
+		for j := range result.samples {
+			sample := &result.samples[j]
+			if a.targetLabel != "" {
+				if _, ok := sample.Labels[a.targetLabel]; !ok {
+					if sample.Labels == nil {
+						sample.Labels = make(map[string]string, 1)
+					}
+					sample.Labels[a.targetLabel] = targetIdx
+				}
+			}
+			as := &aggregatedSample{
+				seriesName: sample.SeriesName(),
+				value:      sample.Value,
+				timestamp:  sample.Timestamp,
+			}
+			if prev := seen[as.seriesName]; prev != nil {
+				if !a.sumDuplicates {
+					recordDuplicateSeries(duplicateSourceAggregator, as.seriesName)
+				} else if isSummableSample(sample.Name, types) {
+					prev.value += as.value
+				}
+				continue
+			}
+			seen[as.seriesName] = as
+			samples = append(samples, as)
+		}
+	}
+
+	for _, as := range samples {
+		dst = append(dst, as.seriesName...)
+		dst = append(dst, ' ')
+		dst = appendGaugeValue(dst, as.value)
+		if as.timestamp != 0 {
+			dst = append(dst, ' ')
+			dst = strconv.AppendInt(dst, as.timestamp, 10)
+		}
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+// isSummableSample returns true if the sample with the given name belongs to a counter or a histogram.
+//
+// types contains metric types from `# TYPE` lines keyed by metric family names.
+func isSummableSample(name string, types map[string]string) bool {
+	if metricType, ok := types[name]; ok {
+		return metricType == "counter"
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if metricType, ok := types[name[:len(name)-len(suffix)]]; ok {
+			return metricType == "histogram"
+		}
+	}
+	return strings.HasSuffix(name, "_total")
+}
+
+// fetchTarget fetches and parses metrics from the given url.
+func (a *aggregator) fetchTarget(ctx context.Context, u string) aggregatorTargetResult {
+	data, err := a.fetchData(ctx, u)
+	if err != nil {
+		return aggregatorTargetResult{
+			err: err,
+		}
+	}
+	samples, err := ReadPrometheus(bytes.NewReader(data))
+	if err != nil {
+		return aggregatorTargetResult{
+			err: fmt.Errorf("cannot parse metrics from %q: %w", u, err),
+		}
+	}
+	return aggregatorTargetResult{
+		samples: samples,
+		types:   parseMetricTypes(data),
+	}
+}
+
+func (a *aggregator) fetchData(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request to %q: %w", u, err)
+	}
+	req.Header.Set("Accept", prometheusContentType)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch metrics from %q: %w", u, err)
+	}
+	// Read the whole response body, so the connection can be reused for the next scrape.
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response from %q: %w", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code returned from %q: %d; want %d; response body: %q", u, resp.StatusCode, http.StatusOK, data)
+	}
+	return data, nil
+}
+
+// parseMetricTypes returns metric types from `# TYPE` lines in src keyed by metric family names.
+func parseMetricTypes(src []byte) map[string]string {
+	types := make(map[string]string)
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		s := string(bytes.TrimSpace(line))
+		tail := strings.TrimPrefix(s, "# TYPE ")
+		if len(tail) == len(s) {
+			continue
+		}
+		n = strings.IndexByte(tail, ' ')
+		if n <= 0 {
+			continue
+		}
+		types[tail[:n]] = strings.TrimSpace(tail[n+1:])
+	}
+	return types
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestAggregatorTarget(t *testing.T, data string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, data)
+	}))
+}
+
+func testAggregatorResponse(t *testing.T, h http.Handler) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code; got %d; want %d", w.Code, http.StatusOK)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != prometheusContentType {
+		t.Fatalf("unexpected Content-Type; got %q; want %q", contentType, prometheusContentType)
+	}
+	return w.Body.String()
+}
+
+func TestAggregator(t *testing.T) {
+	srv1 := newTestAggregatorTarget(t, `# TYPE requests_total counter
+requests_total{path="/foo"} 10
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="1"} 2
+duration_seconds_sum 1.5
+duration_seconds_count 2
+temperature 20
+`)
+	defer srv1.Close()
+	srv2 := newTestAggregatorTarget(t, `requests_total{path="/foo"} 5
+duration_seconds_bucket{le="1"} 3
+duration_seconds_sum 2
+duration_seconds_count 3
+temperature 30
+`)
+	defer srv2.Close()
+	srvBroken := newTestAggregatorTarget(t, "foo{\n")
+	defer srvBroken.Close()
+	targets := []string{srv1.URL, srv2.URL + "/metrics", srvBroken.URL, "http://127.0.0.1:1/metrics"}
+
+	// Without options
+	// Duplicate series from the second target are dropped.
+	duplicatesPrev := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceAggregator])
+	h := NewAggregator(targets, AggregatorOpts{})
+	result := testAggregatorResponse(t, h)
+	resultExpected := `requests_total{path="/foo"} 10
+duration_seconds_bucket{le="1"} 2
+duration_seconds_sum 1.5
+duration_seconds_count 2
+temperature 20
+up{target="` + targets[0] + `"} 1
+up{target="` + targets[1] + `"} 1
+up{target="` + targets[2] + `"} 0
+up{target="` + targets[3] + `"} 0
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+	if n := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceAggregator]) - duplicatesPrev; n != 5 {
+		t.Fatalf("unexpected number of duplicate series; got %d; want %d", n, 5)
+	}
+
+	// With target label
+	h = NewAggregator(targets[:2], AggregatorOpts{
+		TargetLabel: "worker",
+	})
+	result = testAggregatorResponse(t, h)
+	resultExpected = `requests_total{path="/foo",worker="0"} 10
+duration_seconds_bucket{le="1",worker="0"} 2
+duration_seconds_sum{worker="0"} 1.5
+duration_seconds_count{worker="0"} 2
+temperature{worker="0"} 20
+requests_total{path="/foo",worker="1"} 5
+duration_seconds_bucket{le="1",worker="1"} 3
+duration_seconds_sum{worker="1"} 2
+duration_seconds_count{worker="1"} 3
+temperature{worker="1"} 30
+up{target="` + targets[0] + `"} 1
+up{target="` + targets[1] + `"} 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// With summing duplicates
+	h = NewAggregator(targets[:3], AggregatorOpts{
+		SumDuplicates: true,
+	})
+	result = testAggregatorResponse(t, h)
+	resultExpected = `requests_total{path="/foo"} 15
+duration_seconds_bucket{le="1"} 5
+duration_seconds_sum 3.5
+duration_seconds_count 5
+temperature 20
+up{target="` + targets[0] + `"} 1
+up{target="` + targets[1] + `"} 1
+up{target="` + targets[2] + `"} 0
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestAggregatorReuseConnections(t *testing.T) {
+	var conns uint64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "foo 1\n")
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddUint64(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	h := NewAggregator([]string{srv.URL}, AggregatorOpts{})
+	for i := 0; i < 5; i++ {
+		result := testAggregatorResponse(t, h)
+		if !strings.HasPrefix(result, "foo 1\n") {
+			t.Fatalf("unexpected result: %s", result)
+		}
+	}
+	if n := atomic.LoadUint64(&conns); n != 1 {
+		t.Fatalf("unexpected number of connections; got %d; want %d", n, 1)
+	}
+}
+
+func TestNewAggregatorInvalidTarget(t *testing.T) {
+	expectPanic(t, "invalid scheme", func() {
+		NewAggregator([]string{"ftp://foo/metrics"}, AggregatorOpts{})
+	})
+	expectPanic(t, "invalid target label", func() {
+		NewAggregator([]string{"http://foo/metrics"}, AggregatorOpts{
+			TargetLabel: "foo-bar",
+		})
+	})
+}
@@ -0,0 +1,16 @@
+//go:build go1.19
+
+package metrics
+
+import (
+	"sync/atomic"
+)
+
+// atomicUint64 is uint64 value, which is updated atomically.
+//
+// It is guaranteed to be 64-bit aligned on 32-bit platforms, so metrics containing it may be embedded into user structs
+// at any offset. See https://pkg.go.dev/sync/atomic#pkg-note-BUG
+type atomicUint64 = atomic.Uint64
+
+// atomicInt64 is int64 value, which is updated atomically. See atomicUint64 for details.
+type atomicInt64 = atomic.Int64
@@ -0,0 +1,59 @@
+//go:build !go1.19
+
+package metrics
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// atomicUint64 is uint64 value, which is updated atomically.
+//
+// Go older than 1.19 guarantees 64-bit alignment only for the first word of allocated structs, so atomicUint64
+// reserves 12 bytes and stores the value in the 64-bit aligned 8 bytes inside them. This allows embedding metrics
+// containing atomicUint64 into user structs at any offset. See https://pkg.go.dev/sync/atomic#pkg-note-BUG
+//
+// atomicUint64 must not be copied after the first use, since the copy may have distinct alignment.
+type atomicUint64 struct {
+	b [12]byte
+}
+
+func (a *atomicUint64) addr() *uint64 {
+	if uintptr(unsafe.Pointer(&a.b[0]))%8 == 0 {
+		return (*uint64)(unsafe.Pointer(&a.b[0]))
+	}
+	return (*uint64)(unsafe.Pointer(&a.b[4]))
+}
+
+func (a *atomicUint64) Load() uint64 {
+	return atomic.LoadUint64(a.addr())
+}
+
+func (a *atomicUint64) Store(v uint64) {
+	atomic.StoreUint64(a.addr(), v)
+}
+
+func (a *atomicUint64) Add(delta uint64) uint64 {
+	return atomic.AddUint64(a.addr(), delta)
+}
+
+func (a *atomicUint64) Swap(v uint64) uint64 {
+	return atomic.SwapUint64(a.addr(), v)
+}
+
+func (a *atomicUint64) CompareAndSwap(old, v uint64) bool {
+	return atomic.CompareAndSwapUint64(a.addr(), old, v)
+}
+
+// atomicInt64 is int64 value, which is updated atomically. See atomicUint64 for details.
+type atomicInt64 struct {
+	n atomicUint64
+}
+
+func (a *atomicInt64) Load() int64 {
+	return int64(a.n.Load())
+}
+
+func (a *atomicInt64) Store(v int64) {
+	a.n.Store(uint64(v))
+}
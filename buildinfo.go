@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// RegisterBuildInfo registers gauge with the given base name and labels in the default set. The gauge always has value 1.
+//
+// Such gauges are usually used for exposing build details such as `app_build_info{version="v1.2.3",commit="abcdef"} 1`.
+// Label values are escaped, so they may contain arbitrary chars.
+//
+// Repeated calls with the same name and labels are no-op. RegisterBuildInfo panics if the build info with the given name
+// is already registered with distinct labels.
+//
+// See also RegisterDefaultBuildInfo, which obtains labels from the build info embedded into the binary.
+func RegisterBuildInfo(name string, labels map[string]string) {
+	defaultSet.RegisterBuildInfo(name, labels)
+}
+
+// RegisterBuildInfo registers gauge with the given base name and labels in s. The gauge always has value 1.
+//
+// See RegisterBuildInfo for details.
+func (s *Set) RegisterBuildInfo(name string, labels map[string]string) {
+	metricName := s.mustNormalizeMetricName(BuildName(name, labels))
+
+	s.mu.Lock()
+	// defer will unlock in case of panic
+	defer s.mu.Unlock()
+
+	if prevMetricName, ok := s.buildInfos[name]; ok && s.m[prevMetricName] != nil {
+		if prevMetricName != metricName {
+			panic(fmt.Errorf("BUG: build info %q is already registered as %s; cannot register it as %s", name, prevMetricName, metricName))
+		}
+		return
+	}
+	g := &Gauge{
+		f: getBuildInfoValue,
+	}
+	s.mustRegisterLocked(metricName, g, false)
+	if s.buildInfos == nil {
+		s.buildInfos = make(map[string]string)
+	}
+	s.buildInfos[name] = metricName
+}
+
+func getBuildInfoValue() float64 {
+	return 1
+}
+
+// RegisterDefaultBuildInfo registers build info gauge with the given base name in the default set
+// with labels obtained from the build info embedded into the binary.
+//
+// The following labels are set:
+//
+//   - go_version - the Go version used for building the binary
+//   - path - the main module path
+//   - version - the main module version
+//   - revision - VCS revision for the main module
+//   - dirty - whether the source tree had local modifications at build time
+//
+// Labels, which cannot be obtained from the binary, are set to empty values. See RegisterBuildInfo for details.
+func RegisterDefaultBuildInfo(name string) {
+	defaultSet.RegisterDefaultBuildInfo(name)
+}
+
+// RegisterDefaultBuildInfo registers build info gauge with the given base name in s
+// with labels obtained from the build info embedded into the binary.
+//
+// See RegisterDefaultBuildInfo for details.
+func (s *Set) RegisterDefaultBuildInfo(name string) {
+	s.RegisterBuildInfo(name, getDefaultBuildInfoLabels())
+}
+
+func getDefaultBuildInfoLabels() map[string]string {
+	labels := map[string]string{
+		"go_version": runtime.Version(),
+		"path":       "",
+		"version":    "",
+		"revision":   "",
+		"dirty":      "",
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return labels
+	}
+	labels["path"] = bi.Main.Path
+	labels["version"] = bi.Main.Version
+	addBuildSettingsLabels(labels, bi)
+	return labels
+}
@@ -0,0 +1,22 @@
+//go:build go1.18
+
+package metrics
+
+import (
+	"runtime/debug"
+)
+
+// addBuildSettingsLabels adds labels obtained from the Go version and VCS settings in bi.
+func addBuildSettingsLabels(labels map[string]string, bi *debug.BuildInfo) {
+	if bi.GoVersion != "" {
+		labels["go_version"] = bi.GoVersion
+	}
+	for _, bs := range bi.Settings {
+		switch bs.Key {
+		case "vcs.revision":
+			labels["revision"] = bs.Value
+		case "vcs.modified":
+			labels["dirty"] = bs.Value
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !go1.18
+
+package metrics
+
+import (
+	"runtime/debug"
+)
+
+// addBuildSettingsLabels is no-op, since build settings are available in debug.BuildInfo starting from Go 1.18.
+func addBuildSettingsLabels(labels map[string]string, bi *debug.BuildInfo) {
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSetRegisterBuildInfo(t *testing.T) {
+	s := NewSet()
+	labels := map[string]string{
+		"version": "v1.2.3",
+		"branch":  "fix \"quotes\" and \\ backslash\nnewline",
+	}
+	s.RegisterBuildInfo("app_build_info", labels)
+
+	// Repeated registration with the same labels is no-op.
+	s.RegisterBuildInfo("app_build_info", map[string]string{
+		"branch":  labels["branch"],
+		"version": "v1.2.3",
+	})
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `app_build_info{branch="fix \"quotes\" and \\ backslash\nnewline",version="v1.2.3"} 1` + "\n"
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Registration with distinct labels must panic.
+	expectPanic(t, "distinct labels", func() {
+		s.RegisterBuildInfo("app_build_info", map[string]string{"version": "v1.2.4"})
+	})
+
+	// Invalid names must panic.
+	expectPanic(t, "invalid name", func() {
+		s.RegisterBuildInfo("bad name", nil)
+	})
+	expectPanic(t, "invalid label name", func() {
+		s.RegisterBuildInfo("app_info", map[string]string{"bad label": "x"})
+	})
+
+	// Build info may be registered again after unregistering.
+	if !s.UnregisterMetric(BuildName("app_build_info", labels)) {
+		t.Fatalf("cannot unregister build info")
+	}
+	s.RegisterBuildInfo("app_build_info", map[string]string{"version": "v1.2.4"})
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	resultExpected = `app_build_info{version="v1.2.4"} 1` + "\n"
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestSetRegisterDefaultBuildInfo(t *testing.T) {
+	s := NewSet()
+	s.RegisterDefaultBuildInfo("app_build_info")
+	s.RegisterDefaultBuildInfo("app_build_info")
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	for _, label := range []string{`dirty="`, `go_version="` + runtime.Version() + `"`, `path="`, `revision="`, `version="`} {
+		if !strings.Contains(result, label) {
+			t.Fatalf("missing %s label in the output\n%s", label, result)
+		}
+	}
+	if !strings.HasPrefix(result, "app_build_info{") || !strings.HasSuffix(result, "} 1\n") || strings.Count(result, "\n") != 1 {
+		t.Fatalf("unexpected result\n%s", result)
+	}
+}
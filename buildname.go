@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"fmt"
+)
+
+// BuildName returns metric name with the given base name and labels in canonical form.
+//
+// Labels are sorted by name, while `"`, `\` and newline chars in label values are escaped
+// according to Prometheus text exposition format. So the same base name and labels always result in the same metric name.
+// For example, BuildName("requests_total", map[string]string{"path": "/foo", "method": "GET"}) returns
+// `requests_total{method="GET",path="/foo"}`.
+//
+// The returned name may be passed to GetOrCreate* functions. See also GetOrCreateCounterWithLabels,
+// which doesn't build the intermediate name if the counter already exists.
+//
+// BuildName panics if base or label names are invalid.
+func BuildName(base string, labels map[string]string) string {
+	pairs := make([]string, 0, 2*len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name, value)
+	}
+	b := appendMetricName(nil, base, pairs)
+	return string(b)
+}
+
+// BuildNameOrdered returns metric name with the given base name and labels in canonical form.
+//
+// pairs must contain alternating label names and values, e.g. `"path", "/foo", "method", "GET"`.
+//
+// See BuildName for details.
+func BuildNameOrdered(base string, pairs ...string) string {
+	// Copy pairs, since they are sorted in place.
+	pairs = append([]string{}, pairs...)
+	b := appendMetricName(nil, base, pairs)
+	return string(b)
+}
+
+// appendMetricName validates base and label pairs and appends the canonical metric name built from them to dst.
+//
+// pairs are sorted in place by label names.
+func appendMetricName(dst []byte, base string, pairs []string) []byte {
+	if err := validateIdent(base); err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name %q: %s", base, err))
+	}
+	if len(pairs)%2 != 0 {
+		panic(fmt.Errorf("BUG: odd number of label name-value pairs for metric %q: %d", base, len(pairs)))
+	}
+	sortLabelPairs(pairs)
+	for i := 0; i < len(pairs); i += 2 {
+		name := pairs[i]
+		if err := validateIdent(name); err != nil {
+			panic(fmt.Errorf("BUG: invalid label name %q for metric %q: %s", name, base, err))
+		}
+		if i > 0 && pairs[i-2] == name {
+			panic(fmt.Errorf("BUG: duplicate label name %q for metric %q", name, base))
+		}
+	}
+	return appendSortedMetricName(dst, base, pairs)
+}
+
+// appendSortedMetricName appends metric name built from base and label pairs sorted by label names to dst.
+//
+// It doesn't validate base and pairs.
+func appendSortedMetricName(dst []byte, base string, pairs []string) []byte {
+	dst = append(dst, base...)
+	if len(pairs) == 0 {
+		return dst
+	}
+	dst = append(dst, '{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, pairs[i]...)
+		dst = append(dst, `="`...)
+		dst = appendEscapedLabelValue(dst, pairs[i+1])
+		dst = append(dst, '"')
+	}
+	dst = append(dst, '}')
+	return dst
+}
+
+// sortLabelPairs sorts alternating label names and values by label names.
+//
+// Insertion sort is used, since the number of labels is usually small and it doesn't allocate memory.
+func sortLabelPairs(pairs []string) {
+	for i := 2; i < len(pairs); i += 2 {
+		for j := i; j > 0 && pairs[j] < pairs[j-2]; j -= 2 {
+			pairs[j], pairs[j-2] = pairs[j-2], pairs[j]
+			pairs[j+1], pairs[j-1] = pairs[j-1], pairs[j+1]
+		}
+	}
+}
+
+// appendEscapedLabelValue appends v with escaped `"`, `\` and newline chars to dst.
+func appendEscapedLabelValue(dst []byte, v string) []byte {
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '"':
+			dst = append(dst, `\"`...)
+		case '\\':
+			dst = append(dst, `\\`...)
+		case '\n':
+			dst = append(dst, `\n`...)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// GetOrCreateCounterWithLabels returns registered counter with the given base name and labels
+// or creates new counter if the registry doesn't contain counter with the given name.
+//
+// It is equivalent to GetOrCreateCounter(BuildName(base, labels)), but it doesn't allocate the intermediate name
+// if the counter already exists.
+func GetOrCreateCounterWithLabels(base string, labels map[string]string) *Counter {
+	return defaultSet.GetOrCreateCounterWithLabels(base, labels)
+}
+
+// GetOrCreateCounterWithLabels returns registered counter in s with the given base name and labels
+// or creates new counter if s doesn't contain counter with the given name.
+//
+// See GetOrCreateCounterWithLabels for details.
+func (s *Set) GetOrCreateCounterWithLabels(base string, labels map[string]string) *Counter {
+	var pairsBuf [16]string
+	pairs := pairsBuf[:0]
+	for name, value := range labels {
+		pairs = append(pairs, name, value)
+	}
+
+	// Build the name in a stack buffer instead of a pooled buffer, so the hit path never allocates.
+	var nameBuf [256]byte
+	b := appendMetricName(nameBuf[:0], base, pairs)
+	s.mu.RLock()
+	nm := s.m[string(b)]
+	s.mu.RUnlock()
+	if nm == nil {
+		// Slow path - materialize the name and create the counter.
+		return s.GetOrCreateCounter(string(b))
+	}
+	c, ok := nm.metric.(*Counter)
+	if !ok {
+		panic(fmt.Errorf("BUG: metric %q isn't a Counter. It is %T", nm.name, nm.metric))
+	}
+	return c
+}
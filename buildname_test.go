@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestBuildName(t *testing.T) {
+	f := func(base string, labels map[string]string, resultExpected string) {
+		t.Helper()
+		result := BuildName(base, labels)
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %s; want %s", result, resultExpected)
+		}
+		if err := validateMetric(result); err != nil {
+			t.Fatalf("invalid metric name %q: %s", result, err)
+		}
+	}
+	f("foo", nil, "foo")
+	f("foo", map[string]string{}, "foo")
+	f("requests_total", map[string]string{
+		"path":   "/foo",
+		"method": "GET",
+	}, `requests_total{method="GET",path="/foo"}`)
+
+	// Escaped values
+	f("foo", map[string]string{
+		"a": `x"y`,
+		"b": `x\y`,
+		"c": "x\ny",
+		"d": "",
+	}, `foo{a="x\"y",b="x\\y",c="x\ny",d=""}`)
+}
+
+func TestBuildNameOrdered(t *testing.T) {
+	f := func(base string, pairs []string, resultExpected string) {
+		t.Helper()
+		pairsOrig := append([]string{}, pairs...)
+		result := BuildNameOrdered(base, pairs...)
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %s; want %s", result, resultExpected)
+		}
+		for i := range pairs {
+			if pairs[i] != pairsOrig[i] {
+				t.Fatalf("pairs mustn't be modified; got %q; want %q", pairs, pairsOrig)
+			}
+		}
+	}
+	f("foo", nil, "foo")
+	f("foo", []string{"b", "1", "a", "2"}, `foo{a="2",b="1"}`)
+	f("foo", []string{"c", "3", "b", "2", "a", `"1"`}, `foo{a="\"1\"",b="2",c="3"}`)
+
+	// The same labels in different order result in the same name
+	if a, b := BuildNameOrdered("foo", "x", "1", "y", "2"), BuildNameOrdered("foo", "y", "2", "x", "1"); a != b {
+		t.Fatalf("names must be equal; got %s and %s", a, b)
+	}
+}
+
+func TestBuildNameOrderedFailure(t *testing.T) {
+	f := func(base string, pairs ...string) {
+		t.Helper()
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expecting panic")
+			}
+		}()
+		BuildNameOrdered(base, pairs...)
+	}
+	f("")
+	f("foo{bar}")
+	f("foo", "a")
+	f("foo", "a-b", "c")
+	f("foo", "", "c")
+	f("foo", "a", "1", "a", "2")
+}
+
+func TestGetOrCreateCounterWithLabels(t *testing.T) {
+	s := NewSet()
+	labels := map[string]string{
+		"path":   `/foo"bar`,
+		"method": "GET",
+	}
+	c := s.GetOrCreateCounterWithLabels("requests_total", labels)
+	c.Inc()
+	if c2 := s.GetOrCreateCounterWithLabels("requests_total", labels); c2 != c {
+		t.Fatalf("expecting the same counter")
+	}
+	if c2 := s.GetOrCreateCounter(BuildName("requests_total", labels)); c2 != c {
+		t.Fatalf("expecting the same counter for BuildName")
+	}
+	if c2 := s.GetOrCreateCounter(`requests_total{method="GET",path="/foo\"bar"}`); c2 != c {
+		t.Fatalf("expecting the same counter for the canonical name")
+	}
+	if n := len(s.ListMetricNames()); n != 1 {
+		t.Fatalf("unexpected number of metrics; got %d; want 1", n)
+	}
+
+	// The hit path doesn't allocate memory
+	n := testing.AllocsPerRun(100, func() {
+		s.GetOrCreateCounterWithLabels("requests_total", labels).Inc()
+	})
+	if n != 0 {
+		t.Fatalf("unexpected number of allocations; got %v; want 0", n)
+	}
+}
@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetClockForTesting sets the clock for time-windowed metrics.
+//
+// now must return the current time, while tick must return a channel, which receives the time every d.
+// The clock is used for summary window rotation, metrics expiration for metrics with TTL, periodic pushes,
+// RateGauge windows and cached gauges.
+//
+// Background tickers use the clock, which is set at the moment they are started, so SetClockForTesting must be called
+// before creating the metrics. Summary window rotation is switched to the new clock when a summary is registered.
+//
+// SetClockForTesting(nil, nil) restores the default clock based on time.Now and time.NewTicker.
+// See also SetFakeClockForTesting.
+//
+// SetClockForTesting is intended for tests only.
+func SetClockForTesting(now func() time.Time, tick func(d time.Duration) <-chan time.Time) {
+	if now == nil && tick == nil {
+		setClock(realClock{})
+		return
+	}
+	if now == nil {
+		now = time.Now
+	}
+	if tick == nil {
+		tick = realClock{}.tick
+	}
+	setClock(&funcClock{
+		nowFunc:  now,
+		tickFunc: tick,
+	})
+}
+
+// SetFakeClockForTesting sets fc as the clock for time-windowed metrics.
+//
+// fc.Advance calls the tickers synchronously, so tests can deterministically trigger summary window rotations
+// and periodic pushes without sleeping. See SetClockForTesting for details.
+//
+// SetFakeClockForTesting(nil) restores the default clock.
+func SetFakeClockForTesting(fc *FakeClock) {
+	if fc == nil {
+		setClock(realClock{})
+		return
+	}
+	setClock(fc)
+}
+
+// clock is the source of time for time-windowed metrics.
+type clock interface {
+	// now returns the current time.
+	now() time.Time
+
+	// startTicker calls f every d until the returned stop func is called.
+	//
+	// The stop func waits until the in-flight f call is finished.
+	startTicker(d time.Duration, f func()) (stop func())
+}
+
+type clockHolder struct {
+	c clock
+}
+
+var currentClock atomic.Value
+
+func setClock(c clock) {
+	currentClock.Store(clockHolder{
+		c: c,
+	})
+}
+
+func getClock() clock {
+	if ch, ok := currentClock.Load().(clockHolder); ok {
+		return ch.c
+	}
+	return realClock{}
+}
+
+// clockNow returns the current time according to the clock set via SetClockForTesting.
+func clockNow() time.Time {
+	return getClock().now()
+}
+
+// realClock is the default clock based on time.Now and time.NewTicker.
+type realClock struct{}
+
+func (realClock) now() time.Time {
+	return time.Now()
+}
+
+func (realClock) tick(d time.Duration) <-chan time.Time {
+	return time.NewTicker(d).C
+}
+
+func (realClock) startTicker(d time.Duration, f func()) func() {
+	ticker := time.NewTicker(d)
+	stop := startChanTicker(ticker.C, f)
+	return func() {
+		stop()
+		ticker.Stop()
+	}
+}
+
+// funcClock is the clock set via SetClockForTesting.
+type funcClock struct {
+	nowFunc  func() time.Time
+	tickFunc func(d time.Duration) <-chan time.Time
+}
+
+func (fc *funcClock) now() time.Time {
+	return fc.nowFunc()
+}
+
+func (fc *funcClock) startTicker(d time.Duration, f func()) func() {
+	return startChanTicker(fc.tickFunc(d), f)
+}
+
+// startChanTicker calls f on every value received from tickCh until the returned stop func is called.
+func startChanTicker(tickCh <-chan time.Time, f func()) func() {
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for {
+			select {
+			case <-tickCh:
+				f()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopCh)
+		})
+		<-doneCh
+	}
+}
+
+// FakeClock is a manually advanced clock for tests.
+//
+// Pass it to SetFakeClockForTesting in order to use it for time-windowed metrics.
+type FakeClock struct {
+	mu      sync.Mutex
+	t       time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	d       time.Duration
+	next    time.Time
+	f       func()
+	stopped bool
+}
+
+// NewFakeClock returns new FakeClock, which starts at the given time.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{
+		t: start,
+	}
+}
+
+// Now returns the current time for fc.
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.t
+}
+
+// Advance moves fc forward by d.
+//
+// Tickers, which are due during d, are called synchronously in the order of their deadlines
+// with the time set to the deadline, so Advance returns only after all the due ticks are processed.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	end := fc.t.Add(d)
+	for {
+		ft := fc.nextTickerLocked(end)
+		if ft == nil {
+			break
+		}
+		fc.t = ft.next
+		ft.next = ft.next.Add(ft.d)
+		fc.mu.Unlock()
+		ft.f()
+		fc.mu.Lock()
+	}
+	fc.t = end
+	fc.mu.Unlock()
+}
+
+// nextTickerLocked returns the ticker with the earliest deadline not later than end.
+func (fc *FakeClock) nextTickerLocked(end time.Time) *fakeTicker {
+	sort.SliceStable(fc.tickers, func(i, j int) bool {
+		return fc.tickers[i].next.Before(fc.tickers[j].next)
+	})
+	for _, ft := range fc.tickers {
+		if !ft.stopped && !ft.next.After(end) {
+			return ft
+		}
+	}
+	return nil
+}
+
+func (fc *FakeClock) now() time.Time {
+	return fc.Now()
+}
+
+func (fc *FakeClock) startTicker(d time.Duration, f func()) func() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	ft := &fakeTicker{
+		d:    d,
+		next: fc.t.Add(d),
+		f:    f,
+	}
+	fc.tickers = append(fc.tickers, ft)
+	return func() {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		ft.stopped = true
+		for i, x := range fc.tickers {
+			if x == ft {
+				fc.tickers = append(fc.tickers[:i], fc.tickers[i+1:]...)
+				break
+			}
+		}
+	}
+}
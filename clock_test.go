@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	fc := NewFakeClock(start)
+	var ticks []string
+	stopFoo := fc.startTicker(2*time.Second, func() {
+		ticks = append(ticks, "foo@"+fc.Now().Sub(start).String())
+	})
+	fc.startTicker(3*time.Second, func() {
+		ticks = append(ticks, "bar@"+fc.Now().Sub(start).String())
+	})
+
+	fc.Advance(time.Second)
+	if len(ticks) != 0 {
+		t.Fatalf("unexpected ticks: %q", ticks)
+	}
+	fc.Advance(5 * time.Second)
+	ticksExpected := []string{"foo@2s", "bar@3s", "foo@4s", "foo@6s", "bar@6s"}
+	if !reflect.DeepEqual(ticks, ticksExpected) {
+		t.Fatalf("unexpected ticks; got %q; want %q", ticks, ticksExpected)
+	}
+	if d := fc.Now().Sub(start); d != 6*time.Second {
+		t.Fatalf("unexpected time; got %s; want %s", d, 6*time.Second)
+	}
+
+	// The stopped ticker mustn't be called.
+	stopFoo()
+	ticks = ticks[:0]
+	fc.Advance(3 * time.Second)
+	ticksExpected = []string{"bar@9s"}
+	if !reflect.DeepEqual(ticks, ticksExpected) {
+		t.Fatalf("unexpected ticks; got %q; want %q", ticks, ticksExpected)
+	}
+}
+
+func TestSetClockForTesting(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tickCh := make(chan time.Time)
+	SetClockForTesting(func() time.Time {
+		return now
+	}, func(_ time.Duration) <-chan time.Time {
+		return tickCh
+	})
+	defer SetClockForTesting(nil, nil)
+
+	if tm := clockNow(); !tm.Equal(now) {
+		t.Fatalf("unexpected time; got %s; want %s", tm, now)
+	}
+	doneCh := make(chan struct{}, 1)
+	stop := getClock().startTicker(time.Hour, func() {
+		doneCh <- struct{}{}
+	})
+	tickCh <- now
+	<-doneCh
+	stop()
+
+	SetClockForTesting(nil, nil)
+	if _, ok := getClock().(realClock); !ok {
+		t.Fatalf("unexpected clock after restoring the default clock: %T", getClock())
+	}
+}
+
+func TestFakeClockSummaryRotation(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetFakeClockForTesting(fc)
+	defer SetFakeClockForTesting(nil)
+
+	const window = 7 * time.Minute
+	s := NewSet()
+	sm := s.NewSummaryExt("summary", window, []float64{0.5})
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	sm.Update(5)
+	f(`summary_sum 5
+summary_count 1
+summary{quantile="0.5"} 5
+`)
+
+	// The sample must stay in the summary after the first rotation.
+	fc.Advance(window / 2)
+	f(`summary_sum 5
+summary_count 1
+summary{quantile="0.5"} 5
+`)
+
+	// The sample must be dropped after the window.
+	fc.Advance(window / 2)
+	f(`summary_sum 5
+summary_count 1
+`)
+}
+
+func TestFakeClockTTL(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetFakeClockForTesting(fc)
+	defer SetFakeClockForTesting(nil)
+
+	s := NewSet()
+	s.GetOrCreateCounterWithTTL("foo", time.Minute).Inc()
+	fc.Advance(30 * time.Second)
+	s.ExpireMetricsNow()
+	if names := s.ListMetricNames(); len(names) != 1 {
+		t.Fatalf("unexpected metrics before ttl; got %q; want [foo]", names)
+	}
+	fc.Advance(time.Minute)
+	s.ExpireMetricsNow()
+	if names := s.ListMetricNames(); len(names) != 0 {
+		t.Fatalf("unexpected metrics after ttl; got %q; want no metrics", names)
+	}
+}
+
+func TestFakeClockRateGauge(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetFakeClockForTesting(fc)
+	defer SetFakeClockForTesting(nil)
+
+	s := NewSet()
+	rg := s.NewRateGauge("events_per_second", 10*time.Second)
+	fc.Advance(10 * time.Second)
+	rg.Add(20)
+	if v := rg.Get(); v != 20.0/9 {
+		t.Fatalf("unexpected rate; got %v; want %v", v, 20.0/9)
+	}
+	fc.Advance(20 * time.Second)
+	if v := rg.Get(); v != 0 {
+		t.Fatalf("unexpected rate after the window; got %v; want %v", v, 0)
+	}
+}
+
+func TestFakeClockPush(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1000, 0))
+	SetFakeClockForTesting(fc)
+	defer SetFakeClockForTesting(nil)
+
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		mu.Lock()
+		requests = append(requests, string(data))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	c := s.NewCounter("foo")
+	pw, err := s.StartPush(srv.URL, time.Hour, &PushOptions{
+		DisableCompression: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Set(1)
+	fc.Advance(time.Hour)
+	c.Set(2)
+	fc.Advance(time.Hour)
+	if err := pw.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fc.Advance(time.Hour)
+
+	mu.Lock()
+	result := strings.Join(requests, "")
+	mu.Unlock()
+	resultExpected := "foo 1\nfoo 2\nfoo 2\n"
+	if result != resultExpected {
+		t.Fatalf("unexpected pushed data; got %q; want %q", result, resultExpected)
+	}
+}
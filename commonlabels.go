@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NewSetWithLabels creates new set of metrics, which adds the given labels to all the metrics registered in it.
+//
+// The labels are merged into metric names at registration time, so metrics may be obtained and unregistered
+// by the names passed at registration time and by the full names with the common labels, while the writing of metrics
+// doesn't have any overhead. Label values are escaped according to Prometheus text exposition format.
+// If the metric name already contains some of the common labels, then their values from the metric name are preferred.
+// For example, `requests_total{tenant="foo"}` is registered as is in the set created with `tenant="acme"` label.
+//
+// The common labels cannot be changed after the set creation.
+//
+// It panics if label names are invalid.
+func NewSetWithLabels(labels map[string]string) *Set {
+	s := NewSet()
+	for name, value := range labels {
+		if err := validateIdent(name); err != nil {
+			panic(fmt.Errorf("BUG: invalid label name %q: %s", name, err))
+		}
+		s.commonLabels = append(s.commonLabels, label{
+			name:  name,
+			value: value,
+		})
+	}
+	sort.Slice(s.commonLabels, func(i, j int) bool {
+		return s.commonLabels[i].name < s.commonLabels[j].name
+	})
+	return s
+}
+
+// normalizeMetricName validates name and returns its canonical form with the common labels for s.
+func (s *Set) normalizeMetricName(name string) (string, error) {
+	nameNormalized, err := normalizeMetricName(name)
+	if err != nil {
+		return "", err
+	}
+	return s.addCommonLabels(nameNormalized)
+}
+
+// mustNormalizeMetricName validates name and returns its canonical form with the common labels for s.
+//
+// It panics if name is invalid.
+func (s *Set) mustNormalizeMetricName(name string) string {
+	nameNormalized, err := s.normalizeMetricName(name)
+	if err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
+	}
+	return nameNormalized
+}
+
+// addCommonLabels adds the common labels for s, which are missing in the normalized metric name, to the name.
+func (s *Set) addCommonLabels(name string) (string, error) {
+	if len(s.commonLabels) == 0 {
+		return name, nil
+	}
+	base := name
+	labelsStr := ""
+	var labels []label
+	if n := strings.IndexByte(name, '{'); n >= 0 {
+		base = name[:n]
+		labelsStr = name[n+1 : len(name)-1]
+		var err error
+		labels, _, err = parseLabels(nil, name[n+1:])
+		if err != nil {
+			return "", fmt.Errorf("cannot parse labels: %w", err)
+		}
+	}
+	b := make([]byte, 0, 2*len(name))
+	b = append(b, base...)
+	b = append(b, '{')
+	b = append(b, labelsStr...)
+	hasLabels := labelsStr != ""
+	for _, cl := range s.commonLabels {
+		if hasLabel(labels, cl.name) {
+			continue
+		}
+		if hasLabels {
+			b = append(b, ',')
+		}
+		b = append(b, cl.name...)
+		b = append(b, `="`...)
+		b = appendEscapedLabelValue(b, cl.value)
+		b = append(b, '"')
+		hasLabels = true
+	}
+	b = append(b, '}')
+	return string(b), nil
+}
+
+// addAliasLocked registers name as an alias for nm in s.m, so nm may be obtained by the name passed to the registration function.
+//
+// The name differs from nm.name if it doesn't contain common labels or if it isn't in the canonical form,
+// e.g. quoted UTF-8 names such as {"foo.bar"}. See SetAllowUTF8Names.
+func (s *Set) addAliasLocked(name string, nm *namedMetric) {
+	if name == nm.name {
+		return
+	}
+	if _, ok := s.m[name]; ok {
+		return
+	}
+	s.m[name] = nm
+	nm.aliases = append(nm.aliases, name)
+}
+
+// getNamedMetricLocked returns the metric registered in s under the given name or its alias.
+//
+// The name is normalized if it isn't found in s.m, so the metric may be obtained by any name, which refers to it.
+// nil is returned if the metric isn't registered.
+func (s *Set) getNamedMetricLocked(name string) *namedMetric {
+	if nm := s.m[name]; nm != nil {
+		return nm
+	}
+	nameNormalized, err := s.normalizeMetricName(name)
+	if err != nil || nameNormalized == name {
+		return nil
+	}
+	return s.m[nameNormalized]
+}
+
+// deleteAliasesLocked deletes aliases for nm from s.m.
+func (s *Set) deleteAliasesLocked(nm *namedMetric) {
+	for _, name := range nm.aliases {
+		if s.m[name] == nm {
+			delete(s.m, name)
+		}
+	}
+	nm.aliases = nil
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestNewSetWithLabels(t *testing.T) {
+	s := NewSetWithLabels(map[string]string{
+		"tenant": `ac"me`,
+		"env":    "prod",
+	})
+	c := s.NewCounter("requests_total")
+	c.Inc()
+	s.GetOrCreateCounter(`requests_total{path="/foo"}`).Add(2)
+	s.GetOrCreateGauge(`queue_size{tenant="other"}`, func() float64 { return 3 })
+	s.NewHistogram("duration_seconds").Update(1)
+
+	// GetOrCreate* must return the registered metric by the name passed at registration time
+	// and by the full name with common labels.
+	if c2 := s.GetOrCreateCounter("requests_total"); c2 != c {
+		t.Fatalf("unexpected counter returned by the name without common labels")
+	}
+	if c2 := s.GetOrCreateCounter(`requests_total{env="prod",tenant="ac\"me"}`); c2 != c {
+		t.Fatalf("unexpected counter returned by the full name")
+	}
+	if m, ok := s.GetMetric("requests_total"); !ok || m != Metric(c) {
+		t.Fatalf("cannot obtain the counter via GetMetric")
+	}
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `duration_seconds_bucket{env="prod",tenant="ac\"me",vmrange="8.799e-01...1.000e+00"} 1
+duration_seconds_sum{env="prod",tenant="ac\"me"} 1
+duration_seconds_count{env="prod",tenant="ac\"me"} 1
+queue_size{tenant="other",env="prod"} 3
+requests_total{env="prod",tenant="ac\"me"} 1
+requests_total{path="/foo",env="prod",tenant="ac\"me"} 2
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	list := s.ListMetricNames()
+	listExpected := []string{
+		`duration_seconds{env="prod",tenant="ac\"me"}`,
+		`queue_size{tenant="other",env="prod"}`,
+		`requests_total{env="prod",tenant="ac\"me"}`,
+		`requests_total{path="/foo",env="prod",tenant="ac\"me"}`,
+	}
+	if !reflect.DeepEqual(list, listExpected) {
+		t.Fatalf("unexpected metric names; got %q; want %q", list, listExpected)
+	}
+
+	// Duplicate registration must be detected regardless of the common labels in the name.
+	expectPanic(t, "NewCounter(duplicate)", func() {
+		s.NewCounter(`requests_total{env="prod"}`)
+	})
+
+	// Unregistering by the name without common labels must remove the metric together with its aliases.
+	if !s.UnregisterMetric("requests_total") {
+		t.Fatalf("cannot unregister the counter")
+	}
+	if _, ok := s.GetMetric("requests_total"); ok {
+		t.Fatalf("the counter must be missing after unregistering")
+	}
+	if _, ok := s.GetMetric(`requests_total{env="prod",tenant="ac\"me"}`); ok {
+		t.Fatalf("the counter must be missing by the full name after unregistering")
+	}
+	if c2 := s.GetOrCreateCounter("requests_total"); c2 == c {
+		t.Fatalf("new counter must be created after unregistering")
+	}
+}
+
+func TestNewSetWithLabelsInvalidLabel(t *testing.T) {
+	expectPanic(t, "NewSetWithLabels(invalid)", func() {
+		NewSetWithLabels(map[string]string{
+			"foo-bar": "baz",
+		})
+	})
+}
+
+func TestSetAddCommonLabels(t *testing.T) {
+	s := NewSetWithLabels(map[string]string{
+		"b": "y",
+		"a": "x",
+	})
+	f := func(name, resultExpected string) {
+		t.Helper()
+		result, err := s.addCommonLabels(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q; got %q; want %q", name, result, resultExpected)
+		}
+	}
+	f("foo", `foo{a="x",b="y"}`)
+	f(`foo{c="z"}`, `foo{c="z",a="x",b="y"}`)
+	f(`foo{b="q"}`, `foo{b="q",a="x"}`)
+	f(`foo{a="1",b="2"}`, `foo{a="1",b="2"}`)
+	f(`foo{c="a=\"b\",}"}`, `foo{c="a=\"b\",}",a="x",b="y"}`)
+}
@@ -3,7 +3,11 @@ package metrics
 import (
 	"fmt"
 	"io"
+	"log"
+	"strconv"
 	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 // NewCounter registers and returns new counter with the given name.
@@ -20,53 +24,298 @@ func NewCounter(name string) *Counter {
 	return defaultSet.NewCounter(name)
 }
 
+// CounterOpts contains options for NewCounterOpt.
+type CounterOpts struct {
+	// Name is the counter name. It must be valid Prometheus-compatible metric with possible labels.
+	Name string
+
+	// Help is an optional description for the counter. It is exposed in the `# HELP` line.
+	Help string
+
+	// Unit is an optional unit for the counter such as `seconds` or `bytes`. It is exposed in the `# UNIT` line in OpenMetrics format.
+	//
+	// See SetStrictUnitNames and NormalizeUnit.
+	Unit string
+}
+
+// NewCounterOpt registers and returns new counter with the given opts.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+//
+// The returned counter is safe to use from concurrent goroutines.
+func NewCounterOpt(opts CounterOpts) *Counter {
+	return defaultSet.NewCounterOpt(opts)
+}
+
 // Counter is a counter.
 //
-// It may be used as a gauge if Dec and Set are called.
+// Counter values must monotonically increase, since Prometheus rate() and increase() functions treat decreases
+// as counter resets. Counter value wraps around zero if it is decreased below zero via Dec or Add with negative value,
+// and this results in huge value close to 2^64. Use AddInt64, which rejects such updates, or Gauge for values,
+// which may decrease. Gauge created via NewGauge(name, nil) supports Inc, Dec and Add with negative values.
+//
+// Call SetLogNegativeCounterUpdates for detecting counter decreases.
 type Counter struct {
-	n uint64
+	// n is 64-bit aligned on 32-bit platforms, so Counter may be embedded into user structs at any offset.
+	n atomicUint64
+
+	created createdTimestamp
+
+	// ext points to counterExt. It is allocated on the first use of the rarely used features,
+	// so Counter remains small for the common case.
+	ext unsafe.Pointer
+}
+
+// counterExt contains the optional state for Counter.
+type counterExt struct {
+	timestamp metricTimestamp
+
+	statsd statsdMirror
+
+	exemplar exemplarHolder
+}
+
+// loadExt returns the optional state for c. It returns nil if the state isn't allocated yet.
+func (c *Counter) loadExt() *counterExt {
+	return (*counterExt)(atomic.LoadPointer(&c.ext))
+}
+
+// getExt returns the optional state for c. The state is allocated if it is missing.
+func (c *Counter) getExt() *counterExt {
+	if ext := c.loadExt(); ext != nil {
+		return ext
+	}
+	extNew := &counterExt{}
+	if atomic.CompareAndSwapPointer(&c.ext, nil, unsafe.Pointer(extNew)) {
+		return extNew
+	}
+	return c.loadExt()
+}
+
+// loadStatsDSink returns StatsD sink for c. It returns nil if c isn't mirrored to StatsD.
+func (c *Counter) loadStatsDSink() *statsdSink {
+	ext := c.loadExt()
+	if ext == nil {
+		return nil
+	}
+	return ext.statsd.load()
+}
+
+// loadTimestamp returns the timestamp attached to c. It returns nil if the timestamp has been never attached.
+func (c *Counter) loadTimestamp() *metricTimestamp {
+	ext := c.loadExt()
+	if ext == nil {
+		return nil
+	}
+	return &ext.timestamp
 }
 
 // Inc increments c.
 func (c *Counter) Inc() {
-	atomic.AddUint64(&c.n, 1)
+	c.n.Add(1)
+	if ss := c.loadStatsDSink(); ss != nil {
+		ss.sendInt(1, "c")
+	}
 }
 
 // Dec decrements c.
+//
+// Use Gauge instead of Counter for values, which may decrease. See Counter docs for details.
 func (c *Counter) Dec() {
-	atomic.AddUint64(&c.n, ^uint64(0))
+	logNegativeCounterUpdate(-1)
+	c.n.Add(^uint64(0))
+	if ss := c.loadStatsDSink(); ss != nil {
+		ss.sendInt(-1, "c")
+	}
 }
 
 // Add adds n to c.
+//
+// Negative n decreases c. The value wraps around zero if it is decreased below zero. Use AddInt64 for rejecting such updates.
 func (c *Counter) Add(n int) {
-	atomic.AddUint64(&c.n, uint64(n))
+	if n < 0 {
+		logNegativeCounterUpdate(int64(n))
+	}
+	c.n.Add(uint64(n))
+	if ss := c.loadStatsDSink(); ss != nil {
+		ss.sendInt(int64(n), "c")
+	}
 }
 
 // AddInt64 adds n to c.
-func (c *Counter) AddInt64(n int64) {
-	atomic.AddUint64(&c.n, uint64(n))
+//
+// An error is returned and c isn't updated if negative n would decrease c below zero.
+func (c *Counter) AddInt64(n int64) error {
+	if n < 0 {
+		logNegativeCounterUpdate(n)
+		for {
+			v := c.n.Load()
+			if uint64(-n) > v {
+				return fmt.Errorf("cannot add %d to counter with value %d, since the counter cannot become negative", n, v)
+			}
+			if c.n.CompareAndSwap(v, v-uint64(-n)) {
+				break
+			}
+		}
+	} else {
+		c.n.Add(uint64(n))
+	}
+	if ss := c.loadStatsDSink(); ss != nil {
+		ss.sendInt(n, "c")
+	}
+	return nil
+}
+
+// SetLogNegativeCounterUpdates enables logging of the first decrease of Counter via Dec, Add or AddInt64 with negative value.
+//
+// This is useful for detecting counters, which are used as gauges, during debugging.
+// The decrease is logged only once per process in order to avoid log flooding.
+func SetLogNegativeCounterUpdates(enabled bool) {
+	n := uint32(0)
+	if enabled {
+		n = 1
+	}
+	atomic.StoreUint32(&logNegativeCounterUpdates, n)
+}
+
+var (
+	logNegativeCounterUpdates uint32
+
+	// negativeCounterUpdateLogged is set to 1 after the first logged decrease of Counter.
+	negativeCounterUpdateLogged uint32
+)
+
+func logNegativeCounterUpdate(n int64) {
+	if atomic.LoadUint32(&logNegativeCounterUpdates) == 0 {
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&negativeCounterUpdateLogged, 0, 1) {
+		return
+	}
+	log.Printf("WARNING: metrics: Counter is decreased by %d; counters must monotonically increase; use Gauge for values, which may decrease; "+
+		"subsequent decreases of counters aren't logged", -n)
+}
+
+// IncWithExemplar increments c and attaches exemplar with the given labels to c.
+//
+// See AddWithExemplar for details.
+func (c *Counter) IncWithExemplar(labels map[string]string) error {
+	return c.AddWithExemplar(1, labels)
+}
+
+// AddWithExemplar adds n to c and attaches exemplar with the given labels and value n to c.
+//
+// labels usually contain a reference to the trace, such as `trace_id`. The most recent exemplar is retained
+// and is exposed only in OpenMetrics text format, since Prometheus text format doesn't support exemplars.
+//
+// The combined length of label names and values must not exceed 128 runes. c is updated even if labels are invalid -
+// the exemplar is dropped and an error is returned in this case.
+func (c *Counter) AddWithExemplar(n int, labels map[string]string) error {
+	c.Add(n)
+	e, err := newExemplar(float64(n), labels)
+	if err != nil {
+		return err
+	}
+	c.getExt().exemplar.store(e)
+	return nil
 }
 
 // Get returns the current value for c.
 func (c *Counter) Get() uint64 {
-	return atomic.LoadUint64(&c.n)
+	return c.n.Load()
 }
 
 // Set sets c value to n.
 func (c *Counter) Set(n uint64) {
-	atomic.StoreUint64(&c.n, n)
+	c.n.Store(n)
+	if n == 0 {
+		c.created.onReset()
+	}
+	if ss := c.loadStatsDSink(); ss != nil {
+		ss.sendUint(n, "g")
+	}
+}
+
+// SetWithTimestamp sets c value to n and attaches the given timestamp ts to c.
+//
+// The timestamp is exposed after the value, so the scraper uses it instead of the scrape time.
+// This is useful for mirroring metrics from other systems with their original timestamps.
+// The timestamp is retained on subsequent updates of c until ClearTimestamp is called.
+// Zero ts clears the timestamp.
+func (c *Counter) SetWithTimestamp(n uint64, ts time.Time) {
+	c.Set(n)
+	c.getExt().timestamp.store(ts)
+}
+
+// ClearTimestamp removes the timestamp attached to c via SetWithTimestamp, so the scraper uses the scrape time for c.
+func (c *Counter) ClearTimestamp() {
+	if ext := c.loadExt(); ext != nil {
+		ext.timestamp.store(time.Time{})
+	}
+}
+
+// Swap sets c to n and returns the previous value.
+//
+// Swap isn't mirrored to StatsD, since it is intended for reading deltas. See also GetAndReset.
+func (c *Counter) Swap(n uint64) uint64 {
+	if n == 0 {
+		c.created.onReset()
+	}
+	return c.n.Swap(n)
+}
+
+// GetAndReset atomically returns the current value for c and resets it to zero.
+//
+// This is useful for reporting deltas to systems, which expect them. Do not call GetAndReset
+// for counters scraped by Prometheus, since scrapes observe sawtooth values instead of monotonically
+// increasing counter in this case. See also SnapshotAndReset.
+func (c *Counter) GetAndReset() uint64 {
+	return c.Swap(0)
+}
+
+// MarshalTo writes c with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (c *Counter) MarshalTo(prefix string, w io.Writer) {
+	c.marshalTo(prefix, w)
 }
 
 // marshalTo marshals c with the given prefix to w.
 func (c *Counter) marshalTo(prefix string, w io.Writer) {
 	v := c.Get()
-	fmt.Fprintf(w, "%s %d\n", prefix, v)
+	writeSampleUint64(w, prefix, v, c.loadTimestamp())
+}
+
+func (c *Counter) marshalToOpenMetricsSample(name string, w io.Writer) {
+	v := c.Get()
+	var mt *metricTimestamp
+	var e *exemplar
+	if ext := c.loadExt(); ext != nil {
+		mt = &ext.timestamp
+		e = ext.exemplar.load()
+	}
+	writeOpenMetricsSample(w, name, strconv.FormatUint(v, 10), mt, e)
+}
+
+func (c *Counter) getTimestamp() *metricTimestamp {
+	return &c.getExt().timestamp
 }
 
 func (c *Counter) metricType() string {
 	return "counter"
 }
 
+func (c *Counter) getStatsDMirror(create bool) *statsdMirror {
+	if !create {
+		ext := c.loadExt()
+		if ext == nil {
+			return nil
+		}
+		return &ext.statsd
+	}
+	return &c.getExt().statsd
+}
+
 // GetOrCreateCounter returns registered counter with the given name
 // or creates new counter if the registry doesn't contain counter with
 // the given name.
@@ -1,8 +1,15 @@
 package metrics
 
 import (
+	"bytes"
 	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCounterSerial(t *testing.T) {
@@ -47,6 +54,64 @@ func TestCounterConcurrent(t *testing.T) {
 	}
 }
 
+func TestCounterEmbeddedUnaligned(t *testing.T) {
+	// The counters are embedded after bool fields, so they aren't 64-bit aligned on 32-bit platforms
+	// unless the atomic fields inside them guarantee the alignment. Run the test with GOARCH=386 in order to verify this.
+	type embedded struct {
+		b bool
+		c Counter
+		x bool
+		h Histogram
+		y bool
+		g Gauge
+	}
+	var e embedded
+	const concurrency = 5
+	const iterations = 1000
+	err := testConcurrent(func() error {
+		for i := 0; i < iterations; i++ {
+			e.c.Inc()
+			e.h.Update(1)
+			e.g.Add(1)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := e.c.Get(); n != concurrency*iterations {
+		t.Fatalf("unexpected counter value; got %d; want %d", n, concurrency*iterations)
+	}
+	if sum := e.h.GetSum(); sum != concurrency*iterations {
+		t.Fatalf("unexpected histogram sum; got %v; want %v", sum, concurrency*iterations)
+	}
+	if v := e.g.Get(); v != concurrency*iterations {
+		t.Fatalf("unexpected gauge value; got %v; want %v", v, concurrency*iterations)
+	}
+}
+
+func TestCounterExtLazy(t *testing.T) {
+	s := NewSet()
+	c := s.NewCounter("ext_lazy_total")
+	c.Inc()
+	c.Set(10)
+	c.ClearTimestamp()
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	s.WriteOpenMetrics(&bb)
+	s.ResetAllMetrics()
+
+	// The optional state mustn't be allocated for counters, which don't use it.
+	if c.loadExt() != nil {
+		t.Fatalf("unexpected optional state allocated for the counter")
+	}
+
+	c.SetWithTimestamp(5, time.Unix(1700000000, 0))
+	if c.loadExt() == nil {
+		t.Fatalf("missing optional state for the counter with timestamp")
+	}
+}
+
 func TestGetOrCreateCounterSerial(t *testing.T) {
 	name := "GetOrCreateCounterSerial"
 	if err := testGetOrCreateCounter(name); err != nil {
@@ -74,3 +139,68 @@ func testGetOrCreateCounter(name string) error {
 	}
 	return nil
 }
+
+func TestCounterSwap(t *testing.T) {
+	c := NewSet().NewCounter("foo")
+	c.Add(10)
+	if n := c.Swap(3); n != 10 {
+		t.Fatalf("unexpected previous value; got %d; want 10", n)
+	}
+	if n := c.GetAndReset(); n != 3 {
+		t.Fatalf("unexpected value; got %d; want 3", n)
+	}
+	if n := c.Get(); n != 0 {
+		t.Fatalf("unexpected value after reset; got %d; want 0", n)
+	}
+}
+
+func TestCounterAddInt64(t *testing.T) {
+	c := &Counter{}
+	f := func(n int64, valueExpected uint64, errExpected bool) {
+		t.Helper()
+		err := c.AddInt64(n)
+		if errExpected && err == nil {
+			t.Fatalf("expecting non-nil error when adding %d", n)
+		}
+		if !errExpected && err != nil {
+			t.Fatalf("unexpected error when adding %d: %s", n, err)
+		}
+		if v := c.Get(); v != valueExpected {
+			t.Fatalf("unexpected counter value after adding %d; got %d; want %d", n, v, valueExpected)
+		}
+	}
+	f(10, 10, false)
+	f(-3, 7, false)
+	f(-7, 0, false)
+	f(-1, 0, true)
+	f(5, 5, false)
+	f(-6, 5, true)
+	f(math.MinInt64, 5, true)
+}
+
+func TestLogNegativeCounterUpdates(t *testing.T) {
+	var bb bytes.Buffer
+	log.SetOutput(&bb)
+	defer log.SetOutput(os.Stderr)
+
+	SetLogNegativeCounterUpdates(true)
+	defer SetLogNegativeCounterUpdates(false)
+	atomic.StoreUint32(&negativeCounterUpdateLogged, 0)
+
+	c := &Counter{}
+	c.Add(5)
+	if bb.Len() > 0 {
+		t.Fatalf("unexpected log output for positive update: %q", bb.String())
+	}
+	c.Add(-2)
+	if !strings.Contains(bb.String(), "Counter is decreased by 2") {
+		t.Fatalf("missing log output for negative update: %q", bb.String())
+	}
+
+	// Subsequent decreases mustn't be logged.
+	bb.Reset()
+	c.Dec()
+	if bb.Len() > 0 {
+		t.Fatalf("unexpected log output for the subsequent decrease: %q", bb.String())
+	}
+}
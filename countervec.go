@@ -0,0 +1,58 @@
+package metrics
+
+// CounterVec is a collection of counters with the same name and distinct values for the given label names.
+//
+// CounterVec is safe to use from concurrent goroutines.
+type CounterVec struct {
+	mv *metricVec
+}
+
+// NewCounterVec returns new CounterVec with the given name and labelNames.
+//
+// name must be valid Prometheus-compatible metric name without labels.
+// labelNames must be valid Prometheus-compatible label names.
+//
+// Counters for the particular label values are created and registered in the default set
+// on the first CounterVec.WithLabelValues call. Labels in counter names are sorted by label name,
+// so the names match the names built via BuildName for the same labels.
+func NewCounterVec(name string, labelNames []string) *CounterVec {
+	return defaultSet.NewCounterVec(name, labelNames)
+}
+
+// NewCounterVec returns new CounterVec with the given name and labelNames, which registers counters in s.
+//
+// See NewCounterVec for details.
+func (s *Set) NewCounterVec(name string, labelNames []string) *CounterVec {
+	newMetric := func(name string) metric {
+		return s.NewCounter(name)
+	}
+	return &CounterVec{
+		mv: newMetricVec(s, name, labelNames, newMetric),
+	}
+}
+
+// WithLabelValues returns the counter for the given label values.
+//
+// The number of values must match the number of label names passed to NewCounterVec.
+// The counter is created and registered on the first call for the given values.
+// Subsequent calls for the same values return the same counter without memory allocations.
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	return cv.mv.withLabelValues(values).(*Counter)
+}
+
+// DeleteLabelValues unregisters the counter for the given label values.
+//
+// True is returned if the counter has been unregistered.
+func (cv *CounterVec) DeleteLabelValues(values ...string) bool {
+	return cv.mv.deleteLabelValues(values)
+}
+
+// Reset unregisters all the counters in cv.
+func (cv *CounterVec) Reset() {
+	cv.mv.reset()
+}
+
+// Len returns the number of counters in cv.
+func (cv *CounterVec) Len() int {
+	return cv.mv.len()
+}
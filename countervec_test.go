@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	s := NewSet()
+	cv := s.NewCounterVec("requests_total", []string{"path", "code"})
+	cv.WithLabelValues("/foo", "200").Inc()
+	cv.WithLabelValues("/foo", "200").Add(2)
+	cv.WithLabelValues("/bar", "500").Inc()
+	cv.WithLabelValues("a\"b\\c\nd", "").Inc()
+
+	if n := cv.WithLabelValues("/foo", "200").Get(); n != 3 {
+		t.Fatalf("unexpected counter value; got %d; want 3", n)
+	}
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `requests_total{code="",path="a\"b\\c\nd"} 1
+requests_total{code="200",path="/foo"} 3
+requests_total{code="500",path="/bar"} 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	if !cv.DeleteLabelValues("/bar", "500") {
+		t.Fatalf("expecting the counter to be deleted")
+	}
+	if cv.DeleteLabelValues("/bar", "500") {
+		t.Fatalf("the counter must be already deleted")
+	}
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	result = bb.String()
+	resultExpected = `requests_total{code="",path="a\"b\\c\nd"} 1
+requests_total{code="200",path="/foo"} 3
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output after deletion;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// The deleted counter must be re-created from scratch.
+	if n := cv.WithLabelValues("/bar", "500").Get(); n != 0 {
+		t.Fatalf("unexpected value for re-created counter; got %d; want 0", n)
+	}
+}
+
+func TestCounterVecDistinctKeys(t *testing.T) {
+	s := NewSet()
+	cv := s.NewCounterVec("foo", []string{"a", "b"})
+	c1 := cv.WithLabelValues("x", "yz")
+	c2 := cv.WithLabelValues("xy", "z")
+	if c1 == c2 {
+		t.Fatalf("distinct label values must result in distinct counters")
+	}
+}
+
+func TestCounterVecConcurrent(t *testing.T) {
+	s := NewSet()
+	cv := s.NewCounterVec("foo", []string{"worker"})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				cv.WithLabelValues(fmt.Sprintf("%d", j)).Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	for j := 0; j < 10; j++ {
+		if n := cv.WithLabelValues(fmt.Sprintf("%d", j)).Get(); n != 5 {
+			t.Fatalf("unexpected counter value for worker %d; got %d; want 5", j, n)
+		}
+	}
+}
+
+func TestCounterVecInvalidLabelValues(t *testing.T) {
+	s := NewSet()
+	cv := s.NewCounterVec("foo", []string{"a"})
+	expectPanic(t, "WithLabelValues", func() {
+		cv.WithLabelValues("x", "y")
+	})
+	expectPanic(t, "NewCounterVec", func() {
+		s.NewCounterVec("bar", []string{"a", "a"})
+	})
+	expectPanic(t, "NewCounterVec", func() {
+		s.NewCounterVec("bar", []string{"a-b"})
+	})
+}
+
+func TestCounterVecWithLabelValuesNoAllocs(t *testing.T) {
+	s := NewSet()
+	cv := s.NewCounterVec("foo", []string{"method", "path"})
+	cv.WithLabelValues("GET", "/")
+	allocs := testing.AllocsPerRun(100, func() {
+		cv.WithLabelValues("GET", "/").Inc()
+	})
+	if allocs != 0 {
+		t.Fatalf("unexpected number of allocations; got %v; want 0", allocs)
+	}
+}
+
+func TestCounterVecBuildName(t *testing.T) {
+	s := NewSet()
+	cv := s.NewCounterVec("req_total", []string{"path", "method"})
+	cv.WithLabelValues("/a", "GET").Inc()
+
+	// The vec and BuildName must map the same labels to the same series regardless of the label order.
+	c := s.GetOrCreateCounter(BuildName("req_total", map[string]string{
+		"path":   "/a",
+		"method": "GET",
+	}))
+	if c != cv.WithLabelValues("/a", "GET") {
+		t.Fatalf("the vec and BuildName must return the same counter")
+	}
+	c.Inc()
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `req_total{method="GET",path="/a"} 2
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"io"
+)
+
+// countingWriter counts the number of bytes written to w and remembers the first error returned by w.
+//
+// Writes after the first error aren't passed to w - they return the remembered error instead,
+// so the metrics aren't written into broken connections.
+type countingWriter struct {
+	w   io.Writer
+	n   uint64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	if err != nil {
+		cw.err = err
+	}
+	return n, err
+}
+
+// getWriteError returns the first error occurred when writing to w.
+//
+// nil is returned if w doesn't track write errors.
+func getWriteError(w io.Writer) error {
+	if cw, ok := w.(*countingWriter); ok {
+		return cw.err
+	}
+	return nil
+}
+
+// setWriteError sets err as the first error for w, so the remaining writes to w are skipped.
+//
+// It is no-op if w doesn't track write errors or if w already has an error.
+func setWriteError(w io.Writer, err error) {
+	if cw, ok := w.(*countingWriter); ok && cw.err == nil {
+		cw.err = err
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// failingWriter returns an error for writes exceeding the first maxBytes bytes.
+type failingWriter struct {
+	bb       bytes.Buffer
+	maxBytes int
+
+	failed           bool
+	writesAfterError int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if fw.failed {
+		fw.writesAfterError++
+	}
+	if fw.bb.Len()+len(p) > fw.maxBytes {
+		fw.failed = true
+		return 0, fmt.Errorf("connection closed")
+	}
+	return fw.bb.Write(p)
+}
+
+func TestCountingWriter(t *testing.T) {
+	fw := &failingWriter{
+		maxBytes: 10,
+	}
+	cw := &countingWriter{
+		w: fw,
+	}
+	if _, err := cw.Write([]byte("foo 1\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := cw.Write([]byte("bar 2\n")); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if _, err := cw.Write([]byte("\n")); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if cw.n != 6 {
+		t.Fatalf("unexpected number of written bytes; got %d; want %d", cw.n, 6)
+	}
+	if fw.writesAfterError != 0 {
+		t.Fatalf("unexpected number of writes after error; got %d; want %d", fw.writesAfterError, 0)
+	}
+	if err := getWriteError(cw); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if err := getWriteError(&fw.bb); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestSetWritePrometheusErr(t *testing.T) {
+	const gaugesCount = 10000
+	s := NewSet()
+	calls := 0
+	for i := 0; i < gaugesCount; i++ {
+		s.NewGauge(fmt.Sprintf("gauge_%d", i), func() float64 {
+			calls++
+			return 1
+		})
+	}
+
+	var bb bytes.Buffer
+	if err := s.WritePrometheusErr(&bb); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != gaugesCount {
+		t.Fatalf("unexpected number of gauge calls; got %d; want %d", calls, gaugesCount)
+	}
+
+	// Marshaling must stop on the first write error.
+	calls = 0
+	fw := &failingWriter{}
+	if err := s.WritePrometheusErr(fw); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if calls >= gaugesCount {
+		t.Fatalf("marshaling didn't stop on write error; got %d gauge calls", calls)
+	}
+	if fw.writesAfterError != 0 {
+		t.Fatalf("unexpected number of writes after error; got %d; want %d", fw.writesAfterError, 0)
+	}
+}
+
+func TestWritePrometheusErr(t *testing.T) {
+	var bb bytes.Buffer
+	if err := WritePrometheusErr(&bb, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bb.Len() == 0 {
+		t.Fatalf("expecting non-empty output")
+	}
+
+	fw := &failingWriter{}
+	if err := WritePrometheusErr(fw, true); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if fw.writesAfterError != 0 {
+		t.Fatalf("unexpected number of writes after error; got %d; want %d", fw.writesAfterError, 0)
+	}
+}
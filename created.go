@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ExposeCreatedSeries enables or disables `<name>_created` series in OpenMetrics output globally.
+//
+// The `_created` series contains the time in Unix seconds when the metric was registered. It is written after every
+// Counter, FloatCounter, Histogram, PrometheusHistogram and Summary with the same labels as the metric. The `_total` suffix
+// is dropped from counter names, so `requests_total{path="/foo"}` gets `requests_created{path="/foo"}` series.
+// Metrics obtained via GetOrCreate* functions are registered on the first call.
+//
+// See ExposeCreatedSeriesInText for writing `_created` series in Prometheus text exposition format
+// and SetUpdateCreatedOnReset for updating the creation time on counter resets.
+//
+// It is safe to call this function multiple times. It is allowed to change it in runtime.
+// `_created` series aren't exposed by default.
+func ExposeCreatedSeries(v bool) {
+	storeBool(&exposeCreatedSeries, v)
+}
+
+// ExposeCreatedSeriesInText enables or disables `<name>_created` series in Prometheus text exposition format globally.
+//
+// This applies to WritePrometheus, Set.WritePrometheus, Handler and all the InitPush* functions including
+// InitPushRemoteWrite, so the creation times are delivered to remote storage as usual samples.
+// See ExposeCreatedSeries for details.
+//
+// It is safe to call this function multiple times. It is allowed to change it in runtime.
+// `_created` series aren't exposed by default.
+func ExposeCreatedSeriesInText(v bool) {
+	storeBool(&exposeCreatedSeriesInText, v)
+}
+
+// SetUpdateCreatedOnReset enables or disables updating the creation time for `_created` series on resets.
+//
+// If enabled, the creation time is set to the current time when Counter or FloatCounter is set to zero
+// via Set, Swap or GetAndReset, when Histogram.Reset is called and when the metrics are reset via ResetAllMetrics.
+// This allows detecting resets by the creation time. The creation time isn't updated on resets by default.
+func SetUpdateCreatedOnReset(v bool) {
+	storeBool(&updateCreatedOnReset, v)
+}
+
+var (
+	exposeCreatedSeries       uint32
+	exposeCreatedSeriesInText uint32
+	updateCreatedOnReset      uint32
+)
+
+func storeBool(p *uint32, v bool) {
+	n := uint32(0)
+	if v {
+		n = 1
+	}
+	atomic.StoreUint32(p, n)
+}
+
+func isCreatedSeriesEnabled() bool {
+	return atomic.LoadUint32(&exposeCreatedSeries) != 0
+}
+
+func isCreatedSeriesInTextEnabled() bool {
+	return atomic.LoadUint32(&exposeCreatedSeriesInText) != 0
+}
+
+// createdTimestamp holds the creation time of the metric for `_created` series.
+//
+// Zero createdTimestamp is usable. It means the metric isn't registered yet, so `_created` series isn't written for it.
+type createdTimestamp struct {
+	// ms contains the creation time in milliseconds since Unix epoch.
+	ms atomicInt64
+}
+
+// init sets the creation time to t if it isn't set yet.
+func (ct *createdTimestamp) init(t time.Time) {
+	if ct.ms.Load() == 0 {
+		ct.ms.Store(t.UnixNano() / 1e6)
+	}
+}
+
+// onReset updates the creation time to the current time if it is enabled via SetUpdateCreatedOnReset.
+func (ct *createdTimestamp) onReset() {
+	if atomic.LoadUint32(&updateCreatedOnReset) == 0 || ct.ms.Load() == 0 {
+		return
+	}
+	ct.ms.Store(clockNow().UnixNano() / 1e6)
+}
+
+func (ct *createdTimestamp) load() int64 {
+	return ct.ms.Load()
+}
+
+// createdMetric must be implemented by metrics, which expose `_created` series.
+type createdMetric interface {
+	getCreatedTimestamp() *createdTimestamp
+}
+
+// initCreatedTimestamp sets the creation time for the registered metric nm.
+func initCreatedTimestamp(nm *namedMetric) {
+	if nm.isAux {
+		return
+	}
+	if cm, ok := nm.metric.(createdMetric); ok {
+		cm.getCreatedTimestamp().init(clockNow())
+	}
+}
+
+// appendCreatedSeries appends `_created` series for nm to dst in Prometheus text exposition format.
+//
+// Nothing is appended if nm doesn't support `_created` series.
+func appendCreatedSeries(dst []byte, nm *namedMetric) []byte {
+	if nm.isAux {
+		return dst
+	}
+	cm, ok := nm.metric.(createdMetric)
+	if !ok {
+		return dst
+	}
+	ms := cm.getCreatedTimestamp().load()
+	if ms == 0 {
+		return dst
+	}
+	metricName, labels := splitMetricName(nm.name)
+	dst = append(dst, getCreatedSeriesName(metricName)...)
+	dst = append(dst, labels...)
+	dst = append(dst, ' ')
+	dst = strconv.AppendFloat(dst, float64(ms)/1e3, 'f', -1, 64)
+	return append(dst, '\n')
+}
+
+// getCreatedSeriesName returns the name of `_created` series for the metric with the given base name.
+func getCreatedSeriesName(metricName string) string {
+	return strings.TrimSuffix(metricName, "_total") + "_created"
+}
+
+func (c *Counter) getCreatedTimestamp() *createdTimestamp {
+	return &c.created
+}
+
+func (fc *FloatCounter) getCreatedTimestamp() *createdTimestamp {
+	return &fc.created
+}
+
+func (h *Histogram) getCreatedTimestamp() *createdTimestamp {
+	return &h.created
+}
+
+func (ph *PrometheusHistogram) getCreatedTimestamp() *createdTimestamp {
+	return &ph.created
+}
+
+func (sm *Summary) getCreatedTimestamp() *createdTimestamp {
+	return &sm.created
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestExposeCreatedSeries(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1700000000, 123e6))
+	SetFakeClockForTesting(fc)
+	defer SetFakeClockForTesting(nil)
+	ExposeCreatedSeries(true)
+	defer ExposeCreatedSeries(false)
+
+	s := NewSet()
+	s.NewCounter(`requests_total{path="/foo"}`).Inc()
+	s.GetOrCreateFloatCounter("cpu_seconds_total").Add(1.5)
+	s.NewGauge("queue_size", nil).Set(2)
+	fc.Advance(time.Second)
+	s.GetOrCreateHistogramWithBuckets("duration_seconds", []float64{1}).Update(0.5)
+	sm := s.NewSummaryExt(`response_size_bytes{path="/foo"}`, defaultSummaryWindow, []float64{1})
+	sm.Update(3)
+
+	var bb bytes.Buffer
+	s.WriteOpenMetrics(&bb)
+	result := bb.String()
+	resultExpected := `# TYPE cpu_seconds counter
+cpu_seconds_total 1.5
+cpu_seconds_created 1700000000.123
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="1"} 1
+duration_seconds_bucket{le="+Inf"} 1
+duration_seconds_sum 0.5
+duration_seconds_count 1
+duration_seconds_created 1700000001.123
+# TYPE queue_size gauge
+queue_size 2
+# TYPE requests counter
+requests_total{path="/foo"} 1
+requests_created{path="/foo"} 1700000000.123
+# TYPE response_size_bytes summary
+response_size_bytes{path="/foo",quantile="1"} 3
+response_size_bytes_sum{path="/foo"} 3
+response_size_bytes_count{path="/foo"} 1
+response_size_bytes_created{path="/foo"} 1700000001.123
+# EOF
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected OpenMetrics output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// `_created` series aren't written in Prometheus text exposition format by default.
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	result = bb.String()
+	resultExpected = `cpu_seconds_total 1.5
+duration_seconds_bucket{le="1"} 1
+duration_seconds_bucket{le="+Inf"} 1
+duration_seconds_sum 0.5
+duration_seconds_count 1
+queue_size 2
+requests_total{path="/foo"} 1
+response_size_bytes{path="/foo",quantile="1"} 3
+response_size_bytes_sum{path="/foo"} 3
+response_size_bytes_count{path="/foo"} 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected Prometheus output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestExposeCreatedSeriesInText(t *testing.T) {
+	fc := NewFakeClock(time.Unix(1700000000, 0))
+	SetFakeClockForTesting(fc)
+	defer SetFakeClockForTesting(nil)
+	ExposeCreatedSeriesInText(true)
+	defer ExposeCreatedSeriesInText(false)
+
+	s := NewSet()
+	c := s.NewCounter(`requests_total{path="/foo"}`)
+	c.Inc()
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f(`requests_total{path="/foo"} 1
+requests_created{path="/foo"} 1700000000
+`)
+
+	// `_created` series are pushed via remote_write as usual samples.
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	data, err := marshalRemoteWriteRequest(nil, bb.Bytes(), 1234)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result := unmarshalRemoteWriteRequestForTest(t, data)
+	resultExpected := `{__name__="requests_total",path="/foo"} 1 1234
+{__name__="requests_created",path="/foo"} 1.7e+09 1234
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected remote_write request;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// The creation time isn't updated on reset by default.
+	fc.Advance(time.Minute)
+	c.Set(0)
+	f(`requests_total{path="/foo"} 0
+requests_created{path="/foo"} 1700000000
+`)
+
+	// The creation time is updated on reset if SetUpdateCreatedOnReset is enabled.
+	SetUpdateCreatedOnReset(true)
+	defer SetUpdateCreatedOnReset(false)
+	c.Add(5)
+	c.Set(3)
+	f(`requests_total{path="/foo"} 3
+requests_created{path="/foo"} 1700000000
+`)
+	c.GetAndReset()
+	f(`requests_total{path="/foo"} 0
+requests_created{path="/foo"} 1700000060
+`)
+
+	// `_created` series are renamed together with the counter.
+	defer func() {
+		_ = SetWriteRelabelConfig(nil)
+	}()
+	err = SetWriteRelabelConfig([]RelabelRule{{
+		Match:   "requests_total",
+		Action:  RelabelRename,
+		NewName: "http_requests_total",
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(`http_requests_total{path="/foo"} 0
+http_requests_created{path="/foo"} 1700000060
+`)
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+)
+
+// RegisterMetric registers custom metric m with the given name in the default set.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// m.MarshalTo is called with the full metric name as prefix every time the metric is written to the output,
+// e.g. by WritePrometheus or by InitPush. The metric is exposed with `untyped` type in metadata
+// unless m has `MetricType() string` method, which returns the type such as `counter` or `gauge`.
+//
+// The registered metric may be obtained via GetMetric and unregistered via UnregisterMetric.
+//
+// It panics if name is invalid or if a metric with the given name is already registered.
+// Use TryRegisterMetric for obtaining an error instead of panic.
+func RegisterMetric(name string, m Metric) {
+	defaultSet.RegisterMetric(name, m)
+}
+
+// RegisterMetric registers custom metric m with the given name in s.
+//
+// See RegisterMetric for details.
+func (s *Set) RegisterMetric(name string, m Metric) {
+	if err := s.TryRegisterMetric(name, m); err != nil {
+		panic(fmt.Errorf("BUG: %w", err))
+	}
+}
+
+// customMetric adapts Metric implemented outside the package to the internal metric interface.
+type customMetric struct {
+	m Metric
+}
+
+// newMetric returns m adapted to the internal metric interface.
+func newMetric(m Metric) metric {
+	if mi, ok := m.(metric); ok {
+		return mi
+	}
+	return &customMetric{
+		m: m,
+	}
+}
+
+// exportMetric returns Metric for m.
+//
+// It returns the original metric passed to RegisterMetric for custom metrics.
+func exportMetric(m metric) Metric {
+	if cm, ok := m.(*customMetric); ok {
+		return cm.m
+	}
+	return m.(Metric)
+}
+
+func (cm *customMetric) marshalTo(prefix string, w io.Writer) {
+	cm.m.MarshalTo(prefix, w)
+}
+
+func (cm *customMetric) metricType() string {
+	if mt, ok := cm.m.(interface{ MetricType() string }); ok {
+		return mt.MetricType()
+	}
+	return "untyped"
+}
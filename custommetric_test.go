@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type testCustomMetric struct {
+	v int
+}
+
+func (tm *testCustomMetric) MarshalTo(prefix string, w io.Writer) {
+	fmt.Fprintf(w, "%s %d\n", prefix, tm.v)
+}
+
+type testCustomCounter struct {
+	testCustomMetric
+}
+
+func (tc *testCustomCounter) MetricType() string {
+	return "counter"
+}
+
+func TestRegisterMetric(t *testing.T) {
+	s := NewSet()
+	tm := &testCustomMetric{
+		v: 42,
+	}
+	s.RegisterMetric(`custom_metric{foo="bar"}`, tm)
+	s.NewCounter("native_counter").Add(3)
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `custom_metric{foo="bar"} 42
+native_counter 3
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	m, ok := s.GetMetric(`custom_metric{foo="bar"}`)
+	if !ok {
+		t.Fatalf("cannot find custom metric")
+	}
+	if m != Metric(tm) {
+		t.Fatalf("unexpected metric returned; got %T; want %T", m, tm)
+	}
+
+	if !s.UnregisterMetric(`custom_metric{foo="bar"}`) {
+		t.Fatalf("cannot unregister custom metric")
+	}
+	if _, ok := s.GetMetric(`custom_metric{foo="bar"}`); ok {
+		t.Fatalf("custom metric must be missing after unregistering")
+	}
+}
+
+func TestRegisterMetricFailure(t *testing.T) {
+	s := NewSet()
+	s.RegisterMetric("custom_metric", &testCustomMetric{})
+
+	f := func(name string, m Metric) {
+		t.Helper()
+		if err := s.TryRegisterMetric(name, m); err == nil {
+			t.Fatalf("expecting non-nil error when registering %q", name)
+		}
+	}
+	f("custom_metric", &testCustomMetric{})
+	f("custom_metric", &testCustomCounter{})
+	f("custom_metric", &Counter{})
+	f("invalid{", &testCustomMetric{})
+	f("", &testCustomMetric{})
+	f("foo", nil)
+
+	expectPanic(t, "RegisterMetric(duplicate)", func() {
+		s.RegisterMetric("custom_metric", &testCustomMetric{})
+	})
+	expectPanic(t, "RegisterMetric(invalid)", func() {
+		s.RegisterMetric("invalid{", &testCustomMetric{})
+	})
+}
+
+func TestCustomMetricType(t *testing.T) {
+	f := func(m Metric, typeExpected string) {
+		t.Helper()
+		if typ := newMetric(m).metricType(); typ != typeExpected {
+			t.Fatalf("unexpected metric type; got %q; want %q", typ, typeExpected)
+		}
+	}
+	f(&testCustomMetric{}, "untyped")
+	f(&testCustomCounter{}, "counter")
+	f(&Counter{}, "counter")
+	f(&Gauge{}, "gauge")
+}
+
+func TestCustomMetricMetadata(t *testing.T) {
+	s := NewSet()
+	s.RegisterMetric("custom_total", &testCustomCounter{
+		testCustomMetric: testCustomMetric{
+			v: 5,
+		},
+	})
+	s.SetMetricHelp("custom_total", "Custom counter")
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `# HELP custom_total Custom counter
+# TYPE custom_total counter
+custom_total 5
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
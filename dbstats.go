@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// RegisterDBStats registers gauges with connection pool stats for db in the default set.
+//
+// The following metrics with `db_name` label set to dbName are registered:
+//
+//   - go_sql_open_connections - the number of established connections
+//   - go_sql_in_use_connections - the number of connections currently in use
+//   - go_sql_idle_connections - the number of idle connections
+//   - go_sql_wait_count_total - the total number of connections waited for
+//   - go_sql_wait_duration_seconds_total - the total time blocked waiting for a new connection
+//   - go_sql_max_idle_closed_total - the total number of connections closed due to sql.DB.SetMaxIdleConns
+//   - go_sql_max_lifetime_closed_total - the total number of connections closed due to sql.DB.SetConnMaxLifetime
+//
+// The stats are obtained via db.Stats() when the metrics are written.
+//
+// An error is returned if stats with the given dbName are already registered.
+// Call UnregisterDBStats for unregistering the registered metrics.
+func RegisterDBStats(db *sql.DB, dbName string) error {
+	return defaultSet.RegisterDBStats(db, dbName)
+}
+
+// UnregisterDBStats unregisters metrics registered via RegisterDBStats with the given dbName from the default set.
+//
+// It returns true if the metrics have been unregistered.
+func UnregisterDBStats(dbName string) bool {
+	return defaultSet.UnregisterDBStats(dbName)
+}
+
+// RegisterDBStats registers gauges with connection pool stats for db in s.
+//
+// See RegisterDBStats for details.
+func (s *Set) RegisterDBStats(db *sql.DB, dbName string) error {
+	if db == nil {
+		return fmt.Errorf("db cannot be nil")
+	}
+	labels := map[string]string{
+		"db_name": dbName,
+	}
+	type dbStatsGauge struct {
+		name string
+		help string
+		f    func(stats *sql.DBStats) float64
+	}
+	gauges := []dbStatsGauge{
+		{
+			name: "go_sql_open_connections",
+			help: "The number of established connections both in use and idle",
+			f: func(stats *sql.DBStats) float64 {
+				return float64(stats.OpenConnections)
+			},
+		},
+		{
+			name: "go_sql_in_use_connections",
+			help: "The number of connections currently in use",
+			f: func(stats *sql.DBStats) float64 {
+				return float64(stats.InUse)
+			},
+		},
+		{
+			name: "go_sql_idle_connections",
+			help: "The number of idle connections",
+			f: func(stats *sql.DBStats) float64 {
+				return float64(stats.Idle)
+			},
+		},
+		{
+			name: "go_sql_wait_count_total",
+			help: "The total number of connections waited for",
+			f: func(stats *sql.DBStats) float64 {
+				return float64(stats.WaitCount)
+			},
+		},
+		{
+			name: "go_sql_wait_duration_seconds_total",
+			help: "The total time blocked waiting for a new connection",
+			f: func(stats *sql.DBStats) float64 {
+				return stats.WaitDuration.Seconds()
+			},
+		},
+		{
+			name: "go_sql_max_idle_closed_total",
+			help: "The total number of connections closed due to SetMaxIdleConns",
+			f: func(stats *sql.DBStats) float64 {
+				return float64(stats.MaxIdleClosed)
+			},
+		},
+		{
+			name: "go_sql_max_lifetime_closed_total",
+			help: "The total number of connections closed due to SetConnMaxLifetime",
+			f: func(stats *sql.DBStats) float64 {
+				return float64(stats.MaxLifetimeClosed)
+			},
+		},
+	}
+	names := make([]string, len(gauges))
+	for i, g := range gauges {
+		names[i] = s.mustNormalizeMetricName(BuildName(g.name, labels))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dbStats[dbName]; ok {
+		return fmt.Errorf("db stats with db_name=%q are already registered", dbName)
+	}
+	// Verify all the names before registering any of the gauges, so the registration is atomic.
+	for _, name := range names {
+		if _, ok := s.m[name]; ok {
+			return fmt.Errorf("metric %q is already registered", name)
+		}
+	}
+	for i, g := range gauges {
+		f := g.f
+		gauge := &Gauge{
+			f: func() float64 {
+				stats := db.Stats()
+				return f(&stats)
+			},
+		}
+		s.mustRegisterLocked(names[i], gauge, false)
+		s.m[names[i]].help = g.help
+	}
+	if s.dbStats == nil {
+		s.dbStats = make(map[string][]string)
+	}
+	s.dbStats[dbName] = names
+	return nil
+}
+
+// UnregisterDBStats unregisters metrics registered via RegisterDBStats with the given dbName from s.
+//
+// It returns true if the metrics have been unregistered.
+func (s *Set) UnregisterDBStats(dbName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, ok := s.dbStats[dbName]
+	if !ok {
+		return false
+	}
+	delete(s.dbStats, dbName)
+	for _, name := range names {
+		if nm := s.m[name]; nm != nil {
+			s.unregisterMetricLocked(nm)
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSetRegisterDBStats(t *testing.T) {
+	s := NewSet()
+	db1 := sql.OpenDB(fakeConnector{})
+	defer db1.Close()
+	db2 := sql.OpenDB(fakeConnector{})
+	defer db2.Close()
+
+	if err := s.RegisterDBStats(db1, "users"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.RegisterDBStats(db2, "orders"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Registering the same name twice must fail.
+	if err := s.RegisterDBStats(db2, "users"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if err := s.RegisterDBStats(nil, "foo"); err == nil {
+		t.Fatalf("expecting non-nil error for nil db")
+	}
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	for _, dbName := range []string{"orders", "users"} {
+		for _, name := range []string{
+			"go_sql_open_connections",
+			"go_sql_in_use_connections",
+			"go_sql_idle_connections",
+			"go_sql_wait_count_total",
+			"go_sql_wait_duration_seconds_total",
+			"go_sql_max_idle_closed_total",
+			"go_sql_max_lifetime_closed_total",
+		} {
+			line := fmt.Sprintf("%s{db_name=%q} 0\n", name, dbName)
+			if !strings.Contains(result, line) {
+				t.Fatalf("missing %q in the output\n%s", line, result)
+			}
+		}
+	}
+
+	if !s.UnregisterDBStats("users") {
+		t.Fatalf("cannot unregister db stats")
+	}
+	if s.UnregisterDBStats("users") {
+		t.Fatalf("unexpected unregistering of missing db stats")
+	}
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	result = bb.String()
+	if strings.Contains(result, `db_name="users"`) {
+		t.Fatalf("unexpected metrics for unregistered db stats\n%s", result)
+	}
+	if n := strings.Count(result, `{db_name="orders"} 0`); n != 7 {
+		t.Fatalf("unexpected number of metrics; got %d; want 7\n%s", n, result)
+	}
+
+	// The name may be registered again after unregistering.
+	if err := s.RegisterDBStats(db1, "users"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestSetRegisterDBStatsConflict(t *testing.T) {
+	s := NewSet()
+	db := sql.OpenDB(fakeConnector{})
+	defer db.Close()
+
+	s.NewCounter(`go_sql_idle_connections{db_name="foo"}`)
+	if err := s.RegisterDBStats(db, "foo"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	// Failed registration mustn't leave partially registered metrics.
+	names := s.ListMetricNames()
+	if len(names) != 1 {
+		t.Fatalf("unexpected metrics after failed registration: %q", names)
+	}
+}
+
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return nil, fmt.Errorf("fake connector doesn't support connections")
+}
+
+func (fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(_ string) (driver.Conn, error) {
+	return nil, fmt.Errorf("fake driver doesn't support connections")
+}
@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// SetStrictDuplicateSeries enables or disables strict mode for duplicate series detection.
+//
+// Series with the same names from multiple sets and external sources are written only once by default -
+// the first occurrence is kept, while the later occurrences are skipped and counted by metrics_duplicate_series_total counter.
+// If v is true, then writing stops on the first duplicate series instead, and the error with the series name
+// is logged and is returned from WritePrometheusErr and Set.WritePrometheusErr.
+//
+// Strict mode is intended for integration tests, which must catch duplicate series before they break scrapes in production,
+// since Prometheus rejects the whole scrape with duplicate series. It is disabled by default.
+func SetStrictDuplicateSeries(v bool) {
+	storeBool(&strictDuplicateSeries, v)
+}
+
+var strictDuplicateSeries uint32
+
+func isStrictDuplicateSeriesEnabled() bool {
+	return atomic.LoadUint32(&strictDuplicateSeries) != 0
+}
+
+// GetDuplicateSeries returns sorted names of duplicate series detected since the process start.
+//
+// Use it for finding the offenders when metrics_duplicate_series_total counter is non-zero.
+// Up to 1000 first distinct names are remembered.
+func GetDuplicateSeries() []string {
+	duplicateSeriesLock.Lock()
+	a := make([]string, 0, len(duplicateSeries))
+	for name := range duplicateSeries {
+		a = append(a, name)
+	}
+	duplicateSeriesLock.Unlock()
+
+	sort.Strings(a)
+	return a
+}
+
+var (
+	duplicateSeries     = make(map[string]struct{})
+	duplicateSeriesLock sync.Mutex
+)
+
+const maxDuplicateSeries = 1000
+
+// duplicateSource is the source of duplicate series.
+//
+// Duplicate series are counted per source in metrics_duplicate_series_total{source="..."} counter.
+type duplicateSource int
+
+const (
+	duplicateSourceSet duplicateSource = iota
+	duplicateSourceExternal
+	duplicateSourceAggregator
+
+	duplicateSourcesCount
+)
+
+var duplicateSourceNames = [duplicateSourcesCount]string{
+	duplicateSourceSet:        "set",
+	duplicateSourceExternal:   "external_source",
+	duplicateSourceAggregator: "aggregator",
+}
+
+func (ds duplicateSource) String() string {
+	return duplicateSourceNames[ds]
+}
+
+// duplicateSeriesTotals contains the number of duplicate series dropped from the output per source.
+var duplicateSeriesTotals [duplicateSourcesCount]uint64
+
+const duplicateSeriesTotalName = "metrics_duplicate_series_total"
+
+// recordDuplicateSeries counts the duplicate series with the given name from the given source and remembers its name for GetDuplicateSeries.
+func recordDuplicateSeries(source duplicateSource, name string) {
+	atomic.AddUint64(&duplicateSeriesTotals[source], 1)
+
+	duplicateSeriesLock.Lock()
+	if _, ok := duplicateSeries[name]; !ok && len(duplicateSeries) < maxDuplicateSeries {
+		duplicateSeries[name] = struct{}{}
+	}
+	duplicateSeriesLock.Unlock()
+}
+
+// writeDuplicateSeriesTotals writes metrics_duplicate_series_total counters with non-zero values to w if they match mf.
+func writeDuplicateSeriesTotals(w io.Writer, mf *metricNameFilter) {
+	if !mf.match(duplicateSeriesTotalName) {
+		return
+	}
+	metadataWritten := false
+	for source := duplicateSource(0); source < duplicateSourcesCount; source++ {
+		n := atomic.LoadUint64(&duplicateSeriesTotals[source])
+		if n == 0 {
+			continue
+		}
+		if !metadataWritten {
+			WriteMetadataIfNeeded(w, duplicateSeriesTotalName, "counter")
+			metadataWritten = true
+		}
+		fmt.Fprintf(w, "%s{source=%q} %d\n", duplicateSeriesTotalName, source, n)
+	}
+}
+
+// duplicateDetector detects duplicate series written to a single output.
+//
+// Use getDuplicateDetector for obtaining duplicateDetector, so the hash set of seen series is reused across writes.
+type duplicateDetector struct {
+	// seen contains canonical names of the series written so far. See seriesNameCanonicalizer.
+	seen map[string]struct{}
+
+	// snc is used for obtaining canonical series names for seen.
+	snc seriesNameCanonicalizer
+
+	// err is the error for the first duplicate series if strict mode is enabled via SetStrictDuplicateSeries.
+	err error
+}
+
+// isDuplicate returns true if the series with the given name from the given source has been already written.
+//
+// Series with the same labels in distinct order are considered duplicates.
+// Otherwise the name is remembered and false is returned. Duplicate series are recorded for GetDuplicateSeries.
+func (dd *duplicateDetector) isDuplicate(source duplicateSource, name string) bool {
+	key := dd.snc.canonicalName(name)
+	if _, ok := dd.seen[key]; !ok {
+		dd.seen[key] = struct{}{}
+		return false
+	}
+	dd.addDuplicate(source, name)
+	return true
+}
+
+// addDuplicate records the duplicate series with the given name from the given source.
+//
+// It sets dd.err if strict mode is enabled via SetStrictDuplicateSeries.
+func (dd *duplicateDetector) addDuplicate(source duplicateSource, name string) {
+	recordDuplicateSeries(source, name)
+	if dd.err == nil && isStrictDuplicateSeriesEnabled() {
+		dd.err = fmt.Errorf("duplicate series %s from %s", name, source)
+	}
+}
+
+func getDuplicateDetector() *duplicateDetector {
+	v := duplicateDetectorPool.Get()
+	if v == nil {
+		return &duplicateDetector{
+			seen: make(map[string]struct{}),
+		}
+	}
+	return v.(*duplicateDetector)
+}
+
+func putDuplicateDetector(dd *duplicateDetector) {
+	// The loop is optimized by the compiler into clearing the map, so its memory is reused.
+	for name := range dd.seen {
+		delete(dd.seen, name)
+	}
+	dd.err = nil
+	duplicateDetectorPool.Put(dd)
+}
+
+var duplicateDetectorPool sync.Pool
+
+// seriesNameCanonicalizer returns canonical series names with labels sorted by label names.
+//
+// It reuses the buffers across calls, so it must be used by a single goroutine.
+type seriesNameCanonicalizer struct {
+	labels []label
+	pairs  []string
+	buf    []byte
+}
+
+// canonicalName returns name with labels sorted by label names.
+//
+// name is returned as is without memory allocations if its labels are already sorted or if it cannot be parsed.
+func (snc *seriesNameCanonicalizer) canonicalName(name string) string {
+	n := strings.IndexByte(name, '{')
+	if n <= 0 {
+		// Series without labels or with quoted metric name inside curly braces.
+		return name
+	}
+	labels, tail, err := parseLabels(snc.labels[:0], name[n+1:])
+	snc.labels = labels
+	if err != nil || tail != "" || areLabelsSorted(labels) {
+		return name
+	}
+	pairs := snc.pairs[:0]
+	for _, l := range labels {
+		pairs = append(pairs, l.name, l.value)
+	}
+	sortLabelPairs(pairs)
+	snc.pairs = pairs
+	snc.buf = appendSortedMetricName(snc.buf[:0], name[:n], pairs)
+	return string(snc.buf)
+}
+
+func areLabelsSorted(labels []label) bool {
+	for i := 1; i < len(labels); i++ {
+		if labels[i].name < labels[i-1].name {
+			return false
+		}
+	}
+	return true
+}
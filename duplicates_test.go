@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDuplicateSeriesExternalSource(t *testing.T) {
+	s1 := NewSet()
+	s1.NewCounter(`dup_ext_requests_total{path="/a"}`).Inc()
+	s2 := NewSet()
+	s2.RegisterExternalSource("ext", func() (io.Reader, error) {
+		return strings.NewReader(`dup_ext_requests_total{path="/a"} 10
+dup_ext_requests_total{path="/b"} 20
+dup_ext_requests_total{path="/b"} 30
+`), nil
+	})
+
+	f := func(sort bool) {
+		t.Helper()
+		SetSortMetricsOnWrite(sort)
+		defer SetSortMetricsOnWrite(false)
+
+		duplicatesPrev := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceExternal])
+		var bb bytes.Buffer
+		WriteSets(&bb, s1, s2)
+		if n := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceExternal]) - duplicatesPrev; n != 2 {
+			t.Fatalf("unexpected number of duplicate series; got %d; want %d", n, 2)
+		}
+		resultExpected := `dup_ext_requests_total{path="/a"} 1
+dup_ext_requests_total{path="/b"} 20
+`
+		if result := bb.String(); !strings.HasPrefix(result, resultExpected) {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+		if !strings.Contains(bb.String(), `metrics_duplicate_series_total{source="external_source"} `) {
+			t.Fatalf("missing duplicate series counter for external source in the output\n%s", bb.String())
+		}
+	}
+	f(false)
+	f(true)
+
+	duplicates := GetDuplicateSeries()
+	for _, name := range []string{`dup_ext_requests_total{path="/a"}`, `dup_ext_requests_total{path="/b"}`} {
+		if !containsString(duplicates, name) {
+			t.Fatalf("missing %s in duplicate series %q", name, duplicates)
+		}
+	}
+}
+
+func TestDuplicateSeriesStrict(t *testing.T) {
+	SetStrictDuplicateSeries(true)
+	defer SetStrictDuplicateSeries(false)
+
+	s1 := NewSet()
+	s1.NewCounter("dup_strict_a_total").Inc()
+	s1.NewCounter("dup_strict_b_total").Inc()
+	s2 := NewSet()
+	s2.NewCounter("dup_strict_b_total").Inc()
+	s2.NewCounter("dup_strict_c_total").Inc()
+
+	f := func(sort bool) {
+		t.Helper()
+		SetSortMetricsOnWrite(sort)
+		defer SetSortMetricsOnWrite(false)
+
+		var bb bytes.Buffer
+		cw := &countingWriter{
+			w: &bb,
+		}
+		WriteSets(cw, s1, s2)
+		if cw.err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if !strings.Contains(cw.err.Error(), "dup_strict_b_total") {
+			t.Fatalf("missing duplicate series name in the error: %s", cw.err)
+		}
+		if strings.Contains(bb.String(), "dup_strict_c_total") {
+			t.Fatalf("unexpected series after the duplicate series in the output\n%s", bb.String())
+		}
+	}
+	f(false)
+	f(true)
+
+	// The duplicate of the registered metric in external source
+	s := NewSet()
+	s.NewGauge("dup_strict_gauge", func() float64 { return 1 })
+	s.RegisterExternalSource("ext", func() (io.Reader, error) {
+		return strings.NewReader("dup_strict_gauge 2\n"), nil
+	})
+	var bb bytes.Buffer
+	if err := s.WritePrometheusErr(&bb); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	// Sets without duplicates are written without errors
+	bb.Reset()
+	if err := writeSetsErr(&bb, s1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestDuplicateSeriesLabelsOrder(t *testing.T) {
+	s1 := NewSet()
+	s1.NewCounter(`dup_order_requests_total{path="/a",code="200"}`).Inc()
+	s2 := NewSet()
+	s2.NewCounter(`dup_order_requests_total{code="200",path="/a"}`).Add(2)
+
+	f := func(sort, strict bool) {
+		t.Helper()
+		SetSortMetricsOnWrite(sort)
+		defer SetSortMetricsOnWrite(false)
+		SetStrictDuplicateSeries(strict)
+		defer SetStrictDuplicateSeries(false)
+
+		duplicatesPrev := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceSet])
+		var bb bytes.Buffer
+		err := writeSetsErr(&bb, s1, s2)
+		if n := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceSet]) - duplicatesPrev; n != 1 {
+			t.Fatalf("unexpected number of duplicate series; got %d; want %d", n, 1)
+		}
+		if strict {
+			if err == nil {
+				t.Fatalf("expecting non-nil error")
+			}
+			if !strings.Contains(err.Error(), "dup_order_requests_total") {
+				t.Fatalf("missing duplicate series name in the error: %s", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n := strings.Count(bb.String(), "dup_order_requests_total{"); n != 1 {
+			t.Fatalf("unexpected number of dup_order_requests_total series; got %d; want %d\n%s", n, 1, bb.String())
+		}
+	}
+	f(false, false)
+	f(true, false)
+	f(false, true)
+	f(true, true)
+
+	// The external source series with distinct labels order than the registered metric
+	s := NewSet()
+	s.GetOrCreateCounter(BuildName("dup_order_ext_total", map[string]string{
+		"path": "/a",
+		"code": "200",
+	})).Inc()
+	s.RegisterExternalSource("ext", func() (io.Reader, error) {
+		return strings.NewReader(`dup_order_ext_total{path="/a",code="200"} 10` + "\n"), nil
+	})
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	if n := strings.Count(bb.String(), "dup_order_ext_total{"); n != 1 {
+		t.Fatalf("unexpected number of dup_order_ext_total series; got %d; want %d\n%s", n, 1, bb.String())
+	}
+}
+
+func TestSeriesNameCanonicalizer(t *testing.T) {
+	var snc seriesNameCanonicalizer
+	f := func(name, resultExpected string) {
+		t.Helper()
+		result := snc.canonicalName(name)
+		if result != resultExpected {
+			t.Fatalf("unexpected canonical name for %s; got %s; want %s", name, result, resultExpected)
+		}
+	}
+	f(`foo`, `foo`)
+	f(`foo{}`, `foo{}`)
+	f(`foo{a="1"}`, `foo{a="1"}`)
+	f(`foo{a="1",b="2"}`, `foo{a="1",b="2"}`)
+	f(`foo{b="2",a="1"}`, `foo{a="1",b="2"}`)
+	f(`foo{c="3", b="x\"y", a="1"}`, `foo{a="1",b="x\"y",c="3"}`)
+	f(`{"foo.bar",b="2",a="1"}`, `{"foo.bar",b="2",a="1"}`)
+	f(`foo{b="2",a="1"`, `foo{b="2",a="1"`)
+}
+
+func TestDuplicateDetectorReuse(t *testing.T) {
+	dd := getDuplicateDetector()
+	if dd.isDuplicate(duplicateSourceSet, "dup_reuse") {
+		t.Fatalf("unexpected duplicate for the first series")
+	}
+	if !dd.isDuplicate(duplicateSourceSet, "dup_reuse") {
+		t.Fatalf("expecting duplicate for the second series")
+	}
+	putDuplicateDetector(dd)
+
+	dd = getDuplicateDetector()
+	defer putDuplicateDetector(dd)
+	if len(dd.seen) != 0 {
+		t.Fatalf("unexpected seen series after the reset: %d", len(dd.seen))
+	}
+}
+
+func writeSetsErr(w io.Writer, sets ...*Set) error {
+	cw := &countingWriter{
+		w: w,
+	}
+	WriteSets(cw, sets...)
+	return cw.err
+}
+
+func containsString(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// maxExemplarLabelsRunes is the maximum combined length of exemplar label names and values in runes.
+//
+// See https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md#exemplars
+const maxExemplarLabelsRunes = 128
+
+// exemplar is a sample with labels, which refers to external data such as a trace.
+//
+// Exemplars are exposed only in OpenMetrics text format, since Prometheus text format doesn't support them.
+type exemplar struct {
+	// labels contains marshaled labels in the form `{name1="value1",...,nameN="valueN"}`.
+	labels string
+
+	value     float64
+	timestamp time.Time
+}
+
+// newExemplar returns exemplar with the given value and labels at the current time.
+func newExemplar(v float64, labels map[string]string) (*exemplar, error) {
+	s, err := marshalExemplarLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	return &exemplar{
+		labels:    s,
+		value:     v,
+		timestamp: time.Now(),
+	}, nil
+}
+
+// marshalExemplarLabels validates labels and returns them in the form `{name1="value1",...,nameN="valueN"}` sorted by name.
+func marshalExemplarLabels(labels map[string]string) (string, error) {
+	names := make([]string, 0, len(labels))
+	runes := 0
+	for name, value := range labels {
+		if !exemplarLabelNameRegexp.MatchString(name) {
+			return "", fmt.Errorf("invalid exemplar label name %q", name)
+		}
+		if !utf8.ValidString(value) {
+			return "", fmt.Errorf("exemplar label %q value must be valid UTF-8 string; got %q", name, value)
+		}
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+		names = append(names, name)
+	}
+	if runes > maxExemplarLabelsRunes {
+		return "", fmt.Errorf("exemplar labels may contain up to %d runes in label names and values; got %d runes", maxExemplarLabelsRunes, runes)
+	}
+	sort.Strings(names)
+
+	b := []byte{'{'}
+	for i, name := range names {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, name...)
+		b = append(b, `="`...)
+		b = appendEscapedLabelValue(b, labels[name])
+		b = append(b, '"')
+	}
+	b = append(b, '}')
+	return string(b), nil
+}
+
+var exemplarLabelNameRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// writeOpenMetricsSample writes OpenMetrics sample line with the given name and value to w.
+//
+// The timestamp from mt is appended after the value if mt is non-nil and contains the timestamp.
+// The exemplar is appended to the line in the form ` # {labels} value timestamp` if e is non-nil.
+func writeOpenMetricsSample(w io.Writer, name, value string, mt *metricTimestamp, e *exemplar) {
+	bb := getBytesBuffer()
+	b := append(bb.B[:0], name...)
+	b = append(b, ' ')
+	b = append(b, value...)
+	if mt != nil {
+		b = mt.appendOpenMetrics(b)
+	}
+	if e != nil {
+		b = append(b, " # "...)
+		b = append(b, e.labels...)
+		b = append(b, ' ')
+		b = strconv.AppendFloat(b, e.value, 'g', -1, 64)
+		b = append(b, ' ')
+		b = strconv.AppendFloat(b, float64(e.timestamp.UnixNano()/1e6)/1e3, 'f', -1, 64)
+	}
+	b = append(b, '\n')
+	w.Write(b)
+	bb.B = b
+	putBytesBuffer(bb)
+}
+
+// exemplarHolder holds the most recent exemplar.
+//
+// Zero exemplarHolder is usable.
+type exemplarHolder struct {
+	v atomic.Value
+}
+
+func (eh *exemplarHolder) load() *exemplar {
+	e, _ := eh.v.Load().(*exemplar)
+	return e
+}
+
+func (eh *exemplarHolder) store(e *exemplar) {
+	eh.v.Store(e)
+}
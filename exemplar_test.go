@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalExemplarLabels(t *testing.T) {
+	f := func(labels map[string]string, resultExpected string) {
+		t.Helper()
+		result, err := marshalExemplarLabels(labels)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %s; want %s", result, resultExpected)
+		}
+	}
+	f(nil, "{}")
+	f(map[string]string{
+		"trace_id": "abc",
+	}, `{trace_id="abc"}`)
+	f(map[string]string{
+		"trace_id": "abc",
+		"span_id":  `x"y`,
+	}, `{span_id="x\"y",trace_id="abc"}`)
+
+	// The maximum length
+	f(map[string]string{
+		"a": strings.Repeat("й", maxExemplarLabelsRunes-1),
+	}, `{a="`+strings.Repeat("й", maxExemplarLabelsRunes-1)+`"}`)
+}
+
+func TestMarshalExemplarLabelsFailure(t *testing.T) {
+	f := func(labels map[string]string) {
+		t.Helper()
+		if _, err := marshalExemplarLabels(labels); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	// Invalid label name
+	f(map[string]string{
+		"": "abc",
+	})
+	f(map[string]string{
+		"trace-id": "abc",
+	})
+
+	// Invalid UTF-8 value
+	f(map[string]string{
+		"trace_id": "\xff",
+	})
+
+	// Too long labels
+	f(map[string]string{
+		"a": strings.Repeat("й", maxExemplarLabelsRunes),
+	})
+	f(map[string]string{
+		"trace_id": strings.Repeat("a", 64),
+		"span_id":  strings.Repeat("a", 64),
+	})
+}
+
+func TestCounterExemplar(t *testing.T) {
+	s := NewSet()
+	c := s.NewCounter(`requests_total{path="/foo"}`)
+	if err := c.IncWithExemplar(map[string]string{"trace_id": "abc"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.AddWithExemplar(2, map[string]string{"trace_id": "def"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Invalid exemplar must be dropped, while the counter must be updated.
+	if err := c.AddWithExemplar(3, map[string]string{"trace-id": "xyz"}); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if n := c.Get(); n != 6 {
+		t.Fatalf("unexpected counter value; got %d; want 6", n)
+	}
+	c.loadExt().exemplar.load().timestamp = time.Unix(1700000000, 123e6)
+
+	// Exemplars are exposed in OpenMetrics format
+	var bb bytes.Buffer
+	s.WriteOpenMetrics(&bb)
+	resultExpected := `# TYPE requests counter
+requests_total{path="/foo"} 6 # {trace_id="def"} 2 1700000000.123
+# EOF
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Exemplars aren't exposed in Prometheus text format
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	resultExpected = `requests_total{path="/foo"} 6` + "\n"
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestHistogramExemplar(t *testing.T) {
+	s := NewSet()
+	h := s.NewHistogram(`request_duration_seconds{path="/foo"}`)
+	h.Update(0.5)
+	if err := h.UpdateWithExemplar(0.5, map[string]string{"trace_id": "abc"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := h.UpdateWithExemplar(2, map[string]string{"trace_id": "def"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := h.UpdateWithExemplar(1e20, map[string]string{"trace_id": "ghi"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := h.UpdateWithExemplar(2, map[string]string{"trace-id": "xyz"}); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	for _, e := range h.exemplars {
+		e.timestamp = time.Unix(1700000000, 0)
+	}
+
+	var bb bytes.Buffer
+	s.WriteOpenMetrics(&bb)
+	resultExpected := `# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{path="/foo",le="5.275e-01"} 2 # {trace_id="abc"} 0.5 1700000000
+request_duration_seconds_bucket{path="/foo",le="2.154e+00"} 4 # {trace_id="def"} 2 1700000000
+request_duration_seconds_bucket{path="/foo",le="+Inf"} 5 # {trace_id="ghi"} 1e+20 1700000000
+request_duration_seconds_sum{path="/foo"} 1e+20
+request_duration_seconds_count{path="/foo"} 5
+# EOF
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Exemplars aren't exposed in Prometheus text format
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if result := bb.String(); strings.Contains(result, "#") {
+		t.Fatalf("unexpected exemplars in Prometheus text format:\n%s", result)
+	}
+
+	// Reset drops exemplars
+	h.Reset()
+	h.Update(2)
+	bb.Reset()
+	s.WriteOpenMetrics(&bb)
+	if result := bb.String(); strings.Contains(result, "trace_id") {
+		t.Fatalf("unexpected exemplars after Reset:\n%s", result)
+	}
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RegisterExpvarMetrics registers numeric expvar variables for exposing in the output generated by WritePrometheus.
+//
+// Every numeric variable published via expvar package is exposed as a gauge with `<prefix>_<name>` name.
+// Entries of expvar.Map are exposed as `<prefix>_<name>{key="<entry_key>"}` gauges.
+// Variable names are converted to valid Prometheus identifiers by replacing unsupported chars with `_`.
+//
+// Non-numeric variables such as `cmdline` and `memstats` are skipped.
+// Use WriteProcessMetrics for exposing Go runtime metrics.
+//
+// filter is an optional function, which must return true for variable names, which must be exposed.
+// All the numeric variables are exposed if filter is nil.
+//
+// Variables are read at every WritePrometheus call, so the exposed values are always up to date.
+// Call Close on the returned MetricsWriter for stopping exposing expvar variables.
+func RegisterExpvarMetrics(prefix string, filter func(name string) bool) *MetricsWriter {
+	return defaultSet.RegisterExpvarMetrics(prefix, filter)
+}
+
+// RegisterExpvarMetrics registers numeric expvar variables for exposing in the output generated by s.WritePrometheus.
+//
+// See RegisterExpvarMetrics for details.
+func (s *Set) RegisterExpvarMetrics(prefix string, filter func(name string) bool) *MetricsWriter {
+	return s.RegisterMetricsWriter(func(w io.Writer) {
+		writeExpvarMetrics(w, prefix, filter)
+	})
+}
+
+func writeExpvarMetrics(w io.Writer, prefix string, filter func(name string) bool) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		if filter != nil && !filter(kv.Key) {
+			return
+		}
+		metricName := getExpvarMetricName(prefix, kv.Key)
+		if m, ok := kv.Value.(*expvar.Map); ok {
+			metadataWritten := false
+			m.Do(func(entry expvar.KeyValue) {
+				v, ok := getExpvarValue(entry.Value)
+				if !ok {
+					return
+				}
+				if !metadataWritten {
+					WriteMetadataIfNeeded(w, metricName, "gauge")
+					metadataWritten = true
+				}
+				fmt.Fprintf(w, "%s{key=\"%s\"} %g\n", metricName, appendEscapedLabelValue(nil, entry.Key), v)
+			})
+			return
+		}
+		v, ok := getExpvarValue(kv.Value)
+		if !ok {
+			return
+		}
+		WriteGaugeFloat64(w, metricName, v)
+	})
+}
+
+// getExpvarValue returns numeric value for v.
+//
+// false is returned if v isn't numeric.
+func getExpvarValue(v expvar.Var) (float64, bool) {
+	switch t := v.(type) {
+	case *expvar.Int:
+		return float64(t.Value()), true
+	case *expvar.Float:
+		return t.Value(), true
+	case *expvar.String, *expvar.Map:
+		return 0, false
+	case expvar.Func:
+		switch x := t.Value().(type) {
+		case int:
+			return float64(x), true
+		case int32:
+			return float64(x), true
+		case int64:
+			return float64(x), true
+		case uint:
+			return float64(x), true
+		case uint32:
+			return float64(x), true
+		case uint64:
+			return float64(x), true
+		case float32:
+			return float64(x), true
+		case float64:
+			return x, true
+		default:
+			return 0, false
+		}
+	default:
+		// Custom expvar.Var implementations are exposed if they are marshaled to JSON number.
+		f, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil || math.IsNaN(f) {
+			return 0, false
+		}
+		return f, true
+	}
+}
+
+// getExpvarMetricName returns valid Prometheus metric name for the expvar variable with the given name.
+func getExpvarMetricName(prefix, name string) string {
+	var sb strings.Builder
+	if prefix != "" {
+		sb.WriteString(sanitizeExpvarName(prefix))
+		sb.WriteByte('_')
+	}
+	sb.WriteString(sanitizeExpvarName(name))
+	metricName := sb.String()
+	if metricName == "" || (metricName[0] >= '0' && metricName[0] <= '9') {
+		metricName = "_" + metricName
+	}
+	return metricName
+}
+
+func sanitizeExpvarName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"bytes"
+	"expvar"
+	"strings"
+	"testing"
+)
+
+func TestRegisterExpvarMetrics(t *testing.T) {
+	expvar.NewInt("expvar_test.int").Set(42)
+	expvar.NewFloat("expvar_test-float").Set(1.5)
+	expvar.NewString("expvar_test_string").Set("foo")
+	m := expvar.NewMap("expvar_test_map")
+	m.Add("b", 2)
+	m.AddFloat(`a"x`, 0.5)
+	m.Set("s", new(expvar.String))
+	expvar.Publish("expvar_test_func", expvar.Func(func() interface{} {
+		return 7
+	}))
+	expvar.Publish("expvar_test_func_struct", expvar.Func(func() interface{} {
+		return struct{}{}
+	}))
+
+	s := NewSet()
+	mw := s.RegisterExpvarMetrics("app", func(name string) bool {
+		return strings.HasPrefix(name, "expvar_test")
+	})
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `app_expvar_test_float 1.5
+app_expvar_test_int 42
+app_expvar_test_func 7
+app_expvar_test_map{key="a\"x"} 0.5
+app_expvar_test_map{key="b"} 2
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Values must be read at write time.
+	m.Add("b", 3)
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if result := bb.String(); !strings.Contains(result, `app_expvar_test_map{key="b"} 5`+"\n") {
+		t.Fatalf("missing the updated value in the output:\n%s", result)
+	}
+
+	mw.Close()
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if result := bb.String(); result != "" {
+		t.Fatalf("unexpected output after Close:\n%s", result)
+	}
+}
+
+func TestGetExpvarMetricName(t *testing.T) {
+	f := func(prefix, name, resultExpected string) {
+		t.Helper()
+		if result := getExpvarMetricName(prefix, name); result != resultExpected {
+			t.Fatalf("unexpected result for prefix=%q, name=%q; got %q; want %q", prefix, name, result, resultExpected)
+		}
+	}
+	f("", "foo", "foo")
+	f("", "1foo", "_1foo")
+	f("app", "foo.bar-baz", "app_foo_bar_baz")
+	f("my.app", "requests/sec", "my_app_requests_sec")
+	f("app", "ключ", "app_____")
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+)
+
+// ReadPrometheus reads samples in Prometheus text exposition format from r.
+//
+// The format is described at https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md#text-based-format
+// Empty lines and comments including `# HELP` and `# TYPE` lines are skipped. Label values are unescaped.
+// Values may contain `+Inf`, `-Inf` and `NaN`. Optional timestamps are returned in MetricSample.Timestamp.
+//
+// An error with the line number is returned for the first line, which cannot be parsed.
+func ReadPrometheus(r io.Reader) ([]MetricSample, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read metrics: %w", err)
+	}
+	var samples []MetricSample
+	err = forEachSample(data, func(ps *parsedSample) {
+		var labels map[string]string
+		if len(ps.labels) > 0 {
+			labels = make(map[string]string, len(ps.labels))
+			for _, l := range ps.labels {
+				labels[l.name] = l.value
+			}
+		}
+		samples = append(samples, MetricSample{
+			Name:      ps.metricName,
+			Labels:    labels,
+			Value:     ps.value,
+			Timestamp: ps.timestamp,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// RegisterExternalSource registers the external source of metrics with the given name in the default set.
+//
+// See Set.RegisterExternalSource for details.
+func RegisterExternalSource(name string, fetch func() (io.Reader, error)) *MetricsWriter {
+	return defaultSet.RegisterExternalSource(name, fetch)
+}
+
+// RegisterExternalSource registers the external source of metrics with the given name in s.
+//
+// fetch is called every time s metrics are written. It must return metrics in Prometheus text exposition format.
+// For example, it may open the file written by another process. The returned reader is closed after reading
+// if it implements io.Closer. The samples are read via ReadPrometheus and are written after the metrics registered in s.
+//
+// Errors returned by fetch and parse errors are logged together with the source name, and no samples
+// are written for the source in this case.
+//
+// Samples with the same names and labels as metrics registered in s or as series already written by other sets
+// and external sources are dropped, since they would result in duplicate series. The dropped samples are logged
+// and are counted by the metrics_duplicate_series_total{source="external_source"} counter. See also SetStrictDuplicateSeries.
+//
+// Call Close on the returned MetricsWriter for unregistering the source.
+func (s *Set) RegisterExternalSource(name string, fetch func() (io.Reader, error)) *MetricsWriter {
+	es := &externalSource{
+		s:     s,
+		name:  name,
+		fetch: fetch,
+	}
+	mw := &MetricsWriter{
+		s:                        s,
+		writeMetricsWithDetector: es.writeMetrics,
+	}
+	s.registerMetricsWriter(mw)
+	return mw
+}
+
+// externalSource is the source of metrics registered via RegisterExternalSource.
+type externalSource struct {
+	s     *Set
+	name  string
+	fetch func() (io.Reader, error)
+}
+
+// isRegisteredLocked returns true if the series with the given name is registered in es.s.
+//
+// The series is looked up by its canonical name with sorted labels too, so it matches the metrics
+// registered via BuildName and metric vectors regardless of the label order in the external source.
+//
+// es.s.mu must be locked.
+func (es *externalSource) isRegisteredLocked(name string, snc *seriesNameCanonicalizer) bool {
+	if _, ok := es.s.m[name]; ok {
+		return true
+	}
+	canonicalName := snc.canonicalName(name)
+	if canonicalName == name {
+		return false
+	}
+	_, ok := es.s.m[canonicalName]
+	return ok
+}
+
+// writeMetrics writes samples from es to w.
+//
+// Samples already seen by dd are skipped if dd isn't nil.
+func (es *externalSource) writeMetrics(w io.Writer, dd *duplicateDetector) {
+	samples, err := es.readSamples()
+	if err != nil {
+		log.Printf("ERROR: metrics: cannot read metrics from external source %q: %s", es.name, err)
+		return
+	}
+
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	duplicates := 0
+	firstDuplicate := ""
+	var snc *seriesNameCanonicalizer
+	if dd != nil {
+		snc = &dd.snc
+	} else {
+		snc = &seriesNameCanonicalizer{}
+	}
+	es.s.mu.RLock()
+	for i := range samples {
+		sample := &samples[i]
+		seriesName := sample.SeriesName()
+		isDuplicate := false
+		if es.isRegisteredLocked(seriesName, snc) {
+			// The registered metrics aren't tracked by dd if s is written alone.
+			if dd != nil {
+				dd.addDuplicate(duplicateSourceExternal, seriesName)
+			} else {
+				recordDuplicateSeries(duplicateSourceExternal, seriesName)
+			}
+			isDuplicate = true
+		} else if dd != nil {
+			isDuplicate = dd.isDuplicate(duplicateSourceExternal, seriesName)
+		}
+		if isDuplicate {
+			if duplicates == 0 {
+				firstDuplicate = seriesName
+			}
+			duplicates++
+			continue
+		}
+		bb.B = append(bb.B, seriesName...)
+		bb.B = append(bb.B, ' ')
+		bb.B = appendGaugeValue(bb.B, sample.Value)
+		if sample.Timestamp != 0 {
+			bb.B = append(bb.B, ' ')
+			bb.B = strconv.AppendInt(bb.B, sample.Timestamp, 10)
+		}
+		bb.B = append(bb.B, '\n')
+	}
+	es.s.mu.RUnlock()
+
+	if duplicates > 0 {
+		log.Printf("ERROR: metrics: dropped %d series from external source %q, which duplicate registered metrics or already written series; for example, %s",
+			duplicates, es.name, firstDuplicate)
+	}
+	w.Write(bb.B)
+}
+
+func (es *externalSource) readSamples() ([]MetricSample, error) {
+	r, err := es.fetch()
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, nil
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer func() {
+			_ = c.Close()
+		}()
+	}
+	return ReadPrometheus(r)
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadPrometheusSuccess(t *testing.T) {
+	data := `# HELP foo_total Total foos.
+# TYPE foo_total counter
+foo_total 123
+
+bar{a="b",c="x\"y\\z\nw"} 1.5 1700000000000
+  baz{ a = "b" , } +Inf
+qux -Inf
+nan NaN
+`
+	samples, err := ReadPrometheus(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(samples) != 5 {
+		t.Fatalf("unexpected number of samples; got %d; want %d", len(samples), 5)
+	}
+	samplesExpected := []MetricSample{
+		{
+			Name:  "foo_total",
+			Value: 123,
+		},
+		{
+			Name: "bar",
+			Labels: map[string]string{
+				"a": "b",
+				"c": "x\"y\\z\nw",
+			},
+			Value:     1.5,
+			Timestamp: 1700000000000,
+		},
+		{
+			Name: "baz",
+			Labels: map[string]string{
+				"a": "b",
+			},
+			Value: math.Inf(1),
+		},
+		{
+			Name:  "qux",
+			Value: math.Inf(-1),
+		},
+	}
+	if !reflect.DeepEqual(samples[:4], samplesExpected) {
+		t.Fatalf("unexpected samples;\ngot\n%#v\nwant\n%#v", samples[:4], samplesExpected)
+	}
+	if samples[4].Name != "nan" || !math.IsNaN(samples[4].Value) {
+		t.Fatalf("unexpected sample; got %#v; want NaN value for nan", samples[4])
+	}
+}
+
+func TestReadPrometheusFailure(t *testing.T) {
+	f := func(data, errExpected string) {
+		t.Helper()
+		samples, err := ReadPrometheus(strings.NewReader(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if samples != nil {
+			t.Fatalf("expecting nil samples; got %v", samples)
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want it to contain %q", err, errExpected)
+		}
+	}
+	f("foo", "line #1")
+	f("foo 1\n# comment\nbar{a=\"b\" 1", "line #3")
+	f("foo 1\nbar abc", "line #2")
+	f("foo 1 abc", "line #1")
+	f("foo{a=b} 1", "line #1")
+}
+
+type testReadCloser struct {
+	io.Reader
+	closed bool
+}
+
+func (rc *testReadCloser) Close() error {
+	rc.closed = true
+	return nil
+}
+
+func TestSetRegisterExternalSource(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`foo_total{a="b"}`).Set(1)
+	data := `foo_total{a="b"} 2
+foo_total{a="c"} 3
+bar 4.5 1700000000000
+`
+	var rc *testReadCloser
+	var fetchErr error
+	mw := s.RegisterExternalSource("legacy", func() (io.Reader, error) {
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		rc = &testReadCloser{
+			Reader: strings.NewReader(data),
+		}
+		return rc, nil
+	})
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// The duplicate series from the external source must be dropped.
+	duplicatesPrev := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceExternal])
+	f(`foo_total{a="b"} 1
+foo_total{a="c"} 3
+bar 4.5 1700000000000
+`)
+	if n := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceExternal]) - duplicatesPrev; n != 1 {
+		t.Fatalf("unexpected number of duplicate series; got %d; want %d", n, 1)
+	}
+	if !rc.closed {
+		t.Fatalf("the reader returned from fetch must be closed")
+	}
+
+	// The source must be re-read on every write.
+	data = "baz 5\n"
+	f(`foo_total{a="b"} 1
+baz 5
+`)
+
+	// Samples from the source must be skipped on errors.
+	fetchErr = fmt.Errorf("cannot open file")
+	f(`foo_total{a="b"} 1
+`)
+	fetchErr = nil
+	data = "baz 5\nbaz{\n"
+	f(`foo_total{a="b"} 1
+`)
+
+	mw.Close()
+	data = "baz 5\n"
+	f(`foo_total{a="b"} 1
+`)
+}
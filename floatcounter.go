@@ -1,9 +1,8 @@
 package metrics
 
 import (
-	"fmt"
 	"io"
-	"sync"
+	"math"
 )
 
 // NewFloatCounter registers and returns new counter of float64 type with the given name.
@@ -20,53 +19,107 @@ func NewFloatCounter(name string) *FloatCounter {
 	return defaultSet.NewFloatCounter(name)
 }
 
-// FloatCounter is a float64 counter guarded by RWmutex.
+// FloatCounter is a float64 counter updated with atomic operations.
 //
 // It may be used as a gauge if Add and Sub are called.
 type FloatCounter struct {
-	mu sync.Mutex
-	n  float64
+	// valueBits contains uint64 representation of float64 counter value.
+	valueBits atomicUint64
+
+	// floatFormat is the float format set via SetFloatFormat. It is accessed atomically.
+	floatFormat uint32
+
+	statsd statsdMirror
+
+	created createdTimestamp
 }
 
 // Add adds n to fc.
+//
+// n may be negative.
 func (fc *FloatCounter) Add(n float64) {
-	fc.mu.Lock()
-	fc.n += n
-	fc.mu.Unlock()
+	fc.add(n)
+	if ss := fc.statsd.load(); ss != nil {
+		ss.sendFloat(n, "c")
+	}
 }
 
 // Sub substracts n from fc.
 func (fc *FloatCounter) Sub(n float64) {
-	fc.mu.Lock()
-	fc.n -= n
-	fc.mu.Unlock()
+	fc.add(-n)
+	if ss := fc.statsd.load(); ss != nil {
+		ss.sendFloat(-n, "c")
+	}
+}
+
+func (fc *FloatCounter) add(n float64) {
+	for {
+		bits := fc.valueBits.Load()
+		bitsNew := math.Float64bits(math.Float64frombits(bits) + n)
+		if fc.valueBits.CompareAndSwap(bits, bitsNew) {
+			return
+		}
+	}
 }
 
 // Get returns the current value for fc.
 func (fc *FloatCounter) Get() float64 {
-	fc.mu.Lock()
-	n := fc.n
-	fc.mu.Unlock()
-	return n
+	bits := fc.valueBits.Load()
+	return math.Float64frombits(bits)
 }
 
 // Set sets fc value to n.
 func (fc *FloatCounter) Set(n float64) {
-	fc.mu.Lock()
-	fc.n = n
-	fc.mu.Unlock()
+	fc.valueBits.Store(math.Float64bits(n))
+	if n == 0 {
+		fc.created.onReset()
+	}
+	if ss := fc.statsd.load(); ss != nil {
+		ss.sendFloat(n, "g")
+	}
+}
+
+// Swap sets fc to n and returns the previous value.
+//
+// Swap isn't mirrored to StatsD, since it is intended for reading deltas. See also GetAndReset.
+func (fc *FloatCounter) Swap(n float64) float64 {
+	if n == 0 {
+		fc.created.onReset()
+	}
+	prevBits := fc.valueBits.Swap(math.Float64bits(n))
+	return math.Float64frombits(prevBits)
+}
+
+// GetAndReset atomically returns the current value for fc and resets it to zero.
+//
+// This is useful for reporting deltas to systems, which expect them. Do not call GetAndReset
+// for counters scraped by Prometheus, since scrapes observe sawtooth values instead of monotonically
+// increasing counter in this case. See also SnapshotAndReset.
+func (fc *FloatCounter) GetAndReset() float64 {
+	return fc.Swap(0)
+}
+
+// MarshalTo writes fc with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (fc *FloatCounter) MarshalTo(prefix string, w io.Writer) {
+	fc.marshalTo(prefix, w)
 }
 
 // marshalTo marshals fc with the given prefix to w.
 func (fc *FloatCounter) marshalTo(prefix string, w io.Writer) {
 	v := fc.Get()
-	fmt.Fprintf(w, "%s %g\n", prefix, v)
+	writeSampleGaugeValue(w, prefix, v, nil, &fc.floatFormat)
 }
 
 func (fc *FloatCounter) metricType() string {
 	return "counter"
 }
 
+func (fc *FloatCounter) getStatsDMirror(_ bool) *statsdMirror {
+	return &fc.statsd
+}
+
 // GetOrCreateFloatCounter returns registered FloatCounter with the given name
 // or creates new FloatCounter if the registry doesn't contain FloatCounter with
 // the given name.
@@ -27,6 +27,14 @@ func TestFloatCounterSerial(t *testing.T) {
 
 	// Verify MarshalTo
 	testMarshalTo(t, c, "foobar", "foobar 125.002\n")
+
+	// Verify negative Add and small fractional values
+	c.Add(-125)
+	if n := c.Get(); n > 0.0021 || n < 0.0019 {
+		t.Fatalf("unexpected counter value; got %f; want 0.002", n)
+	}
+	c.Set(1e-9)
+	testMarshalTo(t, c, "foobar", "foobar 1e-09\n")
 }
 
 func TestFloatCounterConcurrent(t *testing.T) {
@@ -74,3 +82,17 @@ func testGetOrCreateFloatCounter(name string) error {
 	}
 	return nil
 }
+
+func TestFloatCounterSwap(t *testing.T) {
+	fc := NewSet().NewFloatCounter("foo")
+	fc.Add(1.5)
+	if v := fc.Swap(2.25); v != 1.5 {
+		t.Fatalf("unexpected previous value; got %v; want 1.5", v)
+	}
+	if v := fc.GetAndReset(); v != 2.25 {
+		t.Fatalf("unexpected value; got %v; want 2.25", v)
+	}
+	if v := fc.Get(); v != 0 {
+		t.Fatalf("unexpected value after reset; got %v; want 0", v)
+	}
+}
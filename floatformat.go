@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+)
+
+// SetFloatFormat sets the format for float64 values of gauges, float counters, summary quantiles and histogram sums
+// in the exposition output.
+//
+// format and precision have the same meaning as for strconv.FormatFloat. For example, SetFloatFormat('f', 3) writes values
+// with three digits after the decimal point, while SetFloatFormat('g', 6) writes values with at most six significant digits.
+// Supported formats are 'e', 'E', 'f', 'g' and 'G'.
+//
+// If precision is -1, then the shortest representation is used, which allows parsing the value back without precision loss.
+// Integer values are written without fractional part and exponent in this case.
+//
+// +Inf, -Inf and NaN are always written as `+Inf`, `-Inf` and `NaN` according to the exposition format.
+//
+// By default SetFloatFormat('g', -1) is used. SetFloatFormat(0, 0) restores the default format.
+// The format may be overridden per metric via SetFloatFormat method of Gauge, FloatCounter, Summary and Histogram.
+func SetFloatFormat(format byte, precision int) {
+	atomic.StoreUint32(&defaultFloatFormat, packFloatFormat(format, precision))
+}
+
+var defaultFloatFormat uint32
+
+// SetFloatFormat sets the format for g value in the exposition output.
+//
+// SetFloatFormat(0, 0) resets the format to the one set via package-level SetFloatFormat.
+func (g *Gauge) SetFloatFormat(format byte, precision int) {
+	atomic.StoreUint32(&g.floatFormat, packFloatFormat(format, precision))
+}
+
+// SetFloatFormat sets the format for fc value in the exposition output.
+//
+// SetFloatFormat(0, 0) resets the format to the one set via package-level SetFloatFormat.
+func (fc *FloatCounter) SetFloatFormat(format byte, precision int) {
+	atomic.StoreUint32(&fc.floatFormat, packFloatFormat(format, precision))
+}
+
+// SetFloatFormat sets the format for sm quantiles and sum in the exposition output.
+//
+// SetFloatFormat(0, 0) resets the format to the one set via package-level SetFloatFormat.
+func (sm *Summary) SetFloatFormat(format byte, precision int) {
+	atomic.StoreUint32(&sm.floatFormat, packFloatFormat(format, precision))
+}
+
+// SetFloatFormat sets the format for h sum in the exposition output.
+//
+// SetFloatFormat(0, 0) resets the format to the one set via package-level SetFloatFormat.
+func (h *Histogram) SetFloatFormat(format byte, precision int) {
+	atomic.StoreUint32(&h.floatFormat, packFloatFormat(format, precision))
+}
+
+// packFloatFormat packs format and precision into uint32, which can be accessed atomically.
+//
+// Zero result means the default format.
+func packFloatFormat(format byte, precision int) uint32 {
+	switch format {
+	case 0:
+		return 0
+	case 'e', 'E', 'f', 'g', 'G':
+	default:
+		panic(fmt.Errorf("BUG: unsupported float format %q; supported formats: 'e', 'E', 'f', 'g', 'G'", format))
+	}
+	if precision < -1 || precision > math.MaxInt16 {
+		panic(fmt.Errorf("BUG: precision must be in the range [-1 ... %d]; got %d", math.MaxInt16, precision))
+	}
+	return uint32(format)<<16 | uint32(uint16(int16(precision)))
+}
+
+// loadFloatFormat returns format and precision stored at p.
+//
+// The format set via package-level SetFloatFormat is returned if p is nil or contains the default format.
+func loadFloatFormat(p *uint32) (byte, int) {
+	ff := uint32(0)
+	if p != nil {
+		ff = atomic.LoadUint32(p)
+	}
+	if ff == 0 {
+		ff = atomic.LoadUint32(&defaultFloatFormat)
+	}
+	if ff == 0 {
+		return 'g', -1
+	}
+	return byte(ff >> 16), int(int16(uint16(ff)))
+}
+
+// appendFloatValue appends v to dst in the format stored at p.
+//
+// p may be nil. See SetFloatFormat for details.
+func appendFloatValue(dst []byte, v float64, p *uint32) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(dst, "NaN"...)
+	case math.IsInf(v, 1):
+		return append(dst, "+Inf"...)
+	case math.IsInf(v, -1):
+		return append(dst, "-Inf"...)
+	}
+	format, precision := loadFloatFormat(p)
+	if precision < 0 && float64(int64(v)) == v {
+		return strconv.AppendInt(dst, int64(v), 10)
+	}
+	return strconv.AppendFloat(dst, v, format, precision, 64)
+}
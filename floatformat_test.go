@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAppendFloatValue(t *testing.T) {
+	f := func(format byte, precision int, v float64, resultExpected string) {
+		t.Helper()
+		ff := packFloatFormat(format, precision)
+		result := string(appendFloatValue(nil, v, &ff))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %v with format %q and precision %d; got %q; want %q", v, format, precision, result, resultExpected)
+		}
+	}
+
+	// default format
+	f(0, 0, 0, "0")
+	f(0, 0, 42, "42")
+	f(0, 0, 1e15, "1000000000000000")
+	f(0, 0, 1e20, "1e+20")
+	f(0, 0, 0.1, "0.1")
+	f(0, 0, 1.0/3, "0.3333333333333333")
+
+	// custom formats
+	f('g', 4, 1.0/3, "0.3333")
+	f('g', 4, 42, "42")
+	f('f', 2, 1.0/3, "0.33")
+	f('f', 2, 42, "42.00")
+	f('f', -1, 42, "42")
+	f('e', 3, 1234.5678, "1.235e+03")
+	f('e', -1, 1234.5, "1.2345e+03")
+	f('G', 3, 1.5e-10, "1.5E-10")
+
+	// special values
+	for _, format := range []byte{0, 'e', 'E', 'f', 'g', 'G'} {
+		f(format, 3, math.Inf(1), "+Inf")
+		f(format, 3, math.Inf(-1), "-Inf")
+		f(format, 3, math.NaN(), "NaN")
+	}
+}
+
+func TestPackFloatFormatInvalid(t *testing.T) {
+	expectPanic(t, "unsupported format", func() {
+		packFloatFormat('x', 2)
+	})
+	expectPanic(t, "too small precision", func() {
+		packFloatFormat('g', -2)
+	})
+	expectPanic(t, "too big precision", func() {
+		packFloatFormat('g', math.MaxInt16+1)
+	})
+}
+
+func TestSetFloatFormat(t *testing.T) {
+	SetFloatFormat('f', 2)
+	defer SetFloatFormat(0, 0)
+
+	s := NewSet()
+	g := s.NewGauge("gauge", nil)
+	g.Set(1.0 / 3)
+	fc := s.NewFloatCounter("float_counter")
+	fc.Add(2.5)
+	h := s.NewHistogram("histogram")
+	h.Update(0.1)
+	h.Update(0.2)
+
+	g.SetFloatFormat('g', 3)
+	h.SetFloatFormat('e', 1)
+
+	testMarshalTo(t, g, "gauge", "gauge 0.333\n")
+	testMarshalTo(t, fc, "float_counter", "float_counter 2.50\n")
+	testMarshalTo(t, h, "histogram", `histogram_bucket{vmrange="8.799e-02...1.000e-01"} 1
+histogram_bucket{vmrange="1.896e-01...2.154e-01"} 1
+histogram_sum 3.0e-01
+histogram_count 2
+`)
+
+	// Reset the per-metric format to the package-level format.
+	g.SetFloatFormat(0, 0)
+	testMarshalTo(t, g, "gauge", "gauge 0.33\n")
+
+	// Restore the default format.
+	SetFloatFormat(0, 0)
+	testMarshalTo(t, g, "gauge", "gauge 0.3333333333333333\n")
+	testMarshalTo(t, fc, "float_counter", "float_counter 2.5\n")
+}
+
+func TestSummarySetFloatFormat(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummaryExt("summary", defaultSummaryWindow, []float64{0.5})
+	sm.SetFloatFormat('f', 1)
+	sm.Update(1.25)
+	sm.Update(2.25)
+
+	var bb bytesBuffer
+	s.WritePrometheus(&bb)
+	result := string(bb.B)
+	resultExpected := `summary_sum 3.5
+summary_count 2
+summary{quantile="0.5"} 2.2
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
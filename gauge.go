@@ -4,7 +4,8 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"sync/atomic"
+	"sync"
+	"time"
 )
 
 // NewGauge registers and returns gauge with the given name, which calls f to obtain gauge value.
@@ -26,13 +27,60 @@ func NewGauge(name string, f func() float64) *Gauge {
 	return defaultSet.NewGauge(name, f)
 }
 
+// NewCachedGauge registers and returns gauge with the given name, which calls f to obtain gauge value at most once per ttl.
+//
+// The value returned by f is cached for ttl, so frequent scrapes from multiple scrapers do not call expensive f
+// on every scrape. Concurrent scrapes wait for the in-flight f call instead of calling f concurrently.
+// The cached value is returned by Get.
+//
+// Zero ttl disables caching, so NewCachedGauge is equivalent to NewGauge in this case.
+//
+// See NewGauge for details.
+func NewCachedGauge(name string, ttl time.Duration, f func() float64) *Gauge {
+	return defaultSet.NewCachedGauge(name, ttl, f)
+}
+
+// GaugeOpts contains options for NewGaugeOpt.
+type GaugeOpts struct {
+	// Name is the gauge name. It must be valid Prometheus-compatible metric with possible labels.
+	Name string
+
+	// Help is an optional description for the gauge. It is exposed in the `# HELP` line.
+	Help string
+
+	// Unit is an optional unit for the gauge such as `seconds` or `bytes`. It is exposed in the `# UNIT` line in OpenMetrics format.
+	//
+	// See SetStrictUnitNames and NormalizeUnit.
+	Unit string
+}
+
+// NewGaugeOpt registers and returns gauge with the given opts, which calls f to obtain gauge value.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+// f may be nil - see NewGauge for details.
+//
+// The returned gauge is safe to use from concurrent goroutines.
+func NewGaugeOpt(opts GaugeOpts, f func() float64) *Gauge {
+	return defaultSet.NewGaugeOpt(opts, f)
+}
+
 // Gauge is a float64 gauge.
 type Gauge struct {
 	// valueBits contains uint64 representation of float64 passed to Gauge.Set.
-	valueBits uint64
+	valueBits atomicUint64
+
+	timestamp metricTimestamp
 
 	// f is a callback, which is called for returning the gauge value.
 	f func() float64
+
+	// fErr is the callback passed to NewFallibleGauge. f calls fErr if it is set.
+	fErr *fallibleGaugeFunc
+
+	// floatFormat is the float format set via SetFloatFormat. It is accessed atomically.
+	floatFormat uint32
+
+	statsd statsdMirror
 }
 
 // Get returns the current value for g.
@@ -40,7 +88,7 @@ func (g *Gauge) Get() float64 {
 	if f := g.f; f != nil {
 		return f()
 	}
-	n := atomic.LoadUint64(&g.valueBits)
+	n := g.valueBits.Load()
 	return math.Float64frombits(n)
 }
 
@@ -52,7 +100,25 @@ func (g *Gauge) Set(v float64) {
 		panic(fmt.Errorf("cannot call Set on gauge created with non-nil callback"))
 	}
 	n := math.Float64bits(v)
-	atomic.StoreUint64(&g.valueBits, n)
+	g.valueBits.Store(n)
+	if ss := g.statsd.load(); ss != nil {
+		ss.sendFloat(v, "g")
+	}
+}
+
+// SetWithTimestamp sets g value to v and attaches the given timestamp ts to g.
+//
+// See Counter.SetWithTimestamp for details.
+//
+// The g must be created with nil callback in order to be able to call this function.
+func (g *Gauge) SetWithTimestamp(v float64, ts time.Time) {
+	g.Set(v)
+	g.timestamp.store(ts)
+}
+
+// ClearTimestamp removes the timestamp attached to g via SetWithTimestamp, so the scraper uses the scrape time for g.
+func (g *Gauge) ClearTimestamp() {
+	g.timestamp.store(time.Time{})
 }
 
 // Inc increments g by 1.
@@ -74,33 +140,101 @@ func (g *Gauge) Dec() {
 // The g must be created with nil callback in order to be able to call this function.
 func (g *Gauge) Add(fAdd float64) {
 	if g.f != nil {
-		panic(fmt.Errorf("cannot call Set on gauge created with non-nil callback"))
+		panic(fmt.Errorf("cannot call Add on gauge created with non-nil callback"))
 	}
 	for {
-		n := atomic.LoadUint64(&g.valueBits)
+		n := g.valueBits.Load()
 		f := math.Float64frombits(n)
 		fNew := f + fAdd
 		nNew := math.Float64bits(fNew)
-		if atomic.CompareAndSwapUint64(&g.valueBits, n, nNew) {
+		if g.valueBits.CompareAndSwap(n, nNew) {
+			if ss := g.statsd.load(); ss != nil {
+				ss.sendFloat(fNew, "g")
+			}
 			break
 		}
 	}
 }
 
+// cachedGaugeFunc caches the result of f for ttl.
+type cachedGaugeFunc struct {
+	f   func() float64
+	ttl time.Duration
+
+	// mu serializes f calls, so concurrent get calls wait for the in-flight f call.
+	mu       sync.Mutex
+	value    float64
+	deadline time.Time
+}
+
+// newCachedGaugeFunc returns f, which caches its result for ttl.
+//
+// f is returned as is if it is nil or if ttl isn't positive.
+func newCachedGaugeFunc(f func() float64, ttl time.Duration) func() float64 {
+	if f == nil || ttl <= 0 {
+		return f
+	}
+	cf := &cachedGaugeFunc{
+		f:   f,
+		ttl: ttl,
+	}
+	return cf.get
+}
+
+func (cf *cachedGaugeFunc) get() float64 {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	now := clockNow()
+	if now.Before(cf.deadline) {
+		return cf.value
+	}
+	v := cf.f()
+	cf.value = v
+	cf.deadline = now.Add(cf.ttl)
+	return v
+}
+
+// MarshalTo writes g with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (g *Gauge) MarshalTo(prefix string, w io.Writer) {
+	g.marshalTo(prefix, w)
+}
+
 func (g *Gauge) marshalTo(prefix string, w io.Writer) {
-	v := g.Get()
-	if float64(int64(v)) == v {
-		// Marshal integer values without scientific notation
-		fmt.Fprintf(w, "%s %d\n", prefix, int64(v))
-	} else {
-		fmt.Fprintf(w, "%s %g\n", prefix, v)
+	v, ok := g.get()
+	if !ok {
+		return
+	}
+	writeSampleGaugeValue(w, prefix, v, &g.timestamp, &g.floatFormat)
+}
+
+func (g *Gauge) marshalToOpenMetricsSample(name string, w io.Writer) {
+	v, ok := g.get()
+	if !ok {
+		return
 	}
+	writeOpenMetricsSample(w, name, formatGaugeValue(v, &g.floatFormat), &g.timestamp, nil)
+}
+
+// formatGaugeValue formats v according to the float format stored at ff the same way as Gauge.marshalTo does.
+func formatGaugeValue(v float64, ff *uint32) string {
+	return string(appendFloatValue(nil, v, ff))
+}
+
+func (g *Gauge) getTimestamp() *metricTimestamp {
+	return &g.timestamp
 }
 
 func (g *Gauge) metricType() string {
 	return "gauge"
 }
 
+func (g *Gauge) getStatsDMirror(_ bool) *statsdMirror {
+	return &g.statsd
+}
+
 // GetOrCreateGauge returns registered gauge with the given name
 // or creates new gauge if the registry doesn't contain gauge with
 // the given name.
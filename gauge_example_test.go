@@ -39,3 +39,19 @@ func ExampleGauge_vec() {
 	// 2
 	// 3
 }
+
+func ExampleGauge_inFlight() {
+	// Use gauge with nil callback instead of Counter for values, which may decrease,
+	// such as the number of in-flight requests.
+	var g = metrics.NewGauge(`requests_in_flight`, nil)
+
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(-1)
+
+	fmt.Println(g.Get())
+
+	// Output:
+	// 0
+}
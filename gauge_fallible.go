@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// NewFallibleGauge registers and returns gauge with the given name, which calls f to obtain gauge value.
+//
+// Use it instead of NewGauge if f may fail, e.g. if it reads files or queries remote services. Errors do not go unnoticed
+// in this case, since stale or zero values aren't exposed for failed f calls.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// If f returns an error or panics, then the gauge sample is omitted from the Prometheus text exposition and OpenMetrics output
+// for the current write, while `<name>_collect_errors_total` counter with the same labels as the gauge is incremented.
+// For example, `foo_collect_errors_total{bar="baz"}` counter is registered for `foo{bar="baz"}` gauge.
+// The counter isn't unregistered together with the gauge, so it must be unregistered separately if needed.
+// The handler set via SetGaugeErrorHandler is called with the error. NaN is written instead of omitting the sample
+// if SetGaugeErrorsAsNaN(true) is called. Get returns NaN on errors. Other output formats such as JSON and protobuf contain NaN too.
+//
+// f must be safe for concurrent calls. f must be non-nil.
+//
+// The returned gauge is safe to use from concurrent goroutines.
+func NewFallibleGauge(name string, f func() (float64, error)) *Gauge {
+	return defaultSet.NewFallibleGauge(name, f)
+}
+
+// NewFallibleGauge registers and returns gauge with the given name in s, which calls f to obtain gauge value.
+//
+// See NewFallibleGauge for details.
+func (s *Set) NewFallibleGauge(name string, f func() (float64, error)) *Gauge {
+	if f == nil {
+		panic(fmt.Errorf("BUG: f cannot be nil for gauge %q", name))
+	}
+	// Validate the gauge name before registering the counter, so the panic refers to the gauge.
+	if _, err := s.normalizeMetricName(name); err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name %q: %w", name, err))
+	}
+
+	// The counter is registered before the gauge, so it is ready when the gauge is written.
+	metricName, labels := splitMetricName(name)
+	errorsTotalName := metricName + "_collect_errors_total" + labels
+	errorsTotalNew := &Counter{}
+	m, err := s.tryRegisterMetricExt(errorsTotalName, errorsTotalNew, "", "", isDuplicateRegistrationAllowed(), false)
+	if err != nil {
+		panic(fmt.Errorf("BUG: cannot register gauge %q: %w", name, err))
+	}
+	errorsTotal := m.(*Counter)
+	g := &Gauge{
+		fErr: &fallibleGaugeFunc{
+			name:        name,
+			f:           f,
+			errorsTotal: errorsTotal,
+		},
+	}
+	g.f = func() float64 {
+		v, _ := g.get()
+		return v
+	}
+	gRegistered, err := s.tryRegisterMetricExt(name, g, "", "", isDuplicateRegistrationAllowed(), false)
+	if err != nil {
+		if errorsTotal == errorsTotalNew {
+			// Do not leak the counter registered above.
+			s.UnregisterMetric(errorsTotalName)
+		}
+		panic(fmt.Errorf("BUG: %w", err))
+	}
+	return gRegistered.(*Gauge)
+}
+
+// SetGaugeErrorHandler sets the handler, which is called with the gauge name and the error returned by the callback
+// passed to NewFallibleGauge.
+//
+// The handler is called for panics in the callback too. It may be used for logging the errors. The handler must be safe
+// for concurrent calls. Pass nil for removing the handler.
+func SetGaugeErrorHandler(h func(name string, err error)) {
+	gaugeErrorHandler.Store(gaugeErrorHandlerHolder{
+		h: h,
+	})
+}
+
+type gaugeErrorHandlerHolder struct {
+	h func(name string, err error)
+}
+
+var gaugeErrorHandler atomic.Value
+
+func getGaugeErrorHandler() func(name string, err error) {
+	if hh, ok := gaugeErrorHandler.Load().(gaugeErrorHandlerHolder); ok {
+		return hh.h
+	}
+	return nil
+}
+
+// SetGaugeErrorsAsNaN enables or disables writing NaN for gauges created via NewFallibleGauge when their callbacks fail.
+//
+// Samples for failed gauges are omitted from the output by default.
+func SetGaugeErrorsAsNaN(v bool) {
+	storeBool(&gaugeErrorsAsNaN, v)
+}
+
+var gaugeErrorsAsNaN uint32
+
+func isGaugeErrorsAsNaNEnabled() bool {
+	return atomic.LoadUint32(&gaugeErrorsAsNaN) != 0
+}
+
+// fallibleGaugeFunc is the callback passed to NewFallibleGauge.
+type fallibleGaugeFunc struct {
+	name string
+	f    func() (float64, error)
+
+	// errorsTotal counts errors and panics in f.
+	errorsTotal *Counter
+}
+
+// call calls ge.f and converts panics in ge.f into errors.
+//
+// Errors are counted and passed to the handler set via SetGaugeErrorHandler.
+func (ge *fallibleGaugeFunc) call() (v float64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in gauge callback: %v", r)
+		}
+		if err == nil {
+			return
+		}
+		v = math.NaN()
+		ge.errorsTotal.Inc()
+		if h := getGaugeErrorHandler(); h != nil {
+			h(ge.name, err)
+		}
+	}()
+	return ge.f()
+}
+
+// get returns the current value for g.
+//
+// false is returned if the sample for g must be omitted from the output, since the callback passed to NewFallibleGauge failed.
+func (g *Gauge) get() (float64, bool) {
+	if g.fErr == nil {
+		return g.Get(), true
+	}
+	v, err := g.fErr.call()
+	if err != nil {
+		return v, isGaugeErrorsAsNaNEnabled()
+	}
+	return v, true
+}
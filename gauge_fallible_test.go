@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFallibleGauge(t *testing.T) {
+	s := NewSet()
+	var err error
+	value := 1.5
+	g := s.NewFallibleGauge(`fallible{a="b"}`, func() (float64, error) {
+		if err != nil {
+			return 0, err
+		}
+		return value, nil
+	})
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// Successful callback
+	f(`fallible{a="b"} 1.5
+fallible_collect_errors_total{a="b"} 0
+`)
+	if v := g.Get(); v != 1.5 {
+		t.Fatalf("unexpected gauge value; got %v; want %v", v, 1.5)
+	}
+
+	// The sample is omitted on error
+	err = fmt.Errorf("cannot read file")
+	f(`fallible_collect_errors_total{a="b"} 1
+`)
+	if v := g.Get(); !math.IsNaN(v) {
+		t.Fatalf("unexpected gauge value; got %v; want NaN", v)
+	}
+
+	// NaN is written on error
+	SetGaugeErrorsAsNaN(true)
+	f(`fallible{a="b"} NaN
+fallible_collect_errors_total{a="b"} 3
+`)
+	SetGaugeErrorsAsNaN(false)
+
+	// The gauge recovers after the error
+	err = nil
+	value = 2
+	f(`fallible{a="b"} 2
+fallible_collect_errors_total{a="b"} 3
+`)
+}
+
+func TestFallibleGaugePanic(t *testing.T) {
+	var mu sync.Mutex
+	var names []string
+	var errs []error
+	SetGaugeErrorHandler(func(name string, err error) {
+		mu.Lock()
+		names = append(names, name)
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	defer SetGaugeErrorHandler(nil)
+
+	s := NewSet()
+	s.NewFallibleGauge("fallible_panic", func() (float64, error) {
+		panic("unexpected state")
+	})
+	s.NewGauge("fallible_panic_other", func() float64 { return 1 })
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `fallible_panic_collect_errors_total 1
+fallible_panic_other 1
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("unexpected number of errors passed to the handler; got %d; want %d", len(errs), 1)
+	}
+	if names[0] != "fallible_panic" {
+		t.Fatalf("unexpected gauge name passed to the handler; got %q; want %q", names[0], "fallible_panic")
+	}
+	if errExpected := "panic in gauge callback: unexpected state"; errs[0].Error() != errExpected {
+		t.Fatalf("unexpected error passed to the handler; got %q; want %q", errs[0], errExpected)
+	}
+}
+
+func TestFallibleGaugeError(t *testing.T) {
+	expectPanic(t, "NewFallibleGauge_nil_callback", func() {
+		NewSet().NewFallibleGauge("fallible_nil", nil)
+	})
+	expectPanic(t, "NewFallibleGauge_Set", func() {
+		g := NewSet().NewFallibleGauge("fallible_set", func() (float64, error) { return 1, nil })
+		g.Set(2)
+	})
+
+	fGauge := func() (float64, error) { return 1, nil }
+
+	// The errors counter mustn't leak if the gauge name is already registered
+	s := NewSet()
+	s.NewCounter("fallible_taken")
+	expectPanic(t, "NewFallibleGauge_taken_gauge_name", func() {
+		s.NewFallibleGauge("fallible_taken", fGauge)
+	})
+	if _, ok := s.GetMetric("fallible_taken_collect_errors_total"); ok {
+		t.Fatalf("unexpected errors counter registered for the gauge, which couldn't be registered")
+	}
+
+	// The panic must refer to the gauge if the errors counter name is already registered
+	s = NewSet()
+	s.NewCounter("fallible_other_collect_errors_total")
+	func() {
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatalf("expecting panic for already registered errors counter")
+			}
+			if err := r.(error); !strings.Contains(err.Error(), `gauge "fallible_other"`) {
+				t.Fatalf("the panic must refer to the gauge; got %q", err)
+			}
+		}()
+		s.NewFallibleGauge("fallible_other", fGauge)
+	}()
+	if _, ok := s.GetMetric("fallible_other"); ok {
+		t.Fatalf("unexpected gauge registered after the panic")
+	}
+}
@@ -1,9 +1,12 @@
 package metrics
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGaugeError(t *testing.T) {
@@ -84,6 +87,32 @@ func TestGaugeIncDecConcurrenc(t *testing.T) {
 	}
 }
 
+func TestGetOrCreateGaugeNilCallback(t *testing.T) {
+	s := NewSet()
+	g := s.GetOrCreateGauge(`foo{bar="baz"}`, nil)
+	g.Set(10)
+	if g2 := s.GetOrCreateGauge(`foo{bar="baz"}`, nil); g2 != g {
+		t.Fatalf("GetOrCreateGauge must return the same gauge for the same name")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g := s.GetOrCreateGauge(`foo{bar="baz"}`, nil)
+			for i := 0; i < 100; i++ {
+				g.Add(0.5)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := g.Get(); n != 260 {
+		t.Fatalf("unexpected gauge value %g; want 260", n)
+	}
+	testMarshalTo(t, g, "foo", "foo 260\n")
+}
+
 func TestGaugeSerial(t *testing.T) {
 	name := "GaugeSerial"
 	n := 1.23
@@ -131,3 +160,71 @@ func TestGaugeConcurrent(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestNewCachedGauge(t *testing.T) {
+	s := NewSet()
+	var calls uint64
+	f := func() float64 {
+		n := atomic.AddUint64(&calls, 1)
+		return float64(n)
+	}
+	g := s.NewCachedGauge("cached", time.Hour, f)
+	gNoCache := s.NewCachedGauge("not_cached", 0, f)
+
+	// The cached value is returned until ttl expires.
+	for i := 0; i < 3; i++ {
+		if v := g.Get(); v != 1 {
+			t.Fatalf("unexpected cached gauge value; got %v; want %v", v, 1)
+		}
+	}
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := "cached 1\nnot_cached 2\n"
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Zero ttl disables caching.
+	if v := gNoCache.Get(); v != 3 {
+		t.Fatalf("unexpected gauge value without cache; got %v; want %v", v, 3)
+	}
+}
+
+func TestNewCachedGaugeExpiration(t *testing.T) {
+	s := NewSet()
+	var calls uint64
+	g := s.NewCachedGauge("cached", time.Millisecond, func() float64 {
+		return float64(atomic.AddUint64(&calls, 1))
+	})
+	if v := g.Get(); v != 1 {
+		t.Fatalf("unexpected gauge value; got %v; want %v", v, 1)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if v := g.Get(); v != 2 {
+		t.Fatalf("unexpected gauge value after ttl expiration; got %v; want %v", v, 2)
+	}
+}
+
+func TestNewCachedGaugeConcurrent(t *testing.T) {
+	s := NewSet()
+	var calls uint64
+	g := s.NewCachedGauge("cached", time.Hour, func() float64 {
+		n := atomic.AddUint64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return float64(n)
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v := g.Get(); v != 1 {
+				panic(fmt.Errorf("unexpected gauge value; got %v; want %v", v, 1))
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadUint64(&calls); n != 1 {
+		t.Fatalf("unexpected number of callback calls; got %d; want %d", n, 1)
+	}
+}
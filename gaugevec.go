@@ -0,0 +1,61 @@
+package metrics
+
+// GaugeVec is a collection of gauges with the same name and distinct values for the given label names.
+//
+// GaugeVec is safe to use from concurrent goroutines.
+type GaugeVec struct {
+	mv *metricVec
+}
+
+// NewGaugeVec returns new GaugeVec with the given name and labelNames.
+//
+// name must be valid Prometheus-compatible metric name without labels.
+// labelNames must be valid Prometheus-compatible label names.
+//
+// Gauges for the particular label values are created and registered in the default set
+// on the first GaugeVec.WithLabelValues call.
+func NewGaugeVec(name string, labelNames []string) *GaugeVec {
+	return defaultSet.NewGaugeVec(name, labelNames)
+}
+
+// NewGaugeVec returns new GaugeVec with the given name and labelNames, which registers gauges in s.
+//
+// See NewGaugeVec for details.
+func (s *Set) NewGaugeVec(name string, labelNames []string) *GaugeVec {
+	newMetric := func(name string) metric {
+		return s.NewGauge(name, nil)
+	}
+	return &GaugeVec{
+		mv: newMetricVec(s, name, labelNames, newMetric),
+	}
+}
+
+// WithLabelValues returns the gauge for the given label values.
+//
+// The number of values must match the number of label names passed to NewGaugeVec.
+// The gauge is created and registered on the first call for the given values.
+// Subsequent calls for the same values return the same gauge without memory allocations.
+//
+// The returned gauge is created with nil callback, so its value may be changed via Gauge.Set, Gauge.Add, etc.
+func (gv *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return gv.mv.withLabelValues(values).(*Gauge)
+}
+
+// DeleteLabelValues unregisters the gauge for the given label values.
+//
+// This is useful for removing stale gauges, such as gauges for closed connections.
+//
+// True is returned if the gauge has been unregistered.
+func (gv *GaugeVec) DeleteLabelValues(values ...string) bool {
+	return gv.mv.deleteLabelValues(values)
+}
+
+// Reset unregisters all the gauges in gv.
+func (gv *GaugeVec) Reset() {
+	gv.mv.reset()
+}
+
+// Len returns the number of gauges in gv.
+func (gv *GaugeVec) Len() int {
+	return gv.mv.len()
+}
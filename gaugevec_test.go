@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestGaugeVec(t *testing.T) {
+	s := NewSet()
+	gv := s.NewGaugeVec("queue_size", []string{"queue"})
+	gv.WithLabelValues("a").Set(10)
+	gv.WithLabelValues("b").Inc()
+	gv.WithLabelValues("a").Add(-2)
+
+	if n := gv.Len(); n != 2 {
+		t.Fatalf("unexpected number of gauges; got %d; want 2", n)
+	}
+	if v := gv.WithLabelValues("a").Get(); v != 8 {
+		t.Fatalf("unexpected gauge value; got %v; want 8", v)
+	}
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `queue_size{queue="a"} 8
+queue_size{queue="b"} 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	if !gv.DeleteLabelValues("a") {
+		t.Fatalf("expecting the gauge to be deleted")
+	}
+	if n := gv.Len(); n != 1 {
+		t.Fatalf("unexpected number of gauges after deletion; got %d; want 1", n)
+	}
+
+	gv.Reset()
+	if n := gv.Len(); n != 0 {
+		t.Fatalf("unexpected number of gauges after reset; got %d; want 0", n)
+	}
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if bb.Len() > 0 {
+		t.Fatalf("unexpected output after reset: %q", bb.String())
+	}
+}
+
+func TestGaugeVecConcurrentSameValues(t *testing.T) {
+	s := NewSet()
+	gv := s.NewGaugeVec("conns", []string{"remote_addr"})
+	const workers = 10
+	gauges := make([]*Gauge, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gauges[i] = gv.WithLabelValues("1.2.3.4")
+		}(i)
+	}
+	wg.Wait()
+	for i, g := range gauges {
+		if g != gauges[0] {
+			t.Fatalf("unexpected gauge instance returned at worker #%d", i)
+		}
+	}
+}
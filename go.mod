@@ -1,10 +1,10 @@
 module github.com/VictoriaMetrics/metrics
 
 require (
+	github.com/golang/snappy v0.0.4
+	github.com/valyala/fastrand v1.1.0
 	github.com/valyala/histogram v1.2.0
 	golang.org/x/sys v0.15.0
 )
 
-require github.com/valyala/fastrand v1.1.0 // indirect
-
 go 1.17
@@ -5,9 +5,11 @@ import (
 	"io"
 	"log"
 	"math"
+	"os"
 	"runtime"
 	runtimemetrics "runtime/metrics"
 	"strings"
+	"sync"
 
 	"github.com/valyala/histogram"
 )
@@ -42,6 +44,118 @@ func initSupportedRuntimeMetrics(rms [][2]string) [][2]string {
 	return supportedMetrics
 }
 
+// WriteGoRuntimeMetrics writes all the metrics exposed by runtime/metrics package to w in Prometheus text exposition format.
+//
+// Metric names are converted into Prometheus-compatible names. For example, `/gc/heap/allocs:bytes`
+// is exposed as `go_gc_heap_allocs_bytes_total`. Cumulative metrics are exposed as counters with `_total` suffix,
+// while Float64Histogram metrics are exposed as histograms with `le` buckets.
+//
+// Metrics, which are already exposed by WriteProcessMetrics such as `go_sched_latencies_seconds`, are skipped,
+// so WriteGoRuntimeMetrics can be called together with WriteProcessMetrics.
+// Metrics of unsupported kinds are skipped, so new Go releases cannot break the output.
+//
+// The metrics are sampled at every WriteGoRuntimeMetrics call.
+func WriteGoRuntimeMetrics(w io.Writer) {
+	samples := make([]runtimemetrics.Sample, len(allRuntimeMetrics))
+	for i, rm := range allRuntimeMetrics {
+		samples[i].Name = rm.name
+	}
+	runtimemetrics.Read(samples)
+	for i := range samples {
+		writeGoRuntimeMetric(w, &allRuntimeMetrics[i], &samples[i])
+	}
+}
+
+type goRuntimeMetric struct {
+	// name is the name of runtime/metrics metric such as `/gc/heap/allocs:bytes`.
+	name string
+
+	// metricName is Prometheus-compatible metric name such as `go_gc_heap_allocs_bytes_total`.
+	metricName string
+
+	cumulative bool
+}
+
+var allRuntimeMetrics = initAllRuntimeMetrics()
+
+func initAllRuntimeMetrics() []goRuntimeMetric {
+	skipMetrics := make(map[string]struct{}, len(runtimeMetrics))
+	for _, rm := range runtimeMetrics {
+		skipMetrics[rm[0]] = struct{}{}
+	}
+	var rms []goRuntimeMetric
+	for _, d := range runtimemetrics.All() {
+		if _, ok := skipMetrics[d.Name]; ok {
+			continue
+		}
+		switch d.Kind {
+		case runtimemetrics.KindUint64, runtimemetrics.KindFloat64, runtimemetrics.KindFloat64Histogram:
+		default:
+			continue
+		}
+		rms = append(rms, goRuntimeMetric{
+			name:       d.Name,
+			metricName: getGoRuntimeMetricName(d.Name, d.Cumulative && d.Kind != runtimemetrics.KindFloat64Histogram),
+			cumulative: d.Cumulative,
+		})
+	}
+	return rms
+}
+
+// getGoRuntimeMetricName converts runtime/metrics name such as `/gc/heap/allocs:bytes` into Prometheus-compatible metric name.
+//
+// `_total` suffix is added to the returned name if isCounter is set.
+func getGoRuntimeMetricName(name string, isCounter bool) string {
+	path := name
+	unit := ""
+	if n := strings.LastIndexByte(name, ':'); n >= 0 {
+		path = name[:n]
+		unit = name[n+1:]
+	}
+	var sb strings.Builder
+	sb.WriteString("go")
+	sb.WriteString(sanitizeGoRuntimeMetricName(path))
+	if unit != "" {
+		sb.WriteByte('_')
+		unit = strings.ReplaceAll(unit, "/", "_per_")
+		sb.WriteString(sanitizeGoRuntimeMetricName(unit))
+	}
+	if isCounter {
+		sb.WriteString("_total")
+	}
+	return sb.String()
+}
+
+func sanitizeGoRuntimeMetricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func writeGoRuntimeMetric(w io.Writer, rm *goRuntimeMetric, sample *runtimemetrics.Sample) {
+	switch sample.Value.Kind() {
+	case runtimemetrics.KindUint64:
+		if rm.cumulative {
+			WriteCounterUint64(w, rm.metricName, sample.Value.Uint64())
+		} else {
+			WriteGaugeUint64(w, rm.metricName, sample.Value.Uint64())
+		}
+	case runtimemetrics.KindFloat64:
+		if rm.cumulative {
+			WriteCounterFloat64(w, rm.metricName, sample.Value.Float64())
+		} else {
+			WriteGaugeFloat64(w, rm.metricName, sample.Value.Float64())
+		}
+	case runtimemetrics.KindFloat64Histogram:
+		writeRuntimeHistogramMetric(w, rm.metricName, sample.Value.Float64Histogram())
+	default:
+		// Skip metrics of unsupported kinds, including KindBad for metrics unsupported by the current Go runtime.
+	}
+}
+
 func writeGoMetrics(w io.Writer) {
 	writeRuntimeMetrics(w)
 
@@ -95,14 +209,54 @@ func writeGoMetrics(w io.Writer) {
 	WriteGaugeUint64(w, "go_goroutines", uint64(runtime.NumGoroutine()))
 	numThread, _ := runtime.ThreadCreateProfile(nil)
 	WriteGaugeUint64(w, "go_threads", uint64(numThread))
+}
+
+// writeGoInfoMetrics writes go_info, go_info_ext and process_pid metrics with the build and the process details to w.
+//
+// If hostnameLabel isn't empty, then the label with the given name and the hostname value is added to go_info and process_pid.
+func writeGoInfoMetrics(w io.Writer, hostnameLabel string) {
+	var labels string
+	if hostnameLabel != "" {
+		labels = hostnameLabel + `="` + string(appendEscapedLabelValue(nil, getHostname())) + `"`
+	}
 
-	// Export build details.
 	WriteMetadataIfNeeded(w, "go_info", "gauge")
-	fmt.Fprintf(w, "go_info{version=%q} 1\n", runtime.Version())
+	if labels != "" {
+		fmt.Fprintf(w, "go_info{version=%q,%s} 1\n", runtime.Version(), labels)
+	} else {
+		fmt.Fprintf(w, "go_info{version=%q} 1\n", runtime.Version())
+	}
 
 	WriteMetadataIfNeeded(w, "go_info_ext", "gauge")
 	fmt.Fprintf(w, "go_info_ext{compiler=%q, GOARCH=%q, GOOS=%q, GOROOT=%q} 1\n",
 		runtime.Compiler, runtime.GOARCH, runtime.GOOS, runtime.GOROOT())
+
+	name := "process_pid"
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	WriteMetadataIfNeeded(w, "process_pid", "gauge")
+	writeSampleUint64(w, name, uint64(os.Getpid()), nil)
+}
+
+var (
+	hostname     string
+	hostnameOnce sync.Once
+)
+
+// getHostname returns the cached hostname of the current host.
+//
+// An empty string is returned if the hostname cannot be obtained.
+func getHostname() string {
+	hostnameOnce.Do(func() {
+		h, err := os.Hostname()
+		if err != nil {
+			log.Printf("ERROR: metrics: cannot determine hostname: %s", err)
+			return
+		}
+		hostname = h
+	})
+	return hostname
 }
 
 func writeRuntimeMetrics(w io.Writer) {
@@ -149,6 +303,7 @@ func writeRuntimeHistogramMetric(w io.Writer, name string, h *runtimemetrics.Flo
 	if len(buckets) != len(counts)+1 {
 		panic(fmt.Errorf("the number of buckets must be bigger than the number of counts by 1 in histogram %s; got buckets=%d, counts=%d", name, len(buckets), len(counts)))
 	}
+	sum := getRuntimeHistogramSum(buckets, counts)
 	tailCount := uint64(0)
 	if strings.HasSuffix(name, "_seconds") {
 		// Limit the maximum bucket to 1 second, since Go runtime exposes buckets with 10K seconds,
@@ -178,6 +333,37 @@ func writeRuntimeHistogramMetric(w io.Writer, name string, h *runtimemetrics.Flo
 	}
 	totalCount += tailCount
 	fmt.Fprintf(w, `%s_bucket{le="+Inf"} %d`+"\n", name, totalCount)
+	// The sum is estimated from buckets. See getRuntimeHistogramSum.
+	fmt.Fprintf(w, "%s_sum %g\n", name, sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, totalCount)
+}
+
+// getRuntimeHistogramSum returns an estimation for the sum of values in the runtime histogram with the given buckets and counts.
+//
+// The returned value is an estimate, not the exact sum. Go runtime doesn't track the sum of values, so the middle of every bucket
+// is used as the value for all the samples in the bucket. The finite bound is used for buckets with -Inf lower bound or +Inf upper bound.
+// Go runtime buckets are fine-grained, so the estimate is good enough for rate(_sum)/rate(_count) queries.
+func getRuntimeHistogramSum(buckets []float64, counts []uint64) float64 {
+	sum := 0.0
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		lower := buckets[i]
+		upper := buckets[i+1]
+		v := (lower + upper) / 2
+		if math.IsInf(lower, -1) {
+			v = upper
+		}
+		if math.IsInf(upper, 1) {
+			v = lower
+		}
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			continue
+		}
+		sum += v * float64(count)
+	}
+	return sum
 }
 
 // Limit the number of buckets for Go runtime histograms in order to prevent from high cardinality issues at scraper side.
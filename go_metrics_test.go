@@ -14,6 +14,16 @@ func TestWriteRuntimeMetrics(t *testing.T) {
 	if n := bb.Len(); n == 0 {
 		t.Fatalf("unexpected empty runtime metrics")
 	}
+	result := bb.String()
+	for _, s := range []string{
+		"\ngo_sched_latencies_seconds_bucket{le=\"+Inf\"} ",
+		"\ngo_sched_latencies_seconds_sum ",
+		"\ngo_sched_latencies_seconds_count ",
+	} {
+		if !strings.Contains(result, s) {
+			t.Fatalf("missing %q in the output:\n%s", s, result)
+		}
+	}
 }
 
 func TestWriteRuntimeHistogramMetricOk(t *testing.T) {
@@ -35,6 +45,8 @@ func TestWriteRuntimeHistogramMetricOk(t *testing.T) {
 foo_bucket{le="3"} 3
 foo_bucket{le="4"} 6
 foo_bucket{le="+Inf"} 6
+foo_sum 17
+foo_count 6
 `)
 
 	f(&runtimemetrics.Float64Histogram{
@@ -44,6 +56,8 @@ foo_bucket{le="+Inf"} 6
 foo_bucket{le="3"} 25
 foo_bucket{le="4"} 26
 foo_bucket{le="+Inf"} 26
+foo_sum 66
+foo_count 26
 `)
 
 	f(&runtimemetrics.Float64Histogram{
@@ -59,6 +73,8 @@ foo_bucket{le="8"} 88
 foo_bucket{le="9"} 220
 foo_bucket{le="10"} 230
 foo_bucket{le="+Inf"} 230
+foo_sum 1695
+foo_count 230
 `)
 
 	f(&runtimemetrics.Float64Histogram{
@@ -67,5 +83,77 @@ foo_bucket{le="+Inf"} 230
 	}, `foo_bucket{le="4"} 1
 foo_bucket{le="5"} 6
 foo_bucket{le="+Inf"} 6
+foo_sum 26.5
+foo_count 6
 `)
 }
+
+func TestWriteRuntimeHistogramMetricSeconds(t *testing.T) {
+	// Buckets above 1 second must be merged into +Inf bucket, while -Inf and +Inf bounds must be handled properly.
+	h := &runtimemetrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 3, 4, 5},
+		Buckets: []float64{math.Inf(-1), 0.5, 1, 2, 10, math.Inf(1)},
+	}
+	var wOut strings.Builder
+	writeRuntimeHistogramMetric(&wOut, "foo_seconds", h)
+	result := wOut.String()
+	resultExpected := `foo_seconds_bucket{le="0.5"} 1
+foo_seconds_bucket{le="1"} 3
+foo_seconds_bucket{le="+Inf"} 15
+foo_seconds_sum 80.5
+foo_seconds_count 15
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestWriteGoRuntimeMetrics(t *testing.T) {
+	var bb bytes.Buffer
+	WriteGoRuntimeMetrics(&bb)
+	result := bb.String()
+	for _, s := range []string{
+		"\ngo_gc_heap_allocs_bytes_total ",
+		"\ngo_memory_classes_total_bytes ",
+		"\ngo_gc_heap_allocs_by_size_bytes_bucket{le=\"+Inf\"} ",
+	} {
+		if !strings.Contains(result, s) {
+			t.Fatalf("missing %q in the output:\n%s", s, result)
+		}
+	}
+
+	// Metrics exposed by WriteProcessMetrics must be skipped.
+	for _, rm := range runtimeMetrics {
+		if strings.Contains(result, "\n"+rm[1]) {
+			t.Fatalf("unexpected metric %q in the output", rm[1])
+		}
+	}
+
+	// All the samples must have valid names and values.
+	for _, line := range strings.Split(strings.TrimSpace(result), "\n") {
+		n := strings.LastIndexByte(line, ' ')
+		if n < 0 {
+			t.Fatalf("missing value in the line %q", line)
+		}
+		if err := validateMetric(line[:n]); err != nil {
+			t.Fatalf("invalid metric name in the line %q: %s", line, err)
+		}
+	}
+}
+
+func TestGetGoRuntimeMetricName(t *testing.T) {
+	f := func(name string, isCounter bool, resultExpected string) {
+		t.Helper()
+		result := getGoRuntimeMetricName(name, isCounter)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q; got %q; want %q", name, result, resultExpected)
+		}
+	}
+
+	f("/gc/heap/allocs:bytes", true, "go_gc_heap_allocs_bytes_total")
+	f("/memory/classes/total:bytes", false, "go_memory_classes_total_bytes")
+	f("/cpu/classes/gc/mark/assist:cpu-seconds", true, "go_cpu_classes_gc_mark_assist_cpu_seconds_total")
+	f("/godebug/non-default-behavior/http2client:events", true, "go_godebug_non_default_behavior_http2client_events_total")
+	f("/foo/bar:bytes/second", false, "go_foo_bar_bytes_per_second")
+	f("/foo/bar:byte*cpu-seconds", false, "go_foo_bar_byte_cpu_seconds")
+}
@@ -0,0 +1,300 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphiteOptions is the list of options, which may be applied to Graphite export and push functions.
+type GraphiteOptions struct {
+	// UseTags enables Graphite tags for metric labels.
+	//
+	// By default labels are converted to `.label_name.label_value` path segments.
+	// If UseTags is set, then labels are converted to `;label_name=label_value` tags.
+	// See https://graphite.readthedocs.io/en/latest/tags.html
+	UseTags bool
+
+	// Optional WaitGroup for waiting until all the push workers created with this WaitGroup are stopped.
+	WaitGroup *sync.WaitGroup
+}
+
+// WriteGraphite writes all the metrics from the default set and all the added sets to w in Graphite plaintext format.
+//
+// Every metric is written as `prefix.metric_name.label_name.label_value value timestamp` line,
+// where timestamp is the number of seconds since Unix epoch for the given now.
+// Histogram buckets and summary quantiles are written as separate paths with the corresponding `vmrange` and `quantile` segments.
+//
+// Chars, which aren't allowed in Graphite paths, are replaced with `_`.
+// Lines, which cannot be parsed from metrics written via RegisterMetricsWriter, are skipped.
+//
+// See https://graphite.readthedocs.io/en/latest/feeding-carbon.html#the-plaintext-protocol
+func WriteGraphite(w io.Writer, prefix string, now time.Time) {
+	WriteGraphiteWithOptions(w, prefix, now, nil)
+}
+
+// WriteGraphiteWithOptions writes all the metrics from the default set and all the added sets to w in Graphite plaintext format.
+//
+// opts may contain additional configuration options if non-nil.
+//
+// See WriteGraphite for details.
+func WriteGraphiteWithOptions(w io.Writer, prefix string, now time.Time, opts *GraphiteOptions) {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, false)
+	}
+	writeGraphite(w, prefix, now, writeMetrics, opts)
+}
+
+// WriteGraphite writes all the metrics from s to w in Graphite plaintext format.
+//
+// opts may contain additional configuration options if non-nil.
+//
+// See WriteGraphite for details.
+func (s *Set) WriteGraphite(w io.Writer, prefix string, now time.Time, opts *GraphiteOptions) {
+	writeGraphite(w, prefix, now, s.WritePrometheus, opts)
+}
+
+// InitGraphitePush sets up periodic push for globally registered metrics to Graphite at the given TCP addr with the given interval.
+//
+// prefix is prepended to all the metric names. It may be empty.
+//
+// The connection to addr is re-established on the next push if it is broken.
+// Push errors are logged and counted in `metrics_graphite_push_errors_total` metric exposed via WriteProcessMetrics.
+//
+// See also WriteGraphite.
+func InitGraphitePush(addr string, interval time.Duration, prefix string) error {
+	return InitGraphitePushWithOptions(context.Background(), addr, interval, prefix, nil)
+}
+
+// InitGraphitePushWithOptions sets up periodic push for globally registered metrics to Graphite at the given TCP addr with the given interval.
+//
+// The periodic push is stopped when ctx is canceled.
+// It is possible to wait until the background metrics push worker is stopped on a WaitGroup passed via opts.WaitGroup.
+//
+// opts may contain additional configuration options if non-nil.
+//
+// See InitGraphitePush for details.
+func InitGraphitePushWithOptions(ctx context.Context, addr string, interval time.Duration, prefix string, opts *GraphiteOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, false)
+	}
+	return initGraphitePush(ctx, addr, interval, prefix, writeMetrics, opts)
+}
+
+// InitGraphitePush sets up periodic push for metrics from s to Graphite at the given TCP addr with the given interval.
+//
+// The periodic push is stopped when ctx is canceled.
+//
+// opts may contain additional configuration options if non-nil.
+//
+// See InitGraphitePush for details.
+func (s *Set) InitGraphitePush(ctx context.Context, addr string, interval time.Duration, prefix string, opts *GraphiteOptions) error {
+	return initGraphitePush(ctx, addr, interval, prefix, s.WritePrometheus, opts)
+}
+
+func initGraphitePush(ctx context.Context, addr string, interval time.Duration, prefix string, writeMetrics func(w io.Writer), opts *GraphiteOptions) error {
+	if addr == "" {
+		return fmt.Errorf("addr cannot be empty")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive; got %s", interval)
+	}
+	gp := newGraphitePusher(addr, prefix, opts)
+
+	var wg *sync.WaitGroup
+	if opts != nil {
+		wg = opts.WaitGroup
+		if wg != nil {
+			wg.Add(1)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		stopCh := ctx.Done()
+		for {
+			select {
+			case <-ticker.C:
+				if err := gp.push(writeMetrics, interval); err != nil {
+					log.Printf("ERROR: metrics.graphite: %s", err)
+				}
+			case <-stopCh:
+				gp.close()
+				if wg != nil {
+					wg.Done()
+				}
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+type graphitePusher struct {
+	addr   string
+	prefix string
+	opts   *GraphiteOptions
+
+	// conn is the connection to addr. It is nil if the connection must be established on the next push.
+	conn net.Conn
+
+	pushesTotal *Counter
+	pushErrors  *Counter
+}
+
+func newGraphitePusher(addr, prefix string, opts *GraphiteOptions) *graphitePusher {
+	return &graphitePusher{
+		addr:        addr,
+		prefix:      prefix,
+		opts:        opts,
+		pushesTotal: pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_graphite_pushes_total{addr=%q}`, addr)),
+		pushErrors:  pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_graphite_push_errors_total{addr=%q}`, addr)),
+	}
+}
+
+func (gp *graphitePusher) push(writeMetrics func(w io.Writer), timeout time.Duration) error {
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+
+	writeGraphite(bb, gp.prefix, time.Now(), writeMetrics, gp.opts)
+
+	gp.pushesTotal.Inc()
+	err := gp.write(bb.B, timeout)
+	if err != nil && gp.conn == nil {
+		// The connection has been broken. Try re-establishing it once,
+		// since Graphite may close idle connections between pushes.
+		err = gp.write(bb.B, timeout)
+	}
+	if err != nil {
+		gp.pushErrors.Inc()
+		return err
+	}
+	return nil
+}
+
+func (gp *graphitePusher) write(data []byte, timeout time.Duration) error {
+	if gp.conn == nil {
+		conn, err := net.DialTimeout("tcp", gp.addr, timeout)
+		if err != nil {
+			return fmt.Errorf("cannot connect to Graphite at %q: %w", gp.addr, err)
+		}
+		gp.conn = conn
+	}
+	if err := gp.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		gp.close()
+		return fmt.Errorf("cannot set write deadline for connection to Graphite at %q: %w", gp.addr, err)
+	}
+	if _, err := gp.conn.Write(data); err != nil {
+		gp.close()
+		return fmt.Errorf("cannot send %d bytes to Graphite at %q: %w", len(data), gp.addr, err)
+	}
+	return nil
+}
+
+func (gp *graphitePusher) close() {
+	if gp.conn != nil {
+		_ = gp.conn.Close()
+		gp.conn = nil
+	}
+}
+
+func writeGraphite(w io.Writer, prefix string, now time.Time, writeMetrics func(w io.Writer), opts *GraphiteOptions) {
+	useTags := opts != nil && opts.UseTags
+
+	bbSrc := getBytesBuffer()
+	defer putBytesBuffer(bbSrc)
+	writeMetrics(bbSrc)
+
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+
+	prefix = strings.TrimSuffix(prefix, ".")
+	timestamp := now.Unix()
+	_ = forEachSample(bbSrc.B, func(ps *parsedSample) {
+		bb.B = appendGraphiteLine(bb.B, prefix, ps, timestamp, useTags)
+	})
+	w.Write(bb.B)
+}
+
+func appendGraphiteLine(dst []byte, prefix string, ps *parsedSample, timestamp int64, useTags bool) []byte {
+	if prefix != "" {
+		dst = append(dst, prefix...)
+		dst = append(dst, '.')
+	}
+	dst = appendGraphitePathSegment(dst, ps.metricName)
+	for _, l := range ps.labels {
+		if l.value == "" {
+			continue
+		}
+		if useTags {
+			dst = append(dst, ';')
+			dst = appendGraphiteTagName(dst, l.name)
+			dst = append(dst, '=')
+			dst = appendGraphiteTagValue(dst, l.value)
+		} else {
+			dst = append(dst, '.')
+			dst = appendGraphitePathSegment(dst, l.name)
+			dst = append(dst, '.')
+			dst = appendGraphitePathSegment(dst, l.value)
+		}
+	}
+	dst = append(dst, ' ')
+	dst = strconv.AppendFloat(dst, ps.value, 'g', -1, 64)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, timestamp, 10)
+	return append(dst, '\n')
+}
+
+// appendGraphitePathSegment appends s to dst, while replacing chars other than `[a-zA-Z0-9_:-]` with `_`.
+//
+// Dots are replaced too, since they delimit Graphite path segments.
+func appendGraphitePathSegment(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if !isGraphitePathChar(ch) {
+			ch = '_'
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
+
+func isGraphitePathChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == ':' || ch == '-'
+}
+
+// appendGraphiteTagName appends s to dst, while replacing chars, which aren't allowed in Graphite tag names, with `_`.
+func appendGraphiteTagName(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch ch {
+		case ';', '!', '^', '=', ' ', '\t', '\n':
+			ch = '_'
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
+
+// appendGraphiteTagValue appends s to dst, while replacing chars, which aren't allowed in Graphite tag values, with `_`.
+func appendGraphiteTagValue(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch ch {
+		case ';', ' ', '\t', '\n':
+			ch = '_'
+		case '~':
+			if i == 0 {
+				ch = '_'
+			}
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
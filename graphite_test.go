@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetWriteGraphite(t *testing.T) {
+	f := func(s *Set, prefix string, useTags bool, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WriteGraphite(&bb, prefix, time.Unix(1700000000, 0), &GraphiteOptions{
+			UseTags: useTags,
+		})
+		result := bb.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	s := NewSet()
+	s.NewCounter(`foo{path="/a b",empty=""}`).Add(3)
+	s.NewGauge("bar", func() float64 { return 1.5 })
+	f(s, "", false, `bar 1.5 1700000000
+foo.path._a_b 3 1700000000
+`)
+	f(s, "app.", false, `app.bar 1.5 1700000000
+app.foo.path._a_b 3 1700000000
+`)
+	f(s, "app", true, `app.bar 1.5 1700000000
+app.foo;path=/a_b 3 1700000000
+`)
+
+	s = NewSet()
+	h := s.NewHistogram(`h{x="y"}`)
+	h.Update(0.5)
+	sm := s.NewSummaryExt("s", time.Minute, []float64{0.5})
+	sm.Update(2)
+	f(s, "", false, `h_bucket.x.y.vmrange.4_642e-01___5_275e-01 1 1700000000
+h_sum.x.y 0.5 1700000000
+h_count.x.y 1 1700000000
+s_sum 2 1700000000
+s_count 1 1700000000
+s.quantile.0_5 2 1700000000
+`)
+	f(s, "", true, `h_bucket;x=y;vmrange=4.642e-01...5.275e-01 1 1700000000
+h_sum;x=y 0.5 1700000000
+h_count;x=y 1 1700000000
+s_sum 2 1700000000
+s_count 1 1700000000
+s;quantile=0.5 2 1700000000
+`)
+}
+
+func TestInitGraphitePush(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start listener: %s", err)
+	}
+	defer ln.Close()
+
+	linesCh := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Close the connection after the first line in order to verify reconnection.
+			r := bufio.NewReader(conn)
+			line, err := r.ReadString('\n')
+			_ = conn.Close()
+			if err == nil {
+				linesCh <- line
+			}
+		}
+	}()
+
+	s := NewSet()
+	s.NewCounter("foo").Inc()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	if err := s.InitGraphitePush(ctx, ln.Addr().String(), 10*time.Millisecond, "app", &GraphiteOptions{
+		WaitGroup: &wg,
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case line := <-linesCh:
+			if !bytes.HasPrefix([]byte(line), []byte("app.foo 1 ")) {
+				t.Fatalf("unexpected line: %q", line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout when waiting for pushed metrics")
+		}
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestInitGraphitePushFailure(t *testing.T) {
+	f := func(addr string, interval time.Duration) {
+		t.Helper()
+		if err := InitGraphitePush(addr, interval, ""); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f("", time.Second)
+	f("localhost:2003", 0)
+	f("localhost:2003", -time.Second)
+}
@@ -0,0 +1,26 @@
+module github.com/VictoriaMetrics/metrics/grpcmetrics
+
+go 1.19
+
+require (
+	github.com/VictoriaMetrics/metrics v1.32.0
+	google.golang.org/grpc v1.58.2
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/valyala/fastrand v1.1.0 // indirect
+	github.com/valyala/histogram v1.2.0 // indirect
+	golang.org/x/net v0.12.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
+
+// The replace directive builds the module against the local copy of github.com/VictoriaMetrics/metrics
+// during development in this repository. It is ignored when the module is used as a dependency,
+// so the require directive above must reference a released version of the root module with all the APIs used here.
+// Tag the root module before tagging this module after adding new root APIs to it.
+replace github.com/VictoriaMetrics/metrics => ../
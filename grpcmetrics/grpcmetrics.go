@@ -0,0 +1,404 @@
+// Package grpcmetrics provides gRPC client and server interceptors, which register metrics
+// for the handled RPCs via github.com/VictoriaMetrics/metrics package.
+//
+// The package is located in a separate module, so github.com/VictoriaMetrics/metrics users
+// do not depend on google.golang.org/grpc unless they need this package.
+package grpcmetrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultDurationBuckets contains upper bounds in seconds for handling duration histogram buckets,
+// which are used if Opts.DurationBuckets is empty.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Opts contains options for NewServerMetrics and NewClientMetrics.
+type Opts struct {
+	// Set is the set for registering metrics. The default set is used if Set is nil.
+	Set *metrics.Set
+
+	// DurationBuckets contains upper bounds in seconds for handling duration histogram buckets.
+	//
+	// DefaultDurationBuckets are used if DurationBuckets is empty.
+	DurationBuckets []float64
+}
+
+// ServerMetrics registers metrics for RPCs handled by gRPC server.
+//
+// The following metrics are registered:
+//
+//   - grpc_server_handled_total{grpc_service="...",grpc_method="...",grpc_code="..."} - the number of completed RPCs
+//   - grpc_server_handling_seconds{grpc_service="...",grpc_method="..."} - RPC handling duration histogram
+//   - grpc_server_msg_received_total{grpc_service="...",grpc_method="..."} - the number of received messages
+//   - grpc_server_msg_sent_total{grpc_service="...",grpc_method="..."} - the number of sent messages
+//
+// ServerMetrics is safe to use from concurrent goroutines.
+type ServerMetrics struct {
+	mc *metricsCache
+}
+
+// NewServerMetrics returns new ServerMetrics for the given opts.
+//
+// opts may be nil. In this case metrics are registered in the default set with DefaultDurationBuckets.
+func NewServerMetrics(opts *Opts) *ServerMetrics {
+	return &ServerMetrics{
+		mc: newMetricsCache("grpc_server", opts),
+	}
+}
+
+// UnaryServerInterceptor returns unary server interceptor, which registers metrics for the handled RPCs.
+//
+// Usage:
+//
+//	sm := grpcmetrics.NewServerMetrics(nil)
+//	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(sm.UnaryServerInterceptor()))
+func (sm *ServerMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		mm := sm.mc.getMethodMetrics(info.FullMethod)
+		startTime := time.Now()
+		mm.msgReceived.Inc()
+		resp, err := handler(ctx, req)
+		if err == nil {
+			mm.msgSent.Inc()
+		}
+		mm.observe(startTime, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns stream server interceptor, which registers metrics for the handled RPCs.
+//
+// Usage:
+//
+//	sm := grpcmetrics.NewServerMetrics(nil)
+//	srv := grpc.NewServer(grpc.ChainStreamInterceptor(sm.StreamServerInterceptor()))
+func (sm *ServerMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		mm := sm.mc.getMethodMetrics(info.FullMethod)
+		startTime := time.Now()
+		err := handler(srv, &serverStream{
+			ServerStream: ss,
+			mm:           mm,
+		})
+		mm.observe(startTime, err)
+		return err
+	}
+}
+
+// UnaryServerInterceptor returns unary server interceptor, which registers metrics in the default set.
+//
+// See ServerMetrics for details.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return getDefaultServerMetrics().UnaryServerInterceptor()
+}
+
+// StreamServerInterceptor returns stream server interceptor, which registers metrics in the default set.
+//
+// See ServerMetrics for details.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return getDefaultServerMetrics().StreamServerInterceptor()
+}
+
+var (
+	defaultServerMetricsOnce sync.Once
+	defaultServerMetrics     *ServerMetrics
+)
+
+func getDefaultServerMetrics() *ServerMetrics {
+	defaultServerMetricsOnce.Do(func() {
+		defaultServerMetrics = NewServerMetrics(nil)
+	})
+	return defaultServerMetrics
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	mm *methodMetrics
+}
+
+func (ss *serverStream) SendMsg(m interface{}) error {
+	err := ss.ServerStream.SendMsg(m)
+	if err == nil {
+		ss.mm.msgSent.Inc()
+	}
+	return err
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	err := ss.ServerStream.RecvMsg(m)
+	if err == nil {
+		ss.mm.msgReceived.Inc()
+	}
+	return err
+}
+
+// ClientMetrics registers metrics for RPCs performed by gRPC client.
+//
+// The following metrics are registered:
+//
+//   - grpc_client_handled_total{grpc_service="...",grpc_method="...",grpc_code="..."} - the number of completed RPCs
+//   - grpc_client_handling_seconds{grpc_service="...",grpc_method="..."} - RPC duration histogram
+//   - grpc_client_msg_received_total{grpc_service="...",grpc_method="..."} - the number of received messages
+//   - grpc_client_msg_sent_total{grpc_service="...",grpc_method="..."} - the number of sent messages
+//
+// Streaming RPCs are counted as completed when ClientStream.RecvMsg returns an error or io.EOF.
+//
+// ClientMetrics is safe to use from concurrent goroutines.
+type ClientMetrics struct {
+	mc *metricsCache
+}
+
+// NewClientMetrics returns new ClientMetrics for the given opts.
+//
+// opts may be nil. In this case metrics are registered in the default set with DefaultDurationBuckets.
+func NewClientMetrics(opts *Opts) *ClientMetrics {
+	return &ClientMetrics{
+		mc: newMetricsCache("grpc_client", opts),
+	}
+}
+
+// UnaryClientInterceptor returns unary client interceptor, which registers metrics for the performed RPCs.
+//
+// Usage:
+//
+//	cm := grpcmetrics.NewClientMetrics(nil)
+//	conn, err := grpc.Dial(addr, grpc.WithChainUnaryInterceptor(cm.UnaryClientInterceptor()))
+func (cm *ClientMetrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		mm := cm.mc.getMethodMetrics(method)
+		startTime := time.Now()
+		mm.msgSent.Inc()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			mm.msgReceived.Inc()
+		}
+		mm.observe(startTime, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns stream client interceptor, which registers metrics for the performed RPCs.
+//
+// Usage:
+//
+//	cm := grpcmetrics.NewClientMetrics(nil)
+//	conn, err := grpc.Dial(addr, grpc.WithChainStreamInterceptor(cm.StreamClientInterceptor()))
+func (cm *ClientMetrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		mm := cm.mc.getMethodMetrics(method)
+		startTime := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			mm.observe(startTime, err)
+			return nil, err
+		}
+		return &clientStream{
+			ClientStream: cs,
+			mm:           mm,
+			startTime:    startTime,
+		}, nil
+	}
+}
+
+// UnaryClientInterceptor returns unary client interceptor, which registers metrics in the default set.
+//
+// See ClientMetrics for details.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return getDefaultClientMetrics().UnaryClientInterceptor()
+}
+
+// StreamClientInterceptor returns stream client interceptor, which registers metrics in the default set.
+//
+// See ClientMetrics for details.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return getDefaultClientMetrics().StreamClientInterceptor()
+}
+
+var (
+	defaultClientMetricsOnce sync.Once
+	defaultClientMetrics     *ClientMetrics
+)
+
+func getDefaultClientMetrics() *ClientMetrics {
+	defaultClientMetricsOnce.Do(func() {
+		defaultClientMetrics = NewClientMetrics(nil)
+	})
+	return defaultClientMetrics
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	mm        *methodMetrics
+	startTime time.Time
+
+	// finished is set to 1 when the RPC completion is registered.
+	finished uint32
+}
+
+func (cs *clientStream) SendMsg(m interface{}) error {
+	err := cs.ClientStream.SendMsg(m)
+	if err == nil {
+		cs.mm.msgSent.Inc()
+	} else {
+		cs.finish(err)
+	}
+	return err
+}
+
+func (cs *clientStream) RecvMsg(m interface{}) error {
+	err := cs.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		cs.mm.msgReceived.Inc()
+	case err == io.EOF:
+		cs.finish(nil)
+	default:
+		cs.finish(err)
+	}
+	return err
+}
+
+func (cs *clientStream) finish(err error) {
+	if atomic.CompareAndSwapUint32(&cs.finished, 0, 1) {
+		cs.mm.observe(cs.startTime, err)
+	}
+}
+
+// metricsCache contains metrics per gRPC method.
+type metricsCache struct {
+	prefix          string
+	s               *metrics.Set
+	durationBuckets []float64
+
+	// m maps full method name to *methodMetrics.
+	m sync.Map
+}
+
+func newMetricsCache(prefix string, opts *Opts) *metricsCache {
+	if opts == nil {
+		opts = &Opts{}
+	}
+	s := opts.Set
+	if s == nil {
+		s = metrics.GetDefaultSet()
+	}
+	durationBuckets := opts.DurationBuckets
+	if len(durationBuckets) == 0 {
+		durationBuckets = DefaultDurationBuckets
+	}
+	return &metricsCache{
+		prefix:          prefix,
+		s:               s,
+		durationBuckets: append([]float64{}, durationBuckets...),
+	}
+}
+
+// getMethodMetrics returns metrics for the given fullMethod.
+//
+// Metrics for already seen methods are returned without memory allocations.
+func (mc *metricsCache) getMethodMetrics(fullMethod string) *methodMetrics {
+	if v, ok := mc.m.Load(fullMethod); ok {
+		return v.(*methodMetrics)
+	}
+
+	// Slow path - register metrics for the new method.
+	service, method := splitFullMethod(fullMethod)
+	name := func(suffix string) string {
+		return metrics.BuildNameOrdered(mc.prefix+suffix, "grpc_service", service, "grpc_method", method)
+	}
+	mm := &methodMetrics{
+		mc:          mc,
+		service:     service,
+		method:      method,
+		duration:    mc.s.GetOrCreateHistogramWithBuckets(name("_handling_seconds"), mc.durationBuckets),
+		msgReceived: mc.s.GetOrCreateCounter(name("_msg_received_total")),
+		msgSent:     mc.s.GetOrCreateCounter(name("_msg_sent_total")),
+	}
+	v, _ := mc.m.LoadOrStore(fullMethod, mm)
+	return v.(*methodMetrics)
+}
+
+// splitFullMethod splits fullMethod in the form `/package.Service/Method` into service and method names.
+func splitFullMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	n := strings.IndexByte(fullMethod, '/')
+	if n < 0 {
+		return "unknown", "unknown"
+	}
+	return fullMethod[:n], fullMethod[n+1:]
+}
+
+// numCodes is the number of gRPC status codes defined in google.golang.org/grpc/codes.
+const numCodes = int(codes.Unauthenticated) + 1
+
+// methodMetrics contains metrics for a single gRPC method.
+type methodMetrics struct {
+	mc      *metricsCache
+	service string
+	method  string
+
+	duration    *metrics.PrometheusHistogram
+	msgReceived *metrics.Counter
+	msgSent     *metrics.Counter
+
+	// handled contains lazily created grpc_*_handled_total counters per status code.
+	handled [numCodes]atomic.Pointer[metrics.Counter]
+}
+
+// observe registers the completion of RPC started at startTime with the given err.
+func (mm *methodMetrics) observe(startTime time.Time, err error) {
+	mm.getHandledCounter(getCode(err)).Inc()
+	mm.duration.UpdateDuration(startTime)
+}
+
+func (mm *methodMetrics) getHandledCounter(code codes.Code) *metrics.Counter {
+	if int(code) >= len(mm.handled) {
+		code = codes.Unknown
+	}
+	p := &mm.handled[code]
+	if c := p.Load(); c != nil {
+		return c
+	}
+	name := metrics.BuildNameOrdered(mm.mc.prefix+"_handled_total", "grpc_service", mm.service, "grpc_method", mm.method, "grpc_code", code.String())
+	c := mm.mc.s.GetOrCreateCounter(name)
+	p.Store(c)
+	return c
+}
+
+// getCode returns gRPC status code for the given err.
+//
+// Errors wrapping gRPC status are supported. Context errors, which aren't converted to gRPC status,
+// are mapped to codes.Canceled and codes.DeadlineExceeded.
+func getCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	var se interface {
+		GRPCStatus() *status.Status
+	}
+	if errors.As(err, &se) {
+		if st := se.GRPCStatus(); st != nil {
+			return st.Code()
+		}
+		return codes.Unknown
+	}
+	if errors.Is(err, context.Canceled) {
+		return codes.Canceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+	return codes.Unknown
+}
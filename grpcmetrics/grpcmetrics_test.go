@@ -0,0 +1,259 @@
+package grpcmetrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	s := metrics.NewSet()
+	sm := NewServerMetrics(&Opts{
+		Set:             s,
+		DurationBuckets: []float64{1},
+	})
+	interceptor := sm.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{
+		FullMethod: "/pkg.Greeter/SayHello",
+	}
+	f := func(err error) {
+		t.Helper()
+		_, errResult := interceptor(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "resp", err
+		})
+		if errResult != err {
+			t.Fatalf("unexpected error; got %v; want %v", errResult, err)
+		}
+	}
+	f(nil)
+	f(nil)
+	f(status.Error(codes.NotFound, "missing"))
+	f(fmt.Errorf("wrapped: %w", status.Error(codes.NotFound, "missing")))
+	f(context.Canceled)
+
+	expectMetrics(t, s, []string{
+		`grpc_server_handled_total{grpc_code="Canceled",grpc_method="SayHello",grpc_service="pkg.Greeter"} 1`,
+		`grpc_server_handled_total{grpc_code="NotFound",grpc_method="SayHello",grpc_service="pkg.Greeter"} 2`,
+		`grpc_server_handled_total{grpc_code="OK",grpc_method="SayHello",grpc_service="pkg.Greeter"} 2`,
+		`grpc_server_handling_seconds_bucket{grpc_method="SayHello",grpc_service="pkg.Greeter",le="1"} 5`,
+		`grpc_server_handling_seconds_bucket{grpc_method="SayHello",grpc_service="pkg.Greeter",le="+Inf"} 5`,
+		`grpc_server_handling_seconds_count{grpc_method="SayHello",grpc_service="pkg.Greeter"} 5`,
+		`grpc_server_msg_received_total{grpc_method="SayHello",grpc_service="pkg.Greeter"} 5`,
+		`grpc_server_msg_sent_total{grpc_method="SayHello",grpc_service="pkg.Greeter"} 2`,
+	})
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	s := metrics.NewSet()
+	sm := NewServerMetrics(&Opts{
+		Set: s,
+	})
+	interceptor := sm.StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{
+		FullMethod: "/pkg.Chat/Talk",
+	}
+	ss := &fakeServerStream{
+		recvMsgs: 3,
+	}
+	err := interceptor(nil, ss, info, func(srv interface{}, stream grpc.ServerStream) error {
+		for {
+			if err := stream.RecvMsg(nil); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			if err := stream.SendMsg(nil); err != nil {
+				return err
+			}
+		}
+		return status.Error(codes.Internal, "oops")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectMetrics(t, s, []string{
+		`grpc_server_handled_total{grpc_code="Internal",grpc_method="Talk",grpc_service="pkg.Chat"} 1`,
+		`grpc_server_handling_seconds_count{grpc_method="Talk",grpc_service="pkg.Chat"} 1`,
+		`grpc_server_msg_received_total{grpc_method="Talk",grpc_service="pkg.Chat"} 3`,
+		`grpc_server_msg_sent_total{grpc_method="Talk",grpc_service="pkg.Chat"} 3`,
+	})
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	s := metrics.NewSet()
+	cm := NewClientMetrics(&Opts{
+		Set: s,
+	})
+	interceptor := cm.UnaryClientInterceptor()
+	f := func(err error) {
+		t.Helper()
+		errResult := interceptor(context.Background(), "/pkg.Greeter/SayHello", "req", nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return err
+		})
+		if errResult != err {
+			t.Fatalf("unexpected error; got %v; want %v", errResult, err)
+		}
+	}
+	f(nil)
+	f(status.Error(codes.Unavailable, "unavailable"))
+	f(fmt.Errorf("deadline: %w", context.DeadlineExceeded))
+
+	expectMetrics(t, s, []string{
+		`grpc_client_handled_total{grpc_code="DeadlineExceeded",grpc_method="SayHello",grpc_service="pkg.Greeter"} 1`,
+		`grpc_client_handled_total{grpc_code="OK",grpc_method="SayHello",grpc_service="pkg.Greeter"} 1`,
+		`grpc_client_handled_total{grpc_code="Unavailable",grpc_method="SayHello",grpc_service="pkg.Greeter"} 1`,
+		`grpc_client_handling_seconds_count{grpc_method="SayHello",grpc_service="pkg.Greeter"} 3`,
+		`grpc_client_msg_received_total{grpc_method="SayHello",grpc_service="pkg.Greeter"} 1`,
+		`grpc_client_msg_sent_total{grpc_method="SayHello",grpc_service="pkg.Greeter"} 3`,
+	})
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	s := metrics.NewSet()
+	cm := NewClientMetrics(&Opts{
+		Set: s,
+	})
+	interceptor := cm.StreamClientInterceptor()
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{
+			recvMsgs: 2,
+		}, nil
+	}
+	cs, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/pkg.Chat/Listen", streamer)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := cs.SendMsg(nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for {
+		if err := cs.RecvMsg(nil); err != nil {
+			if err != io.EOF {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			break
+		}
+	}
+	// Repeated RecvMsg calls mustn't register the RPC completion again.
+	if err := cs.RecvMsg(nil); err != io.EOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Failed stream creation
+	streamerErr := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	}
+	if _, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/pkg.Chat/Listen", streamerErr); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	expectMetrics(t, s, []string{
+		`grpc_client_handled_total{grpc_code="OK",grpc_method="Listen",grpc_service="pkg.Chat"} 1`,
+		`grpc_client_handled_total{grpc_code="PermissionDenied",grpc_method="Listen",grpc_service="pkg.Chat"} 1`,
+		`grpc_client_handling_seconds_count{grpc_method="Listen",grpc_service="pkg.Chat"} 2`,
+		`grpc_client_msg_received_total{grpc_method="Listen",grpc_service="pkg.Chat"} 2`,
+		`grpc_client_msg_sent_total{grpc_method="Listen",grpc_service="pkg.Chat"} 1`,
+	})
+}
+
+func TestGetCode(t *testing.T) {
+	f := func(err error, codeExpected codes.Code) {
+		t.Helper()
+		if code := getCode(err); code != codeExpected {
+			t.Fatalf("unexpected code for %v; got %s; want %s", err, code, codeExpected)
+		}
+	}
+	f(nil, codes.OK)
+	f(status.Error(codes.NotFound, "missing"), codes.NotFound)
+	f(fmt.Errorf("foo: %w", fmt.Errorf("bar: %w", status.Error(codes.Aborted, "aborted"))), codes.Aborted)
+	f(context.Canceled, codes.Canceled)
+	f(fmt.Errorf("foo: %w", context.DeadlineExceeded), codes.DeadlineExceeded)
+	f(status.Error(codes.Unavailable, context.Canceled.Error()), codes.Unavailable)
+	f(errors.New("foo"), codes.Unknown)
+}
+
+func TestSplitFullMethod(t *testing.T) {
+	f := func(fullMethod, serviceExpected, methodExpected string) {
+		t.Helper()
+		service, method := splitFullMethod(fullMethod)
+		if service != serviceExpected || method != methodExpected {
+			t.Fatalf("unexpected result for %q; got %q, %q; want %q, %q", fullMethod, service, method, serviceExpected, methodExpected)
+		}
+	}
+	f("/pkg.Service/Method", "pkg.Service", "Method")
+	f("Service/Method", "Service", "Method")
+	f("foo", "unknown", "unknown")
+	f("", "unknown", "unknown")
+}
+
+func TestGetMethodMetricsNoAllocs(t *testing.T) {
+	mc := newMetricsCache("grpc_server", &Opts{
+		Set: metrics.NewSet(),
+	})
+	mc.getMethodMetrics("/pkg.Greeter/SayHello").observe(time.Now(), nil)
+	n := testing.AllocsPerRun(100, func() {
+		mm := mc.getMethodMetrics("/pkg.Greeter/SayHello")
+		mm.getHandledCounter(codes.OK).Inc()
+	})
+	if n != 0 {
+		t.Fatalf("unexpected number of allocations; got %v; want 0", n)
+	}
+}
+
+func expectMetrics(t *testing.T, s *metrics.Set, lines []string) {
+	t.Helper()
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	for _, line := range lines {
+		if !strings.Contains(result, line+"\n") {
+			t.Fatalf("missing %q in the output\n%s", line, result)
+		}
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	recvMsgs int
+}
+
+func (ss *fakeServerStream) RecvMsg(m interface{}) error {
+	if ss.recvMsgs <= 0 {
+		return io.EOF
+	}
+	ss.recvMsgs--
+	return nil
+}
+
+func (ss *fakeServerStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+	recvMsgs int
+}
+
+func (cs *fakeClientStream) RecvMsg(m interface{}) error {
+	if cs.recvMsgs <= 0 {
+		return io.EOF
+	}
+	cs.recvMsgs--
+	return nil
+}
+
+func (cs *fakeClientStream) SendMsg(m interface{}) error {
+	return nil
+}
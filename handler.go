@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HandlerOpts contains options for Handler.
+type HandlerOpts struct {
+	// ExposeProcessMetrics enables exposing `go_*` and `process_*` metrics for the current process.
+	ExposeProcessMetrics bool
+
+	// MaxRequestsInFlight limits the number of concurrently served requests.
+	//
+	// Requests exceeding the limit are rejected with `503 Service Unavailable` status code.
+	// The number of concurrent requests isn't limited if MaxRequestsInFlight is zero.
+	MaxRequestsInFlight int
+
+	// DisableCompression disables gzip compression of responses.
+	DisableCompression bool
+
+	// MinCompressSize is the minimum response size in bytes for gzip compression.
+	//
+	// Smaller responses are sent uncompressed, since compression doesn't save bandwidth for them.
+	// 1KiB is used if MinCompressSize is zero.
+	MinCompressSize int
+
+	// MaxResponseBytes limits the size of uncompressed responses.
+	//
+	// Responses exceeding the limit are truncated at sample boundaries. The trailing comment about the truncation is added
+	// to the truncated responses in Prometheus text exposition format. The size of responses isn't limited if MaxResponseBytes is zero.
+	MaxResponseBytes int
+}
+
+const defaultMinCompressSize = 1024
+
+// Handler returns http.Handler, which exposes metrics from the default set and all the added sets.
+//
+// The metrics are exposed in OpenMetrics text format if the client prefers `application/openmetrics-text`
+// in the Accept request header. The metrics are exposed in Prometheus protobuf format if the client prefers
+// `application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited`.
+// This is needed for exposing NativeHistogram as Prometheus native histogram.
+// The metrics are exposed in JSON if the client prefers `application/json` or if `?format=json` query arg is set.
+// See WriteJSON for details. Otherwise the metrics are exposed in Prometheus text exposition format.
+//
+// The response is compressed with gzip if the client accepts it in the Accept-Encoding request header
+// and the response size exceeds HandlerOpts.MinCompressSize.
+// HEAD requests are responded with headers only.
+//
+// Prometheus federation-style `name[]` query args such as `?name[]=foo&name[]=bar` limit the exposed metrics
+// to metrics with the given base names. Such responses are always in Prometheus text exposition format.
+// Process metrics are exposed only if they are explicitly requested via `name[]` query args.
+// See WritePrometheusMatching for details.
+//
+// Usage:
+//
+//	http.Handle("/metrics", metrics.Handler(metrics.HandlerOpts{ExposeProcessMetrics: true}))
+func Handler(opts HandlerOpts) http.Handler {
+	var concurrencyCh chan struct{}
+	if opts.MaxRequestsInFlight > 0 {
+		concurrencyCh = make(chan struct{}, opts.MaxRequestsInFlight)
+	}
+	minCompressSize := opts.MinCompressSize
+	if minCompressSize <= 0 {
+		minCompressSize = defaultMinCompressSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if concurrencyCh != nil {
+			select {
+			case concurrencyCh <- struct{}{}:
+				defer func() { <-concurrencyCh }()
+			default:
+				http.Error(w, "too many concurrent requests for metrics; try again later", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		query := r.URL.Query()
+		matchers := query["name[]"]
+		hasMatchers := newMetricNameFilter(matchers) != nil
+		format := expositionFormatPrometheus
+		if !hasMatchers {
+			if query.Get("format") == "json" {
+				format = expositionFormatJSON
+			} else {
+				format = getExpositionFormat(r.Header.Get("Accept"))
+			}
+		}
+		h := w.Header()
+		switch format {
+		case expositionFormatOpenMetrics:
+			h.Set("Content-Type", openMetricsContentType)
+		case expositionFormatProtobuf:
+			h.Set("Content-Type", protobufContentType)
+		case expositionFormatJSON:
+			h.Set("Content-Type", jsonContentType)
+		default:
+			h.Set("Content-Type", prometheusContentType)
+		}
+		if !opts.DisableCompression {
+			h.Add("Vary", "Accept-Encoding")
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		bb := getBytesBuffer()
+		defer putBytesBuffer(bb)
+		switch format {
+		case expositionFormatOpenMetrics:
+			WriteOpenMetrics(bb, opts.ExposeProcessMetrics)
+		case expositionFormatProtobuf:
+			WriteProtobuf(bb, opts.ExposeProcessMetrics)
+		case expositionFormatJSON:
+			WriteJSON(bb, getLegacyWritePrometheusOpts(opts.ExposeProcessMetrics))
+		default:
+			writePrometheusMatching(bb, opts.ExposeProcessMetrics, matchers)
+		}
+		if opts.MaxResponseBytes > 0 {
+			bb.B = truncateResponse(bb.B, format, opts.MaxResponseBytes)
+		}
+
+		if opts.DisableCompression || len(bb.B) < minCompressSize || !isGzipAccepted(r.Header.Get("Accept-Encoding")) {
+			h.Set("Content-Length", strconv.Itoa(len(bb.B)))
+			_, _ = w.Write(bb.B)
+			return
+		}
+		h.Set("Content-Encoding", "gzip")
+		zw := getGzipWriter(w)
+		_, _ = zw.Write(bb.B)
+		_ = zw.Close()
+		putGzipWriter(zw)
+	})
+}
+
+// isGzipAccepted returns true if the given Accept-Encoding header value allows gzip encoding.
+func isGzipAccepted(acceptEncoding string) bool {
+	gzipQ := -1.0
+	anyQ := -1.0
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		encoding, q := parseAcceptItem(item)
+		switch encoding {
+		case "gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	protobufContentType    = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+	jsonContentType        = "application/json"
+)
+
+const (
+	expositionFormatPrometheus = iota
+	expositionFormatOpenMetrics
+	expositionFormatProtobuf
+	expositionFormatJSON
+)
+
+// getExpositionFormat returns the exposition format preferred by the client with the given Accept header value.
+//
+// OpenMetrics text format is preferred over protobuf format with the same q-value,
+// while protobuf format is preferred over JSON with the same q-value.
+func getExpositionFormat(accept string) int {
+	openMetricsQ := -1.0
+	protobufQ := -1.0
+	jsonQ := -1.0
+	textQ := -1.0
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, q := parseAcceptItem(item)
+		switch mediaType {
+		case "application/openmetrics-text":
+			if q > openMetricsQ {
+				openMetricsQ = q
+			}
+		case "application/vnd.google.protobuf":
+			if !isDelimitedMetricFamilyProtobuf(item) {
+				continue
+			}
+			if q > protobufQ {
+				protobufQ = q
+			}
+		case "application/json":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		case "text/plain", "text/*", "*/*":
+			if q > textQ {
+				textQ = q
+			}
+		}
+	}
+	if protobufQ > 0 && protobufQ > openMetricsQ && protobufQ >= textQ {
+		return expositionFormatProtobuf
+	}
+	if openMetricsQ > 0 && openMetricsQ >= textQ {
+		return expositionFormatOpenMetrics
+	}
+	if jsonQ > 0 && jsonQ > openMetricsQ && jsonQ > protobufQ && jsonQ >= textQ {
+		return expositionFormatJSON
+	}
+	return expositionFormatPrometheus
+}
+
+// isOpenMetricsAccepted returns true if the given Accept header value prefers OpenMetrics text format
+// over other formats.
+func isOpenMetricsAccepted(accept string) bool {
+	return getExpositionFormat(accept) == expositionFormatOpenMetrics
+}
+
+// isDelimitedMetricFamilyProtobuf returns true if the given Accept header item requests delimited MetricFamily messages.
+func isDelimitedMetricFamilyProtobuf(item string) bool {
+	item = strings.ToLower(strings.ReplaceAll(item, " ", ""))
+	return strings.Contains(item, ";proto=io.prometheus.client.metricfamily") && strings.Contains(item, ";encoding=delimited")
+}
+
+// parseAcceptItem returns media type and its q-value from a single item of the Accept header.
+func parseAcceptItem(item string) (string, float64) {
+	params := strings.Split(item, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		v, err := strconv.ParseFloat(param[len("q="):], 64)
+		if err == nil {
+			q = v
+		}
+	}
+	return mediaType, q
+}
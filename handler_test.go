@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsOpenMetricsAccepted(t *testing.T) {
+	f := func(accept string, resultExpected bool) {
+		t.Helper()
+		if result := isOpenMetricsAccepted(accept); result != resultExpected {
+			t.Fatalf("unexpected result for Accept: %q; got %v; want %v", accept, result, resultExpected)
+		}
+	}
+	f("", false)
+	f("*/*", false)
+	f("text/plain", false)
+	f("application/openmetrics-text", true)
+	f("application/openmetrics-text; version=1.0.0; charset=utf-8", true)
+	f("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", true)
+	f("application/openmetrics-text;q=0.3,text/plain;q=0.5", false)
+	f("application/openmetrics-text;q=0,text/plain;q=0.5", false)
+	f("text/plain;q=0.5,application/openmetrics-text;q=0.7", true)
+}
+
+func TestHandler(t *testing.T) {
+	f := func(accept, contentTypeExpected string, isOpenMetrics bool) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rw := httptest.NewRecorder()
+		Handler(HandlerOpts{}).ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("unexpected status code; got %d; want %d", rw.Code, http.StatusOK)
+		}
+		if contentType := rw.Header().Get("Content-Type"); contentType != contentTypeExpected {
+			t.Fatalf("unexpected Content-Type; got %q; want %q", contentType, contentTypeExpected)
+		}
+		if hasEOF := strings.HasSuffix(rw.Body.String(), "# EOF\n"); hasEOF != isOpenMetrics {
+			t.Fatalf("unexpected # EOF presence in the response; got %v; want %v", hasEOF, isOpenMetrics)
+		}
+	}
+	f("", prometheusContentType, false)
+	f("text/plain", prometheusContentType, false)
+	f("application/openmetrics-text; version=1.0.0", openMetricsContentType, true)
+}
+
+func TestGetExpositionFormat(t *testing.T) {
+	f := func(accept string, resultExpected int) {
+		t.Helper()
+		if result := getExpositionFormat(accept); result != resultExpected {
+			t.Fatalf("unexpected result for Accept: %q; got %d; want %d", accept, result, resultExpected)
+		}
+	}
+	const protobufAccept = "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited"
+	f("", expositionFormatPrometheus)
+	f("*/*", expositionFormatPrometheus)
+	f("application/openmetrics-text", expositionFormatOpenMetrics)
+	f(protobufAccept, expositionFormatProtobuf)
+	f(protobufContentType, expositionFormatProtobuf)
+	f(protobufAccept+";q=0.7,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", expositionFormatOpenMetrics)
+	f(protobufAccept+",application/openmetrics-text;version=0.0.1;q=0.9,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", expositionFormatProtobuf)
+	f(protobufAccept+";q=0.5,text/plain;q=0.5", expositionFormatProtobuf)
+	f(protobufAccept+";q=0.5,application/openmetrics-text;q=0.5", expositionFormatOpenMetrics)
+	f(protobufAccept+";q=0.3,text/plain;q=0.5", expositionFormatPrometheus)
+	f(protobufAccept+";q=0", expositionFormatPrometheus)
+
+	// JSON
+	f("application/json", expositionFormatJSON)
+	f("application/json;q=0.5,text/plain;q=0.5", expositionFormatJSON)
+	f("application/json;q=0.5,text/plain;q=0.7", expositionFormatPrometheus)
+	f("application/json;q=0.5,application/openmetrics-text;q=0.5", expositionFormatOpenMetrics)
+	f("application/json;q=0.5,"+protobufAccept+";q=0.5", expositionFormatProtobuf)
+	f("application/json;q=0", expositionFormatPrometheus)
+
+	// Only delimited MetricFamily messages are supported.
+	f("application/vnd.google.protobuf", expositionFormatPrometheus)
+	f("application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=text", expositionFormatPrometheus)
+}
+
+func TestHandlerProtobuf(t *testing.T) {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	rw := httptest.NewRecorder()
+	Handler(HandlerOpts{}).ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected status code; got %d; want %d", rw.Code, http.StatusOK)
+	}
+	if contentType := rw.Header().Get("Content-Type"); contentType != protobufContentType {
+		t.Fatalf("unexpected Content-Type; got %q; want %q", contentType, protobufContentType)
+	}
+}
+
+func TestHandlerJSON(t *testing.T) {
+	f := func(target, accept string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rw := httptest.NewRecorder()
+		Handler(HandlerOpts{}).ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("unexpected status code; got %d; want %d", rw.Code, http.StatusOK)
+		}
+		if contentType := rw.Header().Get("Content-Type"); contentType != jsonContentType {
+			t.Fatalf("unexpected Content-Type; got %q; want %q", contentType, jsonContentType)
+		}
+		var items []map[string]interface{}
+		if err := json.Unmarshal(rw.Body.Bytes(), &items); err != nil {
+			t.Fatalf("cannot parse JSON response: %s\n%s", err, rw.Body.String())
+		}
+	}
+	f("/metrics", "application/json")
+	f("/metrics?format=json", "")
+	f("/metrics?format=json", "application/openmetrics-text")
+}
+
+func TestHandlerGzip(t *testing.T) {
+	name := "handler_gzip_test_metric"
+	NewCounter(name).Inc()
+	defer UnregisterMetric(name)
+
+	f := func(opts HandlerOpts, acceptEncoding string, isGzipExpected bool) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rw := httptest.NewRecorder()
+		Handler(opts).ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("unexpected status code; got %d; want %d", rw.Code, http.StatusOK)
+		}
+		contentEncoding := rw.Header().Get("Content-Encoding")
+		if isGzip := contentEncoding == "gzip"; isGzip != isGzipExpected {
+			t.Fatalf("unexpected Content-Encoding; got %q; want gzip=%v", contentEncoding, isGzipExpected)
+		}
+		var body io.Reader = rw.Body
+		if isGzipExpected {
+			zr, err := gzip.NewReader(rw.Body)
+			if err != nil {
+				t.Fatalf("cannot create gzip reader: %s", err)
+			}
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("cannot read response body: %s", err)
+		}
+		if !strings.Contains(string(data), name+" 1\n") {
+			t.Fatalf("missing %s in the response:\n%s", name, data)
+		}
+	}
+
+	opts := HandlerOpts{
+		MinCompressSize: 1,
+	}
+	f(opts, "", false)
+	f(opts, "gzip", true)
+	f(opts, "deflate, gzip;q=0.5", true)
+	f(opts, "gzip;q=0", false)
+	f(opts, "*", true)
+	f(opts, "br", false)
+
+	// Compression is disabled
+	f(HandlerOpts{DisableCompression: true}, "gzip", false)
+
+	// The response is smaller than MinCompressSize
+	f(HandlerOpts{MinCompressSize: 1 << 30}, "gzip", false)
+}
+
+func TestHandlerHead(t *testing.T) {
+	req := httptest.NewRequest("HEAD", "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rw := httptest.NewRecorder()
+	Handler(HandlerOpts{}).ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected status code; got %d; want %d", rw.Code, http.StatusOK)
+	}
+	if contentType := rw.Header().Get("Content-Type"); contentType != prometheusContentType {
+		t.Fatalf("unexpected Content-Type; got %q; want %q", contentType, prometheusContentType)
+	}
+	if n := rw.Body.Len(); n != 0 {
+		t.Fatalf("unexpected non-empty body for HEAD request; got %d bytes", n)
+	}
+}
+
+func TestHandlerMaxRequestsInFlight(t *testing.T) {
+	name := "handler_max_requests_in_flight_test_metric"
+	enteredCh := make(chan struct{})
+	releaseCh := make(chan struct{})
+	blocked := false
+	NewGauge(name, func() float64 {
+		if !blocked {
+			blocked = true
+			close(enteredCh)
+			<-releaseCh
+		}
+		return 1
+	})
+	defer UnregisterMetric(name)
+
+	h := Handler(HandlerOpts{
+		MaxRequestsInFlight: 1,
+	})
+	doneCh := make(chan int)
+	go func() {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+		doneCh <- rw.Code
+	}()
+	<-enteredCh
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code for the request exceeding the limit; got %d; want %d", rw.Code, http.StatusServiceUnavailable)
+	}
+
+	close(releaseCh)
+	if code := <-doneCh; code != http.StatusOK {
+		t.Fatalf("unexpected status code for the first request; got %d; want %d", code, http.StatusOK)
+	}
+
+	// The limit must be released after the request is served.
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected status code after releasing the limit; got %d; want %d", rw.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,321 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// HDRHistogram is a histogram with guaranteed relative error for values in the given range.
+//
+// Values in the range [minValue..maxValue] are counted in logarithmically spaced buckets, so the harmonic mean of bucket bounds
+// is within 10^-significantDigits relative error from any value in the bucket. For example, significantDigits=2
+// guarantees 1% relative error. Values in the range [0..minValue) are counted in the underflow bucket,
+// while values bigger than maxValue are counted in the overflow bucket. Negative values and NaNs are ignored.
+//
+// The number of buckets is
+//
+//	ceil(ln(maxValue/minValue) / ln((1+10^-significantDigits)/(1-10^-significantDigits))) + 2
+//
+// and every bucket occupies 8 bytes. For example, the range 1µs..60s needs 898 buckets or ~7KiB for significantDigits=2
+// and 8957 buckets or ~70KiB for significantDigits=3. Bucket labels are generated only for non-empty buckets at scrape time.
+//
+// Only non-empty buckets are exposed. They are exposed with `vmrange` labels in the same way as for Histogram by default,
+// or as Prometheus-compatible cumulative buckets with `le` labels. See SetOutputFormat.
+//
+// HDRHistogram is updated with atomic operations, so concurrent updates do not block each other.
+type HDRHistogram struct {
+	// sumBits contains uint64 representation of float64 sum of all the observed values.
+	sumBits atomicUint64
+
+	minValue          float64
+	maxValue          float64
+	significantDigits int
+
+	// logMultiplier is the natural logarithm of the ratio between the upper and the lower bounds of every bucket.
+	logMultiplier float64
+
+	// buckets contains counters for the underflow bucket, the buckets for [minValue..maxValue] and the overflow bucket.
+	buckets []uint64
+
+	// format is the output format set via SetOutputFormat. It is accessed atomically.
+	format uint32
+}
+
+// NewHistogramHDR creates and returns new HDRHistogram with the given name for values in the range [minValue..maxValue]
+// with the relative error 10^-significantDigits.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// minValue must be positive and smaller than maxValue. significantDigits must be in the range [1..5].
+// See HDRHistogram for the memory usage.
+//
+// The returned histogram is safe to use from concurrent goroutines.
+func NewHistogramHDR(name string, minValue, maxValue float64, significantDigits int) *HDRHistogram {
+	return defaultSet.NewHistogramHDR(name, minValue, maxValue, significantDigits)
+}
+
+func newHDRHistogram(minValue, maxValue float64, significantDigits int) *HDRHistogram {
+	if math.IsNaN(minValue) || math.IsInf(minValue, 0) || minValue <= 0 {
+		panic(fmt.Errorf("BUG: minValue must be positive; got %v", minValue))
+	}
+	if math.IsNaN(maxValue) || math.IsInf(maxValue, 0) || maxValue <= minValue {
+		panic(fmt.Errorf("BUG: maxValue must be bigger than minValue=%v; got %v", minValue, maxValue))
+	}
+	if significantDigits < 1 || significantDigits > 5 {
+		panic(fmt.Errorf("BUG: significantDigits must be in the range [1..5]; got %d", significantDigits))
+	}
+	relativeError := math.Pow10(-significantDigits)
+	logMultiplier := math.Log((1 + relativeError) / (1 - relativeError))
+	bucketsCount := int(math.Ceil(math.Log(maxValue/minValue) / logMultiplier))
+	return &HDRHistogram{
+		minValue:          minValue,
+		maxValue:          maxValue,
+		significantDigits: significantDigits,
+		logMultiplier:     logMultiplier,
+		buckets:           make([]uint64, bucketsCount+2),
+	}
+}
+
+// Update updates hh with v.
+//
+// Negative values and NaNs are ignored.
+func (hh *HDRHistogram) Update(v float64) {
+	hh.UpdateWithCount(v, 1)
+}
+
+// UpdateWithCount updates hh with count observations of v.
+//
+// It is equivalent to calling Update(v) count times, but it takes O(1) time.
+func (hh *HDRHistogram) UpdateWithCount(v float64, count uint64) {
+	if math.IsNaN(v) || v < 0 || count == 0 {
+		return
+	}
+	idx := hh.getBucketIdx(v)
+	atomic.AddUint64(&hh.buckets[idx], count)
+	hh.addSum(v * float64(count))
+}
+
+// UpdateDuration updates request duration based on the given startTime.
+func (hh *HDRHistogram) UpdateDuration(startTime time.Time) {
+	d := time.Since(startTime).Seconds()
+	hh.Update(d)
+}
+
+// getBucketIdx returns the index of the bucket for non-negative v.
+func (hh *HDRHistogram) getBucketIdx(v float64) int {
+	if v < hh.minValue {
+		return 0
+	}
+	if v > hh.maxValue {
+		return len(hh.buckets) - 1
+	}
+	idx := 1 + int(math.Log(v/hh.minValue)/hh.logMultiplier)
+	if idx > len(hh.buckets)-2 {
+		// v is close to maxValue, so it may get into the overflow bucket because of rounding errors.
+		idx = len(hh.buckets) - 2
+	}
+	return idx
+}
+
+// getBucketBounds returns the lower and the upper bounds for the bucket with the given index.
+func (hh *HDRHistogram) getBucketBounds(idx int) (float64, float64) {
+	switch {
+	case idx == 0:
+		return 0, hh.minValue
+	case idx == len(hh.buckets)-1:
+		return hh.maxValue, math.Inf(1)
+	}
+	lower := hh.minValue * math.Exp(float64(idx-1)*hh.logMultiplier)
+	upper := hh.maxValue
+	if idx < len(hh.buckets)-2 {
+		upper = hh.minValue * math.Exp(float64(idx)*hh.logMultiplier)
+	}
+	return lower, upper
+}
+
+func (hh *HDRHistogram) addSum(v float64) {
+	for {
+		bits := hh.sumBits.Load()
+		bitsNew := math.Float64bits(math.Float64frombits(bits) + v)
+		if hh.sumBits.CompareAndSwap(bits, bitsNew) {
+			return
+		}
+	}
+}
+
+// Quantile returns the estimated phi-quantile for all the values observed by hh.
+//
+// The harmonic mean of bounds for the bucket containing the quantile is returned, so the relative error doesn't exceed 10^-significantDigits
+// for quantiles in the range [minValue..maxValue]. minValue is returned for quantiles in the underflow bucket,
+// while maxValue is returned for quantiles in the overflow bucket, since their exact values are unknown.
+// NaN is returned if hh is empty or if phi is NaN. phi is clamped to the range [0..1].
+func (hh *HDRHistogram) Quantile(phi float64) float64 {
+	if math.IsNaN(phi) {
+		return math.NaN()
+	}
+	total := uint64(0)
+	for i := range hh.buckets {
+		total += atomic.LoadUint64(&hh.buckets[i])
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	if phi < 0 {
+		phi = 0
+	}
+	if phi > 1 {
+		phi = 1
+	}
+	rank := uint64(math.Ceil(phi * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	// Bucket counters may be reset concurrently after calculating the total,
+	// so the last bucket is used if the rank isn't reached.
+	cumulative := uint64(0)
+	idx := len(hh.buckets) - 1
+	for i := range hh.buckets {
+		cumulative += atomic.LoadUint64(&hh.buckets[i])
+		if cumulative >= rank {
+			idx = i
+			break
+		}
+	}
+	switch idx {
+	case 0:
+		return hh.minValue
+	case len(hh.buckets) - 1:
+		return hh.maxValue
+	}
+	// The harmonic mean of the bucket bounds is within the relative error from any value in the bucket.
+	lower, upper := hh.getBucketBounds(idx)
+	return 2 * lower * upper / (lower + upper)
+}
+
+// SetOutputFormat sets the format for hh buckets in Prometheus text exposition format.
+//
+// HistogramFormatDefault resets the format to the one set via SetDefaultHistogramFormat.
+// OpenMetrics and protobuf formats always contain buckets with `le` labels.
+func (hh *HDRHistogram) SetOutputFormat(format HistogramFormat) {
+	atomic.StoreUint32(&hh.format, uint32(format))
+}
+
+func (hh *HDRHistogram) getOutputFormat() HistogramFormat {
+	format := HistogramFormat(atomic.LoadUint32(&hh.format))
+	if format == HistogramFormatDefault {
+		format = HistogramFormat(atomic.LoadUint32(&defaultHistogramFormat))
+	}
+	if format == HistogramFormatDefault {
+		format = HistogramFormatVMRange
+	}
+	return format
+}
+
+// visitNonZeroBuckets calls f for every non-empty bucket of hh in ascending order of bounds and returns the sum of values.
+func (hh *HDRHistogram) visitNonZeroBuckets(f func(lower, upper float64, count uint64)) float64 {
+	for i := range hh.buckets {
+		count := atomic.LoadUint64(&hh.buckets[i])
+		if count == 0 {
+			continue
+		}
+		lower, upper := hh.getBucketBounds(i)
+		f(lower, upper, count)
+	}
+	return math.Float64frombits(hh.sumBits.Load())
+}
+
+// formatBound formats bucket bound with enough precision for distinguishing adjacent buckets.
+func (hh *HDRHistogram) formatBound(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	if v == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'e', hh.significantDigits+2, 64)
+}
+
+// MarshalTo writes hh with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (hh *HDRHistogram) MarshalTo(prefix string, w io.Writer) {
+	hh.marshalTo(prefix, w)
+}
+
+func (hh *HDRHistogram) marshalTo(prefix string, w io.Writer) {
+	if hh.getOutputFormat() == HistogramFormatLE {
+		hh.marshalToLE(prefix, w)
+		return
+	}
+	countTotal := uint64(0)
+	sum := hh.visitNonZeroBuckets(func(lower, upper float64, count uint64) {
+		tag := `vmrange="` + hh.formatBound(lower) + "..." + hh.formatBound(upper) + `"`
+		writeBucketSample(w, prefix, tag, count)
+		countTotal += count
+	})
+	if countTotal == 0 {
+		return
+	}
+	writeSumAndCount(w, prefix, sum, countTotal, nil)
+}
+
+// marshalToLE marshals hh with the given prefix to w as cumulative buckets with `le` labels in Prometheus text exposition format.
+//
+// The upper bound of every non-empty bucket is exposed as `le` label.
+func (hh *HDRHistogram) marshalToLE(prefix string, w io.Writer) {
+	countTotal := uint64(0)
+	sum := hh.visitNonZeroBuckets(func(_, upper float64, count uint64) {
+		countTotal += count
+		if math.IsInf(upper, 1) {
+			// The +Inf bucket is written below.
+			return
+		}
+		writeBucketSample(w, prefix, `le="`+hh.formatBound(upper)+`"`, countTotal)
+	})
+	if countTotal == 0 {
+		return
+	}
+	writeBucketSample(w, prefix, `le="+Inf"`, countTotal)
+	writeSumAndCount(w, prefix, sum, countTotal, nil)
+}
+
+// marshalToOpenMetrics marshals hh with the given prefix to w as cumulative buckets with `le` labels,
+// since OpenMetrics doesn't support buckets with `vmrange` labels.
+func (hh *HDRHistogram) marshalToOpenMetrics(prefix string, w io.Writer) {
+	hh.marshalToLE(prefix, w)
+}
+
+func (hh *HDRHistogram) marshalProtobuf(dst []byte) []byte {
+	// Metric.histogram
+	return appendProtobufMessage(dst, 7, func(dst []byte) []byte {
+		countTotal := uint64(0)
+		sum := hh.visitNonZeroBuckets(func(_, upper float64, count uint64) {
+			countTotal += count
+			if !math.IsInf(upper, 1) {
+				dst = appendProtobufBucket(dst, countTotal, upper)
+			}
+		})
+		dst = appendProtobufVarint(dst, 1, countTotal)
+		return appendProtobufDouble(dst, 2, sum)
+	})
+}
+
+func (hh *HDRHistogram) metricType() string {
+	return "histogram"
+}
+
+func (hh *HDRHistogram) reset() {
+	for i := range hh.buckets {
+		atomic.StoreUint64(&hh.buckets[i], 0)
+	}
+	hh.sumBits.Store(0)
+}
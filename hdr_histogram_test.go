@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestHDRHistogramBucketsCount(t *testing.T) {
+	f := func(minValue, maxValue float64, significantDigits, bucketsCountExpected int) {
+		t.Helper()
+		hh := newHDRHistogram(minValue, maxValue, significantDigits)
+		if n := len(hh.buckets); n != bucketsCountExpected {
+			t.Fatalf("unexpected number of buckets; got %d; want %d", n, bucketsCountExpected)
+		}
+	}
+	f(1e-6, 60, 2, 898)
+	f(1e-6, 60, 3, 8957)
+	f(1, 10, 1, 14)
+}
+
+func TestHDRHistogramRelativeError(t *testing.T) {
+	f := func(minValue, maxValue float64, significantDigits int) {
+		t.Helper()
+		maxRelativeError := math.Pow10(-significantDigits) * (1 + 1e-9)
+		hh := newHDRHistogram(minValue, maxValue, significantDigits)
+		r := rand.New(rand.NewSource(1))
+		logRange := math.Log(maxValue / minValue)
+		for i := 0; i < 10000; i++ {
+			v := minValue * math.Exp(r.Float64()*logRange)
+			hh.reset()
+			hh.Update(v)
+			q := hh.Quantile(0.5)
+			if relativeError := math.Abs(q-v) / v; relativeError > maxRelativeError {
+				t.Fatalf("too big relative error for v=%v; got %v; want up to %v; quantile=%v", v, relativeError, maxRelativeError, q)
+			}
+		}
+		// Bounds of the range must be counted in the regular buckets.
+		for _, v := range []float64{minValue, maxValue} {
+			hh.reset()
+			hh.Update(v)
+			q := hh.Quantile(1)
+			if relativeError := math.Abs(q-v) / v; relativeError > maxRelativeError {
+				t.Fatalf("too big relative error for v=%v; got %v; want up to %v; quantile=%v", v, relativeError, maxRelativeError, q)
+			}
+		}
+	}
+	f(1e-6, 60, 1)
+	f(1e-6, 60, 2)
+	f(1e-6, 60, 3)
+	f(0.5, 1e9, 2)
+}
+
+func TestHDRHistogramQuantile(t *testing.T) {
+	hh := newHDRHistogram(1e-6, 60, 2)
+	if q := hh.Quantile(0.5); !math.IsNaN(q) {
+		t.Fatalf("unexpected quantile for empty histogram; got %v; want NaN", q)
+	}
+
+	// Verify quantiles against exact values for log-normal distribution of latencies.
+	r := rand.New(rand.NewSource(1))
+	values := make([]float64, 1e5)
+	for i := range values {
+		values[i] = 0.01 * math.Exp(r.NormFloat64())
+		hh.Update(values[i])
+	}
+	sort.Float64s(values)
+	for _, phi := range []float64{0, 0.5, 0.9, 0.99, 0.999, 1} {
+		rank := int(math.Ceil(phi * float64(len(values))))
+		if rank == 0 {
+			rank = 1
+		}
+		exact := values[rank-1]
+		q := hh.Quantile(phi)
+		if relativeError := math.Abs(q-exact) / exact; relativeError > 0.01 {
+			t.Fatalf("too big relative error for phi=%v; got %v; want up to 0.01; quantile=%v; exact=%v", phi, relativeError, q, exact)
+		}
+	}
+	if q := hh.Quantile(math.NaN()); !math.IsNaN(q) {
+		t.Fatalf("unexpected quantile for NaN phi; got %v; want NaN", q)
+	}
+
+	// Out-of-range quantiles must be clamped to the range bounds.
+	hh.reset()
+	hh.Update(0)
+	hh.Update(1e6)
+	if q := hh.Quantile(0); q != 1e-6 {
+		t.Fatalf("unexpected quantile for underflow bucket; got %v; want 1e-6", q)
+	}
+	if q := hh.Quantile(1); q != 60 {
+		t.Fatalf("unexpected quantile for overflow bucket; got %v; want 60", q)
+	}
+}
+
+func TestHDRHistogramMarshalTo(t *testing.T) {
+	f := func(format HistogramFormat, resultExpected string) {
+		t.Helper()
+		s := NewSet()
+		hh := s.NewHistogramHDR(`foo{bar="baz"}`, 1, 10, 1)
+		hh.SetOutputFormat(format)
+		for _, v := range []float64{0.5, 1, 10, 100, -1, math.NaN()} {
+			hh.Update(v)
+		}
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(HistogramFormatDefault, `foo_bucket{bar="baz",vmrange="0...1.000e+00"} 1
+foo_bucket{bar="baz",vmrange="1.000e+00...1.222e+00"} 1
+foo_bucket{bar="baz",vmrange="9.092e+00...1.000e+01"} 1
+foo_bucket{bar="baz",vmrange="1.000e+01...+Inf"} 1
+foo_sum{bar="baz"} 111.5
+foo_count{bar="baz"} 4
+`)
+	f(HistogramFormatLE, `foo_bucket{bar="baz",le="1.000e+00"} 1
+foo_bucket{bar="baz",le="1.222e+00"} 2
+foo_bucket{bar="baz",le="1.000e+01"} 3
+foo_bucket{bar="baz",le="+Inf"} 4
+foo_sum{bar="baz"} 111.5
+foo_count{bar="baz"} 4
+`)
+
+	// Empty histogram mustn't be exposed.
+	s := NewSet()
+	s.NewHistogramHDR("foo", 1, 10, 1)
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	if result := bb.String(); result != "" {
+		t.Fatalf("unexpected result for empty histogram; got %q; want empty", result)
+	}
+}
+
+func TestHDRHistogramInvalidArgs(t *testing.T) {
+	f := func(minValue, maxValue float64, significantDigits int) {
+		t.Helper()
+		expectPanic(t, "NewHistogramHDR", func() {
+			newHDRHistogram(minValue, maxValue, significantDigits)
+		})
+	}
+	f(0, 10, 2)
+	f(-1, 10, 2)
+	f(math.NaN(), 10, 2)
+	f(10, 10, 2)
+	f(1, math.Inf(1), 2)
+	f(1, 10, 0)
+	f(1, 10, 6)
+}
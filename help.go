@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"sync/atomic"
+)
+
+// SetMetricHelp sets help for the metric family with the given base name in the default set.
+//
+// The help is exposed in `# HELP` line once per metric family. It overrides the help passed to metric constructors.
+// Backslashes and newlines in help are escaped according to Prometheus text exposition format.
+//
+// The help may be set before the metric family is registered. In this case it is applied to metrics
+// when they are registered. Repeated calls overwrite the previously set help. Pass empty help
+// for removing the help set via SetMetricHelp.
+//
+// name may contain labels - they are ignored. For example, SetMetricHelp(`requests_total{path="/foo"}`, "...")
+// sets help for the requests_total family.
+func SetMetricHelp(name, help string) {
+	defaultSet.SetMetricHelp(name, help)
+}
+
+// GetMetricHelp returns help set via SetMetricHelp for the metric family with the given base name in the default set.
+func GetMetricHelp(name string) string {
+	return defaultSet.GetMetricHelp(name)
+}
+
+// SetMetricHelp sets help for the metric family with the given base name in s.
+//
+// See SetMetricHelp for details.
+func (s *Set) SetMetricHelp(name, help string) {
+	family := getMetricFamily(mustNormalizeMetricName(name))
+
+	s.mu.Lock()
+	mh := s.getMetricHelpLocked(family)
+	s.mu.Unlock()
+
+	mh.set(help)
+}
+
+// GetMetricHelp returns help set via SetMetricHelp for the metric family with the given base name in s.
+func (s *Set) GetMetricHelp(name string) string {
+	family := getMetricFamily(name)
+
+	s.mu.RLock()
+	mh := s.metricHelps[family]
+	s.mu.RUnlock()
+
+	return mh.get()
+}
+
+// getMetricHelpLocked returns help holder for the given metric family in s.
+//
+// The holder is created if it is missing, so the help may be set before the metric family is registered.
+func (s *Set) getMetricHelpLocked(family string) *metricHelp {
+	mh := s.metricHelps[family]
+	if mh == nil {
+		if s.metricHelps == nil {
+			s.metricHelps = make(map[string]*metricHelp)
+		}
+		mh = &metricHelp{}
+		s.metricHelps[family] = mh
+	}
+	return mh
+}
+
+// metricHelp holds help for a metric family set via SetMetricHelp.
+//
+// It is shared among all the metrics of the family, so the help may be updated
+// without modifying metrics, which are concurrently written by other goroutines.
+type metricHelp struct {
+	v atomic.Value
+}
+
+func (mh *metricHelp) set(help string) {
+	mh.v.Store(help)
+}
+
+func (mh *metricHelp) get() string {
+	if mh == nil {
+		return ""
+	}
+	help, _ := mh.v.Load().(string)
+	return help
+}
+
+// getHelp returns help for nm.
+//
+// The help set via SetMetricHelp takes precedence over the help passed to metric constructor.
+func (nm *namedMetric) getHelp() string {
+	if help := nm.familyHelp.get(); help != "" {
+		return help
+	}
+	return nm.help
+}
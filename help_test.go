@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetMetricHelp(t *testing.T) {
+	s := NewSet()
+
+	// Help may be set before the metric is registered.
+	s.SetMetricHelp("requests_total", "The number of requests")
+	s.NewCounter(`requests_total{path="/foo"}`).Inc()
+	s.NewCounter(`requests_total{path="/bar"}`).Add(2)
+	s.NewGauge("temperature", func() float64 { return 42 })
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f(`# HELP requests_total The number of requests
+# TYPE requests_total counter
+requests_total{path="/bar"} 2
+requests_total{path="/foo"} 1
+temperature 42
+`)
+
+	// Help may be set for the already registered metric. Labels in the name are ignored.
+	// Backslashes and newlines must be escaped.
+	s.SetMetricHelp(`temperature{foo="bar"}`, "Temperature in \\degrees\nCelsius")
+	f(`# HELP requests_total The number of requests
+# TYPE requests_total counter
+requests_total{path="/bar"} 2
+requests_total{path="/foo"} 1
+# HELP temperature Temperature in \\degrees\nCelsius
+# TYPE temperature gauge
+temperature 42
+`)
+	if help := s.GetMetricHelp("temperature"); help != "Temperature in \\degrees\nCelsius" {
+		t.Fatalf("unexpected help: %q", help)
+	}
+
+	// The latest help wins.
+	s.SetMetricHelp("requests_total", "Requests")
+	if help := s.GetMetricHelp("requests_total"); help != "Requests" {
+		t.Fatalf("unexpected help; got %q; want %q", help, "Requests")
+	}
+
+	// Empty help removes the help.
+	s.SetMetricHelp("temperature", "")
+	f(`# HELP requests_total Requests
+# TYPE requests_total counter
+requests_total{path="/bar"} 2
+requests_total{path="/foo"} 1
+temperature 42
+`)
+	if help := s.GetMetricHelp("missing"); help != "" {
+		t.Fatalf("unexpected help for missing metric: %q", help)
+	}
+
+	expectPanic(t, "invalid name", func() {
+		s.SetMetricHelp("bad name", "foo")
+	})
+}
+
+func TestSetMetricHelpOverridesConstructorHelp(t *testing.T) {
+	s := NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: "foo_total",
+		Help: "constructor help",
+	})
+	s.SetMetricHelp("foo_total", "new help")
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `# HELP foo_total new help
+# TYPE foo_total counter
+foo_total 0
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 const (
@@ -44,69 +48,363 @@ var bucketMultiplier = math.Pow(10, 1.0/bucketsPerDecimal)
 // Prometheus histogram buckets with `le` labels, since they don't include counters
 // for all the previous buckets.
 //
+// Histogram buckets can be exposed as Prometheus-compatible cumulative buckets with `le` labels
+// instead of `vmrange` buckets via SetOutputFormat or SetDefaultHistogramFormat.
+//
 // Zero histogram is usable.
 type Histogram struct {
-	// Mu gurantees synchronous update for all the counters and sum.
-	mu sync.Mutex
+	// lower and upper contain the number of values outside the decimal buckets.
+	lower atomicUint64
+	upper atomicUint64
+
+	// sumBits contains uint64 representation of float64 sum of all the observed values.
+	sumBits atomicUint64
+
+	// invalidValues contains the number of negative values and NaNs passed to Update with InvalidValueCount policy.
+	invalidValues atomicUint64
 
+	// decimalBuckets contains lazily allocated buckets, which are loaded and stored atomically.
+	// Counters in the buckets are updated atomically, so Update doesn't need locks.
 	decimalBuckets [decimalBucketsCount]*[bucketsPerDecimal]uint64
 
-	lower uint64
-	upper uint64
+	// mu protects exemplars.
+	mu sync.Mutex
+
+	// exemplars contains the most recent exemplars per bucket. It is nil if UpdateWithExemplar wasn't called.
+	exemplars map[int]*exemplar
+
+	// format is the HistogramFormat set via SetOutputFormat. It is accessed atomically.
+	format uint32
+
+	// invalidValuePolicy is the InvalidValuePolicy set via SetInvalidValuePolicy. It is accessed atomically.
+	invalidValuePolicy uint32
 
-	sum float64
+	// floatFormat is the float format set via SetFloatFormat. It is accessed atomically.
+	floatFormat uint32
+
+	statsd statsdMirror
+
+	created createdTimestamp
 }
 
 // Reset resets the given histogram.
+//
+// Updates performed concurrently with Reset may be partially lost.
 func (h *Histogram) Reset() {
-	h.mu.Lock()
-	for _, db := range h.decimalBuckets[:] {
+	h.created.onReset()
+	for i := range h.decimalBuckets[:] {
+		db := h.loadDecimalBucket(i)
 		if db == nil {
 			continue
 		}
-		for i := range db[:] {
-			db[i] = 0
+		for offset := range db[:] {
+			atomic.StoreUint64(&db[offset], 0)
 		}
 	}
-	h.lower = 0
-	h.upper = 0
-	h.sum = 0
+	h.lower.Store(0)
+	h.upper.Store(0)
+	h.sumBits.Store(0)
+	h.invalidValues.Store(0)
+	h.mu.Lock()
+	h.exemplars = nil
 	h.mu.Unlock()
 }
 
 // Update updates h with v.
 //
-// Negative values and NaNs are ignored.
+// Negative values and NaNs are ignored by default. See SetInvalidValuePolicy for changing this.
+//
+// Update uses only atomic operations, so concurrent Update calls do not block each other.
 func (h *Histogram) Update(v float64) {
 	if math.IsNaN(v) || v < 0 {
-		// Skip NaNs and negative values.
+		var ok bool
+		if v, ok = h.handleInvalidValue(1); !ok {
+			return
+		}
+	}
+	h.update(v)
+	if ss := h.statsd.load(); ss != nil {
+		ss.sendHistogram(v)
+	}
+}
+
+// UpdateBatch updates h with all the values.
+//
+// It is equivalent to calling Update for every value, but it is faster for big batches: the sum is updated
+// with a single atomic operation per batch, while adjacent values falling into the same bucket are counted
+// with a single atomic operation.
+//
+// Concurrent readers may observe partially applied batch.
+func (h *Histogram) UpdateBatch(values []float64) {
+	ss := h.statsd.load()
+	sum := float64(0)
+	prevBucketKey := 0
+	prevCount := uint64(0)
+	for _, v := range values {
+		if math.IsNaN(v) || v < 0 {
+			var ok bool
+			if v, ok = h.handleInvalidValue(1); !ok {
+				continue
+			}
+		}
+		sum += v
+		bucketKey := getHistogramBucketKey(v)
+		if bucketKey == prevBucketKey {
+			prevCount++
+		} else {
+			h.addBucketCount(prevBucketKey, prevCount)
+			prevBucketKey = bucketKey
+			prevCount = 1
+		}
+		if ss != nil {
+			ss.sendHistogram(v)
+		}
+	}
+	h.addBucketCount(prevBucketKey, prevCount)
+	h.addSum(sum)
+}
+
+// UpdateWithCount updates h with count observations of v.
+//
+// It is equivalent to calling Update(v) count times, but it takes O(1) time. This is useful for importing
+// pre-aggregated data.
+func (h *Histogram) UpdateWithCount(v float64, count uint64) {
+	if count == 0 {
 		return
 	}
+	if math.IsNaN(v) || v < 0 {
+		var ok bool
+		if v, ok = h.handleInvalidValue(count); !ok {
+			return
+		}
+	}
+	h.addSum(v * float64(count))
+	h.addBucketCount(getHistogramBucketKey(v), count)
+	if ss := h.statsd.load(); ss != nil {
+		ss.sendHistogramWithCount(v, count)
+	}
+}
+
+// UpdateWithExemplar updates h with v and attaches exemplar with the given labels and value v to the bucket containing v.
+//
+// labels usually contain a reference to the trace, such as `trace_id`. The most recent exemplar per bucket is retained
+// and is exposed only in OpenMetrics text format, since Prometheus text format doesn't support exemplars.
+//
+// The combined length of label names and values must not exceed 128 runes. h is updated even if labels are invalid -
+// the exemplar is dropped and an error is returned in this case.
+//
+// Negative values and NaNs are handled according to the policy set via SetInvalidValuePolicy.
+func (h *Histogram) UpdateWithExemplar(v float64, labels map[string]string) error {
+	if math.IsNaN(v) || v < 0 {
+		var ok bool
+		if v, ok = h.handleInvalidValue(1); !ok {
+			return nil
+		}
+	}
+	e, err := newExemplar(v, labels)
+	bucketKey := h.update(v)
+	if err == nil {
+		h.mu.Lock()
+		if h.exemplars == nil {
+			h.exemplars = make(map[int]*exemplar)
+		}
+		h.exemplars[bucketKey] = e
+		h.mu.Unlock()
+	}
+	if ss := h.statsd.load(); ss != nil {
+		ss.sendHistogram(v)
+	}
+	return err
+}
+
+// bucket keys for values outside the supported range of vmrange buckets.
+const (
+	lowerBucketKey = -1
+	upperBucketKey = bucketsCount
+)
+
+// update atomically updates h with non-negative v and returns the key for the updated bucket.
+func (h *Histogram) update(v float64) int {
+	h.addSum(v)
+	bucketKey := getHistogramBucketKey(v)
+	h.addBucketCount(bucketKey, 1)
+	return bucketKey
+}
+
+// addBucketCount atomically adds count to the bucket with the given bucketKey.
+func (h *Histogram) addBucketCount(bucketKey int, count uint64) {
+	if count == 0 {
+		return
+	}
+	switch bucketKey {
+	case lowerBucketKey:
+		h.lower.Add(count)
+	case upperBucketKey:
+		h.upper.Add(count)
+	default:
+		decimalBucketIdx := bucketKey / bucketsPerDecimal
+		offset := bucketKey % bucketsPerDecimal
+		db := h.getOrCreateDecimalBucket(decimalBucketIdx)
+		atomic.AddUint64(&db[offset], count)
+	}
+}
+
+// getHistogramBucketKey returns the key for the bucket containing non-negative v.
+func getHistogramBucketKey(v float64) int {
 	bucketIdx := (math.Log10(v) - e10Min) * bucketsPerDecimal
-	h.mu.Lock()
-	h.sum += v
 	if bucketIdx < 0 {
-		h.lower++
-	} else if bucketIdx >= bucketsCount {
-		h.upper++
-	} else {
-		idx := uint(bucketIdx)
-		if bucketIdx == float64(idx) && idx > 0 {
-			// Edge case for 10^n values, which must go to the lower bucket
-			// according to Prometheus logic for `le`-based histograms.
-			idx--
-		}
-		decimalBucketIdx := idx / bucketsPerDecimal
-		offset := idx % bucketsPerDecimal
-		db := h.decimalBuckets[decimalBucketIdx]
+		return lowerBucketKey
+	}
+	if bucketIdx >= bucketsCount {
+		return upperBucketKey
+	}
+	idx := uint(bucketIdx)
+	if bucketIdx == float64(idx) && idx > 0 {
+		// Edge case for 10^n values, which must go to the lower bucket
+		// according to Prometheus logic for `le`-based histograms.
+		idx--
+	}
+	return int(idx)
+}
+
+func (h *Histogram) addSum(v float64) {
+	for {
+		bits := h.sumBits.Load()
+		bitsNew := math.Float64bits(math.Float64frombits(bits) + v)
+		if h.sumBits.CompareAndSwap(bits, bitsNew) {
+			return
+		}
+	}
+}
+
+// loadDecimalBucket atomically loads the bucket at decimalBucketIdx. nil is returned if the bucket isn't allocated yet.
+func (h *Histogram) loadDecimalBucket(decimalBucketIdx int) *[bucketsPerDecimal]uint64 {
+	p := (*unsafe.Pointer)(unsafe.Pointer(&h.decimalBuckets[decimalBucketIdx]))
+	return (*[bucketsPerDecimal]uint64)(atomic.LoadPointer(p))
+}
+
+// getOrCreateDecimalBucket returns the bucket at decimalBucketIdx and allocates it if needed.
+func (h *Histogram) getOrCreateDecimalBucket(decimalBucketIdx int) *[bucketsPerDecimal]uint64 {
+	if db := h.loadDecimalBucket(decimalBucketIdx); db != nil {
+		return db
+	}
+	var b [bucketsPerDecimal]uint64
+	p := (*unsafe.Pointer)(unsafe.Pointer(&h.decimalBuckets[decimalBucketIdx]))
+	if atomic.CompareAndSwapPointer(p, nil, unsafe.Pointer(&b)) {
+		return &b
+	}
+	// The bucket has been allocated by concurrent goroutine.
+	return h.loadDecimalBucket(decimalBucketIdx)
+}
+
+// Merge adds bucket counters and sum from src to h.
+//
+// src isn't modified. Every counter is added atomically, so concurrent updates of h and src are never lost.
+// Concurrent readers of h may observe partially merged state.
+//
+// Merge may be used for combining per-worker histograms into a single histogram.
+// See also MergeInto and Reset.
+func (h *Histogram) Merge(src *Histogram) {
+	var buf histogramBuckets
+	sum := buf.copyFrom(src)
+
+	for i, db := range buf.decimalBuckets[:] {
 		if db == nil {
-			var b [bucketsPerDecimal]uint64
-			db = &b
-			h.decimalBuckets[decimalBucketIdx] = db
+			continue
+		}
+		dstDB := h.getOrCreateDecimalBucket(i)
+		for offset, count := range db[:] {
+			if count > 0 {
+				atomic.AddUint64(&dstDB[offset], count)
+			}
 		}
-		db[offset]++
 	}
-	h.mu.Unlock()
+	h.lower.Add(buf.lower)
+	h.upper.Add(buf.upper)
+	h.addSum(sum)
+}
+
+// MergeInto adds bucket counters and sum from h to dst without modifying h.
+//
+// It is equivalent to dst.Merge(h) and is convenient for aggregating snapshots of multiple histograms into dst.
+func (h *Histogram) MergeInto(dst *Histogram) {
+	dst.Merge(h)
+}
+
+// GetSum returns the sum of all the values passed to h.Update since its creation or the last Reset call.
+func (h *Histogram) GetSum() float64 {
+	return math.Float64frombits(h.sumBits.Load())
+}
+
+// GetCount returns the number of values passed to h.Update since its creation or the last Reset call.
+//
+// Every bucket counter is read atomically, so the returned count matches the sum of counts passed to VisitNonZeroBuckets
+// if h isn't updated concurrently.
+func (h *Histogram) GetCount() uint64 {
+	n := h.lower.Load()
+	for i := range h.decimalBuckets[:] {
+		db := h.loadDecimalBucket(i)
+		if db == nil {
+			continue
+		}
+		for offset := range db[:] {
+			n += atomic.LoadUint64(&db[offset])
+		}
+	}
+	n += h.upper.Load()
+	return n
+}
+
+// histogramBuckets holds a copy of Histogram buckets.
+type histogramBuckets struct {
+	decimalBuckets [decimalBucketsCount]*[bucketsPerDecimal]uint64
+	lower          uint64
+	upper          uint64
+}
+
+// copyFrom atomically loads buckets from h into hb and returns the sum of values in h.
+func (hb *histogramBuckets) copyFrom(h *Histogram) float64 {
+	return hb.loadFrom(h, false)
+}
+
+// moveFrom atomically moves buckets from h into hb and returns the sum of values in h.
+//
+// Counters in h are reset to zero, so concurrent updates are either moved to hb or are left in h.
+func (hb *histogramBuckets) moveFrom(h *Histogram) float64 {
+	return hb.loadFrom(h, true)
+}
+
+// loadFrom atomically loads buckets from h into hb and returns the sum of values in h.
+//
+// Counters in h are reset to zero if reset is true.
+func (hb *histogramBuckets) loadFrom(h *Histogram, reset bool) float64 {
+	load := func(addr *atomicUint64) uint64 {
+		if reset {
+			return addr.Swap(0)
+		}
+		return addr.Load()
+	}
+	loadBucket := func(addr *uint64) uint64 {
+		if reset {
+			return atomic.SwapUint64(addr, 0)
+		}
+		return atomic.LoadUint64(addr)
+	}
+	sumBits := load(&h.sumBits)
+	for i := range h.decimalBuckets[:] {
+		db := h.loadDecimalBucket(i)
+		if db == nil {
+			continue
+		}
+		var b [bucketsPerDecimal]uint64
+		for offset := range db[:] {
+			b[offset] = loadBucket(&db[offset])
+		}
+		hb.decimalBuckets[i] = &b
+	}
+	hb.lower = load(&h.lower)
+	hb.upper = load(&h.upper)
+	return math.Float64frombits(sumBits)
 }
 
 // VisitNonZeroBuckets calls f for all buckets with non-zero counters.
@@ -115,27 +413,50 @@ func (h *Histogram) Update(v float64) {
 // isn't included in the bucket, while the upper bound is included.
 // This is required to be compatible with Prometheus-style histogram buckets
 // with `le` (less or equal) labels.
+//
+// Buckets are visited in ascending order of their bounds. Every bucket counter is read atomically only once,
+// so concurrent Update calls never result in torn counters. Every value passed to concurrent Update calls is either
+// visited or not, but the visited counters may be inconsistent with GetSum and GetCount results obtained
+// during concurrent updates.
+//
+// See also GetHistogramBucketRange and GetHistogramBucketRanges for aligning external aggregation with h buckets.
 func (h *Histogram) VisitNonZeroBuckets(f func(vmrange string, count uint64)) {
-	h.mu.Lock()
-	if h.lower > 0 {
-		f(lowerBucketRange, h.lower)
+	h.visitNonZeroBuckets(f)
+}
+
+// visitNonZeroBuckets calls f for all buckets with non-zero counters and returns the sum of values in h.
+func (h *Histogram) visitNonZeroBuckets(f func(vmrange string, count uint64)) float64 {
+	return h.visitNonZeroBucketsWithKeys(func(_ int, vmrange string, count uint64) {
+		f(vmrange, count)
+	})
+}
+
+// visitNonZeroBucketsWithKeys calls f for all buckets with non-zero counters and returns the sum of values in h.
+//
+// Every counter is read atomically only once, so the sum of counts passed to f always matches the visited buckets
+// even if h is updated concurrently. The returned sum may be inconsistent with counters during concurrent updates.
+func (h *Histogram) visitNonZeroBucketsWithKeys(f func(bucketKey int, vmrange string, count uint64)) float64 {
+	sum := h.GetSum()
+	if n := h.lower.Load(); n > 0 {
+		f(lowerBucketKey, lowerBucketRange, n)
 	}
-	for decimalBucketIdx, db := range h.decimalBuckets[:] {
+	for decimalBucketIdx := range h.decimalBuckets[:] {
+		db := h.loadDecimalBucket(decimalBucketIdx)
 		if db == nil {
 			continue
 		}
-		for offset, count := range db[:] {
-			if count > 0 {
+		for offset := range db[:] {
+			if count := atomic.LoadUint64(&db[offset]); count > 0 {
 				bucketIdx := decimalBucketIdx*bucketsPerDecimal + offset
 				vmrange := getVMRange(bucketIdx)
-				f(vmrange, count)
+				f(bucketIdx, vmrange, count)
 			}
 		}
 	}
-	if h.upper > 0 {
-		f(upperBucketRange, h.upper)
+	if n := h.upper.Load(); n > 0 {
+		f(upperBucketKey, upperBucketRange, n)
 	}
-	h.mu.Unlock()
+	return sum
 }
 
 // NewHistogram creates and returns new histogram with the given name.
@@ -152,6 +473,29 @@ func NewHistogram(name string) *Histogram {
 	return defaultSet.NewHistogram(name)
 }
 
+// HistogramOpts contains options for NewHistogramOpt.
+type HistogramOpts struct {
+	// Name is the histogram name. It must be valid Prometheus-compatible metric with possible labels.
+	Name string
+
+	// Help is an optional description for the histogram. It is exposed in the `# HELP` line.
+	Help string
+
+	// Unit is an optional unit for the histogram such as `seconds` or `bytes`. It is exposed in the `# UNIT` line in OpenMetrics format.
+	//
+	// See SetStrictUnitNames and NormalizeUnit.
+	Unit string
+}
+
+// NewHistogramOpt creates and returns new histogram with the given opts.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+//
+// The returned histogram is safe to use from concurrent goroutines.
+func NewHistogramOpt(opts HistogramOpts) *Histogram {
+	return defaultSet.NewHistogramOpt(opts)
+}
+
 // GetOrCreateHistogram returns registered histogram with the given name
 // or creates new histogram if the registry doesn't contain histogram with
 // the given name.
@@ -176,11 +520,71 @@ func (h *Histogram) UpdateDuration(startTime time.Time) {
 	h.Update(d)
 }
 
+// GetHistogramBucketRange returns vmrange for the Histogram bucket, which contains v.
+//
+// The returned vmrange matches the vmrange passed to Histogram.VisitNonZeroBuckets and exposed in `vmrange` label
+// for the bucket, which is updated by Histogram.Update(v). Empty string is returned for negative v and NaN,
+// since they are ignored by Histogram.Update.
+func GetHistogramBucketRange(v float64) string {
+	if math.IsNaN(v) || v < 0 {
+		return ""
+	}
+	return getHistogramBucketRange(getHistogramBucketKey(v))
+}
+
+// GetHistogramBucketRanges returns vmrange values for all the Histogram buckets in ascending order of their bounds.
+//
+// The returned slice may be modified by the caller.
+func GetHistogramBucketRanges() []string {
+	bucketRangesOnce.Do(initBucketRanges)
+	vmranges := make([]string, 0, bucketsCount+2)
+	vmranges = append(vmranges, lowerBucketRange)
+	vmranges = append(vmranges, bucketRanges[:]...)
+	vmranges = append(vmranges, upperBucketRange)
+	return vmranges
+}
+
+// getHistogramBucketRange returns vmrange for the bucket with the given key.
+func getHistogramBucketRange(bucketKey int) string {
+	switch bucketKey {
+	case lowerBucketKey:
+		return lowerBucketRange
+	case upperBucketKey:
+		return upperBucketRange
+	default:
+		return getVMRange(bucketKey)
+	}
+}
+
 func getVMRange(bucketIdx int) string {
 	bucketRangesOnce.Do(initBucketRanges)
 	return bucketRanges[bucketIdx]
 }
 
+// getVMRangeTag returns `vmrange="<start>...<end>"` tag for the bucket with the given key.
+func getVMRangeTag(bucketKey int) string {
+	switch bucketKey {
+	case lowerBucketKey:
+		return lowerBucketRangeTag
+	case upperBucketKey:
+		return upperBucketRangeTag
+	default:
+		bucketRangesOnce.Do(initBucketRanges)
+		return bucketRangeTags[bucketKey]
+	}
+}
+
+// getLETag returns `le="<end>"` tag for the bucket with the given key, where <end> is the upper bound of the bucket.
+//
+// The bucket with upperBucketKey must be exposed as `le="+Inf"` bucket by the caller.
+func getLETag(bucketKey int) string {
+	if bucketKey == lowerBucketKey {
+		return lowerBucketLETag
+	}
+	bucketRangesOnce.Do(initBucketRanges)
+	return bucketLETags[bucketKey]
+}
+
 func initBucketRanges() {
 	v := math.Pow10(e10Min)
 	start := fmt.Sprintf("%.3e", v)
@@ -188,6 +592,8 @@ func initBucketRanges() {
 		v *= bucketMultiplier
 		end := fmt.Sprintf("%.3e", v)
 		bucketRanges[i] = start + "..." + end
+		bucketRangeTags[i] = fmt.Sprintf("vmrange=%q", bucketRanges[i])
+		bucketLETags[i] = fmt.Sprintf("le=%q", end)
 		start = end
 	}
 }
@@ -196,39 +602,200 @@ var (
 	lowerBucketRange = fmt.Sprintf("0...%.3e", math.Pow10(e10Min))
 	upperBucketRange = fmt.Sprintf("%.3e...+Inf", math.Pow10(e10Max))
 
+	lowerBucketRangeTag = fmt.Sprintf("vmrange=%q", lowerBucketRange)
+	upperBucketRangeTag = fmt.Sprintf("vmrange=%q", upperBucketRange)
+
+	lowerBucketLETag = fmt.Sprintf(`le="%.3e"`, math.Pow10(e10Min))
+
+	// bucketRanges, bucketRangeTags and bucketLETags are initialized lazily by initBucketRanges.
 	bucketRanges     [bucketsCount]string
+	bucketRangeTags  [bucketsCount]string
+	bucketLETags     [bucketsCount]string
 	bucketRangesOnce sync.Once
 )
 
+// HistogramFormat is the format for Histogram buckets in Prometheus text exposition format.
+type HistogramFormat uint32
+
+const (
+	// HistogramFormatDefault is the format set via SetDefaultHistogramFormat.
+	HistogramFormatDefault HistogramFormat = iota
+
+	// HistogramFormatVMRange exposes non-empty buckets with `vmrange` labels. This is the default format.
+	HistogramFormatVMRange
+
+	// HistogramFormatLE exposes non-empty buckets as Prometheus-compatible cumulative buckets with `le` labels
+	// plus the `le="+Inf"` bucket, so they can be used in histogram_quantile() from PromQL.
+	HistogramFormatLE
+)
+
+// SetDefaultHistogramFormat sets the format for buckets of Histogram metrics without the format set via Histogram.SetOutputFormat.
+//
+// HistogramFormatVMRange is used by default. The format affects only the output in Prometheus text exposition format,
+// while OpenMetrics and protobuf formats always contain buckets with `le` labels.
+func SetDefaultHistogramFormat(format HistogramFormat) {
+	atomic.StoreUint32(&defaultHistogramFormat, uint32(format))
+}
+
+var defaultHistogramFormat uint32
+
+// SetOutputFormat sets the format for h buckets in Prometheus text exposition format.
+//
+// HistogramFormatDefault resets the format to the one set via SetDefaultHistogramFormat.
+// The format affects only the output. Updates and the stored buckets are the same for all the formats.
+func (h *Histogram) SetOutputFormat(format HistogramFormat) {
+	atomic.StoreUint32(&h.format, uint32(format))
+}
+
+func (h *Histogram) getOutputFormat() HistogramFormat {
+	format := HistogramFormat(atomic.LoadUint32(&h.format))
+	if format == HistogramFormatDefault {
+		format = HistogramFormat(atomic.LoadUint32(&defaultHistogramFormat))
+	}
+	if format == HistogramFormatDefault {
+		format = HistogramFormatVMRange
+	}
+	return format
+}
+
+// InvalidValuePolicy defines how Histogram handles negative values and NaNs passed to Update.
+type InvalidValuePolicy uint32
+
+const (
+	// InvalidValueIgnore drops invalid values. This is the default policy.
+	InvalidValueIgnore InvalidValuePolicy = iota
+
+	// InvalidValueClampToZero records invalid values as 0.
+	InvalidValueClampToZero
+
+	// InvalidValueCount drops invalid values and counts them in `<name>_invalid_total` counter,
+	// which is exposed after the histogram buckets in Prometheus text exposition format. The counter isn't exposed
+	// in OpenMetrics and protobuf formats, since they don't allow samples with such a suffix in histogram families.
+	InvalidValueCount
+)
+
+// SetInvalidValuePolicy sets the policy for negative values and NaNs passed to h.Update and h.UpdateWithExemplar.
+//
+// NaN never affects `_sum` of h regardless of the policy.
+func (h *Histogram) SetInvalidValuePolicy(policy InvalidValuePolicy) {
+	atomic.StoreUint32(&h.invalidValuePolicy, uint32(policy))
+}
+
+// handleInvalidValue handles negative value or NaN passed to Update according to the policy for h.
+//
+// It returns the value to record and true if the value must be recorded.
+func (h *Histogram) handleInvalidValue(count uint64) (float64, bool) {
+	switch InvalidValuePolicy(atomic.LoadUint32(&h.invalidValuePolicy)) {
+	case InvalidValueClampToZero:
+		return 0, true
+	case InvalidValueCount:
+		h.invalidValues.Add(count)
+		return 0, false
+	default:
+		return 0, false
+	}
+}
+
+// marshalInvalidValuesTo writes `<name>_invalid_total` sample with the given prefix to w if h has InvalidValueCount policy.
+func (h *Histogram) marshalInvalidValuesTo(prefix string, w io.Writer) {
+	if InvalidValuePolicy(atomic.LoadUint32(&h.invalidValuePolicy)) != InvalidValueCount {
+		return
+	}
+	bb := getSampleBuffer(w)
+	bb.B = appendSampleName(bb.B, prefix, "_invalid_total", "")
+	bb.B = append(bb.B, ' ')
+	bb.B = strconv.AppendUint(bb.B, h.invalidValues.Load(), 10)
+	bb.B = append(bb.B, '\n')
+	putSampleBuffer(w, bb)
+}
+
+// MarshalTo writes h with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (h *Histogram) MarshalTo(prefix string, w io.Writer) {
+	h.marshalTo(prefix, w)
+}
+
 func (h *Histogram) marshalTo(prefix string, w io.Writer) {
+	if h.getOutputFormat() == HistogramFormatLE {
+		h.marshalToLE(prefix, w)
+	} else {
+		h.marshalToVMRange(prefix, w)
+	}
+	h.marshalInvalidValuesTo(prefix, w)
+}
+
+// marshalToVMRange marshals h with the given prefix to w as non-empty buckets with `vmrange` labels in Prometheus text exposition format.
+func (h *Histogram) marshalToVMRange(prefix string, w io.Writer) {
 	countTotal := uint64(0)
-	h.VisitNonZeroBuckets(func(vmrange string, count uint64) {
-		tag := fmt.Sprintf("vmrange=%q", vmrange)
-		metricName := addTag(prefix, tag)
-		name, labels := splitMetricName(metricName)
-		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, count)
+	sum := h.visitNonZeroBucketsWithKeys(func(bucketKey int, _ string, count uint64) {
+		writeBucketSample(w, prefix, getVMRangeTag(bucketKey), count)
 		countTotal += count
 	})
 	if countTotal == 0 {
 		return
 	}
-	name, labels := splitMetricName(prefix)
-	sum := h.getSum()
-	if float64(int64(sum)) == sum {
-		fmt.Fprintf(w, "%s_sum%s %d\n", name, labels, int64(sum))
-	} else {
-		fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, sum)
+	writeSumAndCount(w, prefix, sum, countTotal, &h.floatFormat)
+}
+
+// marshalToLE marshals h with the given prefix to w as cumulative buckets with `le` labels in Prometheus text exposition format.
+//
+// The upper bound of every non-empty bucket is exposed as `le` label.
+func (h *Histogram) marshalToLE(prefix string, w io.Writer) {
+	countTotal := uint64(0)
+	sum := h.visitNonZeroBucketsWithKeys(func(bucketKey int, _ string, count uint64) {
+		countTotal += count
+		if bucketKey == upperBucketKey {
+			// The +Inf bucket is written below.
+			return
+		}
+		writeBucketSample(w, prefix, getLETag(bucketKey), countTotal)
+	})
+	if countTotal == 0 {
+		return
 	}
-	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, countTotal)
+	writeBucketSample(w, prefix, `le="+Inf"`, countTotal)
+	writeSumAndCount(w, prefix, sum, countTotal, &h.floatFormat)
 }
 
-func (h *Histogram) getSum() float64 {
+// marshalToOpenMetrics marshals h with the given prefix to w as cumulative buckets with `le` labels.
+//
+// OpenMetrics doesn't support buckets with `vmrange` labels, so the upper bound of every non-empty bucket
+// is exposed as `le` label instead.
+func (h *Histogram) marshalToOpenMetrics(prefix string, w io.Writer) {
+	countTotal := uint64(0)
+	var infExemplar *exemplar
+	// Hold h.mu while visiting the buckets in order to access h.exemplars.
 	h.mu.Lock()
-	sum := h.sum
-	h.mu.Unlock()
-	return sum
+	defer h.mu.Unlock()
+	sum := h.visitNonZeroBucketsWithKeys(func(bucketKey int, vmrange string, count uint64) {
+		countTotal += count
+		e := h.exemplars[bucketKey]
+		le := vmrange[strings.Index(vmrange, "...")+len("..."):]
+		if le == "+Inf" {
+			// The +Inf bucket is written below.
+			infExemplar = e
+			return
+		}
+		tag := fmt.Sprintf("le=%q", le)
+		metricName := addTag(prefix, tag)
+		name, labels := splitMetricName(metricName)
+		writeOpenMetricsSample(w, name+"_bucket"+labels, strconv.FormatUint(countTotal, 10), nil, e)
+	})
+	if countTotal == 0 {
+		return
+	}
+	metricName := addTag(prefix, `le="+Inf"`)
+	name, labels := splitMetricName(metricName)
+	writeOpenMetricsSample(w, name+"_bucket"+labels, strconv.FormatUint(countTotal, 10), nil, infExemplar)
+
+	writeSumAndCount(w, prefix, sum, countTotal, &h.floatFormat)
 }
 
 func (h *Histogram) metricType() string {
 	return "histogram"
 }
+
+func (h *Histogram) getStatsDMirror(_ bool) *statsdMirror {
+	return &h.statsd
+}
@@ -25,3 +25,24 @@ func ExampleHistogram_vec() {
 		metrics.GetOrCreateHistogram(name).Update(float64(len(response)))
 	}
 }
+
+func ExampleHistogram_NewTimer() {
+	// Define a histogram in global scope.
+	var h = metrics.NewHistogram(`request_duration_seconds{path="/foo/bar"}`)
+
+	// Update the histogram with the duration of processRequest call in seconds.
+	t := h.NewTimer()
+	processRequest()
+	t.ObserveDuration()
+}
+
+func ExampleHistogram_StartTimer() {
+	// Define a histogram in global scope.
+	var h = metrics.NewHistogram(`request_duration_seconds{path="/foo/baz"}`)
+
+	// Update the histogram with the duration of the function call in seconds.
+	func() {
+		defer h.StartTimer()()
+		processRequest()
+	}()
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Quantile returns the estimated phi-quantile for the values observed by h.
+//
+// The quantile is estimated from non-empty buckets with linear interpolation inside the bucket containing the quantile,
+// so the estimate never goes outside the bounds of this bucket. This means the relative error doesn't exceed
+// the bucket width, i.e. 10^(1/18)-1 or ~13.6%. The `1e18...+Inf` bucket has no upper bound, so its lower bound is returned
+// for quantiles in this bucket.
+//
+// phi is clamped to the range [0..1]. NaN is returned if h is empty or if phi is NaN.
+//
+// It is safe to call Quantile concurrently with Update calls. Buckets are read atomically once per call,
+// so the estimate is based on a consistent set of counters. See also Quantiles.
+func (h *Histogram) Quantile(phi float64) float64 {
+	var hc histogramCounts
+	hc.loadFrom(h)
+	return hc.quantile(phi)
+}
+
+// Quantiles returns the estimated phis-quantiles for the values observed by h.
+//
+// It is faster than calling Quantile for every phi, since buckets are read only once.
+// All the quantiles are estimated from the same buckets, so they are consistent with each other
+// even if h is updated concurrently. See Quantile for details.
+func (h *Histogram) Quantiles(phis []float64) []float64 {
+	var hc histogramCounts
+	hc.loadFrom(h)
+	result := make([]float64, len(phis))
+	for i, phi := range phis {
+		result[i] = hc.quantile(phi)
+	}
+	return result
+}
+
+// histogramCounts contains a copy of Histogram bucket counters for quantile estimation.
+type histogramCounts struct {
+	lower  uint64
+	counts [bucketsCount]uint64
+	upper  uint64
+	total  uint64
+}
+
+// loadFrom atomically loads bucket counters from h into hc.
+func (hc *histogramCounts) loadFrom(h *Histogram) {
+	hc.lower = h.lower.Load()
+	total := hc.lower
+	for decimalBucketIdx := range h.decimalBuckets[:] {
+		db := h.loadDecimalBucket(decimalBucketIdx)
+		if db == nil {
+			continue
+		}
+		counts := hc.counts[decimalBucketIdx*bucketsPerDecimal:]
+		for offset := range db[:] {
+			count := atomic.LoadUint64(&db[offset])
+			counts[offset] = count
+			total += count
+		}
+	}
+	hc.upper = h.upper.Load()
+	hc.total = total + hc.upper
+}
+
+// quantile returns the estimated phi-quantile for hc.
+func (hc *histogramCounts) quantile(phi float64) float64 {
+	if hc.total == 0 || math.IsNaN(phi) {
+		return math.NaN()
+	}
+	if phi < 0 {
+		phi = 0
+	}
+	if phi > 1 {
+		phi = 1
+	}
+	rank := phi * float64(hc.total)
+	cumulative := uint64(0)
+	visit := func(bucketKey int, count uint64) (float64, bool) {
+		if count == 0 {
+			return 0, false
+		}
+		if float64(cumulative+count) < rank {
+			cumulative += count
+			return 0, false
+		}
+		lower, upper := getHistogramBucketBounds(bucketKey)
+		if math.IsInf(upper, 1) {
+			return lower, true
+		}
+		fraction := (rank - float64(cumulative)) / float64(count)
+		if fraction < 0 {
+			fraction = 0
+		}
+		return lower + (upper-lower)*fraction, true
+	}
+	if v, ok := visit(lowerBucketKey, hc.lower); ok {
+		return v
+	}
+	for bucketIdx, count := range hc.counts[:] {
+		if v, ok := visit(bucketIdx, count); ok {
+			return v
+		}
+	}
+	if v, ok := visit(upperBucketKey, hc.upper); ok {
+		return v
+	}
+	// This cannot happen, since the rank doesn't exceed the total count.
+	return math.NaN()
+}
+
+// getHistogramBucketBounds returns the lower and the upper bounds for the Histogram bucket with the given key.
+//
+// The lower bound isn't included in the bucket, while the upper bound is included.
+func getHistogramBucketBounds(bucketKey int) (float64, float64) {
+	switch bucketKey {
+	case lowerBucketKey:
+		return 0, math.Pow10(e10Min)
+	case upperBucketKey:
+		return math.Pow10(e10Max), math.Inf(1)
+	default:
+		lower := math.Pow(10, e10Min+float64(bucketKey)/bucketsPerDecimal)
+		upper := math.Pow(10, e10Min+float64(bucketKey+1)/bucketsPerDecimal)
+		return lower, upper
+	}
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	f := func(values []float64, phi, resultExpected float64) {
+		t.Helper()
+		var h Histogram
+		for _, v := range values {
+			h.Update(v)
+		}
+		result := h.Quantile(phi)
+		if math.IsNaN(resultExpected) {
+			if !math.IsNaN(result) {
+				t.Fatalf("unexpected quantile for phi=%v; got %v; want NaN", phi, result)
+			}
+			return
+		}
+		if math.Abs(result-resultExpected) > 1e-9*resultExpected {
+			t.Fatalf("unexpected quantile for phi=%v; got %v; want %v", phi, result, resultExpected)
+		}
+	}
+
+	lower, upper := getHistogramBucketBounds(getHistogramBucketKey(5))
+
+	// Empty histogram
+	f(nil, 0.5, math.NaN())
+
+	// NaN phi
+	f([]float64{5}, math.NaN(), math.NaN())
+
+	// Clamping to the bucket bounds
+	f([]float64{5}, 0, lower)
+	f([]float64{5}, -1, lower)
+	f([]float64{5}, 1, upper)
+	f([]float64{5}, 2, upper)
+
+	// Linear interpolation inside the bucket
+	f([]float64{5, 5, 5, 5}, 0.25, lower+(upper-lower)*0.25)
+	f([]float64{5, 5, 5, 5}, 0.5, lower+(upper-lower)*0.5)
+
+	// Values from the lower and the upper buckets
+	f([]float64{0, 0}, 0.5, 0.5e-9)
+	f([]float64{1e20, 1e20}, 0.5, 1e18)
+	f([]float64{0, 1e20}, 0.9, 1e18)
+}
+
+func TestHistogramQuantilesAccuracy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := make([]float64, 100000)
+	var h Histogram
+	for i := range values {
+		// Log-normal latencies around 10ms
+		v := math.Exp(r.NormFloat64()*1.5) * 0.01
+		values[i] = v
+		h.Update(v)
+	}
+	sort.Float64s(values)
+
+	phis := []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999}
+	quantiles := h.Quantiles(phis)
+	if len(quantiles) != len(phis) {
+		t.Fatalf("unexpected number of quantiles; got %d; want %d", len(quantiles), len(phis))
+	}
+	// The estimate must be in the same bucket as the exact value, so the relative error cannot exceed the bucket width.
+	maxRelativeError := bucketMultiplier - 1
+	for i, phi := range phis {
+		exact := values[int(math.Ceil(phi*float64(len(values))))-1]
+		relativeError := math.Abs(quantiles[i]-exact) / exact
+		if relativeError > maxRelativeError {
+			t.Fatalf("too big relative error for phi=%v: %.4f; max allowed %.4f; got %v; want %v", phi, relativeError, maxRelativeError, quantiles[i], exact)
+		}
+		if q := h.Quantile(phi); q != quantiles[i] {
+			t.Fatalf("Quantile result mismatch for phi=%v; got %v; want %v", phi, q, quantiles[i])
+		}
+	}
+
+	// Quantiles must grow with phi
+	for i := 1; i < len(quantiles); i++ {
+		if quantiles[i] < quantiles[i-1] {
+			t.Fatalf("quantile for phi=%v must be bigger than quantile for phi=%v; got %v and %v", phis[i], phis[i-1], quantiles[i], quantiles[i-1])
+		}
+	}
+}
+
+func TestHistogramQuantileConcurrent(t *testing.T) {
+	var h Histogram
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				h.Update(float64(i*1000 + j + 1))
+			}
+		}(i)
+	}
+	for i := 0; i < 100; i++ {
+		q := h.Quantile(0.99)
+		if !math.IsNaN(q) && (q <= 0 || q > 5000) {
+			t.Fatalf("unexpected quantile: %v", q)
+		}
+	}
+	wg.Wait()
+
+	q := h.Quantile(0.5)
+	lower, upper := getHistogramBucketBounds(getHistogramBucketKey(2000))
+	if q <= lower || q > upper {
+		t.Fatalf("unexpected median; got %v; want value in the range (%v..%v]", q, lower, upper)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -157,6 +159,233 @@ prefix_count 40
 	}
 }
 
+func TestHistogramMerge(t *testing.T) {
+	var h1, h2 Histogram
+	h1.Update(1e-10)
+	h1.Update(1)
+	h1.Update(1)
+	h2.Update(100)
+	h2.Update(1e20)
+
+	var dst Histogram
+	dst.Merge(&h1)
+	dst.Merge(&h2)
+	testMarshalTo(t, &dst, "prefix", `prefix_bucket{vmrange="0...1.000e-09"} 1
+prefix_bucket{vmrange="8.799e-01...1.000e+00"} 2
+prefix_bucket{vmrange="8.799e+01...1.000e+02"} 1
+prefix_bucket{vmrange="1.000e+18...+Inf"} 1
+prefix_sum 1e+20
+prefix_count 5
+`)
+
+	// src histograms mustn't be modified.
+	testMarshalTo(t, &h2, "prefix", `prefix_bucket{vmrange="8.799e+01...1.000e+02"} 1
+prefix_bucket{vmrange="1.000e+18...+Inf"} 1
+prefix_sum 1e+20
+prefix_count 2
+`)
+
+	// Merge overlapping buckets via MergeInto.
+	h2.Reset()
+	h2.Update(1)
+	h2.MergeInto(&h1)
+	testMarshalTo(t, &h1, "prefix", `prefix_bucket{vmrange="0...1.000e-09"} 1
+prefix_bucket{vmrange="8.799e-01...1.000e+00"} 3
+prefix_sum 3.0000000001
+prefix_count 4
+`)
+
+	// Merge histogram into itself.
+	h2.Merge(&h2)
+	testMarshalTo(t, &h2, "prefix", `prefix_bucket{vmrange="8.799e-01...1.000e+00"} 2
+prefix_sum 2
+prefix_count 2
+`)
+}
+
+func TestHistogramMergeConcurrent(t *testing.T) {
+	var dst, src Histogram
+	err := testConcurrent(func() error {
+		for i := 0; i < 100; i++ {
+			src.Update(1)
+			dst.Merge(&src)
+			src.MergeInto(&dst)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	countTotal := uint64(0)
+	dst.VisitNonZeroBuckets(func(vmrange string, count uint64) {
+		countTotal += count
+	})
+	if countTotal == 0 {
+		t.Fatalf("unexpected zero count after merge")
+	}
+}
+
+func TestHistogramUpdateConcurrentWithWritePrometheus(t *testing.T) {
+	s := NewSet()
+	h := s.NewHistogram(`foo{bar="baz"}`)
+	const workers = 4
+	const updatesPerWorker = 10000
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for j := 0; j < updatesPerWorker; j++ {
+				// Cover the lower and the upper buckets in addition to regular buckets.
+				switch j % 10 {
+				case 0:
+					h.Update(1e-12)
+				case 1:
+					h.Update(1e20)
+				default:
+					h.Update(float64(workerID*updatesPerWorker + j))
+				}
+			}
+		}(i)
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+
+	checkOutput := func() uint64 {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		bucketsTotal := uint64(0)
+		count := uint64(0)
+		for _, line := range strings.Split(strings.TrimSpace(bb.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			n := strings.LastIndexByte(line, ' ')
+			name, value := line[:n], line[n+1:]
+			switch {
+			case strings.HasPrefix(name, "foo_bucket{"):
+				v, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					t.Fatalf("cannot parse bucket value in %q: %s", line, err)
+				}
+				bucketsTotal += v
+			case strings.HasPrefix(name, "foo_count{"):
+				v, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					t.Fatalf("cannot parse count value in %q: %s", line, err)
+				}
+				count = v
+			}
+		}
+		if count != bucketsTotal {
+			t.Fatalf("unexpected _count; got %d; want %d (the sum of buckets)", count, bucketsTotal)
+		}
+		return count
+	}
+
+	countPrev := uint64(0)
+	for {
+		select {
+		case <-doneCh:
+			if count := checkOutput(); count != workers*updatesPerWorker {
+				t.Fatalf("unexpected final count; got %d; want %d", count, workers*updatesPerWorker)
+			}
+			return
+		default:
+		}
+		count := checkOutput()
+		if count < countPrev {
+			t.Fatalf("_count mustn't decrease; got %d after %d", count, countPrev)
+		}
+		countPrev = count
+	}
+}
+
+func TestHistogramUpdateConcurrentWithSnapshotAndReset(t *testing.T) {
+	s := NewSet()
+	h := s.NewHistogram("foo")
+	const workers = 4
+	const updatesPerWorker = 10000
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updatesPerWorker; j++ {
+				h.Update(float64(j % 100))
+			}
+		}()
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+
+	countTotal := uint64(0)
+	snapshot := func() {
+		var bb bytes.Buffer
+		s.SnapshotAndReset(&bb)
+		for _, line := range strings.Split(bb.String(), "\n") {
+			if !strings.HasPrefix(line, "foo_count ") {
+				continue
+			}
+			v, err := strconv.ParseUint(line[len("foo_count "):], 10, 64)
+			if err != nil {
+				t.Fatalf("cannot parse count in %q: %s", line, err)
+			}
+			countTotal += v
+		}
+	}
+	for {
+		select {
+		case <-doneCh:
+			snapshot()
+			if countTotal != workers*updatesPerWorker {
+				t.Fatalf("unexpected total count across snapshots; got %d; want %d", countTotal, workers*updatesPerWorker)
+			}
+			return
+		default:
+		}
+		snapshot()
+	}
+}
+
+func TestHistogramReset(t *testing.T) {
+	var h Histogram
+	h.Update(5)
+	h.Update(1e-10)
+	h.Reset()
+	testMarshalTo(t, &h, "prefix", "")
+	h.Update(1)
+	testMarshalTo(t, &h, "prefix", `prefix_bucket{vmrange="8.799e-01...1.000e+00"} 1
+prefix_sum 1
+prefix_count 1
+`)
+}
+
+func TestHistogramGetSum(t *testing.T) {
+	var h Histogram
+	if sum := h.GetSum(); sum != 0 {
+		t.Fatalf("unexpected sum for empty histogram; got %v; want 0", sum)
+	}
+	h.Update(1.5)
+	h.Update(2)
+	if sum := h.GetSum(); sum != 3.5 {
+		t.Fatalf("unexpected sum; got %v; want 3.5", sum)
+	}
+	h.Reset()
+	if sum := h.GetSum(); sum != 0 {
+		t.Fatalf("unexpected sum after Reset; got %v; want 0", sum)
+	}
+}
+
 func TestHistogramWithTags(t *testing.T) {
 	name := `TestHistogram{tag="foo"}`
 	h := NewHistogram(name)
@@ -198,3 +427,263 @@ func testGetOrCreateHistogram(name string) error {
 	}
 	return nil
 }
+
+func TestHistogramOutputFormat(t *testing.T) {
+	s := NewSet()
+	h := s.NewHistogram(`request_duration_seconds{path="/foo"}`)
+	h.Update(0)
+	h.Update(0.2)
+	h.Update(0.25)
+	h.Update(3)
+	h.Update(1e20)
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	resultVMRange := `request_duration_seconds_bucket{path="/foo",vmrange="0...1.000e-09"} 1
+request_duration_seconds_bucket{path="/foo",vmrange="1.896e-01...2.154e-01"} 1
+request_duration_seconds_bucket{path="/foo",vmrange="2.448e-01...2.783e-01"} 1
+request_duration_seconds_bucket{path="/foo",vmrange="2.783e+00...3.162e+00"} 1
+request_duration_seconds_bucket{path="/foo",vmrange="1.000e+18...+Inf"} 1
+request_duration_seconds_sum{path="/foo"} 1e+20
+request_duration_seconds_count{path="/foo"} 5
+`
+	resultLE := `request_duration_seconds_bucket{path="/foo",le="1.000e-09"} 1
+request_duration_seconds_bucket{path="/foo",le="2.154e-01"} 2
+request_duration_seconds_bucket{path="/foo",le="2.783e-01"} 3
+request_duration_seconds_bucket{path="/foo",le="3.162e+00"} 4
+request_duration_seconds_bucket{path="/foo",le="+Inf"} 5
+request_duration_seconds_sum{path="/foo"} 1e+20
+request_duration_seconds_count{path="/foo"} 5
+`
+
+	// vmrange buckets are used by default.
+	f(resultVMRange)
+
+	h.SetOutputFormat(HistogramFormatLE)
+	f(resultLE)
+
+	// The format set for the histogram has priority over the default format.
+	SetDefaultHistogramFormat(HistogramFormatVMRange)
+	f(resultLE)
+	h.SetOutputFormat(HistogramFormatDefault)
+	f(resultVMRange)
+
+	SetDefaultHistogramFormat(HistogramFormatLE)
+	defer SetDefaultHistogramFormat(HistogramFormatDefault)
+	f(resultLE)
+
+	// The format doesn't change the stored buckets.
+	h.Update(3)
+	f(strings.Replace(strings.Replace(strings.Replace(resultLE, `le="3.162e+00"} 4`, `le="3.162e+00"} 5`, 1),
+		`le="+Inf"} 5`, `le="+Inf"} 6`, 1), "_count{path=\"/foo\"} 5", "_count{path=\"/foo\"} 6", 1))
+
+	// Empty histogram isn't written.
+	h.Reset()
+	f("")
+}
+
+func TestHistogramGetCount(t *testing.T) {
+	var h Histogram
+	if n := h.GetCount(); n != 0 {
+		t.Fatalf("unexpected count for empty histogram; got %d; want 0", n)
+	}
+	for _, v := range []float64{0, 1e-20, 0.5, 1, 1, 123, 1e30, -1, math.NaN()} {
+		h.Update(v)
+	}
+	if n := h.GetCount(); n != 7 {
+		t.Fatalf("unexpected count; got %d; want 7", n)
+	}
+	var visitedCount uint64
+	h.VisitNonZeroBuckets(func(vmrange string, count uint64) {
+		visitedCount += count
+	})
+	if visitedCount != 7 {
+		t.Fatalf("unexpected count of visited buckets; got %d; want 7", visitedCount)
+	}
+	h.Reset()
+	if n := h.GetCount(); n != 0 {
+		t.Fatalf("unexpected count after reset; got %d; want 0", n)
+	}
+}
+
+func TestGetHistogramBucketRange(t *testing.T) {
+	f := func(v float64, vmrangeExpected string) {
+		t.Helper()
+		vmrange := GetHistogramBucketRange(v)
+		if vmrange != vmrangeExpected {
+			t.Fatalf("unexpected vmrange for %v; got %q; want %q", v, vmrange, vmrangeExpected)
+		}
+		if vmrange == "" {
+			return
+		}
+
+		// The vmrange must match the bucket updated by Histogram.Update.
+		var h Histogram
+		h.Update(v)
+		h.VisitNonZeroBuckets(func(vmrangeVisited string, count uint64) {
+			if vmrangeVisited != vmrange {
+				t.Fatalf("unexpected vmrange for the updated bucket for %v; got %q; want %q", v, vmrangeVisited, vmrange)
+			}
+		})
+	}
+	f(-1, "")
+	f(math.NaN(), "")
+	f(0, "0...1.000e-09")
+	f(1e-10, "0...1.000e-09")
+	f(1, "8.799e-01...1.000e+00")
+	f(1.1, "1.000e+00...1.136e+00")
+	f(123, "1.136e+02...1.292e+02")
+	f(1e20, "1.000e+18...+Inf")
+	f(math.Inf(1), "1.000e+18...+Inf")
+}
+
+func TestGetHistogramBucketRanges(t *testing.T) {
+	vmranges := GetHistogramBucketRanges()
+	if n := len(vmranges); n != bucketsCount+2 {
+		t.Fatalf("unexpected number of vmranges; got %d; want %d", n, bucketsCount+2)
+	}
+	if vmranges[0] != "0...1.000e-09" {
+		t.Fatalf("unexpected first vmrange: %q", vmranges[0])
+	}
+	if vmranges[len(vmranges)-1] != "1.000e+18...+Inf" {
+		t.Fatalf("unexpected last vmrange: %q", vmranges[len(vmranges)-1])
+	}
+	// Adjacent buckets must share bounds.
+	for i := 1; i < len(vmranges); i++ {
+		prevEnd := vmranges[i-1][strings.Index(vmranges[i-1], "...")+3:]
+		start := vmranges[i][:strings.Index(vmranges[i], "...")]
+		if prevEnd != start {
+			t.Fatalf("the end of vmrange %q doesn't match the start of the next vmrange %q", vmranges[i-1], vmranges[i])
+		}
+	}
+
+	// The returned slice may be modified by the caller.
+	vmranges[0] = "foo"
+	if vmranges := GetHistogramBucketRanges(); vmranges[0] != "0...1.000e-09" {
+		t.Fatalf("unexpected first vmrange after modification: %q", vmranges[0])
+	}
+}
+
+func TestHistogramInvalidValuePolicy(t *testing.T) {
+	f := func(policy InvalidValuePolicy, resultExpected string) {
+		t.Helper()
+		h := &Histogram{}
+		h.SetInvalidValuePolicy(policy)
+		h.Update(2)
+		h.Update(-1)
+		h.Update(math.NaN())
+		if err := h.UpdateWithExemplar(-5, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if sum := h.GetSum(); sum != 2 {
+			t.Fatalf("unexpected sum; got %v; want 2", sum)
+		}
+		testMarshalTo(t, h, "foo", resultExpected)
+	}
+
+	// Invalid values are ignored by default.
+	f(InvalidValueIgnore, `foo_bucket{vmrange="1.896e+00...2.154e+00"} 1
+foo_sum 2
+foo_count 1
+`)
+	f(InvalidValueClampToZero, `foo_bucket{vmrange="0...1.000e-09"} 3
+foo_bucket{vmrange="1.896e+00...2.154e+00"} 1
+foo_sum 2
+foo_count 4
+`)
+	f(InvalidValueCount, `foo_bucket{vmrange="1.896e+00...2.154e+00"} 1
+foo_sum 2
+foo_count 1
+foo_invalid_total 3
+`)
+}
+
+func TestHistogramInvalidValueCountEmpty(t *testing.T) {
+	h := &Histogram{}
+	h.SetInvalidValuePolicy(InvalidValueCount)
+	testMarshalTo(t, h, `foo{bar="baz"}`, `foo_invalid_total{bar="baz"} 0
+`)
+	h.Update(math.NaN())
+	testMarshalTo(t, h, `foo{bar="baz"}`, `foo_invalid_total{bar="baz"} 1
+`)
+	h.Reset()
+	testMarshalTo(t, h, `foo{bar="baz"}`, `foo_invalid_total{bar="baz"} 0
+`)
+}
+
+func TestHistogramUpdateBatch(t *testing.T) {
+	f := func(values []float64) {
+		t.Helper()
+		var hExpected Histogram
+		hExpected.SetInvalidValuePolicy(InvalidValueCount)
+		for _, v := range values {
+			hExpected.Update(v)
+		}
+		var bb bytes.Buffer
+		hExpected.marshalTo("prefix", &bb)
+		resultExpected := bb.String()
+
+		var h Histogram
+		h.SetInvalidValuePolicy(InvalidValueCount)
+		h.UpdateBatch(values)
+		testMarshalTo(t, &h, "prefix", resultExpected)
+	}
+	f(nil)
+	f([]float64{1})
+	f([]float64{1, 1, 1, 2, 2, 1, 0, 1e-12, 1e30, math.NaN(), -1, 1e30, 5})
+}
+
+func TestHistogramUpdateWithCount(t *testing.T) {
+	f := func(v float64, count uint64) {
+		t.Helper()
+		var hExpected Histogram
+		hExpected.SetInvalidValuePolicy(InvalidValueCount)
+		for i := uint64(0); i < count; i++ {
+			hExpected.Update(v)
+		}
+		var bb bytes.Buffer
+		hExpected.marshalTo("prefix", &bb)
+		resultExpected := bb.String()
+
+		var h Histogram
+		h.SetInvalidValuePolicy(InvalidValueCount)
+		h.UpdateWithCount(v, count)
+		testMarshalTo(t, &h, "prefix", resultExpected)
+	}
+	f(1, 0)
+	f(1, 1)
+	f(0.5, 10)
+	f(0, 3)
+	f(1e-12, 3)
+	f(1e30, 3)
+	f(-1, 4)
+	f(math.NaN(), 5)
+}
+
+func TestHistogramUpdateBatchConcurrent(t *testing.T) {
+	var h Histogram
+	err := testConcurrent(func() error {
+		for i := 0; i < 10; i++ {
+			h.UpdateBatch([]float64{0.5, 0.5, 1, 2})
+			h.UpdateWithCount(4, 2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMarshalTo(t, &h, "prefix", `prefix_bucket{vmrange="4.642e-01...5.275e-01"} 100
+prefix_bucket{vmrange="8.799e-01...1.000e+00"} 50
+prefix_bucket{vmrange="1.896e+00...2.154e+00"} 50
+prefix_bucket{vmrange="3.594e+00...4.084e+00"} 100
+prefix_sum 600
+prefix_count 300
+`)
+}
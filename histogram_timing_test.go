@@ -15,3 +15,16 @@ func BenchmarkHistogramUpdate(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkHistogramQuantile(b *testing.B) {
+	var h Histogram
+	for i := 0; i < 100000; i++ {
+		h.Update(float64(i) / 1000)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.Quantile(0.99)
+		}
+	})
+}
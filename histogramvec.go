@@ -0,0 +1,68 @@
+package metrics
+
+// HistogramVec is a collection of histograms with the same name and distinct values for the given label names.
+//
+// HistogramVec is safe to use from concurrent goroutines.
+type HistogramVec struct {
+	mv *metricVec
+}
+
+// NewHistogramVec returns new HistogramVec with the given name and labelNames.
+//
+// name must be valid Prometheus-compatible metric name without labels.
+// labelNames must be valid Prometheus-compatible label names.
+//
+// Histograms for the particular label values are created and registered in the default set
+// on the first HistogramVec.WithLabelValues call.
+func NewHistogramVec(name string, labelNames []string) *HistogramVec {
+	return defaultSet.NewHistogramVec(name, labelNames)
+}
+
+// NewHistogramVec returns new HistogramVec with the given name and labelNames, which registers histograms in s.
+//
+// See NewHistogramVec for details.
+func (s *Set) NewHistogramVec(name string, labelNames []string) *HistogramVec {
+	newMetric := func(name string) metric {
+		return s.NewHistogram(name)
+	}
+	return &HistogramVec{
+		mv: newMetricVec(s, name, labelNames, newMetric),
+	}
+}
+
+// WithLabelValues returns the histogram for the given label values.
+//
+// The number of values must match the number of label names passed to NewHistogramVec.
+// The histogram is created and registered on the first call for the given values.
+// Subsequent calls for the same values return the same histogram without memory allocations.
+//
+// Labels in the registered histogram name are ordered in the same way as label names passed to NewHistogramVec.
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return hv.mv.withLabelValues(values).(*Histogram)
+}
+
+// DeleteLabelValues unregisters the histogram for the given label values.
+//
+// True is returned if the histogram has been unregistered.
+func (hv *HistogramVec) DeleteLabelValues(values ...string) bool {
+	return hv.mv.deleteLabelValues(values)
+}
+
+// VisitLabelValues calls f for all the histograms in hv.
+//
+// values contains label values in the order of label names passed to NewHistogramVec. f mustn't modify values.
+func (hv *HistogramVec) VisitLabelValues(f func(values []string, h *Histogram)) {
+	hv.mv.visit(func(values []string, m metric) {
+		f(values, m.(*Histogram))
+	})
+}
+
+// Reset unregisters all the histograms in hv.
+func (hv *HistogramVec) Reset() {
+	hv.mv.reset()
+}
+
+// Len returns the number of histograms in hv.
+func (hv *HistogramVec) Len() int {
+	return hv.mv.len()
+}
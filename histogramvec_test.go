@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHistogramVec(t *testing.T) {
+	s := NewSet()
+	hv := s.NewHistogramVec("request_duration_seconds", []string{"method", "route", "status"})
+	hv.WithLabelValues("GET", "/", "200").Update(0.5)
+	hv.WithLabelValues("POST", "/api", "500").Update(0.5)
+	hv.WithLabelValues("GET", "/", "200").Update(0.5)
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `request_duration_seconds_bucket{method="GET",route="/",status="200",vmrange="4.642e-01...5.275e-01"} 2
+request_duration_seconds_sum{method="GET",route="/",status="200"} 1
+request_duration_seconds_count{method="GET",route="/",status="200"} 2
+request_duration_seconds_bucket{method="POST",route="/api",status="500",vmrange="4.642e-01...5.275e-01"} 1
+request_duration_seconds_sum{method="POST",route="/api",status="500"} 0.5
+request_duration_seconds_count{method="POST",route="/api",status="500"} 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	var visited []string
+	hv.VisitLabelValues(func(values []string, h *Histogram) {
+		var count uint64
+		h.VisitNonZeroBuckets(func(vmrange string, n uint64) {
+			count += n
+		})
+		visited = append(visited, fmt.Sprintf("%s:%d", strings.Join(values, ","), count))
+	})
+	visitedExpected := "GET,/,200:2 POST,/api,500:1"
+	if s := strings.Join(visited, " "); s != visitedExpected {
+		t.Fatalf("unexpected visited histograms; got %q; want %q", s, visitedExpected)
+	}
+
+	if !hv.DeleteLabelValues("GET", "/", "200") {
+		t.Fatalf("expecting the histogram to be deleted")
+	}
+	if n := hv.Len(); n != 1 {
+		t.Fatalf("unexpected number of histograms after deletion; got %d; want 1", n)
+	}
+}
+
+func TestHistogramVecWithLabelValuesNoAllocs(t *testing.T) {
+	s := NewSet()
+	hv := s.NewHistogramVec("foo", []string{"method", "route", "status"})
+	hv.WithLabelValues("GET", "/", "200")
+	allocs := testing.AllocsPerRun(100, func() {
+		hv.WithLabelValues("GET", "/", "200").Update(1)
+	})
+	if allocs != 0 {
+		t.Fatalf("unexpected number of allocations; got %v; want 0", allocs)
+	}
+}
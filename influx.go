@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteInfluxLineProtocol writes all the metrics from the default set and all the added sets to w in InfluxDB line protocol.
+//
+// See https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/
+//
+// Metric labels are written as tags, while metric values are written as fields with the given timestamp ts in nanoseconds.
+// measurementPrefix is prepended to all measurement names. It may be empty.
+//
+// Metrics with the same name and the same set of labels are written as a single line with the `value` field.
+// Metrics with `_sum`, `_count` and `_bucket` suffixes are merged into a single line per each set of labels
+// for the measurement without the suffix. For example, `foo_sum{bar="baz"} 1` and `foo_count{bar="baz"} 2` are written
+// as `foo,bar=baz sum=1,count=2 <ts>`.
+//
+// Lines, which cannot be parsed from metrics written via RegisterMetricsWriter, are skipped.
+func WriteInfluxLineProtocol(w io.Writer, measurementPrefix string, ts time.Time) {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, false)
+	}
+	writeInfluxLineProtocol(w, measurementPrefix, ts, writeMetrics)
+}
+
+// WriteInfluxLineProtocol writes all the metrics from s to w in InfluxDB line protocol.
+//
+// See WriteInfluxLineProtocol for details.
+func (s *Set) WriteInfluxLineProtocol(w io.Writer, measurementPrefix string, ts time.Time) {
+	writeInfluxLineProtocol(w, measurementPrefix, ts, s.WritePrometheus)
+}
+
+// PushInfluxLineProtocol pushes globally registered metrics in InfluxDB line protocol to writeURL.
+//
+// writeURL must point to InfluxDB write endpoint such as `http://influxdb:8086/write?db=foo`
+// or `http://influxdb:8086/api/v2/write?org=foo&bucket=bar`. The `precision=ns` query arg is always added to writeURL.
+//
+// If pushProcessMetrics is set to true, then 'process_*' and `go_*` metrics are also pushed to writeURL.
+//
+// opts may contain additional configuration options if non-nil.
+// By default the POST method is used. opts.ExtraLabels are written as tags.
+//
+// See also WriteInfluxLineProtocol.
+func PushInfluxLineProtocol(ctx context.Context, writeURL, measurementPrefix string, pushProcessMetrics bool, opts *PushOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, pushProcessMetrics)
+	}
+	return pushInfluxLineProtocol(ctx, writeURL, measurementPrefix, writeMetrics, opts)
+}
+
+// PushInfluxLineProtocol pushes metrics from s in InfluxDB line protocol to writeURL.
+//
+// See PushInfluxLineProtocol for details.
+func (s *Set) PushInfluxLineProtocol(ctx context.Context, writeURL, measurementPrefix string, opts *PushOptions) error {
+	return pushInfluxLineProtocol(ctx, writeURL, measurementPrefix, s.WritePrometheus, opts)
+}
+
+// InitPushInfluxLineProtocol sets up periodic push for globally registered metrics in InfluxDB line protocol
+// to writeURL with the given interval.
+//
+// The periodic push is stopped when ctx is canceled.
+// It is possible to wait until the background metrics push worker is stopped on a WaitGroup passed via opts.WaitGroup.
+//
+// See PushInfluxLineProtocol for details.
+func InitPushInfluxLineProtocol(ctx context.Context, writeURL string, interval time.Duration, measurementPrefix string, pushProcessMetrics bool, opts *PushOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, pushProcessMetrics)
+	}
+	pushURL, writeInflux, opts, err := getInfluxPushParams(writeURL, measurementPrefix, writeMetrics, opts)
+	if err != nil {
+		return err
+	}
+	return InitPushExtWithOptions(ctx, pushURL, interval, writeInflux, opts)
+}
+
+func pushInfluxLineProtocol(ctx context.Context, writeURL, measurementPrefix string, writeMetrics func(w io.Writer), opts *PushOptions) error {
+	pushURL, writeInflux, opts, err := getInfluxPushParams(writeURL, measurementPrefix, writeMetrics, opts)
+	if err != nil {
+		return err
+	}
+	return PushMetricsExt(ctx, pushURL, writeInflux, opts)
+}
+
+// getInfluxPushParams returns push url, metrics writer and push options for pushing metrics generated by writeMetrics
+// in InfluxDB line protocol to writeURL.
+func getInfluxPushParams(writeURL, measurementPrefix string, writeMetrics func(w io.Writer), opts *PushOptions) (string, func(w io.Writer), *PushOptions, error) {
+	u, err := url.Parse(writeURL)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("cannot parse writeURL=%q: %w", writeURL, err)
+	}
+	q := u.Query()
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+
+	var optsCopy PushOptions
+	if opts != nil {
+		optsCopy = *opts
+	}
+	if optsCopy.CountersAsDeltas {
+		return "", nil, nil, fmt.Errorf("CountersAsDeltas isn't supported for pushing metrics in InfluxDB line protocol")
+	}
+	if optsCopy.Method == "" {
+		optsCopy.Method = http.MethodPost
+	}
+	// Extra labels must be added to metrics in Prometheus text exposition format before the conversion to InfluxDB line protocol.
+	extraLabels := optsCopy.ExtraLabels
+	optsCopy.ExtraLabels = ""
+	if err := validateTags(extraLabels); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid extraLabels=%q: %w", extraLabels, err)
+	}
+	if extraLabels != "" {
+		writeMetricsOrig := writeMetrics
+		writeMetrics = func(w io.Writer) {
+			bb := getBytesBuffer()
+			defer putBytesBuffer(bb)
+			writeMetricsOrig(bb)
+			w.Write(addExtraLabels(nil, bb.B, extraLabels))
+		}
+	}
+	writeInflux := func(w io.Writer) {
+		writeInfluxLineProtocol(w, measurementPrefix, time.Now(), writeMetrics)
+	}
+	return u.String(), writeInflux, &optsCopy, nil
+}
+
+func writeInfluxLineProtocol(w io.Writer, measurementPrefix string, ts time.Time, writeMetrics func(w io.Writer)) {
+	bbSrc := getBytesBuffer()
+	defer putBytesBuffer(bbSrc)
+	writeMetrics(bbSrc)
+
+	// Group fields by measurement and tags, while preserving the original order of lines.
+	type influxLine struct {
+		key    string
+		fields []byte
+	}
+	var lines []*influxLine
+	m := make(map[string]*influxLine)
+	var keyBuf []byte
+	_ = forEachSample(bbSrc.B, func(ps *parsedSample) {
+		measurement, field := getInfluxMeasurementAndField(ps.metricName)
+		keyBuf = appendInfluxEscaped(keyBuf[:0], measurementPrefix+measurement, ", ")
+		for _, l := range ps.labels {
+			if l.value == "" {
+				// InfluxDB doesn't support empty tag values.
+				continue
+			}
+			keyBuf = append(keyBuf, ',')
+			keyBuf = appendInfluxEscaped(keyBuf, l.name, ",= ")
+			keyBuf = append(keyBuf, '=')
+			keyBuf = appendInfluxEscaped(keyBuf, l.value, ",= ")
+		}
+		il := m[string(keyBuf)]
+		if il == nil {
+			il = &influxLine{
+				key: string(keyBuf),
+			}
+			m[il.key] = il
+			lines = append(lines, il)
+		} else {
+			il.fields = append(il.fields, ',')
+		}
+		il.fields = append(il.fields, field...)
+		il.fields = append(il.fields, '=')
+		il.fields = strconv.AppendFloat(il.fields, ps.value, 'g', -1, 64)
+	})
+
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	timestamp := ts.UnixNano()
+	for _, il := range lines {
+		bb.B = append(bb.B, il.key...)
+		bb.B = append(bb.B, ' ')
+		bb.B = append(bb.B, il.fields...)
+		bb.B = append(bb.B, ' ')
+		bb.B = strconv.AppendInt(bb.B, timestamp, 10)
+		bb.B = append(bb.B, '\n')
+	}
+	w.Write(bb.B)
+}
+
+// getInfluxMeasurementAndField returns InfluxDB measurement and field names for the given metricName.
+func getInfluxMeasurementAndField(metricName string) (string, string) {
+	for _, suffix := range influxFieldSuffixes {
+		if strings.HasSuffix(metricName, suffix) && len(metricName) > len(suffix) {
+			return metricName[:len(metricName)-len(suffix)], suffix[1:]
+		}
+	}
+	return metricName, "value"
+}
+
+var influxFieldSuffixes = []string{"_sum", "_count", "_bucket"}
+
+// appendInfluxEscaped appends s to dst, while escaping chars from specialChars with backslash.
+//
+// Newlines are replaced with `\n`, since they cannot be escaped in InfluxDB line protocol.
+func appendInfluxEscaped(dst []byte, s, specialChars string) []byte {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == '\n' {
+			dst = append(dst, `\n`...)
+			continue
+		}
+		if strings.IndexByte(specialChars, ch) >= 0 {
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
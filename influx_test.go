@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetWriteInfluxLineProtocol(t *testing.T) {
+	f := func(s *Set, measurementPrefix, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WriteInfluxLineProtocol(&bb, measurementPrefix, time.Unix(1, 2))
+		result := bb.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	s := NewSet()
+	f(s, "", "")
+
+	s.NewCounter(`foo{tag="a b,c=d",empty=""}`).Add(3)
+	s.NewGauge("bar", func() float64 { return 1.5 })
+	f(s, "", `bar value=1.5 1000000002
+foo,tag=a\ b\,c\=d value=3 1000000002
+`)
+	f(s, "app_", `app_bar value=1.5 1000000002
+app_foo,tag=a\ b\,c\=d value=3 1000000002
+`)
+
+	s = NewSet()
+	s.NewHistogram(`h{x="y"}`).Update(0.5)
+	sm := s.NewSummaryExt(`s{x="y"}`, time.Minute, []float64{0.5})
+	sm.Update(2)
+	f(s, "", `h,x=y,vmrange=4.642e-01...5.275e-01 bucket=1 1000000002
+h,x=y sum=0.5,count=1 1000000002
+s,x=y,quantile=0.5 value=2 1000000002
+s,x=y sum=2,count=1 1000000002
+`)
+}
+
+func TestPushInfluxLineProtocol(t *testing.T) {
+	var method, query, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		query = r.URL.RawQuery
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("cannot read request body: %s", err)
+		}
+		body = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	s.NewCounter(`foo{bar="baz"}`).Add(42)
+
+	err := s.PushInfluxLineProtocol(context.Background(), srv.URL+"/write?db=test", "", &PushOptions{
+		ExtraLabels:        `instance="abc"`,
+		DisableCompression: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if method != http.MethodPost {
+		t.Fatalf("unexpected method; got %q; want %q", method, http.MethodPost)
+	}
+	if query != "db=test&precision=ns" {
+		t.Fatalf("unexpected query; got %q; want %q", query, "db=test&precision=ns")
+	}
+	prefixExpected := "foo,instance=abc,bar=baz value=42 "
+	if !bytes.HasPrefix([]byte(body), []byte(prefixExpected)) {
+		t.Fatalf("unexpected body; got %q; want prefix %q", body, prefixExpected)
+	}
+
+	if err := s.PushInfluxLineProtocol(context.Background(), srv.URL, "", &PushOptions{
+		ExtraLabels: "bad",
+	}); err == nil {
+		t.Fatalf("expecting non-nil error for invalid ExtraLabels")
+	}
+}
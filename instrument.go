@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// InstrumentHandler returns http.Handler, which serves requests with h and registers the following metrics
+// for the served requests in the default set:
+//
+//   - http_requests_total{handler="...",method="...",code="..."} - the number of served requests
+//   - http_request_duration_seconds{handler="...",method="..."} - request duration histogram
+//   - http_request_size_bytes{handler="...",method="..."} - request body size summary
+//   - http_response_size_bytes{handler="...",method="..."} - response body size summary
+//   - http_requests_in_flight{handler="..."} - the number of concurrently served requests
+//   - http_request_panics_total{handler="..."} - the number of panics in h
+//
+// The handler label is set to handlerName. The method label is set to the request method
+// or to `other` for non-standard methods. The code label is set to the response status class such as `2xx` or `5xx`.
+// This keeps the number of exposed series bounded.
+//
+// Panics in h are counted and then re-panicked, so http.Server handles them as usual.
+//
+// Usage:
+//
+//	http.Handle("/api", metrics.InstrumentHandler("api", apiHandler))
+func InstrumentHandler(handlerName string, h http.Handler) http.Handler {
+	return defaultSet.InstrumentHandler(handlerName, h)
+}
+
+// InstrumentHandler returns http.Handler, which serves requests with h and registers metrics for the served requests in s.
+//
+// See InstrumentHandler for details.
+func (s *Set) InstrumentHandler(handlerName string, h http.Handler) http.Handler {
+	hm := s.getHTTPServerMetrics()
+	requestsInFlight := hm.requestsInFlight.WithLabelValues(handlerName)
+	panics := hm.panics.WithLabelValues(handlerName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		requestsInFlight.Inc()
+
+		rw := &instrumentedResponseWriter{
+			w: w,
+		}
+		var body *countingReadCloser
+		if r.ContentLength < 0 && r.Body != nil {
+			// The request size is unknown, so count the read bytes.
+			body = &countingReadCloser{
+				rc: r.Body,
+			}
+			r.Body = body
+		}
+
+		completed := false
+		defer func() {
+			requestsInFlight.Dec()
+			if !completed {
+				panics.Inc()
+				return
+			}
+
+			method := getInstrumentedMethod(r.Method)
+			hm.requests.WithLabelValues(handlerName, method, getStatusClass(rw.getStatusCode())).Inc()
+			hm.requestDuration.WithLabelValues(handlerName, method).UpdateDuration(startTime)
+			requestSize := r.ContentLength
+			if body != nil {
+				requestSize = body.n
+			}
+			hm.requestSize.WithLabelValues(handlerName, method).Update(float64(requestSize))
+			hm.responseSize.WithLabelValues(handlerName, method).Update(float64(rw.written))
+		}()
+		h.ServeHTTP(rw, r)
+		completed = true
+	})
+}
+
+// httpServerMetrics contains metrics shared among handlers returned from Set.InstrumentHandler.
+type httpServerMetrics struct {
+	requests         *CounterVec
+	requestDuration  *HistogramVec
+	requestSize      *SummaryVec
+	responseSize     *SummaryVec
+	requestsInFlight *GaugeVec
+	panics           *CounterVec
+}
+
+func (s *Set) getHTTPServerMetrics() *httpServerMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.httpServerMetrics == nil {
+		s.httpServerMetrics = &httpServerMetrics{
+			requests:         s.NewCounterVec("http_requests_total", []string{"handler", "method", "code"}),
+			requestDuration:  s.NewHistogramVec("http_request_duration_seconds", []string{"handler", "method"}),
+			requestSize:      s.NewSummaryVec("http_request_size_bytes", []string{"handler", "method"}),
+			responseSize:     s.NewSummaryVec("http_response_size_bytes", []string{"handler", "method"}),
+			requestsInFlight: s.NewGaugeVec("http_requests_in_flight", []string{"handler"}),
+			panics:           s.NewCounterVec("http_request_panics_total", []string{"handler"}),
+		}
+	}
+	return s.httpServerMetrics
+}
+
+// getInstrumentedMethod returns method label value for the given request method.
+//
+// Non-standard methods are replaced with `other`, since they may be set to arbitrary values by clients.
+func getInstrumentedMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+var statusClasses = [...]string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// getStatusClass returns status class such as `2xx` for the given statusCode.
+func getStatusClass(statusCode int) string {
+	n := statusCode/100 - 1
+	if n < 0 || n >= len(statusClasses) {
+		return "unknown"
+	}
+	return statusClasses[n]
+}
+
+// instrumentedResponseWriter tracks the status code and the number of bytes written to w.
+//
+// It supports http.Flusher, http.Hijacker and io.ReaderFrom if w supports them.
+type instrumentedResponseWriter struct {
+	w http.ResponseWriter
+
+	statusCode  int
+	wroteHeader bool
+	written     int64
+}
+
+func (rw *instrumentedResponseWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *instrumentedResponseWriter) WriteHeader(statusCode int) {
+	// Informational status codes except of `101 Switching Protocols` may be sent multiple times before the final status code.
+	if !rw.wroteHeader && (statusCode >= 200 || statusCode == http.StatusSwitchingProtocols) {
+		rw.statusCode = statusCode
+		rw.wroteHeader = true
+	}
+	rw.w.WriteHeader(statusCode)
+}
+
+func (rw *instrumentedResponseWriter) Write(p []byte) (int, error) {
+	rw.setImplicitStatusCode()
+	n, err := rw.w.Write(p)
+	rw.written += int64(n)
+	return n, err
+}
+
+// ReadFrom implements io.ReaderFrom, so http.ResponseWriter may use sendfile for serving files.
+func (rw *instrumentedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	rw.setImplicitStatusCode()
+	var n int64
+	var err error
+	if rf, ok := rw.w.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		// Hide ReadFrom method of rw from io.Copy in order to avoid infinite recursion.
+		n, err = io.Copy(writerOnly{rw.w}, r)
+	}
+	rw.written += n
+	return n, err
+}
+
+// Flush implements http.Flusher. It is no-op if the underlying http.ResponseWriter doesn't support flushing.
+func (rw *instrumentedResponseWriter) Flush() {
+	rw.setImplicitStatusCode()
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. It returns error if the underlying http.ResponseWriter doesn't support hijacking.
+func (rw *instrumentedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T doesn't support hijacking", rw.w)
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the underlying http.ResponseWriter. It is used by http.ResponseController.
+func (rw *instrumentedResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.w
+}
+
+func (rw *instrumentedResponseWriter) setImplicitStatusCode() {
+	if !rw.wroteHeader {
+		rw.statusCode = http.StatusOK
+		rw.wroteHeader = true
+	}
+}
+
+func (rw *instrumentedResponseWriter) getStatusCode() int {
+	if !rw.wroteHeader {
+		// http.Server sends `200 OK` if the handler doesn't write anything.
+		return http.StatusOK
+	}
+	return rw.statusCode
+}
+
+type writerOnly struct {
+	io.Writer
+}
+
+// countingReadCloser counts the number of bytes read from rc.
+type countingReadCloser struct {
+	rc io.ReadCloser
+	n  int64
+}
+
+func (cr *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := cr.rc.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReadCloser) Close() error {
+	return cr.rc.Close()
+}
@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetInstrumentHandler(t *testing.T) {
+	s := NewSet()
+	h := s.InstrumentHandler("api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("cannot read request body: %s", err)
+		}
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "not found", http.StatusNotFound)
+		case "/empty":
+		default:
+			w.Write(data)
+		}
+	}))
+
+	f := func(method, path, body string) {
+		t.Helper()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+	}
+	f("GET", "/foo", "")
+	f("POST", "/foo", "abcd")
+	f("POST", "/foo", "ab")
+	f("GET", "/missing", "")
+	f("GET", "/empty", "")
+	f("FOOBAR", "/foo", "")
+
+	expectCounter := func(name string, valueExpected uint64) {
+		t.Helper()
+		c := s.GetOrCreateCounter(name)
+		if n := c.Get(); n != valueExpected {
+			t.Fatalf("unexpected value for %s; got %d; want %d", name, n, valueExpected)
+		}
+	}
+	expectCounter(`http_requests_total{code="2xx",handler="api",method="GET"}`, 2)
+	expectCounter(`http_requests_total{code="4xx",handler="api",method="GET"}`, 1)
+	expectCounter(`http_requests_total{code="2xx",handler="api",method="POST"}`, 2)
+	expectCounter(`http_requests_total{code="2xx",handler="api",method="other"}`, 1)
+	expectCounter(`http_request_panics_total{handler="api"}`, 0)
+
+	expectSummary := func(name string, countExpected uint64, sumExpected float64) {
+		t.Helper()
+		sm := s.GetOrCreateSummary(name)
+		if n := sm.GetCount(); n != countExpected {
+			t.Fatalf("unexpected count for %s; got %d; want %d", name, n, countExpected)
+		}
+		if sum := sm.GetSum(); sum != sumExpected {
+			t.Fatalf("unexpected sum for %s; got %v; want %v", name, sum, sumExpected)
+		}
+	}
+	expectSummary(`http_request_size_bytes{handler="api",method="POST"}`, 2, 6)
+	expectSummary(`http_response_size_bytes{handler="api",method="POST"}`, 2, 6)
+	expectSummary(`http_response_size_bytes{handler="api",method="GET"}`, 3, float64(len("not found\n")))
+
+	hist := s.GetOrCreateHistogram(`http_request_duration_seconds{handler="api",method="GET"}`)
+	var count uint64
+	hist.VisitNonZeroBuckets(func(vmrange string, n uint64) {
+		count += n
+	})
+	if count != 3 {
+		t.Fatalf("unexpected number of durations for GET requests; got %d; want 3", count)
+	}
+
+	g := s.GetOrCreateGauge(`http_requests_in_flight{handler="api"}`, nil)
+	if n := g.Get(); n != 0 {
+		t.Fatalf("unexpected number of requests in flight; got %v; want 0", n)
+	}
+}
+
+func TestSetInstrumentHandlerInFlight(t *testing.T) {
+	s := NewSet()
+	h := s.InstrumentHandler("slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := s.GetOrCreateGauge(`http_requests_in_flight{handler="slow"}`, nil)
+		if n := g.Get(); n != 1 {
+			t.Fatalf("unexpected number of requests in flight; got %v; want 1", n)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	g := s.GetOrCreateGauge(`http_requests_in_flight{handler="slow"}`, nil)
+	if n := g.Get(); n != 0 {
+		t.Fatalf("unexpected number of requests in flight; got %v; want 0", n)
+	}
+}
+
+func TestSetInstrumentHandlerPanic(t *testing.T) {
+	s := NewSet()
+	h := s.InstrumentHandler("panicky", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}))
+	expectPanic(t, "handler panic", func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+	if n := s.GetOrCreateCounter(`http_request_panics_total{handler="panicky"}`).Get(); n != 1 {
+		t.Fatalf("unexpected number of panics; got %d; want 1", n)
+	}
+	if n := s.GetOrCreateGauge(`http_requests_in_flight{handler="panicky"}`, nil).Get(); n != 0 {
+		t.Fatalf("unexpected number of requests in flight; got %v; want 0", n)
+	}
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	if strings.Contains(bb.String(), "http_requests_total") {
+		t.Fatalf("unexpected http_requests_total for panicked request\n%s", bb.String())
+	}
+}
+
+func TestInstrumentedResponseWriter(t *testing.T) {
+	f := func(h http.HandlerFunc, statusCodeExpected int, writtenExpected int64) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		rw := &instrumentedResponseWriter{
+			w: w,
+		}
+		h(rw, httptest.NewRequest("GET", "/", nil))
+		if statusCode := rw.getStatusCode(); statusCode != statusCodeExpected {
+			t.Fatalf("unexpected status code; got %d; want %d", statusCode, statusCodeExpected)
+		}
+		if rw.written != writtenExpected {
+			t.Fatalf("unexpected number of written bytes; got %d; want %d", rw.written, writtenExpected)
+		}
+		if int64(w.Body.Len()) != writtenExpected {
+			t.Fatalf("unexpected response size; got %d; want %d", w.Body.Len(), writtenExpected)
+		}
+	}
+
+	// Nothing written
+	f(func(w http.ResponseWriter, r *http.Request) {}, 200, 0)
+
+	// Explicit status code
+	f(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("foo"))
+	}, 418, 3)
+
+	// Informational status code before the final status code
+	f(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(103)
+		w.WriteHeader(http.StatusCreated)
+	}, 201, 0)
+
+	// Status code after Write is ignored
+	f(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foo"))
+		w.WriteHeader(http.StatusInternalServerError)
+	}, 200, 3)
+
+	// Flush
+	f(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foo"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("bar"))
+	}, 200, 6)
+
+	// ReadFrom
+	f(func(w http.ResponseWriter, r *http.Request) {
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader("foobar"))
+	}, 200, 6)
+
+	// Hijack isn't supported by httptest.ResponseRecorder
+	f(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}, 200, 0)
+}
+
+func TestInstrumentHandlerHijack(t *testing.T) {
+	s := NewSet()
+	h := s.InstrumentHandler("hijack", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, bw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("cannot hijack connection: %s", err)
+			return
+		}
+		defer conn.Close()
+		bw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+		bw.Flush()
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("cannot perform request: %s", err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("cannot read response: %s", err)
+	}
+	if string(data) != "ok" {
+		t.Fatalf("unexpected response; got %q; want %q", data, "ok")
+	}
+}
+
+func TestGetStatusClass(t *testing.T) {
+	f := func(statusCode int, resultExpected string) {
+		t.Helper()
+		if result := getStatusClass(statusCode); result != resultExpected {
+			t.Fatalf("unexpected status class for %d; got %q; want %q", statusCode, result, resultExpected)
+		}
+	}
+	f(101, "1xx")
+	f(200, "2xx")
+	f(204, "2xx")
+	f(302, "3xx")
+	f(404, "4xx")
+	f(503, "5xx")
+	f(0, "unknown")
+	f(99, "unknown")
+	f(600, "unknown")
+}
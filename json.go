@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// WriteJSON writes all the metrics from the default set and all the added sets to w as JSON array
+// plus the metrics for the current process enabled in opts.
+//
+// Every metric is written as JSON object on a separate line. For example:
+//
+//	{"name":"requests_total","labels":{"path":"/foo"},"type":"counter","value":123}
+//
+// Histograms are written with "buckets", "sum" and "count" fields instead of "value". Buckets for Histogram contain
+// "vmrange" and non-cumulative "count" fields, while buckets for PrometheusHistogram contain "le" and cumulative "count" fields.
+// Summaries are written with "quantiles", "sum" and "count" fields. Metrics of other types, metrics written
+// via RegisterMetricsWriter and process metrics are written per sample with the type of the metric or "untyped" type.
+// The "help" field is written for metrics with the description set via Help options.
+//
+// Metrics are sorted by names, so the output is deterministic. They are streamed to w one by one.
+// NaN and Inf values are written as "NaN", "+Inf" and "-Inf" strings, since JSON doesn't support them.
+// opts.ExtraLabels are added to every metric unless the metric already has a label with the same name.
+//
+// See also Handler, which writes JSON if the client requests `application/json` or `?format=json`.
+func WriteJSON(w io.Writer, opts WritePrometheusOpts) {
+	if opts.HostnameLabel != "" {
+		if err := validateIdent(opts.HostnameLabel); err != nil {
+			panic(fmt.Errorf("BUG: invalid HostnameLabel %q: %w", opts.HostnameLabel, err))
+		}
+	}
+	sets := getRegisteredSets()
+	runPreWriteHooks(sets)
+	jw := &jsonWriter{
+		w:           w,
+		extraLabels: getSortedExtraLabels(opts.ExtraLabels),
+	}
+	jw.writeString("[")
+	sa, metricsWriters := getMergedSortedMetrics(sets)
+	jw.writeMetrics(sa)
+	bb := getBytesBuffer()
+	for _, mw := range metricsWriters {
+		mw.write(bb)
+	}
+	writeOptionalProcessMetrics(bb, &opts)
+	jw.writeTextMetrics(bb.B, "untyped", "")
+	putBytesBuffer(bb)
+	jw.writeString("\n]\n")
+}
+
+// WriteJSON writes all the metrics from s to w as JSON array.
+//
+// See WriteJSON for details.
+func (s *Set) WriteJSON(w io.Writer) {
+	s.runPreWriteHooks()
+	jw := &jsonWriter{
+		w: w,
+	}
+	jw.writeString("[")
+	sa, metricsWriters := s.getSortedMetrics()
+	jw.writeMetrics(sa)
+	bb := getBytesBuffer()
+	for _, mw := range metricsWriters {
+		mw.write(bb)
+	}
+	jw.writeTextMetrics(bb.B, "untyped", "")
+	putBytesBuffer(bb)
+	jw.writeString("\n]\n")
+}
+
+// jsonWriter writes metrics to w as JSON objects separated by commas.
+type jsonWriter struct {
+	w           io.Writer
+	extraLabels []label
+
+	// buf is used for marshaling a single JSON object.
+	buf    []byte
+	labels []label
+
+	// items is the number of written objects.
+	items int
+}
+
+func (jw *jsonWriter) writeString(s string) {
+	_, _ = io.WriteString(jw.w, s)
+}
+
+func (jw *jsonWriter) writeMetrics(sa []*namedMetric) {
+	for _, nm := range sa {
+		if nm.isAux {
+			// Auxiliary metrics such as summary quantiles are written by the parent metric.
+			continue
+		}
+		jm, ok := nm.metric.(jsonMarshaler)
+		if !ok {
+			bb := getBytesBuffer()
+			nm.metric.marshalTo(nm.name, bb)
+			jw.writeTextMetrics(bb.B, nm.metric.metricType(), nm.getHelp())
+			putBytesBuffer(bb)
+			continue
+		}
+		name, labelsStr := splitMetricName(nm.name)
+		jw.labels = jw.labels[:0]
+		if labelsStr != "" {
+			var err error
+			jw.labels, _, err = parseLabels(jw.labels, labelsStr[1:])
+			if err != nil {
+				// nm.name is validated on registration, so this must be impossible.
+				continue
+			}
+		}
+		dst := jw.appendObjectPrefix(jw.buf[:0], name, jw.labels, nm.metric.metricType(), nm.getHelp())
+		dst = jm.marshalJSON(dst)
+		jw.buf = append(dst, '}')
+		jw.writeObject(jw.buf)
+	}
+}
+
+// writeTextMetrics writes samples from src in Prometheus text exposition format as JSON objects.
+//
+// Samples with the base name of the metric get metricType, while other samples such as histogram buckets get "untyped" type.
+func (jw *jsonWriter) writeTextMetrics(src []byte, metricType, help string) {
+	if metricType == "unsupported" {
+		metricType = "untyped"
+	}
+	baseName := ""
+	_ = forEachSample(src, func(ps *parsedSample) {
+		if baseName == "" {
+			baseName = ps.metricName
+		}
+		sampleType := metricType
+		sampleHelp := help
+		if ps.metricName != baseName {
+			sampleType = "untyped"
+			sampleHelp = ""
+		}
+		dst := jw.appendObjectPrefix(jw.buf[:0], ps.metricName, ps.labels, sampleType, sampleHelp)
+		dst = append(dst, `,"value":`...)
+		dst = appendJSONFloat(dst, ps.value)
+		jw.buf = append(dst, '}')
+		jw.writeObject(jw.buf)
+	})
+}
+
+func (jw *jsonWriter) writeObject(b []byte) {
+	if jw.items > 0 {
+		jw.writeString(",")
+	}
+	jw.writeString("\n")
+	_, _ = jw.w.Write(b)
+	jw.items++
+}
+
+// appendObjectPrefix appends the opening of JSON object with name, labels, type and optional help fields to dst.
+func (jw *jsonWriter) appendObjectPrefix(dst []byte, name string, labels []label, metricType, help string) []byte {
+	dst = append(dst, `{"name":`...)
+	dst = appendJSONString(dst, name)
+	dst = append(dst, `,"labels":{`...)
+	n := 0
+	appendLabel := func(l label) {
+		if n > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, l.name)
+		dst = append(dst, ':')
+		dst = appendJSONString(dst, l.value)
+		n++
+	}
+	for _, l := range labels {
+		appendLabel(l)
+	}
+	for _, el := range jw.extraLabels {
+		if !hasLabel(labels, el.name) {
+			appendLabel(el)
+		}
+	}
+	dst = append(dst, `},"type":`...)
+	dst = appendJSONString(dst, metricType)
+	if help != "" {
+		dst = append(dst, `,"help":`...)
+		dst = appendJSONString(dst, help)
+	}
+	return dst
+}
+
+// jsonMarshaler must be implemented by metrics with dedicated JSON representation.
+type jsonMarshaler interface {
+	// marshalJSON must append the fields with metric values to dst. Every field must start with a comma.
+	marshalJSON(dst []byte) []byte
+}
+
+func (c *Counter) marshalJSON(dst []byte) []byte {
+	dst = append(dst, `,"value":`...)
+	return strconv.AppendUint(dst, c.Get(), 10)
+}
+
+func (fc *FloatCounter) marshalJSON(dst []byte) []byte {
+	dst = append(dst, `,"value":`...)
+	return appendJSONFloat(dst, fc.Get())
+}
+
+func (g *Gauge) marshalJSON(dst []byte) []byte {
+	dst = append(dst, `,"value":`...)
+	return appendJSONFloat(dst, g.Get())
+}
+
+func (h *Histogram) marshalJSON(dst []byte) []byte {
+	dst = append(dst, `,"buckets":[`...)
+	countTotal := uint64(0)
+	sum := h.visitNonZeroBucketsWithKeys(func(_ int, vmrange string, count uint64) {
+		if countTotal > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, `{"vmrange":`...)
+		dst = appendJSONString(dst, vmrange)
+		dst = append(dst, `,"count":`...)
+		dst = strconv.AppendUint(dst, count, 10)
+		dst = append(dst, '}')
+		countTotal += count
+	})
+	dst = append(dst, `],"sum":`...)
+	dst = appendJSONFloat(dst, sum)
+	dst = append(dst, `,"count":`...)
+	return strconv.AppendUint(dst, countTotal, 10)
+}
+
+func (ph *PrometheusHistogram) marshalJSON(dst []byte) []byte {
+	dst = append(dst, `,"buckets":[`...)
+	countTotal := uint64(0)
+	for i, leLabel := range ph.leLabels {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		countTotal += atomic.LoadUint64(&ph.buckets[i])
+		le := leLabel[len(`le="`) : len(leLabel)-1]
+		dst = append(dst, `{"le":`...)
+		dst = appendJSONString(dst, le)
+		dst = append(dst, `,"count":`...)
+		dst = strconv.AppendUint(dst, countTotal, 10)
+		dst = append(dst, '}')
+	}
+	dst = append(dst, `],"sum":`...)
+	dst = appendJSONFloat(dst, math.Float64frombits(ph.sumBits.Load()))
+	dst = append(dst, `,"count":`...)
+	return strconv.AppendUint(dst, countTotal, 10)
+}
+
+func (sm *Summary) marshalJSON(dst []byte) []byte {
+	sm.mu.Lock()
+	quantileValues := sm.backend.quantiles(nil, sm.quantiles)
+	sum, count := sm.sumAndCountLocked()
+	sm.mu.Unlock()
+
+	dst = append(dst, `,"quantiles":[`...)
+	for i, q := range sm.quantiles {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, `{"quantile":`...)
+		dst = appendJSONFloat(dst, q)
+		dst = append(dst, `,"value":`...)
+		dst = appendJSONFloat(dst, quantileValues[i])
+		dst = append(dst, '}')
+	}
+	dst = append(dst, `],"sum":`...)
+	dst = appendJSONFloat(dst, sum)
+	dst = append(dst, `,"count":`...)
+	return strconv.AppendUint(dst, count, 10)
+}
+
+// appendJSONFloat appends v to dst as JSON number. NaN and Inf values are appended as JSON strings.
+func appendJSONFloat(dst []byte, v float64) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(dst, `"NaN"`...)
+	case math.IsInf(v, 1):
+		return append(dst, `"+Inf"`...)
+	case math.IsInf(v, -1):
+		return append(dst, `"-Inf"`...)
+	}
+	return strconv.AppendFloat(dst, v, 'g', -1, 64)
+}
+
+// appendJSONString appends s to dst as quoted JSON string.
+//
+// Invalid UTF-8 sequences are replaced with U+FFFD.
+func appendJSONString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				dst = append(dst, '\\', c)
+			case c == '\n':
+				dst = append(dst, '\\', 'n')
+			case c == '\r':
+				dst = append(dst, '\\', 'r')
+			case c == '\t':
+				dst = append(dst, '\\', 't')
+			case c < 0x20:
+				dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				dst = append(dst, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, "\ufffd"...)
+		} else {
+			dst = append(dst, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(dst, '"')
+}
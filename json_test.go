@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestSetWriteJSON(t *testing.T) {
+	f := func(s *Set, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WriteJSON(&bb)
+		result := bb.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+		var items []map[string]interface{}
+		if err := json.Unmarshal(bb.Bytes(), &items); err != nil {
+			t.Fatalf("cannot parse the result as JSON: %s", err)
+		}
+	}
+
+	// Empty set
+	f(NewSet(), "[\n]\n")
+
+	// Counters and gauges
+	s := NewSet()
+	s.NewCounter(`requests_total{path="/foo",code="200"}`).Add(123)
+	s.NewFloatCounter("bytes_total").Add(1.5)
+	s.NewGauge("nan_gauge", func() float64 { return math.NaN() })
+	s.NewGauge("inf_gauge", func() float64 { return math.Inf(-1) })
+	f(s, `[
+{"name":"bytes_total","labels":{},"type":"counter","value":1.5},
+{"name":"inf_gauge","labels":{},"type":"gauge","value":"-Inf"},
+{"name":"nan_gauge","labels":{},"type":"gauge","value":"NaN"},
+{"name":"requests_total","labels":{"path":"/foo","code":"200"},"type":"counter","value":123}
+]
+`)
+
+	// Help
+	s = NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: "helped_total",
+		Help: "Counter with \"quoted\" help",
+	}).Inc()
+	f(s, `[
+{"name":"helped_total","labels":{},"type":"counter","help":"Counter with \"quoted\" help","value":1}
+]
+`)
+
+	// Histograms
+	s = NewSet()
+	s.NewHistogram(`vm_histogram{a="b"}`).Update(1)
+	ph := s.NewHistogramWithBuckets("prom_histogram", []float64{1, 2})
+	ph.Update(0.5)
+	ph.Update(1.5)
+	ph.Update(5)
+	f(s, `[
+{"name":"prom_histogram","labels":{},"type":"histogram","buckets":[{"le":"1","count":1},{"le":"2","count":2},{"le":"+Inf","count":3}],"sum":7,"count":3},
+{"name":"vm_histogram","labels":{"a":"b"},"type":"histogram","buckets":[{"vmrange":"8.799e-01...1.000e+00","count":1}],"sum":1,"count":1}
+]
+`)
+
+	// Summary
+	s = NewSet()
+	sm := s.NewSummaryExt("summary", defaultSummaryWindow, []float64{0.5, 1})
+	sm.Update(2)
+	f(s, `[
+{"name":"summary","labels":{},"type":"summary","quantiles":[{"quantile":0.5,"value":2},{"quantile":1,"value":2}],"sum":2,"count":1}
+]
+`)
+
+	// Metrics writer
+	s = NewSet()
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		WriteGaugeFloat64(w, `custom_gauge{x="y"}`, 0.25)
+	})
+	f(s, `[
+{"name":"custom_gauge","labels":{"x":"y"},"type":"untyped","value":0.25}
+]
+`)
+}
+
+func TestAppendJSONString(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+		result := string(appendJSONString(nil, s))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q; got %s; want %s", s, result, resultExpected)
+		}
+		var v string
+		if err := json.Unmarshal([]byte(result), &v); err != nil {
+			t.Fatalf("cannot parse %s: %s", result, err)
+		}
+	}
+	f("", `""`)
+	f("foo", `"foo"`)
+	f(`a"b\c`, `"a\"b\\c"`)
+	f("a\nb\tc\rd\x01", `"a\nb\tc\rd\u0001"`)
+	f("привет", `"привет"`)
+	f("a\xffb", "\"a�b\"")
+}
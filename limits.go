@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+// ErrMetricsLimitReached is returned from New*Err functions and TryRegisterMetric when the limit set via SetMaxMetrics is reached.
+var ErrMetricsLimitReached = errors.New("metrics: the limit on the number of registered metrics is reached")
+
+// SetMaxMetrics limits the number of metrics, which can be registered in the default set.
+//
+// GetOrCreate* functions return a shared overflow metric, which isn't registered and isn't exposed,
+// when the limit is reached. New*Err functions and TryRegisterMetric return an error wrapping ErrMetricsLimitReached.
+// New* functions, which panic on errors, aren't limited, since they are usually called with a fixed set of names at init time,
+// but the metrics registered by them are counted towards the limit.
+//
+// Every metric, which couldn't be registered because of the limit, is counted by metrics_dropped_due_to_limit_total counter.
+// The counter is written by WritePrometheus, WriteSets and other functions, which write metrics from multiple sets,
+// if it has non-zero value.
+//
+// The number of metrics isn't limited if n is zero. This is the default.
+func SetMaxMetrics(n int) {
+	defaultSet.SetMaxMetrics(n)
+}
+
+// SetMaxMetrics limits the number of metrics, which can be registered in s.
+//
+// See SetMaxMetrics for details.
+func (s *Set) SetMaxMetrics(n int) {
+	if n < 0 {
+		panic(fmt.Errorf("BUG: n cannot be negative; got %d", n))
+	}
+	s.mu.Lock()
+	s.maxMetrics = n
+	s.mu.Unlock()
+}
+
+// metricsDroppedDueToLimitTotal is the number of metrics, which weren't registered because of the limit set via SetMaxMetrics.
+var metricsDroppedDueToLimitTotal uint64
+
+const metricsDroppedDueToLimitTotalName = "metrics_dropped_due_to_limit_total"
+
+// isMetricsLimitReachedLocked returns true if a new metric cannot be registered in s because of the limit set via SetMaxMetrics.
+func (s *Set) isMetricsLimitReachedLocked() bool {
+	return s.maxMetrics > 0 && s.metricsCount >= s.maxMetrics
+}
+
+// checkMetricsLimitLocked returns an error if the metric with the given name cannot be registered in s
+// because of the limit set via SetMaxMetrics.
+func (s *Set) checkMetricsLimitLocked(name string) error {
+	if !s.isMetricsLimitReachedLocked() {
+		return nil
+	}
+	atomic.AddUint64(&metricsDroppedDueToLimitTotal, 1)
+	return fmt.Errorf("cannot register metric %q, since %d metrics are already registered: %w", name, s.metricsCount, ErrMetricsLimitReached)
+}
+
+// overflowMetrics contains shared metrics returned from GetOrCreate* functions when the limit set via SetMaxMetrics is reached.
+//
+// These metrics aren't registered, so their values aren't exposed.
+type overflowMetrics struct {
+	counter        *Counter
+	floatCounter   *FloatCounter
+	gauge          *Gauge
+	histogram      *Histogram
+	shardedCounter *ShardedCounter
+}
+
+// getOverflowMetricLocked returns the shared overflow metric with the type of m.
+//
+// m is returned as is for metric types with params such as summaries and histograms with buckets,
+// since these metrics cannot be shared across callers with distinct params.
+//
+// The dropped metric is counted by metrics_dropped_due_to_limit_total.
+func (s *Set) getOverflowMetricLocked(m metric) metric {
+	atomic.AddUint64(&metricsDroppedDueToLimitTotal, 1)
+	if s.overflow == nil {
+		s.overflow = &overflowMetrics{}
+	}
+	om := s.overflow
+	switch m.(type) {
+	case *Counter:
+		if om.counter == nil {
+			om.counter = &Counter{}
+		}
+		return om.counter
+	case *FloatCounter:
+		if om.floatCounter == nil {
+			om.floatCounter = &FloatCounter{}
+		}
+		return om.floatCounter
+	case *Gauge:
+		if om.gauge == nil {
+			om.gauge = &Gauge{}
+		}
+		return om.gauge
+	case *Histogram:
+		if om.histogram == nil {
+			om.histogram = &Histogram{}
+		}
+		return om.histogram
+	case *ShardedCounter:
+		if om.shardedCounter == nil {
+			om.shardedCounter = newShardedCounter()
+		}
+		return om.shardedCounter
+	default:
+		return m
+	}
+}
+
+// writeMetricsDroppedDueToLimit writes metrics_dropped_due_to_limit_total counter matching mf to w if it has non-zero value.
+func writeMetricsDroppedDueToLimit(w io.Writer, mf *metricNameFilter) {
+	n := atomic.LoadUint64(&metricsDroppedDueToLimitTotal)
+	if n == 0 || !mf.match(metricsDroppedDueToLimitTotalName) {
+		return
+	}
+	WriteCounterUint64(w, metricsDroppedDueToLimitTotalName, n)
+}
+
+// truncateResponse truncates the response b in the given exposition format to maxBytes.
+//
+// The response is truncated at sample line boundaries for text formats. The trailing comment is added
+// to the truncated response in Prometheus text exposition format, while `# EOF` line is added to the truncated response
+// in OpenMetrics format, since OpenMetrics doesn't allow arbitrary comments. The response in protobuf format
+// is truncated at message boundaries, while the response in JSON format is truncated at object boundaries.
+func truncateResponse(b []byte, format, maxBytes int) []byte {
+	if len(b) <= maxBytes {
+		return b
+	}
+	var tail string
+	switch format {
+	case expositionFormatProtobuf:
+		return truncateDelimitedMessages(b, maxBytes)
+	case expositionFormatJSON:
+		return truncateJSON(b, maxBytes)
+	case expositionFormatOpenMetrics:
+		tail = "# EOF\n"
+	default:
+		tail = "# metrics response is truncated, since its size exceeds MaxResponseBytes=" + strconv.Itoa(maxBytes) + "\n"
+	}
+	n := maxBytes - len(tail)
+	if n < 0 {
+		n = 0
+	}
+	b = b[:n]
+	for len(b) > 0 && b[len(b)-1] != '\n' {
+		b = b[:len(b)-1]
+	}
+	return append(b, tail...)
+}
+
+// truncateJSON truncates JSON array written by WriteJSON to maxBytes at object boundaries, so the result remains valid JSON.
+func truncateJSON(b []byte, maxBytes int) []byte {
+	const tail = "\n]\n"
+	n := maxBytes - len(tail)
+	if n < len("[") {
+		n = len("[")
+	}
+	if n > len(b) {
+		n = len(b)
+	}
+	b = b[:n]
+	// Every object in WriteJSON output starts on a new line, so drop the last incomplete object.
+	for len(b) > 1 && b[len(b)-1] != '\n' {
+		b = b[:len(b)-1]
+	}
+	if len(b) > 1 {
+		b = b[:len(b)-1]
+	}
+	if len(b) > 1 && b[len(b)-1] == ',' {
+		b = b[:len(b)-1]
+	}
+	return append(b, tail...)
+}
+
+// truncateDelimitedMessages returns the longest prefix of varint length-delimited messages in b, which fits maxBytes.
+func truncateDelimitedMessages(b []byte, maxBytes int) []byte {
+	n := 0
+	for n < len(b) {
+		size, sizeLen := binary.Uvarint(b[n:])
+		if sizeLen <= 0 {
+			break
+		}
+		end := n + sizeLen + int(size)
+		if end > maxBytes || end > len(b) || end < n {
+			break
+		}
+		n = end
+	}
+	return b[:n]
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetMaxMetrics(t *testing.T) {
+	s := NewSet()
+	s.SetMaxMetrics(3)
+	s.NewSummary("summary")
+	c := s.GetOrCreateCounter("foo_total")
+	c.Inc()
+
+	// Restore the global counter, since other tests verify the exact output of WriteSets.
+	droppedPrev := atomic.LoadUint64(&metricsDroppedDueToLimitTotal)
+	defer atomic.StoreUint64(&metricsDroppedDueToLimitTotal, droppedPrev)
+	getDropped := func() uint64 {
+		return atomic.LoadUint64(&metricsDroppedDueToLimitTotal) - droppedPrev
+	}
+
+	// Summary quantiles aren't counted towards the limit.
+	s.GetOrCreateGauge("bar", nil).Set(1)
+	if n := getDropped(); n != 0 {
+		t.Fatalf("unexpected number of dropped metrics; got %d; want 0", n)
+	}
+
+	// The limit is reached, so overflow metrics are returned for new metrics.
+	c1 := s.GetOrCreateCounter(`baz_total{a="1"}`)
+	c2 := s.GetOrCreateCounter(`baz_total{a="2"}`)
+	if c1 != c2 {
+		t.Fatalf("expecting shared overflow counter")
+	}
+	c1.Add(10)
+	s.GetOrCreateFloatCounter("float_total").Add(1.5)
+	s.GetOrCreateShardedCounter("sharded_total").Inc()
+	s.GetOrCreateGauge("gauge", nil).Set(2)
+	s.GetOrCreateHistogram("histogram").Update(1)
+	s.GetOrCreateHistogramWithBuckets("histogram_buckets", []float64{1, 2}).Update(1)
+	s.GetOrCreateSummary("summary2").Update(1)
+	s.GetOrCreateCounterPairs("pairs_total", "a", "b").Inc()
+	s.GetOrCreateCounterWithTTL("ttl_total", time.Hour).Inc()
+	if n := getDropped(); n != 10 {
+		t.Fatalf("unexpected number of dropped metrics; got %d; want 10", n)
+	}
+
+	// The existing metrics are returned as usual.
+	if c := s.GetOrCreateCounter("foo_total"); c.Get() != 1 {
+		t.Fatalf("unexpected value for the existing counter; got %d; want 1", c.Get())
+	}
+
+	// Err variants return an error.
+	if _, err := s.NewCounterErr("qux_total"); !errors.Is(err, ErrMetricsLimitReached) {
+		t.Fatalf("unexpected error; got %v; want %v", err, ErrMetricsLimitReached)
+	}
+	if err := s.TryRegisterMetric("qux_total", &Counter{}); !errors.Is(err, ErrMetricsLimitReached) {
+		t.Fatalf("unexpected error; got %v; want %v", err, ErrMetricsLimitReached)
+	}
+	if n := getDropped(); n != 12 {
+		t.Fatalf("unexpected number of dropped metrics; got %d; want 12", n)
+	}
+
+	// Overflow metrics aren't exposed.
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `bar 1
+foo_total 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// The dropped metrics are counted by metrics_dropped_due_to_limit_total.
+	bb.Reset()
+	WriteSets(&bb, s)
+	if result := bb.String(); !strings.Contains(result, "\nmetrics_dropped_due_to_limit_total ") {
+		t.Fatalf("missing metrics_dropped_due_to_limit_total in the output:\n%s", result)
+	}
+
+	// Unregistering a metric allows registering a new one.
+	if !s.UnregisterMetric("summary") {
+		t.Fatalf("cannot unregister summary")
+	}
+	if c := s.GetOrCreateCounter(`baz_total{a="1"}`); c == c1 {
+		t.Fatalf("unexpected overflow counter after unregistering a metric")
+	}
+
+	// Disable the limit.
+	s.SetMaxMetrics(0)
+	if _, err := s.NewCounterErr("qux_total"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectPanic(t, "negative limit", func() {
+		s.SetMaxMetrics(-1)
+	})
+}
+
+func TestTruncateResponse(t *testing.T) {
+	f := func(data string, format, maxBytes int, resultExpected string) {
+		t.Helper()
+		result := string(truncateResponse([]byte(data), format, maxBytes))
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+		if len(data) > maxBytes && len(result) > maxBytes && maxBytes >= len(resultExpected) {
+			t.Fatalf("too long result: %d bytes; want up to %d bytes", len(result), maxBytes)
+		}
+	}
+
+	// The response fits the limit
+	f("foo 1\nbar 2\n", expositionFormatPrometheus, 100, "foo 1\nbar 2\n")
+
+	// Prometheus text exposition format
+	tail := "# metrics response is truncated, since its size exceeds MaxResponseBytes=84\n"
+	f(strings.Repeat("a", 200), expositionFormatPrometheus, 84, tail)
+	f("foo 1\nbar 2\n"+strings.Repeat("a", 200), expositionFormatPrometheus, 84, "foo 1\n"+tail)
+
+	// The limit is smaller than the trailing comment
+	f("foo 1\nbar 2\n", expositionFormatPrometheus, 5, "# metrics response is truncated, since its size exceeds MaxResponseBytes=5\n")
+
+	// OpenMetrics format
+	f("foo 1\nbar 2\nbaz 3\n# EOF\n", expositionFormatOpenMetrics, 20, "foo 1\nbar 2\n# EOF\n")
+
+	// Protobuf format
+	f("\x02ab\x03cde\x01f", expositionFormatProtobuf, 8, "\x02ab\x03cde")
+	f("\x02ab\x03cde\x01f", expositionFormatProtobuf, 6, "\x02ab")
+	f("\x02ab\x03cde\x01f", expositionFormatProtobuf, 2, "")
+	f("\x02ab\x83\x83", expositionFormatProtobuf, 4, "\x02ab")
+
+	// JSON format
+	data := "[\n{\"a\":1},\n{\"b\":2},\n{\"c\":3}\n]\n"
+	f(data, expositionFormatJSON, 24, "[\n{\"a\":1},\n{\"b\":2}\n]\n")
+	f(data, expositionFormatJSON, 20, "[\n{\"a\":1}\n]\n")
+	f(data, expositionFormatJSON, 12, "[\n]\n")
+	f(data, expositionFormatJSON, 2, "[\n]\n")
+	f(data, expositionFormatJSON, len(data)-1, "[\n{\"a\":1},\n{\"b\":2}\n]\n")
+}
+
+func TestHandlerMaxResponseBytes(t *testing.T) {
+	s := NewSet()
+	for i := 0; i < 100; i++ {
+		s.NewCounter(fmt.Sprintf("handler_max_response_bytes_total{i=\"%d\"}", i))
+	}
+	RegisterSet(s)
+	defer UnregisterSet(s)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rw := httptest.NewRecorder()
+	Handler(HandlerOpts{
+		MaxResponseBytes: 1000,
+	}).ServeHTTP(rw, req)
+	body := rw.Body.String()
+	if len(body) > 1000 {
+		t.Fatalf("too big response: %d bytes; want up to 1000 bytes", len(body))
+	}
+	if !strings.HasSuffix(body, "\n# metrics response is truncated, since its size exceeds MaxResponseBytes=1000\n") {
+		t.Fatalf("missing trailing comment about the truncation in the response:\n%s", body)
+	}
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"io"
+	"strconv"
+)
+
+// getSampleBuffer returns a buffer for appending samples, which must be written to w.
+//
+// The returned buffer must be passed to putSampleBuffer after appending the samples.
+//
+// Metrics are usually marshaled into *bytesBuffer, so the samples are appended directly to it
+// without intermediate copying and memory allocations.
+func getSampleBuffer(w io.Writer) *bytesBuffer {
+	if bb, ok := w.(*bytesBuffer); ok {
+		return bb
+	}
+	return getBytesBuffer()
+}
+
+// putSampleBuffer writes samples from bb obtained via getSampleBuffer(w) to w.
+func putSampleBuffer(w io.Writer, bb *bytesBuffer) {
+	if w == io.Writer(bb) {
+		return
+	}
+	_, _ = w.Write(bb.B)
+	putBytesBuffer(bb)
+}
+
+// writeSampleUint64 writes `prefix v` sample with optional timestamp mt to w in Prometheus text exposition format.
+//
+// mt may be nil.
+func writeSampleUint64(w io.Writer, prefix string, v uint64, mt *metricTimestamp) {
+	bb := getSampleBuffer(w)
+	bb.B = append(bb.B, prefix...)
+	bb.B = append(bb.B, ' ')
+	bb.B = strconv.AppendUint(bb.B, v, 10)
+	bb.B = appendSampleEnd(bb.B, mt)
+	putSampleBuffer(w, bb)
+}
+
+// writeSampleFloat64 writes `prefix v` sample to w in Prometheus text exposition format.
+//
+// v is formatted in the same way as fmt.Sprintf("%g", v) does.
+func writeSampleFloat64(w io.Writer, prefix string, v float64) {
+	bb := getSampleBuffer(w)
+	bb.B = append(bb.B, prefix...)
+	bb.B = append(bb.B, ' ')
+	bb.B = strconv.AppendFloat(bb.B, v, 'g', -1, 64)
+	bb.B = append(bb.B, '\n')
+	putSampleBuffer(w, bb)
+}
+
+// writeSampleGaugeValue writes `prefix v` sample with optional timestamp mt to w in Prometheus text exposition format.
+//
+// v is formatted with appendFloatValue according to the float format stored at ff. mt and ff may be nil.
+func writeSampleGaugeValue(w io.Writer, prefix string, v float64, mt *metricTimestamp, ff *uint32) {
+	bb := getSampleBuffer(w)
+	bb.B = append(bb.B, prefix...)
+	bb.B = append(bb.B, ' ')
+	bb.B = appendFloatValue(bb.B, v, ff)
+	bb.B = appendSampleEnd(bb.B, mt)
+	putSampleBuffer(w, bb)
+}
+
+// writeSumAndCount writes `<name>_sum` and `<name>_count` samples for the given prefix to w.
+//
+// sum is formatted with appendFloatValue according to the float format stored at ff. ff may be nil.
+func writeSumAndCount(w io.Writer, prefix string, sum float64, count uint64, ff *uint32) {
+	bb := getSampleBuffer(w)
+	bb.B = appendSampleName(bb.B, prefix, "_sum", "")
+	bb.B = append(bb.B, ' ')
+	bb.B = appendFloatValue(bb.B, sum, ff)
+	bb.B = append(bb.B, '\n')
+	bb.B = appendSampleName(bb.B, prefix, "_count", "")
+	bb.B = append(bb.B, ' ')
+	bb.B = strconv.AppendUint(bb.B, count, 10)
+	bb.B = append(bb.B, '\n')
+	putSampleBuffer(w, bb)
+}
+
+// writeBucketSample writes `<name>_bucket{<labels>,<tag>} count` sample for the given prefix to w.
+//
+// tag must be in the form `name="value"`.
+func writeBucketSample(w io.Writer, prefix, tag string, count uint64) {
+	bb := getSampleBuffer(w)
+	bb.B = appendSampleName(bb.B, prefix, "_bucket", tag)
+	bb.B = append(bb.B, ' ')
+	bb.B = strconv.AppendUint(bb.B, count, 10)
+	bb.B = append(bb.B, '\n')
+	putSampleBuffer(w, bb)
+}
+
+// appendSampleName appends the sample name for the metric with the given prefix to dst.
+//
+// suffix is added to the metric name, while non-empty tag is added to the labels.
+// The result is the same as for the name obtained via splitMetricName(addTag(prefix, tag)) with the suffix
+// put between the name and the labels.
+func appendSampleName(dst []byte, prefix, suffix, tag string) []byte {
+	name, labels := splitMetricName(prefix)
+	dst = append(dst, name...)
+	dst = append(dst, suffix...)
+	if tag == "" {
+		return append(dst, labels...)
+	}
+	if labels == "" {
+		dst = append(dst, '{')
+	} else {
+		dst = append(dst, labels[:len(labels)-1]...)
+		dst = append(dst, ',')
+	}
+	dst = append(dst, tag...)
+	return append(dst, '}')
+}
+
+// appendGaugeValue appends v to dst in the format set via SetFloatFormat.
+//
+// Integer values are appended without scientific notation with the default format.
+func appendGaugeValue(dst []byte, v float64) []byte {
+	return appendFloatValue(dst, v, nil)
+}
+
+// appendSampleEnd appends optional timestamp mt and the trailing newline to dst.
+func appendSampleEnd(dst []byte, mt *metricTimestamp) []byte {
+	if mt != nil {
+		dst = mt.appendPrometheus(dst)
+	}
+	return append(dst, '\n')
+}
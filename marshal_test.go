@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestAppendGaugeValue(t *testing.T) {
+	f := func(v float64) {
+		t.Helper()
+		var resultExpected string
+		if float64(int64(v)) == v {
+			resultExpected = fmt.Sprintf("%d", int64(v))
+		} else {
+			resultExpected = fmt.Sprintf("%g", v)
+		}
+		result := string(appendGaugeValue(nil, v))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %v; got %q; want %q", v, result, resultExpected)
+		}
+	}
+	f(0)
+	f(math.Copysign(0, -1))
+	f(1)
+	f(-1)
+	f(0.1)
+	f(1e20)
+	f(1e21)
+	f(-1e-300)
+	f(5e-324)
+	f(math.MaxFloat64)
+	f(math.Inf(1))
+	f(math.Inf(-1))
+	f(math.NaN())
+}
+
+func TestWriteSampleFloat64(t *testing.T) {
+	f := func(v float64) {
+		t.Helper()
+		var bb bytes.Buffer
+		writeSampleFloat64(&bb, "foo", v)
+		result := bb.String()
+		resultExpected := fmt.Sprintf("foo %g\n", v)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %v; got %q; want %q", v, result, resultExpected)
+		}
+	}
+	f(0)
+	f(1.5)
+	f(1e21)
+	f(1.5e-7)
+	f(math.Inf(1))
+	f(math.NaN())
+}
+
+func TestAppendSampleName(t *testing.T) {
+	f := func(prefix, suffix, tag string) {
+		t.Helper()
+		metricName := prefix
+		if tag != "" {
+			metricName = addTag(prefix, tag)
+		}
+		name, labels := splitMetricName(metricName)
+		resultExpected := name + suffix + labels
+		result := string(appendSampleName(nil, prefix, suffix, tag))
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %q; want %q", result, resultExpected)
+		}
+	}
+	f("foo", "", "")
+	f("foo", "_sum", "")
+	f(`foo{bar="baz"}`, "_count", "")
+	f("foo", "_bucket", `le="1"`)
+	f(`foo{bar="baz"}`, "_bucket", `vmrange="1...2"`)
+	f(`foo{bar="baz",x="y"}`, "_bucket", `le="+Inf"`)
+}
+
+func TestWriteSampleToNonBytesBuffer(t *testing.T) {
+	// Samples written into arbitrary io.Writer must be the same as samples written into bytesBuffer.
+	var bb bytes.Buffer
+	var bbExpected bytesBuffer
+	for _, w := range []io.Writer{&bb, &bbExpected} {
+		writeSampleUint64(w, `foo{a="b"}`, 123, nil)
+		writeSampleGaugeValue(w, "bar", 1.25, nil, nil)
+		writeBucketSample(w, "baz", `le="1"`, 10)
+		writeSumAndCount(w, "baz", 12.5, 10, nil)
+	}
+	result := bb.String()
+	resultExpected := string(bbExpected.B)
+	if result != resultExpected {
+		t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+	}
+	if resultExpected != "foo{a=\"b\"} 123\nbar 1.25\nbaz_bucket{le=\"1\"} 10\nbaz_sum 12.5\nbaz_count 10\n" {
+		t.Fatalf("unexpected samples:\n%s", resultExpected)
+	}
+}
@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// WritePrometheusMatching writes metrics from the default set, all the added sets and metrics writers to w in Prometheus format
+// if their base names match one of the given matchers.
+//
+// The base name is the part of the metric name before `{`. For instance, `foo` is the base name for `foo{bar="baz"}`.
+// Histograms and summaries are written with all their `_bucket`, `_sum` and `_count` samples if their names match.
+//
+// Process metrics such as `go_*` and `process_*` are written only if their names are explicitly passed in matchers.
+//
+// Invalid metric names in matchers are ignored. All the metrics including process metrics are written
+// if matchers contains no valid metric names.
+//
+// See also Handler, which supports Prometheus federation-style `name[]` query args for filtering the exposed metrics.
+func WritePrometheusMatching(w io.Writer, matchers []string) {
+	writePrometheusMatching(w, true, matchers)
+}
+
+// WritePrometheusMatching writes metrics from s to w in Prometheus format if their base names match one of the given matchers.
+//
+// See WritePrometheusMatching for details.
+func (s *Set) WritePrometheusMatching(w io.Writer, matchers []string) {
+	s.writePrometheusFiltered(w, newMetricNameFilter(matchers))
+}
+
+func writePrometheusMatching(w io.Writer, exposeProcessMetrics bool, matchers []string) {
+	writePrometheusWithNameFilter(w, exposeProcessMetrics, newMetricNameFilter(matchers))
+}
+
+// WritePrometheusFiltered writes metrics from the default set, all the added sets and metrics writers to w in Prometheus format
+// if filter returns true for their base names.
+//
+// The base name is the part of the metric name before `{`. For instance, `foo` is the base name for `foo{bar="baz"}`.
+// filter is called once per metric family for registered metrics, so histograms and summaries are written
+// with all their `_bucket`, `_sum` and `_count` samples if filter returns true for their base names.
+// Gauge callbacks aren't called for the filtered out gauges.
+//
+// The output of metrics writers and process metrics such as `go_*` and `process_*` is filtered line by line,
+// so filter is called for every sample name in this output. Samples with `_bucket`, `_sum` and `_count` suffixes
+// are written if filter returns true for the name with or without the suffix.
+//
+// All the metrics are written if filter is nil. See NameFilterRegexp and NameFilterPrefixes for creating filters.
+func WritePrometheusFiltered(w io.Writer, filter func(name string) bool) {
+	writePrometheusWithNameFilter(w, true, newMetricNameFilterFunc(filter))
+}
+
+// WritePrometheusFiltered writes metrics from s to w in Prometheus format if filter returns true for their base names.
+//
+// See WritePrometheusFiltered for details.
+func (s *Set) WritePrometheusFiltered(w io.Writer, filter func(name string) bool) {
+	s.writePrometheusFiltered(w, newMetricNameFilterFunc(filter))
+}
+
+// NameFilterRegexp returns filter for WritePrometheusFiltered, which matches metric base names containing re match.
+//
+// Use `^...$` anchors in re for matching the whole base name. For example, `^(http|rpc)_` matches
+// metric families starting with `http_` or `rpc_`.
+func NameFilterRegexp(re *regexp.Regexp) func(name string) bool {
+	return re.MatchString
+}
+
+// NameFilterPrefixes returns filter for WritePrometheusFiltered, which matches metric base names starting with any of prefixes.
+func NameFilterPrefixes(prefixes ...string) func(name string) bool {
+	prefixes = append([]string{}, prefixes...)
+	return func(name string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// writePrometheusWithNameFilter writes metrics matching mf from all the registered sets to w.
+//
+// Process metrics are written if exposeProcessMetrics is true and they match mf.
+func writePrometheusWithNameFilter(w io.Writer, exposeProcessMetrics bool, mf *metricNameFilter) {
+	if mf == nil {
+		WritePrometheus(w, exposeProcessMetrics)
+		return
+	}
+	writePrometheusSets(w, getRegisteredSets(), mf)
+	if exposeProcessMetrics {
+		bb := getBytesBuffer()
+		WriteProcessMetrics(bb)
+		bbFiltered := getBytesBuffer()
+		bbFiltered.B = mf.filterText(bbFiltered.B[:0], bb.B)
+		putBytesBuffer(bb)
+		w.Write(bbFiltered.B)
+		putBytesBuffer(bbFiltered)
+	}
+}
+
+// metricNameFilter matches metric base names.
+//
+// nil filter matches all the metrics.
+type metricNameFilter struct {
+	// names contains metric names to match. It is used if fn is nil.
+	names map[string]struct{}
+
+	// fn is an optional func for matching metric names.
+	fn func(name string) bool
+}
+
+// newMetricNameFilterFunc returns filter, which matches metric names via fn.
+//
+// nil is returned if fn is nil.
+func newMetricNameFilterFunc(fn func(name string) bool) *metricNameFilter {
+	if fn == nil {
+		return nil
+	}
+	return &metricNameFilter{
+		fn: fn,
+	}
+}
+
+// newMetricNameFilter returns filter for the given matchers.
+//
+// nil is returned if matchers contains no valid metric names.
+func newMetricNameFilter(matchers []string) *metricNameFilter {
+	var names map[string]struct{}
+	for _, name := range matchers {
+		if validateIdent(name) != nil {
+			continue
+		}
+		if names == nil {
+			names = make(map[string]struct{}, len(matchers))
+		}
+		names[name] = struct{}{}
+	}
+	if names == nil {
+		return nil
+	}
+	return &metricNameFilter{
+		names: names,
+	}
+}
+
+// match returns true if the given metric family name matches mf.
+func (mf *metricNameFilter) match(metricFamily string) bool {
+	if mf == nil {
+		return true
+	}
+	if mf.fn != nil {
+		return mf.fn(metricFamily)
+	}
+	_, ok := mf.names[metricFamily]
+	return ok
+}
+
+// matchSample returns true if the sample with the given name in Prometheus text exposition format matches mf.
+//
+// Samples with `_bucket`, `_sum` and `_count` suffixes match if the name without the suffix matches mf.
+func (mf *metricNameFilter) matchSample(name string) bool {
+	if mf.match(name) {
+		return true
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(name, suffix) && mf.match(name[:len(name)-len(suffix)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterText appends lines matching mf from src in Prometheus text exposition format to dst.
+//
+// `# HELP` and `# TYPE` lines are preserved for the matching metric families. Other comments are dropped.
+func (mf *metricNameFilter) filterText(dst, src []byte) []byte {
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		if len(line) == 0 {
+			continue
+		}
+		s := string(line)
+		name := ""
+		if strings.HasPrefix(s, "# HELP ") || strings.HasPrefix(s, "# TYPE ") {
+			name = s[len("# HELP "):]
+		} else if s[0] == '#' {
+			continue
+		} else {
+			name = s
+		}
+		if n := strings.IndexAny(name, "{ \t"); n >= 0 {
+			name = name[:n]
+		}
+		if !mf.matchSample(name) {
+			continue
+		}
+		dst = append(dst, line...)
+		dst = append(dst, '\n')
+	}
+	return dst
+}
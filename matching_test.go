@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSetWritePrometheusMatching(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`foo{bar="baz"}`).Inc()
+	s.NewCounter("foo_total").Add(2)
+	s.NewGauge("gauge", func() float64 { return 3 })
+	s.NewHistogram("hist").Update(1)
+	s.NewSummary("summary").Update(5)
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "# TYPE custom counter\ncustom 4\ncustom_other 5\n")
+	})
+
+	f := func(matchers []string, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheusMatching(&bb, matchers)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result for matchers %q;\ngot\n%s\nwant\n%s", matchers, result, resultExpected)
+		}
+	}
+
+	f([]string{"foo"}, `foo{bar="baz"} 1`+"\n")
+	f([]string{"foo", "gauge", "missing"}, `foo{bar="baz"} 1
+gauge 3
+`)
+	f([]string{"hist"}, `hist_bucket{vmrange="8.799e-01...1.000e+00"} 1
+hist_sum 1
+hist_count 1
+`)
+	f([]string{"summary"}, `summary_sum 5
+summary_count 1
+summary{quantile="0.5"} 5
+summary{quantile="0.9"} 5
+summary{quantile="0.97"} 5
+summary{quantile="0.99"} 5
+summary{quantile="1"} 5
+`)
+	f([]string{"custom"}, "# TYPE custom counter\ncustom 4\n")
+	f([]string{"missing"}, "")
+
+	// Invalid and empty matchers must result in writing all the metrics.
+	var bbAll bytes.Buffer
+	s.WritePrometheus(&bbAll)
+	f(nil, bbAll.String())
+	f([]string{""}, bbAll.String())
+	f([]string{`foo{bar="baz"}`, "1abc"}, bbAll.String())
+}
+
+func TestSetWritePrometheusFiltered(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`http_requests_total{path="/"}`).Inc()
+	s.NewCounter(`http_requests_total{path="/foo"}`).Add(2)
+	s.NewHistogram("http_duration_seconds").Update(1)
+	s.NewCounter("internal_errors_total").Add(3)
+	gaugeCalls := 0
+	s.NewGauge("internal_queue_size", func() float64 {
+		gaugeCalls++
+		return 4
+	})
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "http_custom 5\ninternal_custom 6\n")
+	})
+
+	f := func(filter func(name string) bool, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheusFiltered(&bb, filter)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	resultExpected := `http_duration_seconds_bucket{vmrange="8.799e-01...1.000e+00"} 1
+http_duration_seconds_sum 1
+http_duration_seconds_count 1
+http_requests_total{path="/"} 1
+http_requests_total{path="/foo"} 2
+http_custom 5
+`
+	f(NameFilterPrefixes("http_"), resultExpected)
+	f(NameFilterRegexp(regexp.MustCompile("^http_")), resultExpected)
+	if gaugeCalls != 0 {
+		t.Fatalf("unexpected calls for the filtered out gauge callback; got %d; want 0", gaugeCalls)
+	}
+
+	// The filter is called once per metric family for registered metrics.
+	var names []string
+	f(func(name string) bool {
+		names = append(names, name)
+		return name == "internal_queue_size"
+	}, "internal_queue_size 4\n")
+	namesExpected := []string{"http_duration_seconds", "http_requests_total", "internal_errors_total", "internal_queue_size", "http_custom", "internal_custom"}
+	if !reflect.DeepEqual(names, namesExpected) {
+		t.Fatalf("unexpected filter calls;\ngot\n%q\nwant\n%q", names, namesExpected)
+	}
+
+	// nil filter writes all the metrics.
+	var bbAll bytes.Buffer
+	s.WritePrometheus(&bbAll)
+	f(nil, bbAll.String())
+}
+
+func TestMetricNameFilterFilterText(t *testing.T) {
+	f := func(matchers []string, src, resultExpected string) {
+		t.Helper()
+		mf := newMetricNameFilter(matchers)
+		result := mf.filterText(nil, []byte(src))
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	src := `# HELP go_goroutines Number of goroutines
+# TYPE go_goroutines gauge
+go_goroutines 10
+# some comment
+go_gc_duration_seconds{quantile="0"} 0.001
+go_gc_duration_seconds_sum 0.1
+go_gc_duration_seconds_count 3
+process_cpu_seconds_total 1.5
+`
+	f([]string{"go_goroutines"}, src, `# HELP go_goroutines Number of goroutines
+# TYPE go_goroutines gauge
+go_goroutines 10
+`)
+	f([]string{"go_gc_duration_seconds", "process_cpu_seconds_total"}, src, `go_gc_duration_seconds{quantile="0"} 0.001
+go_gc_duration_seconds_sum 0.1
+go_gc_duration_seconds_count 3
+process_cpu_seconds_total 1.5
+`)
+	f([]string{"process_cpu_seconds"}, src, "")
+	f([]string{"foo"}, "foo 1", "foo 1\n")
+}
+
+func TestHandlerMatching(t *testing.T) {
+	name := "handler_matching_test_metric"
+	NewCounter(name).Add(5)
+	defer UnregisterMetric(name)
+
+	f := func(query string, opts HandlerOpts, mustContain, mustNotContain []string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/metrics?"+query, nil)
+		req.Header.Set("Accept", "application/openmetrics-text")
+		rw := httptest.NewRecorder()
+		Handler(opts).ServeHTTP(rw, req)
+		result := rw.Body.String()
+		for _, s := range mustContain {
+			if !strings.Contains(result, s) {
+				t.Fatalf("missing %q in the response for query %q:\n%s", s, query, result)
+			}
+		}
+		for _, s := range mustNotContain {
+			if strings.Contains(result, s) {
+				t.Fatalf("unexpected %q in the response for query %q:\n%s", s, query, result)
+			}
+		}
+	}
+
+	opts := HandlerOpts{
+		ExposeProcessMetrics: true,
+	}
+	f("name[]="+name, opts, []string{name + " 5\n"}, []string{"go_goroutines", "# EOF"})
+	f("name[]="+name+"&name[]=go_goroutines", opts, []string{name + " 5\n", "go_goroutines "}, []string{"go_memstats_alloc_bytes"})
+	f("name[]="+name+"&name[]=go_goroutines", HandlerOpts{}, []string{name + " 5\n"}, []string{"go_goroutines"})
+
+	// Missing or invalid matchers result in the full output in the requested format.
+	f("", opts, []string{name + "_total 5\n", "go_goroutines ", "# EOF"}, nil)
+	f("name[]=", opts, []string{name + "_total 5\n", "go_goroutines ", "# EOF"}, nil)
+}
@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"io"
+	"math"
+)
+
+// NewMaxGauge registers and returns new gauge with the given name in the default set,
+// which tracks the maximum value passed to MaxGauge.Update.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// The returned gauge is safe to use from concurrent goroutines.
+//
+// See also NewMaxGaugeExt and NewMinGauge.
+func NewMaxGauge(name string) *MaxGauge {
+	return defaultSet.NewMaxGauge(name)
+}
+
+// NewMaxGaugeExt registers and returns new MaxGauge with the given name in the default set.
+//
+// If resetOnWrite is true, then the gauge is reset after every write of its value to the output,
+// so every scrape interval reports its own maximum. Concurrent scrapes race for the observed values in this case -
+// every value is reported to only one of the scrapers. So such gauges must be scraped by a single scraper.
+//
+// See NewMaxGauge for details.
+func NewMaxGaugeExt(name string, resetOnWrite bool) *MaxGauge {
+	return defaultSet.NewMaxGaugeExt(name, resetOnWrite)
+}
+
+// NewMaxGauge registers and returns new MaxGauge with the given name in s.
+//
+// See NewMaxGauge for details.
+func (s *Set) NewMaxGauge(name string) *MaxGauge {
+	return s.NewMaxGaugeExt(name, false)
+}
+
+// NewMaxGaugeExt registers and returns new MaxGauge with the given name in s.
+//
+// See NewMaxGaugeExt for details.
+func (s *Set) NewMaxGaugeExt(name string, resetOnWrite bool) *MaxGauge {
+	mg := &MaxGauge{}
+	mg.eg.init(false, resetOnWrite)
+	return s.registerMetric(name, mg, "").(*MaxGauge)
+}
+
+// MaxGauge is a gauge, which tracks the maximum observed value.
+//
+// 0 is exposed if there were no updates since the gauge creation or since the last reset.
+type MaxGauge struct {
+	eg extremumGauge
+}
+
+// Update updates mg with v if v exceeds the current maximum.
+//
+// NaN values are ignored.
+func (mg *MaxGauge) Update(v float64) {
+	mg.eg.update(v)
+}
+
+// Get returns the maximum value observed by mg.
+//
+// 0 is returned if there were no updates.
+func (mg *MaxGauge) Get() float64 {
+	return mg.eg.get()
+}
+
+// Reset resets mg to the initial state without observed values.
+func (mg *MaxGauge) Reset() {
+	mg.eg.reset()
+}
+
+// MarshalTo writes mg with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (mg *MaxGauge) MarshalTo(prefix string, w io.Writer) {
+	mg.marshalTo(prefix, w)
+}
+
+func (mg *MaxGauge) marshalTo(prefix string, w io.Writer) {
+	mg.eg.marshalTo(prefix, w)
+}
+
+func (mg *MaxGauge) metricType() string {
+	return "gauge"
+}
+
+// NewMinGauge registers and returns new gauge with the given name in the default set,
+// which tracks the minimum value passed to MinGauge.Update.
+//
+// See NewMaxGauge for details.
+func NewMinGauge(name string) *MinGauge {
+	return defaultSet.NewMinGauge(name)
+}
+
+// NewMinGaugeExt registers and returns new MinGauge with the given name in the default set.
+//
+// See NewMaxGaugeExt for details on resetOnWrite.
+func NewMinGaugeExt(name string, resetOnWrite bool) *MinGauge {
+	return defaultSet.NewMinGaugeExt(name, resetOnWrite)
+}
+
+// NewMinGauge registers and returns new MinGauge with the given name in s.
+//
+// See NewMinGauge for details.
+func (s *Set) NewMinGauge(name string) *MinGauge {
+	return s.NewMinGaugeExt(name, false)
+}
+
+// NewMinGaugeExt registers and returns new MinGauge with the given name in s.
+//
+// See NewMinGaugeExt for details.
+func (s *Set) NewMinGaugeExt(name string, resetOnWrite bool) *MinGauge {
+	mg := &MinGauge{}
+	mg.eg.init(true, resetOnWrite)
+	return s.registerMetric(name, mg, "").(*MinGauge)
+}
+
+// MinGauge is a gauge, which tracks the minimum observed value.
+//
+// 0 is exposed if there were no updates since the gauge creation or since the last reset.
+type MinGauge struct {
+	eg extremumGauge
+}
+
+// Update updates mg with v if v is smaller than the current minimum.
+//
+// NaN values are ignored.
+func (mg *MinGauge) Update(v float64) {
+	mg.eg.update(v)
+}
+
+// Get returns the minimum value observed by mg.
+//
+// 0 is returned if there were no updates.
+func (mg *MinGauge) Get() float64 {
+	return mg.eg.get()
+}
+
+// Reset resets mg to the initial state without observed values.
+func (mg *MinGauge) Reset() {
+	mg.eg.reset()
+}
+
+// MarshalTo writes mg with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (mg *MinGauge) MarshalTo(prefix string, w io.Writer) {
+	mg.marshalTo(prefix, w)
+}
+
+func (mg *MinGauge) marshalTo(prefix string, w io.Writer) {
+	mg.eg.marshalTo(prefix, w)
+}
+
+func (mg *MinGauge) metricType() string {
+	return "gauge"
+}
+
+// extremumGauge tracks the maximum or the minimum observed value.
+type extremumGauge struct {
+	// valueBits contains uint64 representation of float64 value.
+	//
+	// It is set to emptyBits if there were no updates.
+	valueBits atomicUint64
+
+	// emptyBits contains uint64 representation of -Inf for maximum and +Inf for minimum,
+	// so any value passed to update replaces it.
+	emptyBits uint64
+
+	isMin        bool
+	resetOnWrite bool
+}
+
+func (eg *extremumGauge) init(isMin, resetOnWrite bool) {
+	sign := 1
+	if !isMin {
+		sign = -1
+	}
+	eg.emptyBits = math.Float64bits(math.Inf(sign))
+	eg.valueBits.Store(eg.emptyBits)
+	eg.isMin = isMin
+	eg.resetOnWrite = resetOnWrite
+}
+
+func (eg *extremumGauge) update(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	bitsNew := math.Float64bits(v)
+	for {
+		bits := eg.valueBits.Load()
+		current := math.Float64frombits(bits)
+		if (eg.isMin && v >= current) || (!eg.isMin && v <= current) {
+			return
+		}
+		if eg.valueBits.CompareAndSwap(bits, bitsNew) {
+			return
+		}
+	}
+}
+
+func (eg *extremumGauge) get() float64 {
+	return eg.valueFromBits(eg.valueBits.Load())
+}
+
+func (eg *extremumGauge) reset() {
+	eg.valueBits.Store(eg.emptyBits)
+}
+
+func (eg *extremumGauge) valueFromBits(bits uint64) float64 {
+	if bits == eg.emptyBits {
+		return 0
+	}
+	return math.Float64frombits(bits)
+}
+
+func (eg *extremumGauge) marshalTo(prefix string, w io.Writer) {
+	var bits uint64
+	if eg.resetOnWrite {
+		bits = eg.valueBits.Swap(eg.emptyBits)
+	} else {
+		bits = eg.valueBits.Load()
+	}
+	writeSampleFloat64(w, prefix, eg.valueFromBits(bits))
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestMaxGauge(t *testing.T) {
+	s := NewSet()
+	mg := s.NewMaxGauge("max_body_size")
+	if v := mg.Get(); v != 0 {
+		t.Fatalf("unexpected initial value; got %v; want 0", v)
+	}
+	testMarshalTo(t, mg, "prefix", "prefix 0\n")
+
+	mg.Update(-10)
+	if v := mg.Get(); v != -10 {
+		t.Fatalf("unexpected value; got %v; want -10", v)
+	}
+	mg.Update(5)
+	mg.Update(3)
+	mg.Update(math.NaN())
+	if v := mg.Get(); v != 5 {
+		t.Fatalf("unexpected value; got %v; want 5", v)
+	}
+	testMarshalTo(t, mg, "prefix", "prefix 5\n")
+
+	// The value isn't reset on write by default.
+	testMarshalTo(t, mg, "prefix", "prefix 5\n")
+	if v, ok := s.GetMetricValue("max_body_size"); !ok || v != 5 {
+		t.Fatalf("unexpected GetMetricValue result; got %v, %v; want 5, true", v, ok)
+	}
+
+	mg.Reset()
+	if v := mg.Get(); v != 0 {
+		t.Fatalf("unexpected value after reset; got %v; want 0", v)
+	}
+	mg.Update(1)
+	s.ResetAllMetrics()
+	if v := mg.Get(); v != 0 {
+		t.Fatalf("unexpected value after ResetAllMetrics; got %v; want 0", v)
+	}
+}
+
+func TestMinGauge(t *testing.T) {
+	s := NewSet()
+	mg := s.NewMinGauge("min_latency_seconds")
+	if v := mg.Get(); v != 0 {
+		t.Fatalf("unexpected initial value; got %v; want 0", v)
+	}
+	mg.Update(10)
+	mg.Update(2.5)
+	mg.Update(7)
+	mg.Update(math.NaN())
+	if v := mg.Get(); v != 2.5 {
+		t.Fatalf("unexpected value; got %v; want 2.5", v)
+	}
+	testMarshalTo(t, mg, "prefix", "prefix 2.5\n")
+
+	mg.Reset()
+	mg.Update(100)
+	if v := mg.Get(); v != 100 {
+		t.Fatalf("unexpected value after reset; got %v; want 100", v)
+	}
+}
+
+func TestMaxGaugeResetOnWrite(t *testing.T) {
+	s := NewSet()
+	maxG := s.NewMaxGaugeExt("max_value", true)
+	minG := s.NewMinGaugeExt("min_value", true)
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	maxG.Update(10)
+	maxG.Update(20)
+	minG.Update(-3)
+	minG.Update(-1)
+	f("max_value 20\nmin_value -3\n")
+
+	// Every write reports values observed since the previous write.
+	f("max_value 0\nmin_value 0\n")
+	maxG.Update(5)
+	minG.Update(7)
+	f("max_value 5\nmin_value 7\n")
+}
+
+func TestMaxGaugeConcurrent(t *testing.T) {
+	const concurrency = 5
+	const iterations = 1000
+	s := NewSet()
+	maxG := s.NewMaxGauge("max_value")
+	minG := s.NewMinGauge("min_value")
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				v := float64(n*iterations + j)
+				maxG.Update(v)
+				minG.Update(v)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if v := maxG.Get(); v != concurrency*iterations-1 {
+		t.Fatalf("unexpected max value; got %v; want %v", v, concurrency*iterations-1)
+	}
+	if v := minG.Get(); v != 0 {
+		t.Fatalf("unexpected min value; got %v; want 0", v)
+	}
+}
+
+func TestMaxGaugeDuplicateRegistration(t *testing.T) {
+	SetAllowDuplicateRegistration(true)
+	defer SetAllowDuplicateRegistration(false)
+
+	s := NewSet()
+	mg := s.NewMaxGaugeExt("foo", true)
+	if mg2 := s.NewMaxGaugeExt("foo", true); mg2 != mg {
+		t.Fatalf("expecting the same gauge for duplicate registration")
+	}
+	expectPanic(t, "resetOnWrite mismatch", func() {
+		s.NewMaxGauge("foo")
+	})
+	expectPanic(t, "type mismatch", func() {
+		s.NewMinGauge("foo")
+	})
+}
@@ -13,12 +13,14 @@
 package metrics
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -26,6 +28,33 @@ type namedMetric struct {
 	name   string
 	metric metric
 	isAux  bool
+
+	// help is an optional description for the metric family exposed in `# HELP` line.
+	help string
+
+	// unit is an optional unit for the metric family exposed in `# UNIT` line in OpenMetrics format.
+	unit string
+
+	// familyHelp contains the help set via SetMetricHelp for the metric family. It takes precedence over help.
+	familyHelp *metricHelp
+
+	// ttl is an optional duration since lastAccessTime after which the metric is unregistered.
+	//
+	// ttl is protected by Set.mu. See GetOrCreateCounterWithTTL.
+	ttl time.Duration
+
+	// lastAccessTime is the last access time in unix nanoseconds. It is updated atomically under Set.mu read lock,
+	// so GetOrCreate*WithTTL calls for already registered metrics don't contend on the exclusive lock.
+	lastAccessTime atomicInt64
+
+	// pairsHash is the hash of the base name and label pairs for metrics registered via GetOrCreate*Pairs functions.
+	// It is set only if hasPairsHash is true. See Set.pairsIdx.
+	pairsHash    uint64
+	hasPairsHash bool
+
+	// aliases contains names without common labels or in non-canonical form, which refer to the metric in Set.m.
+	// See NewSetWithLabels and SetAllowUTF8Names.
+	aliases []string
 }
 
 type metric interface {
@@ -33,6 +62,20 @@ type metric interface {
 	metricType() string
 }
 
+// Metric is a metric registered in a Set.
+//
+// Use type switch for obtaining the particular metric type such as *Counter, *FloatCounter, *Gauge, *Histogram or *Summary.
+//
+// Custom metric types implementing Metric may be registered via RegisterMetric.
+type Metric interface {
+	// MarshalTo must write the metric with the given prefix to w in Prometheus text exposition format.
+	//
+	// prefix contains the full metric name with labels, e.g. foo{bar="baz"}.
+	// Multiple lines may be written if the metric consists of multiple samples,
+	// e.g. foo_bucket{bar="baz",le="1"} and foo_count{bar="baz"}. Every line must end with "\n".
+	MarshalTo(prefix string, w io.Writer)
+}
+
 var defaultSet = NewSet()
 
 func init() {
@@ -65,12 +108,17 @@ func UnregisterSet(s *Set) {
 // RegisterMetricsWriter registers writeMetrics callback for including metrics in the output generated by WritePrometheus.
 //
 // The writeMetrics callback must write metrics to w in Prometheus text exposition format without timestamps and trailing comments.
-// The last line generated by writeMetrics must end with \n.
 // See https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md#text-based-format
 //
-// It is OK to register multiple writeMetrics callbacks - all of them will be called sequentially for gererating the output at WritePrometheus.
-func RegisterMetricsWriter(writeMetrics func(w io.Writer)) {
-	defaultSet.RegisterMetricsWriter(writeMetrics)
+// It is OK to register multiple writeMetrics callbacks - all of them will be called sequentially in the registration order
+// for generating the output at WritePrometheus.
+//
+// Panics in writeMetrics are recovered and logged, so they do not break the output for other metrics.
+// The trailing newline is added to the output of writeMetrics if it is missing.
+//
+// Call Close on the returned MetricsWriter for unregistering writeMetrics.
+func RegisterMetricsWriter(writeMetrics func(w io.Writer)) *MetricsWriter {
+	return defaultSet.RegisterMetricsWriter(writeMetrics)
 }
 
 // WritePrometheus writes all the metrics in Prometheus format from the default set, all the added sets and metrics writers to w.
@@ -81,12 +129,240 @@ func RegisterMetricsWriter(writeMetrics func(w io.Writer)) {
 // If exposeProcessMetrics is true, then various `go_*` and `process_*` metrics
 // are exposed for the current process.
 //
+// Metrics from all the sets are sorted together if SetSortMetricsOnWrite(true) is called.
+// Series with the same names in multiple sets are written only once - see WriteSets for details.
+//
 // The WritePrometheus func is usually called inside "/metrics" handler:
 //
 //	http.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
 //	    metrics.WritePrometheus(w, true)
 //	})
+//
+// See also WritePrometheusWithOpts for fine-grained control over the exposed process metrics.
+//
+// See also WritePrometheusErr.
 func WritePrometheus(w io.Writer, exposeProcessMetrics bool) {
+	opts := getLegacyWritePrometheusOpts(exposeProcessMetrics)
+	writePrometheusWithOpts(w, &opts)
+}
+
+// WritePrometheusErr writes metrics in the same way as WritePrometheus does and returns the first error returned by w.
+//
+// Writing stops on the first error, so the remaining metrics aren't marshaled. This saves CPU time
+// when the client disconnects in the middle of the response.
+func WritePrometheusErr(w io.Writer, exposeProcessMetrics bool) error {
+	cw := &countingWriter{
+		w: w,
+	}
+	WritePrometheus(cw, exposeProcessMetrics)
+	return cw.err
+}
+
+// WritePrometheusOpts contains options for WritePrometheusWithOpts.
+//
+// Every group of metrics for the current process is exposed independently of other groups.
+type WritePrometheusOpts struct {
+	// ExposeProcessMetrics enables `process_*` metrics such as CPU and memory usage for the current process
+	// and `metrics_push_*` metrics for pushing metrics via InitPush* functions.
+	ExposeProcessMetrics bool
+
+	// ExposeGoMetrics enables `go_*` metrics for Go runtime such as memory allocation and garbage collection stats.
+	ExposeGoMetrics bool
+
+	// ExposeFDMetrics enables `process_open_fds` and `process_max_fds` metrics. See WriteFDMetrics.
+	ExposeFDMetrics bool
+
+	// ExposeBuildInfo enables `go_info` and `go_info_ext` metrics with the Go version and the platform details
+	// plus `process_pid` metric with the id of the current process.
+	//
+	// Disable it when merging the output with the output of other sets or apps, which already expose these metrics.
+	ExposeBuildInfo bool
+
+	// HostnameLabel is an optional label name for the hostname of the current host.
+	//
+	// If set, the label with the hostname value is added to `go_info` and `process_pid` metrics. The hostname is obtained once
+	// via os.Hostname.
+	HostnameLabel string
+
+	// ExtraLabels are added to every exposed sample. See WritePrometheusWithLabels for details.
+	ExtraLabels map[string]string
+}
+
+// WritePrometheusWithOpts writes all the metrics in Prometheus format from the default set, all the added sets and metrics writers to w
+// plus the metrics for the current process enabled in opts.
+//
+// WritePrometheus(w, exposeProcessMetrics) is equivalent to WritePrometheusWithOpts with ExposeProcessMetrics, ExposeGoMetrics
+// and ExposeBuildInfo set to exposeProcessMetrics.
+func WritePrometheusWithOpts(w io.Writer, opts WritePrometheusOpts) {
+	if opts.HostnameLabel != "" {
+		if err := validateIdent(opts.HostnameLabel); err != nil {
+			panic(fmt.Errorf("BUG: invalid HostnameLabel %q: %w", opts.HostnameLabel, err))
+		}
+	}
+	labels := getSortedExtraLabels(opts.ExtraLabels)
+	if len(labels) == 0 {
+		writePrometheusWithOpts(w, &opts)
+		return
+	}
+	bb := getBytesBuffer()
+	writePrometheusWithOpts(bb, &opts)
+	bbLabels := getBytesBuffer()
+	bbLabels.B = addMissingLabels(bbLabels.B[:0], bb.B, labels)
+	putBytesBuffer(bb)
+	w.Write(bbLabels.B)
+	putBytesBuffer(bbLabels)
+}
+
+// getLegacyWritePrometheusOpts returns opts, which result in the same output as WritePrometheus(w, exposeProcessMetrics).
+func getLegacyWritePrometheusOpts(exposeProcessMetrics bool) WritePrometheusOpts {
+	return WritePrometheusOpts{
+		ExposeProcessMetrics: exposeProcessMetrics,
+		ExposeGoMetrics:      exposeProcessMetrics,
+		ExposeBuildInfo:      exposeProcessMetrics,
+	}
+}
+
+// writePrometheusWithOpts writes metrics from all the registered sets and the process metrics enabled in opts to w.
+//
+// opts.ExtraLabels are ignored.
+func writePrometheusWithOpts(w io.Writer, opts *WritePrometheusOpts) {
+	writePrometheusSets(w, getRegisteredSets(), nil)
+	if getWriteError(w) != nil {
+		return
+	}
+	writeOptionalProcessMetrics(w, opts)
+}
+
+// writeOptionalProcessMetrics writes the metrics for the current process enabled in opts to w.
+func writeOptionalProcessMetrics(w io.Writer, opts *WritePrometheusOpts) {
+	// The order of metric groups matches the order of metrics in WriteProcessMetrics output.
+	if opts.ExposeGoMetrics {
+		writeGoMetrics(w)
+	}
+	if opts.ExposeBuildInfo {
+		writeGoInfoMetrics(w, opts.HostnameLabel)
+	}
+	if opts.ExposeProcessMetrics {
+		writeProcessMetrics(w)
+		writePushMetrics(w)
+	}
+	if opts.ExposeFDMetrics {
+		writeFDMetrics(w)
+	}
+}
+
+// WritePrometheusWithLabels writes all the metrics in Prometheus format from the default set,
+// all the added sets and metrics writers to w with the given extraLabels added to every sample.
+//
+// extraLabels are added to metric names with and without labels. If a metric already has a label
+// with the same name as in extraLabels, then the metric's own label value is preserved.
+// Label values are escaped according to Prometheus text exposition format.
+//
+// If exposeProcessMetrics is true, then various `go_*` and `process_*` metrics
+// are exposed for the current process. extraLabels are added to these metrics too.
+//
+// See also WritePrometheus.
+func WritePrometheusWithLabels(w io.Writer, exposeProcessMetrics bool, extraLabels map[string]string) {
+	opts := getLegacyWritePrometheusOpts(exposeProcessMetrics)
+	opts.ExtraLabels = extraLabels
+	WritePrometheusWithOpts(w, opts)
+}
+
+func getSortedExtraLabels(extraLabels map[string]string) []label {
+	labels := make([]label, 0, len(extraLabels))
+	for name, value := range extraLabels {
+		if err := validateIdent(name); err != nil {
+			panic(fmt.Errorf("BUG: invalid extra label name %q: %s", name, err))
+		}
+		labels = append(labels, label{
+			name:  name,
+			value: value,
+		})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	return labels
+}
+
+// addMissingLabels appends samples from src in Prometheus text exposition format to dst
+// after adding labels missing in every sample.
+//
+// Comments and lines, which cannot be parsed, are copied to dst as is.
+func addMissingLabels(dst, src []byte, labels []label) []byte {
+	var existingLabels []label
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		if len(line) == 0 || line[0] == '#' {
+			dst = append(dst, line...)
+			dst = append(dst, '\n')
+			continue
+		}
+		s := string(line)
+		n = strings.IndexAny(s, "{ \t")
+		if n <= 0 {
+			dst = append(dst, line...)
+			dst = append(dst, '\n')
+			continue
+		}
+		name := s[:n]
+		tail := s[n:]
+		labelsStr := ""
+		existingLabels = existingLabels[:0]
+		if tail[0] == '{' {
+			var err error
+			existingLabels, tail, err = parseLabels(existingLabels, tail[1:])
+			if err != nil {
+				dst = append(dst, line...)
+				dst = append(dst, '\n')
+				continue
+			}
+			// Preserve the original labels as is, without the closing curly brace.
+			labelsStr = strings.TrimRight(s[n+1:len(s)-len(tail)-1], " ,")
+		}
+		dst = append(dst, name...)
+		dst = append(dst, '{')
+		dst = append(dst, labelsStr...)
+		needComma := labelsStr != ""
+		for _, l := range labels {
+			if hasLabel(existingLabels, l.name) {
+				continue
+			}
+			if needComma {
+				dst = append(dst, ',')
+			}
+			needComma = true
+			dst = append(dst, l.name...)
+			dst = append(dst, `="`...)
+			dst = appendEscapedLabelValue(dst, l.value)
+			dst = append(dst, '"')
+		}
+		dst = append(dst, '}')
+		dst = append(dst, tail...)
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+func hasLabel(labels []label, name string) bool {
+	for _, l := range labels {
+		if l.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getRegisteredSets returns sets registered via RegisterSet in stable order.
+func getRegisteredSets() []*Set {
 	registeredSetsLock.Lock()
 	sets := make([]*Set, 0, len(registeredSets))
 	for s := range registeredSets {
@@ -97,12 +373,7 @@ func WritePrometheus(w io.Writer, exposeProcessMetrics bool) {
 	sort.Slice(sets, func(i, j int) bool {
 		return uintptr(unsafe.Pointer(sets[i])) < uintptr(unsafe.Pointer(sets[j]))
 	})
-	for _, s := range sets {
-		s.WritePrometheus(w)
-	}
-	if exposeProcessMetrics {
-		WriteProcessMetrics(w)
-	}
+	return sets
 }
 
 // WriteProcessMetrics writes additional process metrics in Prometheus format to w.
@@ -130,6 +401,14 @@ func WritePrometheus(w io.Writer, exposeProcessMetrics bool) {
 //
 //   - process_resident_memory_shared_bytes - RSS for memory shared between multiple processes
 //
+//   - process_resident_anon_memory_bytes - RSS for anonymous memory pages according to /proc/self/smaps_rollup
+//
+//   - process_resident_file_memory_bytes - RSS for memory pages, which aren't anonymous, according to /proc/self/smaps_rollup
+//
+//   - process_resident_shared_memory_bytes - RSS for memory pages shared with other processes according to /proc/self/smaps_rollup
+//
+//   - process_swap_bytes - swapped out anonymous memory according to /proc/self/smaps_rollup
+//
 //   - process_virtual_memory_bytes - virtual memory usage
 //
 //   - process_virtual_memory_peak_bytes - the maximum virtual memory usage
@@ -138,6 +417,8 @@ func WritePrometheus(w io.Writer, exposeProcessMetrics bool) {
 //
 //   - process_start_time_seconds - process start time as unix timestamp
 //
+//   - process_pid - the id of the current process
+//
 //   - process_io_read_bytes_total - the number of bytes read via syscalls
 //
 //   - process_io_written_bytes_total - the number of bytes written via syscalls
@@ -150,6 +431,16 @@ func WritePrometheus(w io.Writer, exposeProcessMetrics bool) {
 //
 //   - process_io_storage_written_bytes_total - the number of bytes actually written to disk
 //
+//   - process_cgroup_memory_limit_bytes - the memory limit for the cgroup the process runs in
+//
+//   - process_cgroup_memory_usage_bytes - the memory usage for the cgroup the process runs in
+//
+//   - process_cgroup_cpu_quota - the CPU time in microseconds the cgroup may use per process_cgroup_cpu_period
+//
+//   - process_cgroup_cpu_period - the CPU accounting period in microseconds for the cgroup
+//
+//   - process_cgroup_cpu_limit_cores - the number of CPU cores available to the cgroup
+//
 //   - go_sched_latencies_seconds - time spent by goroutines in ready state before they start execution
 //
 //   - go_mutex_wait_seconds_total - summary time spent by all the goroutines while waiting for locked mutex
@@ -227,6 +518,7 @@ func WritePrometheus(w io.Writer, exposeProcessMetrics bool) {
 // See also WriteFDMetrics.
 func WriteProcessMetrics(w io.Writer) {
 	writeGoMetrics(w)
+	writeGoInfoMetrics(w, "")
 	writeProcessMetrics(w)
 	writePushMetrics(w)
 }
@@ -255,6 +547,43 @@ func ListMetricNames() []string {
 	return defaultSet.ListMetricNames()
 }
 
+// GetMetric returns the metric with the given name from the default set.
+//
+// false is returned if the metric with the given name isn't registered.
+func GetMetric(name string) (Metric, bool) {
+	return defaultSet.GetMetric(name)
+}
+
+// VisitMetrics calls fn for every metric registered in the default set.
+//
+// fn receives the full metric name including labels. Metrics are visited in the order they are exposed
+// by WritePrometheus. The iteration stops when fn returns false. Auxiliary metrics such as summary quantiles aren't visited.
+//
+// The iteration is performed over a snapshot of the registered metrics, so fn may register and unregister metrics.
+// Such changes aren't visible during the current iteration.
+func VisitMetrics(fn func(name string, m Metric) bool) {
+	defaultSet.VisitMetrics(fn)
+}
+
+// GetMetricValue returns the current value for the metric with the given name from the default set.
+//
+// The value is returned only for Counter, ShardedCounter, FloatCounter, Gauge, MaxGauge, MinGauge and RateGauge. false is returned for other metric types
+// such as Histogram and Summary, and for missing metrics.
+func GetMetricValue(name string) (float64, bool) {
+	return defaultSet.GetMetricValue(name)
+}
+
+// SetMetricTimestamp attaches the given timestamp ts to the metric with the given name from the default set.
+//
+// The timestamp is exposed after the metric value, so the scraper uses it instead of the scrape time.
+// Zero ts clears the timestamp. Only Counter and Gauge support timestamps - an error is returned for other metric types
+// and for missing metrics.
+//
+// See also Counter.SetWithTimestamp and Gauge.SetWithTimestamp.
+func SetMetricTimestamp(name string, ts time.Time) error {
+	return defaultSet.SetMetricTimestamp(name, ts)
+}
+
 // GetDefaultSet returns the default metrics set.
 func GetDefaultSet() *Set {
 	return defaultSet
@@ -310,14 +639,14 @@ func writeMetricUint64(w io.Writer, metricName, metricType string, value uint64)
 
 func writeMetricFloat64(w io.Writer, metricName, metricType string, value float64) {
 	WriteMetadataIfNeeded(w, metricName, metricType)
-	fmt.Fprintf(w, "%s %g\n", metricName, value)
+	writeSampleGaugeValue(w, metricName, value, nil, nil)
 }
 
 // WriteMetadataIfNeeded writes HELP and TYPE metadata for the given metricName and metricType if this is globally enabled via ExposeMetadata().
 //
 // If the metadata exposition isn't enabled, then this function is no-op.
 func WriteMetadataIfNeeded(w io.Writer, metricName, metricType string) {
-	if !isMetadataEnabled() {
+	if _, ok := w.(*openMetricsProcessWriter); !ok && !isMetadataEnabled() {
 		return
 	}
 	metricFamily := getMetricFamily(metricName)
@@ -325,6 +654,64 @@ func WriteMetadataIfNeeded(w io.Writer, metricName, metricType string) {
 	fmt.Fprintf(w, "# TYPE %s %s\n", metricFamily, metricType)
 }
 
+// writeMetadataIfNeeded writes HELP and TYPE metadata for the given metricFamily.
+//
+// The metadata is written if it is globally enabled via ExposeMetadata() or if help isn't empty.
+func writeMetadataIfNeeded(w io.Writer, metricFamily, metricType, help string) {
+	if help == "" && !isMetadataEnabled() {
+		return
+	}
+	metricFamily = formatMetricFamily(metricFamily)
+	bb := getSampleBuffer(w)
+	bb.B = append(bb.B, "# HELP "...)
+	bb.B = append(bb.B, metricFamily...)
+	if help != "" {
+		bb.B = append(bb.B, ' ')
+		bb.B = append(bb.B, escapeHelp(help)...)
+	}
+	bb.B = append(bb.B, "\n# TYPE "...)
+	bb.B = append(bb.B, metricFamily...)
+	bb.B = append(bb.B, ' ')
+	bb.B = append(bb.B, metricType...)
+	bb.B = append(bb.B, '\n')
+	putSampleBuffer(w, bb)
+}
+
+// getMetricFamilyMetadata returns type and help for the metricFamily starting at the sorted sa.
+//
+// The type is obtained from the first non-auxiliary metric, since auxiliary metrics such as
+// summary_metric{quantile="..."} may be sorted before their parent metric.
+// The help is obtained from the first metric with non-empty help.
+func getMetricFamilyMetadata(sa []*namedMetric, metricFamily string) (string, string) {
+	metricType := ""
+	help := ""
+	for _, nm := range sa {
+		if getMetricFamily(nm.name) != metricFamily {
+			break
+		}
+		if metricType == "" && !nm.isAux {
+			metricType = nm.metric.metricType()
+		}
+		if help == "" {
+			help = nm.getHelp()
+		}
+	}
+	if metricType == "" {
+		metricType = sa[0].metric.metricType()
+	}
+	return metricType, help
+}
+
+// escapeHelp escapes backslashes and line feeds in help according to Prometheus text exposition format.
+func escapeHelp(help string) string {
+	if !strings.ContainsAny(help, "\\\n") {
+		return help
+	}
+	return helpReplacer.Replace(help)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
 func getMetricFamily(metricName string) string {
 	n := strings.IndexByte(metricName, '{')
 	if n < 0 {
@@ -12,3 +12,12 @@ func ExampleWritePrometheus() {
 		metrics.WritePrometheus(w, true)
 	})
 }
+
+func ExampleHandler() {
+	// Export all the registered metrics at `/metrics` http path.
+	// The response is gzip-compressed if the client supports it.
+	http.Handle("/metrics", metrics.Handler(metrics.HandlerOpts{
+		ExposeProcessMetrics: true,
+		MaxRequestsInFlight:  10,
+	}))
+}
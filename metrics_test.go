@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -132,6 +134,39 @@ func TestRegisterMetricsWriter(t *testing.T) {
 	}
 }
 
+func TestSetRegisterMetricsWriter(t *testing.T) {
+	s := NewSet()
+	s.NewCounter("native").Inc()
+	mw1 := s.RegisterMetricsWriter(func(w io.Writer) {
+		// Missing trailing newline must be added.
+		fmt.Fprintf(w, "first 1")
+	})
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "partial 2\n")
+		panic("unexpected error")
+	})
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "third 3\n")
+	})
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f("native 1\nfirst 1\npartial 2\nthird 3\n")
+
+	mw1.Close()
+	f("native 1\npartial 2\nthird 3\n")
+
+	// Double Close must be ok.
+	mw1.Close()
+	f("native 1\npartial 2\nthird 3\n")
+}
+
 func TestRegisterUnregisterSet(t *testing.T) {
 	const metricName = "metric_from_set"
 	const metricValue = 123
@@ -157,6 +192,162 @@ func TestRegisterUnregisterSet(t *testing.T) {
 	}
 }
 
+func TestAddMissingLabels(t *testing.T) {
+	f := func(s string, extraLabels map[string]string, resultExpected string) {
+		t.Helper()
+		labels := getSortedExtraLabels(extraLabels)
+		result := addMissingLabels(nil, []byte(s), labels)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	extraLabels := map[string]string{
+		"instance": "foo:1234",
+		"env":      `a"b\c`,
+	}
+	f("", extraLabels, "")
+	f("foo 1\n", extraLabels, `foo{env="a\"b\\c",instance="foo:1234"} 1`+"\n")
+	f("foo{} 1\n", extraLabels, `foo{env="a\"b\\c",instance="foo:1234"} 1`+"\n")
+	f(`foo{bar="baz"} 1.5 1234`+"\n", extraLabels, `foo{bar="baz",env="a\"b\\c",instance="foo:1234"} 1.5 1234`+"\n")
+
+	// The metric's own label value must be preserved.
+	f(`foo{instance="x",y="}"} 2`+"\n", extraLabels, `foo{instance="x",y="}",env="a\"b\\c"} 2`+"\n")
+
+	// Comments are copied as is.
+	f("# HELP foo bar\n# TYPE foo counter\nfoo 3\n", map[string]string{"a": "b"}, "# HELP foo bar\n# TYPE foo counter\n"+`foo{a="b"} 3`+"\n")
+}
+
+func TestWritePrometheusWithLabels(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`WritePrometheusWithLabels{region="us"}`).Inc()
+	RegisterSet(s)
+	defer UnregisterSet(s)
+
+	var bb bytes.Buffer
+	WritePrometheusWithLabels(&bb, true, map[string]string{
+		"region": "eu",
+		"env":    "prod",
+	})
+	result := bb.String()
+	if !strings.Contains(result, `WritePrometheusWithLabels{region="us",env="prod"} 1`+"\n") {
+		t.Fatalf("missing metric with extra labels in the output:\n%s", result)
+	}
+	if !strings.Contains(result, `go_goroutines{env="prod",region="eu"} `) {
+		t.Fatalf("missing process metric with extra labels in the output:\n%s", result)
+	}
+
+	expectPanic(t, "WritePrometheusWithLabels", func() {
+		WritePrometheusWithLabels(&bb, false, map[string]string{"bad label": "x"})
+	})
+}
+
+func TestWritePrometheusWithOpts(t *testing.T) {
+	s := NewSet()
+	s.NewCounter("WritePrometheusWithOpts_total").Inc()
+	RegisterSet(s)
+	defer UnregisterSet(s)
+
+	getSampleNames := func(opts WritePrometheusOpts) []string {
+		var bb bytes.Buffer
+		WritePrometheusWithOpts(&bb, opts)
+		var names []string
+		for _, line := range strings.Split(bb.String(), "\n") {
+			// Skip metrics_push_* metrics, since they may be changed by concurrently running pushers from other tests.
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "metrics_push_") {
+				continue
+			}
+			n := strings.LastIndexByte(line, ' ')
+			names = append(names, line[:n])
+		}
+		return names
+	}
+	hasPrefix := func(names []string, prefix string) bool {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	f := func(opts WritePrometheusOpts, prefix string, isExpected bool) {
+		t.Helper()
+		names := getSampleNames(opts)
+		if !hasPrefix(names, "WritePrometheusWithOpts_total") {
+			t.Fatalf("missing registered metric in the output:\n%q", names)
+		}
+		if ok := hasPrefix(names, prefix); ok != isExpected {
+			t.Fatalf("unexpected presence of metrics with %q prefix for opts %+v; got %v; want %v", prefix, opts, ok, isExpected)
+		}
+	}
+
+	f(WritePrometheusOpts{}, "go_", false)
+	f(WritePrometheusOpts{}, "process_", false)
+	f(WritePrometheusOpts{ExposeGoMetrics: true}, "go_goroutines", true)
+	f(WritePrometheusOpts{ExposeGoMetrics: true}, "go_info", false)
+	f(WritePrometheusOpts{ExposeGoMetrics: true}, "process_", false)
+	f(WritePrometheusOpts{ExposeBuildInfo: true}, "go_info", true)
+	f(WritePrometheusOpts{ExposeBuildInfo: true}, "go_goroutines", false)
+	f(WritePrometheusOpts{ExposeBuildInfo: true}, "process_pid", true)
+	f(WritePrometheusOpts{ExposeGoMetrics: true, ExposeProcessMetrics: true}, "process_pid", false)
+	f(WritePrometheusOpts{ExposeProcessMetrics: true}, "go_", false)
+	if runtime.GOOS == "linux" {
+		f(WritePrometheusOpts{ExposeProcessMetrics: true}, "process_cpu_seconds_total", true)
+		f(WritePrometheusOpts{ExposeProcessMetrics: true}, "process_open_fds", false)
+		f(WritePrometheusOpts{ExposeFDMetrics: true}, "process_open_fds", true)
+		f(WritePrometheusOpts{ExposeFDMetrics: true}, "process_cpu_seconds_total", false)
+	}
+
+	// The legacy bool results in the same series.
+	var bb bytes.Buffer
+	WritePrometheus(&bb, true)
+	var namesLegacy []string
+	for _, line := range strings.Split(bb.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "metrics_push_") {
+			namesLegacy = append(namesLegacy, line[:strings.LastIndexByte(line, ' ')])
+		}
+	}
+	names := getSampleNames(WritePrometheusOpts{
+		ExposeProcessMetrics: true,
+		ExposeGoMetrics:      true,
+		ExposeBuildInfo:      true,
+	})
+	if !reflect.DeepEqual(names, namesLegacy) {
+		t.Fatalf("unexpected series names;\ngot\n%q\nwant\n%q", names, namesLegacy)
+	}
+
+	// Extra labels are added to all the samples.
+	names = getSampleNames(WritePrometheusOpts{
+		ExposeBuildInfo: true,
+		ExtraLabels:     map[string]string{"env": "prod"},
+	})
+	if !hasPrefix(names, `WritePrometheusWithOpts_total{env="prod"}`) {
+		t.Fatalf("missing extra labels for the registered metric:\n%q", names)
+	}
+	if !hasPrefix(names, `go_info{version=`) || !strings.HasSuffix(names[len(names)-1], `env="prod"}`) {
+		t.Fatalf("missing extra labels for build info:\n%q", names)
+	}
+
+	// The hostname label is added to go_info and process_pid.
+	hostname := string(appendEscapedLabelValue(nil, getHostname()))
+	names = getSampleNames(WritePrometheusOpts{
+		ExposeBuildInfo: true,
+		HostnameLabel:   "instance",
+	})
+	for _, name := range []string{
+		fmt.Sprintf(`go_info{version=%q,instance="%s"}`, runtime.Version(), hostname),
+		fmt.Sprintf(`process_pid{instance="%s"}`, hostname),
+	} {
+		if !hasPrefix(names, name) {
+			t.Fatalf("missing %s in the output:\n%q", name, names)
+		}
+	}
+	expectPanic(t, "invalid HostnameLabel", func() {
+		WritePrometheusWithOpts(io.Discard, WritePrometheusOpts{
+			HostnameLabel: "bad label",
+		})
+	})
+}
+
 func TestInvalidName(t *testing.T) {
 	f := func(name string) {
 		t.Helper()
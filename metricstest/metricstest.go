@@ -0,0 +1,237 @@
+// Package metricstest provides helpers for verifying metrics from github.com/VictoriaMetrics/metrics in tests.
+//
+// Helpers without Set suffix work with the default set and all the sets added via metrics.RegisterSet,
+// while helpers with Set suffix work with the given metrics.Set. Process metrics such as `go_*` and `process_*`
+// are never included unless they are explicitly requested by name.
+package metricstest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// CollectAndCompare verifies that metrics from the default set and all the added sets match expected text
+// in Prometheus text exposition format.
+//
+// Only metric families with the given metricNames are compared. For instance, `requests_total` compares
+// all the `requests_total{...}` series, while `request_duration_seconds` compares all the `_bucket`, `_sum`
+// and `_count` series for the histogram. All the metrics are compared if metricNames is empty.
+//
+// Lines are compared regardless of their order and of the surrounding whitespace, while empty lines are ignored.
+// The test is marked as failed with a readable diff on mismatch.
+func CollectAndCompare(t testing.TB, expected string, metricNames ...string) {
+	t.Helper()
+	var bb bytes.Buffer
+	if len(metricNames) == 0 {
+		metrics.WritePrometheus(&bb, false)
+	} else {
+		metrics.WritePrometheusMatching(&bb, metricNames)
+	}
+	compare(t, bb.String(), expected)
+}
+
+// CollectAndCompareSet verifies that metrics from s match expected text in Prometheus text exposition format.
+//
+// See CollectAndCompare for details.
+func CollectAndCompareSet(t testing.TB, s *metrics.Set, expected string, metricNames ...string) {
+	t.Helper()
+	var bb bytes.Buffer
+	if len(metricNames) == 0 {
+		s.WritePrometheus(&bb)
+	} else {
+		s.WritePrometheusMatching(&bb, metricNames)
+	}
+	compare(t, bb.String(), expected)
+}
+
+// ToFloat64 returns the current value of the series with the given name from the default set and all the added sets.
+//
+// name must contain the full series name with labels, such as `requests_total{path="/foo"}`. The order of labels doesn't matter.
+// Histograms and summaries are exposed as separate series, such as `request_duration_seconds_count`.
+//
+// ToFloat64 panics if the series is missing, since this is a bug in the test.
+func ToFloat64(name string) float64 {
+	return toFloat64(metrics.SnapshotMetricSamples(), name)
+}
+
+// ToFloat64Set returns the current value of the series with the given name from s.
+//
+// See ToFloat64 for details.
+func ToFloat64Set(s *metrics.Set, name string) float64 {
+	return toFloat64(s.SnapshotMetricSamples(), name)
+}
+
+// CollectAndCount returns the number of series with names starting with prefix in the default set and all the added sets.
+//
+// It is useful for cardinality checks. For example, CollectAndCount(`requests_total{`) returns the number
+// of `requests_total` series with labels.
+func CollectAndCount(prefix string) int {
+	return countSeries(metrics.SnapshotMetricSamples(), prefix)
+}
+
+// CollectAndCountSet returns the number of series with names starting with prefix in s.
+//
+// See CollectAndCount for details.
+func CollectAndCountSet(s *metrics.Set, prefix string) int {
+	return countSeries(s.SnapshotMetricSamples(), prefix)
+}
+
+func compare(t testing.TB, got, expected string) {
+	t.Helper()
+	gotLines := normalizeLines(got)
+	expectedLines := normalizeLines(expected)
+	if diff := diffLines(gotLines, expectedLines); diff != "" {
+		t.Errorf("unexpected metrics (-want +got):\n%s", diff)
+	}
+}
+
+// normalizeLines returns sorted non-empty lines from s with normalized whitespace.
+func normalizeLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		line = normalizeWhitespace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// normalizeWhitespace trims line and replaces every sequence of whitespace chars outside label values with a single space.
+func normalizeWhitespace(line string) string {
+	line = strings.TrimSpace(line)
+	var sb strings.Builder
+	inQuotes := false
+	prevSpace := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if inQuotes {
+			sb.WriteByte(c)
+			switch c {
+			case '\\':
+				if i+1 < len(line) {
+					i++
+					sb.WriteByte(line[i])
+				}
+			case '"':
+				inQuotes = false
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' {
+			if !prevSpace {
+				sb.WriteByte(' ')
+			}
+			prevSpace = true
+			continue
+		}
+		prevSpace = false
+		if c == '"' {
+			inQuotes = true
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// diffLines returns the diff between sorted got and expected lines.
+//
+// Lines missing in got are prefixed with `-`, while unexpected lines in got are prefixed with `+`.
+// Empty string is returned if got and expected are equal.
+func diffLines(got, expected []string) string {
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(got) || j < len(expected) {
+		switch {
+		case i < len(got) && j < len(expected) && got[i] == expected[j]:
+			i++
+			j++
+		case j < len(expected) && (i >= len(got) || expected[j] < got[i]):
+			fmt.Fprintf(&sb, "- %s\n", expected[j])
+			j++
+		default:
+			fmt.Fprintf(&sb, "+ %s\n", got[i])
+			i++
+		}
+	}
+	return sb.String()
+}
+
+func toFloat64(samples []metrics.MetricSample, name string) float64 {
+	metricName, labels, err := parseSeriesName(name)
+	if err != nil {
+		panic(fmt.Errorf("BUG: cannot parse series name %q: %s", name, err))
+	}
+	for i := range samples {
+		ms := &samples[i]
+		if ms.Name == metricName && equalLabels(ms.Labels, labels) {
+			return ms.Value
+		}
+	}
+	panic(fmt.Errorf("BUG: missing series %q", name))
+}
+
+func countSeries(samples []metrics.MetricSample, prefix string) int {
+	n := 0
+	for i := range samples {
+		if strings.HasPrefix(samples[i].SeriesName(), prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// parseSeriesName parses series name in the form `name{label1="value1",...,labelN="valueN"}`.
+func parseSeriesName(s string) (string, map[string]string, error) {
+	n := strings.IndexByte(s, '{')
+	if n < 0 {
+		return s, nil, nil
+	}
+	name := s[:n]
+	tail := strings.TrimSpace(s[n+1:])
+	if !strings.HasSuffix(tail, "}") {
+		return "", nil, fmt.Errorf("missing closing curly brace")
+	}
+	tail = tail[:len(tail)-1]
+	labels := make(map[string]string)
+	for {
+		tail = strings.TrimLeft(tail, " ,")
+		if tail == "" {
+			return name, labels, nil
+		}
+		n := strings.IndexByte(tail, '=')
+		if n <= 0 {
+			return "", nil, fmt.Errorf("missing `=` after label name in %q", tail)
+		}
+		labelName := strings.TrimSpace(tail[:n])
+		tail = strings.TrimLeft(tail[n+1:], " ")
+		value, err := strconv.QuotedPrefix(tail)
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot parse value for %q label: %w", labelName, err)
+		}
+		tail = tail[len(value):]
+		labels[labelName], err = strconv.Unquote(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot unquote value for %q label: %w", labelName, err)
+		}
+	}
+}
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if v, ok := b[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package metricstest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// fakeT captures failures reported by the helpers.
+type fakeT struct {
+	testing.TB
+
+	failures []string
+}
+
+func (ft *fakeT) Helper() {}
+
+func (ft *fakeT) Errorf(format string, args ...interface{}) {
+	ft.failures = append(ft.failures, fmt.Sprintf(format, args...))
+}
+
+func TestCollectAndCompareSetSuccess(t *testing.T) {
+	s := metrics.NewSet()
+	s.NewCounter(`requests_total{path="/foo"}`).Add(2)
+	s.NewCounter(`requests_total{path="/bar"}`).Inc()
+	s.NewCounter("errors_total").Inc()
+	h := s.NewHistogram("duration_seconds")
+	h.Update(1)
+
+	CollectAndCompareSet(t, s, `
+		requests_total{path="/foo"}   2
+		requests_total{path="/bar"}	1
+	`, "requests_total")
+
+	CollectAndCompareSet(t, s, `
+duration_seconds_bucket{vmrange="8.799e-01...1.000e+00"} 1
+duration_seconds_sum 1
+duration_seconds_count 1
+errors_total 1
+requests_total{path="/bar"} 1
+requests_total{path="/foo"} 2
+`)
+}
+
+func TestCollectAndCompareSetFailure(t *testing.T) {
+	s := metrics.NewSet()
+	s.NewCounter(`requests_total{path="/foo"}`).Add(2)
+	s.NewCounter(`requests_total{path="/bar"}`).Inc()
+
+	var ft fakeT
+	CollectAndCompareSet(&ft, s, `
+		requests_total{path="/foo"} 3
+		requests_total{path="/bar"} 1
+	`, "requests_total")
+	if len(ft.failures) != 1 {
+		t.Fatalf("unexpected number of failures; got %d; want 1", len(ft.failures))
+	}
+	failureExpected := `unexpected metrics (-want +got):
++ requests_total{path="/foo"} 2
+- requests_total{path="/foo"} 3
+`
+	if ft.failures[0] != failureExpected {
+		t.Fatalf("unexpected failure message; got\n%s\nwant\n%s", ft.failures[0], failureExpected)
+	}
+}
+
+func TestCollectAndCompare(t *testing.T) {
+	c := metrics.NewCounter(`metricstest_default_total{a="b"}`)
+	defer metrics.UnregisterMetric(`metricstest_default_total{a="b"}`)
+	c.Add(5)
+
+	// metricstest_default_total must be found in the default set, while process metrics mustn't be included.
+	CollectAndCompare(t, `metricstest_default_total{a="b"} 5`, "metricstest_default_total")
+
+	if v := ToFloat64(`metricstest_default_total{a="b"}`); v != 5 {
+		t.Fatalf("unexpected value; got %v; want 5", v)
+	}
+	if n := CollectAndCount("metricstest_default_total"); n != 1 {
+		t.Fatalf("unexpected number of series; got %d; want 1", n)
+	}
+}
+
+func TestToFloat64Set(t *testing.T) {
+	s := metrics.NewSet()
+	s.NewCounter(`requests_total{path="/foo",code="200"}`).Add(2)
+	s.NewGauge(`queue_size{name="a\"b"}`, func() float64 { return 1.5 })
+	sm := s.NewSummary("latency")
+	sm.Update(3)
+
+	f := func(name string, resultExpected float64) {
+		t.Helper()
+		if result := ToFloat64Set(s, name); result != resultExpected {
+			t.Fatalf("unexpected value for %q; got %v; want %v", name, result, resultExpected)
+		}
+	}
+	f(`requests_total{path="/foo",code="200"}`, 2)
+	f(`requests_total{code="200", path="/foo"}`, 2)
+	f(`queue_size{name="a\"b"}`, 1.5)
+	f(`latency_count`, 1)
+	f(`latency_sum`, 3)
+
+	// Missing series
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expecting panic for missing series")
+			}
+		}()
+		ToFloat64Set(s, `requests_total{path="/bar"}`)
+	}()
+}
+
+func TestCollectAndCountSet(t *testing.T) {
+	s := metrics.NewSet()
+	for i := 0; i < 10; i++ {
+		s.NewCounter(fmt.Sprintf(`requests_total{path="/foo/%d"}`, i)).Inc()
+	}
+	s.NewCounter("requests_total_other").Inc()
+
+	f := func(prefix string, nExpected int) {
+		t.Helper()
+		if n := CollectAndCountSet(s, prefix); n != nExpected {
+			t.Fatalf("unexpected number of series for prefix %q; got %d; want %d", prefix, n, nExpected)
+		}
+	}
+	f(`requests_total{`, 10)
+	f(`requests_total`, 11)
+	f(`missing`, 0)
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	f := func(line, resultExpected string) {
+		t.Helper()
+		if result := normalizeWhitespace(line); result != resultExpected {
+			t.Fatalf("unexpected result for %q; got %q; want %q", line, result, resultExpected)
+		}
+	}
+	f("", "")
+	f("  foo   1  ", "foo 1")
+	f("foo{a=\"b  c\"}\t\t1", `foo{a="b  c"} 1`)
+	f(`foo{a="x\"  y"}  2`, `foo{a="x\"  y"} 2`)
+	f("a \t b", "a b")
+}
@@ -0,0 +1,995 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// MultiProcessGaugePolicy is the policy for merging values of gauges with the same name from multiple processes.
+type MultiProcessGaugePolicy int
+
+const (
+	// MultiProcessGaugeSum exposes the sum of gauge values across live processes.
+	MultiProcessGaugeSum MultiProcessGaugePolicy = iota
+
+	// MultiProcessGaugeMax exposes the maximum gauge value across live processes.
+	MultiProcessGaugeMax
+
+	// MultiProcessGaugeLatest exposes the most recently updated gauge value across live processes.
+	MultiProcessGaugeLatest
+)
+
+// String returns string representation for p.
+func (p MultiProcessGaugePolicy) String() string {
+	switch p {
+	case MultiProcessGaugeSum:
+		return "sum"
+	case MultiProcessGaugeMax:
+		return "max"
+	case MultiProcessGaugeLatest:
+		return "latest"
+	default:
+		return fmt.Sprintf("MultiProcessGaugePolicy(%d)", int(p))
+	}
+}
+
+// MultiProcessSet is a set of metrics shared by multiple processes via memory-mapped files in a common directory.
+//
+// It is intended for pre-fork servers, where a single HTTP handler cannot see metrics of all the worker processes.
+// Every process must create its own MultiProcessSet for the same directory via NewMultiProcessSet, while metrics
+// from all the processes are merged at scrape time by NewMultiProcessHandler or WriteMultiProcessMetrics.
+//
+// Metric values are stored in the memory-mapped file, so updating them costs the same atomic operation
+// as updating in-memory metrics such as Counter.
+//
+// Multi-process mode is supported only on Linux, macOS and FreeBSD.
+type MultiProcessSet struct {
+	mu sync.Mutex
+
+	path string
+	f    *os.File
+
+	// data is the current mapping of the file.
+	data []byte
+
+	// prevMappings contains mappings, which were replaced by data when the file grew.
+	//
+	// They are unmapped only on Close, since metrics created before the growth refer to them.
+	prevMappings [][]byte
+
+	metrics map[string]interface{}
+	closed  bool
+}
+
+const (
+	multiProcessFileMagic       = "VMMPROC1"
+	multiProcessHeaderSize      = 16
+	multiProcessInitialFileSize = 64 * 1024
+	multiProcessFilePrefix      = "pid_"
+	multiProcessFileSuffix      = ".db"
+	multiProcessTmpSuffix       = ".tmp"
+	multiProcessArchiveFile     = "archive.db"
+	multiProcessLockFile        = "lock"
+)
+
+// NewMultiProcessSet creates a set backed by a new file for the current process in the given dir.
+//
+// The dir is created if it doesn't exist. The file is named `pid_<pid>_<start_time>.db`. Files of processes,
+// which no longer exist, are compacted and removed by NewMultiProcessHandler and WriteMultiProcessMetrics.
+// It is recommended to clean up dir before starting the server, so metrics from the previous runs aren't exposed.
+//
+// An error is returned if multi-process mode isn't supported on the current platform.
+func NewMultiProcessSet(dir string) (*MultiProcessSet, error) {
+	return newMultiProcessSet(dir, os.Getpid())
+}
+
+func newMultiProcessSet(dir string, pid int) (*MultiProcessSet, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create directory for multi-process metrics: %w", err)
+	}
+	name := fmt.Sprintf("%s%d_%d%s", multiProcessFilePrefix, pid, time.Now().UnixNano(), multiProcessFileSuffix)
+	path := filepath.Join(dir, name)
+	tmpPath := path + multiProcessTmpSuffix
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create file for multi-process metrics: %w", err)
+	}
+	if err := f.Truncate(multiProcessInitialFileSize); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("cannot resize %q: %w", tmpPath, err)
+	}
+	data, err := mmapFile(f, multiProcessInitialFileSize)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("cannot mmap %q: %w", tmpPath, err)
+	}
+	copy(data, multiProcessFileMagic)
+	atomic.StoreUint64(multiProcessUsedPtr(data), multiProcessHeaderSize)
+	// The file is renamed after the header is initialized, so readers never see incomplete header.
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = munmapFile(data)
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("cannot rename %q to %q: %w", tmpPath, path, err)
+	}
+	mps := &MultiProcessSet{
+		path:    path,
+		f:       f,
+		data:    data,
+		metrics: make(map[string]interface{}),
+	}
+	return mps, nil
+}
+
+// Path returns the path to the file backing mps.
+func (mps *MultiProcessSet) Path() string {
+	return mps.path
+}
+
+// Close unmaps and closes the file backing mps.
+//
+// The file isn't removed, so the values of counters and histograms are preserved after the process exits.
+// Metrics obtained from mps must not be used after Close.
+func (mps *MultiProcessSet) Close() error {
+	mps.mu.Lock()
+	defer mps.mu.Unlock()
+	if mps.closed {
+		return nil
+	}
+	mps.closed = true
+	var errs []string
+	for _, data := range append(mps.prevMappings, mps.data) {
+		if err := munmapFile(data); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	mps.prevMappings = nil
+	mps.data = nil
+	if err := mps.f.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot close %q: %s", mps.path, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// MultiProcessCounter is a counter stored in a file shared with other processes.
+//
+// Values of counters with the same name are summed across processes including the processes, which no longer exist.
+type MultiProcessCounter struct {
+	n *uint64
+}
+
+// Inc increments c.
+func (c *MultiProcessCounter) Inc() {
+	atomic.AddUint64(c.n, 1)
+}
+
+// Add adds n to c.
+func (c *MultiProcessCounter) Add(n int) {
+	atomic.AddUint64(c.n, uint64(n))
+}
+
+// Get returns the value of c for the current process.
+func (c *MultiProcessCounter) Get() uint64 {
+	return atomic.LoadUint64(c.n)
+}
+
+// MultiProcessGauge is a gauge stored in a file shared with other processes.
+//
+// Values of gauges with the same name are merged across live processes according to MultiProcessGaugePolicy.
+// Gauges of processes, which no longer exist, aren't exposed.
+type MultiProcessGauge struct {
+	valueBits *uint64
+
+	// timestamp is the last update time in nanoseconds since Unix epoch. It is used by MultiProcessGaugeLatest policy.
+	timestamp *uint64
+}
+
+// Set sets g value to v.
+func (g *MultiProcessGauge) Set(v float64) {
+	atomic.StoreUint64(g.valueBits, math.Float64bits(v))
+	g.updateTimestamp()
+}
+
+// Add adds v to g.
+func (g *MultiProcessGauge) Add(v float64) {
+	addFloat64Bits(g.valueBits, v)
+	g.updateTimestamp()
+}
+
+// Inc increments g by 1.
+func (g *MultiProcessGauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements g by 1.
+func (g *MultiProcessGauge) Dec() {
+	g.Add(-1)
+}
+
+// Get returns the value of g for the current process.
+func (g *MultiProcessGauge) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(g.valueBits))
+}
+
+func (g *MultiProcessGauge) updateTimestamp() {
+	atomic.StoreUint64(g.timestamp, uint64(time.Now().UnixNano()))
+}
+
+// MultiProcessHistogram is a histogram with fixed buckets stored in a file shared with other processes.
+//
+// It is exposed in the same way as PrometheusHistogram. Bucket counters and sums of histograms with the same name
+// are summed across processes including the processes, which no longer exist.
+type MultiProcessHistogram struct {
+	upperBounds []float64
+
+	// buckets contains non-cumulative counters for upperBounds plus the last +Inf bucket.
+	buckets []uint64
+
+	sumBits *uint64
+}
+
+// Update updates h with v.
+//
+// NaN values are ignored.
+func (h *MultiProcessHistogram) Update(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	n := sort.SearchFloat64s(h.upperBounds, v)
+	atomic.AddUint64(&h.buckets[n], 1)
+	addFloat64Bits(h.sumBits, v)
+}
+
+// UpdateDuration updates h with the duration in seconds since startTime.
+func (h *MultiProcessHistogram) UpdateDuration(startTime time.Time) {
+	h.Update(time.Since(startTime).Seconds())
+}
+
+func addFloat64Bits(p *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(p)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(p, old, n) {
+			return
+		}
+	}
+}
+
+// GetOrCreateCounter returns registered counter in mps with the given name or creates new counter if mps doesn't contain it.
+//
+// name must be valid Prometheus-compatible metric with possible labels. For example, `requests_total{path="/foo"}`.
+func (mps *MultiProcessSet) GetOrCreateCounter(name string) *MultiProcessCounter {
+	name = mustNormalizeMultiProcessMetricName(name)
+	mps.mu.Lock()
+	defer mps.mu.Unlock()
+	if m, ok := mps.metrics[name]; ok {
+		c, ok := m.(*MultiProcessCounter)
+		if !ok {
+			panic(fmt.Errorf("BUG: metric %q isn't a MultiProcessCounter; it is %T", name, m))
+		}
+		return c
+	}
+	values := mps.allocValuesLocked("c"+name, 1)
+	c := &MultiProcessCounter{
+		n: &values[0],
+	}
+	mps.metrics[name] = c
+	return c
+}
+
+// GetOrCreateGauge returns registered gauge in mps with the given name or creates new gauge if mps doesn't contain it.
+//
+// The policy defines how values of the gauge are merged across processes.
+// name must be valid Prometheus-compatible metric with possible labels. For example, `active_connections{pool="db"}`.
+func (mps *MultiProcessSet) GetOrCreateGauge(name string, policy MultiProcessGaugePolicy) *MultiProcessGauge {
+	if policy < MultiProcessGaugeSum || policy > MultiProcessGaugeLatest {
+		panic(fmt.Errorf("BUG: unsupported policy for gauge %q: %s", name, policy))
+	}
+	name = mustNormalizeMultiProcessMetricName(name)
+	mps.mu.Lock()
+	defer mps.mu.Unlock()
+	if m, ok := mps.metrics[name]; ok {
+		g, ok := m.(*MultiProcessGauge)
+		if !ok {
+			panic(fmt.Errorf("BUG: metric %q isn't a MultiProcessGauge; it is %T", name, m))
+		}
+		return g
+	}
+	values := mps.allocValuesLocked("g"+strconv.Itoa(int(policy))+name, 2)
+	g := &MultiProcessGauge{
+		valueBits: &values[0],
+		timestamp: &values[1],
+	}
+	mps.metrics[name] = g
+	return g
+}
+
+// GetOrCreateHistogram returns registered histogram in mps with the given name or creates new histogram if mps doesn't contain it.
+//
+// upperBounds must be sorted in ascending order. The last +Inf bucket is added automatically.
+// Histograms with the same name must have the same upperBounds in all the processes.
+// name must be valid Prometheus-compatible metric with possible labels. For example, `request_duration_seconds{path="/foo"}`.
+func (mps *MultiProcessSet) GetOrCreateHistogram(name string, upperBounds []float64) *MultiProcessHistogram {
+	if len(upperBounds) == 0 {
+		panic(fmt.Errorf("BUG: upperBounds for histogram %q cannot be empty", name))
+	}
+	for i, bound := range upperBounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			panic(fmt.Errorf("BUG: upperBounds for histogram %q must be finite; got %v", name, bound))
+		}
+		if i > 0 && bound <= upperBounds[i-1] {
+			panic(fmt.Errorf("BUG: upperBounds for histogram %q must be sorted in ascending order; got %v", name, upperBounds))
+		}
+	}
+	name = mustNormalizeMultiProcessMetricName(name)
+	mps.mu.Lock()
+	defer mps.mu.Unlock()
+	if m, ok := mps.metrics[name]; ok {
+		h, ok := m.(*MultiProcessHistogram)
+		if !ok {
+			panic(fmt.Errorf("BUG: metric %q isn't a MultiProcessHistogram; it is %T", name, m))
+		}
+		return h
+	}
+	bounds := append([]float64{}, upperBounds...)
+	values := mps.allocValuesLocked(marshalMultiProcessHistogramKey(name, bounds), len(bounds)+2)
+	h := &MultiProcessHistogram{
+		upperBounds: bounds,
+		buckets:     values[:len(bounds)+1],
+		sumBits:     &values[len(bounds)+1],
+	}
+	mps.metrics[name] = h
+	return h
+}
+
+func mustNormalizeMultiProcessMetricName(name string) string {
+	normalizedName, err := normalizeMetricName(name)
+	if err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name %q: %w", name, err))
+	}
+	return normalizedName
+}
+
+func marshalMultiProcessHistogramKey(name string, upperBounds []float64) string {
+	a := make([]string, len(upperBounds))
+	for i, bound := range upperBounds {
+		a[i] = strconv.FormatFloat(bound, 'g', -1, 64)
+	}
+	return "h" + name + "\x00" + strings.Join(a, ",")
+}
+
+// allocValuesLocked appends an entry with the given key and the given number of zero values to the file.
+//
+// If the entry cannot be allocated, then the error is logged and the values are allocated in memory,
+// so the metric remains usable, but it isn't exposed.
+func (mps *MultiProcessSet) allocValuesLocked(key string, valuesLen int) []uint64 {
+	if mps.closed {
+		panic(fmt.Errorf("BUG: cannot create metric in closed MultiProcessSet %q", mps.path))
+	}
+	usedPtr := multiProcessUsedPtr(mps.data)
+	used := int(atomic.LoadUint64(usedPtr))
+	valuesOffset := used + 8 + alignMultiProcessSize(len(key))
+	end := valuesOffset + 8*valuesLen
+	if end > len(mps.data) {
+		if err := mps.growLocked(end); err != nil {
+			log.Printf("ERROR: metrics: cannot allocate metric %q in %q: %s; the metric isn't exposed", key[1:], mps.path, err)
+			return make([]uint64, valuesLen)
+		}
+		usedPtr = multiProcessUsedPtr(mps.data)
+	}
+	data := mps.data
+	nativeEndian.PutUint32(data[used:], uint32(len(key)))
+	nativeEndian.PutUint32(data[used+4:], uint32(valuesLen))
+	copy(data[used+8:], key)
+	values := unsafe.Slice((*uint64)(unsafe.Pointer(&data[valuesOffset])), valuesLen)
+	// The entry is published to readers only after it is completely written.
+	atomic.StoreUint64(usedPtr, uint64(end))
+	return values
+}
+
+// growLocked grows the file backing mps, so it can hold at least minSize bytes.
+func (mps *MultiProcessSet) growLocked(minSize int) error {
+	size := len(mps.data)
+	for size < minSize {
+		size *= 2
+	}
+	if err := mps.f.Truncate(int64(size)); err != nil {
+		return fmt.Errorf("cannot resize file to %d bytes: %w", size, err)
+	}
+	data, err := mmapFile(mps.f, size)
+	if err != nil {
+		return fmt.Errorf("cannot mmap %d bytes: %w", size, err)
+	}
+	mps.prevMappings = append(mps.prevMappings, mps.data)
+	mps.data = data
+	return nil
+}
+
+func multiProcessUsedPtr(data []byte) *uint64 {
+	return (*uint64)(unsafe.Pointer(&data[len(multiProcessFileMagic)]))
+}
+
+func alignMultiProcessSize(n int) int {
+	return (n + 7) &^ 7
+}
+
+// nativeEndian is the byte order of the current platform.
+//
+// Files with multi-process metrics are written in native byte order, since values are updated via atomic operations.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// MultiProcessOpts contains options for WriteMultiProcessMetrics and NewMultiProcessHandlerWithOpts.
+type MultiProcessOpts struct {
+	// ExcludeDeadProcesses excludes files of processes, which no longer exist, instead of compacting them.
+	//
+	// By default counters and histograms from files of dead processes are merged into `archive.db` file in the directory,
+	// so their values don't go backwards after the process exits, and then the files are removed.
+	// Enable this option if the directory isn't writable by the process, which exposes the metrics.
+	ExcludeDeadProcesses bool
+}
+
+// NewMultiProcessHandler returns http.Handler, which serves metrics from all the MultiProcessSet files in dir.
+//
+// See WriteMultiProcessMetrics for details.
+func NewMultiProcessHandler(dir string) http.Handler {
+	return NewMultiProcessHandlerWithOpts(dir, nil)
+}
+
+// NewMultiProcessHandlerWithOpts returns http.Handler, which serves metrics from all the MultiProcessSet files in dir
+// according to opts.
+//
+// See WriteMultiProcessMetrics for details.
+func NewMultiProcessHandlerWithOpts(dir string, opts *MultiProcessOpts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bb := getBytesBuffer()
+		defer putBytesBuffer(bb)
+		if err := WriteMultiProcessMetrics(bb, dir, opts); err != nil {
+			log.Printf("ERROR: metrics: cannot write multi-process metrics from %q: %s", dir, err)
+			http.Error(w, "cannot write multi-process metrics", http.StatusInternalServerError)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Type", prometheusContentType)
+		h.Set("Content-Length", strconv.Itoa(len(bb.B)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(bb.B)
+	})
+}
+
+// WriteMultiProcessMetrics merges metrics from all the MultiProcessSet files in dir and writes them to w
+// in Prometheus text exposition format.
+//
+// Counters and histogram buckets with the same name are summed across processes. Gauges are merged across live processes
+// according to MultiProcessGaugePolicy. Processes are detected as dead if there is no process with the PID from the file name.
+// Counters and histograms of dead processes are compacted into `archive.db` file and their files are removed
+// unless opts.ExcludeDeadProcesses is set. Compaction is serialized across concurrent callers via file lock in dir.
+//
+// Metrics are sorted by names. HELP and TYPE metadata is written if it is enabled via ExposeMetadata.
+func WriteMultiProcessMetrics(w io.Writer, dir string, opts *MultiProcessOpts) error {
+	if opts == nil {
+		opts = &MultiProcessOpts{}
+	}
+	agg := newMultiProcessAggregate()
+	var err error
+	if opts.ExcludeDeadProcesses {
+		err = agg.addLiveFiles(dir)
+	} else {
+		err = agg.addFilesWithCompaction(dir)
+	}
+	if err != nil {
+		return err
+	}
+	agg.writePrometheus(w)
+	return nil
+}
+
+// multiProcessFile is a file created by MultiProcessSet.
+type multiProcessFile struct {
+	name string
+	pid  int
+
+	// isTmp is set for files, which weren't initialized yet.
+	isTmp bool
+}
+
+func listMultiProcessFiles(dir string) ([]multiProcessFile, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory with multi-process metrics: %w", err)
+	}
+	var files []multiProcessFile
+	for _, de := range des {
+		name := de.Name()
+		if !strings.HasPrefix(name, multiProcessFilePrefix) {
+			continue
+		}
+		tail := name[len(multiProcessFilePrefix):]
+		isTmp := strings.HasSuffix(tail, multiProcessFileSuffix+multiProcessTmpSuffix)
+		if !isTmp && !strings.HasSuffix(tail, multiProcessFileSuffix) {
+			continue
+		}
+		n := strings.IndexByte(tail, '_')
+		if n < 0 {
+			continue
+		}
+		pid, err := strconv.Atoi(tail[:n])
+		if err != nil || pid <= 0 {
+			continue
+		}
+		files = append(files, multiProcessFile{
+			name:  name,
+			pid:   pid,
+			isTmp: isTmp,
+		})
+	}
+	return files, nil
+}
+
+// multiProcessAggregate contains metrics merged from multiple files.
+type multiProcessAggregate struct {
+	counters   map[string]uint64
+	gauges     map[string]*multiProcessGaugeValue
+	histograms map[string]*multiProcessHistogramValue
+
+	// merged contains names of files of dead processes merged into the archive file.
+	merged map[string]bool
+}
+
+type multiProcessGaugeValue struct {
+	policy    MultiProcessGaugePolicy
+	value     float64
+	timestamp uint64
+}
+
+type multiProcessHistogramValue struct {
+	// buckets contains non-cumulative counters per upper bound including +Inf.
+	buckets map[float64]uint64
+	sum     float64
+}
+
+func newMultiProcessAggregate() *multiProcessAggregate {
+	return &multiProcessAggregate{
+		counters:   make(map[string]uint64),
+		gauges:     make(map[string]*multiProcessGaugeValue),
+		histograms: make(map[string]*multiProcessHistogramValue),
+		merged:     make(map[string]bool),
+	}
+}
+
+// addLiveFiles adds files of live processes from dir to agg.
+func (agg *multiProcessAggregate) addLiveFiles(dir string) error {
+	files, err := listMultiProcessFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.isTmp || !isProcessAlive(f.pid) {
+			continue
+		}
+		if err := agg.addFile(filepath.Join(dir, f.name), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFilesWithCompaction adds files of live processes and the archive file from dir to agg.
+//
+// Files of dead processes are merged into the archive file and then removed.
+func (agg *multiProcessAggregate) addFilesWithCompaction(dir string) error {
+	lockPath := filepath.Join(dir, multiProcessLockFile)
+	lf, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open lock file: %w", err)
+	}
+	defer func() {
+		_ = lf.Close()
+	}()
+	if err := lockFile(lf); err != nil {
+		return fmt.Errorf("cannot lock %q: %w", lockPath, err)
+	}
+	defer func() {
+		_ = unlockFile(lf)
+	}()
+
+	archivePath := filepath.Join(dir, multiProcessArchiveFile)
+	archive := newMultiProcessAggregate()
+	if err := archive.addFile(archivePath, false); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	files, err := listMultiProcessFiles(dir)
+	if err != nil {
+		return err
+	}
+	archiveChanged := false
+	existingFiles := make(map[string]bool, len(files))
+	var deadFiles []string
+	for _, f := range files {
+		existingFiles[f.name] = true
+		path := filepath.Join(dir, f.name)
+		if isProcessAlive(f.pid) {
+			if f.isTmp {
+				continue
+			}
+			if err := agg.addFile(path, true); err != nil {
+				return err
+			}
+			continue
+		}
+		deadFiles = append(deadFiles, path)
+		if f.isTmp || archive.merged[f.name] {
+			// The file could be merged into the archive during the previous compaction, which failed to remove it.
+			continue
+		}
+		if err := archive.addFile(path, false); err != nil {
+			return err
+		}
+		archive.merged[f.name] = true
+		archiveChanged = true
+	}
+	for name := range archive.merged {
+		if !existingFiles[name] {
+			delete(archive.merged, name)
+			archiveChanged = true
+		}
+	}
+	if archiveChanged {
+		if err := writeFileAtomically(archivePath, archive.marshalArchive(nil)); err != nil {
+			return err
+		}
+	}
+	for _, path := range deadFiles {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot remove file of dead process: %w", err)
+		}
+	}
+	agg.addAggregate(archive)
+	return nil
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmpPath := path + multiProcessTmpSuffix
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("cannot create %q: %w", tmpPath, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("cannot write %q: %w", tmpPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("cannot sync %q: %w", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close %q: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("cannot rename %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
+
+// addFile adds metrics from the file at the given path to agg.
+//
+// Gauges are added only if includeGauges is set.
+func (agg *multiProcessAggregate) addFile(path string, includeGauges bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read multi-process metrics: %w", err)
+	}
+	err = forEachMultiProcessEntry(data, func(key string, values []byte) error {
+		return agg.addEntry(key, values, includeGauges)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	return nil
+}
+
+// forEachMultiProcessEntry calls f for every entry in data with the given key and encoded values.
+func forEachMultiProcessEntry(data []byte, f func(key string, values []byte) error) error {
+	if len(data) < multiProcessHeaderSize || string(data[:len(multiProcessFileMagic)]) != multiProcessFileMagic {
+		return fmt.Errorf("missing %q header", multiProcessFileMagic)
+	}
+	used := nativeEndian.Uint64(data[len(multiProcessFileMagic):])
+	if used > uint64(len(data)) {
+		return fmt.Errorf("the used size %d exceeds file size %d", used, len(data))
+	}
+	data = data[:used]
+	offset := uint64(multiProcessHeaderSize)
+	for offset < used {
+		if used-offset < 8 {
+			return fmt.Errorf("incomplete entry header at offset %d", offset)
+		}
+		keyLen := uint64(nativeEndian.Uint32(data[offset:]))
+		valuesLen := uint64(nativeEndian.Uint32(data[offset+4:]))
+		keyOffset := offset + 8
+		valuesOffset := keyOffset + uint64(alignMultiProcessSize(int(keyLen)))
+		end := valuesOffset + 8*valuesLen
+		if keyLen == 0 || end > used {
+			return fmt.Errorf("invalid entry at offset %d", offset)
+		}
+		key := string(data[keyOffset : keyOffset+keyLen])
+		if err := f(key, data[valuesOffset:end]); err != nil {
+			return fmt.Errorf("invalid entry at offset %d: %w", offset, err)
+		}
+		offset = end
+	}
+	return nil
+}
+
+func (agg *multiProcessAggregate) addEntry(key string, values []byte, includeGauges bool) error {
+	getValue := func(i int) uint64 {
+		return nativeEndian.Uint64(values[8*i:])
+	}
+	valuesLen := len(values) / 8
+	kind, name := key[0], key[1:]
+	switch kind {
+	case 'c':
+		if valuesLen != 1 {
+			return fmt.Errorf("unexpected number of values for counter %q; got %d; want 1", name, valuesLen)
+		}
+		agg.counters[name] += getValue(0)
+	case 'g':
+		if valuesLen != 2 || len(name) == 0 {
+			return fmt.Errorf("unexpected gauge entry %q", key)
+		}
+		if !includeGauges {
+			return nil
+		}
+		policy := MultiProcessGaugePolicy(name[0] - '0')
+		name = name[1:]
+		agg.addGauge(name, policy, math.Float64frombits(getValue(0)), getValue(1))
+	case 'h':
+		n := strings.IndexByte(name, 0)
+		if n < 0 {
+			return fmt.Errorf("missing upper bounds for histogram %q", name)
+		}
+		boundsStr := strings.Split(name[n+1:], ",")
+		if valuesLen != len(boundsStr)+2 {
+			return fmt.Errorf("unexpected number of values for histogram %q; got %d; want %d", name[:n], valuesLen, len(boundsStr)+2)
+		}
+		hv := agg.histograms[name[:n]]
+		if hv == nil {
+			hv = &multiProcessHistogramValue{
+				buckets: make(map[float64]uint64),
+			}
+			agg.histograms[name[:n]] = hv
+		}
+		for i, s := range boundsStr {
+			bound, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("cannot parse upper bound for histogram %q: %w", name[:n], err)
+			}
+			hv.buckets[bound] += getValue(i)
+		}
+		hv.buckets[math.Inf(1)] += getValue(len(boundsStr))
+		hv.sum += math.Float64frombits(getValue(len(boundsStr) + 1))
+	case 'm':
+		agg.merged[name] = true
+	default:
+		return fmt.Errorf("unexpected entry %q", key)
+	}
+	return nil
+}
+
+func (agg *multiProcessAggregate) addGauge(name string, policy MultiProcessGaugePolicy, value float64, timestamp uint64) {
+	gv := agg.gauges[name]
+	if gv == nil {
+		gv = &multiProcessGaugeValue{
+			policy: policy,
+		}
+		agg.gauges[name] = gv
+	}
+	switch gv.policy {
+	case MultiProcessGaugeMax:
+		if timestamp != 0 && (gv.timestamp == 0 || value > gv.value) {
+			gv.value = value
+			gv.timestamp = timestamp
+		}
+	case MultiProcessGaugeLatest:
+		if timestamp > gv.timestamp {
+			gv.value = value
+			gv.timestamp = timestamp
+		}
+	default:
+		gv.value += value
+	}
+}
+
+// addAggregate adds counters and histograms from src to agg.
+func (agg *multiProcessAggregate) addAggregate(src *multiProcessAggregate) {
+	for name, n := range src.counters {
+		agg.counters[name] += n
+	}
+	for name, srcHV := range src.histograms {
+		hv := agg.histograms[name]
+		if hv == nil {
+			hv = &multiProcessHistogramValue{
+				buckets: make(map[float64]uint64, len(srcHV.buckets)),
+			}
+			agg.histograms[name] = hv
+		}
+		for bound, n := range srcHV.buckets {
+			hv.buckets[bound] += n
+		}
+		hv.sum += srcHV.sum
+	}
+}
+
+// marshalArchive appends counters, histograms and names of merged files from agg to dst in the file format used by MultiProcessSet.
+func (agg *multiProcessAggregate) marshalArchive(dst []byte) []byte {
+	dst = append(dst, multiProcessFileMagic...)
+	usedOffset := len(dst)
+	dst = append(dst, make([]byte, 8)...)
+	var buf [8]byte
+	appendEntry := func(key string, values []uint64) {
+		nativeEndian.PutUint32(buf[:], uint32(len(key)))
+		nativeEndian.PutUint32(buf[4:], uint32(len(values)))
+		dst = append(dst, buf[:]...)
+		dst = append(dst, key...)
+		dst = append(dst, make([]byte, alignMultiProcessSize(len(key))-len(key))...)
+		for _, v := range values {
+			nativeEndian.PutUint64(buf[:], v)
+			dst = append(dst, buf[:]...)
+		}
+	}
+	names := make([]string, 0, len(agg.counters))
+	for name := range agg.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		appendEntry("c"+name, []uint64{agg.counters[name]})
+	}
+	names = names[:0]
+	for name := range agg.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hv := agg.histograms[name]
+		bounds, counts := hv.getSortedBuckets()
+		// The last bound is +Inf, which is implicit in histogram keys.
+		values := append(counts, math.Float64bits(hv.sum))
+		appendEntry(marshalMultiProcessHistogramKey(name, bounds[:len(bounds)-1]), values)
+	}
+	names = names[:0]
+	for name := range agg.merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		appendEntry("m"+name, nil)
+	}
+	nativeEndian.PutUint64(dst[usedOffset:], uint64(len(dst)))
+	return dst
+}
+
+// getSortedBuckets returns sorted upper bounds and the corresponding non-cumulative counts for hv.
+//
+// The last upper bound is always +Inf.
+func (hv *multiProcessHistogramValue) getSortedBuckets() ([]float64, []uint64) {
+	bounds := make([]float64, 0, len(hv.buckets)+1)
+	for bound := range hv.buckets {
+		if !math.IsInf(bound, 1) {
+			bounds = append(bounds, bound)
+		}
+	}
+	sort.Float64s(bounds)
+	bounds = append(bounds, math.Inf(1))
+	counts := make([]uint64, len(bounds))
+	for i, bound := range bounds {
+		counts[i] = hv.buckets[bound]
+	}
+	return bounds, counts
+}
+
+// writePrometheus writes metrics from agg to w in Prometheus text exposition format.
+func (agg *multiProcessAggregate) writePrometheus(w io.Writer) {
+	type entry struct {
+		name       string
+		metricType string
+	}
+	entries := make([]entry, 0, len(agg.counters)+len(agg.gauges)+len(agg.histograms))
+	for name := range agg.counters {
+		entries = append(entries, entry{name, "counter"})
+	}
+	for name := range agg.gauges {
+		entries = append(entries, entry{name, "gauge"})
+	}
+	for name := range agg.histograms {
+		entries = append(entries, entry{name, "histogram"})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return lessMetricName(entries[i].name, entries[j].name)
+	})
+
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	prevMetricFamily := ""
+	for _, e := range entries {
+		metricFamily := getMetricFamily(e.name)
+		if metricFamily != prevMetricFamily {
+			writeMetadataIfNeeded(bb, metricFamily, e.metricType, "")
+			prevMetricFamily = metricFamily
+		}
+		dst := bb.B
+		switch e.metricType {
+		case "counter":
+			dst = appendMultiProcessSample(dst, e.name, "", "")
+			dst = strconv.AppendUint(dst, agg.counters[e.name], 10)
+			dst = append(dst, '\n')
+		case "gauge":
+			dst = appendMultiProcessSample(dst, e.name, "", "")
+			dst = strconv.AppendFloat(dst, agg.gauges[e.name].value, 'g', -1, 64)
+			dst = append(dst, '\n')
+		case "histogram":
+			hv := agg.histograms[e.name]
+			bounds, counts := hv.getSortedBuckets()
+			total := uint64(0)
+			for i, bound := range bounds {
+				total += counts[i]
+				le := "+Inf"
+				if !math.IsInf(bound, 1) {
+					le = strconv.FormatFloat(bound, 'g', -1, 64)
+				}
+				dst = appendMultiProcessSample(dst, e.name, "_bucket", `le="`+le+`"`)
+				dst = strconv.AppendUint(dst, total, 10)
+				dst = append(dst, '\n')
+			}
+			dst = appendMultiProcessSample(dst, e.name, "_sum", "")
+			dst = strconv.AppendFloat(dst, hv.sum, 'g', -1, 64)
+			dst = append(dst, '\n')
+			dst = appendMultiProcessSample(dst, e.name, "_count", "")
+			dst = strconv.AppendUint(dst, total, 10)
+			dst = append(dst, '\n')
+		}
+		bb.B = dst
+	}
+	_, _ = w.Write(bb.B)
+}
+
+// appendMultiProcessSample appends the name of the sample for the metric with the given name, suffix and extra label to dst.
+func appendMultiProcessSample(dst []byte, name, suffix, extraLabel string) []byte {
+	metricName, labels := splitMetricName(name)
+	dst = append(dst, metricName...)
+	dst = append(dst, suffix...)
+	if extraLabel != "" {
+		if labels == "" {
+			labels = "{" + extraLabel + "}"
+		} else {
+			labels = labels[:len(labels)-1] + "," + extraLabel + "}"
+		}
+	}
+	dst = append(dst, labels...)
+	return append(dst, ' ')
+}
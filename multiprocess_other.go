@@ -0,0 +1,32 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func mmapFile(_ *os.File, _ int) ([]byte, error) {
+	return nil, fmt.Errorf("multi-process metrics aren't supported on %s", runtime.GOOS)
+}
+
+func munmapFile(_ []byte) error {
+	return nil
+}
+
+// lockFile is no-op, since files with multi-process metrics cannot be created on this platform.
+func lockFile(_ *os.File) error {
+	return nil
+}
+
+func unlockFile(_ *os.File) error {
+	return nil
+}
+
+// isProcessAlive always returns true, since there is no portable way to check whether the process exists.
+func isProcessAlive(_ int) bool {
+	return true
+}
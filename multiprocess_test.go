@@ -0,0 +1,318 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiProcessSetMerge(t *testing.T) {
+	dir := t.TempDir()
+	pid := os.Getpid()
+	mps1, err := newMultiProcessSet(dir, pid)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, mps1)
+	mps2, err := newMultiProcessSet(dir, pid)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, mps2)
+
+	mps1.GetOrCreateCounter(`requests_total{path="/foo"}`).Add(3)
+	mps2.GetOrCreateCounter(`requests_total{path="/foo"}`).Inc()
+	mps2.GetOrCreateCounter(`requests_total{path="/bar"}`).Add(2)
+	if n := mps1.GetOrCreateCounter(`requests_total{path="/foo"}`).Get(); n != 3 {
+		t.Fatalf("unexpected counter value; got %d; want 3", n)
+	}
+
+	mps1.GetOrCreateGauge("connections", MultiProcessGaugeSum).Set(2)
+	mps2.GetOrCreateGauge("connections", MultiProcessGaugeSum).Add(5)
+	mps1.GetOrCreateGauge("queue_size", MultiProcessGaugeMax).Set(10)
+	mps2.GetOrCreateGauge("queue_size", MultiProcessGaugeMax).Set(-1)
+	// queue_size from the process without updates must be ignored by max policy.
+	mps3, err := newMultiProcessSet(dir, pid)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, mps3)
+	mps3.GetOrCreateGauge("queue_size", MultiProcessGaugeMax)
+	mps2.GetOrCreateGauge("config_version", MultiProcessGaugeLatest).Set(1)
+	time.Sleep(time.Millisecond)
+	mps1.GetOrCreateGauge("config_version", MultiProcessGaugeLatest).Set(2)
+
+	h1 := mps1.GetOrCreateHistogram(`duration_seconds{path="/foo"}`, []float64{0.1, 1})
+	h2 := mps2.GetOrCreateHistogram(`duration_seconds{path="/foo"}`, []float64{0.1, 1})
+	h1.Update(0.0625)
+	h1.Update(0.5)
+	h2.Update(0.03125)
+	h2.Update(3)
+
+	var bb bytes.Buffer
+	if err := WriteMultiProcessMetrics(&bb, dir, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resultExpected := `config_version 2
+connections 7
+duration_seconds_bucket{path="/foo",le="0.1"} 2
+duration_seconds_bucket{path="/foo",le="1"} 3
+duration_seconds_bucket{path="/foo",le="+Inf"} 4
+duration_seconds_sum{path="/foo"} 3.59375
+duration_seconds_count{path="/foo"} 4
+queue_size 10
+requests_total{path="/bar"} 2
+requests_total{path="/foo"} 4
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestMultiProcessSetMetadata(t *testing.T) {
+	dir := t.TempDir()
+	mps, err := newMultiProcessSet(dir, os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, mps)
+	mps.GetOrCreateCounter(`requests_total{path="/foo"}`).Inc()
+	mps.GetOrCreateCounter(`requests_total{path="/bar"}`).Inc()
+	mps.GetOrCreateHistogram("duration_seconds", []float64{1}).Update(2)
+
+	ExposeMetadata(true)
+	defer ExposeMetadata(false)
+	var bb bytes.Buffer
+	if err := WriteMultiProcessMetrics(&bb, dir, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resultExpected := `# HELP duration_seconds
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="1"} 0
+duration_seconds_bucket{le="+Inf"} 1
+duration_seconds_sum 2
+duration_seconds_count 1
+# HELP requests_total
+# TYPE requests_total counter
+requests_total{path="/bar"} 1
+requests_total{path="/foo"} 1
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestMultiProcessDeadProcesses(t *testing.T) {
+	dir := t.TempDir()
+	deadPID := getDeadPIDForTest(t)
+
+	live, err := newMultiProcessSet(dir, os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, live)
+	live.GetOrCreateCounter("requests_total").Add(3)
+	live.GetOrCreateGauge("connections", MultiProcessGaugeSum).Set(1)
+	live.GetOrCreateHistogram("duration_seconds", []float64{1}).Update(0.5)
+
+	dead, err := newMultiProcessSet(dir, deadPID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dead.GetOrCreateCounter("requests_total").Add(5)
+	dead.GetOrCreateCounter("dead_only_total").Inc()
+	dead.GetOrCreateGauge("connections", MultiProcessGaugeSum).Set(10)
+	dead.GetOrCreateHistogram("duration_seconds", []float64{1}).Update(2)
+	deadPath := dead.Path()
+	mustCloseMultiProcessSet(t, dead)
+	deadData, err := os.ReadFile(deadPath)
+	if err != nil {
+		t.Fatalf("cannot read %q: %s", deadPath, err)
+	}
+
+	f := func(opts *MultiProcessOpts, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		if err := WriteMultiProcessMetrics(&bb, dir, opts); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// Dead processes must be excluded without touching their files.
+	resultLive := `connections 1
+duration_seconds_bucket{le="1"} 1
+duration_seconds_bucket{le="+Inf"} 1
+duration_seconds_sum 0.5
+duration_seconds_count 1
+requests_total 3
+`
+	f(&MultiProcessOpts{ExcludeDeadProcesses: true}, resultLive)
+	if _, err := os.Stat(deadPath); err != nil {
+		t.Fatalf("the file of dead process must remain: %s", err)
+	}
+
+	// Counters and histograms of dead processes must be compacted into the archive.
+	resultCompacted := `connections 1
+dead_only_total 1
+duration_seconds_bucket{le="1"} 1
+duration_seconds_bucket{le="+Inf"} 2
+duration_seconds_sum 2.5
+duration_seconds_count 2
+requests_total 8
+`
+	f(nil, resultCompacted)
+	if _, err := os.Stat(deadPath); !os.IsNotExist(err) {
+		t.Fatalf("the file of dead process must be removed; got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, multiProcessArchiveFile)); err != nil {
+		t.Fatalf("missing archive file: %s", err)
+	}
+
+	// The file, which is already merged into the archive, mustn't be counted twice.
+	// This may happen if the compaction failed after updating the archive.
+	if err := os.WriteFile(deadPath, deadData, 0644); err != nil {
+		t.Fatalf("cannot restore %q: %s", deadPath, err)
+	}
+	dead, err = newMultiProcessSet(dir, deadPID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dead.GetOrCreateCounter("requests_total").Add(100)
+	mustCloseMultiProcessSet(t, dead)
+	resultCompacted = strings.Replace(resultCompacted, "requests_total 8", "requests_total 108", 1)
+	f(nil, resultCompacted)
+	f(nil, resultCompacted)
+	if _, err := os.Stat(deadPath); !os.IsNotExist(err) {
+		t.Fatalf("the file of dead process must be removed; got %v", err)
+	}
+}
+
+func TestMultiProcessSetGrowth(t *testing.T) {
+	dir := t.TempDir()
+	mps, err := newMultiProcessSet(dir, os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, mps)
+
+	first := mps.GetOrCreateCounter("first_total")
+	const n = 5000
+	for i := 0; i < n; i++ {
+		mps.GetOrCreateCounter(`growth_total{id="` + strings.Repeat("x", 10) + strconv.Itoa(i) + `"}`).Inc()
+	}
+	if len(mps.prevMappings) == 0 {
+		t.Fatalf("expecting the file to grow")
+	}
+	// The counter created before the growth must remain usable.
+	first.Add(42)
+
+	var bb bytes.Buffer
+	if err := WriteMultiProcessMetrics(&bb, dir, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(bb.String()), "\n")
+	if len(lines) != n+1 {
+		t.Fatalf("unexpected number of lines; got %d; want %d", len(lines), n+1)
+	}
+	if lines[0] != "first_total 42" {
+		t.Fatalf("unexpected first line; got %q; want %q", lines[0], "first_total 42")
+	}
+}
+
+func TestMultiProcessHandler(t *testing.T) {
+	dir := t.TempDir()
+	mps, err := newMultiProcessSet(dir, os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, mps)
+	mps.GetOrCreateCounter("requests_total").Add(2)
+
+	h := NewMultiProcessHandler(dir)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 {
+		t.Fatalf("unexpected status code; got %d; want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != prometheusContentType {
+		t.Fatalf("unexpected Content-Type; got %q; want %q", ct, prometheusContentType)
+	}
+	if body := w.Body.String(); body != "requests_total 2\n" {
+		t.Fatalf("unexpected body; got %q; want %q", body, "requests_total 2\n")
+	}
+
+	// Missing directory must result in error.
+	h = NewMultiProcessHandler(filepath.Join(dir, "missing"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 500 {
+		t.Fatalf("unexpected status code; got %d; want 500", w.Code)
+	}
+}
+
+func TestMultiProcessSetInvalidArgs(t *testing.T) {
+	dir := t.TempDir()
+	mps, err := newMultiProcessSet(dir, os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer mustCloseMultiProcessSet(t, mps)
+	mps.GetOrCreateCounter("foo")
+
+	expectPanic(t, "invalid name", func() {
+		mps.GetOrCreateCounter("foo{")
+	})
+	expectPanic(t, "type mismatch", func() {
+		mps.GetOrCreateGauge("foo", MultiProcessGaugeSum)
+	})
+	expectPanic(t, "invalid policy", func() {
+		mps.GetOrCreateGauge("bar", MultiProcessGaugePolicy(10))
+	})
+	expectPanic(t, "empty bounds", func() {
+		mps.GetOrCreateHistogram("baz", nil)
+	})
+	expectPanic(t, "unsorted bounds", func() {
+		mps.GetOrCreateHistogram("baz", []float64{2, 1})
+	})
+}
+
+func TestMultiProcessInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, multiProcessFilePrefix+strconv.Itoa(os.Getpid())+"_1"+multiProcessFileSuffix)
+	if err := os.WriteFile(path, []byte("foobar"), 0644); err != nil {
+		t.Fatalf("cannot write %q: %s", path, err)
+	}
+	var bb bytes.Buffer
+	if err := WriteMultiProcessMetrics(&bb, dir, nil); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func mustCloseMultiProcessSet(t *testing.T, mps *MultiProcessSet) {
+	t.Helper()
+	if err := mps.Close(); err != nil {
+		t.Fatalf("cannot close MultiProcessSet: %s", err)
+	}
+}
+
+// getDeadPIDForTest returns the pid of the finished child process.
+func getDeadPIDForTest(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("cannot run child process: %s", err)
+	}
+	return cmd.Process.Pid
+}
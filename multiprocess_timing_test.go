@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package metrics
+
+import (
+	"testing"
+)
+
+func BenchmarkMultiProcessCounterInc(b *testing.B) {
+	mps, err := newMultiProcessSet(b.TempDir(), 1)
+	if err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+	defer func() {
+		_ = mps.Close()
+	}()
+	c := mps.GetOrCreateCounter("BenchmarkMultiProcessCounterInc")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc()
+		}
+	})
+}
+
+func BenchmarkCounterIncForMultiProcessComparison(b *testing.B) {
+	var c Counter
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc()
+		}
+	})
+}
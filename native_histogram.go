@@ -0,0 +1,307 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NativeHistogram is a histogram with exponential buckets, which is exposed as Prometheus native histogram.
+//
+// See https://prometheus.io/docs/concepts/metric_types/#histogram
+//
+// Bucket bounds are defined by the schema, which is selected from the growth factor passed to NewNativeHistogram.
+// Bucket with index i covers (base^(i-1), base^i] range for positive values and the corresponding range for negative values,
+// where base = 2^(2^-schema). Values with absolute value smaller or equal to the zero threshold go to the zero bucket.
+//
+// The native histogram representation is exposed only in Prometheus protobuf exposition format. See WriteProtobuf.
+// Text exposition formats contain classic cumulative buckets with `le` labels for all the non-empty buckets.
+//
+// Zero NativeHistogram isn't usable. Use NewNativeHistogram for creating it.
+type NativeHistogram struct {
+	mu sync.Mutex
+
+	schema        int32
+	zeroThreshold float64
+
+	zeroCount uint64
+	positive  map[int32]uint64
+	negative  map[int32]uint64
+
+	count uint64
+	sum   float64
+
+	statsd statsdMirror
+}
+
+// Bounds for the schema of native histograms supported by Prometheus.
+const (
+	nativeHistogramMinSchema = -4
+	nativeHistogramMaxSchema = 8
+)
+
+// nativeHistogramZeroThreshold is the default zero threshold used by Prometheus client libraries.
+const nativeHistogramZeroThreshold = 2.938735877055719e-39
+
+// NewNativeHistogram creates and returns new NativeHistogram with the given name and growthFactor.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// growthFactor is the maximum ratio between upper and lower bounds of every bucket. It must be bigger than 1.
+// The schema with the biggest bucket growth, which doesn't exceed growthFactor, is selected.
+// For example, growthFactor=1.1 results in schema 3 with bucket growth 2^(1/8)=1.0905.
+//
+// The returned histogram is safe to use from concurrent goroutines.
+func NewNativeHistogram(name string, growthFactor float64) *NativeHistogram {
+	return defaultSet.NewNativeHistogram(name, growthFactor)
+}
+
+func newNativeHistogram(growthFactor float64) *NativeHistogram {
+	if !(growthFactor > 1) || math.IsInf(growthFactor, 1) {
+		panic(fmt.Errorf("BUG: growthFactor must be bigger than 1; got %g", growthFactor))
+	}
+	return &NativeHistogram{
+		schema:        getNativeHistogramSchema(growthFactor),
+		zeroThreshold: nativeHistogramZeroThreshold,
+		positive:      make(map[int32]uint64),
+		negative:      make(map[int32]uint64),
+	}
+}
+
+// getNativeHistogramSchema returns the schema with the biggest bucket growth, which doesn't exceed growthFactor.
+func getNativeHistogramSchema(growthFactor float64) int32 {
+	for schema := int32(nativeHistogramMinSchema); schema < nativeHistogramMaxSchema; schema++ {
+		if getNativeHistogramBase(schema) <= growthFactor {
+			return schema
+		}
+	}
+	return nativeHistogramMaxSchema
+}
+
+func getNativeHistogramBase(schema int32) float64 {
+	return math.Pow(2, math.Pow(2, float64(-schema)))
+}
+
+// Update updates nh with v.
+//
+// Negative values are counted in negative buckets. NaNs are ignored.
+func (nh *NativeHistogram) Update(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	nh.mu.Lock()
+	nh.count++
+	nh.sum += v
+	absV := math.Abs(v)
+	if absV <= nh.zeroThreshold {
+		nh.zeroCount++
+	} else {
+		key := nh.getBucketKey(absV)
+		if v > 0 {
+			nh.positive[key]++
+		} else {
+			nh.negative[key]++
+		}
+	}
+	nh.mu.Unlock()
+	if ss := nh.statsd.load(); ss != nil {
+		ss.sendHistogram(v)
+	}
+}
+
+// UpdateDuration updates request duration based on the given startTime.
+func (nh *NativeHistogram) UpdateDuration(startTime time.Time) {
+	d := time.Since(startTime).Seconds()
+	nh.Update(d)
+}
+
+// getBucketKey returns the index of the bucket for the given positive v.
+func (nh *NativeHistogram) getBucketKey(v float64) int32 {
+	if math.IsInf(v, 1) {
+		// Put +Inf into the bucket for the maximum float64 value.
+		v = math.MaxFloat64
+	}
+	frac, exp := math.Frexp(v)
+	if nh.schema <= 0 {
+		// Buckets cover whole powers of 2, so the key may be calculated exactly from the exponent.
+		// Powers of 2 go to the lower bucket, since upper bounds are inclusive.
+		key := int32(exp)
+		if frac == 0.5 {
+			key--
+		}
+		offset := (int32(1) << uint(-nh.schema)) - 1
+		return (key + offset) >> uint(-nh.schema)
+	}
+	key := int32(math.Ceil(math.Log2(v) * float64(int32(1)<<uint(nh.schema))))
+	if frac == 0.5 {
+		// Exact power of 2 - avoid rounding errors for the bucket upper bound.
+		key = int32(exp-1) << uint(nh.schema)
+	}
+	return key
+}
+
+// getBucketUpperBound returns the upper bound for the bucket with the given key.
+func (nh *NativeHistogram) getBucketUpperBound(key int32) float64 {
+	return math.Pow(getNativeHistogramBase(nh.schema), float64(key))
+}
+
+type nativeHistogramSnapshot struct {
+	schema        int32
+	zeroThreshold float64
+	zeroCount     uint64
+	count         uint64
+	sum           float64
+
+	// positiveKeys and negativeKeys contain sorted keys for non-empty buckets.
+	positiveKeys   []int32
+	positiveCounts []uint64
+	negativeKeys   []int32
+	negativeCounts []uint64
+}
+
+func (nh *NativeHistogram) getSnapshot() *nativeHistogramSnapshot {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	snap := &nativeHistogramSnapshot{
+		schema:        nh.schema,
+		zeroThreshold: nh.zeroThreshold,
+		zeroCount:     nh.zeroCount,
+		count:         nh.count,
+		sum:           nh.sum,
+	}
+	snap.positiveKeys, snap.positiveCounts = getSortedNativeBuckets(nh.positive)
+	snap.negativeKeys, snap.negativeCounts = getSortedNativeBuckets(nh.negative)
+	return snap
+}
+
+func getSortedNativeBuckets(m map[int32]uint64) ([]int32, []uint64) {
+	keys := make([]int32, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	counts := make([]uint64, len(keys))
+	for i, key := range keys {
+		counts[i] = m[key]
+	}
+	return keys, counts
+}
+
+// marshalTo marshals nh as classic cumulative buckets with `le` labels for the non-empty buckets.
+// MarshalTo writes nh with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (nh *NativeHistogram) MarshalTo(prefix string, w io.Writer) {
+	nh.marshalTo(prefix, w)
+}
+
+func (nh *NativeHistogram) marshalTo(prefix string, w io.Writer) {
+	snap := nh.getSnapshot()
+	if snap.count == 0 {
+		return
+	}
+	countTotal := uint64(0)
+	writeBucket := func(le string) {
+		writeBucketSample(w, prefix, fmt.Sprintf("le=%q", le), countTotal)
+	}
+	// Negative buckets in ascending order of their upper bounds.
+	for i := len(snap.negativeKeys) - 1; i >= 0; i-- {
+		countTotal += snap.negativeCounts[i]
+		upperBound := -nh.getBucketUpperBound(snap.negativeKeys[i] - 1)
+		writeBucket(strconv.FormatFloat(upperBound, 'g', -1, 64))
+	}
+	if snap.zeroCount > 0 {
+		countTotal += snap.zeroCount
+		writeBucket(strconv.FormatFloat(snap.zeroThreshold, 'g', -1, 64))
+	}
+	for i, key := range snap.positiveKeys {
+		countTotal += snap.positiveCounts[i]
+		upperBound := nh.getBucketUpperBound(key)
+		if math.IsInf(upperBound, 1) {
+			continue
+		}
+		writeBucket(strconv.FormatFloat(upperBound, 'g', -1, 64))
+	}
+	writeBucket("+Inf")
+
+	writeSumAndCount(w, prefix, snap.sum, snap.count, nil)
+}
+
+func (nh *NativeHistogram) metricType() string {
+	return "histogram"
+}
+
+func (nh *NativeHistogram) getStatsDMirror(_ bool) *statsdMirror {
+	return &nh.statsd
+}
+
+func (nh *NativeHistogram) marshalProtobuf(dst []byte) []byte {
+	snap := nh.getSnapshot()
+
+	// Metric.histogram
+	return appendProtobufMessage(dst, 7, func(dst []byte) []byte {
+		dst = appendProtobufVarint(dst, 1, snap.count)
+		dst = appendProtobufDouble(dst, 2, snap.sum)
+		dst = appendProtobufSint(dst, 5, int64(snap.schema))
+		dst = appendProtobufDouble(dst, 6, snap.zeroThreshold)
+		dst = appendProtobufVarint(dst, 7, snap.zeroCount)
+		dst = appendNativeHistogramBuckets(dst, 8, 9, snap.negativeKeys, snap.negativeCounts)
+		if snap.count == 0 {
+			// Empty native histogram must contain a no-op span in order to be distinguished from classic histogram.
+			dst = appendProtobufMessage(dst, 11, func(dst []byte) []byte {
+				dst = appendProtobufSint(dst, 1, 0)
+				return appendProtobufVarint(dst, 2, 0)
+			})
+			return dst
+		}
+		return appendNativeHistogramBuckets(dst, 11, 12, snap.positiveKeys, snap.positiveCounts)
+	})
+}
+
+// appendNativeHistogramBuckets appends BucketSpan messages for spanField and packed count deltas for deltaField to dst.
+func appendNativeHistogramBuckets(dst []byte, spanField, deltaField int, keys []int32, counts []uint64) []byte {
+	if len(keys) == 0 {
+		return dst
+	}
+	// Every span contains consecutive buckets. The offset for the first span is the key of its first bucket,
+	// while the offset for the remaining spans is the gap since the end of the previous span.
+	spanStart := 0
+	prevEnd := int32(0)
+	for i := 1; i <= len(keys); i++ {
+		if i < len(keys) && keys[i] == keys[i-1]+1 {
+			continue
+		}
+		offset := keys[spanStart]
+		if spanStart > 0 {
+			offset -= prevEnd
+		}
+		length := i - spanStart
+		dst = appendProtobufMessage(dst, spanField, func(dst []byte) []byte {
+			dst = appendProtobufSint(dst, 1, int64(offset))
+			return appendProtobufVarint(dst, 2, uint64(length))
+		})
+		prevEnd = keys[i-1] + 1
+		spanStart = i
+	}
+	dst = appendProtobufTag(dst, deltaField, protobufWireBytes)
+	return appendProtobufDelimited(dst, func(dst []byte) []byte {
+		prevCount := int64(0)
+		for _, count := range counts {
+			dst = marshalVarUint64(dst, zigzagEncode(int64(count)-prevCount))
+			prevCount = int64(count)
+		}
+		return dst
+	})
+}
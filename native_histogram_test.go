@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGetNativeHistogramSchema(t *testing.T) {
+	f := func(growthFactor float64, schemaExpected int32) {
+		t.Helper()
+		if schema := getNativeHistogramSchema(growthFactor); schema != schemaExpected {
+			t.Fatalf("unexpected schema for growthFactor=%g; got %d; want %d", growthFactor, schema, schemaExpected)
+		}
+	}
+	f(1e6, -4)
+	f(65536, -4)
+	f(256, -3)
+	f(4, -1)
+	f(3, 0)
+	f(2, 0)
+	f(1.5, 1)
+	f(1.1, 3)
+	f(1.0001, 8)
+}
+
+func TestNativeHistogramGetBucketKey(t *testing.T) {
+	f := func(growthFactor, v float64, keyExpected int32) {
+		t.Helper()
+		nh := newNativeHistogram(growthFactor)
+		if key := nh.getBucketKey(v); key != keyExpected {
+			t.Fatalf("unexpected bucket key for v=%g, schema=%d; got %d; want %d", v, nh.schema, key, keyExpected)
+		}
+		// Verify the bucket bounds.
+		upperBound := nh.getBucketUpperBound(keyExpected)
+		lowerBound := nh.getBucketUpperBound(keyExpected - 1)
+		if v > upperBound*(1+1e-12) || v <= lowerBound*(1-1e-12) {
+			t.Fatalf("v=%g is out of bucket bounds (%g, %g]", v, lowerBound, upperBound)
+		}
+	}
+
+	// schema 0
+	f(2, 1, 0)
+	f(2, 1.5, 1)
+	f(2, 2, 1)
+	f(2, 2.1, 2)
+	f(2, 0.5, -1)
+	f(2, 0.3, -1)
+	f(2, 1024, 10)
+
+	// schema -1
+	f(4, 1, 0)
+	f(4, 2, 1)
+	f(4, 4, 1)
+	f(4, 5, 2)
+	f(4, 0.25, -1)
+
+	// schema 3
+	f(1.1, 1, 0)
+	f(1.1, 2, 8)
+	f(1.1, 1.05, 1)
+	f(1.1, 1.1, 2)
+	f(1.1, 0.5, -8)
+	f(1.1, 1024, 80)
+}
+
+func TestNativeHistogramInvalidGrowthFactor(t *testing.T) {
+	f := func(growthFactor float64) {
+		t.Helper()
+		expectPanic(t, "NewNativeHistogram", func() {
+			s := NewSet()
+			s.NewNativeHistogram("foo", growthFactor)
+		})
+	}
+	f(1)
+	f(0.5)
+	f(-2)
+	f(math.NaN())
+	f(math.Inf(1))
+}
+
+func TestNativeHistogramMarshalTo(t *testing.T) {
+	s := NewSet()
+	nh := s.NewNativeHistogram(`foo{bar="baz"}`, 2)
+
+	// Empty histogram isn't exposed in text format.
+	testMarshalTo(t, nh, "prefix", "")
+
+	nh.Update(math.NaN())
+	nh.Update(0)
+	nh.Update(1)
+	nh.Update(1.5)
+	nh.Update(2)
+	nh.Update(-3)
+	nh.Update(100)
+	testMarshalTo(t, nh, `prefix{bar="baz"}`, `prefix_bucket{bar="baz",le="-2"} 1
+prefix_bucket{bar="baz",le="2.938735877055719e-39"} 2
+prefix_bucket{bar="baz",le="1"} 3
+prefix_bucket{bar="baz",le="2"} 5
+prefix_bucket{bar="baz",le="128"} 6
+prefix_bucket{bar="baz",le="+Inf"} 6
+prefix_sum{bar="baz"} 101.5
+prefix_count{bar="baz"} 6
+`)
+}
+
+func TestNativeHistogramSnapshot(t *testing.T) {
+	s := NewSet()
+	nh := s.NewNativeHistogram("foo", 2)
+	for _, v := range []float64{0, 1e-40, -1e-40, 1, 3, 4, 16, -0.5, -0.6} {
+		nh.Update(v)
+	}
+	snap := nh.getSnapshot()
+	if snap.schema != 0 {
+		t.Fatalf("unexpected schema; got %d; want 0", snap.schema)
+	}
+	if snap.zeroCount != 3 {
+		t.Fatalf("unexpected zero count; got %d; want 3", snap.zeroCount)
+	}
+	if snap.count != 9 {
+		t.Fatalf("unexpected count; got %d; want 9", snap.count)
+	}
+	f := func(keys []int32, counts []uint64, keysExpected []int32, countsExpected []uint64) {
+		t.Helper()
+		if len(keys) != len(keysExpected) || len(counts) != len(countsExpected) {
+			t.Fatalf("unexpected buckets; got keys=%v, counts=%v; want keys=%v, counts=%v", keys, counts, keysExpected, countsExpected)
+		}
+		for i := range keys {
+			if keys[i] != keysExpected[i] || counts[i] != countsExpected[i] {
+				t.Fatalf("unexpected buckets; got keys=%v, counts=%v; want keys=%v, counts=%v", keys, counts, keysExpected, countsExpected)
+			}
+		}
+	}
+	f(snap.positiveKeys, snap.positiveCounts, []int32{0, 2, 4}, []uint64{1, 2, 1})
+	f(snap.negativeKeys, snap.negativeCounts, []int32{-1, 0}, []uint64{1, 1})
+}
+
+func TestNativeHistogramMetricType(t *testing.T) {
+	s := NewSet()
+	nh := s.NewNativeHistogram("foo", 1.5)
+	if nh.metricType() != "histogram" {
+		t.Fatalf("unexpected metric type; got %q; want %q", nh.metricType(), "histogram")
+	}
+	expectPanic(t, "NewNativeHistogram_duplicate", func() {
+		s.NewNativeHistogram("foo", 1.5)
+	})
+}
@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteOpenMetrics writes all the metrics from the default set and all the added sets to w in OpenMetrics text format.
+//
+// See https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
+//
+// The output is always terminated with `# EOF` line, even if there are no registered metrics.
+//
+// If exposeProcessMetrics is true, then various `go_*` and `process_*` metrics
+// are exposed for the current process with OpenMetrics metadata.
+//
+// Every metric family is written as a single contiguous group across all the sets as required by OpenMetrics.
+// Families are sorted across the sets if sorting is enabled via SetSortMetricsOnWrite.
+// Otherwise families go in the order of the sets containing them.
+//
+// See also Handler, which selects between Prometheus text format and OpenMetrics text format
+// depending on the Accept request header.
+func WriteOpenMetrics(w io.Writer, exposeProcessMetrics bool) {
+	sets := getRegisteredSets()
+	runPreWriteHooks(sets)
+	var sa []*namedMetric
+	var metricsWriters []*MetricsWriter
+	if isSortMetricsOnWriteEnabled() {
+		sa, metricsWriters = getMergedSortedMetrics(sets)
+	} else {
+		sa, metricsWriters = getFamilyGroupedMetrics(sets)
+	}
+	writeOpenMetricsMetrics(w, sa, metricsWriters)
+	if exposeProcessMetrics {
+		writeOpenMetricsProcessMetrics(w)
+	}
+	io.WriteString(w, "# EOF\n")
+}
+
+// writeOpenMetricsProcessMetrics writes the metrics exposed by WriteProcessMetrics to w in OpenMetrics text format.
+func writeOpenMetricsProcessMetrics(w io.Writer) {
+	pw := &openMetricsProcessWriter{}
+	writeGoMetrics(pw)
+	writeGoInfoMetrics(pw, "")
+	writeProcessMetrics(pw)
+	w.Write(appendOpenMetricsFromPrometheus(nil, pw.Bytes()))
+
+	pushMetricsSet.writeOpenMetrics(w)
+}
+
+// openMetricsProcessWriter collects process metrics in Prometheus text exposition format for conversion to OpenMetrics format.
+//
+// WriteMetadataIfNeeded always writes metadata to openMetricsProcessWriter, since OpenMetrics requires metadata
+// for every metric type except of unknown.
+type openMetricsProcessWriter struct {
+	bytes.Buffer
+}
+
+// appendOpenMetricsFromPrometheus appends src in Prometheus text exposition format written by WriteProcessMetrics to dst
+// in OpenMetrics text format.
+//
+// `# TYPE` lines are converted to OpenMetrics metadata, while `_total` suffix is added to counter samples without it.
+// Samples without `# TYPE` lines are written as is, so they are exposed with unknown type.
+func appendOpenMetricsFromPrometheus(dst, src []byte) []byte {
+	bb := bytes.NewBuffer(dst)
+	metricFamily := ""
+	metricType := ""
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		if bytes.HasPrefix(line, []byte("# HELP ")) {
+			// WriteMetadataIfNeeded writes empty HELP lines, so they are skipped.
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("# TYPE ")) {
+			fields := strings.Fields(string(line[len("# TYPE "):]))
+			if len(fields) != 2 {
+				continue
+			}
+			metricFamily, metricType = fields[0], fields[1]
+			writeOpenMetricsMetadata(bb, metricFamily, metricType, "", "")
+			continue
+		}
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		n = bytes.IndexAny(line, "{ ")
+		if n < 0 {
+			continue
+		}
+		name := string(line[:n])
+		if !strings.HasPrefix(name, metricFamily) {
+			// The sample doesn't belong to the family from the last `# TYPE` line.
+			metricFamily = ""
+			metricType = ""
+		}
+		bb.Write(line[:n])
+		if metricType == "counter" && !isCounterName(name) {
+			// OpenMetrics requires `_total` suffix for counter samples.
+			bb.WriteString("_total")
+		}
+		bb.Write(line[n:])
+		bb.WriteByte('\n')
+	}
+	return bb.Bytes()
+}
+
+// WriteOpenMetrics writes all the metrics from s to w in OpenMetrics text format.
+//
+// The output is always terminated with `# EOF` line, even if s contains no metrics.
+func (s *Set) WriteOpenMetrics(w io.Writer) {
+	s.runPreWriteHooks()
+	s.writeOpenMetrics(w)
+	io.WriteString(w, "# EOF\n")
+}
+
+func (s *Set) writeOpenMetrics(w io.Writer) {
+	sa, metricsWriters := s.getSortedMetrics()
+	writeOpenMetricsMetrics(w, sa, metricsWriters)
+}
+
+// writeOpenMetricsMetrics writes metrics from sa sorted by names and metricsWriters output to w in OpenMetrics text format.
+func writeOpenMetricsMetrics(w io.Writer, sa []*namedMetric, metricsWriters []*MetricsWriter) {
+	// Collect all the metrics in in-memory buffer in order to avoid small writes to w.
+	var bb bytes.Buffer
+	exposeCreated := isCreatedSeriesEnabled()
+	prevMetricFamily := ""
+	metricType := ""
+	for i, nm := range sa {
+		metricFamily := getMetricFamily(nm.name)
+		if metricFamily != prevMetricFamily {
+			// write meta info only once per metric family
+			var help string
+			metricType, help = getMetricFamilyMetadata(sa[i:], metricFamily)
+			unit := getMetricFamilyUnit(sa[i:], metricFamily)
+			writeOpenMetricsMetadata(&bb, metricFamily, metricType, help, unit)
+			prevMetricFamily = metricFamily
+		}
+		if needsQuoting(metricFamily) {
+			bbTmp := getBytesBuffer()
+			marshalOpenMetrics(bbTmp, nm, metricType)
+			if exposeCreated {
+				bbTmp.B = appendCreatedSeries(bbTmp.B, nm)
+			}
+			bb.Write(quoteMetricNames(nil, bbTmp.B, metricFamily))
+			putBytesBuffer(bbTmp)
+			continue
+		}
+		marshalOpenMetrics(&bb, nm, metricType)
+		if exposeCreated {
+			bbTmp := getBytesBuffer()
+			bbTmp.B = appendCreatedSeries(bbTmp.B[:0], nm)
+			bb.Write(bbTmp.B)
+			putBytesBuffer(bbTmp)
+		}
+	}
+	w.Write(bb.Bytes())
+
+	for _, mw := range metricsWriters {
+		mw.write(w)
+	}
+}
+
+// openMetricsMarshaler must be implemented by metrics, which have distinct representation in OpenMetrics format.
+type openMetricsMarshaler interface {
+	marshalToOpenMetrics(prefix string, w io.Writer)
+}
+
+func marshalOpenMetrics(w io.Writer, nm *namedMetric, metricType string) {
+	if om, ok := nm.metric.(openMetricsMarshaler); ok {
+		om.marshalToOpenMetrics(nm.name, w)
+		return
+	}
+	name := nm.name
+	if metricType == "counter" {
+		// OpenMetrics requires `_total` suffix for counter samples.
+		metricName, labels := splitMetricName(name)
+		if !isCounterName(metricName) {
+			name = metricName + "_total" + labels
+		}
+	}
+	if sm, ok := nm.metric.(openMetricsSampleMarshaler); ok {
+		sm.marshalToOpenMetricsSample(name, w)
+		return
+	}
+	nm.metric.marshalTo(name, w)
+}
+
+// openMetricsSampleMarshaler must be implemented by single-sample metrics, which may expose timestamps
+// or exemplars in OpenMetrics format.
+//
+// name is the sample name with the suffixes required by OpenMetrics.
+type openMetricsSampleMarshaler interface {
+	marshalToOpenMetricsSample(name string, w io.Writer)
+}
+
+func writeOpenMetricsMetadata(w io.Writer, metricFamily, metricType, help, unit string) {
+	switch metricType {
+	case "counter":
+		// OpenMetrics counter family names must not contain `_total` suffix.
+		metricFamily = strings.TrimSuffix(metricFamily, "_total")
+	case "gauge", "histogram", "summary":
+	default:
+		metricType = "unknown"
+	}
+	// OpenMetrics requires metric family names with units to end with the unit.
+	hasUnit := unit != "" && strings.HasSuffix(metricFamily, "_"+unit)
+	metricFamily = formatMetricFamily(metricFamily)
+	fmt.Fprintf(w, "# TYPE %s %s\n", metricFamily, metricType)
+	if hasUnit {
+		fmt.Fprintf(w, "# UNIT %s %s\n", metricFamily, unit)
+	}
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", metricFamily, openMetricsHelpReplacer.Replace(help))
+	}
+}
+
+var openMetricsHelpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
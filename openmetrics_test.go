@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSetWriteOpenMetrics(t *testing.T) {
+	f := func(s *Set, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WriteOpenMetrics(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// Empty set
+	f(NewSet(), "# EOF\n")
+
+	// Counters
+	s := NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: `requests{path="/foo"}`,
+		Help: `Requests with "quoted" help`,
+	}).Inc()
+	s.NewCounter(`requests{path="/bar"}`).Add(2)
+	s.NewFloatCounter("cpu_seconds_total").Add(1.5)
+	f(s, `# TYPE cpu_seconds counter
+cpu_seconds_total 1.5
+# TYPE requests counter
+# HELP requests Requests with \"quoted\" help
+requests_total{path="/bar"} 2
+requests_total{path="/foo"} 1
+# EOF
+`)
+
+	// Gauges
+	s = NewSet()
+	s.NewGauge(`queue_size{queue="a"}`, func() float64 { return 1.5 })
+	s.NewGauge(`queue_size{queue="b"}`, nil).Set(2)
+	f(s, `# TYPE queue_size gauge
+queue_size{queue="a"} 1.5
+queue_size{queue="b"} 2
+# EOF
+`)
+
+	// Histogram
+	s = NewSet()
+	h := s.NewHistogram(`request_duration_seconds{path="/foo"}`)
+	h.Update(0.5)
+	h.Update(1)
+	h.Update(2)
+	h.Update(1e20)
+	f(s, `# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{path="/foo",le="5.275e-01"} 1
+request_duration_seconds_bucket{path="/foo",le="1.000e+00"} 2
+request_duration_seconds_bucket{path="/foo",le="2.154e+00"} 3
+request_duration_seconds_bucket{path="/foo",le="+Inf"} 4
+request_duration_seconds_sum{path="/foo"} 1e+20
+request_duration_seconds_count{path="/foo"} 4
+# EOF
+`)
+
+	// Empty histogram
+	s = NewSet()
+	s.NewHistogram("empty_histogram")
+	f(s, `# TYPE empty_histogram histogram
+# EOF
+`)
+
+	// Summary
+	s = NewSet()
+	sm := s.NewSummaryExt(`response_size_bytes{path="/foo"}`, defaultSummaryWindow, []float64{0.5, 1})
+	sm.Update(1)
+	sm.Update(3)
+	f(s, `# TYPE response_size_bytes summary
+response_size_bytes{path="/foo",quantile="0.5"} 3
+response_size_bytes{path="/foo",quantile="1"} 3
+response_size_bytes_sum{path="/foo"} 4
+response_size_bytes_count{path="/foo"} 2
+# EOF
+`)
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	s := NewSet()
+	s.NewCounter("open_metrics_test_counter").Inc()
+	RegisterSet(s)
+	defer UnregisterSet(s)
+
+	var bb bytes.Buffer
+	WriteOpenMetrics(&bb, true)
+	result := bb.String()
+	if !strings.Contains(result, "\nopen_metrics_test_counter_total 1\n") {
+		t.Fatalf("missing counter in the output:\n%s", result)
+	}
+	if !strings.Contains(result, "\nprocess_") {
+		t.Fatalf("missing process metrics in the output:\n%s", result)
+	}
+	if !strings.HasSuffix(result, "\n# EOF\n") {
+		t.Fatalf("missing # EOF at the end of the output:\n%s", result)
+	}
+	if n := strings.Count(result, "# EOF"); n != 1 {
+		t.Fatalf("unexpected number of # EOF lines; got %d; want 1", n)
+	}
+}
+
+func TestWriteOpenMetricsContiguousFamilies(t *testing.T) {
+	s1 := NewSet()
+	s1.NewCounter(`om_grouped_total{a="1"}`).Inc()
+	s1.NewCounter("om_other_total").Inc()
+	s2 := NewSet()
+	s2.NewCounter(`om_grouped_total{a="2"}`).Inc()
+	s2.NewCounter(`om_grouped_total{a="1"}`).Add(2)
+	RegisterSet(s1)
+	RegisterSet(s2)
+	defer UnregisterSet(s1)
+	defer UnregisterSet(s2)
+
+	f := func(sort bool) {
+		t.Helper()
+		SetSortMetricsOnWrite(sort)
+		defer SetSortMetricsOnWrite(false)
+
+		var bb bytes.Buffer
+		WriteOpenMetrics(&bb, false)
+		result := bb.String()
+		resultExpected := `# TYPE om_grouped counter
+om_grouped_total{a="1"} 1
+om_grouped_total{a="2"} 1
+# TYPE om_other counter
+om_other_total 1
+`
+		if !strings.Contains(result, resultExpected) {
+			t.Fatalf("missing contiguous metric families in the output;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+		if n := strings.Count(result, "# TYPE om_grouped counter"); n != 1 {
+			t.Fatalf("unexpected number of metadata lines for om_grouped; got %d; want 1", n)
+		}
+		if !strings.HasSuffix(result, "\n# EOF\n") {
+			t.Fatalf("missing # EOF at the end of the output:\n%s", result)
+		}
+	}
+	f(false)
+	f(true)
+}
+
+func TestWriteOpenMetricsProcessMetrics(t *testing.T) {
+	f := func(exposeMetadata bool) {
+		t.Helper()
+		ExposeMetadata(exposeMetadata)
+		defer ExposeMetadata(false)
+
+		var bb bytes.Buffer
+		WriteOpenMetrics(&bb, true)
+		result := bb.String()
+		for _, s := range []string{
+			"\n# TYPE go_memstats_alloc_bytes_total counter\n",
+			"\n# HELP go_memstats_alloc_bytes_total\n",
+			"\ngo_cgo_calls_count ",
+		} {
+			if strings.Contains(result, s) {
+				t.Fatalf("unexpected %q in the output:\n%s", s, result)
+			}
+		}
+		for _, s := range []string{
+			"\n# TYPE go_memstats_alloc_bytes gauge\ngo_memstats_alloc_bytes ",
+			"\n# TYPE go_memstats_alloc_bytes counter\ngo_memstats_alloc_bytes_total ",
+			"\n# TYPE go_cgo_calls_count counter\ngo_cgo_calls_count_total ",
+			"\n# TYPE go_gc_duration_seconds summary\ngo_gc_duration_seconds{quantile=\"0\"} ",
+			"\n# TYPE go_info gauge\ngo_info{",
+		} {
+			if !strings.Contains(result, s) {
+				t.Fatalf("missing %q in the output:\n%s", s, result)
+			}
+		}
+	}
+	f(false)
+	f(true)
+}
+
+func TestAppendOpenMetricsFromPrometheus(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+		result := appendOpenMetricsFromPrometheus(nil, []byte(s))
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f("", "")
+
+	// Samples without metadata have unknown type
+	f("foo 1\nbar{a=\"b\"} 2\n", "foo 1\nbar{a=\"b\"} 2\n")
+
+	// Counters
+	f(`# HELP process_cpu_seconds_total
+# TYPE process_cpu_seconds_total counter
+process_cpu_seconds_total 1.5
+# HELP go_cgo_calls_count
+# TYPE go_cgo_calls_count counter
+go_cgo_calls_count 3
+`, `# TYPE process_cpu_seconds counter
+process_cpu_seconds_total 1.5
+# TYPE go_cgo_calls_count counter
+go_cgo_calls_count_total 3
+`)
+
+	// Histograms and gauges
+	f(`# TYPE foo_seconds histogram
+foo_seconds_bucket{le="1"} 1
+foo_seconds_bucket{le="+Inf"} 2
+foo_seconds_sum 3
+foo_seconds_count 2
+# TYPE bar gauge
+bar 4
+baz 5`, `# TYPE foo_seconds histogram
+foo_seconds_bucket{le="1"} 1
+foo_seconds_bucket{le="+Inf"} 2
+foo_seconds_sum 3
+foo_seconds_count 2
+# TYPE bar gauge
+bar 4
+baz 5
+`)
+}
@@ -0,0 +1,320 @@
+// Package otelbridge allows exporting metrics from github.com/VictoriaMetrics/metrics package via OpenTelemetry SDK.
+//
+// The package is located in a separate module, so github.com/VictoriaMetrics/metrics users
+// do not depend on OpenTelemetry SDK unless they need this package.
+//
+// Usage:
+//
+//	exporter, err := otlpmetrichttp.New(ctx)
+//	...
+//	reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithProducer(otelbridge.NewProducer()))
+//	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+package otelbridge
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// ScopeName is the name of instrumentation scope for metrics returned by Producer.
+const ScopeName = "github.com/VictoriaMetrics/metrics"
+
+// Producer exposes metrics registered in github.com/VictoriaMetrics/metrics sets to OpenTelemetry readers.
+//
+// Producer implements go.opentelemetry.io/otel/sdk/metric.Producer interface,
+// so it can be passed to readers via go.opentelemetry.io/otel/sdk/metric.WithProducer option.
+//
+// Metrics are converted in the following way:
+//
+//   - Counter and FloatCounter are exported as cumulative monotonic Sum.
+//   - Gauge is exported as Gauge.
+//   - Histogram is exported as cumulative Histogram with explicit bounds
+//     obtained from the upper bounds of non-empty vmrange buckets.
+//
+// Labels in metric names such as `foo{bar="baz"}` are exported as OpenTelemetry attributes.
+//
+// Summary and PrometheusHistogram metrics, as well as metrics written by the functions
+// passed to Set.RegisterMetricsWriter, aren't exported.
+type Producer struct {
+	sets      []*metrics.Set
+	startTime time.Time
+}
+
+// NewProducer returns Producer for metrics registered in the given sets.
+//
+// Metrics from the default set are exported if no sets are passed.
+func NewProducer(sets ...*metrics.Set) *Producer {
+	if len(sets) == 0 {
+		sets = []*metrics.Set{metrics.GetDefaultSet()}
+	}
+	return &Producer{
+		sets:      append([]*metrics.Set{}, sets...),
+		startTime: time.Now(),
+	}
+}
+
+// Produce returns metrics from the sets passed to NewProducer.
+//
+// It is safe calling Produce from concurrently running goroutines.
+func (p *Producer) Produce(ctx context.Context) ([]metricdata.ScopeMetrics, error) {
+	now := time.Now()
+	var mb metricsBuilder
+	var firstErr error
+	for _, s := range p.sets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, name := range s.ListMetricNames() {
+			m, ok := s.GetMetric(name)
+			if !ok {
+				// The metric has been unregistered concurrently.
+				continue
+			}
+			family, attrs, err := parseMetricName(name)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			mb.addMetric(family, attrs, m, p.startTime, now)
+		}
+	}
+	if len(mb.metrics) == 0 {
+		return nil, firstErr
+	}
+	mb.finalize()
+	sm := metricdata.ScopeMetrics{
+		Scope: instrumentation.Scope{
+			Name: ScopeName,
+		},
+		Metrics: mb.metrics,
+	}
+	return []metricdata.ScopeMetrics{sm}, firstErr
+}
+
+type metricKind int
+
+const (
+	kindCounter metricKind = iota
+	kindFloatCounter
+	kindGauge
+	kindHistogram
+)
+
+type familyKey struct {
+	name string
+	kind metricKind
+}
+
+// metricsBuilder groups data points by metric family name.
+type metricsBuilder struct {
+	metrics []metricdata.Metrics
+	idxs    map[familyKey]int
+}
+
+func (mb *metricsBuilder) addMetric(family string, attrs attribute.Set, m metrics.Metric, startTime, now time.Time) {
+	switch t := m.(type) {
+	case *metrics.Counter:
+		dp := metricdata.DataPoint[int64]{
+			Attributes: attrs,
+			StartTime:  startTime,
+			Time:       now,
+			Value:      int64(t.Get()),
+		}
+		data := mb.getData(family, kindCounter).(*metricdata.Sum[int64])
+		data.DataPoints = append(data.DataPoints, dp)
+	case *metrics.FloatCounter:
+		dp := metricdata.DataPoint[float64]{
+			Attributes: attrs,
+			StartTime:  startTime,
+			Time:       now,
+			Value:      t.Get(),
+		}
+		data := mb.getData(family, kindFloatCounter).(*metricdata.Sum[float64])
+		data.DataPoints = append(data.DataPoints, dp)
+	case *metrics.Gauge:
+		dp := metricdata.DataPoint[float64]{
+			Attributes: attrs,
+			Time:       now,
+			Value:      t.Get(),
+		}
+		data := mb.getData(family, kindGauge).(*metricdata.Gauge[float64])
+		data.DataPoints = append(data.DataPoints, dp)
+	case *metrics.Histogram:
+		dp := getHistogramDataPoint(t)
+		dp.Attributes = attrs
+		dp.StartTime = startTime
+		dp.Time = now
+		data := mb.getData(family, kindHistogram).(*metricdata.Histogram[float64])
+		data.DataPoints = append(data.DataPoints, dp)
+	}
+}
+
+// getData returns data for the given metric family and kind.
+//
+// The returned value is a pointer to the aggregation stored in mb.metrics. Call finalize after adding all the metrics.
+func (mb *metricsBuilder) getData(family string, kind metricKind) interface{} {
+	key := familyKey{
+		name: family,
+		kind: kind,
+	}
+	if idx, ok := mb.idxs[key]; ok {
+		return mb.metrics[idx].Data
+	}
+	if mb.idxs == nil {
+		mb.idxs = make(map[familyKey]int)
+	}
+	var data metricdata.Aggregation
+	switch kind {
+	case kindCounter:
+		data = &metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+		}
+	case kindFloatCounter:
+		data = &metricdata.Sum[float64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+		}
+	case kindGauge:
+		data = &metricdata.Gauge[float64]{}
+	case kindHistogram:
+		data = &metricdata.Histogram[float64]{
+			Temporality: metricdata.CumulativeTemporality,
+		}
+	default:
+		panic(fmt.Errorf("BUG: unexpected metric kind: %d", kind))
+	}
+	mb.idxs[key] = len(mb.metrics)
+	mb.metrics = append(mb.metrics, metricdata.Metrics{
+		Name: family,
+		Data: data,
+	})
+	return data
+}
+
+// finalize replaces pointers to aggregations with values, since OpenTelemetry exporters expect values.
+func (mb *metricsBuilder) finalize() {
+	for i := range mb.metrics {
+		m := &mb.metrics[i]
+		switch t := m.Data.(type) {
+		case *metricdata.Sum[int64]:
+			m.Data = *t
+		case *metricdata.Sum[float64]:
+			m.Data = *t
+		case *metricdata.Gauge[float64]:
+			m.Data = *t
+		case *metricdata.Histogram[float64]:
+			m.Data = *t
+		}
+	}
+}
+
+// getHistogramDataPoint converts h buckets to OpenTelemetry histogram data point with explicit bounds.
+//
+// Every non-empty vmrange bucket `<start>...<end>` is converted to the bucket with `<end>` upper bound.
+// Empty vmrange buckets between non-empty buckets are merged into the next non-empty bucket,
+// since they do not change bucket counts. This keeps the number of exported bounds small.
+// The last OpenTelemetry bucket with +Inf upper bound contains the number of values
+// exceeding the maximum supported histogram value.
+func getHistogramDataPoint(h *metrics.Histogram) metricdata.HistogramDataPoint[float64] {
+	// Take a consistent snapshot of h, since buckets and sum are read separately.
+	var snapshot metrics.Histogram
+	snapshot.Merge(h)
+
+	var dp metricdata.HistogramDataPoint[float64]
+	var infCount uint64
+	snapshot.VisitNonZeroBuckets(func(vmrange string, count uint64) {
+		dp.Count += count
+		upperBound := getVMRangeUpperBound(vmrange)
+		if math.IsInf(upperBound, 1) {
+			infCount += count
+			return
+		}
+		dp.Bounds = append(dp.Bounds, upperBound)
+		dp.BucketCounts = append(dp.BucketCounts, count)
+	})
+	dp.BucketCounts = append(dp.BucketCounts, infCount)
+	dp.Sum = snapshot.GetSum()
+	return dp
+}
+
+func getVMRangeUpperBound(vmrange string) float64 {
+	n := strings.Index(vmrange, "...")
+	if n < 0 {
+		panic(fmt.Errorf("BUG: missing `...` in vmrange=%q", vmrange))
+	}
+	upperBound, err := strconv.ParseFloat(vmrange[n+len("..."):], 64)
+	if err != nil {
+		panic(fmt.Errorf("BUG: cannot parse upper bound in vmrange=%q: %w", vmrange, err))
+	}
+	return upperBound
+}
+
+// parseMetricName splits the metric name such as `foo{bar="baz"}` into metric family name and attributes.
+func parseMetricName(name string) (string, attribute.Set, error) {
+	n := strings.IndexByte(name, '{')
+	if n < 0 {
+		return name, *attribute.EmptySet(), nil
+	}
+	family := name[:n]
+	tail := name[n+1:]
+	if !strings.HasSuffix(tail, "}") {
+		return "", attribute.Set{}, fmt.Errorf("missing closing curly brace in metric name %q", name)
+	}
+	tail = tail[:len(tail)-1]
+	var kvs []attribute.KeyValue
+	for len(tail) > 0 {
+		n := strings.Index(tail, `="`)
+		if n <= 0 {
+			return "", attribute.Set{}, fmt.Errorf("missing label name in metric name %q", name)
+		}
+		key := tail[:n]
+		value, rest, err := unquoteLabelValue(tail[n+1:])
+		if err != nil {
+			return "", attribute.Set{}, fmt.Errorf("cannot parse value for label %q in metric name %q: %w", key, name, err)
+		}
+		kvs = append(kvs, attribute.String(key, value))
+		tail = strings.TrimPrefix(rest, ",")
+	}
+	return family, attribute.NewSet(kvs...), nil
+}
+
+// unquoteLabelValue unquotes label value at the start of s and returns the remaining tail.
+func unquoteLabelValue(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, fmt.Errorf("missing opening quote")
+	}
+	s = s[1:]
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return sb.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", "", fmt.Errorf("unexpected end of escape sequence")
+			}
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("missing closing quote")
+}
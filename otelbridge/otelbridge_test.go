@@ -0,0 +1,319 @@
+package otelbridge
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProducerManualReader(t *testing.T) {
+	s := metrics.NewSet()
+	s.NewCounter(`requests_total{path="/foo"}`).Add(3)
+	s.NewCounter(`requests_total{path="/bar"}`).Add(5)
+	s.NewFloatCounter("bytes_total").Add(1.5)
+	s.NewGauge("temperature", func() float64 { return -2.5 })
+	s.NewHistogram(`duration_seconds{job="x"}`).Update(1)
+
+	rm := collectMetrics(t, s)
+	if len(rm.ScopeMetrics) != 1 {
+		t.Fatalf("unexpected number of scopes; got %d; want 1", len(rm.ScopeMetrics))
+	}
+	sm := rm.ScopeMetrics[0]
+	if sm.Scope.Name != ScopeName {
+		t.Fatalf("unexpected scope name; got %q; want %q", sm.Scope.Name, ScopeName)
+	}
+	ms := make(map[string]metricdata.Aggregation)
+	for _, m := range sm.Metrics {
+		ms[m.Name] = m.Data
+	}
+	if len(ms) != 4 {
+		t.Fatalf("unexpected number of metrics; got %d; want 4", len(ms))
+	}
+
+	requests, ok := ms["requests_total"].(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("unexpected data type for requests_total: %T", ms["requests_total"])
+	}
+	if !requests.IsMonotonic || requests.Temporality != metricdata.CumulativeTemporality {
+		t.Fatalf("requests_total must be cumulative monotonic sum")
+	}
+	values := make(map[string]int64)
+	for _, dp := range requests.DataPoints {
+		path, _ := dp.Attributes.Value("path")
+		values[path.AsString()] = dp.Value
+	}
+	if !reflect.DeepEqual(values, map[string]int64{"/foo": 3, "/bar": 5}) {
+		t.Fatalf("unexpected requests_total values: %v", values)
+	}
+
+	bytesTotal, ok := ms["bytes_total"].(metricdata.Sum[float64])
+	if !ok {
+		t.Fatalf("unexpected data type for bytes_total: %T", ms["bytes_total"])
+	}
+	if !bytesTotal.IsMonotonic || len(bytesTotal.DataPoints) != 1 || bytesTotal.DataPoints[0].Value != 1.5 {
+		t.Fatalf("unexpected bytes_total: %+v", bytesTotal)
+	}
+
+	temperature, ok := ms["temperature"].(metricdata.Gauge[float64])
+	if !ok {
+		t.Fatalf("unexpected data type for temperature: %T", ms["temperature"])
+	}
+	if len(temperature.DataPoints) != 1 || temperature.DataPoints[0].Value != -2.5 {
+		t.Fatalf("unexpected temperature: %+v", temperature)
+	}
+
+	duration, ok := ms["duration_seconds"].(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("unexpected data type for duration_seconds: %T", ms["duration_seconds"])
+	}
+	if len(duration.DataPoints) != 1 {
+		t.Fatalf("unexpected number of duration_seconds data points; got %d; want 1", len(duration.DataPoints))
+	}
+	dp := duration.DataPoints[0]
+	if job, _ := dp.Attributes.Value("job"); job.AsString() != "x" {
+		t.Fatalf("unexpected job attribute; got %q; want %q", job.AsString(), "x")
+	}
+	if dp.Count != 1 || dp.Sum != 1 {
+		t.Fatalf("unexpected count or sum; got %d, %v; want 1, 1", dp.Count, dp.Sum)
+	}
+	if !reflect.DeepEqual(dp.Bounds, []float64{1}) || !reflect.DeepEqual(dp.BucketCounts, []uint64{1, 0}) {
+		t.Fatalf("unexpected buckets; got bounds=%v, counts=%v; want bounds=[1], counts=[1 0]", dp.Bounds, dp.BucketCounts)
+	}
+}
+
+func TestProducerEmptySet(t *testing.T) {
+	p := NewProducer(metrics.NewSet())
+	sms, err := p.Produce(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sms) != 0 {
+		t.Fatalf("unexpected scope metrics for empty set: %+v", sms)
+	}
+}
+
+func TestGetHistogramDataPoint(t *testing.T) {
+	f := func(values []float64, boundsExpected []float64, countsExpected []uint64) {
+		t.Helper()
+		var h metrics.Histogram
+		for _, v := range values {
+			h.Update(v)
+		}
+		dp := getHistogramDataPoint(&h)
+		if !reflect.DeepEqual(dp.Bounds, boundsExpected) {
+			t.Fatalf("unexpected bounds; got %v; want %v", dp.Bounds, boundsExpected)
+		}
+		if !reflect.DeepEqual(dp.BucketCounts, countsExpected) {
+			t.Fatalf("unexpected bucket counts; got %v; want %v", dp.BucketCounts, countsExpected)
+		}
+		if dp.Count != uint64(len(values)) {
+			t.Fatalf("unexpected count; got %d; want %d", dp.Count, len(values))
+		}
+	}
+
+	// Empty histogram
+	f(nil, nil, []uint64{0})
+
+	// Single bucket
+	f([]float64{1, 1}, []float64{1}, []uint64{2, 0})
+
+	// Values below the minimum supported value
+	f([]float64{0, 1e-12}, []float64{1e-9}, []uint64{2, 0})
+
+	// Values above the maximum supported value go to +Inf bucket
+	f([]float64{1e20, 1}, []float64{1}, []uint64{1, 1})
+}
+
+func TestGetHistogramDataPointBucketValues(t *testing.T) {
+	values := []float64{0.001, 0.5, 0.7, 3, 42, 42, 1234.5, 1e6}
+	var h metrics.Histogram
+	for _, v := range values {
+		h.Update(v)
+	}
+	dp := getHistogramDataPoint(&h)
+	if len(dp.BucketCounts) != len(dp.Bounds)+1 {
+		t.Fatalf("unexpected number of bucket counts; got %d; want %d", len(dp.BucketCounts), len(dp.Bounds)+1)
+	}
+	for i := 1; i < len(dp.Bounds); i++ {
+		if dp.Bounds[i-1] >= dp.Bounds[i] {
+			t.Fatalf("bounds must be sorted in ascending order; got %v", dp.Bounds)
+		}
+	}
+
+	// Every value must be counted in the OTLP bucket (bounds[i-1] ... bounds[i]].
+	countsExpected := make([]uint64, len(dp.BucketCounts))
+	for _, v := range values {
+		i := 0
+		for i < len(dp.Bounds) && v > dp.Bounds[i] {
+			i++
+		}
+		countsExpected[i]++
+	}
+	if !reflect.DeepEqual(dp.BucketCounts, countsExpected) {
+		t.Fatalf("unexpected bucket counts for bounds %v; got %v; want %v", dp.Bounds, dp.BucketCounts, countsExpected)
+	}
+	sumExpected := 0.0
+	for _, v := range values {
+		sumExpected += v
+	}
+	if math.Abs(dp.Sum-sumExpected) > 1e-9 {
+		t.Fatalf("unexpected sum; got %v; want %v", dp.Sum, sumExpected)
+	}
+}
+
+func TestParseMetricNameSuccess(t *testing.T) {
+	f := func(name, familyExpected string, kvsExpected []attribute.KeyValue) {
+		t.Helper()
+		family, attrs, err := parseMetricName(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if family != familyExpected {
+			t.Fatalf("unexpected family; got %q; want %q", family, familyExpected)
+		}
+		attrsExpected := attribute.NewSet(kvsExpected...)
+		if !attrs.Equals(&attrsExpected) {
+			t.Fatalf("unexpected attributes; got %v; want %v", attrs.ToSlice(), kvsExpected)
+		}
+	}
+
+	f("foo", "foo", nil)
+	f(`foo{}`, "foo", nil)
+	f(`foo{bar="baz"}`, "foo", []attribute.KeyValue{
+		attribute.String("bar", "baz"),
+	})
+	f(`foo{bar="baz",x="a,b=\"c\"\\\n"}`, "foo", []attribute.KeyValue{
+		attribute.String("bar", "baz"),
+		attribute.String("x", "a,b=\"c\"\\\n"),
+	})
+}
+
+func TestParseMetricNameFailure(t *testing.T) {
+	f := func(name string) {
+		t.Helper()
+		_, _, err := parseMetricName(name)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for %q", name)
+		}
+	}
+
+	f(`foo{`)
+	f(`foo{bar}`)
+	f(`foo{bar=baz}`)
+	f(`foo{bar="baz}`)
+	f(`foo{="baz"}`)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	reqCh := make(chan *colmetricpb.ExportMetricsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("cannot read request body: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req colmetricpb.ExportMetricsServiceRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			t.Errorf("cannot unmarshal request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reqCh <- &req
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		resp, _ := proto.Marshal(&colmetricpb.ExportMetricsServiceResponse{})
+		_, _ = w.Write(resp)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpoint(srv.Listener.Addr().String()), otlpmetrichttp.WithInsecure())
+	if err != nil {
+		t.Fatalf("cannot create exporter: %s", err)
+	}
+
+	s := metrics.NewSet()
+	s.NewCounter(`requests_total{path="/foo"}`).Add(3)
+	h := s.NewHistogram("duration_seconds")
+	h.Update(0.5)
+	h.Update(1)
+	h.Update(1e20)
+
+	rm := collectMetrics(t, s)
+	if err := exporter.Export(ctx, &rm); err != nil {
+		t.Fatalf("cannot export metrics: %s", err)
+	}
+	if err := exporter.Shutdown(ctx); err != nil {
+		t.Fatalf("cannot shutdown exporter: %s", err)
+	}
+	req := <-reqCh
+
+	ms := make(map[string]*metricpb.Metric)
+	for _, rms := range req.ResourceMetrics {
+		for _, sms := range rms.ScopeMetrics {
+			for _, m := range sms.Metrics {
+				ms[m.Name] = m
+			}
+		}
+	}
+	sum := ms["requests_total"].GetSum()
+	if sum == nil {
+		t.Fatalf("missing requests_total sum in %v", ms)
+	}
+	if !sum.IsMonotonic || sum.AggregationTemporality != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("requests_total must be cumulative monotonic sum; got %v", sum)
+	}
+	if len(sum.DataPoints) != 1 || sum.DataPoints[0].GetAsInt() != 3 {
+		t.Fatalf("unexpected requests_total data points: %v", sum.DataPoints)
+	}
+	attrs := sum.DataPoints[0].Attributes
+	if len(attrs) != 1 || attrs[0].Key != "path" || attrs[0].Value.GetStringValue() != "/foo" {
+		t.Fatalf("unexpected requests_total attributes: %v", attrs)
+	}
+
+	hist := ms["duration_seconds"].GetHistogram()
+	if hist == nil {
+		t.Fatalf("missing duration_seconds histogram in %v", ms)
+	}
+	if len(hist.DataPoints) != 1 {
+		t.Fatalf("unexpected number of duration_seconds data points; got %d; want 1", len(hist.DataPoints))
+	}
+	hdp := hist.DataPoints[0]
+	if hdp.Count != 3 {
+		t.Fatalf("unexpected count; got %d; want 3", hdp.Count)
+	}
+	boundsExpected := getHistogramDataPoint(h).Bounds
+	if len(boundsExpected) != 2 || boundsExpected[0] < 0.5 || boundsExpected[1] != 1 {
+		t.Fatalf("unexpected bounds for vmrange buckets: %v", boundsExpected)
+	}
+	if !reflect.DeepEqual(hdp.ExplicitBounds, boundsExpected) {
+		t.Fatalf("unexpected explicit bounds; got %v; want %v", hdp.ExplicitBounds, boundsExpected)
+	}
+	if !reflect.DeepEqual(hdp.BucketCounts, []uint64{1, 1, 1}) {
+		t.Fatalf("unexpected bucket counts; got %v; want [1 1 1]", hdp.BucketCounts)
+	}
+}
+
+func collectMetrics(t *testing.T, s *metrics.Set) metricdata.ResourceMetrics {
+	t.Helper()
+	reader := sdkmetric.NewManualReader(sdkmetric.WithProducer(NewProducer(s)))
+	_ = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("cannot collect metrics: %s", err)
+	}
+	return rm
+}
@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"fmt"
+)
+
+// GetOrCreateCounterPairs returns registered counter with the given base name and labelPairs
+// or creates new counter if the registry doesn't contain counter with the given name.
+//
+// labelPairs must contain alternating label names and values, e.g. `"path", "/foo", "method", "GET"`.
+// The order of labels doesn't matter - the counter name is built with BuildNameOrdered.
+//
+// The existing counter is obtained without building its name and without memory allocations,
+// so GetOrCreateCounterPairs is preferred over GetOrCreateCounter(fmt.Sprintf(...)) on hot paths.
+func GetOrCreateCounterPairs(base string, labelPairs ...string) *Counter {
+	return defaultSet.GetOrCreateCounterPairs(base, labelPairs...)
+}
+
+// GetOrCreateCounterPairs returns registered counter in s with the given base name and labelPairs
+// or creates new counter if s doesn't contain counter with the given name.
+//
+// See GetOrCreateCounterPairs for details.
+func (s *Set) GetOrCreateCounterPairs(base string, labelPairs ...string) *Counter {
+	m := s.getOrCreateMetricPairs(base, labelPairs, func(name string) metric {
+		return s.GetOrCreateCounter(name)
+	})
+	c, ok := m.(*Counter)
+	if !ok {
+		panic(fmt.Errorf("BUG: metric %q isn't a Counter. It is %T", BuildNameOrdered(base, labelPairs...), m))
+	}
+	return c
+}
+
+// GetOrCreateGaugePairs returns registered gauge with the given base name and labelPairs
+// or creates new gauge if the registry doesn't contain gauge with the given name.
+//
+// See GetOrCreateCounterPairs and GetOrCreateGauge for details.
+func GetOrCreateGaugePairs(base string, f func() float64, labelPairs ...string) *Gauge {
+	return defaultSet.GetOrCreateGaugePairs(base, f, labelPairs...)
+}
+
+// GetOrCreateGaugePairs returns registered gauge in s with the given base name and labelPairs
+// or creates new gauge if s doesn't contain gauge with the given name.
+//
+// See GetOrCreateCounterPairs and GetOrCreateGauge for details.
+func (s *Set) GetOrCreateGaugePairs(base string, f func() float64, labelPairs ...string) *Gauge {
+	m := s.getOrCreateMetricPairs(base, labelPairs, func(name string) metric {
+		return s.GetOrCreateGauge(name, f)
+	})
+	g, ok := m.(*Gauge)
+	if !ok {
+		panic(fmt.Errorf("BUG: metric %q isn't a Gauge. It is %T", BuildNameOrdered(base, labelPairs...), m))
+	}
+	return g
+}
+
+// GetOrCreateHistogramPairs returns registered histogram with the given base name and labelPairs
+// or creates new histogram if the registry doesn't contain histogram with the given name.
+//
+// See GetOrCreateCounterPairs for details.
+func GetOrCreateHistogramPairs(base string, labelPairs ...string) *Histogram {
+	return defaultSet.GetOrCreateHistogramPairs(base, labelPairs...)
+}
+
+// GetOrCreateHistogramPairs returns registered histogram in s with the given base name and labelPairs
+// or creates new histogram if s doesn't contain histogram with the given name.
+//
+// See GetOrCreateCounterPairs for details.
+func (s *Set) GetOrCreateHistogramPairs(base string, labelPairs ...string) *Histogram {
+	m := s.getOrCreateMetricPairs(base, labelPairs, func(name string) metric {
+		return s.GetOrCreateHistogram(name)
+	})
+	h, ok := m.(*Histogram)
+	if !ok {
+		panic(fmt.Errorf("BUG: metric %q isn't a Histogram. It is %T", BuildNameOrdered(base, labelPairs...), m))
+	}
+	return h
+}
+
+// GetOrCreateSummaryPairs returns registered summary with the given base name and labelPairs
+// or creates new summary if the registry doesn't contain summary with the given name.
+//
+// See GetOrCreateCounterPairs and GetOrCreateSummary for details.
+func GetOrCreateSummaryPairs(base string, labelPairs ...string) *Summary {
+	return defaultSet.GetOrCreateSummaryPairs(base, labelPairs...)
+}
+
+// GetOrCreateSummaryPairs returns registered summary in s with the given base name and labelPairs
+// or creates new summary if s doesn't contain summary with the given name.
+//
+// See GetOrCreateCounterPairs and GetOrCreateSummary for details.
+func (s *Set) GetOrCreateSummaryPairs(base string, labelPairs ...string) *Summary {
+	m := s.getOrCreateMetricPairs(base, labelPairs, func(name string) metric {
+		return s.GetOrCreateSummary(name)
+	})
+	sm, ok := m.(*Summary)
+	if !ok {
+		panic(fmt.Errorf("BUG: metric %q isn't a Summary. It is %T", BuildNameOrdered(base, labelPairs...), m))
+	}
+	return sm
+}
+
+// pairsEntry is an entry in Set.pairsIdx.
+type pairsEntry struct {
+	base string
+
+	// pairs contains label pairs sorted by label names.
+	pairs []string
+
+	nm *namedMetric
+}
+
+// maxStackLabelPairs is the maximum number of label names and values, which are sorted without memory allocations.
+const maxStackLabelPairs = 32
+
+// getOrCreateMetricPairs returns the metric registered in s with the given base name and labelPairs.
+//
+// If the metric is missing, then create is called with the canonical metric name for registering the metric in s.
+// create must return the metric obtained for the given name.
+func (s *Set) getOrCreateMetricPairs(base string, labelPairs []string, create func(name string) metric) metric {
+	if len(labelPairs)%2 != 0 {
+		panic(fmt.Errorf("BUG: odd number of label name-value pairs for metric %q: %d", base, len(labelPairs)))
+	}
+	var pairsBuf [maxStackLabelPairs]string
+	var pairs []string
+	if len(labelPairs) <= len(pairsBuf) {
+		pairs = append(pairsBuf[:0], labelPairs...)
+	} else {
+		pairs = append([]string{}, labelPairs...)
+	}
+	sortLabelPairs(pairs)
+	h := hashLabelPairs(base, pairs)
+
+	s.mu.RLock()
+	for _, e := range s.pairsIdx[h] {
+		// Verify the entry, since distinct names may have the same hash.
+		if e.base == base && equalStrings(e.pairs, pairs) && s.m[e.nm.name] == e.nm {
+			m := e.nm.metric
+			s.mu.RUnlock()
+			return m
+		}
+	}
+	s.mu.RUnlock()
+
+	// Slow path - build and validate the metric name, then register the metric.
+	name := string(appendMetricName(nil, base, pairs))
+	m := create(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nm := s.m[name]
+	if nm == nil || nm.metric != m {
+		// The metric hasn't been registered because of the limit set via SetMaxMetrics
+		// or it has been unregistered concurrently.
+		return m
+	}
+	s.addPairsEntryLocked(h, &pairsEntry{
+		base:  base,
+		pairs: append([]string{}, pairs...),
+		nm:    nm,
+	})
+	return nm.metric
+}
+
+// addPairsEntryLocked adds e with the hash h to s.pairsIdx.
+//
+// Stale entries with the same hash for metrics, which are unregistered from s, are removed.
+func (s *Set) addPairsEntryLocked(h uint64, e *pairsEntry) {
+	if s.pairsIdx == nil {
+		s.pairsIdx = make(map[uint64][]*pairsEntry)
+	}
+	entries := s.pairsIdx[h]
+	entriesNew := entries[:0]
+	for _, prev := range entries {
+		if prev.nm != e.nm && s.m[prev.nm.name] == prev.nm {
+			entriesNew = append(entriesNew, prev)
+		}
+	}
+	s.pairsIdx[h] = append(entriesNew, e)
+	e.nm.pairsHash = h
+	e.nm.hasPairsHash = true
+}
+
+// deletePairsEntryLocked deletes the entry for nm from s.pairsIdx.
+func (s *Set) deletePairsEntryLocked(nm *namedMetric) {
+	if !nm.hasPairsHash {
+		return
+	}
+	h := nm.pairsHash
+	entries := s.pairsIdx[h]
+	entriesNew := entries[:0]
+	for _, e := range entries {
+		if e.nm != nm {
+			entriesNew = append(entriesNew, e)
+		}
+	}
+	if len(entriesNew) == 0 {
+		delete(s.pairsIdx, h)
+	} else {
+		s.pairsIdx[h] = entriesNew
+	}
+}
+
+// hashLabelPairs returns FNV-1a hash for base and label pairs.
+//
+// Every string is followed by its length, so distinct pairs cannot result in the same sequence of hashed bytes.
+func hashLabelPairs(base string, pairs []string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	hashString := func(s string) {
+		for i := 0; i < len(s); i++ {
+			h ^= uint64(s[i])
+			h *= prime64
+		}
+		n := uint64(len(s))
+		for i := 0; i < 8; i++ {
+			h ^= n & 0xff
+			h *= prime64
+			n >>= 8
+		}
+	}
+	hashString(base)
+	for _, s := range pairs {
+		hashString(s)
+	}
+	return h
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestGetOrCreateCounterPairs(t *testing.T) {
+	s := NewSet()
+	c := s.GetOrCreateCounterPairs("requests_total", "path", "/foo", "method", "GET")
+	c.Inc()
+
+	// The order of labels doesn't matter
+	if c2 := s.GetOrCreateCounterPairs("requests_total", "method", "GET", "path", "/foo"); c2 != c {
+		t.Fatalf("expecting the same counter for labels in distinct order")
+	}
+	if c2 := s.GetOrCreateCounter(`requests_total{method="GET",path="/foo"}`); c2 != c {
+		t.Fatalf("expecting the same counter for the canonical name")
+	}
+
+	// Distinct labels result in distinct counters
+	if c2 := s.GetOrCreateCounterPairs("requests_total", "path", "/bar", "method", "GET"); c2 == c {
+		t.Fatalf("expecting distinct counters for distinct labels")
+	}
+	if c2 := s.GetOrCreateCounterPairs("requests_total"); c2 == c {
+		t.Fatalf("expecting distinct counters for missing labels")
+	}
+	names := s.ListMetricNames()
+	namesExpected := []string{"requests_total", `requests_total{method="GET",path="/bar"}`, `requests_total{method="GET",path="/foo"}`}
+	if !equalStrings(names, namesExpected) {
+		t.Fatalf("unexpected metric names; got %q; want %q", names, namesExpected)
+	}
+
+	// The counter registered via GetOrCreateCounter is found
+	c = s.GetOrCreateCounter(`requests_total{method="POST",path="/foo"}`)
+	if c2 := s.GetOrCreateCounterPairs("requests_total", "path", "/foo", "method", "POST"); c2 != c {
+		t.Fatalf("expecting the counter registered via GetOrCreateCounter")
+	}
+
+	// Unregistered counter is re-created
+	c = s.GetOrCreateCounterPairs("foo", "a", "b")
+	c.Inc()
+	if !s.UnregisterMetric(`foo{a="b"}`) {
+		t.Fatalf("cannot unregister the counter")
+	}
+	if c2 := s.GetOrCreateCounterPairs("foo", "a", "b"); c2 == c || c2.Get() != 0 {
+		t.Fatalf("expecting new counter after unregistering the old one")
+	}
+}
+
+func TestGetOrCreateMetricPairsHashCollision(t *testing.T) {
+	s := NewSet()
+	c := s.GetOrCreateCounterPairs("foo", "a", "b")
+
+	// Register another metric under the same hash as foo{a="b"}
+	h := hashLabelPairs("foo", []string{"a", "b"})
+	barCounter := s.GetOrCreateCounter("bar")
+	s.mu.Lock()
+	entries := s.pairsIdx[h]
+	s.pairsIdx[h] = append([]*pairsEntry{{
+		base:  "bar",
+		pairs: nil,
+		nm:    s.m["bar"],
+	}}, entries...)
+	s.mu.Unlock()
+
+	if c2 := s.GetOrCreateCounterPairs("foo", "a", "b"); c2 != c {
+		t.Fatalf("unexpected counter returned on hash collision")
+	}
+	if c2 := s.GetOrCreateCounterPairs("bar"); c2 != barCounter {
+		t.Fatalf("unexpected counter returned for bar")
+	}
+}
+
+func TestGetOrCreateMetricPairsTypes(t *testing.T) {
+	s := NewSet()
+	g := s.GetOrCreateGaugePairs("temperature", nil, "room", "a")
+	g.Set(10)
+	if g2 := s.GetOrCreateGaugePairs("temperature", nil, "room", "a"); g2 != g {
+		t.Fatalf("expecting the same gauge")
+	}
+	h := s.GetOrCreateHistogramPairs("duration_seconds", "path", "/foo")
+	if h2 := s.GetOrCreateHistogramPairs("duration_seconds", "path", "/foo"); h2 != h {
+		t.Fatalf("expecting the same histogram")
+	}
+	sm := s.GetOrCreateSummaryPairs("response_size_bytes", "path", "/foo")
+	if sm2 := s.GetOrCreateSummaryPairs("response_size_bytes", "path", "/foo"); sm2 != sm {
+		t.Fatalf("expecting the same summary")
+	}
+	if sm2 := s.GetOrCreateSummary(`response_size_bytes{path="/foo"}`); sm2 != sm {
+		t.Fatalf("expecting the same summary for the canonical name")
+	}
+
+	// Type mismatch
+	expectPanic(t, "GetOrCreateCounterPairs for gauge", func() {
+		s.GetOrCreateCounterPairs("temperature", "room", "a")
+	})
+	expectPanic(t, "GetOrCreateHistogramPairs for summary", func() {
+		s.GetOrCreateHistogramPairs("response_size_bytes", "path", "/foo")
+	})
+}
+
+func TestGetOrCreateMetricPairsFailure(t *testing.T) {
+	s := NewSet()
+	expectPanic(t, "odd number of pairs", func() {
+		s.GetOrCreateCounterPairs("foo", "a")
+	})
+	expectPanic(t, "invalid base", func() {
+		s.GetOrCreateCounterPairs("foo{", "a", "b")
+	})
+	expectPanic(t, "invalid label name", func() {
+		s.GetOrCreateCounterPairs("foo", "a-b", "c")
+	})
+	expectPanic(t, "duplicate label name", func() {
+		s.GetOrCreateCounterPairs("foo", "a", "b", "a", "c")
+	})
+}
+
+func TestGetOrCreateMetricPairsNoAllocs(t *testing.T) {
+	s := NewSet()
+	s.GetOrCreateCounterPairs("requests_total", "path", "/foo", "method", "GET")
+	s.GetOrCreateHistogramPairs("duration_seconds", "path", "/foo", "method", "GET")
+	s.GetOrCreateSummaryPairs("response_size_bytes", "path", "/foo")
+	s.GetOrCreateGaugePairs("temperature", nil, "room", "a")
+
+	path := "/foo"
+	n := testing.AllocsPerRun(100, func() {
+		s.GetOrCreateCounterPairs("requests_total", "path", path, "method", "GET").Inc()
+		s.GetOrCreateHistogramPairs("duration_seconds", "method", "GET", "path", path).Update(1)
+		s.GetOrCreateSummaryPairs("response_size_bytes", "path", path)
+		s.GetOrCreateGaugePairs("temperature", nil, "room", "a").Inc()
+	})
+	if n != 0 {
+		t.Fatalf("unexpected number of allocations; got %v; want 0", n)
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkGetOrCreateCounterPairs(b *testing.B) {
+	s := NewSet()
+	s.GetOrCreateCounterPairs("requests_total", "path", "/foo", "method", "GET")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		path := "/foo"
+		for pb.Next() {
+			s.GetOrCreateCounterPairs("requests_total", "path", path, "method", "GET").Inc()
+		}
+	})
+}
+
+func BenchmarkGetOrCreateCounterSprintf(b *testing.B) {
+	s := NewSet()
+	s.GetOrCreateCounter(`requests_total{path="/foo",method="GET"}`)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		path := "/foo"
+		for pb.Next() {
+			s.GetOrCreateCounter(fmt.Sprintf(`requests_total{path=%q,method=%q}`, path, "GET")).Inc()
+		}
+	})
+}
+
+func BenchmarkGetOrCreateHistogramPairs(b *testing.B) {
+	s := NewSet()
+	s.GetOrCreateHistogramPairs("duration_seconds", "path", "/foo", "method", "GET")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		path := "/foo"
+		for pb.Next() {
+			s.GetOrCreateHistogramPairs("duration_seconds", "path", path, "method", "GET").Update(1)
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// label is a single `name="value"` label pair.
+type label struct {
+	name  string
+	value string
+}
+
+// parsedSample is a sample parsed from a line in Prometheus text exposition format.
+type parsedSample struct {
+	// metricName is the metric name without labels.
+	metricName string
+
+	// labels contains labels in the order they appear in the line.
+	labels []label
+
+	value float64
+
+	// timestamp is an optional timestamp in milliseconds. It is set to 0 if the line has no timestamp.
+	timestamp int64
+}
+
+// forEachSample calls f for every sample in src in Prometheus text exposition format.
+//
+// Empty lines and comments are skipped. Lines, which cannot be parsed, are skipped too,
+// and the error for the first such line is returned after processing all the lines in src.
+//
+// ps passed to f is valid only until f returns.
+func forEachSample(src []byte, f func(ps *parsedSample)) error {
+	var ps parsedSample
+	var firstErr error
+	lineNum := 0
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		lineNum++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			// Skip empty lines and comments
+			continue
+		}
+		if err := parseSampleLine(&ps, string(line)); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("cannot parse line #%d: %w", lineNum, err)
+			}
+			continue
+		}
+		f(&ps)
+	}
+	return firstErr
+}
+
+// parseSampleLine parses the given line in Prometheus text exposition format into ps.
+//
+// The line must not contain comments or leading whitespace.
+// See https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md#text-based-format
+func parseSampleLine(ps *parsedSample, line string) error {
+	ps.labels = ps.labels[:0]
+	ps.timestamp = 0
+
+	n := strings.IndexAny(line, "{ \t")
+	if n <= 0 {
+		return fmt.Errorf("missing value after metric name in %q", line)
+	}
+	ps.metricName = line[:n]
+	tail := line[n:]
+	if tail[0] == '{' {
+		var err error
+		ps.labels, tail, err = parseLabels(ps.labels, tail[1:])
+		if err != nil {
+			return fmt.Errorf("cannot parse labels in %q: %w", line, err)
+		}
+	}
+	tail = strings.TrimLeft(tail, " \t")
+	n = strings.IndexAny(tail, " \t")
+	valueStr := tail
+	timestampStr := ""
+	if n >= 0 {
+		valueStr = tail[:n]
+		timestampStr = strings.TrimSpace(tail[n+1:])
+	}
+	if valueStr == "" {
+		return fmt.Errorf("missing value in %q", line)
+	}
+	v, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return fmt.Errorf("cannot parse value %q in %q: %w", valueStr, line, err)
+	}
+	ps.value = v
+	if timestampStr != "" {
+		ts, err := strconv.ParseInt(timestampStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot parse timestamp %q in %q: %w", timestampStr, line, err)
+		}
+		ps.timestamp = ts
+	}
+	return nil
+}
+
+// parseLabels parses labels from s until the closing curly brace and appends them to dst.
+//
+// It returns the tail after the closing curly brace.
+func parseLabels(dst []label, s string) ([]label, string, error) {
+	for {
+		s = strings.TrimLeft(s, " ")
+		if len(s) == 0 {
+			return dst, s, fmt.Errorf("missing closing curly brace")
+		}
+		if s[0] == '}' {
+			return dst, s[1:], nil
+		}
+		n := strings.IndexByte(s, '=')
+		if n <= 0 {
+			return dst, s, fmt.Errorf("missing `=` after label name in %q", s)
+		}
+		name := strings.TrimSpace(s[:n])
+		s = strings.TrimLeft(s[n+1:], " ")
+		if len(s) == 0 || s[0] != '"' {
+			return dst, s, fmt.Errorf("missing starting `\"` for %q label value", name)
+		}
+		value, tail, err := parseQuotedString(s[1:])
+		if err != nil {
+			return dst, s, fmt.Errorf("cannot parse value for %q label: %w", name, err)
+		}
+		dst = append(dst, label{
+			name:  name,
+			value: value,
+		})
+		s = strings.TrimLeft(tail, " ")
+		if len(s) > 0 && s[0] == ',' {
+			s = s[1:]
+		}
+	}
+}
+
+// parseQuotedString parses the string value until the closing `"` and unescapes it.
+//
+// It returns the tail after the closing `"`.
+func parseQuotedString(s string) (string, string, error) {
+	n := strings.IndexAny(s, `"\`)
+	if n < 0 {
+		return "", s, fmt.Errorf("missing trailing `\"`")
+	}
+	if s[n] == '"' {
+		// Fast path - the string has no escape sequences.
+		return s[:n], s[n+1:], nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch ch {
+		case '"':
+			return sb.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", s, fmt.Errorf("missing trailing `\"`")
+			}
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	return "", s, fmt.Errorf("missing trailing `\"`")
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseSampleLineSuccess(t *testing.T) {
+	f := func(line string, psExpected *parsedSample) {
+		t.Helper()
+		var ps parsedSample
+		if err := parseSampleLine(&ps, line); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(ps.labels) == 0 {
+			ps.labels = nil
+		}
+		if !reflect.DeepEqual(&ps, psExpected) {
+			t.Fatalf("unexpected result for %q; got %+v; want %+v", line, &ps, psExpected)
+		}
+	}
+	f("foo 123", &parsedSample{
+		metricName: "foo",
+		value:      123,
+	})
+	f("foo 1.5 1700000000000", &parsedSample{
+		metricName: "foo",
+		value:      1.5,
+		timestamp:  1700000000000,
+	})
+	f(`foo{} -Inf`, &parsedSample{
+		metricName: "foo",
+		value:      math.Inf(-1),
+	})
+	f(`foo{a="b", c="d\"e\\f\ng",} 2`, &parsedSample{
+		metricName: "foo",
+		labels: []label{
+			{name: "a", value: "b"},
+			{name: "c", value: "d\"e\\f\ng"},
+		},
+		value: 2,
+	})
+}
+
+func TestParseSampleLineFailure(t *testing.T) {
+	f := func(line string) {
+		t.Helper()
+		var ps parsedSample
+		if err := parseSampleLine(&ps, line); err == nil {
+			t.Fatalf("expecting non-nil error for %q", line)
+		}
+	}
+	f("foo")
+	f("foo{")
+	f(`foo{a} 1`)
+	f(`foo{a=b} 1`)
+	f(`foo{a="b} 1`)
+	f(`foo{a="b"}`)
+	f("foo bar")
+	f("foo 1 bar")
+}
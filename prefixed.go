@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PrefixedSet creates metrics in the underlying Set with the given prefix prepended to their base names.
+//
+// PrefixedSet allows libraries to register metrics without knowing the naming convention of the application.
+// For example, the same library may be used twice in a single binary with distinct prefixes without metric name collisions.
+//
+// Use WithPrefix or Set.WithPrefix for creating PrefixedSet.
+type PrefixedSet struct {
+	s      *Set
+	prefix string
+}
+
+// WithPrefix returns PrefixedSet for creating metrics with base names starting with `prefix_` in the default set.
+//
+// See Set.WithPrefix for details.
+func WithPrefix(prefix string) *PrefixedSet {
+	return defaultSet.WithPrefix(prefix)
+}
+
+// WithPrefix returns PrefixedSet for creating metrics with base names starting with `prefix_` in s.
+//
+// The `_` delimiter is added after the prefix automatically. Labels are left untouched.
+// For example, the counter created via s.WithPrefix("cache").NewCounter(`requests_total{type="get"}`)
+// is registered in s as `cache_requests_total{type="get"}`.
+//
+// prefix must be a valid Prometheus-compatible metric name, otherwise WithPrefix panics.
+func (s *Set) WithPrefix(prefix string) *PrefixedSet {
+	if err := validateIdent(prefix); err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name prefix %q: %s", prefix, err))
+	}
+	return &PrefixedSet{
+		s:      s,
+		prefix: prefix + "_",
+	}
+}
+
+// WithPrefix returns PrefixedSet for creating metrics with base names starting with `ps_prefix_` in the underlying set.
+//
+// This allows nesting prefixes. See Set.WithPrefix for details.
+func (ps *PrefixedSet) WithPrefix(prefix string) *PrefixedSet {
+	if err := validateIdent(prefix); err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name prefix %q: %s", prefix, err))
+	}
+	return &PrefixedSet{
+		s:      ps.s,
+		prefix: ps.prefix + prefix + "_",
+	}
+}
+
+// Set returns the underlying set for ps.
+func (ps *PrefixedSet) Set() *Set {
+	return ps.s
+}
+
+// Prefix returns the prefix, which is prepended to base names of metrics created via ps, including the trailing `_`.
+func (ps *PrefixedSet) Prefix() string {
+	return ps.prefix
+}
+
+// metricName returns name with ps.prefix prepended to the base name.
+//
+// Quoted UTF-8 metric names such as `{"foo.bar",x="y"}` get the prefix inside the quotes.
+func (ps *PrefixedSet) metricName(name string) string {
+	if strings.HasPrefix(name, `{"`) {
+		return `{"` + ps.prefix + name[len(`{"`):]
+	}
+	return ps.prefix + name
+}
+
+// NewCounter registers and returns new counter with the prefixed name in the underlying set.
+//
+// See Set.NewCounter for details.
+func (ps *PrefixedSet) NewCounter(name string) *Counter {
+	return ps.s.NewCounter(ps.metricName(name))
+}
+
+// NewCounterOpt registers and returns new counter with the prefixed opts.Name in the underlying set.
+//
+// See Set.NewCounterOpt for details.
+func (ps *PrefixedSet) NewCounterOpt(opts CounterOpts) *Counter {
+	opts.Name = ps.metricName(opts.Name)
+	return ps.s.NewCounterOpt(opts)
+}
+
+// GetOrCreateCounter returns registered counter with the prefixed name in the underlying set
+// or creates new counter if it is missing.
+//
+// See Set.GetOrCreateCounter for details.
+func (ps *PrefixedSet) GetOrCreateCounter(name string) *Counter {
+	return ps.s.GetOrCreateCounter(ps.metricName(name))
+}
+
+// NewFloatCounter registers and returns new float counter with the prefixed name in the underlying set.
+//
+// See Set.NewFloatCounter for details.
+func (ps *PrefixedSet) NewFloatCounter(name string) *FloatCounter {
+	return ps.s.NewFloatCounter(ps.metricName(name))
+}
+
+// GetOrCreateFloatCounter returns registered float counter with the prefixed name in the underlying set
+// or creates new float counter if it is missing.
+//
+// See Set.GetOrCreateFloatCounter for details.
+func (ps *PrefixedSet) GetOrCreateFloatCounter(name string) *FloatCounter {
+	return ps.s.GetOrCreateFloatCounter(ps.metricName(name))
+}
+
+// NewShardedCounter registers and returns new sharded counter with the prefixed name in the underlying set.
+//
+// See Set.NewShardedCounter for details.
+func (ps *PrefixedSet) NewShardedCounter(name string) *ShardedCounter {
+	return ps.s.NewShardedCounter(ps.metricName(name))
+}
+
+// GetOrCreateShardedCounter returns registered sharded counter with the prefixed name in the underlying set
+// or creates new sharded counter if it is missing.
+//
+// See Set.GetOrCreateShardedCounter for details.
+func (ps *PrefixedSet) GetOrCreateShardedCounter(name string) *ShardedCounter {
+	return ps.s.GetOrCreateShardedCounter(ps.metricName(name))
+}
+
+// NewGauge registers and returns gauge with the prefixed name in the underlying set, which calls f for obtaining gauge value.
+//
+// See Set.NewGauge for details.
+func (ps *PrefixedSet) NewGauge(name string, f func() float64) *Gauge {
+	return ps.s.NewGauge(ps.metricName(name), f)
+}
+
+// NewGaugeOpt registers and returns gauge with the prefixed opts.Name in the underlying set, which calls f for obtaining gauge value.
+//
+// See Set.NewGaugeOpt for details.
+func (ps *PrefixedSet) NewGaugeOpt(opts GaugeOpts, f func() float64) *Gauge {
+	opts.Name = ps.metricName(opts.Name)
+	return ps.s.NewGaugeOpt(opts, f)
+}
+
+// GetOrCreateGauge returns registered gauge with the prefixed name in the underlying set
+// or creates new gauge if it is missing.
+//
+// See Set.GetOrCreateGauge for details.
+func (ps *PrefixedSet) GetOrCreateGauge(name string, f func() float64) *Gauge {
+	return ps.s.GetOrCreateGauge(ps.metricName(name), f)
+}
+
+// NewHistogram creates and returns new histogram with the prefixed name in the underlying set.
+//
+// See Set.NewHistogram for details.
+func (ps *PrefixedSet) NewHistogram(name string) *Histogram {
+	return ps.s.NewHistogram(ps.metricName(name))
+}
+
+// NewHistogramOpt creates and returns new histogram with the prefixed opts.Name in the underlying set.
+//
+// See Set.NewHistogramOpt for details.
+func (ps *PrefixedSet) NewHistogramOpt(opts HistogramOpts) *Histogram {
+	opts.Name = ps.metricName(opts.Name)
+	return ps.s.NewHistogramOpt(opts)
+}
+
+// GetOrCreateHistogram returns registered histogram with the prefixed name in the underlying set
+// or creates new histogram if it is missing.
+//
+// See Set.GetOrCreateHistogram for details.
+func (ps *PrefixedSet) GetOrCreateHistogram(name string) *Histogram {
+	return ps.s.GetOrCreateHistogram(ps.metricName(name))
+}
+
+// NewHistogramWithBuckets creates and returns new PrometheusHistogram with the prefixed name and upperBounds in the underlying set.
+//
+// See Set.NewHistogramWithBuckets for details.
+func (ps *PrefixedSet) NewHistogramWithBuckets(name string, upperBounds []float64) *PrometheusHistogram {
+	return ps.s.NewHistogramWithBuckets(ps.metricName(name), upperBounds)
+}
+
+// NewHistogramHDR creates and returns new HDRHistogram with the prefixed name in the underlying set.
+//
+// See Set.NewHistogramHDR for details.
+func (ps *PrefixedSet) NewHistogramHDR(name string, minValue, maxValue float64, significantDigits int) *HDRHistogram {
+	return ps.s.NewHistogramHDR(ps.metricName(name), minValue, maxValue, significantDigits)
+}
+
+// GetOrCreateHistogramWithBuckets returns registered PrometheusHistogram with the prefixed name in the underlying set
+// or creates new PrometheusHistogram if it is missing.
+//
+// See Set.GetOrCreateHistogramWithBuckets for details.
+func (ps *PrefixedSet) GetOrCreateHistogramWithBuckets(name string, upperBounds []float64) *PrometheusHistogram {
+	return ps.s.GetOrCreateHistogramWithBuckets(ps.metricName(name), upperBounds)
+}
+
+// NewSummary creates and returns new summary with the prefixed name in the underlying set.
+//
+// See Set.NewSummary for details.
+func (ps *PrefixedSet) NewSummary(name string) *Summary {
+	return ps.s.NewSummary(ps.metricName(name))
+}
+
+// NewSummaryExt creates and returns new summary with the prefixed name, window and quantiles in the underlying set.
+//
+// See Set.NewSummaryExt for details.
+func (ps *PrefixedSet) NewSummaryExt(name string, window time.Duration, quantiles []float64) *Summary {
+	return ps.s.NewSummaryExt(ps.metricName(name), window, quantiles)
+}
+
+// NewSummaryOpt creates and returns new summary with the prefixed opts.Name in the underlying set.
+//
+// See Set.NewSummaryOpt for details.
+func (ps *PrefixedSet) NewSummaryOpt(opts SummaryOpts) *Summary {
+	opts.Name = ps.metricName(opts.Name)
+	return ps.s.NewSummaryOpt(opts)
+}
+
+// GetOrCreateSummary returns registered summary with the prefixed name in the underlying set
+// or creates new summary if it is missing.
+//
+// See Set.GetOrCreateSummary for details.
+func (ps *PrefixedSet) GetOrCreateSummary(name string) *Summary {
+	return ps.s.GetOrCreateSummary(ps.metricName(name))
+}
+
+// GetOrCreateSummaryExt returns registered summary with the prefixed name, window and quantiles in the underlying set
+// or creates new summary if it is missing.
+//
+// See Set.GetOrCreateSummaryExt for details.
+func (ps *PrefixedSet) GetOrCreateSummaryExt(name string, window time.Duration, quantiles []float64) *Summary {
+	return ps.s.GetOrCreateSummaryExt(ps.metricName(name), window, quantiles)
+}
+
+// NewNativeHistogram creates and returns new NativeHistogram with the prefixed name and growthFactor in the underlying set.
+//
+// See Set.NewNativeHistogram for details.
+func (ps *PrefixedSet) NewNativeHistogram(name string, growthFactor float64) *NativeHistogram {
+	return ps.s.NewNativeHistogram(ps.metricName(name), growthFactor)
+}
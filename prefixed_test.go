@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixedSet(t *testing.T) {
+	s := NewSet()
+	cache := s.WithPrefix("cache")
+	storage := s.WithPrefix("storage")
+
+	cache.NewCounter(`requests_total{type="get"}`).Add(1)
+	storage.NewCounter(`requests_total{type="get"}`).Add(2)
+	cache.NewGauge("size_bytes", func() float64 { return 100 })
+	storage.WithPrefix("index").GetOrCreateFloatCounter("lookups_total").Add(1.5)
+
+	// GetOrCreate calls resolve to the prefixed names.
+	c := cache.GetOrCreateCounter(`requests_total{type="get"}`)
+	if c != s.GetOrCreateCounter(`cache_requests_total{type="get"}`) {
+		t.Fatalf("GetOrCreateCounter via PrefixedSet must return the counter with the prefixed name")
+	}
+	c.Inc()
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `cache_requests_total{type="get"} 2
+cache_size_bytes 100
+storage_index_lookups_total 1.5
+storage_requests_total{type="get"} 2
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	if prefix := storage.WithPrefix("index").Prefix(); prefix != "storage_index_" {
+		t.Fatalf("unexpected prefix; got %q; want %q", prefix, "storage_index_")
+	}
+	if storage.Set() != s {
+		t.Fatalf("unexpected underlying set")
+	}
+}
+
+func TestPrefixedSetInvalidPrefix(t *testing.T) {
+	f := func(prefix string) {
+		t.Helper()
+		expectPanic(t, prefix, func() {
+			NewSet().WithPrefix(prefix)
+		})
+	}
+	f("")
+	f("1foo")
+	f("foo{bar}")
+	f("foo bar")
+	f("foo-bar")
+}
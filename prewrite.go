@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// RegisterPreWriteHook registers fn, which is called at the start of WritePrometheus, WriteOpenMetrics and WriteProtobuf
+// before any metric is marshaled.
+//
+// Pre-write hooks are useful for refreshing gauges, which reflect expensive external state such as queue length
+// or the number of files on disk, once per scrape instead of refreshing them in background.
+//
+// Hooks are called sequentially in the registration order. Hooks from all the registered sets are called
+// before writing metrics from any set. Hooks are called without holding the registry lock, so they may
+// create and update metrics. Panics in hooks are recovered and logged, so they do not break the output.
+//
+// Concurrent writes call hooks concurrently, so fn must be safe for calling from concurrent goroutines.
+//
+// Call Close on the returned PreWriteHook for unregistering fn. See also RegisterPreWriteHookWithTimeout.
+func RegisterPreWriteHook(fn func()) *PreWriteHook {
+	return defaultSet.RegisterPreWriteHook(fn)
+}
+
+// RegisterPreWriteHookWithTimeout registers fn, which is called at the start of WritePrometheus with the given timeout.
+//
+// If fn doesn't return during the timeout, then the write continues without waiting for fn, so the slow fn cannot hang
+// every scrape. The next call of fn is skipped if the previous call is still in progress.
+//
+// See RegisterPreWriteHook for details.
+func RegisterPreWriteHookWithTimeout(fn func(), timeout time.Duration) *PreWriteHook {
+	return defaultSet.RegisterPreWriteHookWithTimeout(fn, timeout)
+}
+
+// RegisterPreWriteHook registers fn, which is called at the start of s.WritePrometheus before any metric is marshaled.
+//
+// See RegisterPreWriteHook for details.
+func (s *Set) RegisterPreWriteHook(fn func()) *PreWriteHook {
+	return s.RegisterPreWriteHookWithTimeout(fn, 0)
+}
+
+// RegisterPreWriteHookWithTimeout registers fn, which is called at the start of s.WritePrometheus with the given timeout.
+//
+// Zero timeout means no timeout. See RegisterPreWriteHookWithTimeout for details.
+func (s *Set) RegisterPreWriteHookWithTimeout(fn func(), timeout time.Duration) *PreWriteHook {
+	if timeout < 0 {
+		timeout = 0
+	}
+	h := &PreWriteHook{
+		s:       s,
+		fn:      fn,
+		timeout: timeout,
+	}
+	s.mu.Lock()
+	s.preWriteHooks = append(s.preWriteHooks, h)
+	s.mu.Unlock()
+	return h
+}
+
+// PreWriteHook is a callback registered via RegisterPreWriteHook.
+type PreWriteHook struct {
+	s       *Set
+	fn      func()
+	timeout time.Duration
+
+	// running is set to 1 while fn is executed in a goroutine started by the call with the timeout.
+	running uint32
+}
+
+// Close unregisters h, so it is no longer called before writing metrics.
+func (h *PreWriteHook) Close() {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Create new slice instead of modifying the existing one in place,
+	// since it may be in use by concurrent s.getPreWriteHooks callers.
+	hooks := make([]*PreWriteHook, 0, len(s.preWriteHooks))
+	for _, x := range s.preWriteHooks {
+		if x != h {
+			hooks = append(hooks, x)
+		}
+	}
+	s.preWriteHooks = hooks
+}
+
+// call calls h.fn. It waits up to h.timeout for h.fn to return if h.timeout is set.
+func (h *PreWriteHook) call() {
+	if h.timeout <= 0 {
+		h.callRecover()
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&h.running, 0, 1) {
+		log.Printf("ERROR: metrics: skipping the callback passed to RegisterPreWriteHook, since its previous call didn't finish yet")
+		return
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		defer func() {
+			atomic.StoreUint32(&h.running, 0)
+			close(doneCh)
+		}()
+		h.callRecover()
+	}()
+	t := time.NewTimer(h.timeout)
+	select {
+	case <-doneCh:
+		t.Stop()
+	case <-t.C:
+		log.Printf("ERROR: metrics: the callback passed to RegisterPreWriteHook didn't finish in %s; continue writing metrics without waiting for it", h.timeout)
+	}
+}
+
+func (h *PreWriteHook) callRecover() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: metrics: panic in the callback passed to RegisterPreWriteHook: %v", r)
+		}
+	}()
+	h.fn()
+}
+
+// runPreWriteHooks calls pre-write hooks registered in s.
+func (s *Set) runPreWriteHooks() {
+	s.mu.Lock()
+	hooks := s.preWriteHooks
+	s.mu.Unlock()
+
+	// Hooks are called without the lock, since they may register and update metrics in s.
+	for _, h := range hooks {
+		h.call()
+	}
+}
+
+// runPreWriteHooks calls pre-write hooks registered in sets.
+func runPreWriteHooks(sets []*Set) {
+	for _, s := range sets {
+		s.runPreWriteHooks()
+	}
+}
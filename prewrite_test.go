@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetRegisterPreWriteHook(t *testing.T) {
+	s := NewSet()
+	queueSize := s.NewCounter("queue_size")
+
+	var calls []string
+	s.RegisterPreWriteHook(func() {
+		calls = append(calls, "first")
+		// Hooks may create and update metrics, since they are called without the registry lock.
+		queueSize.Set(10)
+		s.GetOrCreateCounter("files_count").Set(3)
+	})
+	s.RegisterPreWriteHook(func() {
+		calls = append(calls, "panic")
+		panic("unexpected error")
+	})
+	h := s.RegisterPreWriteHook(func() {
+		calls = append(calls, "last")
+	})
+
+	f := func(callsExpected []string, resultExpected string) {
+		t.Helper()
+		calls = nil
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+		if !equalStrings(calls, callsExpected) {
+			t.Fatalf("unexpected hook calls; got %q; want %q", calls, callsExpected)
+		}
+	}
+
+	f([]string{"first", "panic", "last"}, "files_count 3\nqueue_size 10\n")
+
+	h.Close()
+	f([]string{"first", "panic"}, "files_count 3\nqueue_size 10\n")
+
+	s.UnregisterAllMetrics()
+	f(nil, "")
+}
+
+func TestSetRegisterPreWriteHookWithTimeout(t *testing.T) {
+	s := NewSet()
+	stopCh := make(chan struct{})
+	startedCh := make(chan struct{}, 10)
+	s.RegisterPreWriteHookWithTimeout(func() {
+		startedCh <- struct{}{}
+		<-stopCh
+	}, 10*time.Millisecond)
+	s.NewCounter("foo").Inc()
+
+	f := func(startsExpected int) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != "foo 1\n" {
+			t.Fatalf("unexpected result; got %q; want %q", result, "foo 1\n")
+		}
+		if n := len(startedCh); n != startsExpected {
+			t.Fatalf("unexpected number of hook calls; got %d; want %d", n, startsExpected)
+		}
+	}
+
+	// The slow hook doesn't block the write.
+	f(1)
+
+	// The hook isn't called again while the previous call is in progress.
+	f(1)
+
+	// The hook is called again after the previous call finishes.
+	<-startedCh
+	close(stopCh)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if len(startedCh) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the hook wasn't called after the previous call finished")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWritePrometheusPreWriteHooksOrder(t *testing.T) {
+	s1 := NewSet()
+	s2 := NewSet()
+	c := s2.NewCounter("foo")
+	s1.RegisterPreWriteHook(func() {
+		c.Set(42)
+	})
+
+	// Hooks from all the sets are called before writing metrics from any set.
+	var bb bytes.Buffer
+	WriteSets(&bb, s2, s1)
+	// The output may contain metrics_duplicate_series_total counter from other tests.
+	if result := bb.String(); !strings.HasPrefix(result, "foo 42\n") {
+		t.Fatalf("unexpected result; got %q; want %q prefix", result, "foo 42\n")
+	}
+}
@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cgroupV1UnlimitedMemory is the minimum memory.limit_in_bytes value, which means unlimited memory in cgroup v1.
+//
+// The kernel reports PAGE_COUNTER_MAX multiplied by page size, which is close to math.MaxInt64.
+const cgroupV1UnlimitedMemory = 1 << 62
+
+// writeCgroupMetrics writes process_cgroup_* metrics to w.
+//
+// Nothing is written if the process doesn't run under cgroup with the corresponding controllers.
+func writeCgroupMetrics(w io.Writer) {
+	cg := getCgroup()
+	if cg == nil {
+		return
+	}
+	cg.writeMetrics(w)
+}
+
+func (cg *cgroup) writeMetrics(w io.Writer) {
+	cs := cg.readStats()
+	if cs.hasMemoryLimit {
+		WriteGaugeUint64(w, "process_cgroup_memory_limit_bytes", cs.memoryLimit)
+	}
+	if cs.hasMemoryUsage {
+		WriteGaugeUint64(w, "process_cgroup_memory_usage_bytes", cs.memoryUsage)
+	}
+	if cs.cpuPeriod > 0 {
+		WriteGaugeUint64(w, "process_cgroup_cpu_period", cs.cpuPeriod)
+		if cs.cpuQuota > 0 {
+			WriteGaugeUint64(w, "process_cgroup_cpu_quota", uint64(cs.cpuQuota))
+			WriteGaugeFloat64(w, "process_cgroup_cpu_limit_cores", float64(cs.cpuQuota)/float64(cs.cpuPeriod))
+		}
+	}
+}
+
+var (
+	cgroupOnce   sync.Once
+	cgroupCached *cgroup
+)
+
+// getCgroup returns cgroup for the current process.
+//
+// The cgroup is detected only once, since the process cannot move between cgroup hierarchies.
+func getCgroup() *cgroup {
+	cgroupOnce.Do(func() {
+		cgroupCached = detectCgroup("/proc/self/cgroup", "/sys/fs/cgroup")
+	})
+	return cgroupCached
+}
+
+// cgroup contains directories with cgroup controller files for the current process.
+type cgroup struct {
+	// isV2 is set to true for cgroup v2 (unified hierarchy).
+	isV2 bool
+
+	memoryDir string
+	cpuDir    string
+}
+
+// detectCgroup detects cgroup for the process from procSelfCgroupPath file contents and cgroup hierarchy mounted at cgroupRoot.
+//
+// nil is returned if the process doesn't run under cgroup.
+func detectCgroup(procSelfCgroupPath, cgroupRoot string) *cgroup {
+	data, err := ioutil.ReadFile(procSelfCgroupPath)
+	if err != nil {
+		return nil
+	}
+	paths := parseProcSelfCgroup(data)
+	if len(paths) == 0 {
+		return nil
+	}
+	if fileExists(filepath.Join(cgroupRoot, "cgroup.controllers")) {
+		// cgroup v2 is mounted at cgroupRoot.
+		path, ok := paths[""]
+		if !ok {
+			return nil
+		}
+		dir := getCgroupDir(cgroupRoot, path, "memory.max", "cpu.max")
+		if dir == "" {
+			return nil
+		}
+		return &cgroup{
+			isV2:      true,
+			memoryDir: dir,
+			cpuDir:    dir,
+		}
+	}
+
+	// cgroup v1 contains individual directories per controller.
+	cg := &cgroup{}
+	if path, ok := paths["memory"]; ok {
+		cg.memoryDir = getCgroupDir(filepath.Join(cgroupRoot, "memory"), path, "memory.limit_in_bytes")
+	}
+	if path, ok := paths["cpu"]; ok {
+		cg.cpuDir = getCgroupDir(filepath.Join(cgroupRoot, "cpu"), path, "cpu.cfs_period_us")
+	}
+	if cg.memoryDir == "" && cg.cpuDir == "" {
+		return nil
+	}
+	return cg
+}
+
+// parseProcSelfCgroup returns cgroup paths per controller from /proc/self/cgroup contents.
+//
+// The path for cgroup v2 is returned under empty controller name.
+// See https://man7.org/linux/man-pages/man7/cgroups.7.html
+func parseProcSelfCgroup(data []byte) map[string]string {
+	paths := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		// The line has the following format: hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths
+}
+
+// getCgroupDir returns the directory with the given controller files for the cgroup path inside mountDir.
+//
+// The cgroup path from /proc/self/cgroup may be missing inside mountDir when the process runs in a container
+// with its own cgroup namespace or with bind-mounted cgroup directory. Fall back to mountDir in this case.
+// Empty string is returned if files are missing in both directories.
+func getCgroupDir(mountDir, path string, files ...string) string {
+	for _, dir := range []string{filepath.Join(mountDir, path), mountDir} {
+		for _, file := range files {
+			if fileExists(filepath.Join(dir, file)) {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+type cgroupStats struct {
+	memoryLimit    uint64
+	hasMemoryLimit bool
+
+	memoryUsage    uint64
+	hasMemoryUsage bool
+
+	// cpuQuota is the CPU time in microseconds the cgroup may use per cpuPeriod.
+	// cpuQuota is negative if the CPU usage isn't limited.
+	cpuQuota int64
+
+	// cpuPeriod is the CPU accounting period in microseconds. It is zero if the period is unknown.
+	cpuPeriod uint64
+}
+
+// readStats reads the current stats for cg.
+//
+// Stats, which cannot be read or which are set to unlimited values, are left unset.
+func (cg *cgroup) readStats() *cgroupStats {
+	var cs cgroupStats
+	cs.cpuQuota = -1
+	if cg.isV2 {
+		if s, err := readCgroupFile(cg.memoryDir, "memory.max"); err == nil && s != "max" {
+			cs.memoryLimit, err = strconv.ParseUint(s, 10, 64)
+			cs.hasMemoryLimit = err == nil
+		}
+		if s, err := readCgroupFile(cg.memoryDir, "memory.current"); err == nil {
+			cs.memoryUsage, err = strconv.ParseUint(s, 10, 64)
+			cs.hasMemoryUsage = err == nil
+		}
+		if s, err := readCgroupFile(cg.cpuDir, "cpu.max"); err == nil {
+			cs.cpuQuota, cs.cpuPeriod, _ = parseCgroupV2CPUMax(s)
+		}
+		return &cs
+	}
+
+	if cg.memoryDir != "" {
+		if s, err := readCgroupFile(cg.memoryDir, "memory.limit_in_bytes"); err == nil {
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err == nil && n < cgroupV1UnlimitedMemory {
+				cs.memoryLimit = n
+				cs.hasMemoryLimit = true
+			}
+		}
+		if s, err := readCgroupFile(cg.memoryDir, "memory.usage_in_bytes"); err == nil {
+			cs.memoryUsage, err = strconv.ParseUint(s, 10, 64)
+			cs.hasMemoryUsage = err == nil
+		}
+	}
+	if cg.cpuDir != "" {
+		if s, err := readCgroupFile(cg.cpuDir, "cpu.cfs_period_us"); err == nil {
+			cs.cpuPeriod, _ = strconv.ParseUint(s, 10, 64)
+		}
+		if s, err := readCgroupFile(cg.cpuDir, "cpu.cfs_quota_us"); err == nil {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				cs.cpuQuota = n
+			}
+		}
+	}
+	return &cs
+}
+
+// parseCgroupV2CPUMax parses cpu.max file contents in the format `$MAX $PERIOD`.
+//
+// The returned quota is -1 if $MAX is set to `max`.
+func parseCgroupV2CPUMax(s string) (int64, uint64, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return -1, 0, fmt.Errorf("unexpected number of fields in cpu.max; got %d; want 2", len(fields))
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return -1, 0, fmt.Errorf("cannot parse cpu period: %w", err)
+	}
+	if fields[0] == "max" {
+		return -1, period, nil
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return -1, 0, fmt.Errorf("cannot parse cpu quota: %w", err)
+	}
+	return quota, period, nil
+}
+
+func readCgroupFile(dir, name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(data)), nil
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCgroupWriteMetrics(t *testing.T) {
+	f := func(dir, resultExpected string) {
+		t.Helper()
+		cg := detectCgroup(dir+"/proc_self_cgroup", dir+"/root")
+		if cg == nil {
+			t.Fatalf("cannot detect cgroup at %q", dir)
+		}
+		var bb bytes.Buffer
+		cg.writeMetrics(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result for %q;\ngot\n%s\nwant\n%s", dir, result, resultExpected)
+		}
+	}
+
+	f("testdata/cgroup/v1", `process_cgroup_memory_limit_bytes 536870912
+process_cgroup_memory_usage_bytes 104857600
+process_cgroup_cpu_period 100000
+process_cgroup_cpu_quota 150000
+process_cgroup_cpu_limit_cores 1.5
+`)
+	f("testdata/cgroup/v2", `process_cgroup_memory_limit_bytes 1073741824
+process_cgroup_memory_usage_bytes 52428800
+process_cgroup_cpu_period 100000
+process_cgroup_cpu_quota 50000
+process_cgroup_cpu_limit_cores 0.5
+`)
+
+	// Unlimited memory and cpu
+	f("testdata/cgroup/v2_unlimited", `process_cgroup_memory_usage_bytes 1234
+process_cgroup_cpu_period 100000
+`)
+}
+
+func TestDetectCgroupMissing(t *testing.T) {
+	f := func(procSelfCgroupPath, cgroupRoot string) {
+		t.Helper()
+		if cg := detectCgroup(procSelfCgroupPath, cgroupRoot); cg != nil {
+			t.Fatalf("expecting nil cgroup; got %+v", cg)
+		}
+	}
+
+	f("testdata/cgroup/missing/proc_self_cgroup", "testdata/cgroup/missing/root")
+	f("testdata/cgroup/none/proc_self_cgroup", "testdata/cgroup/none/root")
+}
+
+func TestParseCgroupV2CPUMax(t *testing.T) {
+	f := func(s string, quotaExpected int64, periodExpected uint64, wantErr bool) {
+		t.Helper()
+		quota, period, err := parseCgroupV2CPUMax(s)
+		if (err != nil) != wantErr {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if quota != quotaExpected || period != periodExpected {
+			t.Fatalf("unexpected result for %q; got %d, %d; want %d, %d", s, quota, period, quotaExpected, periodExpected)
+		}
+	}
+
+	f("max 100000", -1, 100000, false)
+	f("200000 100000", 200000, 100000, false)
+	f("", -1, 0, true)
+	f("max", -1, 0, true)
+	f("foo 100000", -1, 0, true)
+}
+
+func TestParseProcSelfCgroup(t *testing.T) {
+	paths := parseProcSelfCgroup([]byte("12:memory:/docker/abc\n11:cpu,cpuacct:/docker/def\n0::/unified\n"))
+	for controller, pathExpected := range map[string]string{
+		"memory":  "/docker/abc",
+		"cpu":     "/docker/def",
+		"cpuacct": "/docker/def",
+		"":        "/unified",
+	} {
+		if path := paths[controller]; path != pathExpected {
+			t.Fatalf("unexpected path for controller %q; got %q; want %q", controller, path, pathExpected)
+		}
+	}
+}
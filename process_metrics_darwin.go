@@ -0,0 +1,97 @@
+//go:build darwin
+// +build darwin
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"syscall"
+)
+
+// See https://developer.apple.com/library/archive/documentation/System/Conceptual/ManPages_iPhoneOS/man2/getrusage.2.html
+func writeProcessMetrics(w io.Writer) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		log.Printf("ERROR: metrics: cannot read process resource usage: %s", err)
+		return
+	}
+	utime := float64(ru.Utime.Nano()) / 1e9
+	stime := float64(ru.Stime.Nano()) / 1e9
+	WriteCounterFloat64(w, "process_cpu_seconds_system_total", stime)
+	WriteCounterFloat64(w, "process_cpu_seconds_total", utime+stime)
+	WriteCounterFloat64(w, "process_cpu_seconds_user_total", utime)
+	WriteCounterUint64(w, "process_major_pagefaults_total", uint64(ru.Majflt))
+	WriteCounterUint64(w, "process_minor_pagefaults_total", uint64(ru.Minflt))
+
+	// ru_maxrss is measured in bytes on darwin in contrast to kilobytes on linux.
+	WriteGaugeUint64(w, "process_resident_memory_peak_bytes", uint64(ru.Maxrss))
+
+	// Task info is available only when the app is built with cgo and `metrics_libproc` build tag.
+	// Omit the corresponding metrics otherwise instead of exposing misleading zero values.
+	ti, err := getTaskInfo()
+	if err != nil {
+		if err != errTaskInfoUnsupported {
+			log.Printf("ERROR: metrics: %s", err)
+		}
+		return
+	}
+	WriteGaugeUint64(w, "process_num_threads", ti.numThreads)
+	WriteGaugeUint64(w, "process_resident_memory_bytes", ti.residentBytes)
+	WriteGaugeUint64(w, "process_start_time_seconds", ti.startTimeSeconds)
+	WriteGaugeUint64(w, "process_virtual_memory_bytes", ti.virtualBytes)
+}
+
+// taskInfo contains process information obtained via proc_pidinfo.
+type taskInfo struct {
+	numThreads       uint64
+	residentBytes    uint64
+	virtualBytes     uint64
+	startTimeSeconds uint64
+}
+
+var errTaskInfoUnsupported = fmt.Errorf("task info is unsupported without cgo and metrics_libproc build tag")
+
+// writeFDMetrics writes process_max_fds and process_open_fds metrics to w.
+func writeFDMetrics(w io.Writer) {
+	totalOpenFDs, err := getOpenFDsCount("/dev/fd")
+	if err != nil {
+		log.Printf("ERROR: metrics: cannot determine open file descriptors count: %s", err)
+		return
+	}
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		log.Printf("ERROR: metrics: cannot determine the limit on open file descritors: %s", err)
+		return
+	}
+	WriteGaugeUint64(w, "process_max_fds", rlimit.Cur)
+	WriteGaugeUint64(w, "process_open_fds", totalOpenFDs)
+}
+
+// getOpenFDsCount returns the number of open file descriptors listed at path.
+//
+// The file descriptor opened for reading path isn't counted.
+func getOpenFDsCount(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var totalOpenFDs uint64
+	for {
+		names, err := f.Readdirnames(512)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("unexpected error at Readdirnames: %s", err)
+		}
+		totalOpenFDs += uint64(len(names))
+	}
+	if totalOpenFDs > 0 {
+		totalOpenFDs--
+	}
+	return totalOpenFDs, nil
+}
@@ -0,0 +1,47 @@
+//go:build darwin && cgo && metrics_libproc
+// +build darwin,cgo,metrics_libproc
+
+package metrics
+
+// This file is built only with `metrics_libproc` build tag, so the default build for macOS doesn't need cgo
+// and libproc headers. Build the app with `-tags=metrics_libproc` for exposing process_num_threads,
+// process_resident_memory_bytes, process_start_time_seconds and process_virtual_memory_bytes metrics.
+
+/*
+#include <libproc.h>
+#include <sys/proc_info.h>
+#include <unistd.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// getTaskInfo returns information about the current process via proc_pidinfo.
+//
+// See https://opensource.apple.com/source/xnu/xnu-7195.81.3/bsd/sys/proc_info.h.auto.html
+func getTaskInfo() (*taskInfo, error) {
+	pid := C.int(C.getpid())
+
+	var pti C.struct_proc_taskinfo
+	n := C.proc_pidinfo(pid, C.PROC_PIDTASKINFO, 0, unsafe.Pointer(&pti), C.int(C.sizeof_struct_proc_taskinfo))
+	if n != C.int(C.sizeof_struct_proc_taskinfo) {
+		return nil, fmt.Errorf("cannot read task info via proc_pidinfo")
+	}
+
+	var pbi C.struct_proc_bsdinfo
+	n = C.proc_pidinfo(pid, C.PROC_PIDTBSDINFO, 0, unsafe.Pointer(&pbi), C.int(C.sizeof_struct_proc_bsdinfo))
+	if n != C.int(C.sizeof_struct_proc_bsdinfo) {
+		return nil, fmt.Errorf("cannot read bsd info via proc_pidinfo")
+	}
+
+	ti := &taskInfo{
+		numThreads:       uint64(pti.pti_threadnum),
+		residentBytes:    uint64(pti.pti_resident_size),
+		virtualBytes:     uint64(pti.pti_virtual_size),
+		startTimeSeconds: uint64(pbi.pbi_start_tvsec),
+	}
+	return ti, nil
+}
@@ -0,0 +1,11 @@
+//go:build darwin && !(cgo && metrics_libproc)
+// +build darwin
+// +build !cgo !metrics_libproc
+
+package metrics
+
+// getTaskInfo returns errTaskInfoUnsupported, since proc_pidinfo is called only when the app is built
+// with cgo and `metrics_libproc` build tag.
+func getTaskInfo() (*taskInfo, error) {
+	return nil, errTaskInfoUnsupported
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteProcessMetricsDarwin(t *testing.T) {
+	var bb bytes.Buffer
+	WriteProcessMetrics(&bb)
+	WriteFDMetrics(&bb)
+	result := bb.String()
+	names := []string{
+		"process_cpu_seconds_total",
+		"process_resident_memory_peak_bytes",
+		"process_max_fds",
+		"process_open_fds",
+	}
+	if _, err := getTaskInfo(); err == nil {
+		names = append(names, "process_num_threads", "process_resident_memory_bytes", "process_start_time_seconds")
+	}
+	for _, name := range names {
+		if !strings.Contains(result, "\n"+name+" ") {
+			t.Fatalf("missing %s in the output:\n%s", name, result)
+		}
+	}
+}
+
+func TestGetTaskInfo(t *testing.T) {
+	ti, err := getTaskInfo()
+	if err == errTaskInfoUnsupported {
+		t.Skip("task info is unavailable without cgo")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ti.residentBytes == 0 {
+		t.Fatalf("expecting non-zero resident memory")
+	}
+	if ti.numThreads == 0 {
+		t.Fatalf("expecting non-zero number of threads")
+	}
+	if ti.startTimeSeconds == 0 {
+		t.Fatalf("expecting non-zero process start time")
+	}
+}
@@ -84,61 +84,120 @@ func writeProcessMetrics(w io.Writer) {
 	WriteGaugeUint64(w, "process_start_time_seconds", uint64(startTimeSeconds))
 	WriteGaugeUint64(w, "process_virtual_memory_bytes", uint64(p.Vsize))
 	writeProcessMemMetrics(w)
+	writeSmapsRollupMetrics(w)
 	writeIOMetrics(w)
+	writeCgroupMetrics(w)
 }
 
 var procSelfIOErrLogged uint32
 
 func writeIOMetrics(w io.Writer) {
 	ioFilepath := "/proc/self/io"
-	data, err := ioutil.ReadFile(ioFilepath)
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	var err error
+	bb.B, err = readFileToBuffer(bb.B[:0], ioFilepath)
 	if err != nil {
-		// Do not spam the logs with errors - this error cannot be fixed without process restart.
+		// The file may be unreadable in some containers because of missing permissions.
+		// Silently omit process_io_* metrics in this case, since this cannot be fixed without process restart.
 		// See https://github.com/VictoriaMetrics/metrics/issues/42
-		if atomic.CompareAndSwapUint32(&procSelfIOErrLogged, 0, 1) {
-			log.Printf("ERROR: metrics: cannot read process_io_* metrics from %q, so these metrics won't be updated until the error is fixed; "+
+		if !os.IsPermission(err) && atomic.CompareAndSwapUint32(&procSelfIOErrLogged, 0, 1) {
+			log.Printf("ERROR: metrics: cannot read process_io_* metrics from %q, so these metrics won't be exposed until the error is fixed; "+
 				"see https://github.com/VictoriaMetrics/metrics/issues/42 ; The error: %s", ioFilepath, err)
 		}
+		return
+	}
+	pio, err := parseProcIO(bb.B)
+	if err != nil {
+		log.Printf("ERROR: metrics: cannot parse %q: %s", ioFilepath, err)
+		return
 	}
+	WriteCounterUint64(w, "process_io_read_bytes_total", pio.rchar)
+	WriteCounterUint64(w, "process_io_written_bytes_total", pio.wchar)
+	WriteCounterUint64(w, "process_io_read_syscalls_total", pio.syscr)
+	WriteCounterUint64(w, "process_io_write_syscalls_total", pio.syscw)
+	WriteCounterUint64(w, "process_io_storage_read_bytes_total", pio.readBytes)
+	WriteCounterUint64(w, "process_io_storage_written_bytes_total", pio.writeBytes)
+}
+
+// See https://man7.org/linux/man-pages/man5/proc.5.html
+type procIO struct {
+	rchar      uint64
+	wchar      uint64
+	syscr      uint64
+	syscw      uint64
+	readBytes  uint64
+	writeBytes uint64
+}
 
-	getInt := func(s string) int64 {
-		n := strings.IndexByte(s, ' ')
+// parseProcIO parses /proc/self/io contents from data.
+//
+// Unknown fields are ignored, so newer kernels may add fields to the file.
+func parseProcIO(data []byte) (*procIO, error) {
+	var pio procIO
+	for len(data) > 0 {
+		var line []byte
+		n := bytes.IndexByte(data, '\n')
+		if n >= 0 {
+			line = data[:n]
+			data = data[n+1:]
+		} else {
+			line = data
+			data = nil
+		}
+		n = bytes.IndexByte(line, ':')
 		if n < 0 {
-			log.Printf("ERROR: metrics: cannot find whitespace in %q at %q", s, ioFilepath)
-			return 0
+			continue
+		}
+		var dst *uint64
+		switch string(bytes.TrimSpace(line[:n])) {
+		case "rchar":
+			dst = &pio.rchar
+		case "wchar":
+			dst = &pio.wchar
+		case "syscr":
+			dst = &pio.syscr
+		case "syscw":
+			dst = &pio.syscw
+		case "read_bytes":
+			dst = &pio.readBytes
+		case "write_bytes":
+			dst = &pio.writeBytes
+		default:
+			continue
 		}
-		v, err := strconv.ParseInt(s[n+1:], 10, 64)
+		value := string(bytes.TrimSpace(line[n+1:]))
+		v, err := strconv.ParseUint(value, 10, 64)
 		if err != nil {
-			log.Printf("ERROR: metrics: cannot parse %q at %q: %s", s, ioFilepath, err)
-			return 0
+			return nil, fmt.Errorf("cannot parse %q: %w", line, err)
 		}
-		return v
+		*dst = v
 	}
-	var rchar, wchar, syscr, syscw, readBytes, writeBytes int64
-	lines := strings.Split(string(data), "\n")
-	for _, s := range lines {
-		s = strings.TrimSpace(s)
-		switch {
-		case strings.HasPrefix(s, "rchar: "):
-			rchar = getInt(s)
-		case strings.HasPrefix(s, "wchar: "):
-			wchar = getInt(s)
-		case strings.HasPrefix(s, "syscr: "):
-			syscr = getInt(s)
-		case strings.HasPrefix(s, "syscw: "):
-			syscw = getInt(s)
-		case strings.HasPrefix(s, "read_bytes: "):
-			readBytes = getInt(s)
-		case strings.HasPrefix(s, "write_bytes: "):
-			writeBytes = getInt(s)
+	return &pio, nil
+}
+
+// readFileToBuffer appends the contents of the file at path to dst and returns the result.
+//
+// This allows re-using dst buffer between calls in contrast to ioutil.ReadFile.
+func readFileToBuffer(dst []byte, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return dst, err
+	}
+	defer f.Close()
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := f.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if err == io.EOF {
+			return dst, nil
+		}
+		if err != nil {
+			return dst, err
 		}
 	}
-	WriteGaugeUint64(w, "process_io_read_bytes_total", uint64(rchar))
-	WriteGaugeUint64(w, "process_io_written_bytes_total", uint64(wchar))
-	WriteGaugeUint64(w, "process_io_read_syscalls_total", uint64(syscr))
-	WriteGaugeUint64(w, "process_io_write_syscalls_total", uint64(syscw))
-	WriteGaugeUint64(w, "process_io_storage_read_bytes_total", uint64(readBytes))
-	WriteGaugeUint64(w, "process_io_storage_written_bytes_total", uint64(writeBytes))
 }
 
 var startTimeSeconds = time.Now().Unix()
@@ -209,6 +268,103 @@ func getMaxFilesLimit(path string) (uint64, error) {
 	return 0, fmt.Errorf("cannot find max open files limit")
 }
 
+var procSelfSmapsRollupErrLogged uint32
+
+// writeSmapsRollupMetrics writes memory metrics from /proc/self/smaps_rollup to w.
+//
+// In contrast to process_resident_memory_bytes, these metrics allow distinguishing memory pages owned by the process
+// from file-backed pages shared with other processes.
+func writeSmapsRollupMetrics(w io.Writer) {
+	smapsFilepath := "/proc/self/smaps_rollup"
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	var err error
+	bb.B, err = readFileToBuffer(bb.B[:0], smapsFilepath)
+	if err != nil {
+		// The file is missing in Linux kernels older than 4.14 and it may be unreadable in some sandboxes.
+		// Silently omit these metrics in this case.
+		if !os.IsNotExist(err) && !os.IsPermission(err) && atomic.CompareAndSwapUint32(&procSelfSmapsRollupErrLogged, 0, 1) {
+			log.Printf("ERROR: metrics: cannot read %q, so process_resident_anon_memory_bytes and related metrics won't be exposed "+
+				"until the error is fixed; the error: %s", smapsFilepath, err)
+		}
+		return
+	}
+	sr, err := parseSmapsRollup(bb.B)
+	if err != nil {
+		log.Printf("ERROR: metrics: cannot parse %q: %s", smapsFilepath, err)
+		return
+	}
+	WriteGaugeUint64(w, "process_resident_anon_memory_bytes", sr.anonymous)
+	WriteGaugeUint64(w, "process_resident_file_memory_bytes", sr.file())
+	WriteGaugeUint64(w, "process_resident_shared_memory_bytes", sr.sharedClean+sr.sharedDirty)
+	WriteGaugeUint64(w, "process_swap_bytes", sr.swap)
+}
+
+// See https://www.kernel.org/doc/Documentation/ABI/testing/procfs-smaps_rollup
+type smapsRollup struct {
+	rss         uint64
+	anonymous   uint64
+	sharedClean uint64
+	sharedDirty uint64
+	swap        uint64
+}
+
+// file returns the size of resident memory pages, which aren't anonymous.
+func (sr *smapsRollup) file() uint64 {
+	if sr.rss < sr.anonymous {
+		return 0
+	}
+	return sr.rss - sr.anonymous
+}
+
+// parseSmapsRollup parses /proc/self/smaps_rollup contents from data.
+//
+// The header line with the address range and unknown fields are ignored.
+func parseSmapsRollup(data []byte) (*smapsRollup, error) {
+	var sr smapsRollup
+	for len(data) > 0 {
+		var line []byte
+		n := bytes.IndexByte(data, '\n')
+		if n >= 0 {
+			line = data[:n]
+			data = data[n+1:]
+		} else {
+			line = data
+			data = nil
+		}
+		n = bytes.IndexByte(line, ':')
+		if n < 0 {
+			continue
+		}
+		var dst *uint64
+		switch string(line[:n]) {
+		case "Rss":
+			dst = &sr.rss
+		case "Anonymous":
+			dst = &sr.anonymous
+		case "Shared_Clean":
+			dst = &sr.sharedClean
+		case "Shared_Dirty":
+			dst = &sr.sharedDirty
+		case "Swap":
+			dst = &sr.swap
+		default:
+			continue
+		}
+		value := bytes.TrimSpace(line[n+1:])
+		if !bytes.HasSuffix(value, []byte(" kB")) {
+			return nil, fmt.Errorf("expecting kB value in %q", line)
+		}
+		value = bytes.TrimSpace(value[:len(value)-len(" kB")])
+		v, err := strconv.ParseUint(string(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %q: %w", line, err)
+		}
+		*dst = v * 1024
+	}
+	return &sr, nil
+}
+
 // https://man7.org/linux/man-pages/man5/procfs.5.html
 type memStats struct {
 	vmPeak   uint64
@@ -1,6 +1,9 @@
 package metrics
 
-import "testing"
+import (
+	"os"
+	"testing"
+)
 
 func TestGetMaxFilesLimit(t *testing.T) {
 	f := func(want uint64, path string, wantErr bool) {
@@ -49,3 +52,92 @@ func TestGetMemStats(t *testing.T) {
 	f(memStats{vmPeak: 2130489344, rssPeak: 200679424, rssAnon: 121602048, rssFile: 11362304}, "testdata/status", false)
 	f(memStats{}, "testdata/status_bad", true)
 }
+
+func TestParseProcIO(t *testing.T) {
+	f := func(data string, want procIO, wantErr bool) {
+		t.Helper()
+		got, err := parseProcIO([]byte(data))
+		if (err != nil && !wantErr) || (err == nil && wantErr) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != nil && *got != want {
+			t.Fatalf("unexpected result: %+v, want: %+v at parseProcIO", *got, want)
+		}
+	}
+	f(`rchar: 4956
+wchar: 101
+syscr: 13
+syscw: 2
+read_bytes: 8192
+write_bytes: 4096
+cancelled_write_bytes: 0
+`, procIO{rchar: 4956, wchar: 101, syscr: 13, syscw: 2, readBytes: 8192, writeBytes: 4096}, false)
+
+	// Unknown fields and lines must be ignored
+	f("rchar: 1\nsome_future_field: 123\nunexpected line\nwchar: 2", procIO{rchar: 1, wchar: 2}, false)
+
+	// Empty data
+	f("", procIO{}, false)
+
+	// Invalid value for the known field
+	f("rchar: foobar\n", procIO{}, true)
+}
+
+func TestParseSmapsRollup(t *testing.T) {
+	f := func(data string, want smapsRollup, wantErr bool) {
+		t.Helper()
+		got, err := parseSmapsRollup([]byte(data))
+		if (err != nil && !wantErr) || (err == nil && wantErr) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != nil && *got != want {
+			t.Fatalf("unexpected result: %+v, want: %+v at parseSmapsRollup", *got, want)
+		}
+	}
+	data, err := os.ReadFile("testdata/smaps_rollup")
+	if err != nil {
+		t.Fatalf("cannot read testdata/smaps_rollup: %s", err)
+	}
+	f(string(data), smapsRollup{rss: 1273856, anonymous: 106496, sharedClean: 1044480, swap: 8192}, false)
+
+	// Unknown fields must be ignored
+	f("Rss: 2 kB\nSome_Future_Field: 5 kB\nAnonymous: 1 kB", smapsRollup{rss: 2048, anonymous: 1024}, false)
+
+	// Empty data
+	f("", smapsRollup{}, false)
+
+	// Invalid value for the known field
+	f("Rss: foobar kB\n", smapsRollup{}, true)
+
+	// Missing unit
+	f("Rss: 123\n", smapsRollup{}, true)
+}
+
+func TestSmapsRollupFile(t *testing.T) {
+	sr := smapsRollup{rss: 4096, anonymous: 1024}
+	if n := sr.file(); n != 3072 {
+		t.Fatalf("unexpected file memory; got %d; want %d", n, 3072)
+	}
+	sr = smapsRollup{rss: 1024, anonymous: 4096}
+	if n := sr.file(); n != 0 {
+		t.Fatalf("unexpected file memory; got %d; want %d", n, 0)
+	}
+}
+
+func TestReadFileToBuffer(t *testing.T) {
+	buf := make([]byte, 0, 1)
+	data, err := readFileToBuffer(buf, "testdata/limits")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dataExpected, err := os.ReadFile("testdata/limits")
+	if err != nil {
+		t.Fatalf("cannot read testdata/limits: %s", err)
+	}
+	if string(data) != string(dataExpected) {
+		t.Fatalf("unexpected data read;\ngot\n%s\nwant\n%s", data, dataExpected)
+	}
+	if _, err := readFileToBuffer(nil, "testdata/bad_path"); err == nil {
+		t.Fatalf("expecting non-nil error for missing file")
+	}
+}
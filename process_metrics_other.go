@@ -1,14 +1,20 @@
-//go:build !linux && !windows
-// +build !linux,!windows
+//go:build !linux && !windows && !darwin
+// +build !linux,!windows,!darwin
 
 package metrics
 
 import (
 	"io"
+	"time"
 )
 
+// startTimeSeconds is the approximate start time of the process, since the actual start time
+// cannot be obtained in a portable way on the current platform.
+var startTimeSeconds = time.Now().Unix()
+
 func writeProcessMetrics(w io.Writer) {
-	// TODO: implement it
+	// TODO: implement the remaining metrics
+	WriteGaugeUint64(w, "process_start_time_seconds", uint64(startTimeSeconds))
 }
 
 func writeFDMetrics(w io.Writer) {
@@ -4,6 +4,7 @@
 package metrics
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"syscall"
@@ -36,13 +37,42 @@ type processMemoryCounters struct {
 	PrivateUsage               uintptr
 }
 
+type processStats struct {
+	startTimeSeconds    uint64
+	stimeSeconds        float64
+	utimeSeconds        float64
+	pageFaults          uint64
+	privateBytes        uint64
+	workingSetBytes     uint64
+	peakWorkingSetBytes uint64
+	numThreads          uint64
+}
+
 func writeProcessMetrics(w io.Writer) {
+	ps, err := getProcessStats()
+	if err != nil {
+		log.Printf("ERROR: metrics: %s", err)
+		return
+	}
+	WriteCounterFloat64(w, "process_cpu_seconds_system_total", ps.stimeSeconds)
+	WriteCounterFloat64(w, "process_cpu_seconds_total", ps.stimeSeconds+ps.utimeSeconds)
+	WriteCounterFloat64(w, "process_cpu_seconds_user_total", ps.utimeSeconds)
+	WriteCounterUint64(w, "process_pagefaults_total", ps.pageFaults)
+	if ps.numThreads > 0 {
+		WriteGaugeUint64(w, "process_num_threads", ps.numThreads)
+	}
+	WriteGaugeUint64(w, "process_start_time_seconds", ps.startTimeSeconds)
+	WriteGaugeUint64(w, "process_virtual_memory_bytes", ps.privateBytes)
+	WriteGaugeUint64(w, "process_resident_memory_peak_bytes", ps.peakWorkingSetBytes)
+	WriteGaugeUint64(w, "process_resident_memory_bytes", ps.workingSetBytes)
+}
+
+func getProcessStats() (*processStats, error) {
 	h := windows.CurrentProcess()
 	var startTime, exitTime, stime, utime windows.Filetime
 	err := windows.GetProcessTimes(h, &startTime, &exitTime, &stime, &utime)
 	if err != nil {
-		log.Printf("ERROR: metrics: cannot read process times: %s", err)
-		return
+		return nil, fmt.Errorf("cannot read process times: %w", err)
 	}
 	var mc processMemoryCounters
 	r1, _, err := procGetProcessMemoryInfo.Call(
@@ -51,22 +81,77 @@ func writeProcessMetrics(w io.Writer) {
 		unsafe.Sizeof(mc),
 	)
 	if r1 != 1 {
-		log.Printf("ERROR: metrics: cannot read process memory information: %s", err)
-		return
+		return nil, fmt.Errorf("cannot read process memory information: %w", err)
+	}
+	numThreads, err := getThreadsCount()
+	if err != nil {
+		// The number of threads is optional, so do not fail the remaining metrics.
+		log.Printf("ERROR: metrics: %s", err)
+	}
+	ps := &processStats{
+		startTimeSeconds:    uint64(startTime.Nanoseconds()) / 1e9,
+		stimeSeconds:        filetimeDurationSeconds(stime),
+		utimeSeconds:        filetimeDurationSeconds(utime),
+		pageFaults:          uint64(mc.PageFaultCount),
+		privateBytes:        uint64(mc.PrivateUsage),
+		workingSetBytes:     uint64(mc.WorkingSetSize),
+		peakWorkingSetBytes: uint64(mc.PeakWorkingSetSize),
+		numThreads:          numThreads,
+	}
+	return ps, nil
+}
+
+// filetimeDurationSeconds converts ft containing the duration in 100-nanosecond intervals to seconds.
+func filetimeDurationSeconds(ft windows.Filetime) float64 {
+	return float64(uint64(ft.HighDateTime)<<32+uint64(ft.LowDateTime)) / 1e7
+}
+
+// getThreadsCount returns the number of threads in the current process.
+//
+// See https://learn.microsoft.com/en-us/windows/win32/toolhelp/traversing-the-thread-list
+func getThreadsCount() (uint64, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create threads snapshot: %w", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	pid := windows.GetCurrentProcessId()
+	var te windows.ThreadEntry32
+	te.Size = uint32(unsafe.Sizeof(te))
+	if err := windows.Thread32First(snapshot, &te); err != nil {
+		return 0, fmt.Errorf("cannot read the first thread from snapshot: %w", err)
+	}
+	n := uint64(0)
+	for {
+		if te.OwnerProcessID == pid {
+			n++
+		}
+		err := windows.Thread32Next(snapshot, &te)
+		if err == windows.ERROR_NO_MORE_FILES {
+			return n, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("cannot read the next thread from snapshot: %w", err)
+		}
 	}
-	stimeSeconds := float64(uint64(stime.HighDateTime)<<32+uint64(stime.LowDateTime)) / 1e7
-	utimeSeconds := float64(uint64(utime.HighDateTime)<<32+uint64(utime.LowDateTime)) / 1e7
-	WriteCounterFloat64(w, "process_cpu_seconds_system_total", stimeSeconds)
-	WriteCounterFloat64(w, "process_cpu_seconds_total", stimeSeconds+utimeSeconds)
-	WriteCounterFloat64(w, "process_cpu_seconds_user_total", stimeSeconds)
-	WriteCounterUint64(w, "process_pagefaults_total", uint64(mc.PageFaultCount))
-	WriteGaugeUint64(w, "process_start_time_seconds", uint64(startTime.Nanoseconds())/1e9)
-	WriteGaugeUint64(w, "process_virtual_memory_bytes", uint64(mc.PrivateUsage))
-	WriteGaugeUint64(w, "process_resident_memory_peak_bytes", uint64(mc.PeakWorkingSetSize))
-	WriteGaugeUint64(w, "process_resident_memory_bytes", uint64(mc.WorkingSetSize))
 }
 
 func writeFDMetrics(w io.Writer) {
+	count, err := getOpenHandlesCount()
+	if err != nil {
+		log.Printf("ERROR: metrics: %s", err)
+		return
+	}
+	// it seems to be hard-coded limit for 64-bit systems
+	// https://learn.microsoft.com/en-us/archive/blogs/markrussinovich/pushing-the-limits-of-windows-handles#maximum-number-of-handles
+	WriteGaugeUint64(w, "process_max_fds", 16777216)
+	WriteGaugeUint64(w, "process_open_fds", count)
+	// Windows has handles instead of file descriptors, so expose them under the native name too.
+	WriteGaugeUint64(w, "process_open_handles", count)
+}
+
+func getOpenHandlesCount() (uint64, error) {
 	h := windows.CurrentProcess()
 	var count uint32
 	r1, _, err := procGetProcessHandleCount.Call(
@@ -74,11 +159,7 @@ func writeFDMetrics(w io.Writer) {
 		uintptr(unsafe.Pointer(&count)),
 	)
 	if r1 != 1 {
-		log.Printf("ERROR: metrics: cannot determine open file descriptors count: %s", err)
-		return
+		return 0, fmt.Errorf("cannot determine open handles count: %w", err)
 	}
-	// it seems to be hard-coded limit for 64-bit systems
-	// https://learn.microsoft.com/en-us/archive/blogs/markrussinovich/pushing-the-limits-of-windows-handles#maximum-number-of-handles
-	WriteGaugeUint64(w, "process_max_fds", 16777216)
-	WriteGaugeUint64(w, "process_open_fds", uint64(count))
+	return uint64(count), nil
 }
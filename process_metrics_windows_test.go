@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetProcessStats(t *testing.T) {
+	// Burn some CPU, so the process CPU time becomes non-zero.
+	deadline := time.Now().Add(100 * time.Millisecond)
+	n := 0
+	for time.Now().Before(deadline) {
+		n++
+	}
+
+	ps, err := getProcessStats()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cpuSeconds := ps.stimeSeconds + ps.utimeSeconds; cpuSeconds <= 0 {
+		t.Fatalf("expecting non-zero CPU time; got %v", cpuSeconds)
+	}
+	if ps.workingSetBytes == 0 {
+		t.Fatalf("expecting non-zero resident memory")
+	}
+	if ps.privateBytes == 0 {
+		t.Fatalf("expecting non-zero virtual memory")
+	}
+	if ps.numThreads == 0 {
+		t.Fatalf("expecting non-zero number of threads")
+	}
+	if ps.startTimeSeconds == 0 || ps.startTimeSeconds > uint64(time.Now().Unix()) {
+		t.Fatalf("unexpected process start time: %d", ps.startTimeSeconds)
+	}
+}
+
+func TestGetOpenHandlesCount(t *testing.T) {
+	n, err := getOpenHandlesCount()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n == 0 {
+		t.Fatalf("expecting non-zero number of open handles")
+	}
+}
+
+func TestWriteProcessMetricsWindows(t *testing.T) {
+	var bb bytes.Buffer
+	WriteProcessMetrics(&bb)
+	WriteFDMetrics(&bb)
+	result := bb.String()
+	for _, name := range []string{
+		"process_cpu_seconds_total",
+		"process_resident_memory_bytes",
+		"process_virtual_memory_bytes",
+		"process_start_time_seconds",
+		"process_num_threads",
+		"process_open_handles",
+	} {
+		if !strings.Contains(result, "\n"+name+" ") {
+			t.Fatalf("missing %s in the output:\n%s", name, result)
+		}
+	}
+}
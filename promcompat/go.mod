@@ -0,0 +1,29 @@
+module github.com/VictoriaMetrics/metrics/promcompat
+
+go 1.19
+
+require (
+	github.com/VictoriaMetrics/metrics v1.32.0
+	github.com/prometheus/client_golang v1.17.0
+	github.com/prometheus/common v0.44.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/procfs v0.11.1 // indirect
+	github.com/valyala/fastrand v1.1.0 // indirect
+	github.com/valyala/histogram v1.2.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
+
+// The replace directive builds the module against the local copy of github.com/VictoriaMetrics/metrics
+// during development in this repository. It is ignored when the module is used as a dependency,
+// so the require directive above must reference a released version of the root module with all the APIs used here.
+// Tag the root module before tagging this module after adding new root APIs to it.
+replace github.com/VictoriaMetrics/metrics => ../
@@ -0,0 +1,105 @@
+// Package promcompat allows exposing metrics from github.com/prometheus/client_golang collectors
+// together with metrics from github.com/VictoriaMetrics/metrics package.
+//
+// The package is located in a separate module, so github.com/VictoriaMetrics/metrics users
+// do not depend on github.com/prometheus/client_golang unless they need this package.
+package promcompat
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// WriteGathered writes metrics gathered from g to w in Prometheus text exposition format.
+//
+// Histograms, summaries, labels and timestamps from the gathered metric families are preserved.
+//
+// Metric families gathered before the error are written to w even if g returns an error.
+func WriteGathered(w io.Writer, g prometheus.Gatherer) error {
+	mfs, gatherErr := g.Gather()
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return fmt.Errorf("cannot write metric family %q: %w", mf.GetName(), err)
+		}
+	}
+	if gatherErr != nil {
+		return fmt.Errorf("cannot gather metrics: %w", gatherErr)
+	}
+	return nil
+}
+
+// RegisterPrometheusCollector registers c for exposing its metrics in the output generated by metrics.WritePrometheus.
+//
+// Call Close on the returned MetricsWriter for stopping exposing metrics from c.
+//
+// See RegisterGatherer for details.
+func RegisterPrometheusCollector(c prometheus.Collector) (*metrics.MetricsWriter, error) {
+	r := prometheus.NewRegistry()
+	if err := r.Register(c); err != nil {
+		return nil, fmt.Errorf("cannot register collector: %w", err)
+	}
+	return RegisterGatherer(metrics.GetDefaultSet(), r), nil
+}
+
+// RegisterGatherer registers g for exposing its metrics in the output generated by s.WritePrometheus.
+//
+// Metrics are gathered from g at every s.WritePrometheus call.
+// Metric families with the same names as metrics registered in s are skipped, since they result in duplicate time series.
+// Such name collisions and errors returned by g are logged only once per metric family or error message.
+//
+// Call Close on the returned MetricsWriter for stopping exposing metrics from g.
+func RegisterGatherer(s *metrics.Set, g prometheus.Gatherer) *metrics.MetricsWriter {
+	gw := &gathererWriter{
+		s: s,
+		g: g,
+	}
+	return s.RegisterMetricsWriter(gw.writeMetrics)
+}
+
+type gathererWriter struct {
+	s *metrics.Set
+	g prometheus.Gatherer
+
+	// reported contains already logged collisions and errors.
+	reported sync.Map
+}
+
+func (gw *gathererWriter) writeMetrics(w io.Writer) {
+	mfs, err := gw.g.Gather()
+	if err != nil {
+		gw.reportOnce(fmt.Sprintf("ERROR: metrics.promcompat: cannot gather metrics: %s", err))
+	}
+	if len(mfs) == 0 {
+		return
+	}
+	nativeFamilies := make(map[string]struct{})
+	for _, name := range gw.s.ListMetricNames() {
+		if n := strings.IndexByte(name, '{'); n >= 0 {
+			name = name[:n]
+		}
+		nativeFamilies[name] = struct{}{}
+	}
+	for _, mf := range mfs {
+		name := mf.GetName()
+		if _, ok := nativeFamilies[name]; ok {
+			gw.reportOnce(fmt.Sprintf("ERROR: metrics.promcompat: skipping metric family %q, since it collides with the metric registered in github.com/VictoriaMetrics/metrics", name))
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			gw.reportOnce(fmt.Sprintf("ERROR: metrics.promcompat: cannot write metric family %q: %s", name, err))
+		}
+	}
+}
+
+func (gw *gathererWriter) reportOnce(msg string) {
+	if _, loaded := gw.reported.LoadOrStore(msg, struct{}{}); !loaded {
+		log.Print(msg)
+	}
+}
@@ -0,0 +1,85 @@
+package promcompat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterGatherer(t *testing.T) {
+	r := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "requests_total",
+		Help:        "The number of requests",
+		ConstLabels: prometheus.Labels{"app": "x"},
+	}, []string{"path"})
+	c.WithLabelValues("/foo").Add(3)
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "duration_seconds",
+		Buckets: []float64{0.1, 1},
+	})
+	h.Observe(0.5)
+	sm := prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "size_bytes",
+		Objectives: map[float64]float64{0.5: 0.05},
+	})
+	sm.Observe(10)
+	collision := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "native_metric",
+	})
+	r.MustRegister(c, h, sm, collision)
+
+	s := metrics.NewSet()
+	s.NewCounter(`native_metric{a="b"}`).Inc()
+	mw := RegisterGatherer(s, r)
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	for _, line := range []string{
+		`native_metric{a="b"} 1`,
+		`requests_total{app="x",path="/foo"} 3`,
+		`duration_seconds_bucket{le="0.1"} 0`,
+		`duration_seconds_bucket{le="1"} 1`,
+		`duration_seconds_bucket{le="+Inf"} 1`,
+		`duration_seconds_sum 0.5`,
+		`duration_seconds_count 1`,
+		`size_bytes{quantile="0.5"} 10`,
+		`size_bytes_sum 10`,
+		`size_bytes_count 1`,
+	} {
+		if !strings.Contains(result, line+"\n") {
+			t.Fatalf("missing %q in the output:\n%s", line, result)
+		}
+	}
+	if strings.Contains(result, "native_metric 0") {
+		t.Fatalf("colliding metric family must be skipped; got\n%s", result)
+	}
+
+	mw.Close()
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if result := bb.String(); result != `native_metric{a="b"} 1`+"\n" {
+		t.Fatalf("unexpected output after Close:\n%s", result)
+	}
+}
+
+func TestWriteGathered(t *testing.T) {
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "foo",
+	})
+	g.Set(-1.5)
+	r.MustRegister(g)
+
+	var bb bytes.Buffer
+	if err := WriteGathered(&bb, r); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result := bb.String(); !strings.Contains(result, "foo -1.5\n") {
+		t.Fatalf("missing gauge in the output:\n%s", result)
+	}
+}
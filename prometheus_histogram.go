@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// PrometheusHistogram is a histogram with user-defined upper bounds for classic Prometheus buckets with `le` labels.
+//
+// Every bucket is exposed via the following metric:
+//
+//	<metric_name>_bucket{<optional_tags>,le="<upper_bound>"} <cumulative_counter>
+//
+// The last bucket has `le="+Inf"` label. Additionally, `<metric_name>_sum` and `<metric_name>_count` metrics are exposed.
+//
+// Prefer Histogram if there is no need in compatibility with the existing `le`-based queries and alerting rules,
+// since Histogram doesn't need tuning for bucket bounds.
+//
+// PrometheusHistogram is updated with atomic operations, so concurrent updates do not block each other.
+type PrometheusHistogram struct {
+	// sumBits contains uint64 representation of float64 sum of all the observed values.
+	sumBits atomicUint64
+
+	// upperBounds contains sorted upper bounds for the buckets without +Inf.
+	upperBounds []float64
+
+	// leLabels contains `le="..."` labels for the buckets including the last +Inf bucket.
+	leLabels []string
+
+	// buckets contains non-cumulative counters per bucket. The last counter is for the +Inf bucket.
+	buckets []uint64
+
+	statsd statsdMirror
+
+	created createdTimestamp
+}
+
+// NewHistogramWithBuckets creates and returns new PrometheusHistogram with the given name and upperBounds.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// upperBounds must be sorted in strictly increasing order. The +Inf bucket is added automatically.
+//
+// The returned histogram is safe to use from concurrent goroutines.
+func NewHistogramWithBuckets(name string, upperBounds []float64) *PrometheusHistogram {
+	return defaultSet.NewHistogramWithBuckets(name, upperBounds)
+}
+
+// GetOrCreateHistogramWithBuckets returns registered PrometheusHistogram with the given name and upperBounds
+// or creates new histogram if the registry doesn't contain histogram with the given name.
+//
+// It panics if the registered histogram has distinct upperBounds.
+//
+// See NewHistogramWithBuckets for details.
+//
+// Performance tip: prefer NewHistogramWithBuckets instead of GetOrCreateHistogramWithBuckets.
+func GetOrCreateHistogramWithBuckets(name string, upperBounds []float64) *PrometheusHistogram {
+	return defaultSet.GetOrCreateHistogramWithBuckets(name, upperBounds)
+}
+
+func newPrometheusHistogram(upperBounds []float64) *PrometheusHistogram {
+	if err := validateUpperBounds(upperBounds); err != nil {
+		panic(fmt.Errorf("BUG: %s", err))
+	}
+	if n := len(upperBounds); n > 0 && math.IsInf(upperBounds[n-1], 1) {
+		// The +Inf bucket is added automatically.
+		upperBounds = upperBounds[:n-1]
+	}
+	upperBounds = append([]float64{}, upperBounds...)
+	leLabels := make([]string, 0, len(upperBounds)+1)
+	for _, bound := range upperBounds {
+		leLabels = append(leLabels, fmt.Sprintf(`le="%s"`, strconv.FormatFloat(bound, 'g', -1, 64)))
+	}
+	leLabels = append(leLabels, `le="+Inf"`)
+	return &PrometheusHistogram{
+		upperBounds: upperBounds,
+		leLabels:    leLabels,
+		buckets:     make([]uint64, len(leLabels)),
+	}
+}
+
+func validateUpperBounds(upperBounds []float64) error {
+	if len(upperBounds) == 0 {
+		return fmt.Errorf("upperBounds cannot be empty")
+	}
+	for i, bound := range upperBounds {
+		if math.IsNaN(bound) {
+			return fmt.Errorf("upperBounds cannot contain NaN")
+		}
+		if i > 0 && bound <= upperBounds[i-1] {
+			return fmt.Errorf("upperBounds must be sorted in strictly increasing order; got %g after %g", bound, upperBounds[i-1])
+		}
+	}
+	return nil
+}
+
+// Update updates ph with v.
+//
+// NaNs are ignored.
+func (ph *PrometheusHistogram) Update(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	// sort.SearchFloat64s returns the index of the first bound, which is greater or equal to v.
+	// This matches `le` semantics. Values bigger than all the bounds go to the +Inf bucket.
+	idx := sort.SearchFloat64s(ph.upperBounds, v)
+	atomic.AddUint64(&ph.buckets[idx], 1)
+	ph.addSum(v)
+	if ss := ph.statsd.load(); ss != nil {
+		ss.sendHistogram(v)
+	}
+}
+
+// UpdateBatch updates ph with all the values.
+//
+// It is equivalent to calling Update for every value, but the sum is updated with a single atomic operation per batch.
+//
+// Concurrent readers may observe partially applied batch.
+func (ph *PrometheusHistogram) UpdateBatch(values []float64) {
+	ss := ph.statsd.load()
+	sum := float64(0)
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		idx := sort.SearchFloat64s(ph.upperBounds, v)
+		atomic.AddUint64(&ph.buckets[idx], 1)
+		sum += v
+		if ss != nil {
+			ss.sendHistogram(v)
+		}
+	}
+	ph.addSum(sum)
+}
+
+// UpdateWithCount updates ph with count observations of v.
+//
+// It is equivalent to calling Update(v) count times, but it takes O(1) time. This is useful for importing
+// pre-aggregated data.
+func (ph *PrometheusHistogram) UpdateWithCount(v float64, count uint64) {
+	if math.IsNaN(v) || count == 0 {
+		return
+	}
+	idx := sort.SearchFloat64s(ph.upperBounds, v)
+	atomic.AddUint64(&ph.buckets[idx], count)
+	ph.addSum(v * float64(count))
+	if ss := ph.statsd.load(); ss != nil {
+		ss.sendHistogramWithCount(v, count)
+	}
+}
+
+func (ph *PrometheusHistogram) addSum(v float64) {
+	for {
+		bits := ph.sumBits.Load()
+		bitsNew := math.Float64bits(math.Float64frombits(bits) + v)
+		if ph.sumBits.CompareAndSwap(bits, bitsNew) {
+			return
+		}
+	}
+}
+
+// UpdateDuration updates request duration based on the given startTime.
+func (ph *PrometheusHistogram) UpdateDuration(startTime time.Time) {
+	d := time.Since(startTime).Seconds()
+	ph.Update(d)
+}
+
+// MarshalTo writes ph with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (ph *PrometheusHistogram) MarshalTo(prefix string, w io.Writer) {
+	ph.marshalTo(prefix, w)
+}
+
+func (ph *PrometheusHistogram) marshalTo(prefix string, w io.Writer) {
+	countTotal := uint64(0)
+	for i, leLabel := range ph.leLabels {
+		countTotal += atomic.LoadUint64(&ph.buckets[i])
+		writeBucketSample(w, prefix, leLabel, countTotal)
+	}
+	sum := math.Float64frombits(ph.sumBits.Load())
+	writeSumAndCount(w, prefix, sum, countTotal, nil)
+}
+
+func (ph *PrometheusHistogram) metricType() string {
+	return "histogram"
+}
+
+func (ph *PrometheusHistogram) getStatsDMirror(_ bool) *statsdMirror {
+	return &ph.statsd
+}
+
+func (ph *PrometheusHistogram) hasUpperBounds(upperBounds []float64) bool {
+	if n := len(upperBounds); n > 0 && math.IsInf(upperBounds[n-1], 1) {
+		upperBounds = upperBounds[:n-1]
+	}
+	if len(upperBounds) != len(ph.upperBounds) {
+		return false
+	}
+	for i, bound := range upperBounds {
+		if bound != ph.upperBounds[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestPrometheusHistogramSerial(t *testing.T) {
+	s := NewSet()
+	ph := s.NewHistogramWithBuckets(`request_duration_seconds{path="/foo"}`, []float64{0.1, 0.5, 1})
+
+	// Verify the output for empty histogram
+	testMarshalTo(t, ph, `request_duration_seconds{path="/foo"}`, `request_duration_seconds_bucket{path="/foo",le="0.1"} 0
+request_duration_seconds_bucket{path="/foo",le="0.5"} 0
+request_duration_seconds_bucket{path="/foo",le="1"} 0
+request_duration_seconds_bucket{path="/foo",le="+Inf"} 0
+request_duration_seconds_sum{path="/foo"} 0
+request_duration_seconds_count{path="/foo"} 0
+`)
+
+	for _, v := range []float64{0.25, 0.1, 0.25, 2, math.NaN(), -1} {
+		ph.Update(v)
+	}
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `request_duration_seconds_bucket{path="/foo",le="0.1"} 2
+request_duration_seconds_bucket{path="/foo",le="0.5"} 4
+request_duration_seconds_bucket{path="/foo",le="1"} 4
+request_duration_seconds_bucket{path="/foo",le="+Inf"} 5
+request_duration_seconds_sum{path="/foo"} 1.6
+request_duration_seconds_count{path="/foo"} 5
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Verify the output without labels and with explicit +Inf bound
+	ph = s.NewHistogramWithBuckets("foo", []float64{1, math.Inf(1)})
+	ph.Update(1)
+	testMarshalTo(t, ph, "foo", `foo_bucket{le="1"} 1
+foo_bucket{le="+Inf"} 1
+foo_sum 1
+foo_count 1
+`)
+}
+
+func TestPrometheusHistogramInvalidBounds(t *testing.T) {
+	f := func(upperBounds []float64) {
+		t.Helper()
+		expectPanic(t, "NewHistogramWithBuckets", func() {
+			s := NewSet()
+			s.NewHistogramWithBuckets("foo", upperBounds)
+		})
+	}
+	f(nil)
+	f([]float64{})
+	f([]float64{1, 1})
+	f([]float64{2, 1})
+	f([]float64{1, math.NaN()})
+}
+
+func TestGetOrCreateHistogramWithBuckets(t *testing.T) {
+	s := NewSet()
+	ph := s.GetOrCreateHistogramWithBuckets("foo", []float64{1, 2})
+	if ph2 := s.GetOrCreateHistogramWithBuckets("foo", []float64{1, 2}); ph2 != ph {
+		t.Fatalf("GetOrCreateHistogramWithBuckets must return the same histogram for the same name")
+	}
+	expectPanic(t, "GetOrCreateHistogramWithBuckets_distinct_bounds", func() {
+		s.GetOrCreateHistogramWithBuckets("foo", []float64{1, 3})
+	})
+	s.NewCounter("bar")
+	expectPanic(t, "GetOrCreateHistogramWithBuckets_counter", func() {
+		s.GetOrCreateHistogramWithBuckets("bar", []float64{1})
+	})
+}
+
+func TestPrometheusHistogramConcurrent(t *testing.T) {
+	s := NewSet()
+	ph := s.NewHistogramWithBuckets("foo", []float64{1, 10})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ph.Update(float64(j % 20))
+			}
+		}()
+	}
+	wg.Wait()
+	testMarshalTo(t, ph, "foo", `foo_bucket{le="1"} 50
+foo_bucket{le="10"} 275
+foo_bucket{le="+Inf"} 500
+foo_sum 4750
+foo_count 500
+`)
+}
+
+func TestPrometheusHistogramUpdateBatch(t *testing.T) {
+	s := NewSet()
+	ph := s.NewHistogramWithBuckets("foo", []float64{0.1, 0.5, 1})
+	ph.UpdateBatch([]float64{0.25, 0.1, math.NaN(), 2})
+	ph.UpdateWithCount(0.25, 0)
+	ph.UpdateWithCount(math.NaN(), 2)
+	ph.UpdateWithCount(0.75, 4)
+	testMarshalTo(t, ph, "foo", `foo_bucket{le="0.1"} 1
+foo_bucket{le="0.5"} 2
+foo_bucket{le="1"} 6
+foo_bucket{le="+Inf"} 7
+foo_sum 5.35
+foo_count 7
+`)
+}
@@ -0,0 +1,388 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// WriteProtobuf writes all the metrics from the default set and all the added sets to w
+// in Prometheus protobuf exposition format with delimited io.prometheus.client.MetricFamily messages.
+//
+// See https://github.com/prometheus/client_model/blob/master/io/prometheus/client/metrics.proto
+//
+// This format is required for exposing NativeHistogram as Prometheus native histogram.
+// Histogram and PrometheusHistogram are exposed as classic histograms with `le` buckets.
+// Metrics written via RegisterMetricsWriter and process metrics are exposed as untyped metrics.
+//
+// If exposeProcessMetrics is true, then various `go_*` and `process_*` metrics
+// are exposed for the current process.
+//
+// See also Handler, which selects the exposition format depending on the Accept request header.
+func WriteProtobuf(w io.Writer, exposeProcessMetrics bool) {
+	WritePrometheusProto(w, getLegacyWritePrometheusOpts(exposeProcessMetrics))
+}
+
+// WritePrometheusProto writes all the metrics from the default set and all the added sets to w
+// plus the metrics for the current process enabled in opts in Prometheus protobuf exposition format
+// with delimited io.prometheus.client.MetricFamily messages.
+//
+// Labels embedded into metric names are written as LabelPair messages. Metrics with the same base name
+// from all the sets are grouped into a single MetricFamily message. opts.ExtraLabels are added to every metric
+// unless the metric already has a label with the same name.
+//
+// See WriteProtobuf for details.
+func WritePrometheusProto(w io.Writer, opts WritePrometheusOpts) {
+	if opts.HostnameLabel != "" {
+		if err := validateIdent(opts.HostnameLabel); err != nil {
+			panic(fmt.Errorf("BUG: invalid HostnameLabel %q: %w", opts.HostnameLabel, err))
+		}
+	}
+	sets := getRegisteredSets()
+	runPreWriteHooks(sets)
+	pfs := protobufFamilies{
+		extraLabels: getSortedExtraLabels(opts.ExtraLabels),
+	}
+	for _, s := range sets {
+		s.addProtobufFamilies(&pfs)
+	}
+	bb := getBytesBuffer()
+	writeOptionalProcessMetrics(bb, &opts)
+	pfs.addTextMetrics(bb.B)
+	putBytesBuffer(bb)
+	pfs.writeTo(w)
+}
+
+// WriteProtobuf writes all the metrics from s to w in Prometheus protobuf exposition format.
+//
+// See WriteProtobuf for details.
+func (s *Set) WriteProtobuf(w io.Writer) {
+	s.runPreWriteHooks()
+	var pfs protobufFamilies
+	s.addProtobufFamilies(&pfs)
+	pfs.writeTo(w)
+}
+
+func (s *Set) addProtobufFamilies(pfs *protobufFamilies) {
+	sa, metricsWriters := s.getSortedMetrics()
+	var labels []label
+	for _, nm := range sa {
+		if nm.isAux {
+			// Auxiliary metrics such as summary quantiles are exposed by the parent metric.
+			continue
+		}
+		pm, ok := nm.metric.(protobufMarshaler)
+		if !ok {
+			bb := getBytesBuffer()
+			nm.metric.marshalTo(nm.name, bb)
+			pfs.addTextMetrics(bb.B)
+			putBytesBuffer(bb)
+			continue
+		}
+		name, labelsStr := splitMetricName(nm.name)
+		labels = labels[:0]
+		if labelsStr != "" {
+			var err error
+			labels, _, err = parseLabels(labels, labelsStr[1:])
+			if err != nil {
+				// nm.name is validated on registration, so this must be impossible.
+				continue
+			}
+		}
+		pf := pfs.getFamily(name, nm.getHelp(), getProtobufMetricType(nm.metric.metricType()))
+		pf.metrics = appendProtobufMessage(pf.metrics, 4, func(dst []byte) []byte {
+			dst = pfs.appendLabels(dst, labels)
+			return pm.marshalProtobuf(dst)
+		})
+	}
+	if len(metricsWriters) > 0 {
+		bb := getBytesBuffer()
+		for _, mw := range metricsWriters {
+			mw.write(bb)
+		}
+		pfs.addTextMetrics(bb.B)
+		putBytesBuffer(bb)
+	}
+}
+
+// protobufMarshaler must be implemented by metrics, which support Prometheus protobuf exposition format.
+type protobufMarshaler interface {
+	// marshalProtobuf must append the value field for io.prometheus.client.Metric message to dst.
+	marshalProtobuf(dst []byte) []byte
+}
+
+// Values for io.prometheus.client.MetricType enum.
+const (
+	protobufTypeCounter   = 0
+	protobufTypeGauge     = 1
+	protobufTypeSummary   = 2
+	protobufTypeUntyped   = 3
+	protobufTypeHistogram = 4
+)
+
+func getProtobufMetricType(metricType string) uint64 {
+	switch metricType {
+	case "counter":
+		return protobufTypeCounter
+	case "gauge":
+		return protobufTypeGauge
+	case "summary":
+		return protobufTypeSummary
+	case "histogram":
+		return protobufTypeHistogram
+	default:
+		return protobufTypeUntyped
+	}
+}
+
+// protobufFamilies groups metrics into io.prometheus.client.MetricFamily messages by metric name.
+type protobufFamilies struct {
+	a []*protobufFamily
+	m map[string]*protobufFamily
+
+	// extraLabels are added to every metric, which doesn't have labels with the same names.
+	extraLabels []label
+}
+
+type protobufFamily struct {
+	name       string
+	help       string
+	metricType uint64
+
+	// metrics contains marshaled `repeated Metric metric = 4` fields.
+	metrics []byte
+}
+
+func (pfs *protobufFamilies) getFamily(name, help string, metricType uint64) *protobufFamily {
+	if pfs.m == nil {
+		pfs.m = make(map[string]*protobufFamily)
+	}
+	pf := pfs.m[name]
+	if pf == nil {
+		pf = &protobufFamily{
+			name:       name,
+			metricType: metricType,
+		}
+		pfs.m[name] = pf
+		pfs.a = append(pfs.a, pf)
+	}
+	if pf.help == "" {
+		pf.help = help
+	}
+	return pf
+}
+
+// addTextMetrics adds metrics in Prometheus text exposition format from src to pfs as untyped metrics.
+func (pfs *protobufFamilies) addTextMetrics(src []byte) {
+	_ = forEachSample(src, func(ps *parsedSample) {
+		pf := pfs.getFamily(ps.metricName, "", protobufTypeUntyped)
+		pf.metrics = appendProtobufMessage(pf.metrics, 4, func(dst []byte) []byte {
+			dst = pfs.appendLabels(dst, ps.labels)
+			// Metric.untyped
+			return appendProtobufMessage(dst, 5, func(dst []byte) []byte {
+				return appendProtobufDouble(dst, 1, ps.value)
+			})
+		})
+	})
+}
+
+// writeTo writes all the families from pfs to w as length-delimited MetricFamily messages.
+func (pfs *protobufFamilies) writeTo(w io.Writer) {
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	for _, pf := range pfs.a {
+		bb.B = appendProtobufDelimited(bb.B, func(dst []byte) []byte {
+			dst = appendProtobufString(dst, 1, pf.name)
+			if pf.help != "" {
+				dst = appendProtobufString(dst, 2, pf.help)
+			}
+			dst = appendProtobufVarint(dst, 3, pf.metricType)
+			return append(dst, pf.metrics...)
+		})
+	}
+	w.Write(bb.B)
+}
+
+// appendLabels appends labels plus the missing pfs.extraLabels as Metric.label fields to dst.
+func (pfs *protobufFamilies) appendLabels(dst []byte, labels []label) []byte {
+	dst = appendProtobufLabels(dst, labels)
+	for _, el := range pfs.extraLabels {
+		if !hasLabel(labels, el.name) {
+			dst = appendProtobufLabels(dst, []label{el})
+		}
+	}
+	return dst
+}
+
+func appendProtobufLabels(dst []byte, labels []label) []byte {
+	for _, l := range labels {
+		// Metric.label
+		dst = appendProtobufMessage(dst, 1, func(dst []byte) []byte {
+			dst = appendProtobufString(dst, 1, l.name)
+			return appendProtobufString(dst, 2, l.value)
+		})
+	}
+	return dst
+}
+
+func (c *Counter) marshalProtobuf(dst []byte) []byte {
+	// Metric.counter
+	return appendProtobufMessage(dst, 3, func(dst []byte) []byte {
+		return appendProtobufDouble(dst, 1, float64(c.Get()))
+	})
+}
+
+func (fc *FloatCounter) marshalProtobuf(dst []byte) []byte {
+	// Metric.counter
+	return appendProtobufMessage(dst, 3, func(dst []byte) []byte {
+		return appendProtobufDouble(dst, 1, fc.Get())
+	})
+}
+
+func (g *Gauge) marshalProtobuf(dst []byte) []byte {
+	// Metric.gauge
+	return appendProtobufMessage(dst, 2, func(dst []byte) []byte {
+		return appendProtobufDouble(dst, 1, g.Get())
+	})
+}
+
+func (sm *Summary) marshalProtobuf(dst []byte) []byte {
+	sm.mu.Lock()
+	sum, count := sm.sumAndCountLocked()
+	quantileValues := append([]float64{}, sm.quantileValues...)
+	sm.mu.Unlock()
+
+	// Metric.summary
+	return appendProtobufMessage(dst, 4, func(dst []byte) []byte {
+		dst = appendProtobufVarint(dst, 1, count)
+		dst = appendProtobufDouble(dst, 2, sum)
+		for i, q := range sm.quantiles {
+			if i >= len(quantileValues) {
+				break
+			}
+			v := quantileValues[i]
+			// Summary.quantile
+			dst = appendProtobufMessage(dst, 3, func(dst []byte) []byte {
+				dst = appendProtobufDouble(dst, 1, q)
+				return appendProtobufDouble(dst, 2, v)
+			})
+		}
+		return dst
+	})
+}
+
+func (h *Histogram) marshalProtobuf(dst []byte) []byte {
+	type bucket struct {
+		upperBound      float64
+		cumulativeCount uint64
+	}
+	var buckets []bucket
+	countTotal := uint64(0)
+	sum := h.visitNonZeroBuckets(func(vmrange string, count uint64) {
+		countTotal += count
+		le := vmrange[strings.Index(vmrange, "...")+len("..."):]
+		upperBound, err := strconv.ParseFloat(le, 64)
+		if err != nil || math.IsInf(upperBound, 1) {
+			// The +Inf bucket is implicitly defined by sample_count.
+			return
+		}
+		buckets = append(buckets, bucket{
+			upperBound:      upperBound,
+			cumulativeCount: countTotal,
+		})
+	})
+
+	// Metric.histogram
+	return appendProtobufMessage(dst, 7, func(dst []byte) []byte {
+		dst = appendProtobufVarint(dst, 1, countTotal)
+		dst = appendProtobufDouble(dst, 2, sum)
+		for _, b := range buckets {
+			dst = appendProtobufBucket(dst, b.cumulativeCount, b.upperBound)
+		}
+		return dst
+	})
+}
+
+func (ph *PrometheusHistogram) marshalProtobuf(dst []byte) []byte {
+	// Metric.histogram
+	return appendProtobufMessage(dst, 7, func(dst []byte) []byte {
+		countTotal := uint64(0)
+		for i, upperBound := range ph.upperBounds {
+			countTotal += atomic.LoadUint64(&ph.buckets[i])
+			dst = appendProtobufBucket(dst, countTotal, upperBound)
+		}
+		countTotal += atomic.LoadUint64(&ph.buckets[len(ph.upperBounds)])
+		sum := math.Float64frombits(ph.sumBits.Load())
+		dst = appendProtobufVarint(dst, 1, countTotal)
+		return appendProtobufDouble(dst, 2, sum)
+	})
+}
+
+func appendProtobufBucket(dst []byte, cumulativeCount uint64, upperBound float64) []byte {
+	// Histogram.bucket
+	return appendProtobufMessage(dst, 3, func(dst []byte) []byte {
+		dst = appendProtobufVarint(dst, 1, cumulativeCount)
+		return appendProtobufDouble(dst, 2, upperBound)
+	})
+}
+
+// Protobuf wire types.
+const (
+	protobufWireVarint  = 0
+	protobufWireFixed64 = 1
+	protobufWireBytes   = 2
+)
+
+func appendProtobufTag(dst []byte, field, wireType int) []byte {
+	return marshalVarUint64(dst, uint64(field<<3|wireType))
+}
+
+func appendProtobufVarint(dst []byte, field int, v uint64) []byte {
+	dst = appendProtobufTag(dst, field, protobufWireVarint)
+	return marshalVarUint64(dst, v)
+}
+
+func appendProtobufSint(dst []byte, field int, v int64) []byte {
+	dst = appendProtobufTag(dst, field, protobufWireVarint)
+	return marshalVarUint64(dst, zigzagEncode(v))
+}
+
+func appendProtobufDouble(dst []byte, field int, v float64) []byte {
+	dst = appendProtobufTag(dst, field, protobufWireFixed64)
+	return marshalFixed64(dst, math.Float64bits(v))
+}
+
+func appendProtobufString(dst []byte, field int, s string) []byte {
+	dst = appendProtobufTag(dst, field, protobufWireBytes)
+	dst = marshalVarUint64(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// appendProtobufMessage appends the embedded message generated by f for the given field to dst.
+func appendProtobufMessage(dst []byte, field int, f func(dst []byte) []byte) []byte {
+	dst = appendProtobufTag(dst, field, protobufWireBytes)
+	return appendProtobufDelimited(dst, f)
+}
+
+// appendProtobufDelimited appends the message generated by f prefixed with its varint-encoded length to dst.
+func appendProtobufDelimited(dst []byte, f func(dst []byte) []byte) []byte {
+	start := len(dst)
+	dst = f(dst)
+	size := uint64(len(dst) - start)
+	n := protoVarintSize(size)
+	// Move the message in order to free space for the length prefix.
+	for i := 0; i < n; i++ {
+		dst = append(dst, 0)
+	}
+	copy(dst[start+n:], dst[start:len(dst)-n])
+	// The length prefix is written in place, since dst has enough capacity for it.
+	marshalVarUint64(dst[start:start], size)
+	return dst
+}
+
+func zigzagEncode(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,353 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWriteProtobuf(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`counter{a="b"}`).Add(42)
+	s.NewCounter(`counter{a="c"}`).Inc()
+	s.NewFloatCounter("float_counter").Add(1.5)
+	s.NewGauge("gauge", nil).Set(-2)
+	s.NewGauge("gauge_func", func() float64 { return 3 })
+	sm := s.NewSummaryExt("summary", defaultSummaryWindow, []float64{0.5})
+	sm.Update(7)
+	ph := s.NewHistogramWithBuckets("prom_histogram", []float64{1, 10})
+	ph.Update(5)
+	ph.Update(50)
+	h := s.NewHistogram("vm_histogram")
+	h.Update(1)
+	nh := s.NewNativeHistogram("native_histogram", 2)
+	nh.Update(0)
+	nh.Update(1)
+	nh.Update(3)
+	nh.Update(4)
+	nh.Update(16)
+	nh.Update(-0.5)
+	s.NewNativeHistogram("native_histogram_empty", 2)
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "custom_metric{x=\"y\"} 12\n")
+	})
+
+	var bb bytes.Buffer
+	s.WriteProtobuf(&bb)
+	result := unmarshalMetricFamiliesForTest(t, bb.Bytes())
+	resultExpected := `counter type=0
+  {a="b"} counter=42
+  {a="c"} counter=1
+float_counter type=0
+  {} counter=1.5
+gauge type=1
+  {} gauge=-2
+gauge_func type=1
+  {} gauge=3
+native_histogram type=4
+  {} histogram count=6 sum=23.5 schema=0 zero_threshold=2.938735877055719e-39 zero_count=1 negative_spans=[-1:1] negative_deltas=[1] positive_spans=[0:1,1:1,1:1] positive_deltas=[1,1,-1]
+native_histogram_empty type=4
+  {} histogram count=0 sum=0 schema=0 zero_threshold=2.938735877055719e-39 zero_count=0 positive_spans=[0:0]
+prom_histogram type=4
+  {} histogram bucket=[1:0,10:1] count=2 sum=55
+summary type=2
+  {} summary count=1 sum=7 quantiles=[0.5:7]
+vm_histogram type=4
+  {} histogram count=1 sum=1 bucket=[1:1]
+custom_metric type=3
+  {x="y"} untyped=12
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestAppendProtobufDelimited(t *testing.T) {
+	f := func(n int) {
+		t.Helper()
+		payload := bytes.Repeat([]byte("x"), n)
+		data := appendProtobufDelimited([]byte("prefix"), func(dst []byte) []byte {
+			return append(dst, payload...)
+		})
+		if !bytes.HasPrefix(data, []byte("prefix")) {
+			t.Fatalf("missing prefix in %q", data)
+		}
+		size, tail := readVarUint64ForTest(t, data[len("prefix"):])
+		if size != uint64(n) {
+			t.Fatalf("unexpected size; got %d; want %d", size, n)
+		}
+		if !bytes.Equal(tail, payload) {
+			t.Fatalf("unexpected payload; got %q; want %q", tail, payload)
+		}
+	}
+	f(0)
+	f(1)
+	f(127)
+	f(128)
+	f(20000)
+}
+
+func TestZigzagEncode(t *testing.T) {
+	f := func(v int64, resultExpected uint64) {
+		t.Helper()
+		if result := zigzagEncode(v); result != resultExpected {
+			t.Fatalf("unexpected result for %d; got %d; want %d", v, result, resultExpected)
+		}
+	}
+	f(0, 0)
+	f(-1, 1)
+	f(1, 2)
+	f(-2, 3)
+	f(math.MaxInt64, math.MaxUint64-1)
+	f(math.MinInt64, math.MaxUint64)
+}
+
+// unmarshalMetricFamiliesForTest converts delimited MetricFamily messages to human-readable text.
+func unmarshalMetricFamiliesForTest(t *testing.T, data []byte) string {
+	t.Helper()
+	var sb strings.Builder
+	for len(data) > 0 {
+		n, tail := readVarUint64ForTest(t, data)
+		mf := tail[:n]
+		data = tail[n:]
+		var name string
+		var metricType uint64
+		var metrics []string
+		forEachProtobufFieldForTest(t, mf, func(field int, v uint64, b []byte) {
+			switch field {
+			case 1:
+				name = string(b)
+			case 3:
+				metricType = v
+			case 4:
+				metrics = append(metrics, unmarshalMetricForTest(t, b))
+			}
+		})
+		fmt.Fprintf(&sb, "%s type=%d\n", name, metricType)
+		for _, m := range metrics {
+			fmt.Fprintf(&sb, "  %s\n", m)
+		}
+	}
+	return sb.String()
+}
+
+func unmarshalMetricForTest(t *testing.T, data []byte) string {
+	t.Helper()
+	var labels []string
+	var value string
+	forEachProtobufFieldForTest(t, data, func(field int, _ uint64, b []byte) {
+		switch field {
+		case 1:
+			var name, value string
+			forEachProtobufFieldForTest(t, b, func(field int, _ uint64, b []byte) {
+				if field == 1 {
+					name = string(b)
+				} else {
+					value = string(b)
+				}
+			})
+			labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+		case 2:
+			value = "gauge=" + unmarshalSingleDoubleForTest(t, b)
+		case 3:
+			value = "counter=" + unmarshalSingleDoubleForTest(t, b)
+		case 4:
+			value = "summary " + unmarshalSummaryForTest(t, b)
+		case 5:
+			value = "untyped=" + unmarshalSingleDoubleForTest(t, b)
+		case 7:
+			value = "histogram " + unmarshalHistogramForTest(t, b)
+		default:
+			t.Fatalf("unexpected Metric field %d", field)
+		}
+	})
+	return fmt.Sprintf("{%s} %s", strings.Join(labels, ","), value)
+}
+
+func unmarshalSingleDoubleForTest(t *testing.T, data []byte) string {
+	t.Helper()
+	var result string
+	forEachProtobufFieldForTest(t, data, func(field int, v uint64, _ []byte) {
+		result = fmt.Sprintf("%g", math.Float64frombits(v))
+	})
+	return result
+}
+
+func unmarshalSummaryForTest(t *testing.T, data []byte) string {
+	t.Helper()
+	var parts, quantiles []string
+	forEachProtobufFieldForTest(t, data, func(field int, v uint64, b []byte) {
+		switch field {
+		case 1:
+			parts = append(parts, fmt.Sprintf("count=%d", v))
+		case 2:
+			parts = append(parts, fmt.Sprintf("sum=%g", math.Float64frombits(v)))
+		case 3:
+			var q, value float64
+			forEachProtobufFieldForTest(t, b, func(field int, v uint64, _ []byte) {
+				if field == 1 {
+					q = math.Float64frombits(v)
+				} else {
+					value = math.Float64frombits(v)
+				}
+			})
+			quantiles = append(quantiles, fmt.Sprintf("%g:%g", q, value))
+		}
+	})
+	parts = append(parts, fmt.Sprintf("quantiles=[%s]", strings.Join(quantiles, ",")))
+	return strings.Join(parts, " ")
+}
+
+func unmarshalHistogramForTest(t *testing.T, data []byte) string {
+	t.Helper()
+	var parts []string
+	var buckets []string
+	flushBuckets := func() {
+		if len(buckets) > 0 {
+			parts = append(parts, fmt.Sprintf("bucket=[%s]", strings.Join(buckets, ",")))
+			buckets = nil
+		}
+	}
+	addSpan := func(name string, b []byte) {
+		var offset int64
+		var length uint64
+		forEachProtobufFieldForTest(t, b, func(field int, v uint64, _ []byte) {
+			if field == 1 {
+				offset = int64(v>>1) ^ -int64(v&1)
+			} else {
+				length = v
+			}
+		})
+		span := fmt.Sprintf("%d:%d", offset, length)
+		if n := len(parts); n > 0 && strings.HasPrefix(parts[n-1], name+"=[") {
+			parts[n-1] = parts[n-1][:len(parts[n-1])-1] + "," + span + "]"
+			return
+		}
+		parts = append(parts, fmt.Sprintf("%s=[%s]", name, span))
+	}
+	addDeltas := func(name string, b []byte) {
+		var deltas []string
+		for len(b) > 0 {
+			var v uint64
+			v, b = readVarUint64ForTest(t, b)
+			deltas = append(deltas, fmt.Sprintf("%d", int64(v>>1)^-int64(v&1)))
+		}
+		parts = append(parts, fmt.Sprintf("%s=[%s]", name, strings.Join(deltas, ",")))
+	}
+	forEachProtobufFieldForTest(t, data, func(field int, v uint64, b []byte) {
+		if field != 3 {
+			flushBuckets()
+		}
+		switch field {
+		case 1:
+			parts = append(parts, fmt.Sprintf("count=%d", v))
+		case 2:
+			parts = append(parts, fmt.Sprintf("sum=%g", math.Float64frombits(v)))
+		case 3:
+			var count uint64
+			var upperBound float64
+			forEachProtobufFieldForTest(t, b, func(field int, v uint64, _ []byte) {
+				if field == 1 {
+					count = v
+				} else {
+					upperBound = math.Float64frombits(v)
+				}
+			})
+			buckets = append(buckets, fmt.Sprintf("%g:%d", upperBound, count))
+		case 5:
+			parts = append(parts, fmt.Sprintf("schema=%d", int64(v>>1)^-int64(v&1)))
+		case 6:
+			parts = append(parts, fmt.Sprintf("zero_threshold=%g", math.Float64frombits(v)))
+		case 7:
+			parts = append(parts, fmt.Sprintf("zero_count=%d", v))
+		case 8:
+			addSpan("negative_spans", b)
+		case 9:
+			addDeltas("negative_deltas", b)
+		case 11:
+			addSpan("positive_spans", b)
+		case 12:
+			addDeltas("positive_deltas", b)
+		default:
+			t.Fatalf("unexpected Histogram field %d", field)
+		}
+	})
+	flushBuckets()
+	return strings.Join(parts, " ")
+}
+
+// forEachProtobufFieldForTest calls f for every field in data.
+//
+// v contains the value for varint and fixed64 fields, while b contains the value for length-delimited fields.
+func forEachProtobufFieldForTest(t *testing.T, data []byte, f func(field int, v uint64, b []byte)) {
+	t.Helper()
+	for len(data) > 0 {
+		var tag uint64
+		tag, data = readVarUint64ForTest(t, data)
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case protobufWireVarint:
+			var v uint64
+			v, data = readVarUint64ForTest(t, data)
+			f(field, v, nil)
+		case protobufWireFixed64:
+			var v uint64
+			for i := 0; i < 8; i++ {
+				v |= uint64(data[i]) << (8 * i)
+			}
+			data = data[8:]
+			f(field, v, nil)
+		case protobufWireBytes:
+			var n uint64
+			n, data = readVarUint64ForTest(t, data)
+			f(field, 0, data[:n])
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type for tag 0x%x", tag)
+		}
+	}
+}
+
+func TestWritePrometheusProto(t *testing.T) {
+	s1 := NewSet()
+	s1.NewCounter(`proto_requests_total{path="/a"}`).Add(2)
+	s1.NewGauge("proto_temperature", nil).Set(10)
+	s2 := NewSet()
+	s2.NewCounter(`proto_requests_total{path="/b",env="dev"}`).Inc()
+	RegisterSet(s1)
+	defer UnregisterSet(s1)
+	RegisterSet(s2)
+	defer UnregisterSet(s2)
+
+	var bb bytes.Buffer
+	WritePrometheusProto(&bb, WritePrometheusOpts{
+		ExposeBuildInfo: true,
+		ExtraLabels: map[string]string{
+			"env": "prod",
+		},
+	})
+	result := unmarshalMetricFamiliesForTest(t, bb.Bytes())
+
+	// Metrics with the same base name from distinct sets must be grouped into a single family.
+	familyExpected := `proto_requests_total type=0
+  {path="/a",env="prod"} counter=2
+  {path="/b",env="dev"} counter=1
+proto_temperature type=1
+  {env="prod"} gauge=10
+`
+	if !strings.Contains(result, familyExpected) {
+		t.Fatalf("missing\n%s\nin the result\n%s", familyExpected, result)
+	}
+	if n := strings.Count(result, "proto_requests_total type="); n != 1 {
+		t.Fatalf("unexpected number of proto_requests_total families; got %d; want 1", n)
+	}
+	if !strings.Contains(result, "\ngo_info type=3\n") {
+		t.Fatalf("missing go_info family in the result\n%s", result)
+	}
+	if strings.Contains(result, "\nprocess_cpu_seconds_total type=") {
+		t.Fatalf("unexpected process metrics in the result\n%s", result)
+	}
+}
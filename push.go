@@ -3,18 +3,23 @@ package metrics
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"compress/gzip"
+
+	"github.com/golang/snappy"
 )
 
 // PushOptions is the list of options, which may be applied to InitPushWithOptions().
@@ -27,13 +32,95 @@ type PushOptions struct {
 	// Every item in the list must have the form `Header: value`. For example, `Authorization: Custom my-top-secret`.
 	Headers []string
 
+	// Header is an optional set of HTTP headers to add to every push request to pushURL in addition to Headers.
+	//
+	// The header is copied when the push is initialized, so it isn't modified and it may be re-used by the caller.
+	Header http.Header
+
+	// BasicAuthUser is an optional username for basic auth at pushURL.
+	BasicAuthUser string
+
+	// BasicAuthPassword is an optional password for basic auth at pushURL. It is used only if BasicAuthUser is set.
+	BasicAuthPassword string
+
+	// BearerTokenFile is an optional path to the file with the bearer token for `Authorization: Bearer <token>` header.
+	//
+	// The file is re-read every minute, so rotated tokens are picked up without restarting the push.
+	// The previously read token is used if the file cannot be re-read.
+	BearerTokenFile string
+
+	// TLSConfig is an optional TLS config for https pushURL. It may contain client certificates and custom root CAs.
+	//
+	// TLSConfig cannot be set together with Client. Configure the transport of the Client instead.
+	TLSConfig *tls.Config
+
+	// Client is an optional HTTP client for pushing metrics. It may be used for configuring proxies, timeouts and TLS.
+	//
+	// By default a client with the proxy from environment variables and with TLSConfig is used.
+	Client *http.Client
+
 	// Whether to disable HTTP request body compression before sending the metrics to pushURL.
 	//
 	// By default the compression is enabled.
 	DisableCompression bool
 
+	// Compression is an optional compression for HTTP request bodies sent to pushURL.
+	//
+	// Supported values are PushCompressionNone, PushCompressionGzip and encodings registered via RegisterPushCompressor
+	// such as PushCompressionZstd. The metrics are streamed through the compressor while they are written
+	// unless ExtraLabels or CountersAsDeltas are set. The Content-Encoding header is set to the Compression value.
+	// The push falls back to uncompressed request bodies for pushURL with a logged warning if the server responds
+	// with 415 Unsupported Media Type status code.
+	//
+	// By default gzip compression is used unless DisableCompression is set.
+	// The option is ignored for remote_write pushes, since remote_write protocol requires snappy compression.
+	Compression string
+
+	// Method is an optional HTTP request method to use when pushing metrics to pushURL.
+	//
+	// By default the GET method is used.
+	Method string
+
 	// Optional WaitGroup for waiting until all the push workers created with this WaitGroup are stopped.
 	WaitGroup *sync.WaitGroup
+
+	// MaxRetries is an optional number of retries for failed pushes.
+	//
+	// Pushes are retried on network errors and on 5xx responses. They aren't retried on 4xx responses,
+	// since such requests cannot succeed. Retries are skipped if they cannot complete before the next periodic push.
+	//
+	// By default pushes in Prometheus text exposition format aren't retried, while remote_write pushes are retried 5 times.
+	// See also DisableRetries.
+	MaxRetries int
+
+	// DisableRetries disables retries for failed pushes. MaxRetries is ignored if DisableRetries is set.
+	//
+	// This is useful for remote_write pushes, which are retried by default.
+	DisableRetries bool
+
+	// MinBackoff is an optional minimum duration to wait before the first retry. The duration is doubled after every retry.
+	//
+	// By default 1 second is used.
+	MinBackoff time.Duration
+
+	// MaxBackoff is an optional maximum duration to wait between retries.
+	//
+	// By default the push interval is used. The MinBackoff is used if the push interval is smaller than MinBackoff.
+	MaxBackoff time.Duration
+
+	// CountersAsDeltas enables pushing counter values as deltas since the previous successful push
+	// instead of cumulative values.
+	//
+	// This is useful for remote systems, which expect deltas and which cannot handle counter resets on process restarts.
+	// The delta is rolled into the next push if the push fails. Counters, which appeared since the previous push,
+	// are pushed with their full values. Other metric types such as gauges, histograms and summaries are pushed as is.
+	//
+	// Counters are detected by `# TYPE` metadata lines if they are present. Otherwise metrics with `_total` suffix
+	// are treated as counters. See ExposeMetadata.
+	//
+	// Deltas are tracked per push worker, so the option has no effect on one-shot pushes via PushMetrics* functions.
+	// The option isn't supported for pushes in InfluxDB line protocol.
+	CountersAsDeltas bool
 }
 
 // InitPushWithOptions sets up periodic push for globally registered metrics to the given pushURL with the given interval.
@@ -206,12 +293,151 @@ func InitPushExtWithOptions(ctx context.Context, pushURL string, interval time.D
 	if err != nil {
 		return err
 	}
+	return initPush(ctx, pc, interval, writeMetrics, opts)
+}
+
+// initPush starts periodic push of metrics generated by writeMetrics via pc with the given interval.
+func initPush(ctx context.Context, pc *pushContext, interval time.Duration, writeMetrics func(w io.Writer), opts *PushOptions) error {
+	_, err := startPush(ctx, pc, interval, writeMetrics, opts)
+	return err
+}
+
+// StartPush starts periodic push for globally registered metrics to the given pushURL with the given interval.
+//
+// If pushProcessMetrics is set to true, then 'process_*' and `go_*` metrics are also pushed to pushURL.
+//
+// opts may contain additional configuration options if non-nil.
+//
+// Call Stop on the returned PushWorker for stopping the periodic push and pushing the remaining metrics before the app exits.
+// This is useful for short-lived jobs, which would lose metrics collected since the last push otherwise.
+// Call PushNow on the returned PushWorker for pushing metrics at the needed moments such as the end of a job stage.
+//
+// Use PushMetrics for pushing metrics once without the periodic push.
+//
+// See also InitPushWithOptions.
+func StartPush(pushURL string, interval time.Duration, pushProcessMetrics bool, opts *PushOptions) (*PushWorker, error) {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, pushProcessMetrics)
+	}
+	return StartPushExt(pushURL, interval, writeMetrics, opts)
+}
+
+// StartPush starts periodic push for metrics from s to the given pushURL with the given interval.
+//
+// See StartPush for details.
+func (s *Set) StartPush(pushURL string, interval time.Duration, opts *PushOptions) (*PushWorker, error) {
+	return StartPushExt(pushURL, interval, s.WritePrometheus, opts)
+}
+
+// StartPushExt starts periodic push for metrics obtained by calling writeMetrics to the given pushURL with the given interval.
+//
+// The writeMetrics callback must write metrics to w in Prometheus text exposition format without timestamps and trailing comments.
+// See https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md#text-based-format
+//
+// See StartPush for details.
+func StartPushExt(pushURL string, interval time.Duration, writeMetrics func(w io.Writer), opts *PushOptions) (*PushWorker, error) {
+	pc, err := newPushContext(pushURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	return startPush(context.Background(), pc, interval, writeMetrics, opts)
+}
+
+// ErrPushWorkerStopped is returned from PushWorker.PushNow after the PushWorker is stopped.
+var ErrPushWorkerStopped = errors.New("metrics.push: the push worker is stopped")
+
+// PushWorker periodically pushes metrics to the configured url.
+//
+// PushWorker is returned from StartPush* functions.
+type PushWorker struct {
+	pc           *pushContext
+	interval     time.Duration
+	writeMetrics func(w io.Writer)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	doneCh chan struct{}
+
+	// pushMu serializes pushes, so periodic pushes, PushNow calls and the final push from Stop never overlap.
+	pushMu sync.Mutex
+
+	// stopped is set to true when Stop is called. It is protected by pushMu.
+	stopped bool
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// PushNow immediately pushes metrics to the configured url and returns the push result.
+//
+// The push uses the same url, extra labels, headers and client as the periodic pushes.
+// It waits for the in-flight periodic push to finish, so pushes never overlap.
+// PushNow may be called at any time before Stop, including before the first periodic push.
+// ErrPushWorkerStopped is returned if Stop has been called or the context passed to StartPush* is canceled.
+//
+// ctx limits the duration of the push. The push is limited by the push interval if ctx has no deadline.
+func (pw *PushWorker) PushNow(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pw.interval)
+		defer cancel()
+	}
+	pw.pushMu.Lock()
+	defer pw.pushMu.Unlock()
+	if pw.stopped || pw.ctx.Err() != nil {
+		return ErrPushWorkerStopped
+	}
+	return pw.pc.pushMetrics(ctx, pw.writeMetrics)
+}
+
+// Stop stops the periodic push and then pushes metrics for the last time.
+//
+// ctx limits the duration of the final push. The final push is limited by the push interval if ctx has no deadline.
+// The error from the final push is returned to the caller.
+//
+// It is safe calling Stop multiple times - the subsequent calls return the result of the first call.
+func (pw *PushWorker) Stop(ctx context.Context) error {
+	pw.stopOnce.Do(func() {
+		pw.pushMu.Lock()
+		pw.stopped = true
+		pw.pushMu.Unlock()
+
+		pw.cancel()
+		<-pw.doneCh
+
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, pw.interval+time.Second)
+			defer cancel()
+		}
+		pw.pushMu.Lock()
+		pw.stopErr = pw.pc.pushMetrics(ctx, pw.writeMetrics)
+		pw.pushMu.Unlock()
+		pw.pc.closeIdleConnections()
+	})
+	return pw.stopErr
+}
+
+// pushPeriodic pushes metrics for the periodic push unless Stop has been called.
+func (pw *PushWorker) pushPeriodic(ctx context.Context) error {
+	pw.pushMu.Lock()
+	defer pw.pushMu.Unlock()
+	if pw.stopped {
+		return nil
+	}
+	return pw.pc.pushMetrics(ctx, pw.writeMetrics)
+}
 
+// startPush starts periodic push of metrics generated by writeMetrics via pc with the given interval.
+//
+// The periodic push is stopped when ctx is canceled or when Stop is called on the returned PushWorker.
+func startPush(ctx context.Context, pc *pushContext, interval time.Duration, writeMetrics func(w io.Writer), opts *PushOptions) (*PushWorker, error) {
 	// validate interval
 	if interval <= 0 {
-		return fmt.Errorf("interval must be positive; got %s", interval)
+		return nil, fmt.Errorf("interval must be positive; got %s", interval)
 	}
-	pushMetricsSet.GetOrCreateFloatCounter(fmt.Sprintf(`metrics_push_interval_seconds{url=%q}`, pc.pushURLRedacted)).Set(interval.Seconds())
+	pushMetricsSet.GetOrCreateFloatCounter(fmt.Sprintf(`metrics_push_interval_seconds{%s}`, pc.selfMetricsLabels)).Set(interval.Seconds())
+	pc.initMaxBackoff(interval)
 
 	var wg *sync.WaitGroup
 	if opts != nil {
@@ -220,29 +446,34 @@ func InitPushExtWithOptions(ctx context.Context, pushURL string, interval time.D
 			wg.Add(1)
 		}
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	pw := &PushWorker{
+		pc:           pc,
+		interval:     interval,
+		writeMetrics: writeMetrics,
+		ctx:          ctx,
+		cancel:       cancel,
+		doneCh:       make(chan struct{}),
+	}
+	stopTicker := getClock().startTicker(interval, func() {
+		// Limit the push duration including retries by the interval, so it doesn't overlap the next push.
+		ctxLocal, cancel := context.WithTimeout(ctx, interval)
+		err := pw.pushPeriodic(ctxLocal)
+		cancel()
+		if err != nil {
+			log.Printf("ERROR: metrics.push: %s", err)
+		}
+	})
 	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		stopCh := ctx.Done()
-		for {
-			select {
-			case <-ticker.C:
-				ctxLocal, cancel := context.WithTimeout(ctx, interval+time.Second)
-				err := pc.pushMetrics(ctxLocal, writeMetrics)
-				cancel()
-				if err != nil {
-					log.Printf("ERROR: metrics.push: %s", err)
-				}
-			case <-stopCh:
-				if wg != nil {
-					wg.Done()
-				}
-				return
-			}
+		defer close(pw.doneCh)
+		<-ctx.Done()
+		stopTicker()
+		if wg != nil {
+			wg.Done()
 		}
 	}()
 
-	return nil
+	return pw, nil
 }
 
 // PushMetricsExt pushes metrics generated by wirteMetrics to pushURL.
@@ -259,26 +490,66 @@ func PushMetricsExt(ctx context.Context, pushURL string, writeMetrics func(w io.
 	if err != nil {
 		return err
 	}
+	defer pc.closeIdleConnections()
 	return pc.pushMetrics(ctx, writeMetrics)
 }
 
 type pushContext struct {
-	pushURL            *url.URL
-	pushURLRedacted    string
-	extraLabels        string
-	headers            http.Header
-	disableCompression bool
+	pushURL         *url.URL
+	pushURLRedacted string
+
+	// selfMetricsLabels contains labels for push metrics such as metrics_push_total.
+	selfMetricsLabels string
+
+	extraLabels       string
+	headers           http.Header
+	basicAuthUser     string
+	basicAuthPassword string
+	bearerTokenFile   *bearerTokenFile
+	method            string
+
+	// compression is the Content-Encoding for compressed request bodies. It is empty if the compression is disabled.
+	compression    string
+	compressorPool *pushCompressorPool
+
+	// compressionFallback is set to 1 after the server responds with 415 Unsupported Media Type to compressed request.
+	// It is accessed atomically.
+	compressionFallback uint32
+
+	// isRemoteWrite is set to true if metrics must be pushed in Prometheus remote_write protocol.
+	isRemoteWrite bool
+
+	// maxRetries is the maximum number of retries for failed pushes. Pushes aren't retried if it is set to 0.
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// deltas converts counter values into deltas if PushOptions.CountersAsDeltas is set. It is nil otherwise.
+	deltas *pushDeltas
 
 	client *http.Client
 
-	pushesTotal      *Counter
-	bytesPushedTotal *Counter
-	pushBlockSize    *Histogram
-	pushDuration     *Histogram
-	pushErrors       *Counter
+	// transport is the transport created for PushOptions.TLSConfig. It is nil if the default transport is used.
+	transport *http.Transport
+
+	pushesTotal        *Counter
+	bytesPushedTotal   *Counter
+	uncompressedBytes  *Counter
+	pushBlockSize      *Histogram
+	pushDuration       *Histogram
+	pushErrors         *Counter
+	pushRetries        *Counter
+	pushRetriesSkipped *Counter
 }
 
 func newPushContext(pushURL string, opts *PushOptions) (*pushContext, error) {
+	return newPushContextForTarget(pushURL, opts, "")
+}
+
+// newPushContextForTarget returns new pushContext for pushing metrics to pushURL.
+//
+// The target is added as `target` label to push metrics if it isn't empty.
+func newPushContextForTarget(pushURL string, opts *PushOptions, target string) (*pushContext, error) {
 	if opts == nil {
 		opts = &PushOptions{}
 	}
@@ -312,23 +583,131 @@ func newPushContext(pushURL string, opts *PushOptions) (*pushContext, error) {
 		value := strings.TrimSpace(h[n+1:])
 		headers.Add(name, value)
 	}
+	for name, values := range opts.Header {
+		for _, value := range values {
+			headers.Add(name, value)
+		}
+	}
+
+	// validate auth options
+	if opts.BasicAuthUser == "" && opts.BasicAuthPassword != "" {
+		return nil, fmt.Errorf("BasicAuthPassword cannot be set without BasicAuthUser")
+	}
+	if opts.BasicAuthUser != "" && opts.BearerTokenFile != "" {
+		return nil, fmt.Errorf("BasicAuthUser and BearerTokenFile cannot be set simultaneously")
+	}
+	var tf *bearerTokenFile
+	if opts.BearerTokenFile != "" {
+		tf, err = newBearerTokenFile(opts.BearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// validate client options
+	if opts.Client != nil && opts.TLSConfig != nil {
+		return nil, fmt.Errorf("TLSConfig cannot be set together with Client; configure TLS at the Client transport instead")
+	}
+	client := opts.Client
+	var transport *http.Transport
+	if client == nil {
+		if opts.TLSConfig != nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = opts.TLSConfig.Clone()
+		}
+		client = &http.Client{}
+		if transport != nil {
+			client.Transport = transport
+		}
+	}
+
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// validate compression
+	compression := opts.Compression
+	if compression == "" {
+		compression = PushCompressionGzip
+		if opts.DisableCompression {
+			compression = PushCompressionNone
+		}
+	} else if opts.DisableCompression && compression != PushCompressionNone {
+		return nil, fmt.Errorf("Compression=%q cannot be set together with DisableCompression", compression)
+	}
+	compressorPool, err := getPushCompressorPool(compression)
+	if err != nil {
+		return nil, err
+	}
+	if compressorPool == nil {
+		compression = ""
+	}
+
+	// validate retry options
+	if opts.MaxRetries < 0 {
+		return nil, fmt.Errorf("MaxRetries cannot be negative; got %d", opts.MaxRetries)
+	}
+	maxRetries := opts.MaxRetries
+	if opts.DisableRetries {
+		maxRetries = 0
+	}
+	if opts.MinBackoff < 0 {
+		return nil, fmt.Errorf("MinBackoff cannot be negative; got %s", opts.MinBackoff)
+	}
+	if opts.MaxBackoff < 0 {
+		return nil, fmt.Errorf("MaxBackoff cannot be negative; got %s", opts.MaxBackoff)
+	}
+	minBackoff := opts.MinBackoff
+	if minBackoff == 0 {
+		minBackoff = time.Second
+	}
+	if opts.MaxBackoff > 0 && opts.MaxBackoff < minBackoff {
+		return nil, fmt.Errorf("MaxBackoff=%s cannot be smaller than MinBackoff=%s", opts.MaxBackoff, minBackoff)
+	}
+
+	var deltas *pushDeltas
+	if opts.CountersAsDeltas {
+		deltas = newPushDeltas()
+	}
 
 	pushURLRedacted := pu.Redacted()
-	client := &http.Client{}
+	selfMetricsLabels := fmt.Sprintf("url=%q", pushURLRedacted)
+	if target != "" {
+		selfMetricsLabels += fmt.Sprintf(",target=%q", target)
+	}
 	return &pushContext{
-		pushURL:            pu,
-		pushURLRedacted:    pushURLRedacted,
-		extraLabels:        extraLabels,
-		headers:            headers,
-		disableCompression: opts.DisableCompression,
-
-		client: client,
-
-		pushesTotal:      pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_total{url=%q}`, pushURLRedacted)),
-		bytesPushedTotal: pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_bytes_pushed_total{url=%q}`, pushURLRedacted)),
-		pushBlockSize:    pushMetricsSet.GetOrCreateHistogram(fmt.Sprintf(`metrics_push_block_size_bytes{url=%q}`, pushURLRedacted)),
-		pushDuration:     pushMetricsSet.GetOrCreateHistogram(fmt.Sprintf(`metrics_push_duration_seconds{url=%q}`, pushURLRedacted)),
-		pushErrors:       pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_errors_total{url=%q}`, pushURLRedacted)),
+		pushURL:           pu,
+		pushURLRedacted:   pushURLRedacted,
+		selfMetricsLabels: selfMetricsLabels,
+		extraLabels:       extraLabels,
+		headers:           headers,
+		basicAuthUser:     opts.BasicAuthUser,
+		basicAuthPassword: opts.BasicAuthPassword,
+		bearerTokenFile:   tf,
+		method:            method,
+
+		compression:    compression,
+		compressorPool: compressorPool,
+
+		maxRetries: maxRetries,
+		minBackoff: minBackoff,
+		maxBackoff: opts.MaxBackoff,
+
+		deltas: deltas,
+
+		client:    client,
+		transport: transport,
+
+		pushesTotal:       pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_total{%s}`, selfMetricsLabels)),
+		bytesPushedTotal:  pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_bytes_pushed_total{%s}`, selfMetricsLabels)),
+		uncompressedBytes: pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_uncompressed_bytes_total{%s}`, selfMetricsLabels)),
+		pushBlockSize:     pushMetricsSet.GetOrCreateHistogram(fmt.Sprintf(`metrics_push_block_size_bytes{%s}`, selfMetricsLabels)),
+		pushDuration:      pushMetricsSet.GetOrCreateHistogram(fmt.Sprintf(`metrics_push_duration_seconds{%s}`, selfMetricsLabels)),
+		pushErrors:        pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_errors_total{%s}`, selfMetricsLabels)),
+
+		pushRetries:        pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_retries_total{%s}`, selfMetricsLabels)),
+		pushRetriesSkipped: pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_push_retries_skipped_total{%s}`, selfMetricsLabels)),
 	}, nil
 }
 
@@ -336,74 +715,264 @@ func (pc *pushContext) pushMetrics(ctx context.Context, writeMetrics func(w io.W
 	bb := getBytesBuffer()
 	defer putBytesBuffer(bb)
 
+	compression := pc.getCompression()
+	uncompressedLen, err := pc.writeRequestBody(bb, writeMetrics, compression)
+	if err != nil {
+		pc.pushErrors.Inc()
+		return err
+	}
+
+	// Update metrics
+	pc.pushesTotal.Inc()
+	blockLen := len(bb.B)
+	pc.bytesPushedTotal.Add(blockLen)
+	pc.uncompressedBytes.Add(uncompressedLen)
+	pc.pushBlockSize.Update(float64(blockLen))
+
+	// Perform the request and retry it on temporary errors if needed
+	backoff := pc.minBackoff
+	for attempt := 0; ; attempt++ {
+		startTime := time.Now()
+		retryable, err := pc.sendRequest(ctx, bb.B, compression)
+		pc.pushDuration.UpdateDuration(startTime)
+		if err == nil {
+			if pc.deltas != nil {
+				pc.deltas.commit()
+			}
+			return nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		var umtErr *unsupportedMediaTypeError
+		if compression != "" && errors.As(err, &umtErr) {
+			// The server doesn't support the compression, so push uncompressed metrics to it from now on.
+			atomic.StoreUint32(&pc.compressionFallback, 1)
+			log.Printf("WARNING: metrics.push: falling back to uncompressed pushes to %q, since it doesn't support Content-Encoding: %s; error: %s",
+				pc.pushURLRedacted, compression, err)
+			return pc.pushMetrics(ctx, writeMetrics)
+		}
+		if !retryable || attempt >= pc.maxRetries {
+			pc.pushErrors.Inc()
+			return err
+		}
+		sleepDuration := getBackoffWithJitter(backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < sleepDuration {
+			// Do not retry the push if the retry cannot complete before the deadline,
+			// since the deadline for periodic pushes is set to the start of the next push.
+			pc.pushRetriesSkipped.Inc()
+			pc.pushErrors.Inc()
+			return fmt.Errorf("skipping retry of push to %q, since it cannot complete before the next push; last error: %w", pc.pushURLRedacted, err)
+		}
+		pc.pushRetries.Inc()
+		t := time.NewTimer(sleepDuration)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			pc.pushErrors.Inc()
+			return fmt.Errorf("cannot retry push to %q after the error: %w", pc.pushURLRedacted, err)
+		case <-t.C:
+		}
+		backoff *= 2
+		if pc.maxBackoff > 0 && backoff > pc.maxBackoff {
+			backoff = pc.maxBackoff
+		}
+	}
+}
+
+// getCompression returns Content-Encoding for the pushed request bodies.
+//
+// An empty string is returned if request bodies mustn't be compressed.
+func (pc *pushContext) getCompression() string {
+	if pc.isRemoteWrite || atomic.LoadUint32(&pc.compressionFallback) != 0 {
+		return ""
+	}
+	return pc.compression
+}
+
+// writeRequestBody writes the request body with the metrics generated by writeMetrics to bb
+// and returns the size of the body before the compression.
+//
+// The metrics are streamed through the compressor if they don't need transformations.
+func (pc *pushContext) writeRequestBody(bb *bytesBuffer, writeMetrics func(w io.Writer), compression string) (int, error) {
+	if compression != "" && pc.deltas == nil && len(pc.extraLabels) == 0 {
+		c := pc.compressorPool.get(bb)
+		cw := &countingWriter{
+			w: c,
+		}
+		writeMetrics(cw)
+		err := cw.err
+		if err == nil {
+			err = c.Close()
+		}
+		pc.compressorPool.put(c)
+		if err != nil {
+			return 0, fmt.Errorf("cannot compress metrics for %q with %s: %w", pc.pushURLRedacted, compression, err)
+		}
+		return int(cw.n), nil
+	}
+
 	writeMetrics(bb)
 
+	if pc.deltas != nil {
+		bbTmp := getBytesBuffer()
+		bbTmp.B = append(bbTmp.B[:0], bb.B...)
+		bb.B = pc.deltas.convert(bb.B[:0], bbTmp.B)
+		putBytesBuffer(bbTmp)
+	}
 	if len(pc.extraLabels) > 0 {
 		bbTmp := getBytesBuffer()
 		bbTmp.B = append(bbTmp.B[:0], bb.B...)
 		bb.B = addExtraLabels(bb.B[:0], bbTmp.B, pc.extraLabels)
 		putBytesBuffer(bbTmp)
 	}
-	if !pc.disableCompression {
+	if pc.isRemoteWrite {
 		bbTmp := getBytesBuffer()
-		bbTmp.B = append(bbTmp.B[:0], bb.B...)
-		bb.B = bb.B[:0]
-		zw := getGzipWriter(bb)
-		if _, err := zw.Write(bbTmp.B); err != nil {
-			panic(fmt.Errorf("BUG: cannot write %d bytes to gzip writer: %s", len(bbTmp.B), err))
-		}
-		if err := zw.Close(); err != nil {
-			panic(fmt.Errorf("BUG: cannot flush metrics to gzip writer: %s", err))
+		var err error
+		bbTmp.B, err = marshalRemoteWriteRequest(bbTmp.B[:0], bb.B, time.Now().UnixNano()/1e6)
+		if err != nil {
+			putBytesBuffer(bbTmp)
+			return 0, fmt.Errorf("cannot prepare remote_write request for %q: %w", pc.pushURLRedacted, err)
 		}
-		putGzipWriter(zw)
+		uncompressedLen := len(bbTmp.B)
+		bb.B = snappy.Encode(bb.B[:cap(bb.B)], bbTmp.B)
 		putBytesBuffer(bbTmp)
+		return uncompressedLen, nil
+	}
+	uncompressedLen := len(bb.B)
+	if compression == "" {
+		return uncompressedLen, nil
 	}
 
-	// Update metrics
-	pc.pushesTotal.Inc()
-	blockLen := len(bb.B)
-	pc.bytesPushedTotal.Add(blockLen)
-	pc.pushBlockSize.Update(float64(blockLen))
+	bbTmp := getBytesBuffer()
+	c := pc.compressorPool.get(bbTmp)
+	_, err := c.Write(bb.B)
+	if err == nil {
+		err = c.Close()
+	}
+	pc.compressorPool.put(c)
+	bb.B, bbTmp.B = bbTmp.B, bb.B
+	putBytesBuffer(bbTmp)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compress metrics for %q with %s: %w", pc.pushURLRedacted, compression, err)
+	}
+	return uncompressedLen, nil
+}
+
+// getBackoffWithJitter returns random duration in the range [backoff/2 ... backoff).
+//
+// The jitter prevents from simultaneous retries by multiple push workers after the remote side recovers.
+func getBackoffWithJitter(backoff time.Duration) time.Duration {
+	half := backoff / 2
+	if half <= 0 {
+		return backoff
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
 
+// initMaxBackoff sets the maximum backoff between push retries to the push interval if it isn't set explicitly.
+func (pc *pushContext) initMaxBackoff(interval time.Duration) {
+	if pc.maxBackoff > 0 {
+		return
+	}
+	pc.maxBackoff = interval
+	if pc.maxBackoff < pc.minBackoff {
+		pc.maxBackoff = pc.minBackoff
+	}
+}
+
+// sendRequest sends the given body to pc.pushURL.
+//
+// It returns true if the request may be retried on error.
+func (pc *pushContext) sendRequest(ctx context.Context, body []byte, compression string) (bool, error) {
 	// Prepare the request to sent to pc.pushURL
-	reqBody := bytes.NewReader(bb.B)
-	req, err := http.NewRequestWithContext(ctx, "GET", pc.pushURL.String(), reqBody)
+	reqBody := bytes.NewReader(body)
+	req, err := http.NewRequestWithContext(ctx, pc.method, pc.pushURL.String(), reqBody)
 	if err != nil {
 		panic(fmt.Errorf("BUG: metrics.push: cannot initialize request for metrics push to %q: %w", pc.pushURLRedacted, err))
 	}
 
 	// Set the needed headers
-	for name, values := range pc.headers {
-		for _, value := range values {
-			req.Header.Add(name, value)
-		}
+	if err := pc.setRequestHeaders(req); err != nil {
+		return false, err
 	}
-	req.Header.Set("Content-Type", "text/plain")
-	if !pc.disableCompression {
-		req.Header.Set("Content-Encoding", "gzip")
+	if pc.isRemoteWrite {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+		if compression != "" {
+			req.Header.Set("Content-Encoding", compression)
+		}
 	}
 
 	// Perform the request
-	startTime := time.Now()
 	resp, err := pc.client.Do(req)
-	pc.pushDuration.UpdateDuration(startTime)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
-			return nil
+			return false, err
 		}
-		pc.pushErrors.Inc()
-		return fmt.Errorf("cannot push metrics to %q: %s", pc.pushURLRedacted, err)
+		return true, fmt.Errorf("cannot push metrics to %q: %w", pc.pushURLRedacted, err)
 	}
 	if resp.StatusCode/100 != 2 {
 		body, _ := ioutil.ReadAll(resp.Body)
 		_ = resp.Body.Close()
-		pc.pushErrors.Inc()
-		return fmt.Errorf("unexpected status code in response from %q: %d; expecting 2xx; response body: %q", pc.pushURLRedacted, resp.StatusCode, body)
+		// Retry only server-side errors, since client-side errors cannot be fixed by retrying the same request.
+		retryable := resp.StatusCode/100 == 5
+		err := fmt.Errorf("unexpected status code in response from %q: %d; expecting 2xx; response body: %q", pc.pushURLRedacted, resp.StatusCode, body)
+		if resp.StatusCode == http.StatusUnsupportedMediaType {
+			err = &unsupportedMediaTypeError{
+				err: err,
+			}
+		}
+		return retryable, err
 	}
 	_ = resp.Body.Close()
+	return false, nil
+}
+
+// closeIdleConnections closes idle connections for the transport created for PushOptions.TLSConfig,
+// so they don't leak after one-shot pushes.
+func (pc *pushContext) closeIdleConnections() {
+	if pc.transport != nil {
+		pc.transport.CloseIdleConnections()
+	}
+}
+
+// setRequestHeaders sets the headers from PushOptions and auth headers for req.
+func (pc *pushContext) setRequestHeaders(req *http.Request) error {
+	for name, values := range pc.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if pc.basicAuthUser != "" {
+		req.SetBasicAuth(pc.basicAuthUser, pc.basicAuthPassword)
+	}
+	if pc.bearerTokenFile != nil {
+		token, err := pc.bearerTokenFile.getToken()
+		if err != nil {
+			return fmt.Errorf("cannot push metrics to %q: %w", pc.pushURLRedacted, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 	return nil
 }
 
+// unsupportedMediaTypeError is returned from sendRequest if the server responds with 415 Unsupported Media Type.
+type unsupportedMediaTypeError struct {
+	err error
+}
+
+func (e *unsupportedMediaTypeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unsupportedMediaTypeError) Unwrap() error {
+	return e.err
+}
+
 var pushMetricsSet = NewSet()
 
 func writePushMetrics(w io.Writer) {
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bearerTokenFileRereadInterval is the interval for re-reading the file set via PushOptions.BearerTokenFile.
+const bearerTokenFileRereadInterval = time.Minute
+
+// bearerTokenFile reads the bearer token from the file and re-reads it periodically in order to pick up rotated tokens.
+type bearerTokenFile struct {
+	path string
+
+	mu           sync.Mutex
+	token        string
+	lastReadTime time.Time
+}
+
+func newBearerTokenFile(path string) (*bearerTokenFile, error) {
+	tf := &bearerTokenFile{
+		path: path,
+	}
+	if _, err := tf.getToken(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// getToken returns the bearer token from tf.
+//
+// The previously read token is returned if the file cannot be re-read, so temporary errors during the token rotation
+// don't break pushes.
+func (tf *bearerTokenFile) getToken() (string, error) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+
+	if !tf.lastReadTime.IsZero() && time.Since(tf.lastReadTime) < bearerTokenFileRereadInterval {
+		return tf.token, nil
+	}
+	data, err := os.ReadFile(tf.path)
+	if err != nil {
+		if tf.token != "" {
+			return tf.token, nil
+		}
+		return "", fmt.Errorf("cannot read bearer token from %q: %w", tf.path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		if tf.token != "" {
+			return tf.token, nil
+		}
+		return "", fmt.Errorf("missing bearer token in %q", tf.path)
+	}
+	tf.token = token
+	tf.lastReadTime = time.Now()
+	return token, nil
+}
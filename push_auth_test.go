@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestClientCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "push-client",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, pool
+}
+
+func TestPushMetricsTLSClientCert(t *testing.T) {
+	clientCert, clientCAs := newTestClientCert(t)
+	var commonName string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commonName = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(srv.Certificate())
+
+	s := NewSet()
+	s.NewCounter("foo").Inc()
+	ctx := context.Background()
+
+	// The push must fail without client certificate.
+	err := s.PushMetrics(ctx, srv.URL, &PushOptions{
+		TLSConfig: &tls.Config{
+			RootCAs: rootCAs,
+		},
+	})
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	err = s.PushMetrics(ctx, srv.URL, &PushOptions{
+		TLSConfig: &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: []tls.Certificate{clientCert},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if commonName != "push-client" {
+		t.Fatalf("unexpected client certificate common name; got %q; want %q", commonName, "push-client")
+	}
+
+	// The TLS config may be set at the custom client.
+	commonName = ""
+	err = s.PushMetrics(ctx, srv.URL, &PushOptions{
+		Client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      rootCAs,
+					Certificates: []tls.Certificate{clientCert},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if commonName != "push-client" {
+		t.Fatalf("unexpected client certificate common name; got %q; want %q", commonName, "push-client")
+	}
+}
+
+func TestPushMetricsAuth(t *testing.T) {
+	var authHeader string
+	var fooHeader []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		fooHeader = r.Header.Values("Foo")
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	s.NewCounter("foo").Inc()
+	ctx := context.Background()
+
+	// Basic auth and headers
+	header := http.Header{
+		"Foo": []string{"bar"},
+	}
+	opts := &PushOptions{
+		Headers:           []string{"Foo: baz"},
+		Header:            header,
+		BasicAuthUser:     "user",
+		BasicAuthPassword: "secret",
+	}
+	if err := s.PushMetrics(ctx, srv.URL, opts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if authHeader != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatalf("unexpected Authorization header; got %q; want %q", authHeader, "Basic dXNlcjpzZWNyZXQ=")
+	}
+	if !reflect.DeepEqual(fooHeader, []string{"baz", "bar"}) {
+		t.Fatalf("unexpected Foo header; got %q; want %q", fooHeader, []string{"baz", "bar"})
+	}
+	headerExpected := http.Header{
+		"Foo": []string{"bar"},
+	}
+	if !reflect.DeepEqual(header, headerExpected) {
+		t.Fatalf("unexpected modification of the Header passed by the caller; got %v; want %v", header, headerExpected)
+	}
+
+	// Bearer token file
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("token1\n"), 0600); err != nil {
+		t.Fatalf("cannot write token file: %s", err)
+	}
+	pc, err := newPushContext(srv.URL, &PushOptions{
+		BearerTokenFile: tokenPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := pc.pushMetrics(ctx, s.WritePrometheus); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if authHeader != "Bearer token1" {
+		t.Fatalf("unexpected Authorization header; got %q; want %q", authHeader, "Bearer token1")
+	}
+
+	// The rotated token must be picked up after the re-read interval.
+	if err := os.WriteFile(tokenPath, []byte("token2"), 0600); err != nil {
+		t.Fatalf("cannot write token file: %s", err)
+	}
+	pc.bearerTokenFile.lastReadTime = time.Now().Add(-bearerTokenFileRereadInterval)
+	if err := pc.pushMetrics(ctx, s.WritePrometheus); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if authHeader != "Bearer token2" {
+		t.Fatalf("unexpected Authorization header; got %q; want %q", authHeader, "Bearer token2")
+	}
+
+	// The previous token must be used if the file cannot be re-read.
+	if err := os.Remove(tokenPath); err != nil {
+		t.Fatalf("cannot remove token file: %s", err)
+	}
+	pc.bearerTokenFile.lastReadTime = time.Now().Add(-bearerTokenFileRereadInterval)
+	if err := pc.pushMetrics(ctx, s.WritePrometheus); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if authHeader != "Bearer token2" {
+		t.Fatalf("unexpected Authorization header; got %q; want %q", authHeader, "Bearer token2")
+	}
+}
+
+func TestPushOptionsAuthFailure(t *testing.T) {
+	f := func(opts *PushOptions) {
+		t.Helper()
+		if _, err := newPushContext("https://foobar", opts); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f(&PushOptions{
+		BasicAuthPassword: "secret",
+	})
+	f(&PushOptions{
+		BasicAuthUser:   "user",
+		BearerTokenFile: "/path/to/token",
+	})
+	f(&PushOptions{
+		BearerTokenFile: filepath.Join(t.TempDir(), "missing"),
+	})
+	f(&PushOptions{
+		Client:    &http.Client{},
+		TLSConfig: &tls.Config{},
+	})
+}
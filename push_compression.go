@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression values for PushOptions.Compression.
+const (
+	// PushCompressionNone disables compression of the pushed request bodies.
+	PushCompressionNone = "none"
+
+	// PushCompressionGzip enables gzip compression of the pushed request bodies. This is the default.
+	PushCompressionGzip = "gzip"
+
+	// PushCompressionZstd enables zstd compression of the pushed request bodies.
+	//
+	// zstd encoder must be registered via RegisterPushCompressor, since the package has no zstd implementation
+	// in order to stay dependency-free.
+	PushCompressionZstd = "zstd"
+)
+
+// PushCompressor is a compressing writer for pushed request bodies.
+//
+// For example, *gzip.Writer from compress/gzip and *zstd.Encoder from github.com/klauspost/compress/zstd
+// implement PushCompressor.
+type PushCompressor interface {
+	io.WriteCloser
+
+	// Reset discards the state of the compressor and makes it writing compressed data to w.
+	Reset(w io.Writer)
+}
+
+// RegisterPushCompressor registers the compressor for the given Content-Encoding, which may be used in PushOptions.Compression.
+//
+// newCompressor must return new compressor writing compressed data to w. Compressors are pooled and re-used via Reset.
+// For example, the following code registers zstd compressor from github.com/klauspost/compress/zstd:
+//
+//	metrics.RegisterPushCompressor(metrics.PushCompressionZstd, func(w io.Writer) metrics.PushCompressor {
+//		zw, err := zstd.NewWriter(w)
+//		if err != nil {
+//			panic(err)
+//		}
+//		return zw
+//	})
+//
+// RegisterPushCompressor must be called before initializing the pushes, which use the encoding.
+func RegisterPushCompressor(encoding string, newCompressor func(w io.Writer) PushCompressor) {
+	if encoding == "" || encoding == PushCompressionNone || encoding == PushCompressionGzip {
+		panic(fmt.Errorf("BUG: cannot register compressor for %q encoding", encoding))
+	}
+	if newCompressor == nil {
+		panic(fmt.Errorf("BUG: newCompressor cannot be nil"))
+	}
+	pushCompressorsLock.Lock()
+	pushCompressors[encoding] = &pushCompressorPool{
+		newCompressor: newCompressor,
+	}
+	pushCompressorsLock.Unlock()
+}
+
+var (
+	pushCompressorsLock sync.Mutex
+	pushCompressors     = map[string]*pushCompressorPool{}
+)
+
+// getPushCompressorPool returns the pool of compressors for the given encoding.
+//
+// nil is returned for PushCompressionNone.
+func getPushCompressorPool(encoding string) (*pushCompressorPool, error) {
+	switch encoding {
+	case PushCompressionNone:
+		return nil, nil
+	case PushCompressionGzip:
+		return gzipPushCompressorPool, nil
+	}
+	pushCompressorsLock.Lock()
+	cp := pushCompressors[encoding]
+	pushCompressorsLock.Unlock()
+	if cp == nil {
+		return nil, fmt.Errorf("unsupported Compression=%q; supported values: %q, %q and encodings registered via RegisterPushCompressor",
+			encoding, PushCompressionNone, PushCompressionGzip)
+	}
+	return cp, nil
+}
+
+var gzipPushCompressorPool = &pushCompressorPool{
+	newCompressor: func(w io.Writer) PushCompressor {
+		return gzip.NewWriter(w)
+	},
+}
+
+// pushCompressorPool is a pool of compressors for a single encoding.
+type pushCompressorPool struct {
+	newCompressor func(w io.Writer) PushCompressor
+	p             sync.Pool
+}
+
+func (cp *pushCompressorPool) get(w io.Writer) PushCompressor {
+	v := cp.p.Get()
+	if v == nil {
+		return cp.newCompressor(w)
+	}
+	c := v.(PushCompressor)
+	c.Reset(w)
+	return c
+}
+
+func (cp *pushCompressorPool) put(c PushCompressor) {
+	c.Reset(io.Discard)
+	cp.p.Put(c)
+}
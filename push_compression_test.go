@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func init() {
+	// Register gzip under distinct encoding in order to test compressors registered via RegisterPushCompressor.
+	RegisterPushCompressor("x-test-gzip", func(w io.Writer) PushCompressor {
+		return gzip.NewWriter(w)
+	})
+}
+
+func TestPushMetricsCompression(t *testing.T) {
+	f := func(opts *PushOptions, statusCode int, contentEncodingsExpected []string, dataExpected string) {
+		t.Helper()
+		var contentEncodings []string
+		var data []byte
+		var reqErr error
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentEncoding := r.Header.Get("Content-Encoding")
+			contentEncodings = append(contentEncodings, contentEncoding)
+			if contentEncoding != "" && statusCode != 0 {
+				w.WriteHeader(statusCode)
+				return
+			}
+			var body io.Reader = r.Body
+			if contentEncoding != "" {
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					reqErr = err
+					return
+				}
+				body = zr
+			}
+			data, reqErr = io.ReadAll(body)
+		}))
+		defer srv.Close()
+
+		s := NewSet()
+		s.NewCounter("foo").Set(1234)
+		pc, err := newPushContext(srv.URL, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		uncompressedBefore := pc.uncompressedBytes.Get()
+		if err := pc.pushMetrics(context.Background(), s.WritePrometheus); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if reqErr != nil {
+			t.Fatalf("unexpected error: %s", reqErr)
+		}
+		if !reflect.DeepEqual(contentEncodings, contentEncodingsExpected) {
+			t.Fatalf("unexpected Content-Encoding headers; got %q; want %q", contentEncodings, contentEncodingsExpected)
+		}
+		if string(data) != dataExpected {
+			t.Fatalf("unexpected data; got\n%s\nwant\n%s", data, dataExpected)
+		}
+		if n := pc.uncompressedBytes.Get() - uncompressedBefore; n != uint64(len(dataExpected))*uint64(len(contentEncodings)) {
+			t.Fatalf("unexpected number of uncompressed bytes; got %d; want %d", n, len(dataExpected)*len(contentEncodings))
+		}
+	}
+
+	// The default compression
+	f(nil, 0, []string{"gzip"}, "foo 1234\n")
+	f(&PushOptions{
+		Compression: PushCompressionGzip,
+	}, 0, []string{"gzip"}, "foo 1234\n")
+
+	// Disabled compression
+	f(&PushOptions{
+		Compression: PushCompressionNone,
+	}, 0, []string{""}, "foo 1234\n")
+	f(&PushOptions{
+		Compression:        PushCompressionNone,
+		DisableCompression: true,
+	}, 0, []string{""}, "foo 1234\n")
+
+	// Registered compressor
+	f(&PushOptions{
+		Compression: "x-test-gzip",
+	}, 0, []string{"x-test-gzip"}, "foo 1234\n")
+
+	// Compression for transformed metrics
+	f(&PushOptions{
+		Compression: "x-test-gzip",
+		ExtraLabels: `a="b"`,
+	}, 0, []string{"x-test-gzip"}, `foo{a="b"} 1234`+"\n")
+
+	// Fall back to uncompressed pushes on 415 Unsupported Media Type
+	f(&PushOptions{
+		Compression: "x-test-gzip",
+	}, http.StatusUnsupportedMediaType, []string{"x-test-gzip", ""}, "foo 1234\n")
+}
+
+func TestPushMetricsCompressionFallbackPersists(t *testing.T) {
+	var contentEncodings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentEncoding := r.Header.Get("Content-Encoding")
+		contentEncodings = append(contentEncodings, contentEncoding)
+		if contentEncoding != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	s.NewCounter("foo").Inc()
+	pc, err := newPushContext(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := pc.pushMetrics(context.Background(), s.WritePrometheus); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	contentEncodingsExpected := []string{"gzip", "", ""}
+	if !reflect.DeepEqual(contentEncodings, contentEncodingsExpected) {
+		t.Fatalf("unexpected Content-Encoding headers; got %q; want %q", contentEncodings, contentEncodingsExpected)
+	}
+}
+
+func TestPushOptionsCompressionFailure(t *testing.T) {
+	f := func(opts *PushOptions) {
+		t.Helper()
+		if _, err := newPushContext("http://foobar", opts); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f(&PushOptions{
+		Compression: "unknown",
+	})
+	f(&PushOptions{
+		Compression:        PushCompressionGzip,
+		DisableCompression: true,
+	})
+}
+
+func TestRegisterPushCompressorFailure(t *testing.T) {
+	newCompressor := func(w io.Writer) PushCompressor {
+		return gzip.NewWriter(w)
+	}
+	expectPanic(t, "empty encoding", func() {
+		RegisterPushCompressor("", newCompressor)
+	})
+	expectPanic(t, "gzip encoding", func() {
+		RegisterPushCompressor(PushCompressionGzip, newCompressor)
+	})
+	expectPanic(t, "nil compressor", func() {
+		RegisterPushCompressor("foo", nil)
+	})
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PushTarget is a destination for InitPushMulti* functions.
+type PushTarget struct {
+	// URL is the url to push metrics to.
+	URL string
+
+	// Name is an optional name for the target. It is used as `target` label value in push metrics
+	// such as metrics_push_errors_total. The index of the target is used by default.
+	Name string
+
+	// Headers is an optional list of HTTP headers to add to every push request to the target
+	// in addition to PushOptions.Headers. Every item must have the form `Header: value`.
+	Headers []string
+
+	// ExtraLabels is an optional comma-separated list of `label="value"` labels, which must be added to all the metrics
+	// pushed to the target in addition to PushOptions.ExtraLabels.
+	ExtraLabels string
+}
+
+// InitPushMulti sets up periodic push for globally registered metrics to the given targets with the given interval.
+//
+// If pushProcessMetrics is set to true, then 'process_*' and `go_*` metrics are also pushed to targets.
+//
+// See InitPushMultiExtWithOptions for details.
+func InitPushMulti(targets []PushTarget, interval time.Duration, pushProcessMetrics bool) error {
+	return InitPushMultiWithOptions(context.Background(), targets, interval, pushProcessMetrics, nil)
+}
+
+// InitPushMultiWithOptions sets up periodic push for globally registered metrics to the given targets with the given interval.
+//
+// If pushProcessMetrics is set to true, then 'process_*' and `go_*` metrics are also pushed to targets.
+//
+// See InitPushMultiExtWithOptions for details.
+func InitPushMultiWithOptions(ctx context.Context, targets []PushTarget, interval time.Duration, pushProcessMetrics bool, opts *PushOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, pushProcessMetrics)
+	}
+	return InitPushMultiExtWithOptions(ctx, targets, interval, writeMetrics, opts)
+}
+
+// InitPushMultiWithOptions sets up periodic push for metrics from s to the given targets with the given interval.
+//
+// See InitPushMultiExtWithOptions for details.
+func (s *Set) InitPushMultiWithOptions(ctx context.Context, targets []PushTarget, interval time.Duration, opts *PushOptions) error {
+	return InitPushMultiExtWithOptions(ctx, targets, interval, s.WritePrometheus, opts)
+}
+
+// InitPushMultiExtWithOptions sets up periodic push for metrics obtained by calling writeMetrics to the given targets
+// with the given interval.
+//
+// The metrics are rendered once per interval and they are pushed to all the targets concurrently.
+// Every target has its own retry state, so slow or failing targets don't delay pushes to other targets.
+// If the previous push to a target is still in progress, then the target receives only the most recently rendered metrics
+// after the push is finished.
+//
+// opts contains options shared by all the targets. Per-target headers and extra labels are added to the shared ones.
+// Push metrics such as metrics_push_errors_total have `target` label with PushTarget.Name or with the target index.
+//
+// The periodic push is stopped when ctx is canceled.
+// It is possible to wait until all the background push workers are stopped on a WaitGroup passed via opts.WaitGroup.
+func InitPushMultiExtWithOptions(ctx context.Context, targets []PushTarget, interval time.Duration, writeMetrics func(w io.Writer), opts *PushOptions) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("targets cannot be empty")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive; got %s", interval)
+	}
+	var optsCopy PushOptions
+	if opts != nil {
+		optsCopy = *opts
+	}
+	pcs := make([]*pushContext, len(targets))
+	for i, target := range targets {
+		targetOpts := optsCopy
+		targetOpts.Headers = append(append([]string{}, optsCopy.Headers...), target.Headers...)
+		if target.ExtraLabels != "" {
+			if targetOpts.ExtraLabels != "" {
+				targetOpts.ExtraLabels += ","
+			}
+			targetOpts.ExtraLabels += target.ExtraLabels
+		}
+		name := target.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		pc, err := newPushContextForTarget(target.URL, &targetOpts, name)
+		if err != nil {
+			return fmt.Errorf("cannot initialize push to target %q: %w", name, err)
+		}
+		pushMetricsSet.GetOrCreateFloatCounter(fmt.Sprintf(`metrics_push_interval_seconds{%s}`, pc.selfMetricsLabels)).Set(interval.Seconds())
+		pc.initMaxBackoff(interval)
+		pcs[i] = pc
+	}
+
+	wg := optsCopy.WaitGroup
+	if wg != nil {
+		wg.Add(1)
+	}
+	var workersWG sync.WaitGroup
+	workers := make([]*pushMultiWorker, len(pcs))
+	for i, pc := range pcs {
+		pmw := &pushMultiWorker{
+			pc:       pc,
+			interval: interval,
+			dataCh:   make(chan []byte, 1),
+		}
+		workers[i] = pmw
+		workersWG.Add(1)
+		go func() {
+			defer workersWG.Done()
+			pmw.run(ctx)
+		}()
+	}
+	stopTicker := getClock().startTicker(interval, func() {
+		// Render the metrics once for all the targets. The rendered data is shared by the workers in read-only mode.
+		bb := getBytesBuffer()
+		writeMetrics(bb)
+		data := append([]byte{}, bb.B...)
+		putBytesBuffer(bb)
+		for _, pmw := range workers {
+			pmw.send(data)
+		}
+	})
+	go func() {
+		<-ctx.Done()
+		stopTicker()
+		workersWG.Wait()
+		if wg != nil {
+			wg.Done()
+		}
+	}()
+	return nil
+}
+
+// pushMultiWorker pushes metrics to a single target for InitPushMulti* functions.
+type pushMultiWorker struct {
+	pc       *pushContext
+	interval time.Duration
+
+	// dataCh contains the most recently rendered metrics, which weren't pushed yet.
+	dataCh chan []byte
+}
+
+// send schedules the push of data to the target.
+//
+// It never blocks. The previously scheduled data is replaced with data if it isn't pushed yet.
+func (pmw *pushMultiWorker) send(data []byte) {
+	for {
+		select {
+		case pmw.dataCh <- data:
+			return
+		default:
+		}
+		select {
+		case <-pmw.dataCh:
+		default:
+		}
+	}
+}
+
+func (pmw *pushMultiWorker) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-pmw.dataCh:
+			writeMetrics := func(w io.Writer) {
+				_, _ = w.Write(data)
+			}
+			// Limit the push duration including retries by the interval, so it doesn't overlap the next push.
+			ctxLocal, cancel := context.WithTimeout(ctx, pmw.interval)
+			err := pmw.pc.pushMetrics(ctxLocal, writeMetrics)
+			cancel()
+			if err != nil {
+				log.Printf("ERROR: metrics.push: %s", err)
+			}
+		}
+	}
+}
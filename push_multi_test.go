@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInitPushMulti(t *testing.T) {
+	dataCh := make(chan string, 100)
+	srvGood := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		select {
+		case dataCh <- r.Header.Get("X-Target") + " " + string(data):
+		default:
+		}
+	}))
+	defer srvGood.Close()
+
+	// The hanging target must not delay pushes to other targets.
+	stopCh := make(chan struct{})
+	srvHanging := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-stopCh:
+		}
+	}))
+	defer srvHanging.Close()
+	defer close(stopCh)
+
+	srvFailing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srvFailing.Close()
+
+	s := NewSet()
+	s.NewCounter("foo").Set(42)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	targets := []PushTarget{
+		{
+			URL: srvHanging.URL,
+		},
+		{
+			URL:         srvFailing.URL,
+			Name:        "failing",
+			ExtraLabels: `cluster="old"`,
+		},
+		{
+			URL:         srvGood.URL,
+			Name:        "good",
+			Headers:     []string{"X-Target: good"},
+			ExtraLabels: `cluster="new"`,
+		},
+	}
+	err := s.InitPushMultiWithOptions(ctx, targets, 100*time.Millisecond, &PushOptions{
+		ExtraLabels:        `job="test"`,
+		DisableCompression: true,
+		MaxRetries:         10,
+		MinBackoff:         10 * time.Millisecond,
+		WaitGroup:          &wg,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	dataExpected := `good foo{job="test",cluster="new"} 42` + "\n"
+	for i := 0; i < 3; i++ {
+		select {
+		case data := <-dataCh:
+			if data != dataExpected {
+				t.Fatalf("unexpected data pushed to the good target; got %q; want %q", data, dataExpected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout when waiting for push to the good target")
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	// Push metrics must be exposed per target.
+	var bb strings.Builder
+	pushMetricsSet.WritePrometheus(&bb)
+	result := bb.String()
+	for _, target := range []string{"0", "failing", "good"} {
+		series := fmt.Sprintf(`metrics_push_total{url=%q,target=%q} `, getPushTargetURL(targets, target), target)
+		if !strings.Contains(result, series) {
+			t.Fatalf("missing %s in push metrics:\n%s", series, result)
+		}
+	}
+	series := fmt.Sprintf(`metrics_push_errors_total{url=%q,target="good"} 0`, srvGood.URL)
+	if !strings.Contains(result, series) {
+		t.Fatalf("missing %s in push metrics:\n%s", series, result)
+	}
+	series = fmt.Sprintf(`metrics_push_errors_total{url=%q,target="failing"} 0`, srvFailing.URL)
+	if strings.Contains(result, series) {
+		t.Fatalf("unexpected %s in push metrics:\n%s", series, result)
+	}
+}
+
+func getPushTargetURL(targets []PushTarget, name string) string {
+	for i, target := range targets {
+		if target.Name == name || (target.Name == "" && fmt.Sprintf("%d", i) == name) {
+			return target.URL
+		}
+	}
+	return ""
+}
+
+func TestInitPushMultiFailure(t *testing.T) {
+	f := func(targets []PushTarget, interval time.Duration) {
+		t.Helper()
+		if err := InitPushMulti(targets, interval, false); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f(nil, time.Second)
+	f([]PushTarget{{URL: "http://foo"}}, 0)
+	f([]PushTarget{{URL: "http://foo"}, {URL: "bar"}}, time.Second)
+	f([]PushTarget{{URL: "http://foo", ExtraLabels: "bad"}}, time.Second)
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -241,3 +244,272 @@ func TestPushMetrics(t *testing.T) {
 		Headers: []string{"Foo: Bar", "baz:aaaa-bbb"},
 	}, "Baz: aaaa-bbb\r\nContent-Encoding: gzip\r\nContent-Type: text/plain\r\nFoo: Bar\r\n", "bar 42.12\nfoo 1234\n")
 }
+
+func TestPushWorkerStop(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	statusCode := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		mu.Lock()
+		requests = append(requests, string(data))
+		code := statusCode
+		mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	c := s.NewCounter("foo")
+	opts := &PushOptions{
+		DisableCompression: true,
+	}
+
+	// The final push must contain the metrics updated after the start of the periodic push.
+	pw, err := s.StartPush(srv.URL, time.Hour, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Set(123)
+	if err := pw.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("unexpected number of requests; got %d; want 1", len(requests))
+	}
+	if requests[0] != "foo 123\n" {
+		t.Fatalf("unexpected data; got\n%s\nwant\n%s", requests[0], "foo 123\n")
+	}
+
+	// Subsequent Stop calls do not push metrics
+	if err := pw.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("unexpected number of requests after the second Stop call; got %d; want 1", len(requests))
+	}
+
+	// The error from the final push is returned from Stop
+	mu.Lock()
+	statusCode = http.StatusBadRequest
+	mu.Unlock()
+	pw, err = s.StartPush(srv.URL, time.Hour, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = pw.Stop(ctx)
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if errSecond := pw.Stop(ctx); errSecond != err {
+		t.Fatalf("unexpected error on the second Stop call; got %v; want %v", errSecond, err)
+	}
+}
+
+func TestPushWorkerPushNow(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		mu.Lock()
+		requests = append(requests, string(data))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	c := s.NewCounter("foo")
+	opts := &PushOptions{
+		DisableCompression: true,
+	}
+	pw, err := s.StartPush(srv.URL, time.Hour, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// PushNow must push metrics before the first periodic push.
+	c.Set(1)
+	if err := pw.PushNow(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Set(2)
+	if err := pw.PushNow(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := pw.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	requestsExpected := []string{"foo 1\n", "foo 2\n", "foo 2\n"}
+	if !reflect.DeepEqual(requests, requestsExpected) {
+		t.Fatalf("unexpected requests; got %q; want %q", requests, requestsExpected)
+	}
+
+	// PushNow must return ErrPushWorkerStopped after Stop.
+	if err := pw.PushNow(context.Background()); !errors.Is(err, ErrPushWorkerStopped) {
+		t.Fatalf("unexpected error; got %v; want %v", err, ErrPushWorkerStopped)
+	}
+	if len(requests) != len(requestsExpected) {
+		t.Fatalf("unexpected number of requests after PushNow on stopped worker; got %d; want %d", len(requests), len(requestsExpected))
+	}
+
+	// PushNow must return ErrPushWorkerStopped after the context passed to StartPush* is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	pc, err := newPushContext(srv.URL, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	pw, err = startPush(ctx, pc, time.Hour, s.WritePrometheus, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cancel()
+	if err := pw.PushNow(context.Background()); !errors.Is(err, ErrPushWorkerStopped) {
+		t.Fatalf("unexpected error; got %v; want %v", err, ErrPushWorkerStopped)
+	}
+}
+
+func TestStartPushFailure(t *testing.T) {
+	f := func(pushURL string, interval time.Duration) {
+		t.Helper()
+		pw, err := StartPush(pushURL, interval, false, nil)
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if pw != nil {
+			t.Fatalf("expecting nil PushWorker")
+		}
+	}
+	f("foobar", time.Second)
+	f("http://foobar", 0)
+}
+
+func TestPushMetricsRetry(t *testing.T) {
+	f := func(failures int, statusCode int, maxRetries int, expectedRequests, expectedRetries uint64, expectError bool) {
+		t.Helper()
+		var requests uint64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddUint64(&requests, 1)
+			if n <= uint64(failures) {
+				w.WriteHeader(statusCode)
+			}
+		}))
+		defer srv.Close()
+
+		s := NewSet()
+		s.NewCounter("foo").Inc()
+		pc, err := newPushContext(srv.URL, &PushOptions{
+			MaxRetries: maxRetries,
+			MinBackoff: time.Millisecond,
+			MaxBackoff: 2 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		retriesBefore := pc.pushRetries.Get()
+		err = pc.pushMetrics(context.Background(), s.WritePrometheus)
+		if expectError && err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if !expectError && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n := atomic.LoadUint64(&requests); n != expectedRequests {
+			t.Fatalf("unexpected number of requests; got %d; want %d", n, expectedRequests)
+		}
+		if n := pc.pushRetries.Get() - retriesBefore; n != expectedRetries {
+			t.Fatalf("unexpected number of retries; got %d; want %d", n, expectedRetries)
+		}
+	}
+
+	// No retries by default
+	f(1, http.StatusBadGateway, 0, 1, 0, true)
+
+	// Retry server-side errors
+	f(2, http.StatusBadGateway, 3, 3, 2, false)
+	f(3, http.StatusServiceUnavailable, 3, 4, 3, false)
+
+	// Too many failures
+	f(5, http.StatusBadGateway, 3, 4, 3, true)
+
+	// Client-side errors aren't retried
+	f(1, http.StatusBadRequest, 3, 1, 0, true)
+}
+
+func TestPushMetricsRetrySkipped(t *testing.T) {
+	var requests uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	s.NewCounter("foo").Inc()
+	pc, err := newPushContext(srv.URL, &PushOptions{
+		MaxRetries: 3,
+		MinBackoff: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	skippedBefore := pc.pushRetriesSkipped.Get()
+
+	// The retry cannot complete before the deadline, so it must be skipped.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pc.pushMetrics(ctx, s.WritePrometheus); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if n := atomic.LoadUint64(&requests); n != 1 {
+		t.Fatalf("unexpected number of requests; got %d; want 1", n)
+	}
+	if n := pc.pushRetriesSkipped.Get() - skippedBefore; n != 1 {
+		t.Fatalf("unexpected number of skipped retries; got %d; want 1", n)
+	}
+}
+
+func TestPushOptionsRetryFailure(t *testing.T) {
+	f := func(opts *PushOptions) {
+		t.Helper()
+		if _, err := newPushContext("http://foobar", opts); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f(&PushOptions{
+		MaxRetries: -1,
+	})
+	f(&PushOptions{
+		MinBackoff: -time.Second,
+	})
+	f(&PushOptions{
+		MaxBackoff: -time.Second,
+	})
+	f(&PushOptions{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Millisecond,
+	})
+}
+
+func TestGetBackoffWithJitter(t *testing.T) {
+	f := func(backoff time.Duration) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			d := getBackoffWithJitter(backoff)
+			if d < backoff/2 || d > backoff {
+				t.Fatalf("unexpected backoff for %s; got %s; want in the range [%s ... %s]", backoff, d, backoff/2, backoff)
+			}
+		}
+	}
+	f(0)
+	f(1)
+	f(time.Millisecond)
+	f(time.Second)
+}
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// pushDeltas converts cumulative counter values into deltas since the previous successful push.
+//
+// It is used by pushContext when PushOptions.CountersAsDeltas is set.
+// pushDeltas isn't safe for concurrent use - pushes via the same pushContext are performed sequentially.
+type pushDeltas struct {
+	// pushed contains counter values for the previous successful push keyed by series name with labels.
+	pushed map[string]pushDeltaValue
+
+	// pending contains counter values for the push in progress. They are moved to pushed on success.
+	pending map[string]pushDeltaValue
+
+	// types contains metric types from `# TYPE` lines for the push in progress.
+	types map[string]string
+}
+
+// pushDeltaValue is a counter value.
+//
+// Integer values are stored as uint64 in order to avoid precision loss for big counters.
+type pushDeltaValue struct {
+	u     uint64
+	f     float64
+	isInt bool
+}
+
+func newPushDeltas() *pushDeltas {
+	return &pushDeltas{
+		pushed:  make(map[string]pushDeltaValue),
+		pending: make(map[string]pushDeltaValue),
+		types:   make(map[string]string),
+	}
+}
+
+// convert appends src in Prometheus text exposition format to dst with counter values substituted by deltas
+// since the previous successful push and returns the result.
+//
+// Counters are detected by `# TYPE <name> counter` lines. Samples without `# TYPE` line for their metric name
+// are treated as counters if the name has `_total` suffix according to Prometheus naming conventions.
+// Counters missing at the previous successful push are written with their full value.
+//
+// Call commit after the successful push of the result.
+func (pd *pushDeltas) convert(dst, src []byte) []byte {
+	for k := range pd.pending {
+		delete(pd.pending, k)
+	}
+	for k := range pd.types {
+		delete(pd.types, k)
+	}
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '#' {
+			pd.addType(string(line))
+			dst = append(dst, line...)
+			dst = append(dst, '\n')
+			continue
+		}
+		dst = pd.appendLine(dst, string(line))
+	}
+	return dst
+}
+
+// commit marks counter values from the last convert call as successfully pushed.
+//
+// Counters, which were missing in the last convert call, are forgotten, so they are written with their full value
+// if they appear again.
+func (pd *pushDeltas) commit() {
+	pd.pushed, pd.pending = pd.pending, pd.pushed
+}
+
+func (pd *pushDeltas) addType(line string) {
+	tail := strings.TrimPrefix(line, "# TYPE ")
+	if len(tail) == len(line) {
+		return
+	}
+	n := strings.IndexByte(tail, ' ')
+	if n <= 0 {
+		return
+	}
+	pd.types[tail[:n]] = strings.TrimSpace(tail[n+1:])
+}
+
+func (pd *pushDeltas) isCounter(metricName string) bool {
+	if metricType, ok := pd.types[metricName]; ok {
+		return metricType == "counter"
+	}
+	return strings.HasSuffix(metricName, "_total")
+}
+
+// appendLine appends the sample line to dst with the counter value substituted by delta.
+//
+// Lines with other metric types and lines, which cannot be parsed, are appended as is.
+func (pd *pushDeltas) appendLine(dst []byte, line string) []byte {
+	n := strings.LastIndexByte(line, '}')
+	if n < 0 {
+		n = strings.IndexAny(line, " \t") - 1
+	}
+	if n < 0 {
+		return appendLine(dst, line)
+	}
+	series := line[:n+1]
+	metricName := series
+	if n := strings.IndexByte(series, '{'); n >= 0 {
+		metricName = series[:n]
+	}
+	if !pd.isCounter(metricName) {
+		return appendLine(dst, line)
+	}
+	tail := strings.TrimLeft(line[n+1:], " \t")
+	valueStr := tail
+	timestampStr := ""
+	if n := strings.IndexAny(tail, " \t"); n >= 0 {
+		valueStr = tail[:n]
+		timestampStr = tail[n:]
+	}
+	var v pushDeltaValue
+	if u, err := strconv.ParseUint(valueStr, 10, 64); err == nil {
+		v.u = u
+		v.f = float64(u)
+		v.isInt = true
+	} else if f, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		v.f = f
+	} else {
+		return appendLine(dst, line)
+	}
+	pd.pending[series] = v
+
+	dst = append(dst, series...)
+	dst = append(dst, ' ')
+	prev, ok := pd.pushed[series]
+	switch {
+	case !ok:
+		// The counter is new since the previous push.
+		dst = append(dst, valueStr...)
+	case v.isInt && prev.isInt:
+		if v.u < prev.u {
+			// The counter has been reset since the previous push.
+			dst = append(dst, valueStr...)
+		} else {
+			dst = strconv.AppendUint(dst, v.u-prev.u, 10)
+		}
+	default:
+		if v.f < prev.f {
+			dst = append(dst, valueStr...)
+		} else {
+			dst = strconv.AppendFloat(dst, v.f-prev.f, 'g', -1, 64)
+		}
+	}
+	dst = append(dst, timestampStr...)
+	dst = append(dst, '\n')
+	return dst
+}
+
+func appendLine(dst []byte, line string) []byte {
+	dst = append(dst, line...)
+	return append(dst, '\n')
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPushDeltasConvert(t *testing.T) {
+	pd := newPushDeltas()
+	f := func(src, resultExpected string, commit bool) {
+		t.Helper()
+		result := pd.convert(nil, []byte(src))
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+		}
+		if commit {
+			pd.commit()
+		}
+	}
+
+	// The first push contains full values
+	f(`foo_total 10
+bar_total{x="y"} 1.5
+# TYPE baz counter
+baz 3
+gauge 5
+`, `foo_total 10
+bar_total{x="y"} 1.5
+# TYPE baz counter
+baz 3
+gauge 5
+`, true)
+
+	// Subsequent push contains deltas for counters
+	f(`foo_total 15
+bar_total{x="y"} 2
+# TYPE baz counter
+baz 3
+gauge 7
+new_total 4
+`, `foo_total 5
+bar_total{x="y"} 0.5
+# TYPE baz counter
+baz 0
+gauge 7
+new_total 4
+`, false)
+
+	// The previous push failed, so its deltas must be rolled into the next push
+	f(`foo_total 20
+bar_total{x="y"} 2
+# TYPE baz counter
+baz 4
+gauge 7
+new_total 6
+`, `foo_total 10
+bar_total{x="y"} 0.5
+# TYPE baz counter
+baz 1
+gauge 7
+new_total 6
+`, true)
+
+	// Counter reset, timestamps, metadata for non-counters with _total suffix and invalid lines
+	f(`foo_total 3 1234
+# TYPE bar_total gauge
+bar_total{x="y"} 2
+new_total{a="}"} 8
+invalid_total
+`, `foo_total 3 1234
+# TYPE bar_total gauge
+bar_total{x="y"} 2
+new_total{a="}"} 8
+invalid_total
+`, true)
+	f(`foo_total 5 1235
+new_total{a="}"} 9
+`, `foo_total 2 1235
+new_total{a="}"} 1
+`, true)
+}
+
+func TestPushMetricsCountersAsDeltas(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("cannot read request body: %s", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bodies = append(bodies, string(body))
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	c := s.NewCounter("requests_total")
+	fc := s.NewFloatCounter("bytes_total")
+	pc, err := newPushContext(srv.URL, &PushOptions{
+		DisableCompression: true,
+		CountersAsDeltas:   true,
+		ExtraLabels:        `instance="foo"`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	push := func(bodyExpected string) {
+		t.Helper()
+		if err := pc.pushMetrics(context.Background(), s.WritePrometheus); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		mu.Lock()
+		body := bodies[len(bodies)-1]
+		mu.Unlock()
+		if body != bodyExpected {
+			t.Fatalf("unexpected body; got\n%s\nwant\n%s", body, bodyExpected)
+		}
+	}
+
+	c.Add(3)
+	fc.Add(1.5)
+	push(`bytes_total{instance="foo"} 1.5
+requests_total{instance="foo"} 3
+`)
+
+	c.Add(2)
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	if err := pc.pushMetrics(context.Background(), s.WritePrometheus); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+
+	c.Add(1)
+	push(`bytes_total{instance="foo"} 0
+requests_total{instance="foo"} 3
+`)
+}
+
+func TestPushCountersAsDeltasInfluxFailure(t *testing.T) {
+	err := PushInfluxLineProtocol(context.Background(), "http://localhost:8428/write", "", false, &PushOptions{
+		CountersAsDeltas: true,
+	})
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
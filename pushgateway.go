@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PushToGateway pushes globally registered metrics to Prometheus Pushgateway at gatewayURL.
+//
+// The metrics are pushed to the group identified by the given job and the optional grouping labels.
+// All the previously pushed metrics for the group are replaced by the pushed metrics.
+// See https://github.com/prometheus/pushgateway#url
+//
+// opts may contain additional configuration options if non-nil.
+// By default the PUT method is used.
+//
+// See also InitPushGateway and DeleteFromGateway.
+func PushToGateway(ctx context.Context, gatewayURL, job string, grouping map[string]string, opts *PushOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, false)
+	}
+	return pushToGateway(ctx, gatewayURL, job, grouping, writeMetrics, opts)
+}
+
+// PushToGateway pushes s metrics to Prometheus Pushgateway at gatewayURL.
+//
+// The metrics are pushed to the group identified by the given job and the optional grouping labels.
+// All the previously pushed metrics for the group are replaced by the pushed metrics.
+// See https://github.com/prometheus/pushgateway#url
+//
+// opts may contain additional configuration options if non-nil.
+// By default the PUT method is used.
+func (s *Set) PushToGateway(ctx context.Context, gatewayURL, job string, grouping map[string]string, opts *PushOptions) error {
+	return pushToGateway(ctx, gatewayURL, job, grouping, s.WritePrometheus, opts)
+}
+
+// InitPushGateway sets up periodic push for globally registered metrics to Prometheus Pushgateway at gatewayURL with the given interval.
+//
+// The metrics are pushed to the group identified by the given job and the optional grouping labels.
+// See https://github.com/prometheus/pushgateway#url
+//
+// The periodic push is stopped when ctx is canceled.
+// Push errors are logged and counted in `metrics_push_errors_total` metric exposed via WriteProcessMetrics.
+// Call DeleteFromGateway after the periodic push is stopped in order to delete the pushed metrics from Pushgateway.
+//
+// opts may contain additional configuration options if non-nil.
+// By default the PUT method is used.
+func InitPushGateway(ctx context.Context, gatewayURL, job string, grouping map[string]string, interval time.Duration, opts *PushOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, false)
+	}
+	return initPushGateway(ctx, gatewayURL, job, grouping, interval, writeMetrics, opts)
+}
+
+// InitPushGateway sets up periodic push for s metrics to Prometheus Pushgateway at gatewayURL with the given interval.
+//
+// The metrics are pushed to the group identified by the given job and the optional grouping labels.
+// See https://github.com/prometheus/pushgateway#url
+//
+// The periodic push is stopped when ctx is canceled.
+// Push errors are logged and counted in `metrics_push_errors_total` metric exposed via WriteProcessMetrics.
+//
+// opts may contain additional configuration options if non-nil.
+// By default the PUT method is used.
+func (s *Set) InitPushGateway(ctx context.Context, gatewayURL, job string, grouping map[string]string, interval time.Duration, opts *PushOptions) error {
+	return initPushGateway(ctx, gatewayURL, job, grouping, interval, s.WritePrometheus, opts)
+}
+
+// DeleteFromGateway deletes all the metrics for the group identified by the given job and grouping labels
+// from Prometheus Pushgateway at gatewayURL.
+//
+// This function is usually called before exiting the job, which pushed metrics via PushToGateway or InitPushGateway.
+//
+// opts may contain additional configuration options if non-nil. Only HTTP client, headers and auth options are used.
+func DeleteFromGateway(ctx context.Context, gatewayURL, job string, grouping map[string]string, opts *PushOptions) error {
+	pushURL, err := getGatewayURL(gatewayURL, job, grouping)
+	if err != nil {
+		return err
+	}
+	pc, err := newPushContext(pushURL, opts)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, pc.pushURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot initialize request for deleting metrics from %q: %w", pc.pushURLRedacted, err)
+	}
+	if err := pc.setRequestHeaders(req); err != nil {
+		return err
+	}
+	resp, err := pc.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot delete metrics from %q: %w", pc.pushURLRedacted, err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code in response from %q: %d; expecting 2xx; response body: %q", pc.pushURLRedacted, resp.StatusCode, body)
+	}
+	return nil
+}
+
+func pushToGateway(ctx context.Context, gatewayURL, job string, grouping map[string]string, writeMetrics func(w io.Writer), opts *PushOptions) error {
+	pushURL, err := getGatewayURL(gatewayURL, job, grouping)
+	if err != nil {
+		return err
+	}
+	return PushMetricsExt(ctx, pushURL, writeMetrics, getGatewayPushOptions(opts))
+}
+
+func initPushGateway(ctx context.Context, gatewayURL, job string, grouping map[string]string, interval time.Duration, writeMetrics func(w io.Writer), opts *PushOptions) error {
+	pushURL, err := getGatewayURL(gatewayURL, job, grouping)
+	if err != nil {
+		return err
+	}
+	return InitPushExtWithOptions(ctx, pushURL, interval, writeMetrics, getGatewayPushOptions(opts))
+}
+
+func getGatewayPushOptions(opts *PushOptions) *PushOptions {
+	var optsCopy PushOptions
+	if opts != nil {
+		optsCopy = *opts
+	}
+	if optsCopy.Method == "" {
+		optsCopy.Method = http.MethodPut
+	}
+	return &optsCopy
+}
+
+// getGatewayURL returns Pushgateway url for the group identified by the given job and grouping labels.
+//
+// See https://github.com/prometheus/pushgateway#url
+func getGatewayURL(gatewayURL, job string, grouping map[string]string) (string, error) {
+	if job == "" {
+		return "", fmt.Errorf("job cannot be empty")
+	}
+	keys := make([]string, 0, len(grouping))
+	for k := range grouping {
+		if err := validateIdent(k); err != nil {
+			return "", fmt.Errorf("invalid grouping label name: %w", err)
+		}
+		if k == "job" {
+			return "", fmt.Errorf("grouping labels cannot contain %q label; pass it via job arg instead", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(strings.TrimSuffix(gatewayURL, "/"))
+	sb.WriteString("/metrics")
+	writeGatewayURLLabel(&sb, "job", job)
+	for _, k := range keys {
+		writeGatewayURLLabel(&sb, k, grouping[k])
+	}
+	return sb.String(), nil
+}
+
+// writeGatewayURLLabel writes `/<name>/<value>` path segments to sb.
+//
+// The value is base64-encoded if it contains `/` or if it is empty, since such values cannot be passed in the path as is.
+func writeGatewayURLLabel(sb *strings.Builder, name, value string) {
+	sb.WriteByte('/')
+	sb.WriteString(name)
+	if value == "" || strings.Contains(value, "/") {
+		sb.WriteString("@base64/")
+		if value == "" {
+			// Pushgateway requires at least a single padding char for empty base64-encoded values.
+			sb.WriteByte('=')
+		} else {
+			sb.WriteString(base64.RawURLEncoding.EncodeToString([]byte(value)))
+		}
+		return
+	}
+	sb.WriteByte('/')
+	sb.WriteString(url.PathEscape(value))
+}
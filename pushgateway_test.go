@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetGatewayURL(t *testing.T) {
+	f := func(job string, grouping map[string]string, resultExpected string) {
+		t.Helper()
+		result, err := getGatewayURL("http://pushgateway:9091/", job, grouping)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected url; got %q; want %q", result, resultExpected)
+		}
+	}
+	f("foo", nil, "http://pushgateway:9091/metrics/job/foo")
+	f("foo bar", map[string]string{
+		"instance": "host:1234",
+		"az":       "us-east-1",
+	}, "http://pushgateway:9091/metrics/job/foo%20bar/az/us-east-1/instance/host:1234")
+	f("a/b", map[string]string{
+		"path":  "/var/tmp",
+		"empty": "",
+	}, "http://pushgateway:9091/metrics/job@base64/YS9i/empty@base64/=/path@base64/L3Zhci90bXA")
+}
+
+func TestGetGatewayURLFailure(t *testing.T) {
+	f := func(job string, grouping map[string]string) {
+		t.Helper()
+		if _, err := getGatewayURL("http://pushgateway:9091", job, grouping); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f("", nil)
+	f("foo", map[string]string{"job": "bar"})
+	f("foo", map[string]string{"a-b": "bar"})
+}
+
+func TestPushToGateway(t *testing.T) {
+	var method, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.EscapedPath()
+		if strings.HasPrefix(path, "/metrics/job/failing_job") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	s.NewCounter("foo").Inc()
+	ctx := context.Background()
+
+	f := func(method, path string, methodExpected, pathExpected string) {
+		t.Helper()
+		if method != methodExpected {
+			t.Fatalf("unexpected method; got %q; want %q", method, methodExpected)
+		}
+		if path != pathExpected {
+			t.Fatalf("unexpected path; got %q; want %q", path, pathExpected)
+		}
+	}
+
+	grouping := map[string]string{"instance": "a/b"}
+	if err := s.PushToGateway(ctx, srv.URL, "my_job", grouping, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(method, path, "PUT", "/metrics/job/my_job/instance@base64/YS9i")
+
+	if err := s.PushToGateway(ctx, srv.URL, "my_job", nil, &PushOptions{Method: "POST"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(method, path, "POST", "/metrics/job/my_job")
+
+	if err := DeleteFromGateway(ctx, srv.URL, "my_job", grouping, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(method, path, "DELETE", "/metrics/job/my_job/instance@base64/YS9i")
+
+	if err := s.PushToGateway(ctx, srv.URL, "failing_job", nil, nil); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if err := DeleteFromGateway(ctx, srv.URL, "failing_job", nil, nil); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
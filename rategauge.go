@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRateGaugeBuckets is the maximum number of buckets in RateGauge.
+//
+// It limits memory usage for RateGauge with long windows.
+const maxRateGaugeBuckets = 60
+
+// NewRateGauge registers and returns new gauge with the given name in the default set,
+// which exposes the per-second rate of events registered via RateGauge.Add over the given window.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// This is useful for pushing metrics to systems, which cannot calculate rates on their own.
+// Prefer Counter for metrics scraped by Prometheus-compatible systems, since they calculate rates via rate() function.
+//
+// window must be positive. The window is split into per-second buckets, while the number of buckets is limited by 60,
+// so memory usage doesn't depend on the number of events and on the window size.
+//
+// The returned gauge is safe to use from concurrent goroutines.
+func NewRateGauge(name string, window time.Duration) *RateGauge {
+	return defaultSet.NewRateGauge(name, window)
+}
+
+// NewRateGauge registers and returns new RateGauge with the given name and window in s.
+//
+// See NewRateGauge for details.
+func (s *Set) NewRateGauge(name string, window time.Duration) *RateGauge {
+	rg := newRateGauge(window, clockNow())
+	return s.registerMetric(name, rg, "").(*RateGauge)
+}
+
+// RateGauge is a gauge, which exposes the per-second rate of events over the sliding window.
+//
+// The buckets are rotated lazily on Add and Get calls, so RateGauge doesn't need background goroutines.
+type RateGauge struct {
+	window         time.Duration
+	bucketDuration time.Duration
+	createdAt      time.Time
+
+	mu sync.Mutex
+
+	// buckets contains the number of events per bucketDuration in a ring buffer.
+	buckets []float64
+
+	// lastBucket is the number of the bucket for the latest update since the Unix epoch.
+	lastBucket int64
+}
+
+func newRateGauge(window time.Duration, now time.Time) *RateGauge {
+	if window <= 0 {
+		panic(fmt.Errorf("BUG: window must be positive; got %s", window))
+	}
+	n := int64(window / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	if n > maxRateGaugeBuckets {
+		n = maxRateGaugeBuckets
+	}
+	bucketDuration := window / time.Duration(n)
+	return &RateGauge{
+		window:         window,
+		bucketDuration: bucketDuration,
+		createdAt:      now,
+		buckets:        make([]float64, n),
+		lastBucket:     now.UnixNano() / int64(bucketDuration),
+	}
+}
+
+// Inc registers a single event in rg.
+func (rg *RateGauge) Inc() {
+	rg.Add(1)
+}
+
+// Add registers n events in rg.
+func (rg *RateGauge) Add(n float64) {
+	rg.addAt(n, clockNow())
+}
+
+// Get returns the per-second rate of events over the window passed to NewRateGauge.
+//
+// The rate is calculated over the lifetime of rg if it is shorter than the window.
+func (rg *RateGauge) Get() float64 {
+	return rg.getAt(clockNow())
+}
+
+func (rg *RateGauge) addAt(n float64, now time.Time) {
+	rg.mu.Lock()
+	idx := rg.rotateLocked(now)
+	rg.buckets[idx] += n
+	rg.mu.Unlock()
+}
+
+func (rg *RateGauge) getAt(now time.Time) float64 {
+	rg.mu.Lock()
+	rg.rotateLocked(now)
+	sum := float64(0)
+	for _, v := range rg.buckets {
+		sum += v
+	}
+	rg.mu.Unlock()
+
+	// The current bucket is partially filled, so the covered duration is shorter than the window.
+	bucketDuration := int64(rg.bucketDuration)
+	d := time.Duration(int64(len(rg.buckets)-1)*bucketDuration + now.UnixNano()%bucketDuration)
+	if lifetime := now.Sub(rg.createdAt); lifetime < d {
+		d = lifetime
+	}
+	// Do not divide by tiny durations in order to avoid spikes right after rg creation.
+	if d < rg.bucketDuration {
+		d = rg.bucketDuration
+	}
+	return sum / d.Seconds()
+}
+
+// rotateLocked clears the buckets, which went out of the window at now, and returns the index of the current bucket.
+func (rg *RateGauge) rotateLocked(now time.Time) int {
+	bucket := now.UnixNano() / int64(rg.bucketDuration)
+	if bucket <= rg.lastBucket {
+		// Put updates into the latest bucket if the clock goes backwards.
+		return rg.bucketIndex(rg.lastBucket)
+	}
+	if bucket-rg.lastBucket >= int64(len(rg.buckets)) {
+		// rg was idle for longer than the window.
+		for i := range rg.buckets {
+			rg.buckets[i] = 0
+		}
+	} else {
+		for b := rg.lastBucket + 1; b <= bucket; b++ {
+			rg.buckets[rg.bucketIndex(b)] = 0
+		}
+	}
+	rg.lastBucket = bucket
+	return rg.bucketIndex(bucket)
+}
+
+func (rg *RateGauge) bucketIndex(bucket int64) int {
+	return int(bucket % int64(len(rg.buckets)))
+}
+
+// MarshalTo writes rg with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (rg *RateGauge) MarshalTo(prefix string, w io.Writer) {
+	rg.marshalTo(prefix, w)
+}
+
+func (rg *RateGauge) marshalTo(prefix string, w io.Writer) {
+	writeSampleFloat64(w, prefix, rg.Get())
+}
+
+func (rg *RateGauge) metricType() string {
+	return "gauge"
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateGauge(t *testing.T) {
+	startTime := time.Unix(1000, 0)
+	rg := newRateGauge(10*time.Second, startTime)
+	if n := len(rg.buckets); n != 10 {
+		t.Fatalf("unexpected number of buckets; got %d; want 10", n)
+	}
+
+	f := func(offset time.Duration, rateExpected float64) {
+		t.Helper()
+		rate := rg.getAt(startTime.Add(offset))
+		if math.Abs(rate-rateExpected) > 1e-9 {
+			t.Fatalf("unexpected rate at %s; got %v; want %v", offset, rate, rateExpected)
+		}
+	}
+
+	// No events
+	f(0, 0)
+	f(5*time.Second, 0)
+
+	// The rate is calculated over the lifetime if it is shorter than the window.
+	// Tiny lifetimes are rounded up to the bucket duration.
+	rg.addAt(10, startTime.Add(5*time.Second))
+	f(5*time.Second, 2)
+	f(5*time.Second+500*time.Millisecond, 10/5.5)
+
+	// The rate is calculated over the window.
+	for i := 0; i < 10; i++ {
+		rg.addAt(1, startTime.Add(time.Duration(10+i)*time.Second))
+	}
+	// Only events in [11s .. 20s] are in the window at 20s.
+	f(20*time.Second, 1)
+	f(20*time.Second+500*time.Millisecond, 9/9.5)
+
+	// Old buckets decay when the gauge is idle.
+	f(25*time.Second, 4.0/9)
+	f(29*time.Second, 0)
+
+	// The gauge is idle for longer than the window.
+	rg.addAt(3, startTime.Add(100*time.Second))
+	f(100*time.Second, 3.0/9)
+	f(200*time.Second, 0)
+}
+
+func TestRateGaugeClockBackwards(t *testing.T) {
+	startTime := time.Unix(1000, 0)
+	rg := newRateGauge(10*time.Second, startTime)
+	rg.addAt(1, startTime.Add(20*time.Second))
+	rg.addAt(1, startTime.Add(15*time.Second))
+	if rate := rg.getAt(startTime.Add(20 * time.Second)); rate != 2.0/9 {
+		t.Fatalf("unexpected rate; got %v; want %v", rate, 2.0/9)
+	}
+}
+
+func TestRateGaugeBuckets(t *testing.T) {
+	f := func(window time.Duration, bucketsExpected int, bucketDurationExpected time.Duration) {
+		t.Helper()
+		rg := newRateGauge(window, time.Now())
+		if n := len(rg.buckets); n != bucketsExpected {
+			t.Fatalf("unexpected number of buckets for window=%s; got %d; want %d", window, n, bucketsExpected)
+		}
+		if rg.bucketDuration != bucketDurationExpected {
+			t.Fatalf("unexpected bucket duration for window=%s; got %s; want %s", window, rg.bucketDuration, bucketDurationExpected)
+		}
+	}
+	f(100*time.Millisecond, 1, 100*time.Millisecond)
+	f(time.Second, 1, time.Second)
+	f(30*time.Second, 30, time.Second)
+	f(time.Minute, 60, time.Second)
+	f(time.Hour, 60, time.Minute)
+
+	expectPanic(t, "zero window", func() {
+		newRateGauge(0, time.Now())
+	})
+}
+
+func TestSetNewRateGauge(t *testing.T) {
+	s := NewSet()
+	rg := s.NewRateGauge("requests_per_second", time.Minute)
+	rg.Inc()
+	rg.Add(2)
+	v, ok := s.GetMetricValue("requests_per_second")
+	if !ok {
+		t.Fatalf("cannot obtain the value for rate gauge")
+	}
+	// The lifetime is shorter than the bucket duration, so the rate is calculated over a single bucket.
+	if v != 3 {
+		t.Fatalf("unexpected rate; got %v; want 3", v)
+	}
+	testMarshalTo(t, rg, "prefix", "prefix 3\n")
+
+	s.ResetAllMetrics()
+	if v := rg.Get(); v != 0 {
+		t.Fatalf("unexpected rate after reset; got %v; want 0", v)
+	}
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// TryRegisterMetric registers m with the given name in the default set.
+//
+// An error is returned instead of panic if name is invalid or if a metric with the given name is already registered.
+// See ValidateMetricName for the rules for name.
+//
+// Zero values of Counter, FloatCounter, Gauge and Histogram may be passed as m, e.g. &Counter{}.
+// Other metric types must be created via New* functions and unregistered before passing them to TryRegisterMetric.
+// Summary must not be registered in multiple sets at the same time.
+//
+// Custom metric types implementing Metric may be passed as m. See RegisterMetric for details.
+func TryRegisterMetric(name string, m Metric) error {
+	return defaultSet.TryRegisterMetric(name, m)
+}
+
+// TryRegisterMetric registers m with the given name in s.
+//
+// See TryRegisterMetric for details.
+func (s *Set) TryRegisterMetric(name string, m Metric) error {
+	if m == nil {
+		return fmt.Errorf("metric %q cannot be nil", name)
+	}
+	_, err := s.tryRegisterMetric(name, newMetric(m), "", false)
+	return err
+}
+
+// NewCounterErr registers and returns new counter with the given name in the default set.
+//
+// It works like NewCounter, but returns an error instead of panic if name is invalid or if it is already registered.
+func NewCounterErr(name string) (*Counter, error) {
+	return defaultSet.NewCounterErr(name)
+}
+
+// NewCounterErr registers and returns new counter with the given name in s.
+//
+// See NewCounterErr for details.
+func (s *Set) NewCounterErr(name string) (*Counter, error) {
+	m, err := s.tryRegisterMetric(name, &Counter{}, "", isDuplicateRegistrationAllowed())
+	if err != nil {
+		return nil, err
+	}
+	return m.(*Counter), nil
+}
+
+// NewGaugeErr registers and returns gauge with the given name in the default set, which calls f to obtain gauge value.
+//
+// It works like NewGauge, but returns an error instead of panic if name is invalid or if it is already registered.
+func NewGaugeErr(name string, f func() float64) (*Gauge, error) {
+	return defaultSet.NewGaugeErr(name, f)
+}
+
+// NewGaugeErr registers and returns gauge with the given name in s, which calls f to obtain gauge value.
+//
+// See NewGaugeErr for details.
+func (s *Set) NewGaugeErr(name string, f func() float64) (*Gauge, error) {
+	g := &Gauge{
+		f: f,
+	}
+	m, err := s.tryRegisterMetric(name, g, "", isDuplicateRegistrationAllowed())
+	if err != nil {
+		return nil, err
+	}
+	return m.(*Gauge), nil
+}
+
+// NewHistogramErr registers and returns new histogram with the given name in the default set.
+//
+// It works like NewHistogram, but returns an error instead of panic if name is invalid or if it is already registered.
+func NewHistogramErr(name string) (*Histogram, error) {
+	return defaultSet.NewHistogramErr(name)
+}
+
+// NewHistogramErr registers and returns new histogram with the given name in s.
+//
+// See NewHistogramErr for details.
+func (s *Set) NewHistogramErr(name string) (*Histogram, error) {
+	m, err := s.tryRegisterMetric(name, &Histogram{}, "", isDuplicateRegistrationAllowed())
+	if err != nil {
+		return nil, err
+	}
+	return m.(*Histogram), nil
+}
+
+// NewSummaryErr registers and returns new summary with the given name in the default set.
+//
+// It works like NewSummary, but returns an error instead of panic if name is invalid or if it is already registered.
+func NewSummaryErr(name string) (*Summary, error) {
+	return defaultSet.NewSummaryErr(name)
+}
+
+// NewSummaryErr registers and returns new summary with the given name in s.
+//
+// See NewSummaryErr for details.
+func (s *Set) NewSummaryErr(name string) (*Summary, error) {
+	sm := newSummary(defaultSummaryWindow, defaultSummaryQuantiles)
+	m, err := s.tryRegisterMetric(name, sm, "", isDuplicateRegistrationAllowed())
+	if err != nil {
+		return nil, err
+	}
+	return m.(*Summary), nil
+}
+
+// SetAllowDuplicateRegistration allows or disallows registering metrics with the already registered names via New* functions.
+//
+// By default New* functions panic if a metric with the given name is already registered.
+// If allow is true, then New* functions return the already registered metric if it has the same type and compatible params
+// such as summary window and quantiles, histogram buckets or gauge callback presence. The returned metric
+// is the same object for all the callers, so their updates are aggregated. The callback and help passed to subsequent calls are ignored.
+// New* functions still panic if the already registered metric has distinct type or params.
+//
+// This is useful when multiple packages register the same metric at init time.
+// It is recommended to call SetAllowDuplicateRegistration before registering metrics.
+func SetAllowDuplicateRegistration(allow bool) {
+	n := uint32(0)
+	if allow {
+		n = 1
+	}
+	atomic.StoreUint32(&allowDuplicateRegistration, n)
+}
+
+var allowDuplicateRegistration uint32
+
+func isDuplicateRegistrationAllowed() bool {
+	return atomic.LoadUint32(&allowDuplicateRegistration) != 0
+}
+
+// checkCompatibleMetric returns an error if m cannot be used instead of the already registered metric mRegistered.
+//
+// mRegistered and m must have the same type.
+func checkCompatibleMetric(mRegistered, m metric) error {
+	switch x := mRegistered.(type) {
+	case *Gauge:
+		y := m.(*Gauge)
+		if (x.f == nil) != (y.f == nil) {
+			return fmt.Errorf("gauge callback presence mismatch; registered callback is set: %v; new callback is set: %v", x.f != nil, y.f != nil)
+		}
+	case *MaxGauge:
+		y := m.(*MaxGauge)
+		if x.eg.resetOnWrite != y.eg.resetOnWrite {
+			return fmt.Errorf("resetOnWrite mismatch; registered resetOnWrite=%v; new resetOnWrite=%v", x.eg.resetOnWrite, y.eg.resetOnWrite)
+		}
+	case *MinGauge:
+		y := m.(*MinGauge)
+		if x.eg.resetOnWrite != y.eg.resetOnWrite {
+			return fmt.Errorf("resetOnWrite mismatch; registered resetOnWrite=%v; new resetOnWrite=%v", x.eg.resetOnWrite, y.eg.resetOnWrite)
+		}
+	case *RateGauge:
+		y := m.(*RateGauge)
+		if x.window != y.window {
+			return fmt.Errorf("window mismatch; registered window=%s; new window=%s", x.window, y.window)
+		}
+	case *Summary:
+		y := m.(*Summary)
+		if x.window != y.window {
+			return fmt.Errorf("window mismatch; registered window=%s; new window=%s", x.window, y.window)
+		}
+		if !isEqualQuantiles(x.quantiles, y.quantiles) {
+			return fmt.Errorf("quantiles mismatch; registered quantiles=%v; new quantiles=%v", x.quantiles, y.quantiles)
+		}
+	case *PrometheusHistogram:
+		y := m.(*PrometheusHistogram)
+		if !x.hasUpperBounds(y.upperBounds) {
+			return fmt.Errorf("buckets mismatch; registered buckets=%v; new buckets=%v", x.upperBounds, y.upperBounds)
+		}
+	case *HDRHistogram:
+		y := m.(*HDRHistogram)
+		if x.minValue != y.minValue || x.maxValue != y.maxValue || x.significantDigits != y.significantDigits {
+			return fmt.Errorf("range mismatch; registered range=[%v..%v] with significantDigits=%d; new range=[%v..%v] with significantDigits=%d",
+				x.minValue, x.maxValue, x.significantDigits, y.minValue, y.maxValue, y.significantDigits)
+		}
+	case *NativeHistogram:
+		y := m.(*NativeHistogram)
+		if x.schema != y.schema || x.zeroThreshold != y.zeroThreshold {
+			return fmt.Errorf("schema mismatch; registered schema=%d; new schema=%d", x.schema, y.schema)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestValidateMetricName(t *testing.T) {
+	f := func(name string, isValid bool) {
+		t.Helper()
+		err := ValidateMetricName(name)
+		if isValid && err != nil {
+			t.Fatalf("unexpected error for %q: %s", name, err)
+		}
+		if !isValid && err == nil {
+			t.Fatalf("expecting non-nil error for %q", name)
+		}
+	}
+	f("foo", true)
+	f(`foo{bar="baz"}`, true)
+	f(`foo{bar="baz",aaa="b"}`, true)
+	f("", false)
+	f("1foo", false)
+	f("foo{", false)
+	f(`foo{bar}`, false)
+	f(`foo{bar="baz}`, false)
+}
+
+func TestSetNewErr(t *testing.T) {
+	s := NewSet()
+	f := func(err error, errExpected string) {
+		t.Helper()
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want it containing %q", err, errExpected)
+		}
+	}
+
+	c, err := s.NewCounterErr(`counter{a="b"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Inc()
+	if _, err := s.NewGaugeErr("gauge", func() float64 { return 2 }); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := s.NewHistogramErr("histogram"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sm, err := s.NewSummaryErr("summary")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sm.Update(1)
+
+	// Invalid names
+	_, err = s.NewCounterErr("1foo")
+	f(err, `invalid metric name "1foo"`)
+	_, err = s.NewGaugeErr(`foo{bar}`, nil)
+	f(err, `invalid metric name "foo{bar}"`)
+	_, err = s.NewHistogramErr("")
+	f(err, `invalid metric name ""`)
+	_, err = s.NewSummaryErr(`foo{`)
+	f(err, `invalid metric name "foo{"`)
+
+	// Duplicate names
+	_, err = s.NewCounterErr(`counter{a="b"}`)
+	f(err, `metric "counter{a=\"b\"}" is already registered`)
+	_, err = s.NewSummaryErr("summary")
+	f(err, `metric "summary" is already registered`)
+
+	// Type mismatch
+	_, err = s.NewHistogramErr("gauge")
+	f(err, `metric "gauge" is already registered with type *metrics.Gauge; cannot register it with type *metrics.Histogram`)
+	_, err = s.NewCounterErr(`summary{quantile="0.5"}`)
+	f(err, `with type *metrics.quantileValue`)
+
+	// Summary quantiles conflict with the registered metric
+	s.NewCounter(`conflict{quantile="0.5"}`)
+	_, err = s.NewSummaryErr("conflict")
+	f(err, `metric "conflict{quantile=\"0.5\"}" for summary "conflict" is already registered`)
+	if _, ok := s.GetMetric("conflict"); ok {
+		t.Fatalf("the summary with conflicting quantiles mustn't be registered")
+	}
+
+	// TryRegisterMetric
+	if err := s.TryRegisterMetric("float_counter", &FloatCounter{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(s.TryRegisterMetric("float_counter", &FloatCounter{}), `metric "float_counter" is already registered`)
+	f(s.TryRegisterMetric("nil_metric", nil), `metric "nil_metric" cannot be nil`)
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `conflict{quantile="0.5"} 0
+counter{a="b"} 1
+float_counter 0
+gauge 2
+summary_sum 1
+summary_count 1
+summary{quantile="0.5"} 1
+summary{quantile="0.9"} 1
+summary{quantile="0.97"} 1
+summary{quantile="0.99"} 1
+summary{quantile="1"} 1
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestSetAllowDuplicateRegistration(t *testing.T) {
+	SetAllowDuplicateRegistration(true)
+	defer SetAllowDuplicateRegistration(false)
+
+	s := NewSet()
+	c1 := s.NewCounter("checks_total")
+	c2 := s.NewCounter("checks_total")
+	if c1 != c2 {
+		t.Fatalf("NewCounter must return the registered counter")
+	}
+	c1.Inc()
+	c2.Inc()
+	if n := c1.Get(); n != 2 {
+		t.Fatalf("unexpected counter value; got %d; want %d", n, 2)
+	}
+	if c, err := s.NewCounterErr("checks_total"); err != nil || c != c1 {
+		t.Fatalf("NewCounterErr must return the registered counter; got %p, %v", c, err)
+	}
+
+	f := func(create func() interface{}) {
+		t.Helper()
+		m1 := create()
+		m2 := create()
+		if m1 != m2 {
+			t.Fatalf("unexpected distinct metrics for duplicate registration: %p and %p", m1, m2)
+		}
+	}
+	f(func() interface{} { return s.NewFloatCounter("float_counter") })
+	f(func() interface{} { return s.NewShardedCounter("sharded_counter") })
+	f(func() interface{} { return s.NewGauge("gauge", nil) })
+	f(func() interface{} { return s.NewGauge("gauge_callback", func() float64 { return 1 }) })
+	f(func() interface{} { return s.NewHistogram("histogram") })
+	f(func() interface{} { return s.NewHistogramWithBuckets("prometheus_histogram", []float64{1, 2}) })
+	f(func() interface{} { return s.NewNativeHistogram("native_histogram", 1.1) })
+	f(func() interface{} { return s.NewSummary("summary") })
+
+	// Type and params mismatch still panics.
+	expectPanic(t, "type mismatch", func() { s.NewHistogram("checks_total") })
+	expectPanic(t, "gauge callback mismatch", func() { s.NewGauge("gauge", func() float64 { return 1 }) })
+	expectPanic(t, "buckets mismatch", func() { s.NewHistogramWithBuckets("prometheus_histogram", []float64{1, 3}) })
+	expectPanic(t, "schema mismatch", func() { s.NewNativeHistogram("native_histogram", 2) })
+	expectPanic(t, "quantiles mismatch", func() { s.NewSummaryExt("summary", defaultSummaryWindow, []float64{0.5}) })
+	expectPanic(t, "summary quantile", func() { s.NewCounter(`summary{quantile="0.5"}`) })
+
+	// TryRegisterMetric doesn't allow duplicates.
+	if err := s.TryRegisterMetric("checks_total", &Counter{}); err == nil {
+		t.Fatalf("expecting non-nil error for duplicate TryRegisterMetric")
+	}
+}
+
+func TestSetDuplicateRegistrationDisallowed(t *testing.T) {
+	s := NewSet()
+	s.NewCounter("checks_total")
+	expectPanic(t, "duplicate NewCounter", func() { s.NewCounter("checks_total") })
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+// ExposeRegistryMetrics enables or disables exposing metrics about the metrics registry itself.
+//
+// The following metrics are written after the registered metrics by WritePrometheus, WriteSets and other functions,
+// which write metrics from multiple sets, if v is true:
+//
+//   - metrics_getorcreate_slowpath_total - the number of GetOrCreate* calls, which had to create and register a new metric.
+//   - metrics_registered_total{type="..."} - the number of metrics registered in the written sets per metric type.
+//   - metrics_write_bytes - the size of the previous exposition in bytes.
+//   - metrics_write_duration_seconds - histogram for the duration of writing the metrics.
+//
+// These metrics aren't registered in any set - they are calculated at write time. They are written by filtered writes
+// such as WritePrometheusMatching only if they match the filter.
+//
+// The registry metrics aren't exposed by default.
+func ExposeRegistryMetrics(v bool) {
+	n := uint32(0)
+	if v {
+		n = 1
+	}
+	atomic.StoreUint32(&exposeRegistryMetrics, n)
+}
+
+var exposeRegistryMetrics uint32
+
+func isRegistryMetricsExposed() bool {
+	return atomic.LoadUint32(&exposeRegistryMetrics) != 0
+}
+
+var (
+	// getOrCreateSlowPathTotal is the number of GetOrCreate* calls, which took the slow path.
+	getOrCreateSlowPathTotal uint64
+
+	// registryWriteBytes is the size of the previous exposition with registry metrics enabled.
+	registryWriteBytes uint64
+
+	// registryWriteDuration isn't registered in any set in order to avoid registering metrics while writing them.
+	registryWriteDuration Histogram
+)
+
+// registryMetricTypes contains metric types, which are always written in metrics_registered_total.
+var registryMetricTypes = []string{"counter", "gauge", "histogram", "summary"}
+
+// writeRegistryMetrics writes registry metrics for the given sets matching mf to w.
+func writeRegistryMetrics(w io.Writer, sets []*Set, mf *metricNameFilter) {
+	if mf.match("metrics_getorcreate_slowpath_total") {
+		WriteCounterUint64(w, "metrics_getorcreate_slowpath_total", atomic.LoadUint64(&getOrCreateSlowPathTotal))
+	}
+	if mf.match("metrics_registered_total") {
+		writeRegisteredMetrics(w, sets)
+	}
+	if mf.match("metrics_write_bytes") {
+		WriteGaugeUint64(w, "metrics_write_bytes", atomic.LoadUint64(&registryWriteBytes))
+	}
+	if mf.match("metrics_write_duration_seconds") {
+		WriteMetadataIfNeeded(w, "metrics_write_duration_seconds", "histogram")
+		registryWriteDuration.marshalTo("metrics_write_duration_seconds", w)
+	}
+}
+
+// writeRegisteredMetrics writes metrics_registered_total per metric type for metrics registered in sets to w.
+func writeRegisteredMetrics(w io.Writer, sets []*Set) {
+	counts := make(map[string]uint64, len(registryMetricTypes))
+	for _, metricType := range registryMetricTypes {
+		counts[metricType] = 0
+	}
+	for _, s := range sets {
+		s.mu.RLock()
+		for _, nm := range s.a {
+			if !nm.isAux {
+				counts[nm.metric.metricType()]++
+			}
+		}
+		s.mu.RUnlock()
+	}
+	metricTypes := make([]string, 0, len(counts))
+	for metricType := range counts {
+		metricTypes = append(metricTypes, metricType)
+	}
+	sort.Strings(metricTypes)
+
+	WriteMetadataIfNeeded(w, "metrics_registered_total", "gauge")
+	for _, metricType := range metricTypes {
+		name := `metrics_registered_total{type="` + metricType + `"}`
+		writeSampleUint64(w, name, counts[metricType], nil)
+	}
+}
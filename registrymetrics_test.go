@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExposeRegistryMetrics(t *testing.T) {
+	s := NewSet()
+	s.GetOrCreateCounter("foo_total").Inc()
+	s.NewCounter("bar_total")
+	s.NewGauge("baz", func() float64 { return 1 })
+	s.NewFloatCounter("qux_total")
+	s.NewSummary("summary")
+	s.RegisterMetric("custom", &testCustomMetric{})
+
+	// Registry metrics aren't exposed by default.
+	var bb bytes.Buffer
+	WriteSets(&bb, s)
+	if result := bb.String(); strings.Contains(result, "metrics_registered_total") {
+		t.Fatalf("unexpected registry metrics in the output:\n%s", result)
+	}
+
+	ExposeRegistryMetrics(true)
+	defer ExposeRegistryMetrics(false)
+	registryWriteDuration.Reset()
+	atomic.StoreUint64(&registryWriteBytes, 0)
+
+	bb.Reset()
+	WriteSets(&bb, s)
+	firstSize := bb.Len()
+	result := bb.String()
+	for _, line := range []string{
+		`metrics_registered_total{type="counter"} 3` + "\n",
+		`metrics_registered_total{type="untyped"} 1` + "\n",
+		`metrics_registered_total{type="gauge"} 1` + "\n",
+		`metrics_registered_total{type="histogram"} 0` + "\n",
+		`metrics_registered_total{type="summary"} 1` + "\n",
+		"metrics_write_bytes 0\n",
+		"metrics_getorcreate_slowpath_total ",
+	} {
+		if !strings.Contains(result, line) {
+			t.Fatalf("missing %q in the output:\n%s", line, result)
+		}
+	}
+	if strings.Contains(result, "metrics_write_duration_seconds") {
+		t.Fatalf("unexpected metrics_write_duration_seconds before the first finished write:\n%s", result)
+	}
+
+	// The second write must contain the size and the duration of the first write.
+	bb.Reset()
+	WriteSets(&bb, s)
+	result = bb.String()
+	for _, line := range []string{
+		"metrics_write_bytes " + strconv.Itoa(firstSize) + "\n",
+		"metrics_write_duration_seconds_count ",
+	} {
+		if !strings.Contains(result, line) {
+			t.Fatalf("missing %q in the output:\n%s", line, result)
+		}
+	}
+
+	// Registry metrics must be excluded from filtered writes, which don't ask for them.
+	bb.Reset()
+	WritePrometheusFiltered(&bb, NameFilterPrefixes("metrics_write_bytes"))
+	result = bb.String()
+	if !strings.HasPrefix(result, "metrics_write_bytes ") || strings.Count(result, "\n") != 1 {
+		t.Fatalf("unexpected output for filtered write:\n%s", result)
+	}
+
+	bb.Reset()
+	WritePrometheusMatching(&bb, []string{"foo_total"})
+	if result := bb.String(); strings.Contains(result, "metrics_registered_total") {
+		t.Fatalf("unexpected registry metrics in the output:\n%s", result)
+	}
+}
@@ -0,0 +1,445 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// RelabelAction is the action for RelabelRule.
+type RelabelAction int
+
+const (
+	// RelabelDrop drops all the series for the matching metrics.
+	RelabelDrop RelabelAction = iota + 1
+
+	// RelabelRename renames the matching metrics to RelabelRule.NewName.
+	RelabelRename
+
+	// RelabelDropLabel drops RelabelRule.Label from the series for the matching metrics.
+	RelabelDropLabel
+
+	// RelabelReplaceLabelValue replaces the value of RelabelRule.Label with RelabelRule.Value in the series for the matching metrics.
+	RelabelReplaceLabelValue
+)
+
+// String returns human-readable name for a.
+func (a RelabelAction) String() string {
+	switch a {
+	case RelabelDrop:
+		return "drop"
+	case RelabelRename:
+		return "rename"
+	case RelabelDropLabel:
+		return "drop_label"
+	case RelabelReplaceLabelValue:
+		return "replace_label_value"
+	default:
+		return fmt.Sprintf("RelabelAction(%d)", int(a))
+	}
+}
+
+// RelabelRule is a rule for SetWriteRelabelConfig.
+type RelabelRule struct {
+	// Match is an optional regular expression for metric base names. The rule is applied to all the metrics if Match is empty.
+	//
+	// The base name is the part of the metric name before `{`. Match must match the whole base name,
+	// so `http_.+` matches `http_requests_total`, but doesn't match `app_http_requests_total`.
+	// Histograms and summaries are matched by the base name without `_bucket`, `_sum` and `_count` suffixes.
+	Match string
+
+	// Action is the action to apply to the matching metrics.
+	Action RelabelAction
+
+	// NewName is the new base name for RelabelRename action.
+	//
+	// `_bucket`, `_sum` and `_count` suffixes are preserved for histograms and summaries.
+	NewName string
+
+	// Label is the label name for RelabelDropLabel and RelabelReplaceLabelValue actions.
+	Label string
+
+	// Value is the new label value for RelabelReplaceLabelValue action.
+	Value string
+
+	// ValueMatch is an optional regular expression for the current label value for RelabelReplaceLabelValue action.
+	//
+	// The label value is replaced only if ValueMatch matches the whole value. Any value is replaced if ValueMatch is empty.
+	// Series without Label aren't changed.
+	ValueMatch string
+}
+
+// SetWriteRelabelConfig sets rules for relabeling the metrics at exposition time.
+//
+// The rules are applied to the metrics from all the sets and metrics writers in the given order, so every rule sees
+// the changes made by the previous rules. For example, a rule after RelabelRename must match the new name.
+// The rules are applied by WritePrometheus, WriteSets, Set.WritePrometheus and all the other functions, which write metrics
+// in Prometheus text exposition format, including Handler and all the InitPush* functions. Process metrics such as `go_*`
+// and `process_*` aren't relabeled.
+//
+// Series, which become identical after the relabeling (for example, after dropping the label, which distinguished them),
+// are written only once. The remaining series are dropped and are counted by metrics_relabel_duplicate_series_total counter,
+// which is written after the metrics if it has non-zero value.
+//
+// The regular expressions are compiled once by SetWriteRelabelConfig. An error is returned if rules contain invalid
+// regular expressions, unknown actions or invalid names. The previously set rules remain active in this case.
+//
+// SetWriteRelabelConfig(nil) disables the relabeling. This is the default.
+func SetWriteRelabelConfig(rules []RelabelRule) error {
+	if len(rules) == 0 {
+		writeRelabelConfig.Store((*relabelConfig)(nil))
+		return nil
+	}
+	rc := &relabelConfig{
+		rules: make([]relabelRule, 0, len(rules)),
+	}
+	for i := range rules {
+		r, err := compileRelabelRule(&rules[i])
+		if err != nil {
+			return fmt.Errorf("invalid rule #%d: %w", i, err)
+		}
+		rc.rules = append(rc.rules, r)
+	}
+	writeRelabelConfig.Store(rc)
+	return nil
+}
+
+var writeRelabelConfig atomic.Value
+
+// relabelDuplicateSeriesTotal is the number of series dropped because they became identical after the relabeling.
+var relabelDuplicateSeriesTotal uint64
+
+const relabelDuplicateSeriesTotalName = "metrics_relabel_duplicate_series_total"
+
+// relabelConfig contains compiled rules set via SetWriteRelabelConfig.
+type relabelConfig struct {
+	rules []relabelRule
+}
+
+type relabelRule struct {
+	// re matches metric base names. nil re matches all the names.
+	re *regexp.Regexp
+
+	action  RelabelAction
+	newName string
+	label   string
+	value   string
+
+	// valueRe matches label values for RelabelReplaceLabelValue. nil valueRe matches all the values.
+	valueRe *regexp.Regexp
+}
+
+func compileRelabelRule(rule *RelabelRule) (relabelRule, error) {
+	r := relabelRule{
+		action:  rule.Action,
+		newName: rule.NewName,
+		label:   rule.Label,
+		value:   rule.Value,
+	}
+	if rule.Match != "" {
+		re, err := compileAnchoredRegexp(rule.Match)
+		if err != nil {
+			return r, fmt.Errorf("cannot compile Match=%q: %w", rule.Match, err)
+		}
+		r.re = re
+	}
+	switch rule.Action {
+	case RelabelDrop:
+	case RelabelRename:
+		if err := validateIdent(rule.NewName); err != nil {
+			return r, fmt.Errorf("invalid NewName for %s action: %w", rule.Action, err)
+		}
+	case RelabelDropLabel, RelabelReplaceLabelValue:
+		if err := validateIdent(rule.Label); err != nil {
+			return r, fmt.Errorf("invalid Label for %s action: %w", rule.Action, err)
+		}
+		if rule.ValueMatch != "" {
+			if rule.Action != RelabelReplaceLabelValue {
+				return r, fmt.Errorf("ValueMatch cannot be used with %s action", rule.Action)
+			}
+			re, err := compileAnchoredRegexp(rule.ValueMatch)
+			if err != nil {
+				return r, fmt.Errorf("cannot compile ValueMatch=%q: %w", rule.ValueMatch, err)
+			}
+			r.valueRe = re
+		}
+	default:
+		return r, fmt.Errorf("unknown action: %s", rule.Action)
+	}
+	return r, nil
+}
+
+func compileAnchoredRegexp(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// familyRelabeling is the result of applying relabel rules to a metric base name.
+type familyRelabeling struct {
+	// name is the base name after the relabeling.
+	name string
+
+	// drop is set if the metric must be dropped.
+	drop bool
+
+	// labelRules contains rules for labels, which must be applied to every series of the metric.
+	labelRules []*relabelRule
+}
+
+func (fr *familyRelabeling) isChanged(name string) bool {
+	return fr.drop || fr.name != name || len(fr.labelRules) > 0
+}
+
+// writeRelabeler applies relabel rules to the metrics during a single write.
+type writeRelabeler struct {
+	rc *relabelConfig
+
+	// families caches familyRelabeling per metric base name.
+	families map[string]*familyRelabeling
+
+	// seen contains the written series. It is used for detecting series, which became identical after the relabeling.
+	seen map[string]struct{}
+
+	// seenMetadata contains already written `# HELP` and `# TYPE` lines in the form `# TYPE <metricFamily>`.
+	seenMetadata map[string]struct{}
+
+	labels []label
+}
+
+// getWriteRelabeler returns relabeler for the rules set via SetWriteRelabelConfig.
+//
+// nil is returned if the relabeling is disabled.
+func getWriteRelabeler() *writeRelabeler {
+	rc, _ := writeRelabelConfig.Load().(*relabelConfig)
+	if rc == nil {
+		return nil
+	}
+	return &writeRelabeler{
+		rc:           rc,
+		families:     make(map[string]*familyRelabeling),
+		seen:         make(map[string]struct{}),
+		seenMetadata: make(map[string]struct{}),
+	}
+}
+
+func (wr *writeRelabeler) getFamily(name string) *familyRelabeling {
+	if fr, ok := wr.families[name]; ok {
+		return fr
+	}
+	fr := &familyRelabeling{
+		name: name,
+	}
+	rules := wr.rc.rules
+	for i := range rules {
+		r := &rules[i]
+		if r.re != nil && !r.re.MatchString(fr.name) {
+			continue
+		}
+		if r.action == RelabelDrop {
+			fr.drop = true
+			fr.labelRules = nil
+			break
+		}
+		if r.action == RelabelRename {
+			fr.name = r.newName
+			continue
+		}
+		fr.labelRules = append(fr.labelRules, r)
+	}
+	wr.families[name] = fr
+	return fr
+}
+
+// getSampleFamily returns relabeling for the sample with the given name and the suffix of the name after the base name.
+func (wr *writeRelabeler) getSampleFamily(name string) (*familyRelabeling, string) {
+	fr := wr.getFamily(name)
+	if fr.isChanged(name) {
+		return fr, ""
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		baseName := name[:len(name)-len(suffix)]
+		if frBase := wr.getFamily(baseName); frBase.isChanged(baseName) {
+			return frBase, suffix
+		}
+	}
+	return fr, ""
+}
+
+// writeMetadata writes metadata for the given metric family after the relabeling to w.
+//
+// The metadata is written only once per metric family.
+func (wr *writeRelabeler) writeMetadata(w io.Writer, metricFamily, metricType, help string) {
+	fr := wr.getFamily(metricFamily)
+	if fr.drop {
+		return
+	}
+	if _, ok := wr.seenMetadata["# TYPE "+fr.name]; ok {
+		return
+	}
+	wr.seenMetadata["# HELP "+fr.name] = struct{}{}
+	wr.seenMetadata["# TYPE "+fr.name] = struct{}{}
+	writeMetadataIfNeeded(w, fr.name, metricType, help)
+}
+
+// relabelMetric appends samples of the registered metric with the given metricFamily from src to dst after the relabeling.
+//
+// src must contain the output of marshalTo for the metric.
+func (wr *writeRelabeler) relabelMetric(dst, src []byte, metricFamily string) []byte {
+	fr := wr.getFamily(metricFamily)
+	if fr.drop {
+		return dst
+	}
+	if !needsQuoting(fr.name) {
+		return wr.relabelText(dst, src, fr, metricFamily)
+	}
+	bb := getBytesBuffer()
+	bb.B = wr.relabelText(bb.B[:0], src, fr, metricFamily)
+	dst = quoteMetricNames(dst, bb.B, fr.name)
+	putBytesBuffer(bb)
+	return dst
+}
+
+// relabelText appends lines from src in Prometheus text exposition format to dst after the relabeling.
+//
+// fr is the relabeling for metricFamily, which contains all the samples in src. If fr is nil, then the relabeling is detected
+// for every sample name and for the name without `_bucket`, `_sum` and `_count` suffixes.
+func (wr *writeRelabeler) relabelText(dst, src []byte, fr *familyRelabeling, metricFamily string) []byte {
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		dst = wr.relabelLine(dst, string(line), fr, metricFamily)
+	}
+	return dst
+}
+
+// relabelLine appends the line s in Prometheus text exposition format to dst after the relabeling.
+//
+// See relabelText for details on fr and metricFamily.
+func (wr *writeRelabeler) relabelLine(dst []byte, s string, fr *familyRelabeling, metricFamily string) []byte {
+	if len(s) == 0 {
+		return dst
+	}
+	if s[0] == '#' {
+		return wr.relabelComment(dst, s)
+	}
+	n := strings.IndexAny(s, "{ \t")
+	if n <= 0 {
+		return appendLine(dst, s)
+	}
+	name := s[:n]
+	tail := s[n:]
+	suffix := ""
+	if fr == nil {
+		fr, suffix = wr.getSampleFamily(name)
+	} else if strings.HasPrefix(name, metricFamily) {
+		suffix = name[len(metricFamily):]
+	}
+	if fr.drop {
+		return dst
+	}
+	newName := fr.name
+	if metricFamily != "" && !strings.HasPrefix(name, metricFamily) {
+		// `_created` series for counters don't contain `_total` suffix.
+		if base := strings.TrimSuffix(metricFamily, "_total"); strings.HasPrefix(name, base) {
+			newName = strings.TrimSuffix(fr.name, "_total")
+			suffix = name[len(base):]
+		}
+	}
+	wr.labels = wr.labels[:0]
+	if tail[0] == '{' {
+		var err error
+		wr.labels, tail, err = parseLabels(wr.labels, tail[1:])
+		if err != nil {
+			// Copy the line, which cannot be parsed, as is.
+			return appendLine(dst, s)
+		}
+	}
+	labels := wr.labels
+	for _, r := range fr.labelRules {
+		labels = r.applyToLabels(labels)
+	}
+
+	dstLen := len(dst)
+	dst = append(dst, newName...)
+	dst = append(dst, suffix...)
+	if len(labels) > 0 {
+		dst = append(dst, '{')
+		for i, l := range labels {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, l.name...)
+			dst = append(dst, `="`...)
+			dst = appendEscapedLabelValue(dst, l.value)
+			dst = append(dst, '"')
+		}
+		dst = append(dst, '}')
+	}
+	key := string(dst[dstLen:])
+	if _, ok := wr.seen[key]; ok {
+		atomic.AddUint64(&relabelDuplicateSeriesTotal, 1)
+		return dst[:dstLen]
+	}
+	wr.seen[key] = struct{}{}
+	return appendLine(dst, tail)
+}
+
+// relabelComment appends the comment line s to dst after the relabeling of the metric family in `# HELP` and `# TYPE` lines.
+func (wr *writeRelabeler) relabelComment(dst []byte, s string) []byte {
+	prefix := ""
+	if strings.HasPrefix(s, "# HELP ") {
+		prefix = "# HELP "
+	} else if strings.HasPrefix(s, "# TYPE ") {
+		prefix = "# TYPE "
+	} else {
+		return appendLine(dst, s)
+	}
+	name := s[len(prefix):]
+	tail := ""
+	if n := strings.IndexAny(name, " \t"); n >= 0 {
+		tail = name[n:]
+		name = name[:n]
+	}
+	fr := wr.getFamily(name)
+	if fr.drop {
+		return dst
+	}
+	key := prefix + fr.name
+	if _, ok := wr.seenMetadata[key]; ok {
+		return dst
+	}
+	wr.seenMetadata[key] = struct{}{}
+	dst = append(dst, prefix...)
+	dst = append(dst, fr.name...)
+	return appendLine(dst, tail)
+}
+
+// applyToLabels applies r to labels and returns the result. labels may be modified in place.
+func (r *relabelRule) applyToLabels(labels []label) []label {
+	for i := range labels {
+		l := &labels[i]
+		if l.name != r.label {
+			continue
+		}
+		if r.action == RelabelDropLabel {
+			return append(labels[:i], labels[i+1:]...)
+		}
+		if r.valueRe == nil || r.valueRe.MatchString(l.value) {
+			l.value = r.value
+		}
+		return labels
+	}
+	return labels
+}
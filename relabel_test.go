@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetWriteRelabelConfig(t *testing.T) {
+	duplicatesPrev := atomic.LoadUint64(&relabelDuplicateSeriesTotal)
+	defer atomic.StoreUint64(&relabelDuplicateSeriesTotal, duplicatesPrev)
+	defer func() {
+		if err := SetWriteRelabelConfig(nil); err != nil {
+			t.Fatalf("cannot reset relabel config: %s", err)
+		}
+	}()
+
+	s := NewSet()
+	s.NewCounter(`requests_total{path="/a",user="1"}`).Add(1)
+	s.NewCounter(`requests_total{path="/a",user="2"}`).Add(2)
+	s.NewCounter(`requests_total{path="/b",user="1"}`).Add(3)
+	s.NewGauge(`secret_gauge{a="b"}`, func() float64 { return 42 })
+	s.NewHistogramWithBuckets("legacy_latency", []float64{1}).Update(0.5)
+	s.NewCounter("other_total").Inc()
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "legacy_writer_total{user=\"x\"} 5\n")
+		fmt.Fprintf(w, "secret_writer 6\n")
+	})
+
+	err := SetWriteRelabelConfig([]RelabelRule{
+		{
+			Match:  "secret_.+",
+			Action: RelabelDrop,
+		},
+		{
+			Match:   "legacy_(latency|writer_total)",
+			Action:  RelabelRename,
+			NewName: "renamed",
+		},
+		{
+			// This rule must see the new name after the rename
+			Match:  "renamed",
+			Action: RelabelDropLabel,
+			Label:  "user",
+		},
+		{
+			Match:  "requests_total",
+			Action: RelabelDropLabel,
+			Label:  "user",
+		},
+		{
+			Action:     RelabelReplaceLabelValue,
+			Label:      "path",
+			ValueMatch: "/b.*",
+			Value:      "/other",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `renamed_bucket{le="1"} 1
+renamed_bucket{le="+Inf"} 1
+renamed_sum 0.5
+renamed_count 1
+other_total 1
+requests_total{path="/a"} 1
+requests_total{path="/other"} 3
+renamed 5
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+	if n := atomic.LoadUint64(&relabelDuplicateSeriesTotal) - duplicatesPrev; n != 1 {
+		t.Fatalf("unexpected number of duplicate series; got %d; want 1", n)
+	}
+
+	// The duplicate series are counted in the output of WriteSets.
+	bb.Reset()
+	WriteSets(&bb, s)
+	if result := bb.String(); !strings.Contains(result, "\n"+relabelDuplicateSeriesTotalName+" ") {
+		t.Fatalf("missing %s in the output:\n%s", relabelDuplicateSeriesTotalName, result)
+	}
+
+	// Invalid config doesn't change the current rules.
+	if err := SetWriteRelabelConfig([]RelabelRule{{Match: "(", Action: RelabelDrop}}); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if result := bb.String(); !strings.HasPrefix(result, "renamed_bucket") {
+		t.Fatalf("unexpected result after the invalid config:\n%s", result)
+	}
+
+	// Disable the relabeling.
+	if err := SetWriteRelabelConfig(nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if result := bb.String(); !strings.Contains(result, "secret_gauge") {
+		t.Fatalf("missing secret_gauge in the output after disabling the relabeling:\n%s", result)
+	}
+}
+
+func TestSetWriteRelabelConfigMetadata(t *testing.T) {
+	defer func() {
+		_ = SetWriteRelabelConfig(nil)
+	}()
+	s := NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: "old_total",
+		Help: "Old counter",
+	}).Inc()
+	s.NewCounterOpt(CounterOpts{
+		Name: "dropped_total",
+		Help: "Dropped counter",
+	}).Inc()
+	err := SetWriteRelabelConfig([]RelabelRule{
+		{
+			Match:   "old_total",
+			Action:  RelabelRename,
+			NewName: "new_total",
+		},
+		{
+			Match:  "dropped_total",
+			Action: RelabelDrop,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `# HELP new_total Old counter
+# TYPE new_total counter
+new_total 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestSetWriteRelabelConfigInvalid(t *testing.T) {
+	defer func() {
+		_ = SetWriteRelabelConfig(nil)
+	}()
+	f := func(rule RelabelRule) {
+		t.Helper()
+		if err := SetWriteRelabelConfig([]RelabelRule{rule}); err == nil {
+			t.Fatalf("expecting non-nil error for %#v", rule)
+		}
+	}
+	f(RelabelRule{})
+	f(RelabelRule{Action: RelabelAction(100)})
+	f(RelabelRule{Match: "(", Action: RelabelDrop})
+	f(RelabelRule{Action: RelabelRename})
+	f(RelabelRule{Action: RelabelRename, NewName: "foo bar"})
+	f(RelabelRule{Action: RelabelDropLabel})
+	f(RelabelRule{Action: RelabelDropLabel, Label: "foo", ValueMatch: "bar"})
+	f(RelabelRule{Action: RelabelReplaceLabelValue, Label: "foo", ValueMatch: "("})
+}
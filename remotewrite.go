@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// InitPushRemoteWrite sets up periodic push for globally registered metrics to the given remoteWriteURL with the given interval
+// via Prometheus remote_write protocol.
+//
+// extraLabels may contain comma-separated list of `label="value"` labels, which will be added
+// to all the metrics before pushing them to remoteWriteURL.
+//
+// See https://prometheus.io/docs/concepts/remote_write_spec/
+//
+// Failed pushes are retried with exponential backoff on network errors and on 5xx responses until the next push interval.
+// Pushes rejected with 4xx responses are dropped, since they cannot be fixed by retrying.
+//
+// It is OK calling InitPushRemoteWrite multiple times with different remoteWriteURL -
+// in this case metrics are pushed to all the provided urls.
+//
+// See also InitPushRemoteWriteWithOptions.
+func InitPushRemoteWrite(remoteWriteURL string, interval time.Duration, extraLabels string) error {
+	opts := &PushOptions{
+		ExtraLabels: extraLabels,
+	}
+	return InitPushRemoteWriteWithOptions(context.Background(), remoteWriteURL, interval, false, opts)
+}
+
+// InitPushRemoteWriteWithOptions sets up periodic push for globally registered metrics to the given remoteWriteURL with the given interval
+// via Prometheus remote_write protocol.
+//
+// The periodic push is stopped when ctx is canceled.
+// It is possible to wait until the background metrics push worker is stopped on a WaitGroup passed via opts.WaitGroup.
+//
+// If pushProcessMetrics is set to true, then 'process_*' and `go_*` metrics are also pushed to remoteWriteURL.
+//
+// opts may contain additional configuration options if non-nil. opts.DisableCompression and opts.Compression are ignored,
+// since remote_write protocol requires snappy compression.
+func InitPushRemoteWriteWithOptions(ctx context.Context, remoteWriteURL string, interval time.Duration, pushProcessMetrics bool, opts *PushOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheus(w, pushProcessMetrics)
+	}
+	return initPushRemoteWrite(ctx, remoteWriteURL, interval, writeMetrics, opts)
+}
+
+// InitPushRemoteWrite sets up periodic push for metrics from s to the given remoteWriteURL with the given interval
+// via Prometheus remote_write protocol.
+//
+// The periodic push is stopped when ctx is canceled.
+//
+// opts may contain additional configuration options if non-nil.
+func (s *Set) InitPushRemoteWrite(ctx context.Context, remoteWriteURL string, interval time.Duration, opts *PushOptions) error {
+	return initPushRemoteWrite(ctx, remoteWriteURL, interval, s.WritePrometheus, opts)
+}
+
+func initPushRemoteWrite(ctx context.Context, remoteWriteURL string, interval time.Duration, writeMetrics func(w io.Writer), opts *PushOptions) error {
+	pc, err := newRemoteWritePushContext(remoteWriteURL, interval, opts)
+	if err != nil {
+		return err
+	}
+	return initPush(ctx, pc, interval, writeMetrics, opts)
+}
+
+func newRemoteWritePushContext(remoteWriteURL string, interval time.Duration, opts *PushOptions) (*pushContext, error) {
+	var optsCopy PushOptions
+	if opts != nil {
+		optsCopy = *opts
+	}
+	if optsCopy.Method == "" {
+		optsCopy.Method = http.MethodPost
+	}
+	// remote_write protocol requires snappy compression, so other compression options are ignored.
+	optsCopy.Compression = ""
+	optsCopy.DisableCompression = true
+	pc, err := newPushContext(remoteWriteURL, &optsCopy)
+	if err != nil {
+		return nil, err
+	}
+	pc.isRemoteWrite = true
+	if optsCopy.MaxRetries == 0 && !optsCopy.DisableRetries {
+		pc.maxRetries = remoteWriteMaxRetries
+	}
+	pc.initMaxBackoff(interval)
+	return pc, nil
+}
+
+const remoteWriteMaxRetries = 5
+
+// marshalRemoteWriteRequest converts metrics in Prometheus text exposition format from src
+// to protobuf-encoded remote_write WriteRequest with the given timestamp in milliseconds and appends it to dst.
+//
+// See https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+func marshalRemoteWriteRequest(dst, src []byte, timestamp int64) ([]byte, error) {
+	var labels []label
+	err := forEachSample(src, func(ps *parsedSample) {
+		// Labels in the time series must be sorted by name according to remote_write spec.
+		labels = append(labels[:0], label{
+			name:  "__name__",
+			value: ps.metricName,
+		})
+		for _, l := range ps.labels {
+			if l.value == "" {
+				// Empty label value is equivalent to missing label.
+				continue
+			}
+			labels = append(labels, l)
+		}
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].name < labels[j].name
+		})
+		ts := timestamp
+		if ps.timestamp != 0 {
+			ts = ps.timestamp
+		}
+		dst = marshalTimeSeries(dst, labels, ps.value, ts)
+	})
+	return dst, err
+}
+
+// marshalTimeSeries appends the `repeated TimeSeries timeseries = 1` item of WriteRequest to dst.
+func marshalTimeSeries(dst []byte, labels []label, value float64, timestamp int64) []byte {
+	labelsSize := 0
+	for _, l := range labels {
+		labelSize := protoStringFieldSize(l.name) + protoStringFieldSize(l.value)
+		labelsSize += 1 + protoVarintSize(uint64(labelSize)) + labelSize
+	}
+	sampleSize := 1 + 8
+	if timestamp != 0 {
+		sampleSize += 1 + protoVarintSize(uint64(timestamp))
+	}
+	tsSize := labelsSize + 1 + protoVarintSize(uint64(sampleSize)) + sampleSize
+
+	// WriteRequest.timeseries
+	dst = append(dst, 0x0a)
+	dst = marshalVarUint64(dst, uint64(tsSize))
+	for _, l := range labels {
+		// TimeSeries.labels
+		labelSize := protoStringFieldSize(l.name) + protoStringFieldSize(l.value)
+		dst = append(dst, 0x0a)
+		dst = marshalVarUint64(dst, uint64(labelSize))
+		dst = marshalProtoString(dst, 0x0a, l.name)
+		dst = marshalProtoString(dst, 0x12, l.value)
+	}
+	// TimeSeries.samples
+	dst = append(dst, 0x12)
+	dst = marshalVarUint64(dst, uint64(sampleSize))
+	// Sample.value
+	dst = append(dst, 0x09)
+	dst = marshalFixed64(dst, math.Float64bits(value))
+	if timestamp != 0 {
+		// Sample.timestamp
+		dst = append(dst, 0x10)
+		dst = marshalVarUint64(dst, uint64(timestamp))
+	}
+	return dst
+}
+
+func marshalProtoString(dst []byte, tag byte, s string) []byte {
+	if s == "" {
+		return dst
+	}
+	dst = append(dst, tag)
+	dst = marshalVarUint64(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func protoStringFieldSize(s string) int {
+	if s == "" {
+		return 0
+	}
+	return 1 + protoVarintSize(uint64(len(s))) + len(s)
+}
+
+func protoVarintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func marshalVarUint64(dst []byte, v uint64) []byte {
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+func marshalFixed64(dst []byte, v uint64) []byte {
+	return append(dst, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
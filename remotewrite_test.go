@@ -0,0 +1,253 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+func TestMarshalRemoteWriteRequestSuccess(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+		data, err := marshalRemoteWriteRequest(nil, []byte(s), 1234)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := unmarshalRemoteWriteRequestForTest(t, data)
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f("", "")
+	f("# HELP foo bar\n# TYPE foo counter\n\n", "")
+	f("foo 1\n", `{__name__="foo"} 1 1234`+"\n")
+	f(`foo{z="x",a="b",empty=""} 2.5 5678`, `{__name__="foo",a="b",z="x"} 2.5 5678`+"\n")
+	f("foo 1\nbar{x=\"y\"} -3\n", `{__name__="foo"} 1 1234`+"\n"+`{__name__="bar",x="y"} -3 1234`+"\n")
+}
+
+func TestMarshalRemoteWriteRequestFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		if _, err := marshalRemoteWriteRequest(nil, []byte(s), 1234); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+	f("foo")
+	f("foo 1\nbar{")
+}
+
+func TestInitPushRemoteWrite(t *testing.T) {
+	var requests uint64
+	var headers http.Header
+	var result string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddUint64(&requests, 1) == 1 {
+			// The first request must be retried.
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		headers = r.Header
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			panic(fmt.Errorf("cannot read request body: %w", err))
+		}
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			panic(fmt.Errorf("cannot decode snappy-compressed request body: %w", err))
+		}
+		result = unmarshalRemoteWriteRequestForTest(t, data)
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	s.NewCounter(`foo{bar="baz"}`).Add(42)
+
+	pc, err := newRemoteWritePushContext(srv.URL, time.Second, &PushOptions{
+		ExtraLabels: `instance="abc"`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	pc.minBackoff = time.Millisecond
+	if err := pc.pushMetrics(context.Background(), s.WritePrometheus); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := atomic.LoadUint64(&requests); n != 2 {
+		t.Fatalf("unexpected number of requests; got %d; want 2", n)
+	}
+	if v := headers.Get("Content-Encoding"); v != "snappy" {
+		t.Fatalf("unexpected Content-Encoding header; got %q; want %q", v, "snappy")
+	}
+	if v := headers.Get("Content-Type"); v != "application/x-protobuf" {
+		t.Fatalf("unexpected Content-Type header; got %q; want %q", v, "application/x-protobuf")
+	}
+	if v := headers.Get("X-Prometheus-Remote-Write-Version"); v != "0.1.0" {
+		t.Fatalf("unexpected X-Prometheus-Remote-Write-Version header; got %q; want %q", v, "0.1.0")
+	}
+	if !strings.HasPrefix(result, `{__name__="foo",bar="baz",instance="abc"} 42 `) {
+		t.Fatalf("unexpected request body: %q", result)
+	}
+}
+
+func TestInitPushRemoteWriteNoRetryOnClientError(t *testing.T) {
+	var requests uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&requests, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	s := NewSet()
+	s.NewCounter("foo").Inc()
+
+	pc, err := newRemoteWritePushContext(srv.URL, time.Second, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	pc.minBackoff = time.Millisecond
+	if err := pc.pushMetrics(context.Background(), s.WritePrometheus); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if n := atomic.LoadUint64(&requests); n != 1 {
+		t.Fatalf("unexpected number of requests; got %d; want 1", n)
+	}
+}
+
+func TestInitPushRemoteWriteRetries(t *testing.T) {
+	f := func(opts *PushOptions, requestsExpected uint64) {
+		t.Helper()
+		var requests uint64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddUint64(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		s := NewSet()
+		s.NewCounter("foo").Inc()
+
+		pc, err := newRemoteWritePushContext(srv.URL, time.Second, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		pc.minBackoff = time.Millisecond
+		pc.maxBackoff = time.Millisecond
+		if err := pc.pushMetrics(context.Background(), s.WritePrometheus); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if n := atomic.LoadUint64(&requests); n != requestsExpected {
+			t.Fatalf("unexpected number of requests; got %d; want %d", n, requestsExpected)
+		}
+	}
+
+	// Default retries
+	f(nil, remoteWriteMaxRetries+1)
+	f(&PushOptions{}, remoteWriteMaxRetries+1)
+
+	// Disabled retries
+	f(&PushOptions{
+		DisableRetries: true,
+	}, 1)
+	f(&PushOptions{
+		MaxRetries:     2,
+		DisableRetries: true,
+	}, 1)
+
+	// Custom retries
+	f(&PushOptions{
+		MaxRetries: 2,
+	}, 3)
+}
+
+// unmarshalRemoteWriteRequestForTest converts protobuf-encoded WriteRequest to `{labels} value timestamp` lines.
+func unmarshalRemoteWriteRequestForTest(t *testing.T, data []byte) string {
+	t.Helper()
+	var sb strings.Builder
+	for len(data) > 0 {
+		tag, ts, tail := readProtoFieldForTest(t, data)
+		data = tail
+		if tag != 0x0a {
+			t.Fatalf("unexpected WriteRequest field tag: 0x%x", tag)
+		}
+		var labels []string
+		var sample string
+		for len(ts) > 0 {
+			tag, b, tail := readProtoFieldForTest(t, ts)
+			ts = tail
+			switch tag {
+			case 0x0a:
+				var name, value string
+				for len(b) > 0 {
+					tag, s, tail := readProtoFieldForTest(t, b)
+					b = tail
+					switch tag {
+					case 0x0a:
+						name = string(s)
+					case 0x12:
+						value = string(s)
+					default:
+						t.Fatalf("unexpected Label field tag: 0x%x", tag)
+					}
+				}
+				labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+			case 0x12:
+				var value float64
+				var timestamp uint64
+				for len(b) > 0 {
+					tag := b[0]
+					b = b[1:]
+					switch tag {
+					case 0x09:
+						var v uint64
+						for i := 0; i < 8; i++ {
+							v |= uint64(b[i]) << (8 * i)
+						}
+						value = math.Float64frombits(v)
+						b = b[8:]
+					case 0x10:
+						timestamp, b = readVarUint64ForTest(t, b)
+					default:
+						t.Fatalf("unexpected Sample field tag: 0x%x", tag)
+					}
+				}
+				sample = fmt.Sprintf("%g %d", value, timestamp)
+			default:
+				t.Fatalf("unexpected TimeSeries field tag: 0x%x", tag)
+			}
+		}
+		fmt.Fprintf(&sb, "{%s} %s\n", strings.Join(labels, ","), sample)
+	}
+	return sb.String()
+}
+
+func readProtoFieldForTest(t *testing.T, data []byte) (byte, []byte, []byte) {
+	t.Helper()
+	tag := data[0]
+	n, tail := readVarUint64ForTest(t, data[1:])
+	if uint64(len(tail)) < n {
+		t.Fatalf("too short data for field with tag 0x%x; got %d bytes; want %d bytes", tag, len(tail), n)
+	}
+	return tag, tail[:n], tail[n:]
+}
+
+func readVarUint64ForTest(t *testing.T, data []byte) (uint64, []byte) {
+	t.Helper()
+	var v uint64
+	for i, b := range data {
+		v |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return v, data[i+1:]
+		}
+	}
+	t.Fatalf("unexpected end of varint")
+	return 0, nil
+}
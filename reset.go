@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ResetAllMetrics resets all the metrics in the default set and in the sets registered via RegisterSet to their initial zero state.
+//
+// Counter, FloatCounter and ShardedCounter values are set to zero, while their explicit timestamps and exemplars are cleared.
+// Histogram, PrometheusHistogram, NativeHistogram, Summary, MaxGauge, MinGauge and RateGauge lose all the observed values.
+// Gauges aren't changed.
+//
+// The metrics stay registered, so the existing pointers to them continue working and observe the zeroed state.
+//
+// ResetAllMetrics is intended for isolating tests, which use the global registry. Do not use it in production code,
+// since scrapers observe counter resets after the call. See also ResetAllMetricsAndUnregister.
+func ResetAllMetrics() {
+	for _, s := range getRegisteredSets() {
+		s.ResetAllMetrics()
+	}
+}
+
+// ResetAllMetrics resets all the metrics in s to their initial zero state.
+//
+// See ResetAllMetrics for details.
+func (s *Set) ResetAllMetrics() {
+	sa, _ := s.getSortedMetrics()
+	for _, nm := range sa {
+		if rm, ok := nm.metric.(metricResetter); ok {
+			rm.reset()
+		}
+	}
+}
+
+// ResetAllMetricsAndUnregister resets all the metrics in the default set and in the sets registered via RegisterSet
+// and then unregisters them including Gauge callbacks and callbacks passed to RegisterMetricsWriter.
+//
+// Goroutines holding pointers to the unregistered metrics may continue using them safely.
+// Such metrics start from the zero state, but they are no longer exposed. Metrics with the same names
+// may be registered again after the call.
+//
+// ResetAllMetricsAndUnregister is intended for isolating tests, which use the global registry.
+// Do not use it in production code.
+func ResetAllMetricsAndUnregister() {
+	for _, s := range getRegisteredSets() {
+		s.ResetAllMetricsAndUnregister()
+	}
+}
+
+// ResetAllMetricsAndUnregister resets all the metrics in s and then unregisters them.
+//
+// See ResetAllMetricsAndUnregister for details.
+func (s *Set) ResetAllMetricsAndUnregister() {
+	s.ResetAllMetrics()
+	s.UnregisterAllMetrics()
+}
+
+// metricResetter must be implemented by metrics, which are reset by ResetAllMetrics.
+type metricResetter interface {
+	// reset must reset the metric to the zero state. It must be safe to call reset concurrently with metric updates.
+	reset()
+}
+
+func (c *Counter) reset() {
+	c.created.onReset()
+	c.n.Store(0)
+	if ext := c.loadExt(); ext != nil {
+		ext.timestamp.store(time.Time{})
+		ext.exemplar.store(nil)
+	}
+}
+
+func (fc *FloatCounter) reset() {
+	fc.created.onReset()
+	fc.valueBits.Store(0)
+}
+
+func (sc *ShardedCounter) reset() {
+	shards := sc.getShards()
+	for i := range shards {
+		atomic.StoreUint64(&shards[i].n, 0)
+	}
+}
+
+func (h *Histogram) reset() {
+	h.Reset()
+}
+
+func (ph *PrometheusHistogram) reset() {
+	ph.created.onReset()
+	for i := range ph.buckets {
+		atomic.StoreUint64(&ph.buckets[i], 0)
+	}
+	ph.sumBits.Store(0)
+}
+
+func (nh *NativeHistogram) reset() {
+	nh.mu.Lock()
+	nh.zeroCount = 0
+	nh.positive = make(map[int32]uint64)
+	nh.negative = make(map[int32]uint64)
+	nh.count = 0
+	nh.sum = 0
+	nh.mu.Unlock()
+}
+
+func (mg *MaxGauge) reset() {
+	mg.eg.reset()
+}
+
+func (mg *MinGauge) reset() {
+	mg.eg.reset()
+}
+
+func (rg *RateGauge) reset() {
+	rg.mu.Lock()
+	for i := range rg.buckets {
+		rg.buckets[i] = 0
+	}
+	rg.mu.Unlock()
+}
+
+func (sm *Summary) reset() {
+	sm.created.onReset()
+	sm.mu.Lock()
+	sm.backend.reset()
+	sm.sum = 0
+	sm.count = 0
+	sm.sampledSum = 0
+	sm.sampledCount = 0
+	for i := range sm.quantileValues {
+		sm.quantileValues[i] = math.NaN()
+	}
+	sm.mu.Unlock()
+}
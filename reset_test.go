@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetResetAllMetrics(t *testing.T) {
+	registerMetrics := func(s *Set) *NativeHistogram {
+		s.NewCounter(`counter{a="b"}`)
+		s.NewFloatCounter("float_counter")
+		s.NewShardedCounter("sharded_counter")
+		s.NewGauge("gauge", func() float64 { return 42 })
+		s.NewHistogram("histogram")
+		s.NewHistogramWithBuckets("prometheus_histogram", []float64{1, 10})
+		s.NewSummaryExt("summary", time.Minute, []float64{0.5, 1})
+		return s.NewNativeHistogram("native_histogram", 1.1)
+	}
+	writeSet := func(s *Set) string {
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		return bb.String()
+	}
+
+	sFresh := NewSet()
+	registerMetrics(sFresh)
+	resultExpected := writeSet(sFresh)
+
+	s := NewSet()
+	nh := registerMetrics(s)
+	c := s.GetOrCreateCounter(`counter{a="b"}`)
+	fc := s.GetOrCreateFloatCounter("float_counter")
+	sc := s.GetOrCreateShardedCounter("sharded_counter")
+	h := s.GetOrCreateHistogram("histogram")
+	ph := s.GetOrCreateHistogramWithBuckets("prometheus_histogram", []float64{1, 10})
+	sm := s.GetOrCreateSummaryExt("summary", time.Minute, []float64{0.5, 1})
+
+	update := func() {
+		if err := c.AddWithExemplar(3, map[string]string{"trace_id": "abc"}); err != nil {
+			panic(fmt.Errorf("unexpected error: %w", err))
+		}
+		c.SetWithTimestamp(5, time.Unix(123, 0))
+		fc.Add(1.5)
+		sc.Add(7)
+		h.Update(10)
+		ph.Update(5)
+		nh.Update(-3)
+		sm.Update(4)
+	}
+	update()
+	if result := writeSet(s); result == resultExpected {
+		t.Fatalf("unexpected result before the reset; got\n%s", result)
+	}
+
+	s.ResetAllMetrics()
+	if result := writeSet(s); result != resultExpected {
+		t.Fatalf("unexpected result after the reset;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+	var bb bytes.Buffer
+	s.WriteOpenMetrics(&bb)
+	if bytes.Contains(bb.Bytes(), []byte("trace_id")) {
+		t.Fatalf("unexpected exemplar after the reset; got\n%s", bb.String())
+	}
+
+	// The existing pointers continue working after the reset.
+	c.Inc()
+	h.Update(1)
+	if n := c.Get(); n != 1 {
+		t.Fatalf("unexpected counter value; got %d; want %d", n, 1)
+	}
+	if n := sm.GetCount(); n != 0 {
+		t.Fatalf("unexpected summary count; got %d; want %d", n, 0)
+	}
+	if sum := h.GetSum(); sum != 1 {
+		t.Fatalf("unexpected histogram sum; got %v; want %v", sum, 1)
+	}
+
+	// Reset must be safe to call concurrently with updates.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				update()
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		s.ResetAllMetrics()
+	}
+	wg.Wait()
+	s.ResetAllMetrics()
+	if result := writeSet(s); result != resultExpected {
+		t.Fatalf("unexpected result after the concurrent reset;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestSetResetAllMetricsAndUnregister(t *testing.T) {
+	s := NewSet()
+	c := s.NewCounter("counter")
+	s.NewGauge("gauge", func() float64 { return 1 })
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "custom_metric 1\n")
+	})
+	c.Add(10)
+
+	s.ResetAllMetricsAndUnregister()
+	if names := s.ListMetricNames(); len(names) != 0 {
+		t.Fatalf("unexpected metrics after the unregister: %q", names)
+	}
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	if bb.Len() != 0 {
+		t.Fatalf("unexpected non-empty output after the unregister; got\n%s", bb.String())
+	}
+
+	// The held pointer is still usable and starts from zero.
+	c.Inc()
+	if n := c.Get(); n != 1 {
+		t.Fatalf("unexpected counter value; got %d; want %d", n, 1)
+	}
+
+	// The metrics with the same names may be registered again.
+	cNew := s.NewCounter("counter")
+	s.NewGauge("gauge", func() float64 { return 2 })
+	cNew.Add(3)
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	resultExpected := fmt.Sprintf("counter %d\ngauge %d\n", 3, 2)
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
@@ -1,11 +1,13 @@
 package metrics
 
 import (
-	"bytes"
 	"fmt"
 	"io"
+	"log"
+	"reflect"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,12 +17,55 @@ import (
 //
 // Set.WritePrometheus must be called for exporting metrics from the set.
 type Set struct {
-	mu        sync.Mutex
+	// mu protects the fields below. Lookups of existing metrics are performed under the read lock,
+	// so they don't contend with each other.
+	mu        sync.RWMutex
 	a         []*namedMetric
 	m         map[string]*namedMetric
 	summaries []*Summary
 
-	metricsWriters []func(w io.Writer)
+	// aSorted is set to true when a is sorted by metric names. It is reset to false when new metric is added to a.
+	// This allows avoiding sorting a on every getSortedMetrics call.
+	aSorted bool
+
+	metricsWriters []*MetricsWriter
+
+	// preWriteHooks contains callbacks registered via RegisterPreWriteHook.
+	preWriteHooks []*PreWriteHook
+
+	// buildInfos contains metric names for build info gauges registered via RegisterBuildInfo by their base names.
+	buildInfos map[string]string
+
+	// httpServerMetrics contains metrics for handlers returned from InstrumentHandler. It is created on the first use.
+	httpServerMetrics *httpServerMetrics
+
+	// dbStats contains metric names for gauges registered via RegisterDBStats by db names.
+	dbStats map[string][]string
+
+	// metricHelps contains help holders for metric families by their names. See SetMetricHelp.
+	metricHelps map[string]*metricHelp
+
+	// pairsIdx contains metrics registered via GetOrCreate*Pairs functions by the hash of their base name and label pairs.
+	pairsIdx map[uint64][]*pairsEntry
+
+	// statsd is an optional StatsD client for mirroring metric updates. See AttachStatsD.
+	statsd *StatsDClient
+
+	// hasTTLMetrics is set to true when the first metric with TTL is registered in s.
+	// It allows skipping the search for expired metrics in sets without such metrics.
+	hasTTLMetrics bool
+
+	// commonLabels contains labels, which are added to all the metrics registered in s. See NewSetWithLabels.
+	commonLabels []label
+
+	// metricsCount is the number of registered metrics in s excluding auxiliary metrics such as summary quantiles.
+	metricsCount int
+
+	// maxMetrics is the limit on metricsCount set via SetMaxMetrics. The number of metrics isn't limited if it is zero.
+	maxMetrics int
+
+	// overflow contains metrics returned from GetOrCreate* functions when maxMetrics is reached. It is created on the first use.
+	overflow *overflowMetrics
 }
 
 // NewSet creates new set of metrics.
@@ -33,41 +78,197 @@ func NewSet() *Set {
 }
 
 // WritePrometheus writes all the metrics from s to w in Prometheus format.
+//
+// See also WritePrometheusErr.
 func (s *Set) WritePrometheus(w io.Writer) {
-	// Collect all the metrics in in-memory buffer in order to prevent from long locking due to slow w.
-	var bb bytes.Buffer
-	lessFunc := func(i, j int) bool {
-		return s.a[i].name < s.a[j].name
-	}
-	s.mu.Lock()
-	for _, sm := range s.summaries {
-		sm.updateQuantiles()
+	s.writePrometheusFiltered(w, nil)
+}
+
+// WritePrometheusErr writes all the metrics from s to w in Prometheus format and returns the first error returned by w.
+//
+// Writing stops on the first error, so the remaining metrics aren't marshaled.
+func (s *Set) WritePrometheusErr(w io.Writer) error {
+	cw := &countingWriter{
+		w: w,
 	}
-	if !sort.SliceIsSorted(s.a, lessFunc) {
-		sort.Slice(s.a, lessFunc)
+	s.WritePrometheus(cw)
+	return cw.err
+}
+
+// writePrometheusFiltered writes metrics matching mf from s to w in Prometheus format.
+//
+// All the metrics are written if mf is nil.
+func (s *Set) writePrometheusFiltered(w io.Writer, mf *metricNameFilter) {
+	s.runPreWriteHooks()
+	sa, metricsWriters := s.getSortedMetrics()
+	dd := getDuplicateDetector()
+	writePrometheusMetrics(w, sa, metricsWriters, mf, dd)
+	if dd.err != nil {
+		log.Printf("ERROR: metrics: stop writing metrics: %s", dd.err)
+		setWriteError(w, dd.err)
 	}
-	sa := append([]*namedMetric(nil), s.a...)
-	metricsWriters := s.metricsWriters
-	s.mu.Unlock()
+	putDuplicateDetector(dd)
+}
 
+// writePrometheusMetrics writes metrics from sa sorted by names and metricsWriters output matching mf to w in Prometheus format.
+//
+// Series written by external sources are checked for duplicates with dd. Writing stops if dd.err is set in strict mode.
+// All the metrics are written if mf is nil.
+func writePrometheusMetrics(w io.Writer, sa []*namedMetric, metricsWriters []*MetricsWriter, mf *metricNameFilter, dd *duplicateDetector) {
+	// Collect the metrics in in-memory buffer in order to avoid small writes to w.
+	// Metrics are marshaled directly into the pooled buffer without memory allocations.
+	// The buffer is flushed to w in chunks, so the marshaling stops on the first write error if w tracks write errors.
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	wr := getWriteRelabeler()
+	exposeCreated := isCreatedSeriesInTextEnabled()
 	prevMetricFamily := ""
-	for _, nm := range sa {
+	prevMatch := false
+	for i, nm := range sa {
 		metricFamily := getMetricFamily(nm.name)
-		if metricFamily != prevMetricFamily {
-			// write meta info only once per metric family
-			metricType := nm.metric.metricType()
-			WriteMetadataIfNeeded(&bb, nm.name, metricType)
+		if i == 0 || metricFamily != prevMetricFamily {
+			// Metrics are sorted by family, so mf is evaluated and meta info is written only once per metric family.
 			prevMetricFamily = metricFamily
+			prevMatch = mf.match(metricFamily)
+			if prevMatch {
+				metricType, help := getMetricFamilyMetadata(sa[i:], metricFamily)
+				if wr != nil {
+					wr.writeMetadata(bb, metricFamily, metricType, help)
+				} else {
+					writeMetadataIfNeeded(bb, metricFamily, metricType, help)
+				}
+			}
+		}
+		if !prevMatch {
+			continue
+		}
+		if len(bb.B) >= writeChunkSize {
+			w.Write(bb.B)
+			bb.B = bb.B[:0]
+			if getWriteError(w) != nil {
+				return
+			}
 		}
 		// Call marshalTo without the global lock, since certain metric types such as Gauge
 		// can call a callback, which, in turn, can try calling s.mu.Lock again.
-		nm.metric.marshalTo(nm.name, &bb)
+		if wr != nil || needsQuoting(metricFamily) {
+			bbTmp := getBytesBuffer()
+			nm.metric.marshalTo(nm.name, bbTmp)
+			if exposeCreated {
+				bbTmp.B = appendCreatedSeries(bbTmp.B, nm)
+			}
+			if wr != nil {
+				bb.B = wr.relabelMetric(bb.B, bbTmp.B, metricFamily)
+			} else {
+				bb.B = quoteMetricNames(bb.B, bbTmp.B, metricFamily)
+			}
+			putBytesBuffer(bbTmp)
+			continue
+		}
+		nm.metric.marshalTo(nm.name, bb)
+		if exposeCreated {
+			bb.B = appendCreatedSeries(bb.B, nm)
+		}
+	}
+	w.Write(bb.B)
+	if getWriteError(w) != nil {
+		return
+	}
+
+	if mf == nil && wr == nil {
+		for _, mw := range metricsWriters {
+			mw.writeWithDetector(w, dd)
+			if getWriteError(w) != nil || dd.err != nil {
+				return
+			}
+		}
+		return
+	}
+	if len(metricsWriters) == 0 {
+		return
+	}
+	bbWriters := getBytesBuffer()
+	for _, mw := range metricsWriters {
+		mw.writeWithDetector(bbWriters, dd)
+		if dd.err != nil {
+			putBytesBuffer(bbWriters)
+			return
+		}
+	}
+	if mf != nil {
+		bbFiltered := getBytesBuffer()
+		bbFiltered.B = mf.filterText(bbFiltered.B[:0], bbWriters.B)
+		putBytesBuffer(bbWriters)
+		bbWriters = bbFiltered
+	}
+	if wr != nil {
+		bbRelabeled := getBytesBuffer()
+		bbRelabeled.B = wr.relabelText(bbRelabeled.B[:0], bbWriters.B, nil, "")
+		putBytesBuffer(bbWriters)
+		bbWriters = bbRelabeled
+	}
+	w.Write(bbWriters.B)
+	putBytesBuffer(bbWriters)
+}
+
+// writeChunkSize is the size of chunks for writing marshaled metrics to io.Writer.
+const writeChunkSize = 64 * 1024
+
+// getSortedMetrics returns a copy of metrics registered in s sorted by name.
+//
+// The metrics are sorted by metric family and then by labels - see lessMetricName.
+// The sorted list is cached in s and it is re-sorted only after new metrics are registered,
+// so the list is just copied on subsequent calls.
+//
+// It also returns metricsWriters registered in s.
+func (s *Set) getSortedMetrics() ([]*namedMetric, []*MetricsWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasTTLMetrics {
+		s.expireMetricsLocked(clockNow())
+	}
+	for _, sm := range s.summaries {
+		sm.updateQuantiles()
 	}
-	w.Write(bb.Bytes())
+	s.sortMetricsLocked()
+	sa := append([]*namedMetric(nil), s.a...)
+	return sa, s.metricsWriters
+}
 
-	for _, writeMetrics := range metricsWriters {
-		writeMetrics(w)
+// sortMetricsLocked sorts s.a in the order metrics are exposed by WritePrometheus.
+func (s *Set) sortMetricsLocked() {
+	if s.aSorted {
+		return
 	}
+	sort.Slice(s.a, func(i, j int) bool {
+		return lessMetricName(s.a[i].name, s.a[j].name)
+	})
+	s.aSorted = true
+}
+
+// appendMetricLocked adds nm to the list of metrics registered in s.
+func (s *Set) appendMetricLocked(nm *namedMetric) {
+	nm.familyHelp = s.getMetricHelpLocked(getMetricFamily(nm.name))
+	initCreatedTimestamp(nm)
+	s.a = append(s.a, nm)
+	s.aSorted = false
+	if !nm.isAux {
+		s.metricsCount++
+	}
+}
+
+// lessMetricName returns true if metric a must be written before metric b.
+//
+// Metrics are ordered by metric family names and then by labels, so all the metrics for the same family
+// are written next to each other.
+func lessMetricName(a, b string) bool {
+	familyA, labelsA := splitMetricName(a)
+	familyB, labelsB := splitMetricName(b)
+	if familyA != familyB {
+		return familyA < familyB
+	}
+	return labelsA < labelsB
 }
 
 // NewHistogram creates and returns new histogram in s with the given name.
@@ -82,8 +283,17 @@ func (s *Set) WritePrometheus(w io.Writer) {
 // The returned histogram is safe to use from concurrent goroutines.
 func (s *Set) NewHistogram(name string) *Histogram {
 	h := &Histogram{}
-	s.registerMetric(name, h)
-	return h
+	return s.registerMetric(name, h, "").(*Histogram)
+}
+
+// NewHistogramOpt creates and returns new histogram in s with the given opts.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+//
+// The returned histogram is safe to use from concurrent goroutines.
+func (s *Set) NewHistogramOpt(opts HistogramOpts) *Histogram {
+	h := &Histogram{}
+	return s.registerMetricWithUnit(opts.Name, h, opts.Help, opts.Unit).(*Histogram)
 }
 
 // GetOrCreateHistogram returns registered histogram in s with the given name
@@ -100,25 +310,32 @@ func (s *Set) NewHistogram(name string) *Histogram {
 //
 // Performance tip: prefer NewHistogram instead of GetOrCreateHistogram.
 func (s *Set) GetOrCreateHistogram(name string) *Histogram {
-	s.mu.Lock()
+	s.mu.RLock()
 	nm := s.m[name]
-	s.mu.Unlock()
+	s.mu.RUnlock()
 	if nm == nil {
 		// Slow path - create and register missing histogram.
-		if err := validateMetric(name); err != nil {
-			panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
-		}
+		atomic.AddUint64(&getOrCreateSlowPathTotal, 1)
+		nameRaw := name
+		name = s.mustNormalizeMetricName(name)
 		nmNew := &namedMetric{
 			name:   name,
 			metric: &Histogram{},
 		}
 		s.mu.Lock()
 		nm = s.m[name]
+		if nm == nil && s.isMetricsLimitReachedLocked() {
+			m := s.getOverflowMetricLocked(nmNew.metric)
+			s.mu.Unlock()
+			return m.(*Histogram)
+		}
 		if nm == nil {
 			nm = nmNew
 			s.m[name] = nm
-			s.a = append(s.a, nm)
+			s.appendMetricLocked(nm)
+			s.attachStatsDLocked(nm)
 		}
+		s.addAliasLocked(nameRaw, nm)
 		s.mu.Unlock()
 	}
 	h, ok := nm.metric.(*Histogram)
@@ -128,6 +345,74 @@ func (s *Set) GetOrCreateHistogram(name string) *Histogram {
 	return h
 }
 
+// NewHistogramWithBuckets creates and returns new PrometheusHistogram in s with the given name and upperBounds.
+//
+// See NewHistogramWithBuckets for details.
+func (s *Set) NewHistogramWithBuckets(name string, upperBounds []float64) *PrometheusHistogram {
+	ph := newPrometheusHistogram(upperBounds)
+	return s.registerMetric(name, ph, "").(*PrometheusHistogram)
+}
+
+// NewHistogramHDR creates and returns new HDRHistogram in s with the given name for values in the range [minValue..maxValue]
+// with the relative error 10^-significantDigits.
+//
+// See NewHistogramHDR for details.
+func (s *Set) NewHistogramHDR(name string, minValue, maxValue float64, significantDigits int) *HDRHistogram {
+	hh := newHDRHistogram(minValue, maxValue, significantDigits)
+	return s.registerMetric(name, hh, "").(*HDRHistogram)
+}
+
+// GetOrCreateHistogramWithBuckets returns registered PrometheusHistogram in s with the given name and upperBounds
+// or creates new histogram if s doesn't contain histogram with the given name.
+//
+// See GetOrCreateHistogramWithBuckets for details.
+func (s *Set) GetOrCreateHistogramWithBuckets(name string, upperBounds []float64) *PrometheusHistogram {
+	s.mu.RLock()
+	nm := s.m[name]
+	s.mu.RUnlock()
+	if nm == nil {
+		// Slow path - create and register missing histogram.
+		atomic.AddUint64(&getOrCreateSlowPathTotal, 1)
+		nameRaw := name
+		name = s.mustNormalizeMetricName(name)
+		nmNew := &namedMetric{
+			name:   name,
+			metric: newPrometheusHistogram(upperBounds),
+		}
+		s.mu.Lock()
+		nm = s.m[name]
+		if nm == nil && s.isMetricsLimitReachedLocked() {
+			m := s.getOverflowMetricLocked(nmNew.metric)
+			s.mu.Unlock()
+			return m.(*PrometheusHistogram)
+		}
+		if nm == nil {
+			nm = nmNew
+			s.m[name] = nm
+			s.appendMetricLocked(nm)
+			s.attachStatsDLocked(nm)
+		}
+		s.addAliasLocked(nameRaw, nm)
+		s.mu.Unlock()
+	}
+	ph, ok := nm.metric.(*PrometheusHistogram)
+	if !ok {
+		panic(fmt.Errorf("BUG: metric %q isn't a PrometheusHistogram. It is %T", name, nm.metric))
+	}
+	if !ph.hasUpperBounds(upperBounds) {
+		panic(fmt.Errorf("BUG: PrometheusHistogram %q is already registered with upperBounds %v; got %v", name, ph.upperBounds, upperBounds))
+	}
+	return ph
+}
+
+// NewNativeHistogram creates and returns new NativeHistogram in s with the given name and growthFactor.
+//
+// See NewNativeHistogram for details.
+func (s *Set) NewNativeHistogram(name string, growthFactor float64) *NativeHistogram {
+	nh := newNativeHistogram(growthFactor)
+	return s.registerMetric(name, nh, "").(*NativeHistogram)
+}
+
 // NewCounter registers and returns new counter with the given name in the s.
 //
 // name must be valid Prometheus-compatible metric with possible labels.
@@ -140,8 +425,17 @@ func (s *Set) GetOrCreateHistogram(name string) *Histogram {
 // The returned counter is safe to use from concurrent goroutines.
 func (s *Set) NewCounter(name string) *Counter {
 	c := &Counter{}
-	s.registerMetric(name, c)
-	return c
+	return s.registerMetric(name, c, "").(*Counter)
+}
+
+// NewCounterOpt registers and returns new counter with the given opts in the s.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+//
+// The returned counter is safe to use from concurrent goroutines.
+func (s *Set) NewCounterOpt(opts CounterOpts) *Counter {
+	c := &Counter{}
+	return s.registerMetricWithUnit(opts.Name, c, opts.Help, opts.Unit).(*Counter)
 }
 
 // GetOrCreateCounter returns registered counter in s with the given name
@@ -158,25 +452,32 @@ func (s *Set) NewCounter(name string) *Counter {
 //
 // Performance tip: prefer NewCounter instead of GetOrCreateCounter.
 func (s *Set) GetOrCreateCounter(name string) *Counter {
-	s.mu.Lock()
+	s.mu.RLock()
 	nm := s.m[name]
-	s.mu.Unlock()
+	s.mu.RUnlock()
 	if nm == nil {
 		// Slow path - create and register missing counter.
-		if err := validateMetric(name); err != nil {
-			panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
-		}
+		atomic.AddUint64(&getOrCreateSlowPathTotal, 1)
+		nameRaw := name
+		name = s.mustNormalizeMetricName(name)
 		nmNew := &namedMetric{
 			name:   name,
 			metric: &Counter{},
 		}
 		s.mu.Lock()
 		nm = s.m[name]
+		if nm == nil && s.isMetricsLimitReachedLocked() {
+			m := s.getOverflowMetricLocked(nmNew.metric)
+			s.mu.Unlock()
+			return m.(*Counter)
+		}
 		if nm == nil {
 			nm = nmNew
 			s.m[name] = nm
-			s.a = append(s.a, nm)
+			s.appendMetricLocked(nm)
+			s.attachStatsDLocked(nm)
 		}
+		s.addAliasLocked(nameRaw, nm)
 		s.mu.Unlock()
 	}
 	c, ok := nm.metric.(*Counter)
@@ -186,6 +487,70 @@ func (s *Set) GetOrCreateCounter(name string) *Counter {
 	return c
 }
 
+// NewShardedCounter registers and returns new sharded counter with the given name in the s.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// The returned counter is safe to use from concurrent goroutines.
+func (s *Set) NewShardedCounter(name string) *ShardedCounter {
+	c := newShardedCounter()
+	return s.registerMetric(name, c, "").(*ShardedCounter)
+}
+
+// GetOrCreateShardedCounter returns registered sharded counter in s with the given name
+// or creates new sharded counter if s doesn't contain counter with the given name.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// The returned counter is safe to use from concurrent goroutines.
+//
+// Performance tip: prefer NewShardedCounter instead of GetOrCreateShardedCounter.
+func (s *Set) GetOrCreateShardedCounter(name string) *ShardedCounter {
+	s.mu.RLock()
+	nm := s.m[name]
+	s.mu.RUnlock()
+	if nm == nil {
+		// Slow path - create and register missing counter.
+		atomic.AddUint64(&getOrCreateSlowPathTotal, 1)
+		nameRaw := name
+		name = s.mustNormalizeMetricName(name)
+		nmNew := &namedMetric{
+			name:   name,
+			metric: newShardedCounter(),
+		}
+		s.mu.Lock()
+		nm = s.m[name]
+		if nm == nil && s.isMetricsLimitReachedLocked() {
+			m := s.getOverflowMetricLocked(nmNew.metric)
+			s.mu.Unlock()
+			return m.(*ShardedCounter)
+		}
+		if nm == nil {
+			nm = nmNew
+			s.m[name] = nm
+			s.appendMetricLocked(nm)
+			s.attachStatsDLocked(nm)
+		}
+		s.addAliasLocked(nameRaw, nm)
+		s.mu.Unlock()
+	}
+	c, ok := nm.metric.(*ShardedCounter)
+	if !ok {
+		panic(fmt.Errorf("BUG: metric %q isn't a ShardedCounter. It is %T", name, nm.metric))
+	}
+	return c
+}
+
 // NewFloatCounter registers and returns new FloatCounter with the given name in the s.
 //
 // name must be valid Prometheus-compatible metric with possible labels.
@@ -198,8 +563,7 @@ func (s *Set) GetOrCreateCounter(name string) *Counter {
 // The returned FloatCounter is safe to use from concurrent goroutines.
 func (s *Set) NewFloatCounter(name string) *FloatCounter {
 	c := &FloatCounter{}
-	s.registerMetric(name, c)
-	return c
+	return s.registerMetric(name, c, "").(*FloatCounter)
 }
 
 // GetOrCreateFloatCounter returns registered FloatCounter in s with the given name
@@ -216,25 +580,32 @@ func (s *Set) NewFloatCounter(name string) *FloatCounter {
 //
 // Performance tip: prefer NewFloatCounter instead of GetOrCreateFloatCounter.
 func (s *Set) GetOrCreateFloatCounter(name string) *FloatCounter {
-	s.mu.Lock()
+	s.mu.RLock()
 	nm := s.m[name]
-	s.mu.Unlock()
+	s.mu.RUnlock()
 	if nm == nil {
 		// Slow path - create and register missing counter.
-		if err := validateMetric(name); err != nil {
-			panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
-		}
+		atomic.AddUint64(&getOrCreateSlowPathTotal, 1)
+		nameRaw := name
+		name = s.mustNormalizeMetricName(name)
 		nmNew := &namedMetric{
 			name:   name,
 			metric: &FloatCounter{},
 		}
 		s.mu.Lock()
 		nm = s.m[name]
+		if nm == nil && s.isMetricsLimitReachedLocked() {
+			m := s.getOverflowMetricLocked(nmNew.metric)
+			s.mu.Unlock()
+			return m.(*FloatCounter)
+		}
 		if nm == nil {
 			nm = nmNew
 			s.m[name] = nm
-			s.a = append(s.a, nm)
+			s.appendMetricLocked(nm)
+			s.attachStatsDLocked(nm)
 		}
+		s.addAliasLocked(nameRaw, nm)
 		s.mu.Unlock()
 	}
 	c, ok := nm.metric.(*FloatCounter)
@@ -261,8 +632,28 @@ func (s *Set) NewGauge(name string, f func() float64) *Gauge {
 	g := &Gauge{
 		f: f,
 	}
-	s.registerMetric(name, g)
-	return g
+	return s.registerMetric(name, g, "").(*Gauge)
+}
+
+// NewCachedGauge registers and returns gauge with the given name in s, which calls f to obtain gauge value at most once per ttl.
+//
+// See NewCachedGauge for details.
+func (s *Set) NewCachedGauge(name string, ttl time.Duration, f func() float64) *Gauge {
+	return s.NewGauge(name, newCachedGaugeFunc(f, ttl))
+}
+
+// NewGaugeOpt registers and returns gauge with the given opts in s, which calls f
+// to obtain gauge value.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+// f may be nil - see NewGauge for details.
+//
+// The returned gauge is safe to use from concurrent goroutines.
+func (s *Set) NewGaugeOpt(opts GaugeOpts, f func() float64) *Gauge {
+	g := &Gauge{
+		f: f,
+	}
+	return s.registerMetricWithUnit(opts.Name, g, opts.Help, opts.Unit).(*Gauge)
 }
 
 // GetOrCreateGauge returns registered gauge with the given name in s
@@ -279,14 +670,14 @@ func (s *Set) NewGauge(name string, f func() float64) *Gauge {
 //
 // Performance tip: prefer NewGauge instead of GetOrCreateGauge.
 func (s *Set) GetOrCreateGauge(name string, f func() float64) *Gauge {
-	s.mu.Lock()
+	s.mu.RLock()
 	nm := s.m[name]
-	s.mu.Unlock()
+	s.mu.RUnlock()
 	if nm == nil {
 		// Slow path - create and register missing gauge.
-		if err := validateMetric(name); err != nil {
-			panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
-		}
+		atomic.AddUint64(&getOrCreateSlowPathTotal, 1)
+		nameRaw := name
+		name = s.mustNormalizeMetricName(name)
 		nmNew := &namedMetric{
 			name: name,
 			metric: &Gauge{
@@ -295,11 +686,18 @@ func (s *Set) GetOrCreateGauge(name string, f func() float64) *Gauge {
 		}
 		s.mu.Lock()
 		nm = s.m[name]
+		if nm == nil && s.isMetricsLimitReachedLocked() {
+			m := s.getOverflowMetricLocked(nmNew.metric)
+			s.mu.Unlock()
+			return m.(*Gauge)
+		}
 		if nm == nil {
 			nm = nmNew
 			s.m[name] = nm
-			s.a = append(s.a, nm)
+			s.appendMetricLocked(nm)
+			s.attachStatsDLocked(nm)
 		}
+		s.addAliasLocked(nameRaw, nm)
 		s.mu.Unlock()
 	}
 	g, ok := nm.metric.(*Gauge)
@@ -335,21 +733,35 @@ func (s *Set) NewSummary(name string) *Summary {
 //
 // The returned summary is safe to use from concurrent goroutines.
 func (s *Set) NewSummaryExt(name string, window time.Duration, quantiles []float64) *Summary {
-	if err := validateMetric(name); err != nil {
-		panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
+	return s.newSummaryExt(name, window, quantiles, "")
+}
+
+// NewSummaryOpt creates and returns new summary in s with the given opts.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+// Default window and quantiles are used if opts.Window and opts.Quantiles are unset.
+//
+// The returned summary is safe to use from concurrent goroutines.
+func (s *Set) NewSummaryOpt(opts SummaryOpts) *Summary {
+	window := opts.Window
+	if window <= 0 {
+		window = defaultSummaryWindow
+	}
+	quantiles := opts.Quantiles
+	if quantiles == nil {
+		quantiles = defaultSummaryQuantiles
 	}
 	sm := newSummary(window, quantiles)
+	sm.backend = newSummaryBackend(opts.Backend, opts.Compression)
+	if opts.SampleRate != 0 {
+		sm.SetSampleRate(opts.SampleRate)
+	}
+	return s.registerMetricWithUnit(opts.Name, sm, opts.Help, opts.Unit).(*Summary)
+}
 
-	s.mu.Lock()
-	// defer will unlock in case of panic
-	// checks in tests
-	defer s.mu.Unlock()
-
-	s.mustRegisterLocked(name, sm, false)
-	registerSummaryLocked(sm)
-	s.registerSummaryQuantilesLocked(name, sm)
-	s.summaries = append(s.summaries, sm)
-	return sm
+func (s *Set) newSummaryExt(name string, window time.Duration, quantiles []float64, help string) *Summary {
+	sm := newSummary(window, quantiles)
+	return s.registerMetric(name, sm, help).(*Summary)
 }
 
 // GetOrCreateSummary returns registered summary with the given name in s
@@ -382,16 +794,18 @@ func (s *Set) GetOrCreateSummary(name string) *Summary {
 //
 // The returned summary is safe to use from concurrent goroutines.
 //
+// It panics if the registered summary has distinct window or quantiles.
+//
 // Performance tip: prefer NewSummaryExt instead of GetOrCreateSummaryExt.
 func (s *Set) GetOrCreateSummaryExt(name string, window time.Duration, quantiles []float64) *Summary {
-	s.mu.Lock()
+	s.mu.RLock()
 	nm := s.m[name]
-	s.mu.Unlock()
+	s.mu.RUnlock()
 	if nm == nil {
 		// Slow path - create and register missing summary.
-		if err := validateMetric(name); err != nil {
-			panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
-		}
+		atomic.AddUint64(&getOrCreateSlowPathTotal, 1)
+		nameRaw := name
+		name = s.mustNormalizeMetricName(name)
 		sm := newSummary(window, quantiles)
 		nmNew := &namedMetric{
 			name:   name,
@@ -399,14 +813,21 @@ func (s *Set) GetOrCreateSummaryExt(name string, window time.Duration, quantiles
 		}
 		s.mu.Lock()
 		nm = s.m[name]
+		if nm == nil && s.isMetricsLimitReachedLocked() {
+			m := s.getOverflowMetricLocked(nmNew.metric)
+			s.mu.Unlock()
+			return m.(*Summary)
+		}
 		if nm == nil {
 			nm = nmNew
 			s.m[name] = nm
-			s.a = append(s.a, nm)
+			s.appendMetricLocked(nm)
+			s.attachStatsDLocked(nm)
 			registerSummaryLocked(sm)
 			s.registerSummaryQuantilesLocked(name, sm)
+			s.summaries = append(s.summaries, sm)
 		}
-		s.summaries = append(s.summaries, sm)
+		s.addAliasLocked(nameRaw, nm)
 		s.mu.Unlock()
 	}
 	sm, ok := nm.metric.(*Summary)
@@ -414,10 +835,10 @@ func (s *Set) GetOrCreateSummaryExt(name string, window time.Duration, quantiles
 		panic(fmt.Errorf("BUG: metric %q isn't a Summary. It is %T", name, nm.metric))
 	}
 	if sm.window != window {
-		panic(fmt.Errorf("BUG: invalid window requested for the summary %q; requested %s; need %s", name, window, sm.window))
+		panic(fmt.Errorf("BUG: summary %q is already registered with window=%s; cannot get it with window=%s", name, sm.window, window))
 	}
 	if !isEqualQuantiles(sm.quantiles, quantiles) {
-		panic(fmt.Errorf("BUG: invalid quantiles requested from the summary %q; requested %v; need %v", name, quantiles, sm.quantiles))
+		panic(fmt.Errorf("BUG: summary %q is already registered with quantiles=%v; cannot get it with quantiles=%v", name, sm.quantiles, quantiles))
 	}
 	return sm
 }
@@ -433,15 +854,103 @@ func (s *Set) registerSummaryQuantilesLocked(name string, sm *Summary) {
 	}
 }
 
-func (s *Set) registerMetric(name string, m metric) {
-	if err := validateMetric(name); err != nil {
-		panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
+// registerMetric registers m with the given name and help in s and returns the registered metric.
+//
+// The already registered metric is returned instead of m if it is compatible with m
+// and duplicate registration is allowed via SetAllowDuplicateRegistration.
+// It panics on errors.
+func (s *Set) registerMetric(name string, m metric, help string) metric {
+	return s.registerMetricWithUnit(name, m, help, "")
+}
+
+// registerMetricWithUnit works like registerMetric, but additionally sets the unit for the registered metric.
+func (s *Set) registerMetricWithUnit(name string, m metric, help, unit string) metric {
+	mRegistered, err := s.tryRegisterMetricExt(name, m, help, unit, isDuplicateRegistrationAllowed(), false)
+	if err != nil {
+		panic(fmt.Errorf("BUG: %w", err))
+	}
+	return mRegistered
+}
+
+// tryRegisterMetric registers m with the given name and help in s and returns the registered metric.
+//
+// If allowDuplicate is true and s already contains metric with the given name compatible with m,
+// then the existing metric is returned. Otherwise an error is returned if name is invalid or if it is already registered in s.
+//
+// An error is returned if the limit set via SetMaxMetrics is reached.
+func (s *Set) tryRegisterMetric(name string, m metric, help string, allowDuplicate bool) (metric, error) {
+	return s.tryRegisterMetricExt(name, m, help, "", allowDuplicate, true)
+}
+
+// tryRegisterMetricExt works like tryRegisterMetric, but the limit set via SetMaxMetrics is checked only if checkLimit is true.
+//
+// unit is an optional unit for the metric. See SetStrictUnitNames.
+func (s *Set) tryRegisterMetricExt(name string, m metric, help, unit string, allowDuplicate, checkLimit bool) (metric, error) {
+	nameRaw := name
+	nameNormalized, err := s.normalizeMetricName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metric name %q: %w", name, err)
+	}
+	name = nameNormalized
+	if err := validateUnit(name, m.metricType(), unit); err != nil {
+		return nil, fmt.Errorf("invalid unit for metric %q: %w", name, err)
 	}
+
 	s.mu.Lock()
-	// defer will unlock in case of panic
-	// checks in test
 	defer s.mu.Unlock()
+
+	if nm, ok := s.m[name]; ok && allowDuplicate && !nm.isAux && isSameMetricType(nm.metric, m) {
+		if err := checkCompatibleMetric(nm.metric, m); err != nil {
+			return nil, fmt.Errorf("metric %q is already registered with distinct params: %w", name, err)
+		}
+		s.addAliasLocked(nameRaw, nm)
+		return nm.metric, nil
+	}
+	if err := s.checkRegisterLocked(name, m); err != nil {
+		return nil, err
+	}
+	if checkLimit {
+		if err := s.checkMetricsLimitLocked(name); err != nil {
+			return nil, err
+		}
+	}
 	s.mustRegisterLocked(name, m, false)
+	nm := s.m[name]
+	nm.help = help
+	nm.unit = unit
+	s.addAliasLocked(nameRaw, nm)
+	if sm, ok := m.(*Summary); ok {
+		registerSummaryLocked(sm)
+		s.registerSummaryQuantilesLocked(name, sm)
+		s.summaries = append(s.summaries, sm)
+	}
+	return m, nil
+}
+
+// checkRegisterLocked returns an error if m cannot be registered in s under the given name.
+func (s *Set) checkRegisterLocked(name string, m metric) error {
+	if nm, ok := s.m[name]; ok {
+		if !isSameMetricType(nm.metric, m) {
+			return fmt.Errorf("metric %q is already registered with type %T; cannot register it with type %T", name, exportMetric(nm.metric), exportMetric(m))
+		}
+		return fmt.Errorf("metric %q is already registered", name)
+	}
+	if sm, ok := m.(*Summary); ok {
+		for _, q := range sm.quantiles {
+			quantileValueName := addTag(name, fmt.Sprintf(`quantile="%g"`, q))
+			if _, ok := s.m[quantileValueName]; ok {
+				return fmt.Errorf("metric %q for summary %q is already registered", quantileValueName, name)
+			}
+		}
+	}
+	return nil
+}
+
+// isSameMetricType returns true if a and b have the same type.
+//
+// Custom metrics are compared by the types passed to RegisterMetric.
+func isSameMetricType(a, b metric) bool {
+	return reflect.TypeOf(exportMetric(a)) == reflect.TypeOf(exportMetric(b))
 }
 
 // mustRegisterLocked registers given metric with the given name.
@@ -456,7 +965,8 @@ func (s *Set) mustRegisterLocked(name string, m metric, isAux bool) {
 			isAux:  isAux,
 		}
 		s.m[name] = nm
-		s.a = append(s.a, nm)
+		s.appendMetricLocked(nm)
+		s.attachStatsDLocked(nm)
 	}
 	if ok {
 		panic(fmt.Errorf("BUG: metric %q is already registered", name))
@@ -471,8 +981,8 @@ func (s *Set) UnregisterMetric(name string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	nm, ok := s.m[name]
-	if !ok {
+	nm := s.getNamedMetricLocked(name)
+	if nm == nil {
 		return false
 	}
 	if nm.isAux {
@@ -486,6 +996,8 @@ func (s *Set) UnregisterMetric(name string) bool {
 func (s *Set) unregisterMetricLocked(nm *namedMetric) bool {
 	name := nm.name
 	delete(s.m, name)
+	s.deleteAliasesLocked(nm)
+	s.deletePairsEntryLocked(nm)
 
 	deleteFromList := func(metricName string) {
 		for i, nm := range s.a {
@@ -499,6 +1011,7 @@ func (s *Set) unregisterMetricLocked(nm *namedMetric) bool {
 
 	// remove metric from s.a
 	deleteFromList(name)
+	s.metricsCount--
 
 	sm, ok := nm.metric.(*Summary)
 	if !ok {
@@ -531,7 +1044,7 @@ func (s *Set) unregisterMetricLocked(nm *namedMetric) bool {
 
 // UnregisterAllMetrics de-registers all metrics registered in s.
 //
-// It also de-registers writeMetrics callbacks passed to RegisterMetricsWriter.
+// It also de-registers writeMetrics callbacks passed to RegisterMetricsWriter and hooks passed to RegisterPreWriteHook.
 func (s *Set) UnregisterAllMetrics() {
 	metricNames := s.ListMetricNames()
 	for _, name := range metricNames {
@@ -540,36 +1053,211 @@ func (s *Set) UnregisterAllMetrics() {
 
 	s.mu.Lock()
 	s.metricsWriters = nil
+	s.preWriteHooks = nil
+	s.dbStats = nil
 	s.mu.Unlock()
 }
 
 // ListMetricNames returns sorted list of all the metrics in s.
 //
 // The returned list doesn't include metrics generated by metricsWriter passed to RegisterMetricsWriter.
+// The returned list is a copy, so it may be modified by the caller.
 func (s *Set) ListMetricNames() []string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
 	metricNames := make([]string, 0, len(s.m))
-	for _, nm := range s.m {
+	for _, nm := range s.a {
 		if nm.isAux {
 			continue
 		}
 		metricNames = append(metricNames, nm.name)
 	}
-	sort.Strings(metricNames)
+	s.mu.RUnlock()
+
+	// Sort the names outside the lock. s.a is usually already sorted by the previous WritePrometheus call,
+	// so the sorting is skipped in this case.
+	if !sort.StringsAreSorted(metricNames) {
+		sort.Strings(metricNames)
+	}
 	return metricNames
 }
 
+// GetMetric returns the metric with the given name from s.
+//
+// false is returned if the metric with the given name isn't registered in s.
+// Auxiliary metrics such as summary quantiles aren't returned.
+func (s *Set) GetMetric(name string) (Metric, bool) {
+	s.mu.RLock()
+	nm := s.getNamedMetricLocked(name)
+	s.mu.RUnlock()
+	if nm == nil || nm.isAux {
+		return nil, false
+	}
+	return exportMetric(nm.metric), true
+}
+
+// VisitMetrics calls fn for every metric registered in s.
+//
+// See VisitMetrics for details.
+func (s *Set) VisitMetrics(fn func(name string, m Metric) bool) {
+	s.mu.Lock()
+	s.sortMetricsLocked()
+	sa := make([]*namedMetric, 0, len(s.a))
+	for _, nm := range s.a {
+		if !nm.isAux {
+			sa = append(sa, nm)
+		}
+	}
+	s.mu.Unlock()
+
+	// Call fn outside the lock, so it may call s methods.
+	for _, nm := range sa {
+		if !fn(nm.name, exportMetric(nm.metric)) {
+			return
+		}
+	}
+}
+
+// GetMetricValue returns the current value for the metric with the given name from s.
+//
+// See GetMetricValue for details.
+func (s *Set) GetMetricValue(name string) (float64, bool) {
+	m, ok := s.GetMetric(name)
+	if !ok {
+		return 0, false
+	}
+	// Read the value outside the lock, since Gauge callback may call s methods.
+	switch t := m.(type) {
+	case *Counter:
+		return float64(t.Get()), true
+	case *ShardedCounter:
+		return float64(t.Get()), true
+	case *FloatCounter:
+		return t.Get(), true
+	case *Gauge:
+		return t.Get(), true
+	case *MaxGauge:
+		return t.Get(), true
+	case *MinGauge:
+		return t.Get(), true
+	case *RateGauge:
+		return t.Get(), true
+	default:
+		return 0, false
+	}
+}
+
+// SetMetricTimestamp attaches the given timestamp ts to the metric with the given name from s.
+//
+// See SetMetricTimestamp for details.
+func (s *Set) SetMetricTimestamp(name string, ts time.Time) error {
+	m, ok := s.GetMetric(name)
+	if !ok {
+		return fmt.Errorf("missing metric %q", name)
+	}
+	tm, ok := m.(timestampedMetric)
+	if !ok {
+		return fmt.Errorf("metric %q of type %T doesn't support timestamps", name, m)
+	}
+	tm.getTimestamp().store(ts)
+	return nil
+}
+
 // RegisterMetricsWriter registers writeMetrics callback for including metrics in the output generated by s.WritePrometheus.
 //
 // The writeMetrics callback must write metrics to w in Prometheus text exposition format without timestamps and trailing comments.
-// The last line generated by writeMetrics must end with \n.
 // See https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md#text-based-format
 //
-// It is OK to reguster multiple writeMetrics callbacks - all of them will be called sequentially for gererating the output at s.WritePrometheus.
-func (s *Set) RegisterMetricsWriter(writeMetrics func(w io.Writer)) {
+// It is OK to register multiple writeMetrics callbacks - all of them will be called sequentially in the registration order
+// for generating the output at s.WritePrometheus.
+//
+// Panics in writeMetrics are recovered and logged, so they do not break the output for other metrics.
+// The trailing newline is added to the output of writeMetrics if it is missing.
+//
+// Call Close on the returned MetricsWriter for unregistering writeMetrics.
+func (s *Set) RegisterMetricsWriter(writeMetrics func(w io.Writer)) *MetricsWriter {
+	mw := &MetricsWriter{
+		s:            s,
+		writeMetrics: writeMetrics,
+	}
+	s.registerMetricsWriter(mw)
+	return mw
+}
+
+func (s *Set) registerMetricsWriter(mw *MetricsWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metricsWriters = append(s.metricsWriters, mw)
+}
+
+// MetricsWriter is a writeMetrics callback registered via RegisterMetricsWriter.
+type MetricsWriter struct {
+	s            *Set
+	writeMetrics func(w io.Writer)
+
+	// writeMetricsWithDetector is used instead of writeMetrics if it is set. It must skip series already seen by dd.
+	//
+	// dd may be nil if duplicates aren't detected for the output.
+	writeMetricsWithDetector func(w io.Writer, dd *duplicateDetector)
+}
+
+// Close unregisters mw, so it is no longer called for generating the output.
+func (mw *MetricsWriter) Close() {
+	s := mw.s
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.metricsWriters = append(s.metricsWriters, writeMetrics)
+	// Create new slice instead of modifying the existing one in place,
+	// since it may be in use by concurrent s.getSortedMetrics callers.
+	metricsWriters := make([]*MetricsWriter, 0, len(s.metricsWriters))
+	for _, x := range s.metricsWriters {
+		if x != mw {
+			metricsWriters = append(metricsWriters, x)
+		}
+	}
+	s.metricsWriters = metricsWriters
+}
+
+// write calls mw.writeMetrics for w.
+//
+// It recovers panics in mw.writeMetrics and makes sure the output ends with newline.
+func (mw *MetricsWriter) write(w io.Writer) {
+	mw.writeWithDetector(w, nil)
+}
+
+// writeWithDetector calls mw.writeMetrics for w in the same way as write does, while detecting duplicate series with dd if possible.
+//
+// dd may be nil.
+func (mw *MetricsWriter) writeWithDetector(w io.Writer, dd *duplicateDetector) {
+	lw := &lastByteWriter{
+		w: w,
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: metrics: panic in the callback passed to RegisterMetricsWriter: %v", r)
+		}
+		if lw.hasData && lw.lastByte != '\n' {
+			w.Write([]byte("\n"))
+		}
+	}()
+	if mw.writeMetricsWithDetector != nil {
+		mw.writeMetricsWithDetector(lw, dd)
+		return
+	}
+	mw.writeMetrics(lw)
+}
+
+// lastByteWriter remembers the last byte written to w.
+type lastByteWriter struct {
+	w        io.Writer
+	hasData  bool
+	lastByte byte
+}
+
+func (lw *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		lw.hasData = true
+		lw.lastByte = p[len(p)-1]
+	}
+	return lw.w.Write(p)
 }
@@ -1,7 +1,10 @@
 package metrics
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -37,6 +40,51 @@ func TestNewSet(t *testing.T) {
 	}
 }
 
+func TestSetsWithSameMetricNames(t *testing.T) {
+	s1 := NewSet()
+	s2 := NewSet()
+	s1.NewCounter(`foo{bar="baz"}`).Add(1)
+	s2.NewCounter(`foo{bar="baz"}`).Add(2)
+
+	f := func(s *Set, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f(s1, `foo{bar="baz"} 1`+"\n")
+	f(s2, `foo{bar="baz"} 2`+"\n")
+
+	if !s1.UnregisterMetric(`foo{bar="baz"}`) {
+		t.Fatalf("UnregisterMetric must return true")
+	}
+	f(s1, "")
+	f(s2, `foo{bar="baz"} 2`+"\n")
+}
+
+func TestSetListMetricNamesSorted(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`foo{bar="b"}`)
+	s.NewCounter("bar")
+	s.NewSummary(`foo{bar="a"}`)
+	s.NewCounter("aaa")
+
+	list := s.ListMetricNames()
+	listExpected := []string{"aaa", "bar", `foo{bar="a"}`, `foo{bar="b"}`}
+	if !reflect.DeepEqual(list, listExpected) {
+		t.Fatalf("unexpected metric names; got %q; want %q", list, listExpected)
+	}
+
+	// Modifications of the returned list mustn't affect s.
+	list[0] = "modified"
+	list = s.ListMetricNames()
+	if !reflect.DeepEqual(list, listExpected) {
+		t.Fatalf("unexpected metric names after modifying the returned list; got %q; want %q", list, listExpected)
+	}
+}
+
 func TestSetListMetricNames(t *testing.T) {
 	s := NewSet()
 	expect := []string{"cnt1", "cnt2", "cnt3"}
@@ -64,6 +112,56 @@ func TestSetListMetricNames(t *testing.T) {
 	}
 }
 
+func TestSetVisitMetrics(t *testing.T) {
+	s := NewSet()
+	c := s.NewCounter(`foo{bar="b"}`)
+	s.NewCounter("bar")
+	s.NewSummary(`foo{bar="a"}`)
+	s.NewCounter("aaa")
+
+	var names []string
+	s.VisitMetrics(func(name string, m Metric) bool {
+		names = append(names, name)
+		if name == `foo{bar="b"}` && m != Metric(c) {
+			t.Fatalf("unexpected metric for %q; got %T; want %T", name, m, c)
+		}
+		return true
+	})
+	namesExpected := []string{"aaa", "bar", `foo{bar="a"}`, `foo{bar="b"}`}
+	if !reflect.DeepEqual(names, namesExpected) {
+		t.Fatalf("unexpected metric names; got %q; want %q", names, namesExpected)
+	}
+
+	// The iteration must stop when fn returns false.
+	names = names[:0]
+	s.VisitMetrics(func(name string, m Metric) bool {
+		names = append(names, name)
+		return len(names) < 2
+	})
+	namesExpected = []string{"aaa", "bar"}
+	if !reflect.DeepEqual(names, namesExpected) {
+		t.Fatalf("unexpected metric names after stopping; got %q; want %q", names, namesExpected)
+	}
+
+	// fn may register and unregister metrics.
+	names = names[:0]
+	s.VisitMetrics(func(name string, m Metric) bool {
+		names = append(names, name)
+		s.UnregisterMetric(name)
+		s.NewCounter("new_" + name)
+		return true
+	})
+	namesExpected = []string{"aaa", "bar", `foo{bar="a"}`, `foo{bar="b"}`}
+	if !reflect.DeepEqual(names, namesExpected) {
+		t.Fatalf("unexpected metric names on modification; got %q; want %q", names, namesExpected)
+	}
+	list := s.ListMetricNames()
+	listExpected := []string{"new_aaa", "new_bar", `new_foo{bar="a"}`, `new_foo{bar="b"}`}
+	if !reflect.DeepEqual(list, listExpected) {
+		t.Fatalf("unexpected metric names after modification; got %q; want %q", list, listExpected)
+	}
+}
+
 func TestSetUnregisterAllMetrics(t *testing.T) {
 	s := NewSet()
 	for j := 0; j < 3; j++ {
@@ -171,3 +269,129 @@ func TestRegisterUnregister(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// TestUnregisterWhileWriting verifies that metrics can be unregistered and re-created
+// with the same name while WritePrometheus is called concurrently.
+// Should be tested specifically with `-race` enabled.
+func TestUnregisterWhileWriting(t *testing.T) {
+	s := NewSet()
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				s.WritePrometheus(io.Discard)
+			}
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		s.GetOrCreateCounter("counter").Inc()
+		s.GetOrCreateSummary("summary").Update(float64(i))
+		if !s.UnregisterMetric("counter") {
+			t.Fatalf("UnregisterMetric(counter) must return true")
+		}
+		if !s.UnregisterMetric("summary") {
+			t.Fatalf("UnregisterMetric(summary) must return true")
+		}
+	}
+	close(stopCh)
+	<-doneCh
+
+	if s.UnregisterMetric("counter") {
+		t.Fatalf("UnregisterMetric(counter) must return false for missing metric")
+	}
+	if len(s.summaries) != 0 {
+		t.Fatalf("unexpected number of registered summaries; got %d; want 0", len(s.summaries))
+	}
+}
+
+func TestSetMetricsHelp(t *testing.T) {
+	s := NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: `requests_total{path="/foo"}`,
+		Help: "The number of requests",
+	}).Inc()
+	s.NewCounter(`requests_total{path="/bar"}`).Add(2)
+	s.NewGaugeOpt(GaugeOpts{
+		Name: "queue_size",
+		Help: "Queue size\nwith \\escaped chars",
+	}, func() float64 { return 10 })
+	s.NewHistogramOpt(HistogramOpts{
+		Name: "request_duration_seconds",
+		Help: "Request duration",
+	}).Update(1)
+	s.NewSummaryOpt(SummaryOpts{
+		Name:      "response_size_bytes",
+		Help:      "Response size",
+		Quantiles: []float64{0.5},
+	}).Update(1)
+	s.NewCounter("no_help_total").Inc()
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `no_help_total 1
+# HELP queue_size Queue size\nwith \\escaped chars
+# TYPE queue_size gauge
+queue_size 10
+# HELP request_duration_seconds Request duration
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{vmrange="8.799e-01...1.000e+00"} 1
+request_duration_seconds_sum 1
+request_duration_seconds_count 1
+# HELP requests_total The number of requests
+# TYPE requests_total counter
+requests_total{path="/bar"} 2
+requests_total{path="/foo"} 1
+# HELP response_size_bytes Response size
+# TYPE response_size_bytes summary
+response_size_bytes_sum 1
+response_size_bytes_count 1
+response_size_bytes{quantile="0.5"} 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestSetGetMetricValue(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`counter{a="b"}`).Add(10)
+	s.NewFloatCounter("float_counter").Add(1.5)
+	s.NewGauge("gauge_func", func() float64 { return 42 })
+	s.NewHistogram("histogram").Update(1)
+	s.NewSummary("summary").Update(1)
+
+	f := func(name string, valueExpected float64, okExpected bool) {
+		t.Helper()
+		value, ok := s.GetMetricValue(name)
+		if ok != okExpected {
+			t.Fatalf("unexpected ok for %q; got %v; want %v", name, ok, okExpected)
+		}
+		if value != valueExpected {
+			t.Fatalf("unexpected value for %q; got %v; want %v", name, value, valueExpected)
+		}
+	}
+	f(`counter{a="b"}`, 10, true)
+	f("float_counter", 1.5, true)
+	f("gauge_func", 42, true)
+	f("histogram", 0, false)
+	f("summary", 0, false)
+	f(`summary{quantile="0.5"}`, 0, false)
+	f("missing", 0, false)
+
+	m, ok := s.GetMetric(`counter{a="b"}`)
+	if !ok {
+		t.Fatalf("cannot find the registered counter")
+	}
+	if c, ok := m.(*Counter); !ok || c.Get() != 10 {
+		t.Fatalf("unexpected metric returned: %#v", m)
+	}
+	if _, ok := s.GetMetric(`summary{quantile="0.5"}`); ok {
+		t.Fatalf("auxiliary metrics mustn't be returned")
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func BenchmarkWritePrometheus(b *testing.B) {
+	b.Run("counters", func(b *testing.B) {
+		s := NewSet()
+		for i := 0; i < 10000; i++ {
+			s.NewCounter(fmt.Sprintf(`counter_%d{job="foo",instance="bar"}`, i)).Add(i)
+		}
+		benchmarkSetWritePrometheus(b, s)
+	})
+	b.Run("gauges", func(b *testing.B) {
+		s := NewSet()
+		for i := 0; i < 10000; i++ {
+			s.NewGauge(fmt.Sprintf(`gauge_%d{job="foo",instance="bar"}`, i), nil).Set(float64(i) / 3)
+		}
+		benchmarkSetWritePrometheus(b, s)
+	})
+	b.Run("histograms", func(b *testing.B) {
+		s := NewSet()
+		for i := 0; i < 100; i++ {
+			h := s.NewHistogram(fmt.Sprintf(`histogram_%d{job="foo",instance="bar"}`, i))
+			for j := 0; j < 100; j++ {
+				h.Update(float64(j))
+			}
+		}
+		benchmarkSetWritePrometheus(b, s)
+	})
+}
+
+func benchmarkSetWritePrometheus(b *testing.B, s *Set) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.WritePrometheus(io.Discard)
+	}
+}
+
+func BenchmarkSetGetOrCreateExisting(b *testing.B) {
+	const n = 1000
+	s := NewSet()
+	counterNames := make([]string, n)
+	gaugeNames := make([]string, n)
+	histogramNames := make([]string, n)
+	for i := 0; i < n; i++ {
+		counterNames[i] = fmt.Sprintf(`requests_total{path="/foo/%d"}`, i)
+		gaugeNames[i] = fmt.Sprintf(`queue_size{path="/foo/%d"}`, i)
+		histogramNames[i] = fmt.Sprintf(`duration_seconds{path="/foo/%d"}`, i)
+		s.NewCounter(counterNames[i])
+		s.NewGauge(gaugeNames[i], nil)
+		s.NewHistogram(histogramNames[i])
+	}
+	b.Run("counter", func(b *testing.B) {
+		benchmarkSetGetOrCreateExisting(b, n, func(i int) {
+			s.GetOrCreateCounter(counterNames[i])
+		})
+	})
+	b.Run("gauge", func(b *testing.B) {
+		benchmarkSetGetOrCreateExisting(b, n, func(i int) {
+			s.GetOrCreateGauge(gaugeNames[i], nil)
+		})
+	})
+	b.Run("histogram", func(b *testing.B) {
+		benchmarkSetGetOrCreateExisting(b, n, func(i int) {
+			s.GetOrCreateHistogram(histogramNames[i])
+		})
+	})
+	b.Run("counter_with_ttl", func(b *testing.B) {
+		benchmarkSetGetOrCreateExisting(b, n, func(i int) {
+			s.GetOrCreateCounterWithTTL(counterNames[i], time.Hour)
+		})
+	})
+}
+
+// benchmarkSetGetOrCreateExisting calls f for n existing metrics from concurrent goroutines.
+//
+// Run it with -cpu=1,8,32 for measuring the lock contention.
+func benchmarkSetGetOrCreateExisting(b *testing.B, n int, f func(i int)) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			f(i % n)
+			i++
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// WriteSets writes metrics from the given sets and their metrics writers to w in Prometheus text exposition format.
+//
+// Sets are written in the given order. Metrics sorting across the sets is controlled by SetSortMetricsOnWrite.
+//
+// Series with the same names registered in multiple sets or written by external sources are written only once - the first series is kept,
+// while the rest of series are dropped and are counted by the metrics_duplicate_series_total{source="..."} counter.
+// The counter is written after the metrics if it has non-zero value. Names of the dropped series are returned by GetDuplicateSeries.
+// Duplicate series aren't detected in the output of metrics writers registered via RegisterMetricsWriter except of external sources.
+// See SetStrictDuplicateSeries for failing the write on duplicate series.
+//
+// See also WritePrometheus, which writes the default set and all the sets registered via RegisterSet.
+func WriteSets(w io.Writer, sets ...*Set) {
+	writePrometheusSets(w, sets, nil)
+}
+
+// writePrometheusSets writes metrics matching mf from sets to w, while dropping duplicate series across the sets.
+//
+// All the metrics are written if mf is nil.
+func writePrometheusSets(w io.Writer, sets []*Set, mf *metricNameFilter) {
+	if !isRegistryMetricsExposed() {
+		writePrometheusSetsInternal(w, sets, mf)
+		return
+	}
+	startTime := time.Now()
+	cw := &countingWriter{
+		w: w,
+	}
+	writePrometheusSetsInternal(cw, sets, mf)
+	if cw.err == nil {
+		writeRegistryMetrics(cw, sets, mf)
+	} else {
+		// Pass the error for duplicate series in strict mode to the caller.
+		setWriteError(w, cw.err)
+	}
+	registryWriteDuration.UpdateDuration(startTime)
+	atomic.StoreUint64(&registryWriteBytes, cw.n)
+}
+
+func writePrometheusSetsInternal(w io.Writer, sets []*Set, mf *metricNameFilter) {
+	runPreWriteHooks(sets)
+	dd := getDuplicateDetector()
+	defer putDuplicateDetector(dd)
+	if isSortMetricsOnWriteEnabled() {
+		writePrometheusSorted(w, sets, mf, dd)
+	} else if len(sets) == 1 {
+		// Fast path - a single set cannot contain duplicate series, so its metrics aren't tracked by dd.
+		// External sources detect duplicates of the registered metrics by looking them up in the set.
+		sa, metricsWriters := sets[0].getSortedMetrics()
+		writePrometheusMetrics(w, sa, metricsWriters, mf, dd)
+	} else {
+		for _, s := range sets {
+			sa, metricsWriters := s.getSortedMetrics()
+			sa = dropSeenMetrics(sa, dd)
+			if dd.err != nil {
+				break
+			}
+			writePrometheusMetrics(w, sa, metricsWriters, mf, dd)
+			if getWriteError(w) != nil || dd.err != nil {
+				break
+			}
+		}
+	}
+	if dd.err != nil {
+		log.Printf("ERROR: metrics: stop writing metrics: %s", dd.err)
+		setWriteError(w, dd.err)
+		return
+	}
+	if getWriteError(w) != nil {
+		return
+	}
+	writeDuplicateSeriesTotals(w, mf)
+	if n := atomic.LoadUint64(&relabelDuplicateSeriesTotal); n > 0 && mf.match(relabelDuplicateSeriesTotalName) {
+		WriteCounterUint64(w, relabelDuplicateSeriesTotalName, n)
+	}
+	writeMetricsDroppedDueToLimit(w, mf)
+}
+
+// dropSeenMetrics removes metrics already seen by dd from sa.
+//
+// The removed metrics are recorded as duplicates. sa is modified in place.
+func dropSeenMetrics(sa []*namedMetric, dd *duplicateDetector) []*namedMetric {
+	dst := sa[:0]
+	for _, nm := range sa {
+		if dd.isDuplicate(duplicateSourceSet, nm.name) {
+			if dd.err != nil {
+				return dst
+			}
+			continue
+		}
+		dst = append(dst, nm)
+	}
+	return dst
+}
+
+// dropDuplicateMetrics removes metrics with the same series as the previous metrics in sa.
+//
+// Series with the same labels in distinct order are considered duplicates.
+// The removed metrics are recorded as duplicates. sa is modified in place.
+func dropDuplicateMetrics(sa []*namedMetric) []*namedMetric {
+	dd := getDuplicateDetector()
+	defer putDuplicateDetector(dd)
+	dst := sa[:0]
+	for _, nm := range sa {
+		// Strict mode isn't applied to the merged metrics, so dd.err is ignored.
+		if dd.isDuplicate(duplicateSourceSet, nm.name) {
+			continue
+		}
+		dst = append(dst, nm)
+	}
+	return dst
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteSets(t *testing.T) {
+	s1 := NewSet()
+	s1.NewCounter("storage_rows_total").Add(10)
+	s1.NewCounter(`requests_total{path="/a"}`).Add(1)
+	s1.RegisterMetricsWriter(func(w io.Writer) {
+		fmt.Fprintf(w, "storage_writer 1\n")
+	})
+
+	s2 := NewSet()
+	s2.NewCounter("http_requests_total").Add(20)
+	s2.NewCounter(`requests_total{path="/a"}`).Add(2)
+	s2.NewCounter(`requests_total{path="/b"}`).Add(3)
+
+	f := func(sort bool, resultExpected string, duplicatesExpected uint64) {
+		t.Helper()
+		SetSortMetricsOnWrite(sort)
+		defer SetSortMetricsOnWrite(false)
+
+		duplicatesPrev := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceSet])
+		var bb bytes.Buffer
+		WriteSets(&bb, s1, s2)
+		duplicates := atomic.LoadUint64(&duplicateSeriesTotals[duplicateSourceSet]) - duplicatesPrev
+		if duplicates != duplicatesExpected {
+			t.Fatalf("unexpected number of duplicate series; got %d; want %d", duplicates, duplicatesExpected)
+		}
+		// The counters for other sources may be written after the counter for sets, since they are updated by other tests.
+		resultExpected += fmt.Sprintf("%s{source=\"set\"} %d\n", duplicateSeriesTotalName, duplicatesPrev+duplicates)
+		if result := bb.String(); !strings.HasPrefix(result, resultExpected) {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// The series from the first set is kept for duplicate names.
+	f(false, `requests_total{path="/a"} 1
+storage_rows_total 10
+storage_writer 1
+http_requests_total 20
+requests_total{path="/b"} 3
+`, 1)
+	f(true, `http_requests_total 20
+requests_total{path="/a"} 1
+requests_total{path="/b"} 3
+storage_rows_total 10
+storage_writer 1
+`, 1)
+
+	// The order of sets defines the kept series.
+	SetSortMetricsOnWrite(true)
+	var bb bytes.Buffer
+	WriteSets(&bb, s2, s1)
+	SetSortMetricsOnWrite(false)
+	if !bytes.Contains(bb.Bytes(), []byte(`requests_total{path="/a"} 2`+"\n")) {
+		t.Fatalf("missing series from the first set in the output\n%s", bb.String())
+	}
+
+	// A single set is written as is.
+	bb.Reset()
+	WriteSets(&bb, s2)
+	if bytes.Contains(bb.Bytes(), []byte(`storage_rows_total`)) {
+		t.Fatalf("unexpected series from s1 in the output\n%s", bb.String())
+	}
+}
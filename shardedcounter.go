@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"io"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/valyala/fastrand"
+)
+
+// NewShardedCounter registers and returns new sharded counter with the given name.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// The returned counter is safe to use from concurrent goroutines.
+func NewShardedCounter(name string) *ShardedCounter {
+	return defaultSet.NewShardedCounter(name)
+}
+
+// GetOrCreateShardedCounter returns registered sharded counter with the given name
+// or creates new sharded counter if the registry doesn't contain counter with the given name.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// For instance,
+//
+//   - foo
+//   - foo{bar="baz"}
+//   - foo{bar="baz",aaa="b"}
+//
+// The returned counter is safe to use from concurrent goroutines.
+//
+// Performance tip: prefer NewShardedCounter instead of GetOrCreateShardedCounter.
+func GetOrCreateShardedCounter(name string) *ShardedCounter {
+	return defaultSet.GetOrCreateShardedCounter(name)
+}
+
+// ShardedCounter is a counter optimized for frequent updates from many concurrently running goroutines.
+//
+// Updates are spread among multiple shards located in distinct CPU cache lines,
+// so concurrent updates do not contend on a single memory location as in Counter.
+// The shards are summed when the counter value is read. So ShardedCounter is slower than Counter
+// for reading and it occupies more memory. Use Counter unless profiles show contention on it.
+//
+// The value returned by Get never decreases across calls if only Inc and Add with non-negative values are called.
+//
+// Zero ShardedCounter is usable, but it has a single shard, so it doesn't reduce contention.
+// Use NewShardedCounter or GetOrCreateShardedCounter for obtaining counter with multiple shards.
+type ShardedCounter struct {
+	// single is the shard for zero ShardedCounter. It is the first field, so it is 64-bit aligned on 32-bit platforms.
+	single [1]shardedCounterCell
+
+	// shards contains the shards for ShardedCounter created via newShardedCounter.
+	shards []shardedCounterCell
+
+	statsd statsdMirror
+}
+
+// shardedCounterCell is a counter shard padded to CPU cache line size in order to avoid false sharing.
+type shardedCounterCell struct {
+	n uint64
+
+	_ [cacheLineSize - 8]byte
+}
+
+// cacheLineSize is the typical CPU cache line size. Some CPUs prefetch adjacent cache lines, so use 128 bytes.
+const cacheLineSize = 128
+
+// maxShardedCounterShards limits memory usage per ShardedCounter.
+const maxShardedCounterShards = 64
+
+func newShardedCounter() *ShardedCounter {
+	n := 1
+	for n < runtime.GOMAXPROCS(0) && n < maxShardedCounterShards {
+		n *= 2
+	}
+	return &ShardedCounter{
+		shards: make([]shardedCounterCell, n),
+	}
+}
+
+// getShards returns shards for sc.
+func (sc *ShardedCounter) getShards() []shardedCounterCell {
+	if sc.shards == nil {
+		return sc.single[:]
+	}
+	return sc.shards
+}
+
+func (sc *ShardedCounter) getShard() *uint64 {
+	shards := sc.getShards()
+	if len(shards) == 1 {
+		return &shards[0].n
+	}
+	idx := fastrand.Uint32n(uint32(len(shards)))
+	return &shards[idx].n
+}
+
+// Inc increments sc.
+func (sc *ShardedCounter) Inc() {
+	atomic.AddUint64(sc.getShard(), 1)
+	if ss := sc.statsd.load(); ss != nil {
+		ss.sendInt(1, "c")
+	}
+}
+
+// Dec decrements sc.
+func (sc *ShardedCounter) Dec() {
+	atomic.AddUint64(sc.getShard(), ^uint64(0))
+	if ss := sc.statsd.load(); ss != nil {
+		ss.sendInt(-1, "c")
+	}
+}
+
+// Add adds n to sc.
+func (sc *ShardedCounter) Add(n int) {
+	atomic.AddUint64(sc.getShard(), uint64(n))
+	if ss := sc.statsd.load(); ss != nil {
+		ss.sendInt(int64(n), "c")
+	}
+}
+
+// AddInt64 adds n to sc.
+func (sc *ShardedCounter) AddInt64(n int64) {
+	atomic.AddUint64(sc.getShard(), uint64(n))
+	if ss := sc.statsd.load(); ss != nil {
+		ss.sendInt(n, "c")
+	}
+}
+
+// Get returns the current value for sc.
+//
+// The returned value may miss updates performed concurrently with Get.
+func (sc *ShardedCounter) Get() uint64 {
+	shards := sc.getShards()
+	n := uint64(0)
+	for i := range shards {
+		n += atomic.LoadUint64(&shards[i].n)
+	}
+	return n
+}
+
+// Set sets sc value to n.
+//
+// The value is stored in the first shard, while the remaining shards are reset to zero.
+// Updates performed concurrently with Set may be lost.
+func (sc *ShardedCounter) Set(n uint64) {
+	shards := sc.getShards()
+	atomic.StoreUint64(&shards[0].n, n)
+	for i := 1; i < len(shards); i++ {
+		atomic.StoreUint64(&shards[i].n, 0)
+	}
+	if ss := sc.statsd.load(); ss != nil {
+		ss.sendUint(n, "g")
+	}
+}
+
+// MarshalTo writes sc with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (sc *ShardedCounter) MarshalTo(prefix string, w io.Writer) {
+	sc.marshalTo(prefix, w)
+}
+
+func (sc *ShardedCounter) marshalTo(prefix string, w io.Writer) {
+	v := sc.Get()
+	writeSampleUint64(w, prefix, v, nil)
+}
+
+func (sc *ShardedCounter) metricType() string {
+	return "counter"
+}
+
+func (sc *ShardedCounter) getStatsDMirror(_ bool) *statsdMirror {
+	return &sc.statsd
+}
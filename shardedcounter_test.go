@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestShardedCounterSerial(t *testing.T) {
+	name := "ShardedCounterSerial"
+	c := NewShardedCounter(name)
+	c.Inc()
+	if n := c.Get(); n != 1 {
+		t.Fatalf("unexpected counter value; got %d; want 1", n)
+	}
+	c.Set(123)
+	if n := c.Get(); n != 123 {
+		t.Fatalf("unexpected counter value; got %d; want 123", n)
+	}
+	c.Dec()
+	if n := c.Get(); n != 122 {
+		t.Fatalf("unexpected counter value; got %d; want 122", n)
+	}
+	c.Add(3)
+	if n := c.Get(); n != 125 {
+		t.Fatalf("unexpected counter value; got %d; want 125", n)
+	}
+	c.AddInt64(-5)
+	if n := c.Get(); n != 120 {
+		t.Fatalf("unexpected counter value; got %d; want 120", n)
+	}
+
+	// Verify MarshalTo
+	testMarshalTo(t, c, "foobar", "foobar 120\n")
+}
+
+func TestShardedCounterSetCollapsesShards(t *testing.T) {
+	c := NewSet().NewShardedCounter("foo")
+	for i := 0; i < 1000; i++ {
+		c.Inc()
+	}
+	c.Set(10)
+	for i := 1; i < len(c.shards); i++ {
+		if n := c.shards[i].n; n != 0 {
+			t.Fatalf("unexpected value at shard #%d; got %d; want 0", i, n)
+		}
+	}
+	if n := c.Get(); n != 10 {
+		t.Fatalf("unexpected counter value; got %d; want 10", n)
+	}
+}
+
+func TestShardedCounterZeroValue(t *testing.T) {
+	var c ShardedCounter
+	if n := c.Get(); n != 0 {
+		t.Fatalf("unexpected counter value; got %d; want 0", n)
+	}
+	c.Inc()
+	c.Add(10)
+	c.Dec()
+	if n := c.Get(); n != 10 {
+		t.Fatalf("unexpected counter value; got %d; want 10", n)
+	}
+	c.Set(5)
+	if n := c.Get(); n != 5 {
+		t.Fatalf("unexpected counter value; got %d; want 5", n)
+	}
+	c.reset()
+	if n := c.Get(); n != 0 {
+		t.Fatalf("unexpected counter value after reset; got %d; want 0", n)
+	}
+}
+
+func TestShardedCounterConcurrent(t *testing.T) {
+	name := "ShardedCounterConcurrent"
+	c := NewShardedCounter(name)
+	err := testConcurrent(func() error {
+		nPrev := c.Get()
+		for i := 0; i < 10; i++ {
+			c.Inc()
+			if n := c.Get(); n <= nPrev {
+				return fmt.Errorf("counter value must be greater than %d; got %d", nPrev, n)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardedCounterGetMonotonic(t *testing.T) {
+	c := NewSet().NewShardedCounter("foo")
+	const workers = 8
+	const incsPerWorker = 10000
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < incsPerWorker; j++ {
+				c.Inc()
+			}
+		}()
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+
+	nPrev := uint64(0)
+	for {
+		select {
+		case <-doneCh:
+			if n := c.Get(); n != workers*incsPerWorker {
+				t.Fatalf("unexpected counter value; got %d; want %d", n, workers*incsPerWorker)
+			}
+			return
+		default:
+		}
+		n := c.Get()
+		if n < nPrev {
+			t.Fatalf("counter value mustn't decrease; got %d after %d", n, nPrev)
+		}
+		nPrev = n
+	}
+}
+
+func TestGetOrCreateShardedCounterSerial(t *testing.T) {
+	name := "GetOrCreateShardedCounterSerial"
+	if err := testGetOrCreateShardedCounter(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetOrCreateShardedCounterConcurrent(t *testing.T) {
+	name := "GetOrCreateShardedCounterConcurrent"
+	err := testConcurrent(func() error {
+		return testGetOrCreateShardedCounter(name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testGetOrCreateShardedCounter(name string) error {
+	c1 := GetOrCreateShardedCounter(name)
+	for i := 0; i < 10; i++ {
+		c2 := GetOrCreateShardedCounter(name)
+		if c1 != c2 {
+			return fmt.Errorf("unexpected counter returned; got %p; want %p", c2, c1)
+		}
+	}
+	return nil
+}
+
+func TestShardedCounterWritePrometheus(t *testing.T) {
+	s := NewSet()
+	c := s.NewShardedCounter(`foo{bar="baz"}`)
+	c.Add(42)
+	if v, ok := s.GetMetricValue(`foo{bar="baz"}`); !ok || v != 42 {
+		t.Fatalf("unexpected metric value; got %v, %v; want 42, true", v, ok)
+	}
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `foo{bar="baz"} 42` + "\n"
+	if result != resultExpected {
+		t.Fatalf("unexpected output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+func BenchmarkCounterVsShardedCounterInc(b *testing.B) {
+	for _, procs := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("GOMAXPROCS_%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			b.Run("Counter", func(b *testing.B) {
+				c := NewSet().NewCounter("foo")
+				b.ReportAllocs()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						c.Inc()
+					}
+				})
+			})
+			b.Run("ShardedCounter", func(b *testing.B) {
+				c := NewSet().NewShardedCounter("foo")
+				b.ReportAllocs()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						c.Inc()
+					}
+				})
+			})
+		})
+	}
+}
+
+func BenchmarkShardedCounterGet(b *testing.B) {
+	c := NewSet().NewShardedCounter("foo")
+	c.Add(123)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		n := uint64(0)
+		for pb.Next() {
+			n += c.Get()
+		}
+		atomic.AddUint64(&shardedCounterSink, n)
+	})
+}
+
+var shardedCounterSink uint64
@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+// SnapshotAndReset writes all the metrics from the default set and all the added sets to w
+// in Prometheus text exposition format and resets them.
+//
+// Counter, FloatCounter, Histogram and Summary are reset to zero right after reading their values,
+// so the written values contain deltas since the previous SnapshotAndReset call.
+// Every metric is read and reset atomically, so concurrent updates are never lost:
+// they are either included in the current snapshot or in the next one.
+// Other metric types such as Gauge are written as is.
+// Summary quantiles are calculated over the samples observed during the summary window,
+// while the window is cleared after the snapshot.
+//
+// Metrics written via RegisterMetricsWriter aren't included, since they cannot be reset.
+//
+// Do not use SnapshotAndReset for metrics scraped by Prometheus, since scrapes observe sawtooth values
+// instead of monotonically increasing counters in this case.
+func SnapshotAndReset(w io.Writer) {
+	for _, s := range getRegisteredSets() {
+		s.SnapshotAndReset(w)
+	}
+}
+
+// SnapshotAndReset writes all the metrics from s to w in Prometheus text exposition format and resets them.
+//
+// See SnapshotAndReset for details.
+func (s *Set) SnapshotAndReset(w io.Writer) {
+	var bb bytes.Buffer
+	sa, _ := s.getSortedMetrics()
+	for _, nm := range sa {
+		if nm.isAux {
+			// Auxiliary metrics such as summary quantiles are written by the parent metric.
+			continue
+		}
+		if rm, ok := nm.metric.(resettableMetric); ok {
+			rm.marshalAndResetTo(nm.name, &bb)
+		} else {
+			nm.metric.marshalTo(nm.name, &bb)
+		}
+	}
+	w.Write(bb.Bytes())
+}
+
+// resettableMetric must be implemented by metrics, which can be reset by SnapshotAndReset.
+type resettableMetric interface {
+	// marshalAndResetTo must atomically read the metric, reset it and marshal the read value
+	// with the given prefix to w.
+	marshalAndResetTo(prefix string, w io.Writer)
+}
+
+func (c *Counter) marshalAndResetTo(prefix string, w io.Writer) {
+	fmt.Fprintf(w, "%s %d\n", prefix, c.GetAndReset())
+}
+
+func (fc *FloatCounter) marshalAndResetTo(prefix string, w io.Writer) {
+	writeSampleGaugeValue(w, prefix, fc.GetAndReset(), nil, &fc.floatFormat)
+}
+
+func (h *Histogram) marshalAndResetTo(prefix string, w io.Writer) {
+	var buf histogramBuckets
+	sum := buf.moveFrom(h)
+	snapshot := &Histogram{
+		decimalBuckets: buf.decimalBuckets,
+		format:         atomic.LoadUint32(&h.format),
+		floatFormat:    atomic.LoadUint32(&h.floatFormat),
+	}
+	snapshot.lower.Store(buf.lower)
+	snapshot.upper.Store(buf.upper)
+	snapshot.sumBits.Store(math.Float64bits(sum))
+	snapshot.marshalTo(prefix, w)
+}
+
+func (sm *Summary) marshalAndResetTo(prefix string, w io.Writer) {
+	sm.mu.Lock()
+	quantileValues := sm.backend.quantiles(nil, sm.quantiles)
+	sum, count := sm.sumAndCountLocked()
+	sm.backend.reset()
+	sm.sum = 0
+	sm.count = 0
+	sm.sampledSum = 0
+	sm.sampledCount = 0
+	sm.mu.Unlock()
+
+	if count == 0 {
+		return
+	}
+	for i, q := range sm.quantiles {
+		name := addTag(prefix, fmt.Sprintf(`quantile="%g"`, q))
+		writeSampleGaugeValue(w, name, quantileValues[i], nil, &sm.floatFormat)
+	}
+	writeSumAndCount(w, prefix, sum, count, &sm.floatFormat)
+}
+
+// MetricSample is a single sample obtained via SnapshotMetricSamples or ReadPrometheus.
+type MetricSample struct {
+	// Name is the sample name without labels. For example, `request_duration_seconds_bucket` for histogram buckets.
+	Name string
+
+	// Labels contains sample labels. It is empty for samples without labels.
+	Labels map[string]string
+
+	// Value is the sample value.
+	Value float64
+
+	// Timestamp is an optional sample timestamp in milliseconds. It is zero for samples without timestamps.
+	//
+	// Samples returned by SnapshotMetricSamples have no timestamps.
+	Timestamp int64
+}
+
+// SnapshotMetrics returns the current values for all the metrics from the default set and all the added sets.
+//
+// The returned map contains full series names with labels sorted by names, such as `foo{a="b",c="d"}`, as keys.
+// The keys have the same canonical form as names returned by BuildName.
+// Histograms and summaries are expanded into their `_bucket`, `_sum`, `_count` and quantile series
+// the same way as they are exposed by WritePrometheus. Process metrics aren't included.
+//
+// SnapshotMetrics is convenient for tests and health checks, which need to inspect metric values
+// without parsing WritePrometheus output.
+//
+// See also SnapshotMetricSamples.
+func SnapshotMetrics() map[string]float64 {
+	return snapshotToMap(SnapshotMetricSamples())
+}
+
+// SnapshotMetrics returns the current values for all the metrics from s.
+//
+// See SnapshotMetrics for details.
+func (s *Set) SnapshotMetrics() map[string]float64 {
+	return snapshotToMap(s.SnapshotMetricSamples())
+}
+
+// SnapshotMetricSamples returns the current samples for all the metrics from the default set and all the added sets.
+//
+// The list of metrics is captured atomically under the lock of every set, while every metric value is read atomically.
+// Gauge callbacks are invoked exactly once per snapshot. They are called without holding the set lock,
+// since they may access the set.
+//
+// The returned samples are detached from the registry, so they may be modified by the caller.
+// Process metrics aren't included.
+//
+// See also SnapshotMetrics.
+func SnapshotMetricSamples() []MetricSample {
+	var samples []MetricSample
+	for _, s := range getRegisteredSets() {
+		samples = s.appendMetricSamples(samples)
+	}
+	return samples
+}
+
+// SnapshotMetricSamples returns the current samples for all the metrics from s.
+//
+// See SnapshotMetricSamples for details.
+func (s *Set) SnapshotMetricSamples() []MetricSample {
+	return s.appendMetricSamples(nil)
+}
+
+func (s *Set) appendMetricSamples(dst []MetricSample) []MetricSample {
+	bb := getBytesBuffer()
+	sa, metricsWriters := s.getSortedMetrics()
+	for _, nm := range sa {
+		nm.metric.marshalTo(nm.name, bb)
+	}
+	for _, mw := range metricsWriters {
+		mw.write(bb)
+	}
+	// Metrics are marshaled by this package, so they must be parsed without errors.
+	// Lines written by metrics writers, which cannot be parsed, are skipped.
+	_ = forEachSample(bb.B, func(ps *parsedSample) {
+		labels := make(map[string]string, len(ps.labels))
+		for _, l := range ps.labels {
+			labels[l.name] = l.value
+		}
+		dst = append(dst, MetricSample{
+			Name:   ps.metricName,
+			Labels: labels,
+			Value:  ps.value,
+		})
+	})
+	putBytesBuffer(bb)
+	return dst
+}
+
+// SeriesName returns the full series name for ms in the form `name{label1="value1",...,labelN="valueN"}`.
+//
+// Labels are sorted by names.
+func (ms *MetricSample) SeriesName() string {
+	pairs := make([]string, 0, 2*len(ms.Labels))
+	for name, value := range ms.Labels {
+		pairs = append(pairs, name, value)
+	}
+	sortLabelPairs(pairs)
+	return string(appendSortedMetricName(nil, ms.Name, pairs))
+}
+
+func snapshotToMap(samples []MetricSample) map[string]float64 {
+	m := make(map[string]float64, len(samples))
+	for i := range samples {
+		m[samples[i].SeriesName()] = samples[i].Value
+	}
+	return m
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetSnapshotAndReset(t *testing.T) {
+	s := NewSet()
+	c := s.NewCounter(`counter{a="b"}`)
+	fc := s.NewFloatCounter("float_counter")
+	g := s.NewGauge("gauge", nil)
+	h := s.NewHistogram("histogram")
+	sm := s.NewSummaryExt(`summary{x="y"}`, time.Minute, []float64{0.5, 1})
+
+	f := func(resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.SnapshotAndReset(&bb)
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	c.Add(5)
+	fc.Add(1.5)
+	g.Set(10)
+	h.Update(1)
+	h.Update(1)
+	sm.Update(3)
+	sm.Update(5)
+	sm.Update(7)
+	f(`counter{a="b"} 5
+float_counter 1.5
+gauge 10
+histogram_bucket{vmrange="8.799e-01...1.000e+00"} 2
+histogram_sum 2
+histogram_count 2
+summary{x="y",quantile="0.5"} 5
+summary{x="y",quantile="1"} 7
+summary_sum{x="y"} 15
+summary_count{x="y"} 3
+`)
+
+	// The second snapshot contains only the updates since the previous snapshot.
+	c.Inc()
+	h.Update(100)
+	f(`counter{a="b"} 1
+float_counter 0
+gauge 10
+histogram_bucket{vmrange="8.799e+01...1.000e+02"} 1
+histogram_sum 100
+histogram_count 1
+`)
+
+	// Regular scrape observes the reset values.
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `counter{a="b"} 0
+float_counter 0
+gauge 10
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected WritePrometheus result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestCounterGetAndResetConcurrent(t *testing.T) {
+	s := NewSet()
+	c := s.NewCounter("counter")
+	total := uint64(0)
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				total += c.GetAndReset()
+			}
+		}
+	}()
+	err := testConcurrent(func() error {
+		for i := 0; i < 1000; i++ {
+			c.Inc()
+		}
+		return nil
+	})
+	close(stopCh)
+	<-doneCh
+	if err != nil {
+		t.Fatal(err)
+	}
+	total += c.Get()
+	if total != 5*1000 {
+		t.Fatalf("unexpected total; got %d; want %d", total, 5*1000)
+	}
+}
+
+func TestSetSnapshotMetrics(t *testing.T) {
+	s := NewSet()
+	s.NewCounter(`requests_total{path="/foo",code="200"}`).Add(10)
+	s.NewFloatCounter("bytes_total").Add(1.5)
+	gaugeCalls := 0
+	s.NewGauge(`temperature{room="a\"b"}`, func() float64 {
+		gaugeCalls++
+		return 21.5
+	})
+	h := s.NewHistogram(`duration_seconds{op="get"}`)
+	h.Update(1)
+	h.Update(1)
+	sm := s.NewSummaryExt("latency", time.Minute, []float64{0.5})
+	sm.Update(3)
+	s.RegisterMetricsWriter(func(w io.Writer) {
+		WriteGaugeUint64(w, "external_gauge", 7)
+	})
+
+	m := s.SnapshotMetrics()
+	if gaugeCalls != 1 {
+		t.Fatalf("unexpected number of gauge callback calls; got %d; want 1", gaugeCalls)
+	}
+	mExpected := map[string]float64{
+		`requests_total{code="200",path="/foo"}`: 10,
+		`bytes_total`:                            1.5,
+		`temperature{room="a\"b"}`:               21.5,
+		`duration_seconds_bucket{op="get",vmrange="8.799e-01...1.000e+00"}`: 2,
+		`duration_seconds_sum{op="get"}`:                                    2,
+		`duration_seconds_count{op="get"}`:                                  2,
+		`latency_sum`:                                                       3,
+		`latency_count`:                                                     1,
+		`latency{quantile="0.5"}`:                                           3,
+		`external_gauge`:                                                    7,
+	}
+	if !reflect.DeepEqual(m, mExpected) {
+		t.Fatalf("unexpected snapshot;\ngot\n%v\nwant\n%v", m, mExpected)
+	}
+
+	// The returned samples must be detached from the registry.
+	samples := s.SnapshotMetricSamples()
+	found := false
+	for i := range samples {
+		ms := &samples[i]
+		if ms.Name != "requests_total" {
+			continue
+		}
+		found = true
+		labelsExpected := map[string]string{
+			"path": "/foo",
+			"code": "200",
+		}
+		if !reflect.DeepEqual(ms.Labels, labelsExpected) {
+			t.Fatalf("unexpected labels; got %v; want %v", ms.Labels, labelsExpected)
+		}
+		if ms.Value != 10 {
+			t.Fatalf("unexpected value; got %v; want 10", ms.Value)
+		}
+		ms.Labels["path"] = "/bar"
+		ms.Value = 123
+	}
+	if !found {
+		t.Fatalf("missing requests_total sample in %v", samples)
+	}
+	if v := s.SnapshotMetrics()[`requests_total{code="200",path="/foo"}`]; v != 10 {
+		t.Fatalf("unexpected value after modifying the snapshot; got %v; want 10", v)
+	}
+}
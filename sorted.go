@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// SetSortMetricsOnWrite enables or disables sorting of metrics from all the registered sets on WritePrometheus calls.
+//
+// Metrics from every set are always sorted by metric family name and then by labels. By default sets are written
+// one after another, so the output order depends on the set registration. If v is true, then metrics from all the sets
+// are merged into a single sorted list before writing. This makes the output deterministic, so it can be compared
+// between instances or against golden files.
+//
+// The output of metrics writers registered via RegisterMetricsWriter and process metrics is written after the sorted metrics,
+// since it cannot be re-ordered.
+//
+// Sorting applies to WriteOpenMetrics output too. WriteOpenMetrics always writes every metric family as a single contiguous group
+// with a single metadata block even if the family has series in multiple sets, since this is required by OpenMetrics.
+//
+// It is safe to call this function multiple times. It is allowed to change the setting at runtime.
+// Sorting is disabled by default.
+func SetSortMetricsOnWrite(v bool) {
+	n := uint32(0)
+	if v {
+		n = 1
+	}
+	atomic.StoreUint32(&sortMetricsOnWrite, n)
+}
+
+func isSortMetricsOnWriteEnabled() bool {
+	return atomic.LoadUint32(&sortMetricsOnWrite) != 0
+}
+
+var sortMetricsOnWrite uint32
+
+// writePrometheusSorted writes metrics matching mf from all the sets to w, so the metrics are sorted across the sets.
+//
+// Duplicate series are written only once - the series from the first set in sets is kept. Written series are tracked by dd.
+// All the metrics are written if mf is nil.
+func writePrometheusSorted(w io.Writer, sets []*Set, mf *metricNameFilter, dd *duplicateDetector) {
+	sa, metricsWriters := mergeSetsMetrics(sets)
+	sa = dropSeenMetrics(sa, dd)
+	if dd.err != nil {
+		return
+	}
+	writePrometheusMetrics(w, sa, metricsWriters, mf, dd)
+}
+
+// getMergedSortedMetrics returns metrics from all the sets merged into a single list sorted by lessMetricName.
+//
+// Duplicate series are returned only once - the series from the first set in sets is kept.
+// It also returns metricsWriters registered in the sets.
+func getMergedSortedMetrics(sets []*Set) ([]*namedMetric, []*MetricsWriter) {
+	sa, metricsWriters := mergeSetsMetrics(sets)
+	sa = dropDuplicateMetrics(sa)
+	return sa, metricsWriters
+}
+
+// getFamilyGroupedMetrics returns metrics from all the sets, so every metric family is a single contiguous group.
+//
+// Unlike getMergedSortedMetrics, families go in the order of the first set containing them, while series for every family
+// go in the order of sets. Duplicate series are returned only once - the series from the first set in sets is kept.
+// It also returns metricsWriters registered in the sets.
+func getFamilyGroupedMetrics(sets []*Set) ([]*namedMetric, []*MetricsWriter) {
+	if len(sets) == 1 {
+		return sets[0].getSortedMetrics()
+	}
+	var metricsWriters []*MetricsWriter
+	var families []string
+	familyMetrics := make(map[string][]*namedMetric)
+	for _, s := range sets {
+		saLocal, metricsWritersLocal := s.getSortedMetrics()
+		for _, nm := range saLocal {
+			metricFamily := getMetricFamily(nm.name)
+			a, ok := familyMetrics[metricFamily]
+			if !ok {
+				families = append(families, metricFamily)
+			}
+			familyMetrics[metricFamily] = append(a, nm)
+		}
+		metricsWriters = append(metricsWriters, metricsWritersLocal...)
+	}
+	var sa []*namedMetric
+	for _, metricFamily := range families {
+		sa = append(sa, familyMetrics[metricFamily]...)
+	}
+	sa = dropDuplicateMetrics(sa)
+	return sa, metricsWriters
+}
+
+// mergeSetsMetrics returns metrics from all the sets merged into a single list sorted by lessMetricName.
+//
+// Metrics with the same name are ordered by the order of sets. It also returns metricsWriters registered in the sets.
+func mergeSetsMetrics(sets []*Set) ([]*namedMetric, []*MetricsWriter) {
+	var sa []*namedMetric
+	var metricsWriters []*MetricsWriter
+	for _, s := range sets {
+		// Every set returns a copy of its cached sorted list, so the lists are merged without holding the set locks.
+		saLocal, metricsWritersLocal := s.getSortedMetrics()
+		sa = mergeSortedMetrics(sa, saLocal)
+		metricsWriters = append(metricsWriters, metricsWritersLocal...)
+	}
+	return sa, metricsWriters
+}
+
+// mergeSortedMetrics merges a and b sorted by lessMetricName into a single sorted list.
+//
+// Metrics from a go before metrics from b with the same name.
+func mergeSortedMetrics(a, b []*namedMetric) []*namedMetric {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	result := make([]*namedMetric, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if lessMetricName(b[0].name, a[0].name) {
+			result = append(result, b[0])
+			b = b[1:]
+		} else {
+			result = append(result, a[0])
+			a = a[1:]
+		}
+	}
+	result = append(result, a...)
+	return append(result, b...)
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestLessMetricName(t *testing.T) {
+	f := func(a, b string, resultExpected bool) {
+		t.Helper()
+		if result := lessMetricName(a, b); result != resultExpected {
+			t.Fatalf("unexpected lessMetricName(%q, %q); got %v; want %v", a, b, result, resultExpected)
+		}
+	}
+	f("foo", "foo", false)
+	f("foo", "bar", false)
+	f("bar", "foo", true)
+	f("foo", `foo{a="b"}`, true)
+	f(`foo{a="b"}`, "foo_bar", true)
+	f("foo_bar", `foo{a="b"}`, false)
+	f(`foo{a="b"}`, `foo{a="c"}`, true)
+	f(`foo{a="c"}`, `foo{a="b"}`, false)
+}
+
+func TestSetWritePrometheusSortedByFamily(t *testing.T) {
+	s := NewSet()
+	s.NewCounter("foo_bar").Inc()
+	s.NewCounter(`foo{a="2"}`).Inc()
+	s.NewCounter("foo").Inc()
+	s.NewCounter(`foo{a="1"}`).Inc()
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `foo 1
+foo{a="1"} 1
+foo{a="2"} 1
+foo_bar 1
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Newly registered metrics must be written in sorted order.
+	s.NewCounter("aaa").Inc()
+	s.NewCounter(`foo{a="0"}`).Inc()
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	resultExpected = `aaa 1
+foo 1
+foo{a="0"} 1
+foo{a="1"} 1
+foo{a="2"} 1
+foo_bar 1
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected output after registering new metrics; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestWritePrometheusSorted(t *testing.T) {
+	s1 := NewSet()
+	s1.NewCounter("sorted_b").Inc()
+	s1.NewCounter(`sorted_d{x="1"}`).Inc()
+	_ = s1.NewGauge(`sorted_c{x="2"}`, func() float64 { return 2 })
+	s2 := NewSet()
+	s2.NewCounter("sorted_a").Inc()
+	s2.NewCounter(`sorted_c{x="1"}`).Inc()
+	s2.RegisterMetricsWriter(func(w io.Writer) {
+		WriteGaugeUint64(w, "sorted_writer", 42)
+	})
+
+	var bb bytes.Buffer
+	dd := getDuplicateDetector()
+	writePrometheusSorted(&bb, []*Set{s1, s2}, nil, dd)
+	putDuplicateDetector(dd)
+	resultExpected := `sorted_a 1
+sorted_b 1
+sorted_c{x="1"} 1
+sorted_c{x="2"} 2
+sorted_d{x="1"} 1
+sorted_writer 42
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Verify the global setting.
+	RegisterSet(s1)
+	RegisterSet(s2)
+	defer UnregisterSet(s1)
+	defer UnregisterSet(s2)
+	SetSortMetricsOnWrite(true)
+	defer SetSortMetricsOnWrite(false)
+	bb.Reset()
+	WritePrometheusMatching(&bb, []string{"sorted_a", "sorted_b", "sorted_c", "sorted_d", "sorted_writer"})
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected output for WritePrometheusMatching; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
@@ -0,0 +1,528 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/valyala/fastrand"
+)
+
+// StatsDOpts contains options for NewStatsDClient.
+type StatsDOpts struct {
+	// Prefix is an optional prefix, which is prepended to all the metric names sent to StatsD.
+	Prefix string
+
+	// MaxPacketSize is the maximum size of UDP datagram with batched metric updates.
+	//
+	// By default 1432 bytes are used, which fits Ethernet MTU.
+	MaxPacketSize int
+
+	// FlushInterval is the interval for sending partially filled datagrams.
+	//
+	// By default 100ms is used.
+	FlushInterval time.Duration
+
+	// UseDistributions enables sending histogram and summary updates as DogStatsD distributions with `|d` type.
+	//
+	// By default histogram and summary updates are sent with `|h` type.
+	UseDistributions bool
+}
+
+// StatsDClient sends metric updates to StatsD or DogStatsD agent over UDP.
+//
+// Metric updates are batched into datagrams of up to StatsDOpts.MaxPacketSize bytes.
+// Updates are buffered in per-CPU shards, so concurrent updates don't contend on a single lock.
+// Counter and histogram updates are spread among shards, so they may be sent in distinct order than they were made.
+// Updates with `|g` type for a single metric are always buffered in the same shard, so they are sent in order.
+// Filled datagrams are sent by a background goroutine, so metric updates never wait for network I/O.
+// Partially filled datagrams are sent every StatsDOpts.FlushInterval. Up to 256 filled datagrams may wait for sending -
+// the rest of datagrams are dropped and are counted by `metrics_statsd_packets_dropped_total` counter.
+//
+// Attach the client to Set via Set.AttachStatsD in order to mirror metric updates to StatsD.
+//
+// StatsDClient is safe to use from concurrent goroutines.
+type StatsDClient struct {
+	addr                string
+	prefix              string
+	maxPacketSize       int
+	histogramType       string
+	sendErrorsTotal     *Counter
+	packetsSentTotal    *Counter
+	packetsDroppedTotal *Counter
+
+	conn net.Conn
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	// closed is set to 1 by Close.
+	closed uint32
+
+	// sinksCreated is used for spreading sinks among shards.
+	sinksCreated uint32
+
+	// shards contain partially filled datagrams for metric updates.
+	shards []statsdShard
+
+	// sendCh notifies the background goroutine about filled datagrams in pending.
+	sendCh chan struct{}
+
+	// sendMu serializes sending datagrams.
+	sendMu sync.Mutex
+
+	// mu protects the fields below. It is never held during network I/O.
+	// It is locked by metric updates only when a datagram is filled.
+	mu      sync.Mutex
+	pending [][]byte
+
+	// free contains sent datagram buffers for reuse, so metric updates don't allocate memory.
+	free [][]byte
+}
+
+// statsdShard is a partially filled datagram padded to CPU cache line size in order to avoid false sharing.
+type statsdShard struct {
+	statsdShardData
+
+	_ [cacheLineSize - unsafe.Sizeof(statsdShardData{})%cacheLineSize]byte
+}
+
+type statsdShardData struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// maxStatsDShards limits memory usage per StatsDClient.
+const maxStatsDShards = 64
+
+// maxStatsDPendingPackets is the maximum number of filled datagrams waiting for sending.
+const maxStatsDPendingPackets = 256
+
+// NewStatsDClient returns new StatsDClient, which sends metric updates to StatsD agent at the given UDP addr.
+//
+// Call Close when the client is no longer needed.
+func NewStatsDClient(addr string, opts StatsDOpts) (*StatsDClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to StatsD at %q: %w", addr, err)
+	}
+	maxPacketSize := opts.MaxPacketSize
+	if maxPacketSize <= 0 {
+		maxPacketSize = 1432
+	}
+	flushInterval := opts.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = 100 * time.Millisecond
+	}
+	histogramType := "h"
+	if opts.UseDistributions {
+		histogramType = "d"
+	}
+	c := &StatsDClient{
+		addr:                addr,
+		prefix:              opts.Prefix,
+		maxPacketSize:       maxPacketSize,
+		histogramType:       histogramType,
+		sendErrorsTotal:     pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_statsd_send_errors_total{addr=%q}`, addr)),
+		packetsSentTotal:    pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_statsd_packets_sent_total{addr=%q}`, addr)),
+		packetsDroppedTotal: pushMetricsSet.GetOrCreateCounter(fmt.Sprintf(`metrics_statsd_packets_dropped_total{addr=%q}`, addr)),
+		conn:                conn,
+		stopCh:              make(chan struct{}),
+		sendCh:              make(chan struct{}, 1),
+	}
+	n := 1
+	for n < runtime.GOMAXPROCS(0) && n < maxStatsDShards {
+		n *= 2
+	}
+	c.shards = make([]statsdShard, n)
+	for i := range c.shards {
+		c.shards[i].buf = make([]byte, 0, maxPacketSize)
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Flush()
+			case <-c.sendCh:
+				c.sendPending(false)
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Flush sends all the pending metric updates to StatsD.
+func (c *StatsDClient) Flush() {
+	c.sendPending(true)
+}
+
+// sendPending sends filled datagrams to StatsD. Partially filled datagrams from shards are sent too if includePartial is true.
+func (c *StatsDClient) sendPending(includePartial bool) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if includePartial {
+		c.mergeShards()
+	}
+	c.mu.Lock()
+	pending := c.pending
+	if len(pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.pending = nil
+	c.mu.Unlock()
+
+	for _, packet := range pending {
+		if _, err := c.conn.Write(packet); err != nil {
+			c.sendErrorsTotal.Inc()
+		} else {
+			c.packetsSentTotal.Inc()
+		}
+	}
+
+	c.mu.Lock()
+	c.free = append(c.free, pending...)
+	if c.pending == nil {
+		// Reuse the slice for the next datagrams.
+		c.pending = pending[:0]
+	}
+	c.mu.Unlock()
+}
+
+// mergeShards merges partially filled datagrams from shards into as few datagrams as possible and adds them to c.pending.
+func (c *StatsDClient) mergeShards() {
+	var packet []byte
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		if len(sh.buf) == 0 {
+			sh.mu.Unlock()
+			continue
+		}
+		if packet == nil || len(packet)+1+len(sh.buf) > c.maxPacketSize {
+			c.mu.Lock()
+			if len(packet) > 0 {
+				c.enqueueLocked(packet)
+			}
+			packet = c.getBufLocked()
+			c.mu.Unlock()
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, sh.buf...)
+		sh.buf = sh.buf[:0]
+		sh.mu.Unlock()
+	}
+	if len(packet) > 0 {
+		c.mu.Lock()
+		c.enqueueLocked(packet)
+		c.mu.Unlock()
+	}
+}
+
+// Close flushes pending metric updates and closes c.
+//
+// Metric updates for the closed client are dropped.
+func (c *StatsDClient) Close() error {
+	if !atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
+		return nil
+	}
+
+	close(c.stopCh)
+	c.wg.Wait()
+
+	c.sendPending(true)
+	return c.conn.Close()
+}
+
+// enqueueLocked adds the filled datagram to c.pending.
+//
+// The datagram is dropped if too many datagrams wait for sending, so memory usage remains bounded when StatsD is slow.
+func (c *StatsDClient) enqueueLocked(packet []byte) {
+	if len(c.pending) >= maxStatsDPendingPackets {
+		c.packetsDroppedTotal.Inc()
+		c.free = append(c.free, packet)
+		return
+	}
+	c.pending = append(c.pending, packet)
+}
+
+// getBufLocked returns an empty buffer for the next datagram.
+func (c *StatsDClient) getBufLocked() []byte {
+	n := len(c.free)
+	if n == 0 {
+		return make([]byte, 0, c.maxPacketSize)
+	}
+	buf := c.free[n-1][:0]
+	c.free = c.free[:n-1]
+	return buf
+}
+
+func (c *StatsDClient) newSink(metricName string) *statsdSink {
+	name, labels := splitMetricName(metricName)
+	var dst []byte
+	dst = append(dst, c.prefix...)
+	dst = appendStatsDName(dst, name)
+	dst = append(dst, ':')
+	sink := &statsdSink{
+		c:        c,
+		name:     string(dst),
+		shardIdx: atomic.AddUint32(&c.sinksCreated, 1) % uint32(len(c.shards)),
+	}
+	if labels == "" {
+		return sink
+	}
+	tags, _, err := parseLabels(nil, labels[1:])
+	if err != nil {
+		// metricName is validated on registration, so this must be impossible.
+		panic(fmt.Errorf("BUG: cannot parse labels for %q: %s", metricName, err))
+	}
+	dst = dst[:0]
+	for _, tag := range tags {
+		if tag.value == "" {
+			continue
+		}
+		if len(dst) == 0 {
+			dst = append(dst, "|#"...)
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = appendStatsDName(dst, tag.name)
+		dst = append(dst, ':')
+		dst = appendStatsDTagValue(dst, tag.value)
+	}
+	sink.tags = string(dst)
+	return sink
+}
+
+// appendStatsDName appends s to dst, while replacing chars, which have special meaning in StatsD protocol, with `_`.
+func appendStatsDName(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch ch {
+		case ':', '|', '@', '#', ',', ' ', '\n':
+			ch = '_'
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
+
+// appendStatsDTagValue appends s to dst, while replacing chars, which aren't allowed in DogStatsD tag values, with `_`.
+func appendStatsDTagValue(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch ch {
+		case '|', ',', '#', ' ', '\n':
+			ch = '_'
+		}
+		dst = append(dst, ch)
+	}
+	return dst
+}
+
+// statsdSink sends updates for a single metric to StatsDClient.
+type statsdSink struct {
+	c *StatsDClient
+
+	// name is the StatsD metric name with trailing `:`
+	name string
+
+	// tags contains `|#k:v,k2:v2` DogStatsD tags for the metric. It is empty if the metric has no labels.
+	tags string
+
+	// shardIdx is the index of the shard in c.shards for updates with `|g` type, so they are sent in order.
+	shardIdx uint32
+}
+
+func (ss *statsdSink) sendInt(v int64, metricType string) {
+	sh, start := ss.startLine(metricType)
+	if sh == nil {
+		return
+	}
+	sh.buf = strconv.AppendInt(sh.buf, v, 10)
+	ss.finishLine(sh, start, metricType, 1)
+}
+
+func (ss *statsdSink) sendUint(v uint64, metricType string) {
+	sh, start := ss.startLine(metricType)
+	if sh == nil {
+		return
+	}
+	sh.buf = strconv.AppendUint(sh.buf, v, 10)
+	ss.finishLine(sh, start, metricType, 1)
+}
+
+func (ss *statsdSink) sendFloat(v float64, metricType string) {
+	ss.sendFloatWithSampleRate(v, metricType, 1)
+}
+
+func (ss *statsdSink) sendFloatWithSampleRate(v float64, metricType string, sampleRate float64) {
+	sh, start := ss.startLine(metricType)
+	if sh == nil {
+		return
+	}
+	sh.buf = strconv.AppendFloat(sh.buf, v, 'g', -1, 64)
+	ss.finishLine(sh, start, metricType, sampleRate)
+}
+
+func (ss *statsdSink) sendHistogram(v float64) {
+	ss.sendFloat(v, ss.c.histogramType)
+}
+
+// sendHistogramWithCount sends v, which represents count observations, with `|@<1/count>` sample rate.
+func (ss *statsdSink) sendHistogramWithCount(v float64, count uint64) {
+	if count <= 1 {
+		ss.sendHistogram(v)
+		return
+	}
+	ss.sendHistogramWithSampleRate(v, 1/float64(count))
+}
+
+// sendHistogramWithSampleRate sends v with `|@<sampleRate>` sample rate.
+func (ss *statsdSink) sendHistogramWithSampleRate(v, sampleRate float64) {
+	ss.sendFloatWithSampleRate(v, ss.c.histogramType, sampleRate)
+}
+
+// startLine locks the shard for the update with the given metricType and starts a new line for ss in it.
+//
+// It returns the locked shard and the start of the line in the shard buffer. The shard must be passed to finishLine.
+// nil shard is returned if ss.c is closed.
+func (ss *statsdSink) startLine(metricType string) (*statsdShard, int) {
+	c := ss.c
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return nil, 0
+	}
+	idx := ss.shardIdx
+	if metricType != "g" && len(c.shards) > 1 {
+		// The order of counter and histogram updates doesn't matter, so spread them among shards.
+		idx = fastrand.Uint32n(uint32(len(c.shards)))
+	}
+	sh := &c.shards[idx]
+	sh.mu.Lock()
+	start := len(sh.buf)
+	if start > 0 {
+		sh.buf = append(sh.buf, '\n')
+	}
+	sh.buf = append(sh.buf, ss.name...)
+	return sh, start
+}
+
+// finishLine finishes the line started by startLine and unlocks sh.
+//
+// `|@<sampleRate>` is added to the line if sampleRate is smaller than 1.
+func (ss *statsdSink) finishLine(sh *statsdShard, start int, metricType string, sampleRate float64) {
+	c := ss.c
+	sh.buf = append(sh.buf, '|')
+	sh.buf = append(sh.buf, metricType...)
+	if sampleRate < 1 {
+		sh.buf = append(sh.buf, "|@"...)
+		sh.buf = strconv.AppendFloat(sh.buf, sampleRate, 'g', -1, 64)
+	}
+	sh.buf = append(sh.buf, ss.tags...)
+	if len(sh.buf) <= c.maxPacketSize {
+		sh.mu.Unlock()
+		return
+	}
+
+	c.mu.Lock()
+	buf := c.getBufLocked()
+	if start == 0 {
+		// The line exceeds maxPacketSize. Send it in a separate datagram.
+		c.enqueueLocked(sh.buf)
+	} else {
+		// Send the previously buffered lines and keep the current line without the leading newline.
+		buf = append(buf, sh.buf[start+1:]...)
+		c.enqueueLocked(sh.buf[:start])
+	}
+	c.mu.Unlock()
+	sh.buf = buf
+	sh.mu.Unlock()
+
+	// Wake up the background goroutine for sending the filled datagram without blocking the caller.
+	select {
+	case c.sendCh <- struct{}{}:
+	default:
+	}
+}
+
+// statsdMirror holds an optional StatsD sink for mirroring metric updates.
+//
+// Zero statsdMirror is usable. It is a plain atomic pointer, so metric updates without attached StatsD client
+// cost a single atomic load.
+type statsdMirror struct {
+	p unsafe.Pointer
+}
+
+func (sm *statsdMirror) load() *statsdSink {
+	return (*statsdSink)(atomic.LoadPointer(&sm.p))
+}
+
+func (sm *statsdMirror) store(ss *statsdSink) {
+	atomic.StorePointer(&sm.p, unsafe.Pointer(ss))
+}
+
+// statsdMirrored must be implemented by metrics, which may mirror their updates to StatsD.
+type statsdMirrored interface {
+	// getStatsDMirror returns the mirror for the metric.
+	//
+	// Metrics, which allocate the mirror lazily, return nil if create is false and the mirror isn't allocated yet.
+	getStatsDMirror(create bool) *statsdMirror
+}
+
+// AttachStatsD mirrors updates for all the counters, gauges, histograms and summaries
+// from the default set to c.
+//
+// See Set.AttachStatsD for details.
+func AttachStatsD(c *StatsDClient) {
+	defaultSet.AttachStatsD(c)
+}
+
+// AttachStatsD mirrors updates for all the metrics from s to c, including metrics registered after the call.
+//
+// Counter.Inc, Counter.Dec, Counter.Add and FloatCounter.Add updates are sent with `|c` type.
+// Counter.Set, FloatCounter.Set and Gauge updates are sent with `|g` type and the resulting value.
+// Histogram and Summary updates are sent with `|h` or `|d` type depending on StatsDOpts.UseDistributions.
+// Updates for gauges with non-nil callback aren't mirrored.
+//
+// Metric labels are sent as DogStatsD tags.
+//
+// Pass nil c in order to stop mirroring metric updates for s.
+func (s *Set) AttachStatsD(c *StatsDClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statsd = c
+	for _, nm := range s.a {
+		s.attachStatsDLocked(nm)
+	}
+}
+
+func (s *Set) attachStatsDLocked(nm *namedMetric) {
+	if nm.isAux {
+		return
+	}
+	m, ok := nm.metric.(statsdMirrored)
+	if !ok {
+		return
+	}
+	var ss *statsdSink
+	if s.statsd != nil {
+		ss = s.statsd.newSink(nm.name)
+	}
+	if sm := m.getStatsDMirror(ss != nil); sm != nil {
+		sm.store(ss)
+	}
+}
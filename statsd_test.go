@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStatsDListener(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot start UDP listener: %s", err)
+	}
+	return conn
+}
+
+func readStatsDPacket(t *testing.T, conn *net.UDPConn) string {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("cannot set read deadline: %s", err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("cannot read StatsD packet: %s", err)
+	}
+	return string(buf[:n])
+}
+
+func TestStatsDClient(t *testing.T) {
+	ln := newTestStatsDListener(t)
+	defer ln.Close()
+
+	c, err := NewStatsDClient(ln.LocalAddr().String(), StatsDOpts{
+		Prefix:        "app.",
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	s := NewSet()
+	cnt := s.NewCounter(`requests_total{path="/a,b",empty=""}`)
+	s.AttachStatsD(c)
+	g := s.NewGauge("temperature", nil)
+	fc := s.GetOrCreateFloatCounter("bytes")
+	h := s.NewHistogram(`duration{x="y"}`)
+	sm := s.NewSummary("size")
+
+	cnt.Inc()
+	cnt.Add(5)
+	cnt.Dec()
+	g.Set(1.5)
+	g.Inc()
+	fc.Add(0.25)
+	h.Update(0.5)
+	h.Update(-1)
+	h.UpdateWithCount(2, 4)
+	sm.Update(3)
+	c.Flush()
+
+	// Counter and histogram updates may be sent in distinct order, since they are spread among shards.
+	result := sortStatsDLines(readStatsDPacket(t, ln))
+	resultExpected := sortStatsDLines(strings.Join([]string{
+		"app.requests_total:1|c|#path:/a_b",
+		"app.requests_total:5|c|#path:/a_b",
+		"app.requests_total:-1|c|#path:/a_b",
+		"app.temperature:1.5|g",
+		"app.temperature:2.5|g",
+		"app.bytes:0.25|c",
+		"app.duration:0.5|h|#x:y",
+		"app.duration:2|h|@0.25|#x:y",
+		"app.size:3|h",
+	}, "\n"))
+	if result != resultExpected {
+		t.Fatalf("unexpected packet;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	// Detach the client
+	s.AttachStatsD(nil)
+	cnt.Inc()
+	c.Flush()
+	if err := ln.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatalf("cannot set read deadline: %s", err)
+	}
+	if n, err := ln.Read(make([]byte, 1024)); err == nil {
+		t.Fatalf("unexpected packet after detaching the client; got %d bytes", n)
+	}
+}
+
+func TestStatsDClientBatching(t *testing.T) {
+	ln := newTestStatsDListener(t)
+	defer ln.Close()
+
+	c, err := NewStatsDClient(ln.LocalAddr().String(), StatsDOpts{
+		MaxPacketSize:    20,
+		FlushInterval:    time.Hour,
+		UseDistributions: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	s := NewSet()
+	s.AttachStatsD(c)
+	h := s.NewHistogram("foo")
+	for i := 0; i < 3; i++ {
+		h.Update(float64(i))
+	}
+	c.Flush()
+
+	var lines []string
+	for len(lines) < 3 {
+		packet := readStatsDPacket(t, ln)
+		if len(packet) > 20 {
+			t.Fatalf("too big packet; got %d bytes; want up to %d bytes; packet: %q", len(packet), 20, packet)
+		}
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	result := sortStatsDLines(strings.Join(lines, "\n"))
+	resultExpected := "foo:0|d\nfoo:1|d\nfoo:2|d"
+	if result != resultExpected {
+		t.Fatalf("unexpected lines;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestStatsDClientGaugeOrder(t *testing.T) {
+	ln := newTestStatsDListener(t)
+	defer ln.Close()
+
+	c, err := NewStatsDClient(ln.LocalAddr().String(), StatsDOpts{
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	s := NewSet()
+	s.AttachStatsD(c)
+	g := s.NewGauge("foo", nil)
+	cnt := s.NewCounter("bar")
+	var linesExpected []string
+	for i := 0; i < 50; i++ {
+		g.Set(float64(i))
+		cnt.Inc()
+		linesExpected = append(linesExpected, fmt.Sprintf("foo:%d|g", i))
+	}
+	c.Flush()
+
+	// Gauge updates must be sent in order, since StatsD keeps the last value.
+	var lines []string
+	for _, line := range strings.Split(readStatsDPacket(t, ln), "\n") {
+		if strings.HasPrefix(line, "foo:") {
+			lines = append(lines, line)
+		}
+	}
+	result := strings.Join(lines, "\n")
+	resultExpected := strings.Join(linesExpected, "\n")
+	if result != resultExpected {
+		t.Fatalf("unexpected gauge updates;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestStatsDClientNoAllocs(t *testing.T) {
+	ln := newTestStatsDListener(t)
+	defer ln.Close()
+
+	c, err := NewStatsDClient(ln.LocalAddr().String(), StatsDOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	s := NewSet()
+	s.AttachStatsD(c)
+	cnt := s.NewCounter(`foo{bar="baz"}`)
+	allocs := testing.AllocsPerRun(100, cnt.Inc)
+	if allocs != 0 {
+		t.Fatalf("unexpected number of allocations per Counter.Inc call; got %v; want 0", allocs)
+	}
+}
+
+func TestStatsDClientSlowServer(t *testing.T) {
+	ln := newTestStatsDListener(t)
+	defer ln.Close()
+
+	c, err := NewStatsDClient(ln.LocalAddr().String(), StatsDOpts{
+		MaxPacketSize: 20,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bc := &blockingConn{
+		Conn:      c.conn,
+		unblockCh: make(chan struct{}),
+	}
+	c.conn = bc
+
+	s := NewSet()
+	s.AttachStatsD(c)
+	cnt := s.NewCounter("foo")
+
+	// Updates must not wait for the blocked StatsD server.
+	doneCh := make(chan struct{})
+	go func() {
+		for i := 0; i < 10*maxStatsDPendingPackets; i++ {
+			cnt.Inc()
+		}
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout when updating the metric with blocked StatsD server")
+	}
+	if n := c.packetsDroppedTotal.Get(); n == 0 {
+		t.Fatalf("expecting non-zero number of dropped packets")
+	}
+
+	close(bc.unblockCh)
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// sortStatsDLines sorts lines in the StatsD packet.
+func sortStatsDLines(packet string) string {
+	lines := strings.Split(packet, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+type blockingConn struct {
+	net.Conn
+	unblockCh chan struct{}
+}
+
+func (bc *blockingConn) Write(p []byte) (int, error) {
+	<-bc.unblockCh
+	return bc.Conn.Write(p)
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+)
+
+func BenchmarkStatsDClientCounterInc(b *testing.B) {
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatalf("cannot start UDP listener: %s", err)
+	}
+	defer ln.Close()
+
+	for _, procs := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("GOMAXPROCS_%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			c, err := NewStatsDClient(ln.LocalAddr().String(), StatsDOpts{})
+			if err != nil {
+				b.Fatalf("unexpected error: %s", err)
+			}
+			defer c.Close()
+			s := NewSet()
+			s.AttachStatsD(c)
+			cnt := s.NewCounter(`foo{bar="baz"}`)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					cnt.Inc()
+				}
+			})
+		})
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/valyala/fastrand"
 	"github.com/valyala/histogram"
 )
 
@@ -19,8 +21,8 @@ var defaultSummaryQuantiles = []float64{0.5, 0.9, 0.97, 0.99, 1}
 type Summary struct {
 	mu sync.Mutex
 
-	curr *histogram.Fast
-	next *histogram.Fast
+	// backend estimates quantiles over the summary window. It is protected by mu.
+	backend summaryBackend
 
 	quantiles      []float64
 	quantileValues []float64
@@ -28,7 +30,24 @@ type Summary struct {
 	sum   float64
 	count uint64
 
+	// sampledSum and sampledCount contain the sum and the count for observations recorded with sampleRate.
+	// They are scaled by 1/sampleRate when exposed.
+	sampledSum   float64
+	sampledCount uint64
+	sampleRate   float64
+
+	// sampleThreshold is the threshold for random uint32 values for recording sampled observations.
+	// Zero means that all the observations are recorded. It is accessed atomically.
+	sampleThreshold uint32
+
 	window time.Duration
+
+	// floatFormat is the float format set via SetFloatFormat. It is accessed atomically.
+	floatFormat uint32
+
+	statsd statsdMirror
+
+	created createdTimestamp
 }
 
 // NewSummary creates and returns new summary with the given name.
@@ -55,18 +74,171 @@ func NewSummary(name string) *Summary {
 //   - foo{bar="baz"}
 //   - foo{bar="baz",aaa="b"}
 //
+// window must be positive. Quantiles are calculated over samples observed during the last window,
+// so samples older than window are never taken into account.
+// quantiles must be in the range [0..1].
+//
 // The returned summary is safe to use from concurrent goroutines.
 func NewSummaryExt(name string, window time.Duration, quantiles []float64) *Summary {
 	return defaultSet.NewSummaryExt(name, window, quantiles)
 }
 
+// SummaryOpts contains options for NewSummaryOpt.
+type SummaryOpts struct {
+	// Name is the summary name. It must be valid Prometheus-compatible metric with possible labels.
+	Name string
+
+	// Help is an optional description for the summary. It is exposed in the `# HELP` line.
+	Help string
+
+	// Unit is an optional unit for the summary such as `seconds` or `bytes`. It is exposed in the `# UNIT` line in OpenMetrics format.
+	//
+	// See SetStrictUnitNames and NormalizeUnit.
+	Unit string
+
+	// Window is an optional window for the summary quantiles. 5 minutes window is used by default.
+	Window time.Duration
+
+	// Quantiles is an optional list of quantiles to expose. Quantiles 0.5, 0.9, 0.97, 0.99 and 1 are used by default.
+	Quantiles []float64
+
+	// SampleRate is an optional fraction of observations to record. All the observations are recorded by default.
+	//
+	// See Summary.SetSampleRate for details.
+	SampleRate float64
+
+	// Backend is an optional algorithm for estimating quantiles. SummaryBackendHistogram is used by default.
+	Backend SummaryBackend
+
+	// Compression is an optional compression for SummaryBackendTDigest. 100 is used by default.
+	//
+	// Higher values improve the accuracy at the cost of memory usage and update speed. Every t-digest keeps
+	// up to Compression centroids plus a buffer of up to 5*Compression samples, regardless of the number of observations.
+	// The summary keeps three t-digests: one per every half of the window plus the digest for merging them at read time.
+	Compression float64
+}
+
+// SummaryBackend is the algorithm for estimating Summary quantiles.
+type SummaryBackend int
+
+const (
+	// SummaryBackendHistogram estimates quantiles over a random sample of up to 1000 observations per summary window.
+	//
+	// This is the default backend. It is cheap, but estimations for extreme quantiles such as 0.999 are unstable
+	// between scrapes for skewed distributions, since the sample contains only a few observations from the tail.
+	SummaryBackendHistogram SummaryBackend = iota
+
+	// SummaryBackendTDigest estimates quantiles with t-digest over all the observations per summary window.
+	//
+	// T-digest keeps more details for the tails of the distribution, so it is more accurate for extreme quantiles.
+	// See SummaryOpts.Compression for tuning its accuracy and memory usage.
+	SummaryBackendTDigest
+)
+
+// String returns string representation for b.
+func (b SummaryBackend) String() string {
+	switch b {
+	case SummaryBackendHistogram:
+		return "histogram"
+	case SummaryBackendTDigest:
+		return "tdigest"
+	default:
+		return fmt.Sprintf("SummaryBackend(%d)", int(b))
+	}
+}
+
+// summaryBackend estimates quantiles for Summary.
+//
+// It isn't safe to use from concurrent goroutines, so calls must be protected by Summary.mu.
+type summaryBackend interface {
+	update(v float64)
+	updateWithCount(v float64, count uint64)
+
+	// quantiles appends the estimated quantiles for phis to dst and returns the result.
+	quantiles(dst, phis []float64) []float64
+
+	// rotate is called every window/2, so quantiles must cover the samples observed during the last window.
+	rotate()
+
+	reset()
+}
+
+// newSummaryBackend returns summaryBackend for the given backend and compression from SummaryOpts.
+func newSummaryBackend(backend SummaryBackend, compression float64) summaryBackend {
+	switch backend {
+	case SummaryBackendHistogram:
+		return newHistogramSummaryBackend()
+	case SummaryBackendTDigest:
+		if math.IsNaN(compression) || math.IsInf(compression, 0) || compression < 0 {
+			panic(fmt.Errorf("BUG: Compression must be positive; got %v", compression))
+		}
+		if compression == 0 {
+			compression = defaultTDigestCompression
+		}
+		return newTDigestSummaryBackend(compression)
+	default:
+		panic(fmt.Errorf("BUG: unsupported summary backend: %s", backend))
+	}
+}
+
+// histogramSummaryBackend estimates quantiles with histogram.Fast.
+//
+// Every sample is added to both curr and next. They are rotated every window/2, so every sample stays
+// in curr for up to window.
+type histogramSummaryBackend struct {
+	curr *histogram.Fast
+	next *histogram.Fast
+}
+
+func newHistogramSummaryBackend() *histogramSummaryBackend {
+	return &histogramSummaryBackend{
+		curr: histogram.NewFast(),
+		next: histogram.NewFast(),
+	}
+}
+
+func (hb *histogramSummaryBackend) update(v float64) {
+	hb.curr.Update(v)
+	hb.next.Update(v)
+}
+
+func (hb *histogramSummaryBackend) updateWithCount(v float64, count uint64) {
+	for i := uint64(0); i < count; i++ {
+		hb.curr.Update(v)
+		hb.next.Update(v)
+	}
+}
+
+func (hb *histogramSummaryBackend) quantiles(dst, phis []float64) []float64 {
+	return hb.curr.Quantiles(dst, phis)
+}
+
+func (hb *histogramSummaryBackend) rotate() {
+	hb.curr, hb.next = hb.next, hb.curr
+	hb.next.Reset()
+}
+
+func (hb *histogramSummaryBackend) reset() {
+	hb.curr.Reset()
+	hb.next.Reset()
+}
+
+// NewSummaryOpt creates and returns new summary with the given opts.
+//
+// opts.Help is exposed in the `# HELP` line for the metric family.
+//
+// The returned summary is safe to use from concurrent goroutines.
+func NewSummaryOpt(opts SummaryOpts) *Summary {
+	return defaultSet.NewSummaryOpt(opts)
+}
+
 func newSummary(window time.Duration, quantiles []float64) *Summary {
+	validateSummaryWindow(window)
 	// Make a copy of quantiles in order to prevent from their modification by the caller.
 	quantiles = append([]float64{}, quantiles...)
 	validateQuantiles(quantiles)
 	sm := &Summary{
-		curr:           histogram.NewFast(),
-		next:           histogram.NewFast(),
+		backend:        newHistogramSummaryBackend(),
 		quantiles:      quantiles,
 		quantileValues: make([]float64, len(quantiles)),
 		window:         window,
@@ -74,22 +246,179 @@ func newSummary(window time.Duration, quantiles []float64) *Summary {
 	return sm
 }
 
+func validateSummaryWindow(window time.Duration) {
+	if window <= 0 {
+		panic(fmt.Errorf("BUG: summary window must be positive; got %s", window))
+	}
+}
+
 func validateQuantiles(quantiles []float64) {
 	for _, q := range quantiles {
-		if q < 0 || q > 1 {
+		if math.IsNaN(q) || q < 0 || q > 1 {
 			panic(fmt.Errorf("BUG: quantile must be in the range [0..1]; got %v", q))
 		}
 	}
 }
 
+// SetSampleRate sets the fraction of observations recorded by Update, UpdateBatch and UpdateDuration.
+//
+// sampleRate must be in the range (0..1]. Observations are dropped randomly without acquiring the summary lock,
+// so sampling reduces the overhead for summaries updated millions of times per second. The exposed _sum and _count
+// are scaled by 1/sampleRate, while quantiles are calculated over the recorded observations only.
+//
+// The relative standard error of the exposed _count is sqrt((1-sampleRate)/(sampleRate*n)) for n observations.
+// For example, it is around 1% for sampleRate=0.01 and a million observations. Quantiles are estimated
+// over sampleRate*n observations during the summary window, so they become less accurate, especially for
+// extreme quantiles such as 0.99 and 1, if only a few observations are recorded during the window.
+// UpdateWithCount isn't sampled, since it is already cheap for the sum and the count.
+//
+// sampleRate=1 disables the sampling. This is the default.
+func (sm *Summary) SetSampleRate(sampleRate float64) {
+	if math.IsNaN(sampleRate) || sampleRate <= 0 || sampleRate > 1 {
+		panic(fmt.Errorf("BUG: sampleRate must be in the range (0..1]; got %v", sampleRate))
+	}
+	threshold := uint32(0)
+	if sampleRate < 1 {
+		t := sampleRate * (1 << 32)
+		switch {
+		case t < 1:
+			threshold = 1
+		case t >= math.MaxUint32:
+			threshold = math.MaxUint32
+		default:
+			threshold = uint32(t)
+		}
+	}
+	sm.mu.Lock()
+	// Fold the observations recorded with the previous sample rate, so they are scaled properly.
+	sm.sum, sm.count = sm.sumAndCountLocked()
+	sm.sampledSum = 0
+	sm.sampledCount = 0
+	sm.sampleRate = sampleRate
+	atomic.StoreUint32(&sm.sampleThreshold, threshold)
+	sm.mu.Unlock()
+}
+
+// sumAndCountLocked returns the sum and the count for sm including the scaled sampled observations.
+func (sm *Summary) sumAndCountLocked() (float64, uint64) {
+	if sm.sampledCount == 0 {
+		return sm.sum, sm.count
+	}
+	sum := sm.sum + sm.sampledSum/sm.sampleRate
+	count := sm.count + uint64(math.Round(float64(sm.sampledCount)/sm.sampleRate))
+	return sum, count
+}
+
 // Update updates the summary.
 func (sm *Summary) Update(v float64) {
+	if threshold := atomic.LoadUint32(&sm.sampleThreshold); threshold != 0 {
+		sm.updateSampled(v, threshold)
+		return
+	}
 	sm.mu.Lock()
-	sm.curr.Update(v)
-	sm.next.Update(v)
+	sm.backend.update(v)
 	sm.sum += v
 	sm.count++
 	sm.mu.Unlock()
+	if ss := sm.statsd.load(); ss != nil {
+		ss.sendHistogram(v)
+	}
+}
+
+func (sm *Summary) updateSampled(v float64, threshold uint32) {
+	if getSampleRandomUint32() >= threshold {
+		return
+	}
+	sm.mu.Lock()
+	sm.backend.update(v)
+	sm.sampledSum += v
+	sm.sampledCount++
+	sampleRate := sm.sampleRate
+	sm.mu.Unlock()
+	if ss := sm.statsd.load(); ss != nil {
+		ss.sendHistogramWithSampleRate(v, sampleRate)
+	}
+}
+
+// getSampleRandomUint32 returns pseudorandom uint32 for summary sampling.
+//
+// It uses pooled per-goroutine xorshift generators without global locks. Unlike fastrand.Uint32, new generators
+// are seeded with well-mixed distinct seeds, since generators dropped from the pool on GC are re-created frequently
+// and time-based seeds for them produce correlated values, which would bias the sampling.
+func getSampleRandomUint32() uint32 {
+	v := sampleRNGPool.Get()
+	if v == nil {
+		var r fastrand.RNG
+		r.Seed(getSampleRNGSeed())
+		v = &r
+	}
+	r := v.(*fastrand.RNG)
+	x := r.Uint32()
+	sampleRNGPool.Put(r)
+	return x
+}
+
+var sampleRNGPool sync.Pool
+
+var sampleRNGSeq uint64
+
+func getSampleRNGSeed() uint32 {
+	// Mix the seed with splitmix64 finalizer. See https://prng.di.unimi.it/splitmix64.c
+	x := atomic.AddUint64(&sampleRNGSeq, 1) + uint64(time.Now().UnixNano())
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	if uint32(x) == 0 {
+		// Zero seed makes fastrand.RNG to re-seed itself from the current time.
+		return 1
+	}
+	return uint32(x)
+}
+
+// UpdateBatch updates the summary with all the values.
+//
+// It is equivalent to calling Update for every value, but the summary lock is acquired only once per batch,
+// so concurrent readers observe either none or all the values from the batch.
+func (sm *Summary) UpdateBatch(values []float64) {
+	if threshold := atomic.LoadUint32(&sm.sampleThreshold); threshold != 0 {
+		for _, v := range values {
+			sm.updateSampled(v, threshold)
+		}
+		return
+	}
+	sm.mu.Lock()
+	for _, v := range values {
+		sm.backend.update(v)
+		sm.sum += v
+	}
+	sm.count += uint64(len(values))
+	sm.mu.Unlock()
+	if ss := sm.statsd.load(); ss != nil {
+		for _, v := range values {
+			ss.sendHistogram(v)
+		}
+	}
+}
+
+// UpdateWithCount updates the summary with count observations of v.
+//
+// It is equivalent to calling Update(v) count times under a single lock. The sum and the count are updated in O(1) time,
+// while the quantiles estimation processes every observation for the default backend, so big count values take proportional time.
+// SummaryBackendTDigest adds the observations in O(1) time.
+func (sm *Summary) UpdateWithCount(v float64, count uint64) {
+	if count == 0 {
+		return
+	}
+	sm.mu.Lock()
+	sm.backend.updateWithCount(v, count)
+	sm.sum += v * float64(count)
+	sm.count += count
+	sm.mu.Unlock()
+	if ss := sm.statsd.load(); ss != nil {
+		ss.sendHistogramWithCount(v, count)
+	}
 }
 
 // UpdateDuration updates request duration based on the given startTime.
@@ -98,24 +427,63 @@ func (sm *Summary) UpdateDuration(startTime time.Time) {
 	sm.Update(d)
 }
 
+// GetQuantile returns the estimated phi-quantile for the samples observed during the summary window.
+//
+// phi must be in the range [0..1]. NaN is returned if there are no samples in the window.
+func (sm *Summary) GetQuantile(phi float64) float64 {
+	sm.mu.Lock()
+	v := sm.backend.quantiles(nil, []float64{phi})
+	sm.mu.Unlock()
+	return v[0]
+}
+
+// GetQuantiles returns the estimated quantiles for the given phis for the samples observed during the summary window.
+//
+// Every phi must be in the range [0..1]. NaNs are returned if there are no samples in the window.
+func (sm *Summary) GetQuantiles(phis []float64) []float64 {
+	sm.mu.Lock()
+	quantiles := sm.backend.quantiles(nil, phis)
+	sm.mu.Unlock()
+	return quantiles
+}
+
+// GetSum returns the sum of all the values passed to sm.Update since its creation.
+//
+// The returned sum is estimated if the sampling is enabled via SetSampleRate.
+func (sm *Summary) GetSum() float64 {
+	sm.mu.Lock()
+	sum, _ := sm.sumAndCountLocked()
+	sm.mu.Unlock()
+	return sum
+}
+
+// GetCount returns the number of sm.Update calls since sm creation.
+//
+// The returned count is estimated if the sampling is enabled via SetSampleRate.
+func (sm *Summary) GetCount() uint64 {
+	sm.mu.Lock()
+	_, count := sm.sumAndCountLocked()
+	sm.mu.Unlock()
+	return count
+}
+
+// MarshalTo writes sm with the given prefix to w in Prometheus text exposition format.
+//
+// It implements Metric interface.
+func (sm *Summary) MarshalTo(prefix string, w io.Writer) {
+	sm.marshalTo(prefix, w)
+}
+
 func (sm *Summary) marshalTo(prefix string, w io.Writer) {
 	// Marshal only *_sum and *_count values.
 	// Quantile values should be already updated by the caller via sm.updateQuantiles() call.
 	// sm.quantileValues will be marshaled later via quantileValue.marshalTo.
 	sm.mu.Lock()
-	sum := sm.sum
-	count := sm.count
+	sum, count := sm.sumAndCountLocked()
 	sm.mu.Unlock()
 
 	if count > 0 {
-		name, filters := splitMetricName(prefix)
-		if float64(int64(sum)) == sum {
-			// Marshal integer sum without scientific notation
-			fmt.Fprintf(w, "%s_sum%s %d\n", name, filters, int64(sum))
-		} else {
-			fmt.Fprintf(w, "%s_sum%s %g\n", name, filters, sum)
-		}
-		fmt.Fprintf(w, "%s_count%s %d\n", name, filters, count)
+		writeSumAndCount(w, prefix, sum, count, &sm.floatFormat)
 	}
 }
 
@@ -123,6 +491,10 @@ func (sm *Summary) metricType() string {
 	return "summary"
 }
 
+func (sm *Summary) getStatsDMirror(_ bool) *statsdMirror {
+	return &sm.statsd
+}
+
 func splitMetricName(name string) (string, string) {
 	n := strings.IndexByte(name, '{')
 	if n < 0 {
@@ -133,7 +505,7 @@ func splitMetricName(name string) (string, string) {
 
 func (sm *Summary) updateQuantiles() {
 	sm.mu.Lock()
-	sm.quantileValues = sm.curr.Quantiles(sm.quantileValues[:0], sm.quantiles)
+	sm.quantileValues = sm.backend.quantiles(sm.quantileValues[:0], sm.quantiles)
 	sm.mu.Unlock()
 }
 
@@ -168,6 +540,8 @@ func GetOrCreateSummary(name string) *Summary {
 //
 // The returned summary is safe to use from concurrent goroutines.
 //
+// It panics if the registered summary has distinct window or quantiles.
+//
 // Performance tip: prefer NewSummaryExt instead of GetOrCreateSummaryExt.
 func GetOrCreateSummaryExt(name string, window time.Duration, quantiles []float64) *Summary {
 	return defaultSet.GetOrCreateSummaryExt(name, window, quantiles)
@@ -191,12 +565,17 @@ type quantileValue struct {
 	idx int
 }
 
+// MarshalTo implements Metric interface.
+func (qv *quantileValue) MarshalTo(prefix string, w io.Writer) {
+	qv.marshalTo(prefix, w)
+}
+
 func (qv *quantileValue) marshalTo(prefix string, w io.Writer) {
 	qv.sm.mu.Lock()
 	v := qv.sm.quantileValues[qv.idx]
 	qv.sm.mu.Unlock()
 	if !math.IsNaN(v) {
-		fmt.Fprintf(w, "%s %g\n", prefix, v)
+		writeSampleGaugeValue(w, prefix, v, nil, &qv.sm.floatFormat)
 	}
 }
 
@@ -213,12 +592,28 @@ func addTag(name, tag string) string {
 
 func registerSummaryLocked(sm *Summary) {
 	window := sm.window
+	c := getClock()
+	var stopPrev func()
 	summariesLock.Lock()
 	summaries[window] = append(summaries[window], sm)
-	if len(summaries[window]) == 1 {
-		go summariesSwapCron(window)
+	if st := summariesSwapTickers[window]; st == nil || st.c != c {
+		// Start the rotation for the window or restart it if the clock has been changed via SetClockForTesting.
+		if st != nil {
+			stopPrev = st.stop
+		}
+		summariesSwapTickers[window] = &summariesSwapTicker{
+			c: c,
+			stop: c.startTicker(window/2, func() {
+				swapSummaries(window)
+			}),
+		}
 	}
 	summariesLock.Unlock()
+
+	if stopPrev != nil {
+		// The previous ticker must be stopped without holding summariesLock, since it waits for the in-flight swapSummaries call.
+		stopPrev()
+	}
 }
 
 func unregisterSummary(sm *Summary) {
@@ -240,23 +635,31 @@ func unregisterSummary(sm *Summary) {
 	summariesLock.Unlock()
 }
 
-func summariesSwapCron(window time.Duration) {
-	for {
-		time.Sleep(window / 2)
-		summariesLock.Lock()
-		for _, sm := range summaries[window] {
-			sm.mu.Lock()
-			tmp := sm.curr
-			sm.curr = sm.next
-			sm.next = tmp
-			sm.next.Reset()
-			sm.mu.Unlock()
-		}
-		summariesLock.Unlock()
+// swapSummaries rotates quantile estimators for summaries with the given window.
+//
+// It is called every window/2, so quantiles reflect only the samples for the last window.
+func swapSummaries(window time.Duration) {
+	summariesLock.Lock()
+	for _, sm := range summaries[window] {
+		sm.mu.Lock()
+		sm.backend.rotate()
+		sm.mu.Unlock()
 	}
+	summariesLock.Unlock()
 }
 
 var (
 	summaries     = map[time.Duration][]*Summary{}
 	summariesLock sync.Mutex
+
+	// summariesSwapTickers contains rotation tickers per summary window.
+	summariesSwapTickers = map[time.Duration]*summariesSwapTicker{}
 )
+
+// summariesSwapTicker is the ticker for rotating summaries with the given window.
+type summariesSwapTicker struct {
+	// c is the clock used by the ticker.
+	c clock
+
+	stop func()
+}
@@ -3,6 +3,7 @@ package metrics
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"strings"
 	"testing"
 	"time"
@@ -84,6 +85,87 @@ func TestSummaryInvalidQuantiles(t *testing.T) {
 	})
 }
 
+func TestSummaryInvalidQuantilesNaN(t *testing.T) {
+	name := "SummaryInvalidQuantilesNaN"
+	expectPanic(t, name, func() {
+		NewSummaryExt(name, time.Minute, []float64{0.5, math.NaN()})
+	})
+}
+
+func TestSummaryInvalidWindow(t *testing.T) {
+	f := func(window time.Duration) {
+		t.Helper()
+		name := fmt.Sprintf("SummaryInvalidWindow_%d", window)
+		expectPanic(t, name, func() {
+			NewSummaryExt(name, window, defaultSummaryQuantiles)
+		})
+		expectPanic(t, name, func() {
+			GetOrCreateSummaryExt(name, window, defaultSummaryQuantiles)
+		})
+		expectPanic(t, name, func() {
+			NewSummaryVecExt(name, window, defaultSummaryQuantiles, []string{"foo"})
+		})
+	}
+	f(0)
+	f(-time.Second)
+}
+
+func TestSummaryGetQuantiles(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummaryExt("foo", time.Minute, []float64{0.5})
+	if v := sm.GetQuantile(0.5); !math.IsNaN(v) {
+		t.Fatalf("unexpected quantile for empty summary; got %v; want NaN", v)
+	}
+	if sum := sm.GetSum(); sum != 0 {
+		t.Fatalf("unexpected sum for empty summary; got %v; want 0", sum)
+	}
+	if count := sm.GetCount(); count != 0 {
+		t.Fatalf("unexpected count for empty summary; got %d; want 0", count)
+	}
+	for i := 1; i <= 100; i++ {
+		sm.Update(float64(i))
+	}
+	f := func(phi, resultExpected float64) {
+		t.Helper()
+		if result := sm.GetQuantile(phi); result != resultExpected {
+			t.Fatalf("unexpected quantile for phi=%v; got %v; want %v", phi, result, resultExpected)
+		}
+	}
+	f(0, 1)
+	f(0.5, 51)
+	f(0.99, 99)
+	f(1, 100)
+
+	quantiles := sm.GetQuantiles([]float64{0, 1})
+	if len(quantiles) != 2 || quantiles[0] != 1 || quantiles[1] != 100 {
+		t.Fatalf("unexpected quantiles; got %v; want [1 100]", quantiles)
+	}
+	if sum := sm.GetSum(); sum != 5050 {
+		t.Fatalf("unexpected sum; got %v; want 5050", sum)
+	}
+	if count := sm.GetCount(); count != 100 {
+		t.Fatalf("unexpected count; got %d; want 100", count)
+	}
+}
+
+func TestSummaryGetQuantilesConcurrent(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummaryExt("foo", 10*time.Millisecond, []float64{0.5})
+	err := testConcurrent(func() error {
+		for i := 0; i < 1000; i++ {
+			sm.Update(float64(i))
+			if v := sm.GetQuantile(0.5); !math.IsNaN(v) && (v < 0 || v >= 1000) {
+				return fmt.Errorf("unexpected quantile: %v", v)
+			}
+			_ = sm.GetQuantiles([]float64{0.1, 0.9})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestSummarySmallWindow(t *testing.T) {
 	name := "SummarySmallWindow"
 	window := time.Millisecond * 20
@@ -153,3 +235,99 @@ func testGetOrCreateSummary(name string) error {
 	}
 	return nil
 }
+
+func TestSummaryUpdateBatch(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummary("foo")
+	sm.UpdateBatch(nil)
+	sm.UpdateWithCount(123, 0)
+	testMarshalTo(t, sm, "prefix", "")
+
+	sm.UpdateBatch([]float64{1, 2, 3})
+	sm.UpdateWithCount(10, 4)
+	testMarshalTo(t, sm, "prefix", "prefix_sum 46\nprefix_count 7\n")
+	sm.updateQuantiles()
+	if v := sm.quantileValues[len(sm.quantileValues)-1]; v != 10 {
+		t.Fatalf("unexpected quantileValues[last]; got %v; want 10", v)
+	}
+}
+
+func TestSummaryUpdateBatchConcurrent(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummary("foo")
+	err := testConcurrent(func() error {
+		for i := 0; i < 10; i++ {
+			sm.UpdateBatch([]float64{1, 2, 3})
+			sm.UpdateWithCount(4, 2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMarshalTo(t, sm, "prefix", "prefix_sum 700\nprefix_count 250\n")
+}
+
+func TestSummarySampleRate(t *testing.T) {
+	f := func(sampleRate float64) {
+		t.Helper()
+		s := NewSet()
+		sm := s.NewSummaryOpt(SummaryOpts{
+			Name:       "foo",
+			SampleRate: sampleRate,
+		})
+		const n = 100000
+		for i := 0; i < n; i++ {
+			sm.Update(float64(i % 100))
+		}
+		// Allow up to 6 standard errors for the estimated count and sum in order to avoid flaky failures.
+		maxRelativeErr := 6 * math.Sqrt((1-sampleRate)/(sampleRate*n))
+		count := sm.GetCount()
+		if math.Abs(float64(count)-n) > maxRelativeErr*n {
+			t.Fatalf("too big error for the estimated count; got %d; want %d", count, n)
+		}
+		sum := sm.GetSum()
+		sumExpected := float64(n) * 49.5
+		if math.Abs(sum-sumExpected) > 1.2*maxRelativeErr*sumExpected {
+			t.Fatalf("too big error for the estimated sum; got %v; want %v", sum, sumExpected)
+		}
+		if median := sm.GetQuantile(0.5); math.Abs(median-50) > 10 {
+			t.Fatalf("too big error for the estimated median; got %v; want 50", median)
+		}
+	}
+	f(1)
+	f(0.5)
+	f(0.1)
+	f(0.01)
+}
+
+func TestSummarySetSampleRate(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummary("foo")
+
+	// sampleRate=1 records all the observations.
+	sm.SetSampleRate(1)
+	for i := 0; i < 10; i++ {
+		sm.Update(2)
+	}
+	testMarshalTo(t, sm, "prefix", "prefix_sum 20\nprefix_count 10\n")
+
+	// The observations recorded with the previous sample rate must keep their values after the sample rate change.
+	sm.SetSampleRate(1e-12)
+	sm.Update(2)
+	sm.SetSampleRate(1)
+	sm.UpdateWithCount(3, 2)
+	testMarshalTo(t, sm, "prefix", "prefix_sum 26\nprefix_count 12\n")
+
+	// Reset must drop the sampled observations.
+	sm.SetSampleRate(0.5)
+	sm.UpdateBatch([]float64{1, 2, 3, 4})
+	sm.reset()
+	testMarshalTo(t, sm, "prefix", "")
+
+	for _, sampleRate := range []float64{0, -1, 1.5, math.NaN()} {
+		expectPanic(t, fmt.Sprintf("sampleRate=%v", sampleRate), func() {
+			sm.SetSampleRate(sampleRate)
+		})
+	}
+}
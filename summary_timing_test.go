@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkSummaryUpdate(b *testing.B) {
+	for _, sampleRate := range []float64{1, 0.1, 0.01} {
+		b.Run(fmt.Sprintf("sampleRate_%g", sampleRate), func(b *testing.B) {
+			s := NewSet()
+			sm := s.NewSummary("foo")
+			sm.SetSampleRate(sampleRate)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					sm.Update(float64(i))
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSummaryUpdateBackend(b *testing.B) {
+	for _, backend := range []SummaryBackend{SummaryBackendHistogram, SummaryBackendTDigest} {
+		b.Run(backend.String(), func(b *testing.B) {
+			s := NewSet()
+			sm := s.NewSummaryOpt(SummaryOpts{
+				Name:    "foo",
+				Backend: backend,
+			})
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					sm.Update(float64(i))
+					i++
+				}
+			})
+		})
+	}
+}
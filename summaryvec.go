@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"time"
+)
+
+// SummaryVec is a collection of summaries with the same name, the same window and quantiles
+// and distinct values for the given label names.
+//
+// SummaryVec is safe to use from concurrent goroutines.
+type SummaryVec struct {
+	mv *metricVec
+}
+
+// NewSummaryVec returns new SummaryVec with the given name and labelNames and default window and quantiles.
+//
+// name must be valid Prometheus-compatible metric name without labels.
+// labelNames must be valid Prometheus-compatible label names.
+//
+// Summaries for the particular label values are created and registered in the default set
+// on the first SummaryVec.WithLabelValues call.
+func NewSummaryVec(name string, labelNames []string) *SummaryVec {
+	return defaultSet.NewSummaryVec(name, labelNames)
+}
+
+// NewSummaryVecExt returns new SummaryVec with the given name, window, quantiles and labelNames.
+//
+// All the summaries in the returned SummaryVec share the given window and quantiles.
+//
+// See NewSummaryVec for details.
+func NewSummaryVecExt(name string, window time.Duration, quantiles []float64, labelNames []string) *SummaryVec {
+	return defaultSet.NewSummaryVecExt(name, window, quantiles, labelNames)
+}
+
+// NewSummaryVec returns new SummaryVec with the given name and labelNames and default window and quantiles,
+// which registers summaries in s.
+//
+// See NewSummaryVec for details.
+func (s *Set) NewSummaryVec(name string, labelNames []string) *SummaryVec {
+	return s.NewSummaryVecExt(name, defaultSummaryWindow, defaultSummaryQuantiles, labelNames)
+}
+
+// NewSummaryVecExt returns new SummaryVec with the given name, window, quantiles and labelNames,
+// which registers summaries in s.
+//
+// See NewSummaryVecExt for details.
+func (s *Set) NewSummaryVecExt(name string, window time.Duration, quantiles []float64, labelNames []string) *SummaryVec {
+	validateSummaryWindow(window)
+	validateQuantiles(quantiles)
+	quantiles = append([]float64{}, quantiles...)
+	newMetric := func(name string) metric {
+		return s.NewSummaryExt(name, window, quantiles)
+	}
+	return &SummaryVec{
+		mv: newMetricVec(s, name, labelNames, newMetric),
+	}
+}
+
+// WithLabelValues returns the summary for the given label values.
+//
+// The number of values must match the number of label names passed to NewSummaryVec.
+// The summary is created and registered on the first call for the given values.
+// Subsequent calls for the same values return the same summary without memory allocations.
+func (sv *SummaryVec) WithLabelValues(values ...string) *Summary {
+	return sv.mv.withLabelValues(values).(*Summary)
+}
+
+// DeleteLabelValues unregisters the summary for the given label values together with its
+// `_sum`, `_count` and `quantile` series.
+//
+// True is returned if the summary has been unregistered.
+func (sv *SummaryVec) DeleteLabelValues(values ...string) bool {
+	return sv.mv.deleteLabelValues(values)
+}
+
+// Reset unregisters all the summaries in sv together with their `_sum`, `_count` and `quantile` series.
+//
+// All the series are unregistered atomically, so concurrent WritePrometheus calls
+// never return partially removed summaries.
+func (sv *SummaryVec) Reset() {
+	sv.mv.reset()
+}
+
+// Len returns the number of summaries in sv.
+func (sv *SummaryVec) Len() int {
+	return sv.mv.len()
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSummaryVec(t *testing.T) {
+	s := NewSet()
+	sv := s.NewSummaryVecExt("response_size", time.Minute, []float64{0.5, 1}, []string{"pod"})
+	sv.WithLabelValues("a").Update(10)
+	sv.WithLabelValues("b").Update(20)
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	resultExpected := `response_size{pod="a",quantile="0.5"} 10
+response_size{pod="a",quantile="1"} 10
+response_size_sum{pod="a"} 10
+response_size_count{pod="a"} 1
+response_size{pod="b",quantile="0.5"} 20
+response_size{pod="b",quantile="1"} 20
+response_size_sum{pod="b"} 20
+response_size_count{pod="b"} 1
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	if !sv.DeleteLabelValues("a") {
+		t.Fatalf("expecting the summary to be deleted")
+	}
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if strings.Contains(bb.String(), `pod="a"`) {
+		t.Fatalf("unexpected series for the deleted summary in the output:\n%s", bb.String())
+	}
+
+	sv.Reset()
+	if n := sv.Len(); n != 0 {
+		t.Fatalf("unexpected number of summaries after reset; got %d; want 0", n)
+	}
+	if names := s.ListMetricNames(); len(names) != 0 {
+		t.Fatalf("unexpected metrics after reset: %q", names)
+	}
+	bb.Reset()
+	s.WritePrometheus(&bb)
+	if bb.Len() > 0 {
+		t.Fatalf("unexpected output after reset:\n%s", bb.String())
+	}
+}
+
+func TestSummaryVecResetConcurrent(t *testing.T) {
+	s := NewSet()
+	sv := s.NewSummaryVecExt("foo", time.Minute, []float64{0.5}, []string{"pod"})
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			sv.WithLabelValues("a").Update(1)
+			sv.WithLabelValues("b").Update(1)
+			sv.Reset()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		for _, pod := range []string{`pod="a"`, `pod="b"`} {
+			// Every summary has quantile, _sum and _count series
+			if n := strings.Count(bb.String(), pod); n != 0 && n != 3 {
+				t.Fatalf("unexpected number of series for %s; got %d; want 0 or 3; output:\n%s", pod, n, bb.String())
+			}
+		}
+	}
+	close(stopCh)
+	wg.Wait()
+}
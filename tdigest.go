@@ -0,0 +1,285 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+const defaultTDigestCompression = 100
+
+// tdigest estimates quantiles with the merging t-digest.
+//
+// See https://arxiv.org/abs/1902.04023 . Centroids are merged with k1 and k2 scale functions, so their number
+// is bounded by the compression regardless of the number of samples, while the centroids near the tails stay small.
+// New samples are buffered and merged into the centroids in batches, so adding a sample is amortized O(log(compression)).
+//
+// tdigest isn't safe to use from concurrent goroutines.
+type tdigest struct {
+	compression float64
+
+	// centroids contains merged centroids sorted by mean.
+	centroids []tdigestCentroid
+
+	// buf contains samples, which aren't merged into centroids yet.
+	buf    []tdigestCentroid
+	maxBuf int
+
+	// tmp is used for merging buf into centroids.
+	tmp []tdigestCentroid
+
+	// count is the total weight of centroids and buf.
+	count float64
+	min   float64
+	max   float64
+}
+
+type tdigestCentroid struct {
+	mean   float64
+	weight float64
+}
+
+type tdigestCentroids []tdigestCentroid
+
+func (tc tdigestCentroids) Len() int           { return len(tc) }
+func (tc tdigestCentroids) Less(i, j int) bool { return tc[i].mean < tc[j].mean }
+func (tc tdigestCentroids) Swap(i, j int)      { tc[i], tc[j] = tc[j], tc[i] }
+
+func newTDigest(compression float64) *tdigest {
+	td := &tdigest{
+		compression: compression,
+		maxBuf:      5 * int(math.Ceil(compression)),
+	}
+	td.reset()
+	return td
+}
+
+func (td *tdigest) reset() {
+	td.centroids = td.centroids[:0]
+	td.buf = td.buf[:0]
+	td.count = 0
+	td.min = math.Inf(1)
+	td.max = math.Inf(-1)
+}
+
+// add adds v with the given weight to td.
+//
+// NaN values are ignored.
+func (td *tdigest) add(v, weight float64) {
+	if math.IsNaN(v) || weight <= 0 {
+		return
+	}
+	td.count += weight
+	if v < td.min {
+		td.min = v
+	}
+	if v > td.max {
+		td.max = v
+	}
+	td.appendBuf(tdigestCentroid{
+		mean:   v,
+		weight: weight,
+	})
+}
+
+// addDigest adds all the samples from src to td.
+func (td *tdigest) addDigest(src *tdigest) {
+	if src.count == 0 {
+		return
+	}
+	td.count += src.count
+	if src.min < td.min {
+		td.min = src.min
+	}
+	if src.max > td.max {
+		td.max = src.max
+	}
+	for _, c := range src.centroids {
+		td.appendBuf(c)
+	}
+	for _, c := range src.buf {
+		td.appendBuf(c)
+	}
+}
+
+func (td *tdigest) appendBuf(c tdigestCentroid) {
+	td.buf = append(td.buf, c)
+	if len(td.buf) >= td.maxBuf {
+		td.compress()
+	}
+}
+
+// compress merges buf into centroids.
+func (td *tdigest) compress() {
+	if len(td.buf) == 0 {
+		return
+	}
+	sort.Sort(tdigestCentroids(td.buf))
+
+	// Merge sorted centroids and buf. The merged centroid is closed when its weight reaches the limit
+	// defined by the scale function for its position in the distribution.
+	total := td.count
+	dst := td.tmp[:0]
+	var cur tdigestCentroid
+	weightSoFar := 0.0
+	qLimit := 0.0
+	i, j := 0, 0
+	for i < len(td.centroids) || j < len(td.buf) {
+		var c tdigestCentroid
+		if j >= len(td.buf) || (i < len(td.centroids) && td.centroids[i].mean <= td.buf[j].mean) {
+			c = td.centroids[i]
+			i++
+		} else {
+			c = td.buf[j]
+			j++
+		}
+		if cur.weight == 0 {
+			cur = c
+			qLimit = td.getQLimit(0)
+			continue
+		}
+		if (weightSoFar+cur.weight+c.weight)/total <= qLimit {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		weightSoFar += cur.weight
+		dst = append(dst, cur)
+		qLimit = td.getQLimit(weightSoFar / total)
+		cur = c
+	}
+	dst = append(dst, cur)
+
+	td.tmp = td.centroids[:0]
+	td.centroids = dst
+	td.buf = td.buf[:0]
+}
+
+// getQLimit returns the maximum quantile covered by the centroid starting at quantile q.
+//
+// Every centroid spans at most 1 in k space for both k1 and k2 scale functions:
+//
+//   - k1(q) = compression/(2*pi) * asin(2q-1) keeps centroids small around the median.
+//   - k2(q) = compression/z * log(q/(1-q)) makes centroids exponentially smaller near the tails, so extreme quantiles
+//     such as 0.999 are accurate. The normalizer z = 4*log(count/compression)+24 limits the number of centroids.
+//
+// Every scale function produces up to compression/2 centroids, so their total number is bounded by the compression.
+func (td *tdigest) getQLimit(q float64) float64 {
+	if q <= 0 {
+		// The first sample remains a singleton centroid.
+		return 0
+	}
+	limit := 1.0
+	k1 := td.compression/(2*math.Pi)*math.Asin(2*q-1) + 1
+	if k1 < td.compression/4 {
+		limit = (math.Sin(k1*2*math.Pi/td.compression) + 1) / 2
+	}
+	z := 24.0
+	if td.count > td.compression {
+		z += 4 * math.Log(td.count/td.compression)
+	}
+	k2 := td.compression/z*math.Log(q/(1-q)) + 1
+	if limit2 := 1 / (1 + math.Exp(-k2*z/td.compression)); limit2 < limit {
+		limit = limit2
+	}
+	return limit
+}
+
+// quantile returns the estimated phi-quantile.
+//
+// NaN is returned if td is empty.
+func (td *tdigest) quantile(phi float64) float64 {
+	td.compress()
+	if len(td.centroids) == 0 {
+		return math.NaN()
+	}
+	if phi <= 0 {
+		return td.min
+	}
+	if phi >= 1 {
+		return td.max
+	}
+	v := td.interpolate(phi * td.count)
+	if v < td.min {
+		return td.min
+	}
+	if v > td.max {
+		return td.max
+	}
+	return v
+}
+
+// interpolate returns the value for the given rank.
+//
+// Every centroid is assumed to be centered at its mean, so the rank is interpolated linearly between the centers
+// of the adjacent centroids. The ranks outside the centers are interpolated towards min and max.
+func (td *tdigest) interpolate(rank float64) float64 {
+	cs := td.centroids
+	first := cs[0]
+	if rank < first.weight/2 {
+		return td.min + (first.mean-td.min)*rank/(first.weight/2)
+	}
+	center := first.weight / 2
+	for i := 0; i < len(cs)-1; i++ {
+		a, b := cs[i], cs[i+1]
+		dw := (a.weight + b.weight) / 2
+		if rank < center+dw {
+			return a.mean + (b.mean-a.mean)*(rank-center)/dw
+		}
+		center += dw
+	}
+	last := cs[len(cs)-1]
+	return last.mean + (td.max-last.mean)*(rank-center)/(last.weight/2)
+}
+
+// tdigestSummaryBackend estimates Summary quantiles with t-digest per every half of the summary window.
+//
+// The digests for the current and the previous halves are merged at read time, so quantiles cover the samples
+// observed during the last window in the same way as for the default backend.
+type tdigestSummaryBackend struct {
+	slots [2]*tdigest
+
+	// curr is the index of the slot for new samples.
+	curr int
+
+	// merged is used for merging slots at read time.
+	merged *tdigest
+}
+
+func newTDigestSummaryBackend(compression float64) *tdigestSummaryBackend {
+	return &tdigestSummaryBackend{
+		slots: [2]*tdigest{
+			newTDigest(compression),
+			newTDigest(compression),
+		},
+		merged: newTDigest(compression),
+	}
+}
+
+func (tb *tdigestSummaryBackend) update(v float64) {
+	tb.slots[tb.curr].add(v, 1)
+}
+
+func (tb *tdigestSummaryBackend) updateWithCount(v float64, count uint64) {
+	tb.slots[tb.curr].add(v, float64(count))
+}
+
+func (tb *tdigestSummaryBackend) quantiles(dst, phis []float64) []float64 {
+	td := tb.merged
+	td.reset()
+	td.addDigest(tb.slots[0])
+	td.addDigest(tb.slots[1])
+	for _, phi := range phis {
+		dst = append(dst, td.quantile(phi))
+	}
+	return dst
+}
+
+func (tb *tdigestSummaryBackend) rotate() {
+	tb.curr ^= 1
+	tb.slots[tb.curr].reset()
+}
+
+func (tb *tdigestSummaryBackend) reset() {
+	tb.slots[0].reset()
+	tb.slots[1].reset()
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTDigestAccuracy(t *testing.T) {
+	f := func(name string, generate func(r *rand.Rand) float64) {
+		t.Helper()
+		r := rand.New(rand.NewSource(1))
+		td := newTDigest(defaultTDigestCompression)
+		values := make([]float64, 1e6)
+		for i := range values {
+			v := generate(r)
+			values[i] = v
+			td.add(v, 1)
+		}
+		sort.Float64s(values)
+		for _, phi := range []float64{0.5, 0.99, 0.999} {
+			exact := values[int(phi*float64(len(values)-1))]
+			estimated := td.quantile(phi)
+			relativeError := math.Abs(estimated-exact) / exact
+			if relativeError > 0.01 {
+				t.Fatalf("%s: too big relative error for phi=%v: %v; estimated=%v; exact=%v", name, phi, relativeError, estimated, exact)
+			}
+		}
+		if n := len(td.centroids); n > defaultTDigestCompression {
+			t.Fatalf("%s: too many centroids; got %d; want up to %d", name, n, defaultTDigestCompression)
+		}
+		if n := cap(td.buf); n > 2*td.maxBuf {
+			t.Fatalf("%s: too big buffer; got %d; want up to %d", name, n, 2*td.maxBuf)
+		}
+	}
+
+	// Log-normal distribution is a common model for skewed latencies.
+	f("lognormal", func(r *rand.Rand) float64 {
+		return math.Exp(r.NormFloat64())
+	})
+	f("exponential", func(r *rand.Rand) float64 {
+		return r.ExpFloat64()
+	})
+	f("uniform", func(r *rand.Rand) float64 {
+		return 1 + r.Float64()
+	})
+}
+
+func TestTDigestMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	a := newTDigest(defaultTDigestCompression)
+	b := newTDigest(defaultTDigestCompression)
+	values := make([]float64, 1e5)
+	for i := range values {
+		v := math.Exp(r.NormFloat64())
+		values[i] = v
+		if i%2 == 0 {
+			a.add(v, 1)
+		} else {
+			b.add(v, 1)
+		}
+	}
+	td := newTDigest(defaultTDigestCompression)
+	td.addDigest(a)
+	td.addDigest(b)
+	if td.count != float64(len(values)) {
+		t.Fatalf("unexpected count; got %v; want %d", td.count, len(values))
+	}
+	sort.Float64s(values)
+	for _, phi := range []float64{0.5, 0.99, 0.999} {
+		exact := values[int(phi*float64(len(values)-1))]
+		estimated := td.quantile(phi)
+		if relativeError := math.Abs(estimated-exact) / exact; relativeError > 0.01 {
+			t.Fatalf("too big relative error for phi=%v: %v; estimated=%v; exact=%v", phi, relativeError, estimated, exact)
+		}
+	}
+	if v := td.quantile(0); v != values[0] {
+		t.Fatalf("unexpected min; got %v; want %v", v, values[0])
+	}
+	if v := td.quantile(1); v != values[len(values)-1] {
+		t.Fatalf("unexpected max; got %v; want %v", v, values[len(values)-1])
+	}
+}
+
+func TestTDigestWeighted(t *testing.T) {
+	td := newTDigest(defaultTDigestCompression)
+	td.add(1, 900)
+	td.add(10, 100)
+	if v := td.quantile(0.1); v != 1 {
+		t.Fatalf("unexpected quantile for phi=0.1; got %v; want 1", v)
+	}
+	if v := td.quantile(0.99); v != 10 {
+		t.Fatalf("unexpected quantile for phi=0.99; got %v; want 10", v)
+	}
+}
+
+func TestTDigestEmpty(t *testing.T) {
+	td := newTDigest(defaultTDigestCompression)
+	if v := td.quantile(0.5); !math.IsNaN(v) {
+		t.Fatalf("unexpected quantile for empty digest; got %v; want NaN", v)
+	}
+	td.add(math.NaN(), 1)
+	if v := td.quantile(0.5); !math.IsNaN(v) {
+		t.Fatalf("unexpected quantile after adding NaN; got %v; want NaN", v)
+	}
+	td.add(42, 1)
+	for _, phi := range []float64{0, 0.5, 1} {
+		if v := td.quantile(phi); v != 42 {
+			t.Fatalf("unexpected quantile for phi=%v; got %v; want 42", phi, v)
+		}
+	}
+	td.reset()
+	if v := td.quantile(0.5); !math.IsNaN(v) {
+		t.Fatalf("unexpected quantile after reset; got %v; want NaN", v)
+	}
+}
+
+func TestSummaryTDigestBackend(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummaryOpt(SummaryOpts{
+		Name:      "response_size_bytes",
+		Quantiles: []float64{0.5, 0.999},
+		Backend:   SummaryBackendTDigest,
+	})
+	for i := 1; i <= 1000; i++ {
+		sm.Update(float64(i))
+	}
+	sm.UpdateWithCount(2000, 1000)
+	if n := sm.GetCount(); n != 2000 {
+		t.Fatalf("unexpected count; got %d; want 2000", n)
+	}
+	if v := sm.GetQuantile(0.25); math.Abs(v-500) > 5 {
+		t.Fatalf("unexpected quantile for phi=0.25; got %v; want 500", v)
+	}
+	if v := sm.GetQuantile(0.75); v != 2000 {
+		t.Fatalf("unexpected quantile for phi=0.75; got %v; want 2000", v)
+	}
+
+	var bb strings.Builder
+	s.WritePrometheus(&bb)
+	result := bb.String()
+	for _, line := range []string{
+		`response_size_bytes{quantile="0.5"} `,
+		`response_size_bytes{quantile="0.999"} 2000`,
+		"response_size_bytes_count 2000\n",
+	} {
+		if !strings.Contains(result, line) {
+			t.Fatalf("missing %q in the output\n%s", line, result)
+		}
+	}
+
+	// Samples must be dropped from quantiles after the window passes.
+	sm.mu.Lock()
+	sm.backend.rotate()
+	sm.mu.Unlock()
+	sm.Update(1)
+	if v := sm.GetQuantile(0); v != 1 {
+		t.Fatalf("unexpected min after the rotation; got %v; want 1", v)
+	}
+	sm.mu.Lock()
+	sm.backend.rotate()
+	sm.mu.Unlock()
+	if v := sm.GetQuantile(1); v != 1 {
+		t.Fatalf("unexpected max after the second rotation; got %v; want 1", v)
+	}
+	sm.mu.Lock()
+	sm.backend.rotate()
+	sm.mu.Unlock()
+	if v := sm.GetQuantile(0.5); !math.IsNaN(v) {
+		t.Fatalf("unexpected quantile after the window passed; got %v; want NaN", v)
+	}
+}
+
+func TestSummaryBackendInvalidOpts(t *testing.T) {
+	s := NewSet()
+	expectPanic(t, "negative compression", func() {
+		s.NewSummaryOpt(SummaryOpts{
+			Name:        "foo",
+			Backend:     SummaryBackendTDigest,
+			Compression: -1,
+		})
+	})
+	expectPanic(t, "unsupported backend", func() {
+		s.NewSummaryOpt(SummaryOpts{
+			Name:    "bar",
+			Backend: SummaryBackend(10),
+		})
+	})
+}
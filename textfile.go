@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WriteMetricsToFile writes all the metrics from the default set, all the added sets and metrics writers
+// plus the metrics for the current process enabled in opts to the file at the given path.
+//
+// The file is written in Prometheus text exposition format, so it can be collected by node_exporter textfile collector.
+// See https://github.com/prometheus/node_exporter#textfile-collector
+//
+// The metrics are written to a temporary file in the same directory, which is then atomically renamed to path,
+// so readers never see partially written file. Permissions of the existing file at path are preserved.
+// The temporary file is removed on error.
+func WriteMetricsToFile(path string, opts WritePrometheusOpts) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheusWithOpts(w, opts)
+	}
+	return writeMetricsToFile(path, writeMetrics)
+}
+
+// WriteMetricsToFile writes metrics from s to the file at the given path.
+//
+// See WriteMetricsToFile for details.
+func (s *Set) WriteMetricsToFile(path string) error {
+	return writeMetricsToFile(path, s.WritePrometheus)
+}
+
+// FileWriterOptions is the options for periodic writing of metrics to a file via InitFileWriterWithOptions and Set.InitFileWriter.
+type FileWriterOptions struct {
+	// Optional WaitGroup for waiting until all the file writers created with this WaitGroup are stopped.
+	//
+	// The file isn't written after the WaitGroup is done, so the directory with the file can be safely removed.
+	WaitGroup *sync.WaitGroup
+}
+
+// InitFileWriter sets up periodic writing of globally registered metrics to the file at the given path with the given interval.
+//
+// The metrics are written to path for the first time before returning. An error is returned if this write fails.
+// Errors for subsequent writes are logged.
+//
+// The periodic writing cannot be stopped. Use InitFileWriterWithOptions for stopping the writing when the context is canceled.
+//
+// See WriteMetricsToFile for details.
+func InitFileWriter(path string, interval time.Duration) error {
+	return InitFileWriterWithOptions(context.Background(), path, interval, WritePrometheusOpts{}, nil)
+}
+
+// InitFileWriterWithOptions sets up periodic writing of globally registered metrics plus the metrics for the current process
+// enabled in writeOpts to the file at the given path with the given interval.
+//
+// The periodic writing is stopped when ctx is canceled.
+// It is possible to wait until the background file writer is stopped on a WaitGroup passed via opts.WaitGroup.
+// opts may be nil.
+//
+// See InitFileWriter for details.
+func InitFileWriterWithOptions(ctx context.Context, path string, interval time.Duration, writeOpts WritePrometheusOpts, opts *FileWriterOptions) error {
+	writeMetrics := func(w io.Writer) {
+		WritePrometheusWithOpts(w, writeOpts)
+	}
+	return initFileWriter(ctx, path, interval, writeMetrics, opts)
+}
+
+// InitFileWriter sets up periodic writing of metrics from s to the file at the given path with the given interval.
+//
+// The periodic writing is stopped when ctx is canceled.
+// It is possible to wait until the background file writer is stopped on a WaitGroup passed via opts.WaitGroup.
+// opts may be nil.
+//
+// See InitFileWriter for details.
+func (s *Set) InitFileWriter(ctx context.Context, path string, interval time.Duration, opts *FileWriterOptions) error {
+	return initFileWriter(ctx, path, interval, s.WritePrometheus, opts)
+}
+
+func initFileWriter(ctx context.Context, path string, interval time.Duration, writeMetrics func(w io.Writer), opts *FileWriterOptions) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive; got %s", interval)
+	}
+	if err := writeMetricsToFile(path, writeMetrics); err != nil {
+		return err
+	}
+	var wg *sync.WaitGroup
+	if opts != nil {
+		wg = opts.WaitGroup
+		if wg != nil {
+			wg.Add(1)
+		}
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		stopCh := ctx.Done()
+		for {
+			select {
+			case <-ticker.C:
+				if ctx.Err() != nil {
+					// Do not write the file after ctx is canceled if the ticker fired at the same time.
+					return
+				}
+				if err := writeMetricsToFile(path, writeMetrics); err != nil {
+					log.Printf("ERROR: metrics.file: %s", err)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// writeMetricsToFile atomically writes metrics generated by writeMetrics to the file at the given path.
+func writeMetricsToFile(path string, writeMetrics func(w io.Writer)) error {
+	if path == "" {
+		return fmt.Errorf("path cannot be empty")
+	}
+	bb := getBytesBuffer()
+	defer putBytesBuffer(bb)
+	writeMetrics(bb)
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("cannot obtain information about %q: %w", path, err)
+	}
+
+	// The temporary file name mustn't end with .prom, since node_exporter textfile collector reads all the *.prom files.
+	dir, name := filepath.Split(path)
+	f, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary file for %q: %w", path, err)
+	}
+	tmpPath := f.Name()
+	if err := writeFileSynced(f, bb.B, mode); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("cannot write metrics to %q: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("cannot rename %q to %q: %w", tmpPath, path, err)
+	}
+	return nil
+}
+
+// writeFileSynced writes data to f with the given mode, fsyncs and closes it.
+func writeFileSynced(f *os.File, data []byte, mode os.FileMode) error {
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
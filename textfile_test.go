@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetWriteMetricsToFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "job.prom")
+
+	s := NewSet()
+	c := s.NewCounter("foo_total")
+	c.Add(3)
+	if err := s.WriteMetricsToFile(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectFileContents(t, path, "foo_total 3\n")
+	if runtime.GOOS != "windows" {
+		expectFileMode(t, path, 0644)
+
+		// Permissions of the existing file must be preserved.
+		if err := os.Chmod(path, 0600); err != nil {
+			t.Fatalf("cannot change file permissions: %s", err)
+		}
+	}
+	c.Inc()
+	if err := s.WriteMetricsToFile(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectFileContents(t, path, "foo_total 4\n")
+	if runtime.GOOS != "windows" {
+		expectFileMode(t, path, 0600)
+	}
+	expectNoTempFiles(t, dir)
+}
+
+func TestSetWriteMetricsToFileFailure(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSet()
+	s.NewCounter("foo_total")
+
+	f := func(path string) {
+		t.Helper()
+		if err := s.WriteMetricsToFile(path); err == nil {
+			t.Fatalf("expecting non-nil error for path %q", path)
+		}
+		expectNoTempFiles(t, dir)
+	}
+	f("")
+	f(filepath.Join(dir, "missing", "job.prom"))
+
+	// Rename over the existing directory must fail and the temporary file must be removed.
+	dirPath := filepath.Join(dir, "dir.prom")
+	if err := os.Mkdir(dirPath, 0755); err != nil {
+		t.Fatalf("cannot create directory: %s", err)
+	}
+	if err := s.WriteMetricsToFile(dirPath); err == nil {
+		t.Fatalf("expecting non-nil error for directory path")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("cannot read directory: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "dir.prom" {
+		t.Fatalf("unexpected files left in %q: %v", dir, entries)
+	}
+}
+
+func TestSetInitFileWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "daemon.prom")
+
+	s := NewSet()
+	c := s.NewCounter("foo_total")
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	opts := &FileWriterOptions{
+		WaitGroup: &wg,
+	}
+	// Wait until the background writer is stopped, so it doesn't create files in dir during its cleanup.
+	defer wg.Wait()
+	defer cancel()
+
+	if err := s.InitFileWriter(ctx, path, 0, opts); err == nil {
+		t.Fatalf("expecting non-nil error for zero interval")
+	}
+	if err := s.InitFileWriter(ctx, path, 10*time.Millisecond, opts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The file must be written before InitFileWriter returns.
+	expectFileContents(t, path, "foo_total 0\n")
+
+	c.Inc()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && string(data) == "foo_total 1\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for the updated file; last contents: %q; error: %v", data, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The file mustn't be written after the writer is stopped.
+	cancel()
+	wg.Wait()
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("cannot remove %q: %s", path, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("unexpected file %q after the writer is stopped; error: %v", path, err)
+	}
+}
+
+func expectFileContents(t *testing.T, path, contentsExpected string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read %q: %s", path, err)
+	}
+	if string(data) != contentsExpected {
+		t.Fatalf("unexpected contents of %q; got %q; want %q", path, data, contentsExpected)
+	}
+}
+
+func expectFileMode(t *testing.T, path string, modeExpected os.FileMode) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("cannot stat %q: %s", path, err)
+	}
+	if mode := fi.Mode().Perm(); mode != modeExpected {
+		t.Fatalf("unexpected mode for %q; got %o; want %o", path, mode, modeExpected)
+	}
+}
+
+func expectNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(matches) > 0 {
+		t.Fatalf("unexpected temporary files: %q", matches)
+	}
+}
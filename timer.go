@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Timer measures the duration of a code block and updates the corresponding metric with the measured duration in seconds.
+//
+// Timer is a value type, so it can be used on hot paths without memory allocations:
+//
+//	t := h.NewTimer()
+//	doWork()
+//	t.ObserveDuration()
+//
+// Timer must be created via NewTimer method of the corresponding metric.
+type Timer struct {
+	startTime time.Time
+	u         durationObserver
+}
+
+// durationObserver is implemented by metrics, which can observe durations.
+type durationObserver interface {
+	Update(v float64)
+}
+
+func newTimer(u durationObserver) Timer {
+	return Timer{
+		startTime: time.Now(),
+		u:         u,
+	}
+}
+
+// ObserveDuration updates the metric with the duration in seconds since the Timer creation.
+//
+// Every ObserveDuration call records a new duration. So calling ObserveDuration twice records two durations.
+//
+// The observed duration is returned in seconds.
+func (t Timer) ObserveDuration() float64 {
+	d := time.Since(t.startTime).Seconds()
+	t.u.Update(d)
+	return d
+}
+
+// NewTimer returns Timer for measuring the duration of a code block in seconds.
+//
+// Call ObserveDuration on the returned Timer for updating h with the measured duration.
+func (h *Histogram) NewTimer() Timer {
+	return newTimer(h)
+}
+
+// StartTimer starts measuring the duration of a code block and returns a function, which updates h with the measured duration in seconds.
+//
+// It is convenient to use with defer:
+//
+//	defer h.StartTimer()()
+//
+// Use NewTimer on hot paths, since the returned function requires a memory allocation.
+func (h *Histogram) StartTimer() func() {
+	t := h.NewTimer()
+	return func() {
+		t.ObserveDuration()
+	}
+}
+
+// NewTimer returns Timer for measuring the duration of a code block in seconds.
+//
+// Call ObserveDuration on the returned Timer for updating sm with the measured duration.
+func (sm *Summary) NewTimer() Timer {
+	return newTimer(sm)
+}
+
+// StartTimer starts measuring the duration of a code block and returns a function, which updates sm with the measured duration in seconds.
+//
+// See Histogram.StartTimer for details.
+func (sm *Summary) StartTimer() func() {
+	t := sm.NewTimer()
+	return func() {
+		t.ObserveDuration()
+	}
+}
+
+// NewTimer returns Timer for measuring the duration of a code block in seconds.
+//
+// Call ObserveDuration on the returned Timer for updating ph with the measured duration.
+func (ph *PrometheusHistogram) NewTimer() Timer {
+	return newTimer(ph)
+}
+
+// StartTimer starts measuring the duration of a code block and returns a function, which updates ph with the measured duration in seconds.
+//
+// See Histogram.StartTimer for details.
+func (ph *PrometheusHistogram) StartTimer() func() {
+	t := ph.NewTimer()
+	return func() {
+		t.ObserveDuration()
+	}
+}
+
+// UpdateDurationSince updates h with the duration in seconds since the given start time.
+//
+// It is equivalent to h.UpdateDuration(start).
+func UpdateDurationSince(h *Histogram, start time.Time) {
+	h.UpdateDuration(start)
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramTimer(t *testing.T) {
+	h := &Histogram{}
+	tm := h.NewTimer()
+	time.Sleep(10 * time.Millisecond)
+	d := tm.ObserveDuration()
+	if d < 0.01 {
+		t.Fatalf("unexpected duration; got %v; want at least 0.01", d)
+	}
+	if sum := h.GetSum(); sum != d {
+		t.Fatalf("unexpected histogram sum; got %v; want %v", sum, d)
+	}
+
+	// The second ObserveDuration call records the duration again
+	d2 := tm.ObserveDuration()
+	if d2 < d {
+		t.Fatalf("unexpected duration on the second call; got %v; want at least %v", d2, d)
+	}
+	if sum := h.GetSum(); sum != d+d2 {
+		t.Fatalf("unexpected histogram sum; got %v; want %v", sum, d+d2)
+	}
+}
+
+func TestHistogramStartTimer(t *testing.T) {
+	h := &Histogram{}
+	func() {
+		defer h.StartTimer()()
+		time.Sleep(10 * time.Millisecond)
+	}()
+	if sum := h.GetSum(); sum < 0.01 {
+		t.Fatalf("unexpected histogram sum; got %v; want at least 0.01", sum)
+	}
+}
+
+func TestSummaryTimer(t *testing.T) {
+	s := NewSet()
+	sm := s.NewSummary("foo")
+	tm := sm.NewTimer()
+	d := tm.ObserveDuration()
+	func() {
+		defer sm.StartTimer()()
+	}()
+	if n := sm.GetCount(); n != 2 {
+		t.Fatalf("unexpected summary count; got %d; want 2", n)
+	}
+	if sum := sm.GetSum(); sum < d {
+		t.Fatalf("unexpected summary sum; got %v; want at least %v", sum, d)
+	}
+}
+
+func TestPrometheusHistogramTimer(t *testing.T) {
+	s := NewSet()
+	ph := s.NewHistogramWithBuckets("foo", []float64{3600})
+	ph.NewTimer().ObserveDuration()
+	func() {
+		defer ph.StartTimer()()
+	}()
+	var bb bytes.Buffer
+	ph.marshalTo("foo", &bb)
+	if !strings.Contains(bb.String(), "foo_bucket{le=\"3600\"} 2\n") || !strings.HasSuffix(bb.String(), "foo_count 2\n") {
+		t.Fatalf("unexpected histogram output:\n%s", bb.String())
+	}
+}
+
+func TestUpdateDurationSince(t *testing.T) {
+	h := &Histogram{}
+	UpdateDurationSince(h, time.Now().Add(-time.Second))
+	if sum := h.GetSum(); sum < 1 {
+		t.Fatalf("unexpected histogram sum; got %v; want at least 1", sum)
+	}
+}
+
+func TestTimerNoAllocs(t *testing.T) {
+	h := &Histogram{}
+	n := testing.AllocsPerRun(100, func() {
+		tm := h.NewTimer()
+		tm.ObserveDuration()
+	})
+	if n != 0 {
+		t.Fatalf("unexpected number of allocations; got %v; want 0", n)
+	}
+}
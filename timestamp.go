@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+// metricTimestamp holds an optional explicit timestamp for the metric sample.
+//
+// Zero metricTimestamp is usable. It means the sample has no explicit timestamp,
+// so the scraper assigns the scrape time to it.
+type metricTimestamp struct {
+	// ms contains the timestamp in milliseconds since Unix epoch. It is set to 0 if the timestamp is missing.
+	ms atomicInt64
+}
+
+func (mt *metricTimestamp) store(ts time.Time) {
+	ms := int64(0)
+	if !ts.IsZero() {
+		ms = ts.UnixNano() / 1e6
+	}
+	mt.ms.Store(ms)
+}
+
+func (mt *metricTimestamp) load() int64 {
+	return mt.ms.Load()
+}
+
+// appendPrometheus appends the timestamp in milliseconds with the leading space to dst
+// according to Prometheus text exposition format.
+//
+// Nothing is appended if the timestamp is missing.
+func (mt *metricTimestamp) appendPrometheus(dst []byte) []byte {
+	ms := mt.load()
+	if ms == 0 {
+		return dst
+	}
+	dst = append(dst, ' ')
+	return strconv.AppendInt(dst, ms, 10)
+}
+
+// appendOpenMetrics appends the timestamp in seconds with the leading space to dst according to OpenMetrics text format.
+//
+// Nothing is appended if the timestamp is missing.
+func (mt *metricTimestamp) appendOpenMetrics(dst []byte) []byte {
+	ms := mt.load()
+	if ms == 0 {
+		return dst
+	}
+	dst = append(dst, ' ')
+	return strconv.AppendFloat(dst, float64(ms)/1e3, 'f', -1, 64)
+}
+
+// timestampedMetric must be implemented by metrics, which support explicit timestamps.
+type timestampedMetric interface {
+	getTimestamp() *metricTimestamp
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMetricTimestamp(t *testing.T) {
+	f := func(s *Set, prometheusExpected, openMetricsExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		s.WritePrometheus(&bb)
+		if result := bb.String(); result != prometheusExpected {
+			t.Fatalf("unexpected Prometheus output; got\n%s\nwant\n%s", result, prometheusExpected)
+		}
+		bb.Reset()
+		s.WriteOpenMetrics(&bb)
+		if result := bb.String(); result != openMetricsExpected {
+			t.Fatalf("unexpected OpenMetrics output; got\n%s\nwant\n%s", result, openMetricsExpected)
+		}
+	}
+
+	ts := time.Unix(1700000000, 123456789)
+
+	s := NewSet()
+	c := s.NewCounter(`requests_total{path="/foo"}`)
+	g := s.NewGauge("temperature", nil)
+
+	// Metrics without timestamps
+	c.Set(10)
+	g.Set(1.5)
+	f(s, `requests_total{path="/foo"} 10
+temperature 1.5
+`, `# TYPE requests counter
+requests_total{path="/foo"} 10
+# TYPE temperature gauge
+temperature 1.5
+# EOF
+`)
+
+	// Metrics with timestamps
+	c.SetWithTimestamp(20, ts)
+	g.SetWithTimestamp(-2, ts.Add(time.Second))
+	f(s, `requests_total{path="/foo"} 20 1700000000123
+temperature -2 1700000001123
+`, `# TYPE requests counter
+requests_total{path="/foo"} 20 1700000000.123
+# TYPE temperature gauge
+temperature -2 1700000001.123
+# EOF
+`)
+
+	// The timestamp is retained on subsequent updates
+	c.Inc()
+	g.Set(3.25)
+	f(s, `requests_total{path="/foo"} 21 1700000000123
+temperature 3.25 1700000001123
+`, `# TYPE requests counter
+requests_total{path="/foo"} 21 1700000000.123
+# TYPE temperature gauge
+temperature 3.25 1700000001.123
+# EOF
+`)
+
+	// Clear timestamps
+	c.ClearTimestamp()
+	if err := s.SetMetricTimestamp("temperature", time.Time{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(s, `requests_total{path="/foo"} 21
+temperature 3.25
+`, `# TYPE requests counter
+requests_total{path="/foo"} 21
+# TYPE temperature gauge
+temperature 3.25
+# EOF
+`)
+
+	// Set timestamp via SetMetricTimestamp
+	if err := s.SetMetricTimestamp(`requests_total{path="/foo"}`, ts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(s, `requests_total{path="/foo"} 21 1700000000123
+temperature 3.25
+`, `# TYPE requests counter
+requests_total{path="/foo"} 21 1700000000.123
+# TYPE temperature gauge
+temperature 3.25
+# EOF
+`)
+
+	// The timestamp precedes the exemplar in OpenMetrics output
+	if err := c.IncWithExemplar(map[string]string{"trace_id": "abc"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.loadExt().exemplar.load().timestamp = ts
+	g.ClearTimestamp()
+	f(s, `requests_total{path="/foo"} 22 1700000000123
+temperature 3.25
+`, `# TYPE requests counter
+requests_total{path="/foo"} 22 1700000000.123 # {trace_id="abc"} 1 1700000000.123
+# TYPE temperature gauge
+temperature 3.25
+# EOF
+`)
+}
+
+func TestSetMetricTimestampFailure(t *testing.T) {
+	s := NewSet()
+	s.NewHistogram("foo")
+
+	// Missing metric
+	if err := s.SetMetricTimestamp("bar", time.Now()); err == nil {
+		t.Fatalf("expecting non-nil error for missing metric")
+	}
+
+	// Unsupported metric type
+	if err := s.SetMetricTimestamp("foo", time.Now()); err == nil {
+		t.Fatalf("expecting non-nil error for histogram")
+	}
+}
@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// GetOrCreateCounterWithTTL returns registered counter with the given name in the default set
+// or creates new counter if the registry doesn't contain counter with the given name.
+//
+// Every call updates the last access time for the counter. The counter is unregistered
+// if it isn't accessed via GetOrCreateCounterWithTTL during ttl. This is useful for metrics
+// with label values, which may disappear over time, such as per-client or per-URL metrics.
+//
+// Expired metrics are unregistered when the metrics are written to the output, e.g. via WritePrometheus,
+// or when ExpireMetricsNow is called. The previously returned counter remains usable after it is unregistered,
+// but its updates are no longer exposed. The next GetOrCreateCounterWithTTL call registers new counter.
+//
+// name must be valid Prometheus-compatible metric with possible labels.
+// ttl must be positive.
+func GetOrCreateCounterWithTTL(name string, ttl time.Duration) *Counter {
+	return defaultSet.GetOrCreateCounterWithTTL(name, ttl)
+}
+
+// GetOrCreateGaugeWithTTL returns registered gauge with the given name in the default set
+// or creates new gauge if the registry doesn't contain gauge with the given name.
+//
+// See GetOrCreateCounterWithTTL for details on ttl and GetOrCreateGauge for details on f.
+func GetOrCreateGaugeWithTTL(name string, f func() float64, ttl time.Duration) *Gauge {
+	return defaultSet.GetOrCreateGaugeWithTTL(name, f, ttl)
+}
+
+// GetOrCreateHistogramWithTTL returns registered histogram with the given name in the default set
+// or creates new histogram if the registry doesn't contain histogram with the given name.
+//
+// See GetOrCreateCounterWithTTL for details on ttl.
+func GetOrCreateHistogramWithTTL(name string, ttl time.Duration) *Histogram {
+	return defaultSet.GetOrCreateHistogramWithTTL(name, ttl)
+}
+
+// GetOrCreateSummaryWithTTL returns registered summary with the given name in the default set
+// or creates new summary if the registry doesn't contain summary with the given name.
+//
+// See GetOrCreateCounterWithTTL for details on ttl.
+func GetOrCreateSummaryWithTTL(name string, ttl time.Duration) *Summary {
+	return defaultSet.GetOrCreateSummaryWithTTL(name, ttl)
+}
+
+// ExpireMetricsNow unregisters metrics with expired TTL from the default set.
+//
+// Usually there is no need in calling this function, since expired metrics are unregistered
+// when the metrics are written to the output. It is mostly useful in tests.
+func ExpireMetricsNow() {
+	defaultSet.ExpireMetricsNow()
+}
+
+// GetOrCreateCounterWithTTL returns registered counter with the given name in s
+// or creates new counter if s doesn't contain counter with the given name.
+//
+// See GetOrCreateCounterWithTTL for details.
+func (s *Set) GetOrCreateCounterWithTTL(name string, ttl time.Duration) *Counter {
+	validateTTL(ttl)
+	for {
+		c := s.GetOrCreateCounter(name)
+		if s.touchMetric(name, c, ttl) {
+			return c
+		}
+	}
+}
+
+// GetOrCreateGaugeWithTTL returns registered gauge with the given name in s
+// or creates new gauge if s doesn't contain gauge with the given name.
+//
+// See GetOrCreateGaugeWithTTL for details.
+func (s *Set) GetOrCreateGaugeWithTTL(name string, f func() float64, ttl time.Duration) *Gauge {
+	validateTTL(ttl)
+	for {
+		g := s.GetOrCreateGauge(name, f)
+		if s.touchMetric(name, g, ttl) {
+			return g
+		}
+	}
+}
+
+// GetOrCreateHistogramWithTTL returns registered histogram with the given name in s
+// or creates new histogram if s doesn't contain histogram with the given name.
+//
+// See GetOrCreateHistogramWithTTL for details.
+func (s *Set) GetOrCreateHistogramWithTTL(name string, ttl time.Duration) *Histogram {
+	validateTTL(ttl)
+	for {
+		h := s.GetOrCreateHistogram(name)
+		if s.touchMetric(name, h, ttl) {
+			return h
+		}
+	}
+}
+
+// GetOrCreateSummaryWithTTL returns registered summary with the given name in s
+// or creates new summary if s doesn't contain summary with the given name.
+//
+// See GetOrCreateSummaryWithTTL for details.
+func (s *Set) GetOrCreateSummaryWithTTL(name string, ttl time.Duration) *Summary {
+	validateTTL(ttl)
+	for {
+		sm := s.GetOrCreateSummary(name)
+		if s.touchMetric(name, sm, ttl) {
+			return sm
+		}
+	}
+}
+
+// ExpireMetricsNow unregisters metrics with expired TTL from s.
+//
+// See ExpireMetricsNow for details.
+func (s *Set) ExpireMetricsNow() {
+	s.mu.Lock()
+	s.expireMetricsLocked(clockNow())
+	s.mu.Unlock()
+}
+
+func validateTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		panic(fmt.Errorf("BUG: ttl must be positive; got %s", ttl))
+	}
+}
+
+// touchMetric updates the last access time and sets ttl for the metric m with the given name.
+//
+// It returns false if m has been unregistered concurrently, so the caller must obtain the metric again.
+// It returns true without touching m if m isn't registered because of the limit set via SetMaxMetrics.
+func (s *Set) touchMetric(name string, m metric, ttl time.Duration) bool {
+	// Fast path - the metric already has the given ttl, so only its last access time must be updated.
+	s.mu.RLock()
+	nm := s.getNamedMetricLocked(name)
+	if nm != nil && nm.metric == m && nm.ttl == ttl {
+		nm.lastAccessTime.Store(clockNow().UnixNano())
+		s.mu.RUnlock()
+		return true
+	}
+	s.mu.RUnlock()
+
+	// Slow path - mark the metric as a metric with ttl.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nm = s.getNamedMetricLocked(name)
+	if nm == nil && s.isMetricsLimitReachedLocked() {
+		// m is the overflow metric, which cannot be registered because of the limit set via SetMaxMetrics.
+		return true
+	}
+	if nm == nil || nm.metric != m {
+		return false
+	}
+	nm.ttl = ttl
+	nm.lastAccessTime.Store(clockNow().UnixNano())
+	s.hasTTLMetrics = true
+	return true
+}
+
+// expireMetricsLocked unregisters metrics, which weren't accessed during their ttl until now.
+func (s *Set) expireMetricsLocked(now time.Time) {
+	var expired []*namedMetric
+	for _, nm := range s.a {
+		if nm.ttl > 0 && now.Sub(time.Unix(0, nm.lastAccessTime.Load())) > nm.ttl {
+			expired = append(expired, nm)
+		}
+	}
+	for _, nm := range expired {
+		s.unregisterMetricLocked(nm)
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetGetOrCreateWithTTL(t *testing.T) {
+	s := NewSet()
+	c := s.GetOrCreateCounterWithTTL(`counter{client="a"}`, time.Nanosecond)
+	c.Inc()
+	s.GetOrCreateGaugeWithTTL(`gauge{client="a"}`, nil, time.Nanosecond).Set(2)
+	s.GetOrCreateHistogramWithTTL(`histogram{client="a"}`, time.Nanosecond).Update(1)
+	s.GetOrCreateSummaryWithTTL(`summary{client="a"}`, time.Nanosecond).Update(1)
+	s.GetOrCreateCounter("counter_without_ttl").Inc()
+	s.GetOrCreateCounterWithTTL("counter_long_ttl", time.Hour).Inc()
+
+	// Make sure the metrics become expired.
+	time.Sleep(time.Millisecond)
+	s.ExpireMetricsNow()
+
+	names := s.ListMetricNames()
+	namesExpected := []string{"counter_long_ttl", "counter_without_ttl"}
+	if strings.Join(names, ",") != strings.Join(namesExpected, ",") {
+		t.Fatalf("unexpected metric names after expiration; got %q; want %q", names, namesExpected)
+	}
+
+	// The expired counter remains usable, but isn't exposed.
+	c.Inc()
+	if n := c.Get(); n != 2 {
+		t.Fatalf("unexpected counter value; got %d; want 2", n)
+	}
+
+	// The next call registers new counter.
+	cNew := s.GetOrCreateCounterWithTTL(`counter{client="a"}`, time.Hour)
+	if cNew == c {
+		t.Fatalf("expecting new counter after expiration")
+	}
+	if n := cNew.Get(); n != 0 {
+		t.Fatalf("unexpected value for new counter; got %d; want 0", n)
+	}
+	if cNew != s.GetOrCreateCounterWithTTL(`counter{client="a"}`, time.Hour) {
+		t.Fatalf("expecting the same counter for the subsequent call")
+	}
+}
+
+func TestSetWritePrometheusExpiresMetrics(t *testing.T) {
+	s := NewSet()
+	s.GetOrCreateCounterWithTTL("foo", time.Nanosecond).Inc()
+	time.Sleep(time.Millisecond)
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	if result := bb.String(); result != "" {
+		t.Fatalf("expecting empty output after expiration; got\n%s", result)
+	}
+}
+
+func TestGetOrCreateWithTTLInvalidTTL(t *testing.T) {
+	s := NewSet()
+	expectPanic(t, "GetOrCreateCounterWithTTL", func() {
+		s.GetOrCreateCounterWithTTL("foo", 0)
+	})
+	expectPanic(t, "GetOrCreateSummaryWithTTL", func() {
+		s.GetOrCreateSummaryWithTTL("foo", -time.Second)
+	})
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// SetStrictUnitNames enables or disables strict validation of metric names against their units globally.
+//
+// If enabled, the base name of the metric registered with the unit via CounterOpts.Unit, GaugeOpts.Unit, HistogramOpts.Unit
+// or SummaryOpts.Unit must end with `_<unit>` suffix according to OpenMetrics naming conventions. Counter names
+// may have `_total` suffix after the unit suffix. For example, `request_duration_seconds` and `sent_bytes_total`
+// are valid names for `seconds` and `bytes` units. New*Opt functions panic with the message explaining the expected name
+// if the name doesn't match the unit. The message also suggests the base unit if the unit isn't a base unit - see NormalizeUnit.
+//
+// Strict validation is disabled by default. In this case the `# UNIT` line is written in OpenMetrics output only
+// for metric families with names ending with the unit suffix, since OpenMetrics parsers reject other metric families.
+func SetStrictUnitNames(v bool) {
+	storeBool(&strictUnitNames, v)
+}
+
+var strictUnitNames uint32
+
+func isStrictUnitNamesEnabled() bool {
+	return atomic.LoadUint32(&strictUnitNames) != 0
+}
+
+// NormalizeUnit returns the base unit recommended by Prometheus naming conventions for the given unit.
+//
+// Common abbreviations and singular forms are mapped to base units. For example, "s" and "second" are mapped to "seconds",
+// while "byte" is mapped to "bytes". The unit is returned as is if it is unknown.
+//
+// The returned warning is non-empty if values in the given unit must be converted before exposing them in the base unit.
+// For example, NormalizeUnit("ms") returns "seconds" and the warning about dividing values by 1000.
+func NormalizeUnit(unit string) (string, string) {
+	u, ok := unitAliases[strings.ToLower(unit)]
+	if !ok {
+		return unit, ""
+	}
+	if u.conversion == "" {
+		return u.base, ""
+	}
+	return u.base, fmt.Sprintf("unit %q isn't a base unit; values must be %s in order to be exposed in %q", unit, u.conversion, u.base)
+}
+
+type unitAlias struct {
+	base       string
+	conversion string
+}
+
+var unitAliases = func() map[string]unitAlias {
+	m := make(map[string]unitAlias)
+	add := func(base, conversion string, aliases ...string) {
+		for _, alias := range aliases {
+			m[alias] = unitAlias{
+				base:       base,
+				conversion: conversion,
+			}
+		}
+	}
+
+	add("seconds", "", "seconds", "second", "sec", "secs", "s")
+	add("seconds", "divided by 1000", "milliseconds", "millisecond", "msec", "msecs", "ms")
+	add("seconds", "divided by 1e6", "microseconds", "microsecond", "usec", "usecs", "us", "µs")
+	add("seconds", "divided by 1e9", "nanoseconds", "nanosecond", "nsec", "nsecs", "ns")
+	add("seconds", "multiplied by 60", "minutes", "minute", "min", "mins")
+	add("seconds", "multiplied by 3600", "hours", "hour", "h", "hr", "hrs")
+	add("seconds", "multiplied by 86400", "days", "day")
+
+	add("bytes", "", "bytes", "byte", "b")
+	add("bytes", "multiplied by 1000", "kilobytes", "kilobyte", "kb")
+	add("bytes", "multiplied by 1024", "kibibytes", "kibibyte", "kib")
+	add("bytes", "multiplied by 1e6", "megabytes", "megabyte", "mb")
+	add("bytes", "multiplied by 1048576", "mebibytes", "mebibyte", "mib")
+	add("bytes", "multiplied by 1e9", "gigabytes", "gigabyte", "gb")
+	add("bytes", "multiplied by 1073741824", "gibibytes", "gibibyte", "gib")
+
+	add("ratio", "", "ratio", "ratios")
+	add("ratio", "divided by 100", "percent", "percents", "percentage", "pct", "%")
+
+	add("meters", "", "meters", "meter", "metres", "metre")
+	add("grams", "", "grams", "gram")
+	add("volts", "", "volts", "volt")
+	add("amperes", "", "amperes", "ampere", "amps", "amp")
+	add("joules", "", "joules", "joule")
+	add("celsius", "", "celsius")
+	return m
+}()
+
+// validateUnit returns an error if unit cannot be used for the metric with the given name and type.
+//
+// The name suffix is verified only if strict validation is enabled via SetStrictUnitNames.
+func validateUnit(name, metricType, unit string) error {
+	if unit == "" {
+		return nil
+	}
+	if !isValidUnit(unit) {
+		return fmt.Errorf("invalid unit %q; it may contain only [a-zA-Z0-9_:] chars", unit)
+	}
+	if !isStrictUnitNamesEnabled() {
+		return nil
+	}
+	metricName, _ := splitMetricName(name)
+	if hasUnitSuffix(metricName, metricType, unit) {
+		return nil
+	}
+	expectedName := strings.TrimSuffix(metricName, "_total") + "_" + unit
+	if metricType == "counter" {
+		expectedName += "_total"
+	}
+	msg := fmt.Sprintf("metric name %q must end with %q suffix for %q unit; for example, %q", metricName, "_"+unit, unit, expectedName)
+	if base, warning := NormalizeUnit(unit); base != unit {
+		if warning == "" {
+			warning = fmt.Sprintf("%q is recommended instead of %q unit", base, unit)
+		}
+		msg += "; " + warning
+	}
+	return errors.New(msg)
+}
+
+func isValidUnit(unit string) bool {
+	for i := 0; i < len(unit); i++ {
+		if !isLegacyNameChar(unit[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasUnitSuffix returns true if metricName ends with the suffix for the given unit.
+//
+// `_total` suffix is allowed after the unit suffix for counters.
+func hasUnitSuffix(metricName, metricType, unit string) bool {
+	if metricType == "counter" {
+		metricName = strings.TrimSuffix(metricName, "_total")
+	}
+	return strings.HasSuffix(metricName, "_"+unit)
+}
+
+// getMetricFamilyUnit returns the unit for the metricFamily starting at the sorted sa.
+func getMetricFamilyUnit(sa []*namedMetric, metricFamily string) string {
+	for _, nm := range sa {
+		if getMetricFamily(nm.name) != metricFamily {
+			break
+		}
+		if nm.unit != "" {
+			return nm.unit
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUnit(t *testing.T) {
+	f := func(unit, baseExpected string, hasWarning bool) {
+		t.Helper()
+		base, warning := NormalizeUnit(unit)
+		if base != baseExpected {
+			t.Fatalf("unexpected base unit for %q; got %q; want %q", unit, base, baseExpected)
+		}
+		if (warning != "") != hasWarning {
+			t.Fatalf("unexpected warning for %q: %q", unit, warning)
+		}
+	}
+	f("seconds", "seconds", false)
+	f("Second", "seconds", false)
+	f("s", "seconds", false)
+	f("ms", "seconds", true)
+	f("ns", "seconds", true)
+	f("byte", "bytes", false)
+	f("KiB", "bytes", true)
+	f("percent", "ratio", true)
+	f("ratio", "ratio", false)
+	f("requests", "requests", false)
+}
+
+func TestSetStrictUnitNames(t *testing.T) {
+	SetStrictUnitNames(true)
+	defer SetStrictUnitNames(false)
+
+	s := NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: "sent_bytes_total",
+		Unit: "bytes",
+	})
+	s.NewGaugeOpt(GaugeOpts{
+		Name: `temperature_celsius{room="a"}`,
+		Unit: "celsius",
+	}, nil)
+	s.NewHistogramOpt(HistogramOpts{
+		Name: "request_duration_seconds",
+		Unit: "seconds",
+	})
+	s.NewSummaryOpt(SummaryOpts{
+		Name: "response_size_bytes",
+		Unit: "bytes",
+	})
+
+	f := func(name, unit, msgExpected string) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			r := recover()
+			if r == nil {
+				t.Fatalf("expecting panic for %q with unit %q", name, unit)
+			}
+			if msg := r.(error).Error(); !strings.Contains(msg, msgExpected) {
+				t.Fatalf("unexpected panic message for %q with unit %q; got %q; want it to contain %q", name, unit, msg, msgExpected)
+			}
+		}()
+		s.NewCounterOpt(CounterOpts{
+			Name: name,
+			Unit: unit,
+		})
+	}
+	f("sent_total", "bytes", `for example, "sent_bytes_total"`)
+	f("request_duration", "ms", `values must be divided by 1000 in order to be exposed in "seconds"`)
+	f("request_duration", "second", `"seconds" is recommended instead of "second" unit`)
+	f("invalid_unit_total", "foo bar", "invalid unit")
+
+	// Names aren't validated when strict mode is disabled.
+	SetStrictUnitNames(false)
+	s.NewCounterOpt(CounterOpts{
+		Name: "sent_total",
+		Unit: "bytes",
+	})
+}
+
+func TestWriteOpenMetricsUnit(t *testing.T) {
+	s := NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: "sent_bytes_total",
+		Help: "Sent bytes",
+		Unit: "bytes",
+	}).Add(10)
+	s.NewHistogramOpt(HistogramOpts{
+		Name: "request_duration_seconds",
+		Unit: "seconds",
+	})
+	// The unit isn't exposed for metrics without the unit suffix, since OpenMetrics parsers reject such metrics.
+	s.NewGaugeOpt(GaugeOpts{
+		Name: "queue_length",
+		Unit: "items",
+	}, nil).Set(3)
+
+	var bb bytes.Buffer
+	s.WriteOpenMetrics(&bb)
+	result := bb.String()
+	resultExpected := `# TYPE queue_length gauge
+queue_length 3
+# TYPE request_duration_seconds histogram
+# UNIT request_duration_seconds seconds
+# TYPE sent_bytes counter
+# UNIT sent_bytes bytes
+# HELP sent_bytes Sent bytes
+sent_bytes_total 10
+# EOF
+`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// SetAllowUTF8Names allows or disallows arbitrary UTF-8 metric and label names.
+//
+// By default only Prometheus-compatible legacy names are allowed.
+//
+// If allow is true, then metric and label names may contain arbitrary UTF-8 chars
+// if they are quoted according to Prometheus 3.x naming rules. For example:
+//
+//   - {"my.metric.name"}
+//   - {"my.metric.name",label="x"}
+//   - {"my.metric.name","label.name"="x"}
+//
+// The quoted and unquoted forms of names compatible with legacy naming rules refer to the same metric.
+// For example, `{"foo",bar="baz"}` and `foo{bar="baz"}` refer to the same metric.
+// Metric names, which aren't compatible with legacy naming rules, are exposed in the quoted form.
+// Metric names cannot contain curly braces.
+//
+// SetAllowUTF8Names must be called before registering metrics with UTF-8 names.
+func SetAllowUTF8Names(allow bool) {
+	n := uint32(0)
+	if allow {
+		n = 1
+	}
+	atomic.StoreUint32(&allowUTF8Names, n)
+}
+
+var allowUTF8Names uint32
+
+func isUTF8NamesAllowed() bool {
+	return atomic.LoadUint32(&allowUTF8Names) != 0
+}
+
+// mustNormalizeMetricName validates name and returns its canonical form.
+//
+// It panics if name is invalid.
+func mustNormalizeMetricName(name string) string {
+	nameNormalized, err := normalizeMetricName(name)
+	if err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
+	}
+	return nameNormalized
+}
+
+// normalizeMetricName validates name and returns its canonical form, which is used as a key in Set.
+//
+// name is returned as is if UTF-8 names aren't allowed via SetAllowUTF8Names.
+//
+// Otherwise quoted metric names are converted to `escaped_name{labels}` form, where escaped_name is the metric name
+// with escaped backslashes, double quotes and newlines. Quoted label names compatible with legacy naming rules are unquoted.
+func normalizeMetricName(name string) (string, error) {
+	if !isUTF8NamesAllowed() {
+		return name, validateMetric(name)
+	}
+	if !strings.HasPrefix(name, `{"`) {
+		n := strings.IndexByte(name, '{')
+		if n < 0 {
+			return name, validateMetric(name)
+		}
+		if err := validateIdent(name[:n]); err != nil {
+			return "", err
+		}
+		tail := name[n+1:]
+		if !strings.HasSuffix(tail, "}") {
+			return "", fmt.Errorf("missing closing curly brace at the end of %q", name[:n])
+		}
+		labels, err := normalizeUTF8Labels(tail[:len(tail)-1])
+		if err != nil {
+			return "", err
+		}
+		return name[:n] + "{" + labels + "}", nil
+	}
+
+	metricName, tail, err := readQuotedName(name[1:])
+	if err != nil {
+		return "", fmt.Errorf("cannot parse quoted metric name: %w", err)
+	}
+	if metricName == "" {
+		return "", fmt.Errorf("metric name cannot be empty")
+	}
+	if strings.ContainsAny(metricName, "{}") {
+		return "", fmt.Errorf("metric name %q cannot contain curly braces", metricName)
+	}
+	if !strings.HasSuffix(tail, "}") {
+		return "", fmt.Errorf("missing closing curly brace at the end of %q", name)
+	}
+	tail = tail[:len(tail)-1]
+	family := escapeName(metricName)
+	if tail == "" {
+		return family, nil
+	}
+	if !strings.HasPrefix(tail, ",") {
+		return "", fmt.Errorf("missing `,` after the quoted metric name %q; tail=%q", metricName, tail)
+	}
+	labels, err := normalizeUTF8Labels(skipSpace(tail[1:]))
+	if err != nil {
+		return "", err
+	}
+	if labels == "" {
+		return family, nil
+	}
+	return family + "{" + labels + "}", nil
+}
+
+// normalizeUTF8Labels validates comma-separated labels with possibly quoted label names and returns their canonical form.
+func normalizeUTF8Labels(s string) (string, error) {
+	var sb strings.Builder
+	for len(s) > 0 {
+		var labelName string
+		if strings.HasPrefix(s, `"`) {
+			name, tail, err := readQuotedName(s)
+			if err != nil {
+				return "", fmt.Errorf("cannot parse quoted label name: %w", err)
+			}
+			if name == "" {
+				return "", fmt.Errorf("label name cannot be empty")
+			}
+			labelName = name
+			s = tail
+		} else {
+			n := strings.IndexByte(s, '=')
+			if n < 0 {
+				return "", fmt.Errorf("missing `=` after %q", s)
+			}
+			labelName = s[:n]
+			if err := validateIdent(labelName); err != nil {
+				return "", err
+			}
+			s = s[n:]
+		}
+		if !strings.HasPrefix(s, "=") {
+			return "", fmt.Errorf("missing `=` after %q label name; tail=%q", labelName, s)
+		}
+		s = s[1:]
+		if !strings.HasPrefix(s, `"`) {
+			return "", fmt.Errorf("missing starting `\"` for %q value; tail=%q", labelName, s)
+		}
+		n := findClosingQuote(s[1:])
+		if n < 0 {
+			return "", fmt.Errorf("missing trailing `\"` for %q value; tail=%q", labelName, s)
+		}
+		value := s[:n+2]
+		s = s[n+2:]
+
+		if sb.Len() > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(formatName(labelName, legacyLabelNameRegexp))
+		sb.WriteByte('=')
+		sb.WriteString(value)
+
+		if len(s) == 0 {
+			break
+		}
+		if !strings.HasPrefix(s, ",") {
+			return "", fmt.Errorf("missing `,` after %q value; tail=%q", labelName, s)
+		}
+		s = skipSpace(s[1:])
+	}
+	return sb.String(), nil
+}
+
+// findClosingQuote returns the index of the first unescaped double quote in s.
+//
+// -1 is returned if s contains no unescaped double quotes.
+func findClosingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// readQuotedName reads double-quoted name from the beginning of s and returns the unescaped name and the tail after it.
+//
+// Only `\\`, `\"` and `\n` escape sequences are supported.
+func readQuotedName(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, fmt.Errorf("missing starting `\"`")
+	}
+	s = s[1:]
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			name := sb.String()
+			if !utf8.ValidString(name) {
+				return "", "", fmt.Errorf("name %q must be valid UTF-8 string", name)
+			}
+			return name, s[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", "", fmt.Errorf("unexpected end of escape sequence")
+			}
+			switch s[i] {
+			case '\\', '"':
+				sb.WriteByte(s[i])
+			case 'n':
+				sb.WriteByte('\n')
+			default:
+				return "", "", fmt.Errorf("unsupported escape sequence `\\%c`", s[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("missing trailing `\"`")
+}
+
+// escapeName escapes backslashes, double quotes and newlines in name, so it can be put inside double quotes.
+func escapeName(name string) string {
+	return string(appendEscapedLabelValue(nil, name))
+}
+
+// formatName returns the escaped name as is if it matches legacyRegexp. Otherwise the quoted name is returned.
+func formatName(name string, legacyRegexp *regexp.Regexp) string {
+	if legacyRegexp.MatchString(name) {
+		return name
+	}
+	return `"` + escapeName(name) + `"`
+}
+
+var (
+	legacyMetricNameRegexp = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+	legacyLabelNameRegexp  = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+)
+
+// needsQuoting returns true if the given metric family must be exposed in the quoted form.
+func needsQuoting(metricFamily string) bool {
+	return isUTF8NamesAllowed() && !legacyMetricNameRegexp.MatchString(metricFamily)
+}
+
+// formatMetricFamily returns metricFamily in the form suitable for `# HELP` and `# TYPE` lines.
+func formatMetricFamily(metricFamily string) string {
+	if !needsQuoting(metricFamily) {
+		return metricFamily
+	}
+	return `"` + metricFamily + `"`
+}
+
+// quoteMetricNames appends lines from src in Prometheus text exposition format to dst
+// with metric names starting with metricFamily converted to the quoted form.
+//
+// metricFamily must be escaped with escapeName.
+// Sample names may contain legacy suffixes after metricFamily, such as `_bucket`, `_sum`, `_count` or `_total`.
+// `_created` series for counters may start with metricFamily without `_total` suffix.
+func quoteMetricNames(dst, src []byte, metricFamily string) []byte {
+	counterBase := strings.TrimSuffix(metricFamily, "_total")
+	for len(src) > 0 {
+		var line []byte
+		n := bytes.IndexByte(src, '\n')
+		if n >= 0 {
+			line = src[:n]
+			src = src[n+1:]
+		} else {
+			line = src
+			src = nil
+		}
+		prefix := metricFamily
+		if !bytes.HasPrefix(line, []byte(prefix)) {
+			prefix = counterBase
+		}
+		if !bytes.HasPrefix(line, []byte(prefix)) {
+			dst = append(dst, line...)
+			dst = append(dst, '\n')
+			continue
+		}
+		tail := line[len(prefix):]
+		n = 0
+		for n < len(tail) && isLegacyNameChar(tail[n]) {
+			n++
+		}
+		dst = append(dst, `{"`...)
+		dst = append(dst, prefix...)
+		dst = append(dst, tail[:n]...)
+		dst = append(dst, '"')
+		tail = tail[n:]
+		if len(tail) > 0 && tail[0] == '{' {
+			dst = append(dst, ',')
+			dst = append(dst, tail[1:]...)
+		} else {
+			dst = append(dst, '}')
+			dst = append(dst, tail...)
+		}
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+func isLegacyNameChar(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestNormalizeMetricNameLegacy(t *testing.T) {
+	// UTF-8 names are disallowed by default
+	f := func(name string) {
+		t.Helper()
+		if _, err := normalizeMetricName(name); err == nil {
+			t.Fatalf("expecting non-nil error for %q", name)
+		}
+	}
+	f(`{"foo"}`)
+	f(`{"my.metric",label="x"}`)
+	f(`foo{"label.name"="x"}`)
+
+	result, err := normalizeMetricName(`foo{bar="baz"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result != `foo{bar="baz"}` {
+		t.Fatalf("unexpected result; got %s; want %s", result, `foo{bar="baz"}`)
+	}
+}
+
+func TestNormalizeMetricNameUTF8(t *testing.T) {
+	SetAllowUTF8Names(true)
+	defer SetAllowUTF8Names(false)
+
+	f := func(name, resultExpected string) {
+		t.Helper()
+		result, err := normalizeMetricName(name)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", name, err)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q; got %s; want %s", name, result, resultExpected)
+		}
+	}
+
+	// Legacy names
+	f("foo", "foo")
+	f(`foo{bar="baz"}`, `foo{bar="baz"}`)
+	f(`foo{bar="baz", x="y"}`, `foo{bar="baz",x="y"}`)
+
+	// Quoted legacy-compatible names are unquoted
+	f(`{"foo"}`, "foo")
+	f(`{"foo",bar="baz"}`, `foo{bar="baz"}`)
+	f(`{"foo", "bar"="baz"}`, `foo{bar="baz"}`)
+	f(`foo{"bar"="baz"}`, `foo{bar="baz"}`)
+
+	// UTF-8 names
+	f(`{"my.metric.name"}`, "my.metric.name")
+	f(`{"my.metric.name",label="x"}`, `my.metric.name{label="x"}`)
+	f(`{"my.metric.name","label.name"="x"}`, `my.metric.name{"label.name"="x"}`)
+	f(`{"температура","ключ"="значение"}`, `температура{"ключ"="значение"}`)
+
+	// Escaped quotes, backslashes and newlines
+	f(`{"a\"b"}`, `a\"b`)
+	f(`{"a\\b",x="y"}`, `a\\b{x="y"}`)
+	f(`{"a\nb"}`, `a\nb`)
+	f(`{"foo","a\"b\\c"="x\"y"}`, `foo{"a\"b\\c"="x\"y"}`)
+	f(`{"a,b=\"c\"",x="y,z"}`, `a,b=\"c\"{x="y,z"}`)
+}
+
+func TestNormalizeMetricNameUTF8Failure(t *testing.T) {
+	SetAllowUTF8Names(true)
+	defer SetAllowUTF8Names(false)
+
+	f := func(name string) {
+		t.Helper()
+		if _, err := normalizeMetricName(name); err == nil {
+			t.Fatalf("expecting non-nil error for %q", name)
+		}
+	}
+	f("")
+	f("{}")
+	f(`{""}`)
+	f(`{"foo"`)
+	f(`{"foo}`)
+	f(`{"foo"x}`)
+	f(`{"foo" x="y"}`)
+	f(`{"a{b"}`)
+	f(`{"a}b"}`)
+	f(`{"a\xb"}`)
+	f(`{"a\`)
+	f("{\"\xff\"}")
+	f(`{"foo",""="x"}`)
+	f(`{"foo","x"}`)
+	f(`{"foo","x"=y}`)
+	f(`{"foo",x="y}`)
+	f(`{"foo",x="y"z}`)
+	f(`{"foo",a-b="c"}`)
+	f(`foo{"x"="y"`)
+	f(`my-metric{x="y"}`)
+}
+
+func TestSetUTF8Names(t *testing.T) {
+	SetAllowUTF8Names(true)
+	defer SetAllowUTF8Names(false)
+
+	s := NewSet()
+	c := s.GetOrCreateCounter(`{"foo",bar="baz"}`)
+	if c2 := s.GetOrCreateCounter(`foo{bar="baz"}`); c2 != c {
+		t.Fatalf("quoted and unquoted forms of legacy-compatible name must refer to the same counter")
+	}
+	c.Inc()
+	s.GetOrCreateCounter(`{"my.requests_total","http.path"="/a\"b"}`).Add(2)
+	s.GetOrCreateGauge(`{"a\\b\"c"}`, func() float64 { return 3 })
+	h := s.NewHistogram(`{"my.duration",path="/foo"}`)
+	h.Update(1)
+
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `{"a\\b\"c"} 3
+foo{bar="baz"} 1
+{"my.duration_bucket",path="/foo",vmrange="8.799e-01...1.000e+00"} 1
+{"my.duration_sum",path="/foo"} 1
+{"my.duration_count",path="/foo"} 1
+{"my.requests_total","http.path"="/a\"b"} 2
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected Prometheus output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+
+	bb.Reset()
+	s.WriteOpenMetrics(&bb)
+	resultExpected = `# TYPE "a\\b\"c" gauge
+{"a\\b\"c"} 3
+# TYPE foo counter
+foo_total{bar="baz"} 1
+# TYPE "my.duration" histogram
+{"my.duration_bucket",path="/foo",le="1.000e+00"} 1
+{"my.duration_bucket",path="/foo",le="+Inf"} 1
+{"my.duration_sum",path="/foo"} 1
+{"my.duration_count",path="/foo"} 1
+# TYPE "my.requests" counter
+{"my.requests_total","http.path"="/a\"b"} 2
+# EOF
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected OpenMetrics output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestSetUTF8NamesMetadata(t *testing.T) {
+	SetAllowUTF8Names(true)
+	defer SetAllowUTF8Names(false)
+
+	s := NewSet()
+	s.NewCounterOpt(CounterOpts{
+		Name: `{"my.counter"}`,
+		Help: "help",
+	}).Inc()
+	var bb bytes.Buffer
+	s.WritePrometheus(&bb)
+	resultExpected := `# HELP "my.counter" help
+# TYPE "my.counter" counter
+{"my.counter"} 1
+`
+	if result := bb.String(); result != resultExpected {
+		t.Fatalf("unexpected output; got\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestQuoteMetricNames(t *testing.T) {
+	f := func(src, metricFamily, resultExpected string) {
+		t.Helper()
+		result := quoteMetricNames(nil, []byte(src), metricFamily)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result; got\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f("", "a.b", "")
+	f("a.b 1\n", "a.b", `{"a.b"} 1`+"\n")
+	f("a.b 1 123\n", "a.b", `{"a.b"} 1 123`+"\n")
+	f(`a.b{x="y"} 1`+"\n", "a.b", `{"a.b",x="y"} 1`+"\n")
+	f(`a.b_sum{x="y"} 1`+"\n"+"a.b_count 2\n", "a.b", `{"a.b_sum",x="y"} 1`+"\n"+`{"a.b_count"} 2`+"\n")
+	f("# HELP foo\nother 1\n", "a.b", "# HELP foo\nother 1\n")
+}
+
+func TestSetUTF8NamesLookup(t *testing.T) {
+	SetAllowUTF8Names(true)
+	defer SetAllowUTF8Names(false)
+
+	s := NewSet()
+
+	// GetOrCreate* must return the same metric for quoted names via the fast path.
+	c := s.GetOrCreateCounter(`{"foo.bar"}`)
+	if c2 := s.GetOrCreateCounter(`{"foo.bar"}`); c2 != c {
+		t.Fatalf("GetOrCreateCounter must return the registered counter")
+	}
+
+	// TTL variants must not hang on quoted names.
+	cTTL := s.GetOrCreateCounterWithTTL(`{"foo.ttl",label="x"}`, time.Minute)
+	if c2 := s.GetOrCreateCounterWithTTL(`{"foo.ttl",label="x"}`, time.Minute); c2 != cTTL {
+		t.Fatalf("GetOrCreateCounterWithTTL must return the registered counter")
+	}
+
+	// GetMetric must find the metric by quoted and by normalized name.
+	for _, name := range []string{`{"foo.bar"}`, `foo.bar`} {
+		m, ok := s.GetMetric(name)
+		if !ok {
+			t.Fatalf("cannot find metric %s", name)
+		}
+		if m != c {
+			t.Fatalf("unexpected metric returned for %s", name)
+		}
+	}
+
+	// A quoted name, which wasn't used for the registration, must be normalized.
+	s.NewCounter(`{"foo.new"}`)
+	if _, ok := s.GetMetric(`{"foo.new"}`); !ok {
+		t.Fatalf("cannot find metric registered via NewCounter")
+	}
+
+	// UnregisterMetric must remove the metric together with its aliases.
+	if !s.UnregisterMetric(`{"foo.bar"}`) {
+		t.Fatalf("cannot unregister %s", `{"foo.bar"}`)
+	}
+	for _, name := range []string{`{"foo.bar"}`, `foo.bar`} {
+		if _, ok := s.GetMetric(name); ok {
+			t.Fatalf("unexpected metric %s after unregistering", name)
+		}
+	}
+	if !s.UnregisterMetric(`{"foo.ttl",label="x"}`) {
+		t.Fatalf("cannot unregister %s", `{"foo.ttl",label="x"}`)
+	}
+	if c2 := s.GetOrCreateCounter(`{"foo.bar"}`); c2 == c {
+		t.Fatalf("GetOrCreateCounter must create new counter after unregistering")
+	}
+}
@@ -82,3 +82,15 @@ func validateIdent(s string) error {
 }
 
 var identRegexp = regexp.MustCompile("^[a-zA-Z_:.][a-zA-Z0-9_:.]*$")
+
+// ValidateMetricName returns an error if name isn't a valid metric name with optional labels, which can be registered in Set.
+//
+// For example, `foo`, `foo{bar="baz"}` and `foo{bar="baz",aaa="b"}` are valid metric names.
+// Quoted UTF-8 names are also valid if they are allowed via SetAllowUTF8Names.
+//
+// ValidateMetricName is useful for validating metric names obtained from user config before passing them to New* functions,
+// which panic on invalid names. See also NewCounterErr.
+func ValidateMetricName(name string) error {
+	_, err := normalizeMetricName(name)
+	return err
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// metricVec is a collection of metrics with the same name and distinct values for the given label names.
+//
+// It is used as a base for CounterVec and similar types.
+type metricVec struct {
+	s          *Set
+	name       string
+	labelNames []string
+
+	// sortedLabelIdxs contains indexes of labelNames sorted by label names.
+	// It is used for building metric names with labels in canonical order. See metricName.
+	sortedLabelIdxs []int
+
+	// newMetric must create and register a metric with the given name in s.
+	newMetric func(name string) metric
+
+	mu sync.RWMutex
+
+	// m maps the key for label values to the vec item. See appendVecKey.
+	m map[string]*vecItem
+}
+
+type vecItem struct {
+	name   string
+	values []string
+	metric metric
+}
+
+func newMetricVec(s *Set, name string, labelNames []string, newMetric func(name string) metric) *metricVec {
+	if err := validateIdent(name); err != nil {
+		panic(fmt.Errorf("BUG: invalid metric name %q: %s", name, err))
+	}
+	for i, labelName := range labelNames {
+		if err := validateIdent(labelName); err != nil {
+			panic(fmt.Errorf("BUG: invalid label name for metric %q: %s", name, err))
+		}
+		for _, prevLabelName := range labelNames[:i] {
+			if labelName == prevLabelName {
+				panic(fmt.Errorf("BUG: duplicate label name %q for metric %q", labelName, name))
+			}
+		}
+	}
+	sortedLabelIdxs := make([]int, len(labelNames))
+	for i := range sortedLabelIdxs {
+		sortedLabelIdxs[i] = i
+	}
+	sort.Slice(sortedLabelIdxs, func(i, j int) bool {
+		return labelNames[sortedLabelIdxs[i]] < labelNames[sortedLabelIdxs[j]]
+	})
+	return &metricVec{
+		s:               s,
+		name:            name,
+		labelNames:      append([]string{}, labelNames...),
+		sortedLabelIdxs: sortedLabelIdxs,
+		newMetric:       newMetric,
+		m:               make(map[string]*vecItem),
+	}
+}
+
+// withLabelValues returns the metric for the given label values. The metric is created if it is missing.
+func (mv *metricVec) withLabelValues(values []string) metric {
+	mv.checkLabelValues(values)
+
+	// Fast path - the metric already exists.
+	// The key is built in the buffer on stack, while the map lookup with string(key) doesn't allocate.
+	var buf [128]byte
+	key := appendVecKey(buf[:0], values)
+	mv.mu.RLock()
+	item := mv.m[string(key)]
+	mv.mu.RUnlock()
+	if item != nil {
+		return item.metric
+	}
+
+	// Slow path - create and register the metric.
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+	item = mv.m[string(key)]
+	if item == nil {
+		name := mv.metricName(values)
+		item = &vecItem{
+			name:   name,
+			values: append([]string{}, values...),
+			metric: mv.newMetric(name),
+		}
+		mv.m[string(key)] = item
+	}
+	return item.metric
+}
+
+// deleteLabelValues unregisters the metric for the given label values.
+//
+// It returns true if the metric has been unregistered.
+func (mv *metricVec) deleteLabelValues(values []string) bool {
+	mv.checkLabelValues(values)
+
+	var buf [128]byte
+	key := appendVecKey(buf[:0], values)
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+	item := mv.m[string(key)]
+	if item == nil {
+		return false
+	}
+	delete(mv.m, string(key))
+	return mv.s.UnregisterMetric(item.name)
+}
+
+// reset unregisters all the metrics in mv.
+//
+// All the metrics are unregistered under a single lock, so concurrent scrapes never observe partially removed mv.
+func (mv *metricVec) reset() {
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+
+	s := mv.s
+	s.mu.Lock()
+	for _, item := range mv.m {
+		if nm := s.m[item.name]; nm != nil {
+			s.unregisterMetricLocked(nm)
+		}
+	}
+	s.mu.Unlock()
+	mv.m = make(map[string]*vecItem)
+}
+
+// len returns the number of metrics in mv.
+func (mv *metricVec) len() int {
+	mv.mu.RLock()
+	n := len(mv.m)
+	mv.mu.RUnlock()
+	return n
+}
+
+// visit calls f for all the metrics in mv in the order of their names.
+//
+// f is called outside the lock, so it may call other mv methods.
+// f mustn't modify values.
+func (mv *metricVec) visit(f func(values []string, m metric)) {
+	mv.mu.RLock()
+	items := make([]*vecItem, 0, len(mv.m))
+	for _, item := range mv.m {
+		items = append(items, item)
+	}
+	mv.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].name < items[j].name
+	})
+	for _, item := range items {
+		f(item.values, item.metric)
+	}
+}
+
+func (mv *metricVec) checkLabelValues(values []string) {
+	if len(values) != len(mv.labelNames) {
+		panic(fmt.Errorf("BUG: unexpected number of label values for metric %q; got %d; want %d", mv.name, len(values), len(mv.labelNames)))
+	}
+}
+
+// metricName returns the full metric name with labels for the given values.
+//
+// Labels are sorted by name, so the name matches the name built via BuildName for the same labels.
+func (mv *metricVec) metricName(values []string) string {
+	pairs := make([]string, 0, 2*len(values))
+	for _, idx := range mv.sortedLabelIdxs {
+		pairs = append(pairs, mv.labelNames[idx], values[idx])
+	}
+	b := appendSortedMetricName(nil, mv.name, pairs)
+	return string(b)
+}
+
+// appendVecKey appends unique key for the given label values to dst.
+//
+// Every value is prefixed with its length, so distinct values cannot result in the same key.
+func appendVecKey(dst []byte, values []string) []byte {
+	for _, v := range values {
+		n := uint64(len(v))
+		for n >= 0x80 {
+			dst = append(dst, byte(n)|0x80)
+			n >>= 7
+		}
+		dst = append(dst, byte(n))
+		dst = append(dst, v...)
+	}
+	return dst
+}